@@ -1,10 +1,15 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"mediamanager/filedb"
+	"mediamanager/mediatype"
+	"mediamanager/tagrules"
+	"mediamanager/thumbnail"
 	"os"
 	"slices"
 	"sort"
@@ -19,8 +24,9 @@ type DatabaseArgs struct {
 	DatabasePath string `arg:"positional,required" help:"Path to the database."`
 	Backup       bool   `arg:"-B,--backup" help:"Action. Backup the database to <Datbasepath>.bak"`
 	NoBackup     bool   `arg:"--nobackup" help:"disables database backup during operations"`
-	Dry          bool   `arg:"--dry" help:"A select & Action must be provided. don't modify the database during this operation and log results based on --dryout"`
+	Dry          bool   `arg:"--dry" help:"A select & Action or --relink must be provided. don't modify the database during this operation and log results based on --dryout"`
 	DryOutput    string `arg:"--dryoutput" help:"Output during dry operation. Can be stdout, log, (PATH).txt or (PATH).json" default:"stdout"`
+	User         string `arg:"-u,--user" help:"user to select, display & set stars and last viewed times for. Required by --selectstars, --selectdateunset and --stars"`
 	// * SELECTION METHODS *
 	SelectId        []int    `arg:"-i,--selectid,separate" help:"select file ids, cannot coexist with any other selects"`
 	SelectTags      []string `arg:"-t,--selecttag,separate" help:"select by tags"`
@@ -30,26 +36,62 @@ type DatabaseArgs struct {
 	SelectMissing   bool     `arg:"--selectmissing" help:"select files missing from disk"`
 	SelectNoHash    bool     `arg:"--selectnohash" help:"select files with no hash"`
 	SelectNoSize    bool     `arg:"--selectnosize" help:"select files with no size"`
+	SelectQuery     string   `arg:"-q,--selectquery" help:"select files matching a search query, e.g. '(tag:anime OR tag:cartoon) -tag:watched stars>=4 size<2GB'. stars & viewed use --user"`
+	SelectSaved     string   `arg:"--selectsaved" help:"select files matching a saved search of --user"`
+	SelectSimilar   int      `arg:"--selectsimilar" help:"select images that look like this file id by perceptual hash, closest first. See --updatephash"`
+	SimilarDistance int      `arg:"--similardistance" help:"bits the perceptual hashes of --selectsimilar can differ by, 0 to 64" default:"10"`
 	// ** ACTIONS **
 	DisplayFiles bool   `arg:"-d,--display" help:"Action. Display files selected"`
 	WriteJson    string `arg:"-j,--json" help:"Output files as a JSON array"`
 	// * MODIFY *
-	AddTag    []string `arg:"--tag,separate" help:"Action. tags to add to selected entries"`
-	SetStars  int      `arg:"--stars" help:"Action. stars to set on selected file" default:"-1"`
-	RemoveTag []string `arg:"--removetag,separate" help:"Action. remove a tag from selected files"`
+	AddTag     []string `arg:"--tag,separate" help:"Action. tags to add to selected entries"`
+	SetStars   int      `arg:"--stars" help:"Action. stars to set on selected file" default:"-1"`
+	RemoveTag  []string `arg:"--removetag,separate" help:"Action. remove a tag from selected files"`
+	ApplyRules string   `arg:"--applyrules" help:"Action. add tags to selected files with the rules in this JSON file, like import --rules. Can be used with --dry"`
 	// * TAGS *
 	RemoveTagFromDb []string `arg:"--deletetag,separate" help:"Cannot be used with a select or other action. remove tag from database and all files"`
+	RenameTag       string   `arg:"--renametag" help:"Cannot be used with a select or other action. rename a tag & its children to --to"`
+	MergeTag        string   `arg:"--mergetag" help:"Cannot be used with a select or other action. merge a tag & its children into --to, every file with the tag gets --to instead"`
+	AliasTag        string   `arg:"--aliastag" help:"Cannot be used with a select or other action. make this a alias of --to, adding or searching for it uses --to instead"`
+	TagTo           string   `arg:"--to" help:"tag used by --renametag, --mergetag and --aliastag"`
+	RemoveAlias     string   `arg:"--removealias" help:"Cannot be used with a select or other action. remove a tag alias"`
+	ListAliases     bool     `arg:"--listaliases" help:"Cannot be used with a select or other action. list tag aliases"`
+	// * API KEYS *
+	AddKey         string   `arg:"--addkey" help:"Cannot be used with a select or other action. create a API key with this name for --user, the key is only shown once"`
+	KeyPermissions []string `arg:"--keyperm,separate" help:"permissions of the key created by --addkey, can be read, user-write, global-read, global-modify, global-write, no-rate-limit or admin"`
+	ListKeys       bool     `arg:"--listkeys" help:"Cannot be used with a select or other action. list the API keys of --user"`
+	RemoveKey      int      `arg:"--removekey" help:"Cannot be used with a select or other action. remove a API key by id"`
+
+	SaveSearch   string `arg:"--savesearch" help:"Action. save --selectpath, --selecttag & --selectquery as a search with this name for --user"`
+	ListSearches bool   `arg:"--listsearches" help:"Cannot be used with a select or other action. list the saved searches of --user"`
+	RemoveSearch string `arg:"--removesearch" help:"Cannot be used with a select or other action. remove a saved search of --user by name"`
 	// * REMOVAL *
-	Remove         bool `arg:"--remove" help:"Action. remove selected files from database. Overrides --addtag, --stars, --rmtag, --updatehash and --updatesize."`
-	RemoveFromDisk bool `arg:"--removefromdisk" help:"Action. Remove selected files from disk. Overrides --addtag, --stars, --rmtag, --updatehash and --updatesize."`
+	Remove         bool `arg:"--remove" help:"Action. remove selected files from database. Overrides --addtag, --stars, --rmtag, --updatehash, --updatesize, --updatemeta, --updatephash, --updatetype and --genthumbs."`
+	RemoveFromDisk bool `arg:"--removefromdisk" help:"Action. Remove selected files from disk. Overrides --addtag, --stars, --rmtag, --updatehash, --updatesize, --updatemeta, --updatephash, --updatetype and --genthumbs."`
 	// * UPDATE *
-	UpdateFileHash bool `arg:"-H,--updatehash" help:"Action. Update hashes of selected files"`
-	UpdateFileSize bool `arg:"-S,--updatesize" help:"Action. Update sizes of selected files"`
+	UpdateFileHash  bool   `arg:"-H,--updatehash" help:"Action. Update hashes of selected files"`
+	UpdateFileSize  bool   `arg:"-S,--updatesize" help:"Action. Update sizes of selected files"`
+	UpdateFileMeta  bool   `arg:"-M,--updatemeta" help:"Action. Update the embedded metadata of selected files, like EXIF & ID3 tags"`
+	UpdateFilePHash bool   `arg:"-P,--updatephash" help:"Action. Update perceptual hashes of selected JPEG, PNG & GIF files, used by --selectsimilar"`
+	UpdateFileType  bool   `arg:"--updatetype" help:"Action. Update the MIME types & categories of selected files by extension, files of unknown types are left unchanged. See --sniff & --types"`
+	Sniff           bool   `arg:"--sniff" help:"--updatetype reads the start of files & uses the type of the contents if it's known"`
+	Types           string `arg:"--types" help:"JSON config of types used by --updatetype & the TypeTags of --applyrules, added to the default types. See README.md for format"`
+	// * THUMBNAILS *
+	GenThumbs bool   `arg:"--genthumbs" help:"Action. Create thumbnails of selected JPEG, PNG & GIF files with hashes, the files themselves are only read"`
+	ThumbDir  string `arg:"--thumbdir" help:"directory --genthumbs caches thumbnails in, default is <Databasepath>.thumbs"`
+	ThumbSize int    `arg:"--thumbsize" help:"size of the thumbnails --genthumbs creates, can be 128, 256 or 512" default:"256"`
 	// * INFO *
-	Metadata bool `arg:"--metadata" help:"Show all metadata"`
+	Metadata   bool `arg:"--metadata" help:"Show all metadata"`
+	Duplicates bool `arg:"--duplicates" help:"Cannot be used with a select or other action. list files found at more then one path by hash, with the space the extra copies use"`
+	// * REPAIR *
+	Relink string `arg:"--relink" help:"Cannot be used with a select or other action. find files missing from disk in this directory by size & hash and change their paths, tags, stars & last viewed times are kept. Can be used with --dry"`
 	// ** VERSION **
-	Version bool `arg:"-v,--version" help:"Get database info and exit"`
-	Update  bool `arg:"-U,--update" help:"Update to the latest version, if possible"`
+	Version        bool   `arg:"-v,--version" help:"Get database info and exit"`
+	Update         bool   `arg:"-U,--update" help:"Update to the latest version, if possible"`
+	MigrateAccount string `arg:"--migrateaccount" help:"account that stars & last viewed times are moved to when updating from 3.X, created as a administrator. Requires --update"`
+
+	rules *tagrules.Engine    // Loaded from ApplyRules
+	types *mediatype.Registry // Loaded from Types, the default types if it isn't set
 }
 
 // Verify database argument as valid
@@ -60,7 +102,7 @@ func (d *DatabaseArgs) Verify(p *arg.Parser) bool {
 		return false
 	}
 	// Ensure select id is the only select used.
-	if len(d.SelectId) > 0 && (len(d.SelectTags) > 0 || d.SelectPath != "" || len(d.SelectStars) > 0 || d.SelectDateUnset || d.SelectMissing || d.SelectNoHash || d.SelectNoSize) {
+	if len(d.SelectId) > 0 && (len(d.SelectTags) > 0 || d.SelectPath != "" || len(d.SelectStars) > 0 || d.SelectDateUnset || d.SelectMissing || d.SelectNoHash || d.SelectNoSize || d.SelectQuery != "" || d.SelectSaved != "" || d.SelectSimilar != 0) {
 		p.FailSubcommand("--selectid cannot be combined with other select arguments", "database")
 		return false
 	}
@@ -69,17 +111,106 @@ func (d *DatabaseArgs) Verify(p *arg.Parser) bool {
 		p.FailSubcommand("select argument and --deletetag cannot be used together", "database")
 		return false
 	}
+	// Tag renames, merges & aliases
+	if d.HasTagAction() && d.HasSelect() {
+		p.FailSubcommand("select argument and --renametag, --mergetag, --aliastag, --removealias or --listaliases cannot be used together", "database")
+		return false
+	}
+	moves := 0
+	for _, v := range []string{d.RenameTag, d.MergeTag, d.AliasTag} {
+		if v != "" {
+			moves++
+		}
+	}
+	if moves > 1 {
+		p.FailSubcommand("--renametag, --mergetag and --aliastag cannot be combined", "database")
+		return false
+	}
+	if (moves == 1) != (d.TagTo != "") {
+		p.FailSubcommand("--renametag, --mergetag and --aliastag must be used with --to", "database")
+		return false
+	}
+	// API keys
+	if d.HasKeyAction() && d.HasSelect() {
+		p.FailSubcommand("select argument and --addkey, --listkeys or --removekey cannot be used together", "database")
+		return false
+	}
+	if d.User == "" && (d.AddKey != "" || d.ListKeys) {
+		p.FailSubcommand("--addkey and --listkeys require --user", "database")
+		return false
+	}
+	if (d.AddKey != "") != (len(d.KeyPermissions) > 0) {
+		p.FailSubcommand("--addkey and --keyperm must be used together", "database")
+		return false
+	}
+	// Saved searches
+	if d.HasSearchAction() && d.HasSelect() {
+		p.FailSubcommand("select argument and --listsearches or --removesearch cannot be used together", "database")
+		return false
+	}
+	if d.User == "" && (d.SelectSaved != "" || d.SaveSearch != "" || d.HasSearchAction()) {
+		p.FailSubcommand("--selectsaved, --savesearch, --listsearches and --removesearch require --user", "database")
+		return false
+	}
+	if d.SaveSearch != "" && (len(d.SelectId) > 0 || len(d.SelectStars) > 0 || d.SelectDateUnset || d.SelectMissing || d.SelectNoHash || d.SelectNoSize || d.SelectSaved != "" || d.SelectSimilar != 0) {
+		p.FailSubcommand("--savesearch only saves --selectpath, --selecttag and --selectquery", "database")
+		return false
+	}
+	if d.Duplicates && d.HasSelect() {
+		p.FailSubcommand("select argument and --duplicates cannot be used together", "database")
+		return false
+	}
+	if d.Relink != "" && (d.HasSelect() || d.Duplicates) {
+		p.FailSubcommand("--relink cannot be used with a select argument or --duplicates", "database")
+		return false
+	}
+	if d.Sniff && !d.UpdateFileType {
+		p.FailSubcommand("--sniff requires --updatetype", "database")
+		return false
+	}
+	if d.SimilarDistance < 0 || d.SimilarDistance > 64 {
+		p.FailSubcommand("--similardistance must be 0 to 64", "database")
+		return false
+	}
+	if d.MigrateAccount != "" && !d.Update {
+		p.FailSubcommand("--migrateaccount requires --update", "database")
+		return false
+	}
+	if !thumbnail.IsValidSize(d.ThumbSize) {
+		p.FailSubcommand("--thumbsize must be 128, 256 or 512", "database")
+		return false
+	}
+	// Stars & last viewed times belong to a user
+	if d.User == "" && (len(d.SelectStars) > 0 || d.SelectDateUnset || d.SetStars != -1) {
+		p.FailSubcommand("--selectstars, --selectdateunset and --stars require --user", "database")
+		return false
+	}
 	return true
 }
 
 // Has a Select argument
 func (d *DatabaseArgs) HasSelect() bool {
-	return len(d.SelectId) > 0 || len(d.SelectTags) > 0 || d.SelectPath != "" || len(d.SelectStars) > 0 || d.SelectDateUnset || d.SelectMissing || d.SelectNoHash || d.SelectNoSize
+	return len(d.SelectId) > 0 || len(d.SelectTags) > 0 || d.SelectPath != "" || len(d.SelectStars) > 0 || d.SelectDateUnset || d.SelectMissing || d.SelectNoHash || d.SelectNoSize || d.SelectQuery != "" || d.SelectSaved != "" || d.SelectSimilar != 0
+}
+
+// Has a API key argument
+func (d *DatabaseArgs) HasKeyAction() bool {
+	return d.AddKey != "" || d.ListKeys || d.RemoveKey != 0
+}
+
+// Has a tag rename, merge or alias argument
+func (d *DatabaseArgs) HasTagAction() bool {
+	return d.RenameTag != "" || d.MergeTag != "" || d.AliasTag != "" || d.RemoveAlias != "" || d.ListAliases
+}
+
+// Has a saved search argument that isn't a select or action
+func (d *DatabaseArgs) HasSearchAction() bool {
+	return d.ListSearches || d.RemoveSearch != ""
 }
 
 // Has a action argument
 func (d *DatabaseArgs) HasAction() bool {
-	return d.DisplayFiles || len(d.AddTag) > 0 || d.SetStars != -1 || len(d.RemoveTag) > 0 || d.Remove || d.RemoveFromDisk || d.UpdateFileHash || d.UpdateFileSize || len(d.RemoveTagFromDb) > 0 || d.WriteJson != ""
+	return d.DisplayFiles || len(d.AddTag) > 0 || d.SetStars != -1 || len(d.RemoveTag) > 0 || d.Remove || d.RemoveFromDisk || d.UpdateFileHash || d.UpdateFileSize || d.UpdateFileMeta || d.UpdateFilePHash || d.UpdateFileType || d.GenThumbs || len(d.RemoveTagFromDb) > 0 || d.WriteJson != "" || d.SaveSearch != "" || d.ApplyRules != ""
 }
 
 // Execute a database operation live
@@ -123,6 +254,12 @@ func DbLiveExecute(d *ArgList, db *filedb.FileDb, files []*filedb.File) {
 			// If it doesn't have it who cares.
 			f.RemoveTag(r)
 		}
+		if d.Database.rules != nil {
+			if _, err := applyRules(d.Database.rules, f); err != nil {
+				fmt.Printf("Failed to tag file '%s': %v\n", f.GetPath(), err)
+				return
+			}
+		}
 		if d.Database.SetStars != -1 {
 			err := f.SetStars(uint8(d.Database.SetStars))
 			if err != nil {
@@ -136,10 +273,15 @@ func DbLiveExecute(d *ArgList, db *filedb.FileDb, files []*filedb.File) {
 		return
 	}
 	// Add file hash & sizes, this might take a bit.
-	if d.Database.UpdateFileHash || d.Database.UpdateFileSize {
+	if d.Database.UpdateFileHash || d.Database.UpdateFileSize || d.Database.UpdateFileMeta || d.Database.UpdateFilePHash || d.Database.UpdateFileType {
 		err := filedb.AddInfoToFiles(&filedb.AddInfoOpts{
 			DontAddHash:       !d.Database.UpdateFileHash,
 			DontAddSize:       !d.Database.UpdateFileSize,
+			AddMeta:           d.Database.UpdateFileMeta,
+			AddPHash:          d.Database.UpdateFilePHash,
+			AddType:           d.Database.UpdateFileType,
+			Types:             d.Database.types,
+			Sniff:             d.Database.Sniff,
 			ProgressBarWriter: os.Stdout,
 		}, files...)
 		if err != nil {
@@ -150,12 +292,39 @@ func DbLiveExecute(d *ArgList, db *filedb.FileDb, files []*filedb.File) {
 	// Update all the files
 	for _, v := range files {
 		err := db.UpdateFile(v)
-		if err != nil {
+		var dup *filedb.DuplicateError
+		if errors.As(err, &dup) {
+			fmt.Printf("= '%s' is now a copy of '%s' (%d), its path was recorded. See --duplicates\n", v.GetPath(), dup.Path, dup.FileId)
+		} else if err != nil {
 			fmt.Printf("File '%s' failed to update: %v\n", v.GetPath(), err)
 			fmt.Printf("  | Hash: %s\n", v.GetHash())
 			// Update the remaning files anyway.
 		}
 	}
+	// Thumbnails use the hashes, so they're created after those are updated
+	if d.Database.GenThumbs {
+		cache := thumbnail.NewCache(d.Database.ThumbDir)
+		ctx, can := context.WithCancel(context.Background())
+		defer can()
+		channel := make(chan int64, 10)
+		go ptermProgressBar("Creating thumbnails", len(files), channel, ctx)
+		res, err := cache.GenerateFiles(&thumbnail.GenerateOpts{
+			Size:         d.Database.ThumbSize,
+			ProgressChan: channel,
+			Context:      ctx,
+		}, files...)
+		if err != nil {
+			fmt.Printf("Failed to create thumbnails: %v\n", err)
+			return
+		}
+		fmt.Printf("Created %d thumbnails in '%s', %d already existed\n", res.Generated, cache.GetDir(), res.Cached)
+		if res.Skipped != 0 {
+			fmt.Printf("  | %d files were skipped, they aren't JPEG, PNG or GIF files or have no hash (See --updatehash)\n", res.Skipped)
+		}
+		if res.Failed != 0 {
+			fmt.Printf("  | %d files failed, see the log for why\n", res.Failed)
+		}
+	}
 }
 
 // Dry operation stuff
@@ -214,6 +383,27 @@ func DbDryExecute(d *ArgList, files []*filedb.File) {
 				Error:         nil,
 			})
 		}
+		if d.Database.rules != nil {
+			added, err := applyRules(d.Database.rules, f)
+			for _, t := range added {
+				ops = append(ops, &DryOperation{
+					Operation:     "f.AddTag",
+					OperationArgs: []string{t, "rules"},
+					TargetId:      f.GetId(),
+					TargetPath:    f.GetPath(),
+					Error:         nil,
+				})
+			}
+			if err != nil {
+				ops = append(ops, &DryOperation{
+					Operation:     "applyRules",
+					OperationArgs: []string{d.Database.ApplyRules},
+					TargetId:      f.GetId(),
+					TargetPath:    f.GetPath(),
+					Error:         err,
+				})
+			}
+		}
 		if d.Database.SetStars != -1 {
 			err := f.SetStars(uint8(d.Database.SetStars))
 			ops = append(ops, &DryOperation{
@@ -226,13 +416,17 @@ func DbDryExecute(d *ArgList, files []*filedb.File) {
 		}
 	}
 	// We don't actuall run this cause it would take a long as time for *no* reason.
-	if d.Database.UpdateFileHash || d.Database.UpdateFileSize {
+	if d.Database.UpdateFileHash || d.Database.UpdateFileSize || d.Database.UpdateFileMeta || d.Database.UpdateFilePHash || d.Database.UpdateFileType {
 		ops = append(ops, &DryOperation{
 			Operation: "filedb.AddInfoToFiles",
 			OperationArgs: []string{
 				fmt.Sprintf("%+v", &filedb.AddInfoOpts{
 					DontAddHash:       !d.Database.UpdateFileHash,
 					DontAddSize:       !d.Database.UpdateFileSize,
+					AddMeta:           d.Database.UpdateFileMeta,
+					AddPHash:          d.Database.UpdateFilePHash,
+					AddType:           d.Database.UpdateFileType,
+					Sniff:             d.Database.Sniff,
 					ProgressBarWriter: os.Stdout,
 				}),
 				fmt.Sprintf("{%d files}", len(files)),
@@ -242,6 +436,19 @@ func DbDryExecute(d *ArgList, files []*filedb.File) {
 			Error:      nil,
 		})
 	}
+	if d.Database.GenThumbs {
+		ops = append(ops, &DryOperation{
+			Operation: "thumbnail.GenerateFiles",
+			OperationArgs: []string{
+				d.Database.ThumbDir,
+				fmt.Sprintf("%+v", &thumbnail.GenerateOpts{Size: d.Database.ThumbSize}),
+				fmt.Sprintf("{%d files}", len(files)),
+			},
+			TargetId:   -1,
+			TargetPath: "",
+			Error:      nil,
+		})
+	}
 	// Updatea all the files
 	for _, v := range files {
 		ops = append(ops, &DryOperation{
@@ -252,7 +459,11 @@ func DbDryExecute(d *ArgList, files []*filedb.File) {
 			Error:         nil,
 		})
 	}
-	// Write output
+	writeDryOperations(d, ops)
+}
+
+// Write dry operations to --dryoutput
+func writeDryOperations(d *ArgList, ops []*DryOperation) {
 	var outputWriter io.Writer
 	var outputCloser io.Closer
 	if d.Database.DryOutput == "stdout" {
@@ -281,8 +492,8 @@ func DbDryExecute(d *ArgList, files []*filedb.File) {
 	}
 }
 
-// Select a database and get files
-func DbSelect(d *ArgList, db *filedb.FileDb) ([]*filedb.File, error) {
+// Select a database and get files, if user isn't nil the files will belong to them.
+func DbSelect(d *ArgList, db *filedb.FileDb, user *filedb.User) ([]*filedb.File, error) {
 	files := make([]*filedb.File, 0)
 	if len(d.Database.SelectId) > 0 {
 		for _, v := range d.Database.SelectId {
@@ -292,12 +503,48 @@ func DbSelect(d *ArgList, db *filedb.FileDb) ([]*filedb.File, error) {
 			}
 			files = append(files, f)
 		}
+		if user != nil {
+			err := db.LoadUserData(user, files...)
+			if err != nil {
+				return nil, fmt.Errorf("failed to get user data: %v", err)
+			}
+		}
 	} else {
+		userId := 0
+		if user != nil {
+			userId = user.GetId()
+		}
+		query := d.Database.SelectQuery
+		if d.Database.SelectSaved != "" {
+			s, err := db.GetSavedSearch(user, d.Database.SelectSaved)
+			if err != nil {
+				return nil, fmt.Errorf("failed to get saved search '%s': %v", d.Database.SelectSaved, err)
+			}
+			query = strings.TrimSpace(fmt.Sprintf("(%s) %s", s.GetQuery(), query))
+		}
+		// Similar images, by id
+		var similar map[int]int
+		if d.Database.SelectSimilar != 0 {
+			f, err := db.GetFileById(d.Database.SelectSimilar)
+			if err != nil {
+				return nil, fmt.Errorf("failed to get file with id '%d': %v", d.Database.SelectSimilar, err)
+			}
+			sim, err := db.FindSimilar(f, d.Database.SimilarDistance)
+			if err != nil {
+				return nil, fmt.Errorf("failed to find files similar to '%s': %v", f.GetPath(), err)
+			}
+			similar = make(map[int]int, len(sim))
+			for _, v := range sim {
+				similar[v.File.GetId()] = v.Distance
+			}
+		}
 		// Search
 		sFiles, err := db.SearchFile(&filedb.SearchQuery{
 			Path:          d.Database.SelectPath,
 			WhitelistTags: d.Database.SelectTags,
+			Query:         query,
 			Count:         -1,
+			UserId:        userId,
 		})
 		if err != nil {
 			return nil, fmt.Errorf("failed to search: %v", err)
@@ -334,16 +581,234 @@ func DbSelect(d *ArgList, db *filedb.FileDb) ([]*filedb.File, error) {
 			if d.Database.SelectNoSize && v.GetSize() != 0 {
 				continue
 			}
+			// Not similar
+			if _, found := similar[v.GetId()]; similar != nil && !found {
+				continue
+			}
 			files = append(files, v)
 		}
+		if similar != nil {
+			// Closest first
+			sort.SliceStable(files, func(i, j int) bool {
+				return similar[files[i].GetId()] < similar[files[j].GetId()]
+			})
+		}
 	}
 	return files, nil
 }
 
+// Add, list or remove API keys
+func DbKeyExecute(d *ArgList, db *filedb.FileDb, user *filedb.User) {
+	if d.Database.RemoveKey != 0 {
+		k, err := db.GetApiKeyById(d.Database.RemoveKey)
+		if err != nil {
+			fmt.Printf("Failed to get key %d: %v\n", d.Database.RemoveKey, err)
+			return
+		}
+		err = db.RemoveApiKey(k)
+		if err != nil {
+			fmt.Printf("Failed to remove key %d: %v\n", d.Database.RemoveKey, err)
+			return
+		}
+		fmt.Printf("- Removed key %d\n", d.Database.RemoveKey)
+	}
+	if d.Database.AddKey != "" {
+		perms, err := filedb.ParsePermissions(d.Database.KeyPermissions...)
+		if err != nil {
+			fmt.Printf("Invalid --keyperm: %v\n", err)
+			return
+		}
+		key, k, err := db.AddApiKey(user, d.Database.AddKey, perms)
+		if err != nil {
+			fmt.Printf("Failed to add key '%s': %v\n", d.Database.AddKey, err)
+			return
+		}
+		fmt.Printf("+ Added key '%s' (%d) for '%s' with '%s'\n", k.GetName(), k.GetId(), user.GetName(), k.GetPermissions())
+		fmt.Printf("  | %s\n", key)
+		fmt.Printf("  | This key won't be shown again\n")
+	}
+	if d.Database.ListKeys {
+		keys, err := db.GetApiKeys(user)
+		if err != nil {
+			fmt.Printf("Failed to get keys: %v\n", err)
+			return
+		}
+		fmt.Printf("ID, Name, Permissions, Created\n")
+		for _, v := range keys {
+			fmt.Printf("%d, %s, %s, %s\n", v.GetId(), v.GetName(), v.GetPermissions(), v.GetCreated().Format(time.RFC3339))
+		}
+	}
+}
+
+// Rename, merge or alias tags
+func DbTagExecute(d *ArgList, db *filedb.FileDb) {
+	if d.Database.RenameTag != "" {
+		err := db.RenameTag(d.Database.RenameTag, d.Database.TagTo)
+		if err != nil {
+			fmt.Printf("Failed to rename tag '%s': %v\n", d.Database.RenameTag, err)
+			return
+		}
+		fmt.Printf("* Renamed tag '%s' to '%s'\n", d.Database.RenameTag, d.Database.TagTo)
+	}
+	if d.Database.MergeTag != "" {
+		err := db.MergeTags(d.Database.MergeTag, d.Database.TagTo)
+		if err != nil {
+			fmt.Printf("Failed to merge tag '%s': %v\n", d.Database.MergeTag, err)
+			return
+		}
+		fmt.Printf("* Merged tag '%s' into '%s'\n", d.Database.MergeTag, d.Database.TagTo)
+	}
+	if d.Database.AliasTag != "" {
+		err := db.AddTagAlias(d.Database.AliasTag, d.Database.TagTo)
+		if err != nil {
+			fmt.Printf("Failed to add alias '%s': %v\n", d.Database.AliasTag, err)
+			return
+		}
+		fmt.Printf("+ Added alias '%s' of '%s'\n", d.Database.AliasTag, d.Database.TagTo)
+	}
+	if d.Database.RemoveAlias != "" {
+		err := db.RemoveTagAlias(d.Database.RemoveAlias)
+		if err != nil {
+			fmt.Printf("Failed to remove alias '%s': %v\n", d.Database.RemoveAlias, err)
+			return
+		}
+		fmt.Printf("- Removed alias '%s'\n", d.Database.RemoveAlias)
+	}
+	if d.Database.ListAliases {
+		aliases, err := db.GetTagAliases()
+		if err != nil {
+			fmt.Printf("Failed to get aliases: %v\n", err)
+			return
+		}
+		fmt.Printf("Alias, Tag\n")
+		for _, v := range aliases {
+			fmt.Printf("%s, %s\n", v.Alias, v.Tag)
+		}
+	}
+}
+
+// List files found at more then one path
+func DbDuplicatesExecute(db *filedb.FileDb) {
+	groups, err := db.GetDuplicates()
+	if err != nil {
+		fmt.Printf("Failed to get duplicates: %v\n", err)
+		return
+	}
+	wasted := int64(0)
+	for _, g := range groups {
+		fmt.Printf("%s, %d copies, %s wasted\n", g.File.GetHash(), len(g.Locations)+1, bytesToString(float64(g.GetWasted())))
+		fmt.Printf("  * %d, %s\n", g.File.GetId(), g.File.GetPath())
+		for _, l := range g.Locations {
+			fmt.Printf("    Location %d, %s\n", l.GetId(), l.GetPath())
+		}
+		wasted += g.GetWasted()
+	}
+	fmt.Printf("%d files have copies, using %s\n", len(groups), bytesToString(float64(wasted)))
+}
+
+// Find files that were moved or renamed on disk & fix their paths
+func DbRelinkExecute(d *ArgList, db *filedb.FileDb) {
+	moved, err := db.FindMovedFiles(d.Database.Relink)
+	if err != nil {
+		fmt.Printf("Failed to find moved files: %v\n", err)
+		return
+	}
+	if d.Database.Dry {
+		ops := make([]*DryOperation, 0, len(moved))
+		for _, m := range moved {
+			matchedBy := "size"
+			if m.ByHash {
+				matchedBy = "hash"
+			}
+			ops = append(ops, &DryOperation{
+				Operation:     "db.RelinkFile",
+				OperationArgs: []string{m.NewPath, matchedBy},
+				TargetId:      m.File.GetId(),
+				TargetPath:    m.File.GetPath(),
+				Error:         nil,
+			})
+		}
+		writeDryOperations(d, ops)
+		return
+	}
+	if len(moved) == 0 {
+		fmt.Printf("No moved files found in '%s'\n", d.Database.Relink)
+		return
+	}
+	if !d.Database.Backup && !d.Database.NoBackup {
+		// If d.Database.Backup is set we already backed up
+		err := copyFile(d.Database.DatabasePath, fmt.Sprintf("%s.bak", d.Database.DatabasePath))
+		if err != nil {
+			fmt.Printf("Failed to backup database: %v\n", err)
+			return
+		}
+	}
+	relinked := 0
+	for _, m := range moved {
+		oldPath := m.File.GetPath()
+		err = db.RelinkFile(m.File, m.NewPath)
+		if err != nil {
+			fmt.Printf("Failed to relink '%s' (%d) to '%s': %v\n", oldPath, m.File.GetId(), m.NewPath, err)
+			continue
+		}
+		fmt.Printf("* Relinked '%s' (%d) to '%s'\n", oldPath, m.File.GetId(), m.NewPath)
+		relinked++
+	}
+	fmt.Printf("Relinked %d of %d moved files\n", relinked, len(moved))
+}
+
+// Save, list or remove saved searches
+func DbSearchExecute(d *ArgList, db *filedb.FileDb, user *filedb.User) {
+	if d.Database.SaveSearch != "" {
+		s, err := db.AddSavedSearch(user, d.Database.SaveSearch, &filedb.SearchQuery{
+			Path:          d.Database.SelectPath,
+			WhitelistTags: d.Database.SelectTags,
+			Query:         d.Database.SelectQuery,
+		})
+		if err != nil {
+			fmt.Printf("Failed to save search '%s': %v\n", d.Database.SaveSearch, err)
+			return
+		}
+		fmt.Printf("+ Saved search '%s' (%d) for '%s': %s\n", s.GetName(), s.GetId(), user.GetName(), s.GetQuery())
+	}
+	if d.Database.RemoveSearch != "" {
+		s, err := db.GetSavedSearch(user, d.Database.RemoveSearch)
+		if err != nil {
+			fmt.Printf("Failed to get saved search '%s': %v\n", d.Database.RemoveSearch, err)
+			return
+		}
+		err = db.RemoveSavedSearch(s)
+		if err != nil {
+			fmt.Printf("Failed to remove saved search '%s': %v\n", d.Database.RemoveSearch, err)
+			return
+		}
+		fmt.Printf("- Removed saved search '%s'\n", d.Database.RemoveSearch)
+	}
+	if d.Database.ListSearches {
+		searches, err := db.GetSavedSearches(user)
+		if err != nil {
+			fmt.Printf("Failed to get saved searches: %v\n", err)
+			return
+		}
+		fmt.Printf("ID, Name, Query, Sort, Created\n")
+		for _, v := range searches {
+			sortName := v.GetSortBy().String()
+			if v.GetSortReverse() {
+				sortName += " (reversed)"
+			}
+			if v.GetIgnoreUnsetStars() {
+				sortName += " (ignoring unset stars)"
+			}
+			fmt.Printf("%d, %s, %s, %s, %s\n", v.GetId(), v.GetName(), v.GetQuery(), sortName, v.GetCreated().Format(time.RFC3339))
+		}
+	}
+}
+
 type jsonFile struct {
 	Id         int
 	Tags       []string
 	Path       string
+	Name       string
 	LastViewed string
 	Stars      int
 	Size       int64
@@ -355,6 +820,27 @@ func ParseDatabase(d *ArgList, p *arg.Parser) {
 	if !d.Database.Verify(p) {
 		return
 	}
+	if d.Database.ThumbDir == "" {
+		d.Database.ThumbDir = thumbnail.DefaultDir(d.Database.DatabasePath)
+	}
+	d.Database.types = mediatype.Default()
+	if d.Database.Types != "" {
+		types, err := mediatype.Load(d.Database.Types)
+		if err != nil {
+			fmt.Printf("Failed to load types: %v\n", err)
+			return
+		}
+		d.Database.types = types
+	}
+	if d.Database.ApplyRules != "" {
+		rules, err := tagrules.Load(d.Database.ApplyRules)
+		if err != nil {
+			fmt.Printf("Failed to load rules: %v\n", err)
+			return
+		}
+		rules.SetTypes(d.Database.types)
+		d.Database.rules = rules
+	}
 	if d.Database.Backup {
 		err := copyFile(d.Database.DatabasePath, fmt.Sprintf("%s.bak", d.Database.DatabasePath))
 		if err != nil {
@@ -367,11 +853,11 @@ func ParseDatabase(d *ArgList, p *arg.Parser) {
 			p.FailSubcommand("a action argument is required with a select.", "database")
 			return
 		}
-	} else if !d.Database.Version && !d.Database.Update && !d.Database.Metadata {
+	} else if !d.Database.Version && !d.Database.Update && !d.Database.Metadata && !d.Database.Duplicates && d.Database.Relink == "" && !d.Database.HasKeyAction() && !d.Database.HasSearchAction() && d.Database.SaveSearch == "" && !d.Database.HasTagAction() {
 		if d.Database.Backup {
 			return
 		}
-		p.FailSubcommand("--version, --metadata, --update, --backup or a select & action must be provided", "database")
+		p.FailSubcommand("--version, --metadata, --duplicates, --relink, --update, --backup, --addkey, --listkeys, --removekey, --listsearches, --removesearch, --renametag, --mergetag, --aliastag, --removealias, --listaliases or a select & action must be provided", "database")
 		return
 	}
 	// Load database.
@@ -400,8 +886,11 @@ func ParseDatabase(d *ArgList, p *arg.Parser) {
 			fmt.Printf("!! Legacy database, this database no longer works with filedb. try --update\n")
 			return
 		}
-		if meta.MinorVersion != filedb.MinorVersion {
-			fmt.Printf("! Database out of date, but is still supported. Use --update to apply updates\n")
+		if meta.MinorVersion < filedb.MinorVersion {
+			fmt.Printf("!! Database out of date, it can't be used until it's updated. Use --update to apply updates\n")
+			return
+		} else if meta.MinorVersion > filedb.MinorVersion {
+			fmt.Printf("! FileDb out of date for this database, but it is still supported\n")
 		}
 		if meta.RevisionVersion != filedb.Revision {
 			fmt.Printf("? Database out of date, but is still supported. Use --update to apply bugfixes\n")
@@ -439,7 +928,9 @@ func ParseDatabase(d *ArgList, p *arg.Parser) {
 			return
 		}
 		fmt.Printf("* Attempting to update from %s (%s) to %s (%s)\n", meta.VersionString(), meta.VersionCodeName, filedb.FormatVersion(filedb.MajorVersion, filedb.MinorVersion, filedb.Revision), filedb.VersionCodeName)
-		err = filedb.DoMigration(db)
+		err = filedb.DoMigration(db, &filedb.MigrationOpts{
+			Account: d.Database.MigrateAccount,
+		})
 		if err != nil {
 			fmt.Printf("! Failed to update: %v\n", err)
 			return
@@ -447,6 +938,14 @@ func ParseDatabase(d *ArgList, p *arg.Parser) {
 		fmt.Printf("+ OK\n")
 		return
 	}
+	if d.Database.Duplicates {
+		DbDuplicatesExecute(db)
+		return
+	}
+	if d.Database.Relink != "" {
+		DbRelinkExecute(d, db)
+		return
+	}
 	if len(d.Database.RemoveTagFromDb) > 0 {
 		for _, t := range d.Database.RemoveTagFromDb {
 			err = db.RemoveTag(t)
@@ -458,8 +957,28 @@ func ParseDatabase(d *ArgList, p *arg.Parser) {
 		}
 		return
 	}
+	if d.Database.HasTagAction() {
+		DbTagExecute(d, db)
+		return
+	}
+	var user *filedb.User
+	if d.Database.User != "" {
+		user, err = db.GetUserByName(d.Database.User)
+		if err != nil {
+			fmt.Printf("Failed to get user '%s': %v\n", d.Database.User, err)
+			return
+		}
+	}
+	if d.Database.HasKeyAction() {
+		DbKeyExecute(d, db, user)
+		return
+	}
+	if d.Database.HasSearchAction() || d.Database.SaveSearch != "" {
+		DbSearchExecute(d, db, user)
+		return
+	}
 	// Do the select
-	files, err := DbSelect(d, db)
+	files, err := DbSelect(d, db, user)
 	if err != nil {
 		fmt.Printf("Select failed: %v\n", err)
 		return
@@ -481,6 +1000,7 @@ func ParseDatabase(d *ArgList, p *arg.Parser) {
 				Id:         v.GetId(),
 				Tags:       v.GetTags(),
 				Path:       v.GetPath(),
+				Name:       v.GetName(),
 				LastViewed: v.GetLastPlayTime().UTC().Format(time.RFC3339),
 				Stars:      int(v.GetStars()),
 				Size:       v.GetSize(),
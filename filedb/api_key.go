@@ -0,0 +1,293 @@
+package filedb
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// Permissions a API key has, this is a bitmask.
+type Permission int
+
+const (
+	PermissionRead         Permission = 1 << iota // Read file info & file content
+	PermissionUserWrite                           // Write the keys own user data (stars, last viewed times)
+	PermissionGlobalRead                          // Read info about any account
+	PermissionGlobalModify                        // Modify global data (file paths, tags on files, users)
+	PermissionGlobalWrite                         // Write new data to the database (Add/Remove tags, remove files)
+	PermissionNoRateLimit                         // Removes the API rate limit
+	PermissionAdmin                               // Has every permission, current & future.
+
+	PermissionNone Permission = 0
+)
+
+// Every permission, in order, with its name
+var permissionNames = []struct {
+	Permission Permission
+	Name       string
+}{
+	{PermissionRead, "read"},
+	{PermissionUserWrite, "user-write"},
+	{PermissionGlobalRead, "global-read"},
+	{PermissionGlobalModify, "global-modify"},
+	{PermissionGlobalWrite, "global-write"},
+	{PermissionNoRateLimit, "no-rate-limit"},
+	{PermissionAdmin, "admin"},
+}
+
+// Checks if every permission in o is in p, admin has every permission.
+func (p Permission) Has(o Permission) bool {
+	if p&PermissionAdmin != 0 {
+		return true
+	}
+	return p&o == o
+}
+
+// Names of each permission in p
+func (p Permission) Names() []string {
+	names := make([]string, 0)
+	for _, v := range permissionNames {
+		if p&v.Permission != 0 {
+			names = append(names, v.Name)
+		}
+	}
+	return names
+}
+
+func (p Permission) String() string {
+	return strings.Join(p.Names(), ",")
+}
+
+// Parse permission names, as returned by Permission.Names
+func ParsePermissions(names ...string) (Permission, error) {
+	p := PermissionNone
+	for _, n := range names {
+		found := false
+		for _, v := range permissionNames {
+			if strings.EqualFold(v.Name, strings.TrimSpace(n)) {
+				p |= v.Permission
+				found = true
+				break
+			}
+		}
+		if !found {
+			return PermissionNone, fmt.Errorf("unknown permission '%s'", n)
+		}
+	}
+	return p, nil
+}
+
+// The most permissions a key for this account type can have
+func (a AccountType) Permissions() Permission {
+	switch a {
+	case AccountTypeGuest:
+		return PermissionRead
+	case AccountTypeUser:
+		return PermissionRead | PermissionUserWrite
+	case AccountTypeModerator:
+		return PermissionRead | PermissionUserWrite | PermissionGlobalRead | PermissionGlobalModify | PermissionNoRateLimit
+	case AccountTypeAdministrator:
+		return PermissionAdmin
+	default:
+		return PermissionNone
+	}
+}
+
+// A API key, only the hash of the key is stored.
+type ApiKey struct {
+	id          int
+	userId      int
+	name        string
+	permissions Permission
+	created     time.Time
+}
+
+func (k *ApiKey) GetId() int {
+	return k.id
+}
+
+// Id of the user this key belongs to
+func (k *ApiKey) GetUserId() int {
+	return k.userId
+}
+
+func (k *ApiKey) GetName() string {
+	return k.name
+}
+
+// Permissions given to the key, these are limited by the users account type (See ApiKey.GetEffectivePermissions)
+func (k *ApiKey) GetPermissions() Permission {
+	return k.permissions
+}
+
+// Permissions the key has when used by u, which is the key permissions limited by the account type of u.
+func (k *ApiKey) GetEffectivePermissions(u *User) Permission {
+	allowed := u.GetAccountType().Permissions()
+	if allowed.Has(PermissionAdmin) {
+		return k.permissions
+	}
+	return k.permissions & allowed
+}
+
+// Time the key was created
+func (k *ApiKey) GetCreated() time.Time {
+	return k.created
+}
+
+// Hash of a API key or session token as stored in the database
+func hashToken(key string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(key)))
+}
+
+// Don't call .Next before calling this function or you will lose a key.
+func (d *FileDb) sqlRowsToApiKeys(r *sql.Rows) []*ApiKey {
+	keys := make([]*ApiKey, 0)
+	for r.Next() {
+		k := &ApiKey{}
+		created := int64(0)
+		err := r.Scan(&k.id, &k.userId, &k.name, &k.permissions, &created)
+		if err != nil {
+			// This can only happen if the database structure has changed.
+			slog.Error("Failed to scan from ApiKey rows", "Error", err.Error())
+			panic(fmt.Sprintf("MediaManager: sqlRowsToApiKeys: Scanning from api_key rows failed: %v", err))
+		}
+		k.created = time.Unix(created, 0)
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// Create a new API key for a user, the key is returned and cannot be retrieved again.
+//
+// The permissions cannot be more than the users account type allows.
+func (d *FileDb) AddApiKey(u *User, name string, perms Permission) (string, *ApiKey, error) {
+	if d.safeMode {
+		return "", nil, ErrOutdatedDatabase
+	}
+	if err := isValidUser(u); err != nil {
+		return "", nil, err
+	}
+	if name == "" {
+		return "", nil, errors.New("name cannot be empty")
+	}
+	if perms == PermissionNone {
+		return "", nil, errors.New("key must have at least one permission")
+	}
+	if allowed := u.GetAccountType().Permissions(); !allowed.Has(perms) {
+		return "", nil, fmt.Errorf("%s accounts cannot have the '%s' permissions", u.GetAccountType(), perms&^allowed)
+	}
+	buf := make([]byte, 32)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to generate key: %v", err)
+	}
+	key := fmt.Sprintf("mm_%x", buf)
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	k := &ApiKey{
+		userId:      u.id,
+		name:        name,
+		permissions: perms,
+		created:     time.Unix(time.Now().Unix(), 0),
+	}
+	// Don't log the hash
+	slog.Info("Executing INSERT", "Query", "INSERT INTO api_key(userId, name, hash, permissions, created) VALUES (?, ?, ?, ?, ?)", "QueryArgs", []any{k.userId, k.name, "<hash>", k.permissions, k.created.Unix()})
+	res, err := d.db.Exec("INSERT INTO api_key(userId, name, hash, permissions, created) VALUES (?, ?, ?, ?, ?)", k.userId, k.name, hashToken(key), k.permissions, k.created.Unix())
+	if err != nil {
+		// This would fail if the name isn't unique for the user
+		slog.Info("Failed to insert into api_key", "Query", "INSERT INTO api_key(userId, name, hash, permissions, created) VALUES (?, ?, ?, ?, ?)", "Error", err.Error())
+		return "", nil, fmt.Errorf("failed to insert into api_key table: %v", err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		slog.Error("Failed to get lastInsertId", "Error", err.Error(), "Query", "INSERT INTO api_key(userId, name, hash, permissions, created) VALUES (?, ?, ?, ?, ?)")
+		panic(fmt.Sprintf("MediaManager: AddApiKey: .LastInsertId failed to get id, is the database correct?: %v", err))
+	}
+	k.id = int(id)
+	return key, k, nil
+}
+
+// Get the API key matching key
+func (d *FileDb) GetApiKey(key string) (*ApiKey, error) {
+	if d.safeMode {
+		return nil, ErrOutdatedDatabase
+	}
+	slog.Debug("Executing SELECT", "Query", "SELECT id, userId, name, permissions, created FROM api_key WHERE hash=?")
+	rows, err := d.db.Query("SELECT id, userId, name, permissions, created FROM api_key WHERE hash=?", hashToken(key))
+	if err != nil {
+		slog.Error("Failed to execute select query", "Query", "SELECT id, userId, name, permissions, created FROM api_key WHERE hash=?", "Error", err.Error())
+		panic(fmt.Sprintf("MediaManager: GetApiKey query failed: %v", err))
+	}
+	defer rows.Close()
+	keys := d.sqlRowsToApiKeys(rows)
+	if len(keys) == 0 {
+		return nil, errors.New("key not found")
+	}
+	return keys[0], nil
+}
+
+// Get a API key by ID
+func (d *FileDb) GetApiKeyById(id int) (*ApiKey, error) {
+	if d.safeMode {
+		return nil, ErrOutdatedDatabase
+	}
+	slog.Debug("Executing SELECT", "Query", "SELECT id, userId, name, permissions, created FROM api_key WHERE id=?", "QueryArgs", []any{id})
+	rows, err := d.db.Query("SELECT id, userId, name, permissions, created FROM api_key WHERE id=?", id)
+	if err != nil {
+		slog.Error("Failed to execute select query", "Query", "SELECT id, userId, name, permissions, created FROM api_key WHERE id=?", "QueryArgs", []any{id}, "Error", err.Error())
+		panic(fmt.Sprintf("MediaManager: GetApiKeyById query failed: %v", err))
+	}
+	defer rows.Close()
+	keys := d.sqlRowsToApiKeys(rows)
+	if len(keys) == 0 {
+		return nil, errors.New("key not found")
+	}
+	return keys[0], nil
+}
+
+// Get every API key a user has, ordered by ID
+func (d *FileDb) GetApiKeys(u *User) ([]*ApiKey, error) {
+	if d.safeMode {
+		return nil, ErrOutdatedDatabase
+	}
+	if err := isValidUser(u); err != nil {
+		return nil, err
+	}
+	slog.Debug("Executing SELECT", "Query", "SELECT id, userId, name, permissions, created FROM api_key WHERE userId=? ORDER BY id", "QueryArgs", []any{u.id})
+	rows, err := d.db.Query("SELECT id, userId, name, permissions, created FROM api_key WHERE userId=? ORDER BY id", u.id)
+	if err != nil {
+		slog.Error("Failed to execute select query", "Query", "SELECT id, userId, name, permissions, created FROM api_key WHERE userId=? ORDER BY id", "QueryArgs", []any{u.id}, "Error", err.Error())
+		panic(fmt.Sprintf("MediaManager: GetApiKeys query failed: %v", err))
+	}
+	defer rows.Close()
+	return d.sqlRowsToApiKeys(rows), nil
+}
+
+// Remove a API key, it can no longer be used
+func (d *FileDb) RemoveApiKey(k *ApiKey) error {
+	if d.safeMode {
+		return ErrOutdatedDatabase
+	}
+	if k == nil || k.id == 0 {
+		return errors.New("invalid key")
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	slog.Info("Executing DELETE", "Query", "DELETE FROM api_key WHERE id=?", "QueryArgs", []any{k.id})
+	res, err := d.db.Exec("DELETE FROM api_key WHERE id=?", k.id)
+	if err != nil {
+		slog.Warn("Failed to delete api key", "Query", "DELETE FROM api_key WHERE id=?", "QueryArgs", []any{k.id}, "Error", err.Error())
+		return fmt.Errorf("failed to remove from api_key table: %v", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return errors.New("key not found")
+	}
+	// Invalidate the key
+	k.id = 0
+	return nil
+}
@@ -0,0 +1,84 @@
+package filedb
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAddApiKey(t *testing.T) {
+	db := getTestDb(t)
+	defer db.Close()
+	u := getTestUser(t, db)
+	key, k, err := db.AddApiKey(u, "script", PermissionRead|PermissionUserWrite)
+	if !assert.NoErrorf(t, err, "AddApiKey failed") {
+		return
+	}
+	assert.NotEmptyf(t, key, "Key was empty")
+	assert.Equalf(t, u.GetId(), k.GetUserId(), "Key belongs to wrong user")
+	assert.Equalf(t, PermissionRead|PermissionUserWrite, k.GetPermissions(), "Wrong permissions")
+	fromDb, err := db.GetApiKey(key)
+	if assert.NoErrorf(t, err, "GetApiKey failed") {
+		assert.Equalf(t, k, fromDb, "GetApiKey returned wrong key")
+	}
+	_, err = db.GetApiKey(key + "0")
+	assert.Errorf(t, err, "GetApiKey found a invalid key")
+	_, _, err = db.AddApiKey(u, "script", PermissionRead)
+	assert.Errorf(t, err, "Added key with a duplicate name")
+	_, _, err = db.AddApiKey(u, "none", PermissionNone)
+	assert.Errorf(t, err, "Added key without permissions")
+	// Users can't have global permissions
+	_, _, err = db.AddApiKey(u, "global", PermissionGlobalWrite)
+	assert.Errorf(t, err, "Added key with more permissions than the account allows")
+}
+
+func TestRemoveApiKey(t *testing.T) {
+	db := getTestDb(t)
+	defer db.Close()
+	u := getTestUser(t, db)
+	key, k, err := db.AddApiKey(u, "script", PermissionRead)
+	if !assert.NoErrorf(t, err, "AddApiKey failed") {
+		return
+	}
+	assert.NoErrorf(t, db.RemoveApiKey(k), "RemoveApiKey failed")
+	assert.Errorf(t, db.RemoveApiKey(k), "RemoveApiKey worked on a removed key")
+	_, err = db.GetApiKey(key)
+	assert.Errorf(t, err, "Removed key was found")
+	// Removing a user removes their keys
+	key, _, err = db.AddApiKey(u, "script", PermissionRead)
+	if !assert.NoErrorf(t, err, "AddApiKey failed") {
+		return
+	}
+	assert.NoErrorf(t, db.RemoveUser(u), "RemoveUser failed")
+	_, err = db.GetApiKey(key)
+	assert.Errorf(t, err, "Key of removed user was found")
+}
+
+func TestEffectivePermissions(t *testing.T) {
+	db := getTestDb(t)
+	defer db.Close()
+	u, err := db.AddUser("mod", AccountTypeModerator)
+	if !assert.NoErrorf(t, err, "AddUser failed") {
+		return
+	}
+	_, k, err := db.AddApiKey(u, "script", PermissionRead|PermissionGlobalModify)
+	if !assert.NoErrorf(t, err, "AddApiKey failed") {
+		return
+	}
+	assert.Equalf(t, PermissionRead|PermissionGlobalModify, k.GetEffectivePermissions(u), "Wrong permissions")
+	// Demoting the account limits the key
+	assert.NoErrorf(t, u.SetAccountType(AccountTypeGuest), "SetAccountType failed")
+	assert.Equalf(t, PermissionRead, k.GetEffectivePermissions(u), "Key wasn't limited by account type")
+}
+
+func TestParsePermissions(t *testing.T) {
+	p, err := ParsePermissions("read", "User-Write", " admin")
+	if assert.NoErrorf(t, err, "ParsePermissions failed") {
+		assert.Equalf(t, PermissionRead|PermissionUserWrite|PermissionAdmin, p, "Wrong permissions")
+		assert.Equalf(t, "read,user-write,admin", p.String(), "Wrong permission string")
+	}
+	_, err = ParsePermissions("root")
+	assert.Errorf(t, err, "ParsePermissions accepted a invalid value")
+	assert.Truef(t, PermissionAdmin.Has(PermissionGlobalWrite), "Admin should have every permission")
+	assert.Falsef(t, PermissionRead.Has(PermissionRead|PermissionUserWrite), "Has should require every permission")
+}
@@ -0,0 +1,439 @@
+package filedb
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"time"
+)
+
+// A named & ordered list of files, such as a TV season, album or playlist. A file can only be in a collection once.
+type Collection struct {
+	id          int
+	name        string
+	description string
+	coverId     int
+	created     time.Time
+	count       int
+}
+
+// Columns read by sqlRowsToCollections, the collection table must be 'c'
+const collectionColumns = "c.id, c.name, c.description, c.coverFileId, c.created, (SELECT COUNT(*) FROM collection_item ci WHERE ci.collectionId = c.id)"
+
+func (c *Collection) GetId() int {
+	return c.id
+}
+
+func (c *Collection) GetName() string {
+	return c.name
+}
+
+// Set the name, this is not updated until FileDb.UpdateCollection is called
+func (c *Collection) SetName(name string) error {
+	if name == "" {
+		return errors.New("name cannot be empty")
+	}
+	c.name = name
+	return nil
+}
+
+func (c *Collection) GetDescription() string {
+	return c.description
+}
+
+// Set the description, this is not updated until FileDb.UpdateCollection is called
+func (c *Collection) SetDescription(description string) {
+	c.description = description
+}
+
+// Id of the file used as the cover, or 0 if there isn't one
+func (c *Collection) GetCoverId() int {
+	return c.coverId
+}
+
+// Set the cover, nil removes it. The file doesn't need to be in the collection. This is not updated until FileDb.UpdateCollection is called
+func (c *Collection) SetCover(f *File) error {
+	if f == nil {
+		c.coverId = 0
+		return nil
+	}
+	if err := isValidFile(f); err != nil {
+		return err
+	}
+	c.coverId = f.id
+	return nil
+}
+
+// Time the collection was created
+func (c *Collection) GetCreated() time.Time {
+	return c.created
+}
+
+// Number of files in the collection when it was got
+func (c *Collection) GetCount() int {
+	return c.count
+}
+
+// Don't call .Next before calling this function or you will lose a collection.
+//
+// The rows must be selected with collectionColumns
+func (d *FileDb) sqlRowsToCollections(r *sql.Rows) []*Collection {
+	collections := make([]*Collection, 0)
+	for r.Next() {
+		c := &Collection{}
+		var coverId sql.NullInt64
+		created := int64(0)
+		err := r.Scan(&c.id, &c.name, &c.description, &coverId, &created, &c.count)
+		if err != nil {
+			// This can only happen if the database structure has changed.
+			slog.Error("Failed to scan from Collection rows", "Error", err.Error())
+			panic(fmt.Sprintf("MediaManager: sqlRowsToCollections: Scanning from collection rows failed: %v", err))
+		}
+		c.coverId = int(coverId.Int64)
+		c.created = time.Unix(created, 0)
+		collections = append(collections, c)
+	}
+	return collections
+}
+
+// Create a empty collection, the name must be unique.
+func (d *FileDb) AddCollection(name string, description string) (*Collection, error) {
+	if d.safeMode {
+		return nil, ErrOutdatedDatabase
+	}
+	c := &Collection{
+		description: description,
+		created:     time.Unix(time.Now().Unix(), 0),
+	}
+	if err := c.SetName(name); err != nil {
+		return nil, err
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	slog.Info("Executing INSERT", "Query", "INSERT INTO collection(name, description, created) VALUES (?, ?, ?)", "QueryArgs", []any{c.name, c.description, c.created.Unix()})
+	res, err := d.db.Exec("INSERT INTO collection(name, description, created) VALUES (?, ?, ?)", c.name, c.description, c.created.Unix())
+	if err != nil {
+		// This would fail if the name isn't unique
+		slog.Info("Failed to insert into collection", "Query", "INSERT INTO collection(name, description, created) VALUES (?, ?, ?)", "Error", err.Error())
+		return nil, fmt.Errorf("failed to insert into collection table: %v", err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		slog.Error("Failed to get lastInsertId", "Error", err.Error(), "Query", "INSERT INTO collection(name, description, created) VALUES (?, ?, ?)")
+		panic(fmt.Sprintf("MediaManager: AddCollection: .LastInsertId failed to get id, is the database correct?: %v", err))
+	}
+	c.id = int(id)
+	return c, nil
+}
+
+// Get a collection by name
+func (d *FileDb) GetCollection(name string) (*Collection, error) {
+	if d.safeMode {
+		return nil, ErrOutdatedDatabase
+	}
+	const query = "SELECT " + collectionColumns + " FROM collection c WHERE c.name=?"
+	slog.Debug("Executing SELECT", "Query", query, "QueryArgs", []any{name})
+	rows, err := d.db.Query(query, name)
+	if err != nil {
+		slog.Error("Failed to execute select query", "Query", query, "QueryArgs", []any{name}, "Error", err.Error())
+		panic(fmt.Sprintf("MediaManager: GetCollection query failed: %v", err))
+	}
+	defer rows.Close()
+	collections := d.sqlRowsToCollections(rows)
+	if len(collections) == 0 {
+		return nil, errors.New("collection not found")
+	}
+	return collections[0], nil
+}
+
+// Get a collection by ID
+func (d *FileDb) GetCollectionById(id int) (*Collection, error) {
+	if d.safeMode {
+		return nil, ErrOutdatedDatabase
+	}
+	const query = "SELECT " + collectionColumns + " FROM collection c WHERE c.id=?"
+	slog.Debug("Executing SELECT", "Query", query, "QueryArgs", []any{id})
+	rows, err := d.db.Query(query, id)
+	if err != nil {
+		slog.Error("Failed to execute select query", "Query", query, "QueryArgs", []any{id}, "Error", err.Error())
+		panic(fmt.Sprintf("MediaManager: GetCollectionById query failed: %v", err))
+	}
+	defer rows.Close()
+	collections := d.sqlRowsToCollections(rows)
+	if len(collections) == 0 {
+		return nil, errors.New("collection not found")
+	}
+	return collections[0], nil
+}
+
+// Get every collection, ordered by name
+func (d *FileDb) GetCollections() ([]*Collection, error) {
+	if d.safeMode {
+		return nil, ErrOutdatedDatabase
+	}
+	const query = "SELECT " + collectionColumns + " FROM collection c ORDER BY c.name"
+	slog.Debug("Executing SELECT", "Query", query)
+	rows, err := d.db.Query(query)
+	if err != nil {
+		slog.Error("Failed to execute select query", "Query", query, "Error", err.Error())
+		panic(fmt.Sprintf("MediaManager: GetCollections query failed: %v", err))
+	}
+	defer rows.Close()
+	return d.sqlRowsToCollections(rows), nil
+}
+
+// Get every collection a file is in, ordered by name
+func (d *FileDb) GetFileCollections(f *File) ([]*Collection, error) {
+	if d.safeMode {
+		return nil, ErrOutdatedDatabase
+	}
+	if err := isValidFile(f); err != nil {
+		return nil, err
+	}
+	const query = "SELECT " + collectionColumns + " FROM collection c JOIN collection_item fci ON fci.collectionId = c.id AND fci.fileId = ? ORDER BY c.name"
+	slog.Debug("Executing SELECT", "Query", query, "QueryArgs", []any{f.id})
+	rows, err := d.db.Query(query, f.id)
+	if err != nil {
+		slog.Error("Failed to execute select query", "Query", query, "QueryArgs", []any{f.id}, "Error", err.Error())
+		panic(fmt.Sprintf("MediaManager: GetFileCollections query failed: %v", err))
+	}
+	defer rows.Close()
+	return d.sqlRowsToCollections(rows), nil
+}
+
+// Update the name, description & cover of a collection
+func (d *FileDb) UpdateCollection(c *Collection) error {
+	if d.safeMode {
+		return ErrOutdatedDatabase
+	}
+	if c == nil || c.id == 0 {
+		return errors.New("invalid collection")
+	}
+	var coverId any
+	if c.coverId != 0 {
+		coverId = c.coverId
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	slog.Info("Executing UPDATE", "Query", "UPDATE collection SET name=?, description=?, coverFileId=? WHERE id=?", "QueryArgs", []any{c.name, c.description, coverId, c.id})
+	res, err := d.db.Exec("UPDATE collection SET name=?, description=?, coverFileId=? WHERE id=?", c.name, c.description, coverId, c.id)
+	if err != nil {
+		// This would fail if the name isn't unique or the cover doesn't exist
+		slog.Warn("Failed to update collection", "Query", "UPDATE collection SET name=?, description=?, coverFileId=? WHERE id=?", "QueryArgs", []any{c.name, c.description, coverId, c.id}, "Error", err.Error())
+		return fmt.Errorf("failed to update collection table: %v", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return errors.New("collection not found")
+	}
+	return nil
+}
+
+// Remove a collection, the files in it are kept
+func (d *FileDb) RemoveCollection(c *Collection) error {
+	if d.safeMode {
+		return ErrOutdatedDatabase
+	}
+	if c == nil || c.id == 0 {
+		return errors.New("invalid collection")
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	tx, err := d.db.Begin()
+	if err != nil {
+		slog.Error("Failed to create new transaction for RemoveCollection", "Error", err.Error())
+		return err
+	}
+	slog.Info("Executing DELETE", "Query", "DELETE FROM collection_item WHERE collectionId=?", "QueryArgs", []any{c.id})
+	_, err = tx.Exec("DELETE FROM collection_item WHERE collectionId=?", c.id)
+	if err != nil {
+		slog.Warn("Failed to delete collection items", "Query", "DELETE FROM collection_item WHERE collectionId=?", "QueryArgs", []any{c.id}, "Error", err.Error())
+		tx.Rollback()
+		return fmt.Errorf("failed to remove from collection_item table: %v", err)
+	}
+	slog.Info("Executing DELETE", "Query", "DELETE FROM collection WHERE id=?", "QueryArgs", []any{c.id})
+	res, err := tx.Exec("DELETE FROM collection WHERE id=?", c.id)
+	if err != nil {
+		slog.Warn("Failed to delete collection", "Query", "DELETE FROM collection WHERE id=?", "QueryArgs", []any{c.id}, "Error", err.Error())
+		tx.Rollback()
+		return fmt.Errorf("failed to remove from collection table: %v", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		tx.Rollback()
+		return errors.New("collection not found")
+	}
+	err = tx.Commit()
+	if err != nil {
+		slog.Warn("Failed to commit RemoveCollection", "Error", err.Error(), "Id", c.id)
+		return fmt.Errorf("transaction failed to commit: %v", err)
+	}
+	// Invalidate the collection
+	c.id = 0
+	c.count = 0
+	return nil
+}
+
+// Get the files of a collection in order, userId is the user to get stars & last viewed times for, 0 for none.
+func (d *FileDb) GetCollectionFiles(c *Collection, userId int) ([]*File, error) {
+	if d.safeMode {
+		return nil, ErrOutdatedDatabase
+	}
+	if c == nil || c.id == 0 {
+		return nil, errors.New("invalid collection")
+	}
+	const query = "SELECT " + fileColumns + " FROM collection_item ci JOIN file f ON f.id = ci.fileId" + fileUserJoin + " WHERE ci.collectionId=? ORDER BY ci.position"
+	slog.Debug("Executing SELECT", "Query", query, "QueryArgs", []any{userId, c.id})
+	rows, err := d.db.Query(query, userId, c.id)
+	if err != nil {
+		slog.Error("Failed to execute select query", "Query", query, "QueryArgs", []any{userId, c.id}, "Error", err.Error())
+		panic(fmt.Sprintf("MediaManager: GetCollectionFiles query failed: %v", err))
+	}
+	defer rows.Close()
+	return d.sqlRowsToFiles(rows, userId), nil
+}
+
+// Get the ids of the files in a collection, in order
+func getCollectionItemIds(tx *sql.Tx, collectionId int) ([]int, error) {
+	slog.Debug("Executing SELECT", "Query", "SELECT fileId FROM collection_item WHERE collectionId=? ORDER BY position", "QueryArgs", []any{collectionId})
+	rows, err := tx.Query("SELECT fileId FROM collection_item WHERE collectionId=? ORDER BY position", collectionId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get collection items: %v", err)
+	}
+	defer rows.Close()
+	ids := make([]int, 0)
+	for rows.Next() {
+		id := 0
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to read collection item: %v", err)
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// Replace the items of a collection, positions are the index of each file id.
+//
+// Every item is rewritten so positions never collide while they're moved around, collections are small enough for this to be fine.
+func setCollectionItemIds(tx *sql.Tx, collectionId int, ids []int) error {
+	slog.Info("Executing DELETE", "Query", "DELETE FROM collection_item WHERE collectionId=?", "QueryArgs", []any{collectionId})
+	_, err := tx.Exec("DELETE FROM collection_item WHERE collectionId=?", collectionId)
+	if err != nil {
+		return fmt.Errorf("failed to remove from collection_item table: %v", err)
+	}
+	for i, v := range ids {
+		slog.Info("Executing INSERT", "Query", "INSERT INTO collection_item(collectionId, fileId, position) VALUES (?, ?, ?)", "QueryArgs", []any{collectionId, v, i})
+		_, err = tx.Exec("INSERT INTO collection_item(collectionId, fileId, position) VALUES (?, ?, ?)", collectionId, v, i)
+		if err != nil {
+			// This would fail if the file doesn't exist
+			return fmt.Errorf("failed to insert file %d into collection_item table: %v", v, err)
+		}
+	}
+	return nil
+}
+
+// Change the items of a collection in a transaction, edit is given the current file ids in order & returns the new order.
+func (d *FileDb) editCollectionItems(c *Collection, edit func(ids []int) ([]int, error)) error {
+	if d.safeMode {
+		return ErrOutdatedDatabase
+	}
+	if c == nil || c.id == 0 {
+		return errors.New("invalid collection")
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	tx, err := d.db.Begin()
+	if err != nil {
+		slog.Error("Failed to create new transaction for collection items", "Error", err.Error())
+		return err
+	}
+	ids, err := getCollectionItemIds(tx, c.id)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	ids, err = edit(ids)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	err = setCollectionItemIds(tx, c.id, ids)
+	if err != nil {
+		slog.Warn("Failed to set collection items", "Id", c.id, "Items", ids, "Error", err.Error())
+		tx.Rollback()
+		return err
+	}
+	err = tx.Commit()
+	if err != nil {
+		slog.Warn("Failed to commit collection items", "Error", err.Error(), "Id", c.id)
+		return fmt.Errorf("transaction failed to commit: %v", err)
+	}
+	c.count = len(ids)
+	return nil
+}
+
+// Insert files into a collection at index, keeping their order. A index out of range (Such as -1) appends them.
+//
+// Fails if any file is already in the collection.
+func (d *FileDb) InsertCollectionItems(c *Collection, index int, files ...*File) error {
+	add := make([]int, len(files))
+	for i, f := range files {
+		if err := isValidFile(f); err != nil {
+			return err
+		}
+		if slices.Contains(add[:i], f.id) {
+			return fmt.Errorf("file %d was given more than once", f.id)
+		}
+		add[i] = f.id
+	}
+	return d.editCollectionItems(c, func(ids []int) ([]int, error) {
+		for _, v := range add {
+			if slices.Contains(ids, v) {
+				return nil, fmt.Errorf("file %d is already in the collection", v)
+			}
+		}
+		if index < 0 || index > len(ids) {
+			index = len(ids)
+		}
+		return slices.Insert(ids, index, add...), nil
+	})
+}
+
+// Remove files from a collection, the files after them move up.
+//
+// Fails if any file isn't in the collection.
+func (d *FileDb) RemoveCollectionItems(c *Collection, files ...*File) error {
+	for _, f := range files {
+		if err := isValidFile(f); err != nil {
+			return err
+		}
+	}
+	return d.editCollectionItems(c, func(ids []int) ([]int, error) {
+		for _, f := range files {
+			i := slices.Index(ids, f.id)
+			if i == -1 {
+				return nil, fmt.Errorf("file %d isn't in the collection", f.id)
+			}
+			ids = slices.Delete(ids, i, i+1)
+		}
+		return ids, nil
+	})
+}
+
+// Move a file in a collection to index, the files between its old & new index shift over. A index out of range (Such as -1) moves it to the end.
+func (d *FileDb) MoveCollectionItem(c *Collection, f *File, index int) error {
+	if err := isValidFile(f); err != nil {
+		return err
+	}
+	return d.editCollectionItems(c, func(ids []int) ([]int, error) {
+		i := slices.Index(ids, f.id)
+		if i == -1 {
+			return nil, fmt.Errorf("file %d isn't in the collection", f.id)
+		}
+		ids = slices.Delete(ids, i, i+1)
+		if index < 0 || index > len(ids) {
+			index = len(ids)
+		}
+		return slices.Insert(ids, index, f.id), nil
+	})
+}
@@ -0,0 +1,96 @@
+package filedb
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCollection(t *testing.T) {
+	db := getTestDb(t)
+	defer db.Close()
+	u := getTestUser(t, db)
+	files := []*File{makeTestFile(t, "/s1/e1.mp4"), makeTestFile(t, "/s1/e2.mp4"), makeTestFile(t, "/s1/e3.mp4"), makeTestFile(t, "/s1/e4.mp4")}
+	if _, err := db.AddFiles(files...); !assert.NoErrorf(t, err, "AddFiles failed") {
+		return
+	}
+	e1, e2, e3, e4 := files[0], files[1], files[2], files[3]
+	c, err := db.AddCollection("Season 1", "The first season")
+	if !assert.NoErrorf(t, err, "AddCollection failed") {
+		return
+	}
+	_, err = db.AddCollection("Season 1", "")
+	assert.Errorf(t, err, "Added collection with a duplicate name")
+	_, err = db.AddCollection("", "")
+	assert.Errorf(t, err, "Added collection with no name")
+	order := func(expected ...*File) {
+		t.Helper()
+		fs, err := db.GetCollectionFiles(c, u.GetId())
+		if !assert.NoErrorf(t, err, "GetCollectionFiles failed") {
+			return
+		}
+		expectedIds := make([]int, len(expected))
+		for i, v := range expected {
+			expectedIds[i] = v.GetId()
+		}
+		ids := make([]int, len(fs))
+		for i, v := range fs {
+			ids[i] = v.GetId()
+		}
+		assert.Equalf(t, expectedIds, ids, "Wrong collection order")
+		assert.Equalf(t, len(expected), c.GetCount(), "Wrong collection count")
+	}
+	// Insert
+	assert.NoErrorf(t, db.InsertCollectionItems(c, -1, e2, e4), "InsertCollectionItems failed")
+	assert.NoErrorf(t, db.InsertCollectionItems(c, 0, e1), "InsertCollectionItems failed")
+	assert.NoErrorf(t, db.InsertCollectionItems(c, 2, e3), "InsertCollectionItems failed")
+	order(e1, e2, e3, e4)
+	assert.Errorf(t, db.InsertCollectionItems(c, -1, e1), "Inserted a file twice")
+	order(e1, e2, e3, e4)
+	// Move
+	assert.NoErrorf(t, db.MoveCollectionItem(c, e4, 0), "MoveCollectionItem failed")
+	order(e4, e1, e2, e3)
+	assert.NoErrorf(t, db.MoveCollectionItem(c, e4, -1), "MoveCollectionItem failed")
+	order(e1, e2, e3, e4)
+	assert.NoErrorf(t, db.MoveCollectionItem(c, e1, 2), "MoveCollectionItem failed")
+	order(e2, e3, e1, e4)
+	// Remove
+	assert.NoErrorf(t, db.RemoveCollectionItems(c, e3), "RemoveCollectionItems failed")
+	order(e2, e1, e4)
+	assert.Errorf(t, db.RemoveCollectionItems(c, e3), "Removed a file that isn't in the collection")
+	assert.Errorf(t, db.MoveCollectionItem(c, e3, 0), "Moved a file that isn't in the collection")
+	// Rename, describe & set the cover
+	assert.NoErrorf(t, c.SetName("Season One"), "SetName failed")
+	c.SetDescription("")
+	assert.NoErrorf(t, c.SetCover(e2), "SetCover failed")
+	assert.NoErrorf(t, db.UpdateCollection(c), "UpdateCollection failed")
+	fromDb, err := db.GetCollection("Season One")
+	if assert.NoErrorf(t, err, "GetCollection failed") {
+		assert.Equalf(t, c, fromDb, "GetCollection returned the wrong collection")
+	}
+	inFile, err := db.GetFileCollections(e1)
+	if assert.NoErrorf(t, err, "GetFileCollections failed") {
+		assert.Equalf(t, []*Collection{c}, inFile, "Wrong collections for file")
+	}
+	// Removing files removes them from collections & covers
+	assert.NoErrorf(t, db.RemoveFile(e2), "RemoveFile failed")
+	c, err = db.GetCollectionById(c.GetId())
+	if !assert.NoErrorf(t, err, "GetCollectionById failed") {
+		return
+	}
+	assert.Equalf(t, 0, c.GetCoverId(), "Cover of removed file was kept")
+	order(e1, e4)
+	// Remove
+	other, err := db.AddCollection("Other", "")
+	if !assert.NoErrorf(t, err, "AddCollection failed") {
+		return
+	}
+	assert.NoErrorf(t, db.RemoveCollection(c), "RemoveCollection failed")
+	assert.Errorf(t, db.RemoveCollection(c), "RemoveCollection worked on a removed collection")
+	collections, err := db.GetCollections()
+	if assert.NoErrorf(t, err, "GetCollections failed") {
+		assert.Equalf(t, []*Collection{other}, collections, "Removed collection was found")
+	}
+	_, err = db.GetFileById(e1.GetId())
+	assert.NoErrorf(t, err, "Files were removed with the collection")
+}
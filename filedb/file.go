@@ -3,7 +3,9 @@ package filedb
 import (
 	"errors"
 	"fmt"
+	"mediamanager/metadata"
 	"os"
+	"slices"
 	"time"
 )
 
@@ -11,10 +13,19 @@ type File struct {
 	id         int
 	tags       []string
 	path       string
+	name       string // Display name, defaults to the last element of the path
 	lastViewed time.Time
 	stars      uint8 // Out of 5
 	size       int64
 	hash       string
+	phash      uint64             // Perceptual hash of images, only set if hasPHash is
+	hasPHash   bool               // phash is set, a image can have a hash of 0
+	missing    time.Time          // When the file was found missing from disk, zero if it wasn't
+	modTime    time.Time          // Modification time when the size was added, zero if it isn't known
+	mime       string             // MIME type, like 'video/mp4'. Empty if it isn't known
+	category   string             // image, audio or video, empty if it isn't known
+	user       int                // Id of the user stars & lastViewed belong to, 0 if the file wasn't loaded for a user.
+	meta       *metadata.Metadata // Metadata embedded in the file, nil if none was read
 }
 
 // Adds a tag to this file, if the tag doesn't exist it will be created
@@ -40,6 +51,30 @@ func (f *File) RemoveTag(tag string) {
 	}
 }
 
+// Add tags that another tag implied, tags the file already has are skipped
+func (f *File) addImpliedTags(tags []string) {
+	for _, v := range tags {
+		if !f.HasTag(v) {
+			f.tags = append(f.tags, v)
+		}
+	}
+}
+
+// Replace tags that are aliases with the tag they point to, aliases of a tag the file already has are removed
+func (f *File) resolveTagAliases(resolve func(string) string) {
+	tags := make([]string, 0, len(f.tags))
+	for _, v := range f.tags {
+		if r := resolve(v); r != v {
+			v = r
+			if slices.Contains(f.tags, v) || slices.Contains(tags, v) {
+				continue
+			}
+		}
+		tags = append(tags, v)
+	}
+	f.tags = tags
+}
+
 func (f *File) SetSize(size int64) {
 	f.size = size
 }
@@ -67,6 +102,20 @@ func (f *File) GetPath() string {
 	return f.path
 }
 
+// Gets the display name of the file
+func (f *File) GetName() string {
+	return f.name
+}
+
+// Sets the display name of the file, the name cannot be empty
+func (f *File) SetName(name string) error {
+	if name == "" {
+		return errors.New("cannot use empty name")
+	}
+	f.name = name
+	return nil
+}
+
 func (f *File) MarkFileRead() {
 	// Theres a better way to do this... right?
 	f.lastViewed = time.Unix(time.Now().Unix(), 0)
@@ -88,6 +137,21 @@ func (f *File) SetStars(v uint8) error {
 	return nil
 }
 
+// Set the user this files stars & last viewed time belong to, this does not load the users values (See FileDb.LoadUserData)
+// and they will be overwritten on the next FileDb.UpdateFile
+func (f *File) SetUser(u *User) {
+	if u == nil {
+		f.user = 0
+		return
+	}
+	f.user = u.id
+}
+
+// Id of the user this files stars & last viewed time belong to, or 0 if none.
+func (f *File) GetUserId() int {
+	return f.user
+}
+
 func (f *File) GetId() int {
 	return f.id
 }
@@ -96,9 +160,55 @@ func (f *File) GetHash() string {
 	return f.hash
 }
 
+// Perceptual hash of the image, see the phash package. ok is false if the file has none
+func (f *File) GetPHash() (hash uint64, ok bool) {
+	return f.phash, f.hasPHash
+}
+
+// Remove the perceptual hash on the next FileDb.UpdateFile
+func (f *File) ClearPHash() {
+	f.phash, f.hasPHash = 0, false
+}
+
+// Modification time of the file when its size was added, with the size it tells if the file changed without hashing it. Zero if it isn't known
+func (f *File) GetModTime() time.Time {
+	return f.modTime
+}
+
+// MIME type of the file, like 'video/mp4'. Empty if it isn't known, see AddInfoOpts.AddType
+func (f *File) GetMime() string {
+	return f.mime
+}
+
+// Category of the file, image, audio or video. Empty if it isn't known
+func (f *File) GetCategory() string {
+	return f.category
+}
+
+// Set the MIME type & category, saved by FileDb.UpdateFile. See mediatype.Registry.Detect
+func (f *File) SetType(mime string, category string) {
+	f.mime, f.category = mime, category
+}
+
+// When the file was found missing from disk, see FileDb.MarkFileMissing. ok is false if it wasn't
+func (f *File) GetMissing() (when time.Time, ok bool) {
+	return f.missing, !f.missing.IsZero()
+}
+
+// Metadata embedded in the file like its title or when it was taken, nil if none was read
+func (f *File) GetMeta() *metadata.Metadata {
+	return f.meta
+}
+
+// Set the embedded metadata, nil removes it on the next FileDb.UpdateFile
+func (f *File) SetMeta(m *metadata.Metadata) {
+	f.meta = m
+}
+
 func NewFile(path string) *File {
 	return &File{
 		path:       path,
+		name:       defaultFileName(path),
 		tags:       make([]string, 0),
 		id:         0,
 		lastViewed: time.Unix(0, 0),
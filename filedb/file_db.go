@@ -17,29 +17,6 @@ import (
 	"github.com/mattn/go-sqlite3"
 )
 
-/*
-File Table:
-CREATE TABLE file (
-  id INTEGER PRIMARY KEY AUTOINCREMENT UNIQUE NOT NULL,
-  path TEXT NOT NULL UNIQUE,
-  lastViewed TEXT NOT NULL,
-  stars INTEGER,
-  size INTEGER
- )
-
-Tag Table (File -> Tag ID):
-CREATE TABLE tag (
-  fileId INTEGER NOT NULL,
-  tagNameId INTEGER NOT NULL
-)
-
-Tab Lookup Table (Tag ID -> Text):
-CREATE TABLE tag_name (
-  id INTEGER PRIMARY KEY UNIQUE NOT NULL,
-  value TEXT NOT NULL UNIQUE
-)
-*/
-
 var ErrOutdatedDatabase error = errors.New("outdated databases must be migrated to be accessed")
 
 // Init the database drivers
@@ -57,32 +34,93 @@ func init() {
 type SortMethod int
 
 const (
-	SortMethodNone       SortMethod = iota // Don't sort
-	SortMethodStars                        // Sort by stars
-	SortMethodSize                         // Sort by size
-	SortMethodLastViewed                   // Sort by last viewed
-	SortMethodId                           // Sort by ID (Similar to SortMethodNone, but explicit and can be used with ASC and DESC)
-	SortMethodRandom                       // Get random files
+	SortMethodNone             SortMethod = iota // Don't sort
+	SortMethodStars                              // Sort by stars
+	SortMethodSize                               // Sort by size
+	SortMethodLastViewed                         // Sort by last viewed
+	SortMethodId                                 // Sort by ID (Similar to SortMethodNone, but explicit and can be used with ASC and DESC)
+	SortMethodRandom                             // Get random files
+	SortMethodAverageStars                       // Sort by the average stars of every user (See SearchQuery.IgnoreUnsetStars)
+	SortMethodPopularity                         // Sort by the number of users who viewed or starred the file
+	SortMethodGlobalLastViewed                   // Sort by the latest last viewed time of any user
+	SortMethodRelevance                          // Sort by how well files match the words in SearchQuery.Text & SearchQuery.Query, best first. Same as SortMethodNone without full text search
+	SortMethodTitle                              // Sort by embedded title, files without one are last
+	SortMethodArtist                             // Sort by embedded artist, then album & track. Files without one are last
+	SortMethodAlbum                              // Sort by embedded album, then track. Files without one are last
+	SortMethodDuration                           // Sort by audio or video length, files without one are last
+	SortMethodTaken                              // Sort by when the photo was taken, files without a date are last
+	SortMethodResolution                         // Sort by width * height, files without one are last
 )
 
+// Names of each sort method, as used by the APIs
+var sortMethodNames = map[SortMethod]string{
+	SortMethodNone:             "none",
+	SortMethodStars:            "stars",
+	SortMethodSize:             "size",
+	SortMethodLastViewed:       "date",
+	SortMethodId:               "id",
+	SortMethodRandom:           "random",
+	SortMethodAverageStars:     "average_stars",
+	SortMethodPopularity:       "popularity",
+	SortMethodGlobalLastViewed: "global_date",
+	SortMethodRelevance:        "relevance",
+	SortMethodTitle:            "title",
+	SortMethodArtist:           "artist",
+	SortMethodAlbum:            "album",
+	SortMethodDuration:         "duration",
+	SortMethodTaken:            "taken",
+	SortMethodResolution:       "resolution",
+}
+
+func (s SortMethod) String() string {
+	if n, found := sortMethodNames[s]; found {
+		return n
+	}
+	return fmt.Sprintf("SortMethod(%d)", int(s))
+}
+
+// Parse a sort method name, as returned by SortMethod.String. A empty name is SortMethodNone.
+func ParseSortMethod(name string) (SortMethod, error) {
+	if name == "" {
+		return SortMethodNone, nil
+	}
+	for k, v := range sortMethodNames {
+		if v == name {
+			return k, nil
+		}
+	}
+	return SortMethodNone, fmt.Errorf("unknown sort method '%s'", name)
+}
+
 // A new search query, leaves values unfilled and they wont be used
 type SearchQuery struct {
-	Path          string     // File path, search with a sql LIKE
-	PathRe        string     // File path Regex search.
-	WhitelistTags []string   // Tags that must exist, tags must be exact.
-	BlacklistTags []string   // Tags that cannot exist, tags must be exact.
-	Count         int64      // Max number of results to get. Default: 50
-	Index         int64      // Index to start getting files at.
-	SortBy        SortMethod // Sorting method
-	SortReverse   bool       // Sort by DESC instead of ASC
-	Hash          string     // Search by hash, or "NULL" to search for values with no hashes, if empty ignore this.
+	Path             string     // File path, search with a sql LIKE
+	PathRe           string     // File path Regex search.
+	WhitelistTags    []string   // Tags that must exist, a tag also matches its children (See TagHierarchySeparator).
+	BlacklistTags    []string   // Tags that cannot exist, a tag also matches its children.
+	Count            int64      // Max number of results to get. Default: 50
+	Index            int64      // Index to start getting files at.
+	SortBy           SortMethod // Sorting method
+	SortReverse      bool       // Sort by DESC instead of ASC
+	Hash             string     // Search by hash, or "NULL" to search for values with no hashes, if empty ignore this.
+	UserId           int        // User to get stars & last viewed times for, SortMethodStars and SortMethodLastViewed sort by this users values. 0 for none.
+	IgnoreUnsetStars bool       // SortMethodAverageStars ignores users who haven't starred the file instead of counting them as 0 stars
+	Text             string     // Words that must be in the path, name or tags. "Quoted words" are a phrase and a trailing '*' matches prefixes. Sorted by relevance if SortBy is SortMethodNone.
+	Query            string     // Query language filter that must also match, see QueryNode for the syntax. Its words are ranked like Text. Returns a *QueryError if it's invalid.
 }
 
+// Columns read by sqlRowsToFiles, the file table must be 'f' and user_file joined as 'uf' using fileUserJoin
+const fileColumns = "f.id, f.path, f.name, f.size, f.hash, f.phash, f.missing, f.mtime, f.mime, f.category, uf.stars, uf.lastViewed"
+
+// Join a users file data, takes the user id as a argument
+const fileUserJoin = " LEFT JOIN user_file uf ON uf.fileId = f.id AND uf.userId = ?"
+
 // File Database
 type FileDb struct {
 	db       *sql.DB
 	lock     sync.Mutex
 	safeMode bool // Enabled if the database is outdated.
+	fullText bool // Enabled if the file_search index is available.
 }
 
 // Remove a tag from a file
@@ -132,14 +170,43 @@ func (d *FileDb) removeFileTag(tx *sql.Tx, fileId int, tag string) error {
 	return nil
 }
 
-func (d *FileDb) addFileTag(tx *sql.Tx, fileId int, tag string) error {
-	tag = strings.ToLower(tag)
+// Add a tag to a file along with every tag it implies (See AddTagImplication), implied tags the file already has are skipped.
+//
+// Returns the implied tags that were added, callers must add them to the File so UpdateFile doesn't remove them.
+func (d *FileDb) addFileTag(tx *sql.Tx, fileId int, tag string) ([]string, error) {
+	tag = strings.ToLower(resolveTagAlias(tx, tag))
+	implied := make([]string, 0)
+	seen := []string{tag}
+	pending := []string{tag}
+	for len(pending) != 0 {
+		t := pending[0]
+		pending = pending[1:]
+		t = strings.ToLower(resolveTagAlias(tx, t))
+		added, err := d.insertFileTag(tx, fileId, t, t != tag)
+		if err != nil {
+			return nil, err
+		}
+		if added && t != tag {
+			implied = append(implied, t)
+		}
+		for _, v := range getImpliedTags(tx, t) {
+			if !slices.Contains(seen, v) {
+				seen = append(seen, v)
+				pending = append(pending, v)
+			}
+		}
+	}
+	return implied, nil
+}
+
+// Add a single tag to a file, adding it to tag_name as needed. If ignoreExisting is set a tag the file already has isn't a error & false is returned.
+func (d *FileDb) insertFileTag(tx *sql.Tx, fileId int, tag string, ignoreExisting bool) (bool, error) {
 	// Check if the tag already exists
 	slog.Debug("Executing SELECT", "Query", "SELECT id FROM tag_name WHERE value=?", "QueryArgs", []any{tag})
 	res, err := tx.Query("SELECT id FROM tag_name WHERE value=?", tag)
 	if err != nil {
 		slog.Error("failed to query tag_name table", "Error", err.Error(), "Query", "SELECT id FROM tag_name WHERE value=?", "QueryArgs", tag)
-		panic(fmt.Sprintf("MediaManager: addFileTag: .Query failed when it should never have been able to, Error: %v", err))
+		panic(fmt.Sprintf("MediaManager: insertFileTag: .Query failed when it should never have been able to, Error: %v", err))
 	}
 	defer res.Close()
 	tagId := -1
@@ -150,7 +217,7 @@ func (d *FileDb) addFileTag(tx *sql.Tx, fileId int, tag string) error {
 			// This can only happen if the database structure has changed.
 			slog.Error("Scan failed on query, this is a database structure error", "Error", err.Error(), "Query", "SELECT id FROM tag_name WHERE value=?", "QueryArgs", tag)
 			tx.Rollback()
-			panic(fmt.Sprintf("MediaManager: addFileTag: .Scan failed on tag_name.id, is the database correct?: %v", err))
+			panic(fmt.Sprintf("MediaManager: insertFileTag: .Scan failed on tag_name.id, is the database correct?: %v", err))
 		}
 	} else {
 		// Doesn't exist - insert the tag.
@@ -160,31 +227,40 @@ func (d *FileDb) addFileTag(tx *sql.Tx, fileId int, tag string) error {
 			// This *could* fail if we just inserted it (I suppose.)
 			slog.Error("Failed to insert into tag_name", "Query", "INSERT INTO tag_name(value) VALUES (?)", "QueryArgs", []any{tag}, "Error", err.Error())
 			tx.Rollback()
-			return fmt.Errorf("failed to insert into tag_name table: %v", err)
+			return false, fmt.Errorf("failed to insert into tag_name table: %v", err)
 		}
 		v, err := r.LastInsertId()
 		if err != nil {
 			// This can only happen if the database structure has changed.
 			slog.Error("Failed to get lastInsertId", "Error", err.Error(), "Query", "INSERT INTO tag_name(value) VALUES (?)", "QueryArgs", []any{tag})
 			tx.Rollback()
-			panic(fmt.Sprintf("MediaManager: addFileTag: .LastInsertId failed to get id, is the database correct?: %v", err))
+			panic(fmt.Sprintf("MediaManager: insertFileTag: .LastInsertId failed to get id, is the database correct?: %v", err))
 		}
 		tagId = int(v)
 	}
 	if tagId == -1 {
 		// Programmer error
 		slog.Error("tagId was -1 even though scan worked", "Query", "SELECT id FROM tag_name WHERE value=?", "QueryArgs", tag)
-		panic("MediaManager: addFileTag: tagId was -1 when it should have been set")
+		panic("MediaManager: insertFileTag: tagId was -1 when it should have been set")
 	}
 	// Add the tag to the file
-	slog.Info("Executing INSERT", "Query", "INSERT INTO tag(fileId, tagNameId) VALUES (?, ?)", "QueryArgs", []any{fileId, tagId})
-	_, err = tx.Exec("INSERT INTO tag(fileId, tagNameId) VALUES (?, ?)", fileId, tagId)
+	query := "INSERT INTO tag(fileId, tagNameId) VALUES (?, ?)"
+	if ignoreExisting {
+		query = "INSERT OR IGNORE INTO tag(fileId, tagNameId) VALUES (?, ?)"
+	}
+	slog.Info("Executing INSERT", "Query", query, "QueryArgs", []any{fileId, tagId})
+	r, err := tx.Exec(query, fileId, tagId)
 	if err != nil {
-		slog.Error("Failed to insert into 'tag'", "Error", err.Error(), "Query", "INSERT INTO tag_name(value) VALUES (?)", "QueryArgs", []any{fileId, tagId})
+		slog.Error("Failed to insert into 'tag'", "Error", err.Error(), "Query", query, "QueryArgs", []any{fileId, tagId})
 		tx.Rollback()
-		return fmt.Errorf("failed to insert into tag table: %v", err)
+		return false, fmt.Errorf("failed to insert into tag table: %v", err)
 	}
-	return nil
+	n, err := r.RowsAffected()
+	if err != nil {
+		slog.Error("Failed to get rowsAffected", "Error", err.Error(), "Query", query, "QueryArgs", []any{fileId, tagId})
+		panic(fmt.Sprintf("MediaManager: insertFileTag: .RowsAffected failed, is the database correct?: %v", err))
+	}
+	return n != 0, nil
 }
 
 // Deprecated: Use AddFiles
@@ -219,14 +295,27 @@ func (d *FileDb) putFilesInDb(tx *sql.Tx, files ...*File) (failed []*ImportError
 		// If the hash is already set we can just ignore it.
 		// If we don't have a size or hash we just ignore it
 		// Insert the file
-		lastViewed := f.lastViewed.UTC().Unix()
+		if f.name == "" {
+			f.name = defaultFileName(f.path)
+		}
+		// Files with the same hash as another are recorded as a location of it
+		if f.hash != "" {
+			dup, err := recordDuplicate(tx, f)
+			if err != nil {
+				importErrs = append(importErrs, &ImportError{File: f, Error: err})
+				continue
+			}
+			if dup != nil {
+				importErrs = append(importErrs, &ImportError{File: f, Error: dup})
+				continue
+			}
+		}
 		queryArgs := []any{
 			f.path,
-			lastViewed,
-			f.stars,
+			f.name,
 		}
-		insertInto := "path, lastViewed, stars"
-		argStr := "?, ?, ?"
+		insertInto := "path, name"
+		argStr := "?, ?"
 		if f.size != 0 {
 			insertInto += ", size"
 			argStr += ", ?"
@@ -237,6 +326,21 @@ func (d *FileDb) putFilesInDb(tx *sql.Tx, files ...*File) (failed []*ImportError
 			argStr += ", ?"
 			queryArgs = append(queryArgs, f.hash)
 		}
+		if f.hasPHash {
+			insertInto += ", phash"
+			argStr += ", ?"
+			queryArgs = append(queryArgs, int64(f.phash))
+		}
+		if !f.modTime.IsZero() {
+			insertInto += ", mtime"
+			argStr += ", ?"
+			queryArgs = append(queryArgs, f.modTime.UnixNano())
+		}
+		if f.mime != "" {
+			insertInto += ", mime, category"
+			argStr += ", ?, ?"
+			queryArgs = append(queryArgs, f.mime, f.category)
+		}
 		query := fmt.Sprintf("INSERT INTO file(%s) VALUES (%s)", insertInto, argStr)
 		slog.Info("Executing INSERT", "Query", query, "QueryArgs", queryArgs)
 		res, err := tx.Exec(query, queryArgs...)
@@ -253,13 +357,29 @@ func (d *FileDb) putFilesInDb(tx *sql.Tx, files ...*File) (failed []*ImportError
 		fileId, err := res.LastInsertId()
 		if err != nil {
 			// I don't know how this could happen
-			slog.Error("Failed to get lastInsertId", "Query", "INSERT INTO file(path, lastViewed, stars, size, hash) VALUES (?, ?, ?, ?, ?)", "QueryArgs", []any{f.path, lastViewed, f.stars, f.size, f.hash}, "Error", err.Error())
+			slog.Error("Failed to get lastInsertId", "Query", query, "QueryArgs", queryArgs, "Error", err.Error())
 			tx.Rollback()
 			panic(fmt.Sprintf("MediaManager: AddFile: Failed to get last insert ID for file table: %v", err))
 		}
+		// The path is no longer a copy of another file
+		slog.Info("Executing DELETE", "Query", "DELETE FROM file_location WHERE path=?", "QueryArgs", []any{f.path})
+		_, err = tx.Exec("DELETE FROM file_location WHERE path=?", f.path)
+		if err != nil {
+			slog.Warn("Aborting AddFile after failing to remove file location", "Error", err.Error(), "File.Path", f.path)
+			tx.Rollback()
+			return nil, fmt.Errorf("failed to remove file location '%s', transaction must be rolled back: %v", f.path, err)
+		}
 		// Add each file tag, adding to tag_name as needed
+		f.resolveTagAliases(func(tag string) string { return resolveTagAlias(tx, tag) })
+		implied := make([]string, 0)
 		for _, v := range f.tags {
-			err = d.addFileTag(tx, int(fileId), v)
+			if slices.Contains(implied, strings.ToLower(v)) {
+				// Already added by another tag
+				continue
+			}
+			var added []string
+			added, err = d.addFileTag(tx, int(fileId), v)
+			implied = append(implied, added...)
 			if err != nil {
 				// This is actually concerning as this shouldn't happen.
 				slog.Warn("Aborting AddFile after failing to add tag", "Error", err.Error(), "Tag", v)
@@ -270,8 +390,25 @@ func (d *FileDb) putFilesInDb(tx *sql.Tx, files ...*File) (failed []*ImportError
 				return nil, fmt.Errorf("failed to add tag '%s', transaction must be rolled back: %v", v, err)
 			}
 		}
+		f.addImpliedTags(implied)
+		if f.meta != nil {
+			err = putFileMeta(tx, int(fileId), f.meta)
+			if err != nil {
+				slog.Warn("Aborting AddFile after failing to add metadata", "Error", err.Error(), "File.Path", f.path)
+				tx.Rollback()
+				return nil, fmt.Errorf("failed to add metadata to file '%s', transaction must be rolled back: %v", f.path, err)
+			}
+		}
 		// Now set id
 		f.id = int(fileId)
+		// Stars & last viewed time, if the file belongs to a user
+		err = d.putUserFile(tx, f)
+		if err != nil {
+			slog.Warn("Aborting AddFile after failing to add user file data", "Error", err.Error(), "File.User", f.user)
+			tx.Rollback()
+			f.id = 0
+			return nil, fmt.Errorf("failed to add user data to file '%s', transaction must be rolled back: %v", f.path, err)
+		}
 	}
 	return importErrs, nil
 }
@@ -371,7 +508,7 @@ func (d *FileDb) AddFilesWithInfo(goroutines int, files ...*File) (failed []*Imp
 	return importErrs, nil
 }
 
-// Updates a files tags, stars and path
+// Updates a files tags, path, name, size, modification time, type, hash, perceptual hash and metadata, if the file belongs to a user their stars and last viewed time are updated as well
 func (d *FileDb) UpdateFile(f *File) error {
 	if d.safeMode {
 		return ErrOutdatedDatabase
@@ -382,6 +519,9 @@ func (d *FileDb) UpdateFile(f *File) error {
 	if err := isValidFile(f); err != nil {
 		return err
 	}
+	if f.name == "" {
+		f.name = defaultFileName(f.path)
+	}
 	oldFile, err := d.GetFileById(f.id)
 	if err != nil {
 		// This could just be the user passing a null file or something. Probably not that bad but still worth logging.
@@ -393,39 +533,101 @@ func (d *FileDb) UpdateFile(f *File) error {
 		slog.Error("Failed to create new transaction for UpdateFile", "Error", err.Error())
 		return err
 	}
-	lastViewed := f.lastViewed.UTC().Unix()
 	if f.hash == "" {
-		slog.Info("Executing UPDATE", "Query", "UPDATE file SET path=?, lastViewed=?, stars=?, size=? WHERE id=?", "QueryArgs", []any{f.path, lastViewed, f.stars, f.size, f.id})
-		_, err = tx.Exec("UPDATE file SET path=?, lastViewed=?, stars=?, size=? WHERE id=?", f.path, lastViewed, f.stars, f.size, f.id)
+		slog.Info("Executing UPDATE", "Query", "UPDATE file SET path=?, name=?, size=? WHERE id=?", "QueryArgs", []any{f.path, f.name, f.size, f.id})
+		_, err = tx.Exec("UPDATE file SET path=?, name=?, size=? WHERE id=?", f.path, f.name, f.size, f.id)
 		if err != nil {
 			// This could fail if the path is no longer unique, but its still a issue.
-			slog.Warn("Failed to update file", "Query", "UPDATE file SET path=?, lastViewed=?, stars=?, size=? WHERE id=?", "QueryArgs", []any{f.path, lastViewed, f.stars, f.size, f.id}, "Error", err.Error())
+			slog.Warn("Failed to update file", "Query", "UPDATE file SET path=?, name=?, size=? WHERE id=?", "QueryArgs", []any{f.path, f.name, f.size, f.id}, "Error", err.Error())
 			tx.Rollback()
 			return fmt.Errorf("failed to update file: %v", err)
 		}
 	} else {
-		slog.Info("Executing UPDATE", "Query", "UPDATE file SET path=?, lastViewed=?, stars=?, size=?, hash=? WHERE id=?", "QueryArgs", []any{f.path, lastViewed, f.stars, f.size, f.hash, f.id})
-		_, err = tx.Exec("UPDATE file SET path=?, lastViewed=?, stars=?, size=?, hash=? WHERE id=?", f.path, lastViewed, f.stars, f.size, f.hash, f.id)
+		dup, err := recordChangedDuplicate(tx, f)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		if dup != nil {
+			if err = tx.Commit(); err != nil {
+				slog.Warn("Failed to commit UpdateFile", "Error", err.Error(), "File", *f)
+				return fmt.Errorf("failed to commit: %v", err)
+			}
+			return dup
+		}
+		slog.Info("Executing UPDATE", "Query", "UPDATE file SET path=?, name=?, size=?, hash=? WHERE id=?", "QueryArgs", []any{f.path, f.name, f.size, f.hash, f.id})
+		_, err = tx.Exec("UPDATE file SET path=?, name=?, size=?, hash=? WHERE id=?", f.path, f.name, f.size, f.hash, f.id)
 		if err != nil {
 			// This could fail if the path is no longer unique, but its still a issue.
-			slog.Warn("Failed to update file", "Query", "UPDATE file SET path=?, lastViewed=?, stars=?, size=?, hash=? WHERE id=?", "QueryArgs", []any{f.path, lastViewed, f.stars, f.size, f.hash, f.id}, "Error", err.Error())
+			slog.Warn("Failed to update file", "Query", "UPDATE file SET path=?, name=?, size=?, hash=? WHERE id=?", "QueryArgs", []any{f.path, f.name, f.size, f.hash, f.id}, "Error", err.Error())
+			tx.Rollback()
+			return fmt.Errorf("failed to update file: %v", err)
+		}
+	}
+	if f.hasPHash {
+		slog.Info("Executing UPDATE", "Query", "UPDATE file SET phash=? WHERE id=?", "QueryArgs", []any{int64(f.phash), f.id})
+		_, err = tx.Exec("UPDATE file SET phash=? WHERE id=?", int64(f.phash), f.id)
+		if err != nil {
+			slog.Warn("Failed to update file", "Query", "UPDATE file SET phash=? WHERE id=?", "QueryArgs", []any{int64(f.phash), f.id}, "Error", err.Error())
+			tx.Rollback()
+			return fmt.Errorf("failed to update file: %v", err)
+		}
+	} else if oldFile.hasPHash {
+		slog.Info("Executing UPDATE", "Query", "UPDATE file SET phash=NULL WHERE id=?", "QueryArgs", []any{f.id})
+		_, err = tx.Exec("UPDATE file SET phash=NULL WHERE id=?", f.id)
+		if err != nil {
+			slog.Warn("Failed to update file", "Query", "UPDATE file SET phash=NULL WHERE id=?", "QueryArgs", []any{f.id}, "Error", err.Error())
+			tx.Rollback()
+			return fmt.Errorf("failed to update file: %v", err)
+		}
+	}
+	if !f.modTime.IsZero() {
+		slog.Info("Executing UPDATE", "Query", "UPDATE file SET mtime=? WHERE id=?", "QueryArgs", []any{f.modTime.UnixNano(), f.id})
+		_, err = tx.Exec("UPDATE file SET mtime=? WHERE id=?", f.modTime.UnixNano(), f.id)
+		if err != nil {
+			slog.Warn("Failed to update file", "Query", "UPDATE file SET mtime=? WHERE id=?", "QueryArgs", []any{f.modTime.UnixNano(), f.id}, "Error", err.Error())
+			tx.Rollback()
+			return fmt.Errorf("failed to update file: %v", err)
+		}
+	}
+	if f.mime != "" {
+		slog.Info("Executing UPDATE", "Query", "UPDATE file SET mime=?, category=? WHERE id=?", "QueryArgs", []any{f.mime, f.category, f.id})
+		_, err = tx.Exec("UPDATE file SET mime=?, category=? WHERE id=?", f.mime, f.category, f.id)
+		if err != nil {
+			slog.Warn("Failed to update file", "Query", "UPDATE file SET mime=?, category=? WHERE id=?", "QueryArgs", []any{f.mime, f.category, f.id}, "Error", err.Error())
 			tx.Rollback()
 			return fmt.Errorf("failed to update file: %v", err)
 		}
 	}
+	err = d.putUserFile(tx, f)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if !metaEqual(oldFile.meta, f.meta) {
+		err = putFileMeta(tx, f.id, f.meta)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
 	// Figure out what tags we need to remove & what tags we need to add
+	f.resolveTagAliases(func(tag string) string { return resolveTagAlias(tx, tag) })
+	implied := make([]string, 0)
 	for _, v := range f.tags {
-		if slices.Index(oldFile.tags, v) == -1 {
+		if slices.Index(oldFile.tags, v) == -1 && !slices.Contains(implied, strings.ToLower(v)) {
 			slog.Debug("Adding new file tag", "FileId", f.id, "Tag", v)
 			// Does't exist in old file, add it
-			err = d.addFileTag(tx, f.id, v)
+			added, err := d.addFileTag(tx, f.id, v)
 			if err != nil {
 				slog.Warn("Aborting UpdateFile after failing to add tag", "Error", err.Error(), "Tag", v, "File.Id", f.id)
 				tx.Rollback()
 				return err
 			}
+			implied = append(implied, added...)
 		}
 	}
+	f.addImpliedTags(implied)
 	for _, v := range oldFile.tags {
 		if slices.Index(f.tags, v) == -1 {
 			slog.Debug("Removing tag", "Id", f.id, "File", f.path, "Tag", v)
@@ -446,7 +648,7 @@ func (d *FileDb) UpdateFile(f *File) error {
 	return nil
 }
 
-// Remove a file by File
+// Remove a file by File, the other locations it was found at are forgotten as well
 func (d *FileDb) RemoveFile(f *File) error {
 	if d.safeMode {
 		return ErrOutdatedDatabase
@@ -465,6 +667,36 @@ func (d *FileDb) RemoveFile(f *File) error {
 		tx.Rollback()
 		return fmt.Errorf("failed to remove from tag table: %v", err)
 	}
+	_, err = tx.Exec("DELETE FROM user_file WHERE fileId=?", f.id)
+	if err != nil {
+		slog.Warn("Failed to delete file user data from database", "Query", "DELETE FROM user_file WHERE fileId=?", "QueryArgs", []any{f.id}, "Error", err.Error())
+		tx.Rollback()
+		return fmt.Errorf("failed to remove from user_file table: %v", err)
+	}
+	_, err = tx.Exec("DELETE FROM file_meta WHERE fileId=?", f.id)
+	if err != nil {
+		slog.Warn("Failed to delete file metadata from database", "Query", "DELETE FROM file_meta WHERE fileId=?", "QueryArgs", []any{f.id}, "Error", err.Error())
+		tx.Rollback()
+		return fmt.Errorf("failed to remove from file_meta table: %v", err)
+	}
+	_, err = tx.Exec("DELETE FROM file_location WHERE fileId=?", f.id)
+	if err != nil {
+		slog.Warn("Failed to delete file locations from database", "Query", "DELETE FROM file_location WHERE fileId=?", "QueryArgs", []any{f.id}, "Error", err.Error())
+		tx.Rollback()
+		return fmt.Errorf("failed to remove from file_location table: %v", err)
+	}
+	_, err = tx.Exec("DELETE FROM collection_item WHERE fileId=?", f.id)
+	if err != nil {
+		slog.Warn("Failed to delete file from collections", "Query", "DELETE FROM collection_item WHERE fileId=?", "QueryArgs", []any{f.id}, "Error", err.Error())
+		tx.Rollback()
+		return fmt.Errorf("failed to remove from collection_item table: %v", err)
+	}
+	_, err = tx.Exec("UPDATE collection SET coverFileId=NULL WHERE coverFileId=?", f.id)
+	if err != nil {
+		slog.Warn("Failed to unset collection covers", "Query", "UPDATE collection SET coverFileId=NULL WHERE coverFileId=?", "QueryArgs", []any{f.id}, "Error", err.Error())
+		tx.Rollback()
+		return fmt.Errorf("failed to update collection table: %v", err)
+	}
 	slog.Info("Executing DELETE", "Query", "DELETE FROM file WHERE id=?", "QueryArgs", []any{f.id})
 	_, err = tx.Exec("DELETE FROM file WHERE id=?", f.id)
 	if err != nil {
@@ -484,20 +716,29 @@ func (d *FileDb) RemoveFile(f *File) error {
 }
 
 // Don't call .Next before calling this function or you will lose a file.
-func (d *FileDb) sqlRowsToFiles(r *sql.Rows) []*File {
+//
+// The rows must be selected with fileColumns, userId is the user the files stars & last viewed time belong to.
+func (d *FileDb) sqlRowsToFiles(r *sql.Rows, userId int) []*File {
 	files := make([]*File, 0)
 	// We don't get tag yet.
 	for r.Next() {
 		f := &File{
 			tags: make([]string, 0),
+			user: userId,
 		}
 		// Expected type: nil or string
 		var hashStr interface{}
 		// Expected type: nil or int
 		var sizeInt interface{}
-		timeval := int64(0)
+		var phash sql.NullInt64
+		var missing sql.NullInt64
+		var mtime sql.NullInt64
+		var mime, category sql.NullString
+		// NULL if the user hasn't set anything
+		var stars sql.NullInt16
+		var timeval sql.NullInt64
 		// I think this one is slow as fuck.
-		err := r.Scan(&f.id, &f.path, &timeval, &f.stars, &sizeInt, &hashStr)
+		err := r.Scan(&f.id, &f.path, &f.name, &sizeInt, &hashStr, &phash, &missing, &mtime, &mime, &category, &stars, &timeval)
 		if err != nil {
 			// The only way this fails if we don't pass a correct Rows value or the database is wrong, this is a programmer error.
 			// There really isn't much for us to pass here.
@@ -519,12 +760,23 @@ func (d *FileDb) sqlRowsToFiles(r *sql.Rows) []*File {
 				panic(fmt.Sprintf("MediaManager: sqlRowsToFiles: Failed to hash to string, was %v", reflect.TypeOf(hashStr)))
 			}
 		}
+		// Stored as a signed integer, the bits are the same
+		f.phash, f.hasPHash = uint64(phash.Int64), phash.Valid
+		if missing.Valid {
+			f.missing = time.Unix(missing.Int64, 0)
+		}
+		if mtime.Valid {
+			f.modTime = time.Unix(0, mtime.Int64)
+		}
+		f.mime, f.category = mime.String, category.String
+		f.stars = uint8(stars.Int16)
 		// Now we need to parse the time
-		f.lastViewed = time.Unix(timeval, 0)
+		f.lastViewed = time.Unix(timeval.Int64, 0)
 		files = append(files, f)
 	}
 	// Now we get all tags
 	d.addFileTags(files)
+	d.addFileMeta(files)
 	return files
 }
 
@@ -608,21 +860,22 @@ func (d *FileDb) GetFileByPath(path string) (f *File, err error) {
 	if d.safeMode {
 		return nil, ErrOutdatedDatabase
 	}
-	slog.Debug("Executing SELECT", "Query", "SELECT * FROM file WHERE path=?", "QueryArgs", []any{path})
-	rows, err := d.db.Query("SELECT * FROM file WHERE path=?", path)
+	const query = "SELECT " + fileColumns + " FROM file f" + fileUserJoin + " WHERE f.path=?"
+	slog.Debug("Executing SELECT", "Query", query, "QueryArgs", []any{0, path})
+	rows, err := d.db.Query(query, 0, path)
 	if err != nil {
 		// Fatal.
-		slog.Error("Failed to execute select query", "Query", "SELECT * FROM file WHERE path=?", "QueryArgs", []any{path}, "Error", err.Error())
+		slog.Error("Failed to execute select query", "Query", query, "QueryArgs", []any{0, path}, "Error", err.Error())
 		panic(fmt.Sprintf("MediaManager: GetFileByPath query failed: %v", err))
 	}
 	defer rows.Close()
-	sFile := d.sqlRowsToFiles(rows)
+	sFile := d.sqlRowsToFiles(rows, 0)
 	if len(sFile) == 0 {
 		return nil, errors.New("file not found")
 	}
 	if len(sFile) > 1 {
 		// UNIQUE constraint failed?
-		slog.Error("Got multiple files on query that should have got one", "Query", "SELECT * FROM file WHERE path=?", "QueryArgs", []any{path}, "File", len(sFile))
+		slog.Error("Got multiple files on query that should have got one", "Query", query, "QueryArgs", []any{0, path}, "File", len(sFile))
 		panic(fmt.Sprintf("MediaManager: GetFileByPath got multiple files count: %v", len(sFile)))
 	}
 	return sFile[0], nil
@@ -633,14 +886,15 @@ func (d *FileDb) GetFileById(id int) (*File, error) {
 	if d.safeMode {
 		return nil, ErrOutdatedDatabase
 	}
-	slog.Debug("Executing SELECT", "Query", "SELECT * FROM file WHERE id=?", "QueryArgs", []any{id})
-	rows, err := d.db.Query("SELECT * FROM file WHERE id=?", id)
+	const query = "SELECT " + fileColumns + " FROM file f" + fileUserJoin + " WHERE f.id=?"
+	slog.Debug("Executing SELECT", "Query", query, "QueryArgs", []any{0, id})
+	rows, err := d.db.Query(query, 0, id)
 	if err != nil {
-		slog.Error("Failed to execute select query", "Query", "SELECT * FROM file WHERE id=?", "QueryArgs", []any{id}, "Error", err.Error())
+		slog.Error("Failed to execute select query", "Query", query, "QueryArgs", []any{0, id}, "Error", err.Error())
 		panic(fmt.Sprintf("MediaManager: GetFileById query failed: %v", err))
 	}
 	defer rows.Close()
-	sFile := d.sqlRowsToFiles(rows)
+	sFile := d.sqlRowsToFiles(rows, 0)
 	if len(sFile) == 0 {
 		// File not found
 		return nil, errors.New("file not found")
@@ -679,18 +933,36 @@ func (d *FileDb) SearchFile(q *SearchQuery) ([]*File, error) {
 	if q.Count == 0 {
 		q.Count = 50
 	}
+	query, err := ParseQuery(q.Query)
+	if err != nil {
+		return nil, err
+	}
 	// We'll just log the final query, it's enough to look through and see where things went wrong anyway.
 	needsAnd := false
-	queries := "SELECT DISTINCT f.* FROM file f"
+	queries := "SELECT DISTINCT " + fileColumns + " FROM file f" + fileUserJoin
 	// I don't know how to do the blacklist & I don't care.
 	/*if len(q.BlacklistTags) != 0 {
 		queries += "JOIN tag bt ON f.id = bt.fileId JOIN tag_name btn ON bt.tagNameId = bnt.id"
 	}*/
-	qrArgs := make([]any, 0)
+	qrArgs := []any{q.UserId}
+	terms := parseSearchText(q.Text)
+	// Words in the text & the query are ranked the same way, they filter the files below
+	rankTerms := slices.Clone(terms)
+	if query != nil {
+		rankTerms = append(rankTerms, queryTextTerms(query)...)
+	}
+	ranked := false
 	// This must go first so we can add our joins
+	if len(rankTerms) != 0 && d.fullText {
+		queries += " LEFT JOIN (SELECT rowid, rank FROM file_search WHERE file_search MATCH ?) fs ON fs.rowid = f.id"
+		qrArgs = append(qrArgs, ftsRankQuery(rankTerms))
+		ranked = true
+	}
+	aliases := d.getTagAliasMap()
 	for i, v := range q.WhitelistTags {
-		queries += fmt.Sprintf(" JOIN tag wt%d ON f.id = wt%d.fileId JOIN tag_name wtn%d ON wt%d.tagNameId = wtn%d.id AND wtn%d.value = ?", i, i, i, i, i, i)
-		qrArgs = append(qrArgs, v)
+		match, args := tagMatchSql(fmt.Sprintf("wtn%d.value", i), aliases.resolve(v))
+		queries += fmt.Sprintf(" JOIN tag wt%d ON f.id = wt%d.fileId JOIN tag_name wtn%d ON wt%d.tagNameId = wtn%d.id AND ", i, i, i, i, i) + match
+		qrArgs = append(qrArgs, args...)
 	}
 	// Now add the blacklist (I think this is going to tank performance
 	for i, v := range q.BlacklistTags {
@@ -699,8 +971,9 @@ func (d *FileDb) SearchFile(q *SearchQuery) ([]*File, error) {
 		} else {
 			queries += " WHERE"
 		}
-		queries += fmt.Sprintf(" f.id NOT IN (SELECT fileId FROM tag bt%d JOIN tag_name btn%d ON bt%d.tagNameId = btn%d.id WHERE btn%d.value = ?)", i, i, i, i, i)
-		qrArgs = append(qrArgs, v)
+		match, args := tagMatchSql(fmt.Sprintf("btn%d.value", i), aliases.resolve(v))
+		queries += fmt.Sprintf(" f.id NOT IN (SELECT fileId FROM tag bt%d JOIN tag_name btn%d ON bt%d.tagNameId = btn%d.id WHERE ", i, i, i, i) + match + ")"
+		qrArgs = append(qrArgs, args...)
 		needsAnd = true
 	}
 	if q.Path != "" {
@@ -733,28 +1006,109 @@ func (d *FileDb) SearchFile(q *SearchQuery) ([]*File, error) {
 			queries += " f.hash IS NULL"
 		} else {
 			queries += " f.hash = ?"
+			qrArgs = append(qrArgs, q.Hash)
 		}
-		qrArgs = append(qrArgs, q.Hash)
+		needsAnd = true
+	}
+	if len(terms) != 0 && d.fullText {
+		if needsAnd {
+			queries += " AND"
+		} else {
+			queries += " WHERE"
+		}
+		queries += " f.id IN (SELECT rowid FROM file_search WHERE file_search MATCH ?)"
+		qrArgs = append(qrArgs, ftsQuery(terms))
+		needsAnd = true
+	} else {
+		// Without the index every term has to be in the path, name or a tag
+		for _, v := range terms {
+			if needsAnd {
+				queries += " AND"
+			} else {
+				queries += " WHERE"
+			}
+			sqlStr, sqlArgs := textTermSql(v, false)
+			queries += " " + sqlStr
+			qrArgs = append(qrArgs, sqlArgs...)
+			needsAnd = true
+		}
+	}
+	if query != nil {
+		if needsAnd {
+			queries += " AND"
+		} else {
+			queries += " WHERE"
+		}
+		sqlStr, sqlArgs := compileQuery(query, time.Now(), d.fullText, aliases)
+		queries += " (" + sqlStr + ")"
+		qrArgs = append(qrArgs, sqlArgs...)
+		needsAnd = true
 	}
 	wasSorted := false
 	switch q.SortBy {
-	case SortMethodNone:
-		// Do nothing
+	case SortMethodNone, SortMethodRelevance:
+		if ranked {
+			// Lower ranks are better, files that only matched the rest of the query are last
+			queries += " ORDER BY fs.rank IS NULL, fs.rank"
+			wasSorted = true
+		}
 	case SortMethodSize:
-		queries += " ORDER BY size"
+		queries += " ORDER BY f.size"
 		wasSorted = true
 	case SortMethodStars:
-		queries += " ORDER BY stars"
+		queries += " ORDER BY uf.stars"
 		wasSorted = true
 	case SortMethodId:
-		queries += " ORDER BY id"
+		queries += " ORDER BY f.id"
 		wasSorted = true
 	case SortMethodLastViewed:
-		queries += " ORDER BY lastViewed"
+		queries += " ORDER BY uf.lastViewed"
 		wasSorted = true
 	case SortMethodRandom:
 		queries += " ORDER BY RANDOM()"
 		wasSorted = true
+	case SortMethodAverageStars:
+		if q.IgnoreUnsetStars {
+			queries += " ORDER BY (SELECT COALESCE(AVG(NULLIF(gs.stars, 0)), 0) FROM user_file gs WHERE gs.fileId = f.id)"
+		} else {
+			queries += " ORDER BY (SELECT COALESCE(AVG(gs.stars), 0) FROM user_file gs WHERE gs.fileId = f.id)"
+		}
+		wasSorted = true
+	case SortMethodPopularity:
+		queries += " ORDER BY (SELECT COUNT(*) FROM user_file gs WHERE gs.fileId = f.id AND (gs.stars > 0 OR gs.lastViewed > 0))"
+		wasSorted = true
+	case SortMethodGlobalLastViewed:
+		queries += " ORDER BY (SELECT COALESCE(MAX(gs.lastViewed), 0) FROM user_file gs WHERE gs.fileId = f.id)"
+		wasSorted = true
+	case SortMethodTitle, SortMethodArtist, SortMethodAlbum, SortMethodDuration, SortMethodTaken, SortMethodResolution:
+		// Files without the value are last in either direction, every column after the first gets the direction too
+		columns := map[SortMethod][]string{
+			SortMethodTitle:      {"title"},
+			SortMethodArtist:     {"artist", "album", "track"},
+			SortMethodAlbum:      {"album", "track"},
+			SortMethodDuration:   {"duration"},
+			SortMethodTaken:      {"taken"},
+			SortMethodResolution: {"width * height"},
+		}[q.SortBy]
+		metaSort := func(column string) string {
+			s := "(SELECT " + column + " FROM file_meta sm WHERE sm.fileId = f.id)"
+			if column == "title" || column == "artist" || column == "album" {
+				s += " COLLATE NOCASE"
+			}
+			return s
+		}
+		direction := " ASC"
+		if q.SortReverse {
+			direction = " DESC"
+		}
+		queries += " ORDER BY (SELECT " + columns[0] + " FROM file_meta sm WHERE sm.fileId = f.id) IS NULL"
+		for i, v := range columns {
+			if i != 0 {
+				queries += direction
+			}
+			queries += ", " + metaSort(v)
+		}
+		wasSorted = true
 	default:
 		slog.Error("Got invalid q.SortBy value", "Value", q.SortBy, "Query", *q)
 		panic(fmt.Sprintf("MediaManager: FileDb.SearchFile: Got unexpected q.SortBy value '%d'", q.SortBy))
@@ -779,7 +1133,7 @@ func (d *FileDb) SearchFile(q *SearchQuery) ([]*File, error) {
 		return nil, fmt.Errorf("failed to execute search: %v, Query: %s, Args: %+v", err, queries, qrArgs)
 	}
 	defer rows.Close()
-	files := d.sqlRowsToFiles(rows)
+	files := d.sqlRowsToFiles(rows, q.UserId)
 	return files, nil
 }
 
@@ -842,7 +1196,7 @@ func (d *FileDb) AddTag(tag string) (int, error) {
 		slog.Error("Failed to begin transaction for RemoveTag", "Error", err.Error())
 		return 0, err
 	}
-	tag = strings.ToLower(tag)
+	tag = strings.ToLower(resolveTagAlias(tx, tag))
 	// Doesn't exist - insert the tag.
 	slog.Info("Executing INSERT", "Query", "INSERT INTO tag_name(value) VALUES (?)", "QueryArgs", []any{tag})
 	r, err := tx.Exec("INSERT INTO tag_name(value) VALUES (?)", tag)
@@ -1236,52 +1590,11 @@ func NewFileDb(dbPath string) (*FileDb, error) {
 			db.Close()
 			return nil, fmt.Errorf("failed to start new tx: %v", err)
 		}
-		slog.Info("Creating 'db_info' table")
-		_, err = tx.Exec(`CREATE TABLE db_info (
-			key TEXT UNIQUE NOT NULL ,
-			value ANY
-		)`)
-		if err != nil {
-			slog.Error("Failed to create 'db_info' table", "Error", err.Error())
-			db.Close()
-			return nil, fmt.Errorf("failed to create 'db_info' table: %v", err)
-		}
-		slog.Info("Creating 'file' table")
-		_, err = tx.Exec(`CREATE TABLE file (
-		id INTEGER PRIMARY KEY AUTOINCREMENT UNIQUE NOT NULL,
-  		path TEXT NOT NULL UNIQUE,
-  		lastViewed INTEGER NOT NULL,
-  		stars INTEGER,
-		size INTEGER,
-		hash TEXT UNIQUE,
-		CHECK(HASH is NULL OR length(HASH) == 64),
-		CHECK(stars >= 0 AND stars <= 5)
-		)`)
-		if err != nil {
-			slog.Error("Failed to create 'file' table", "Error", err.Error())
-			db.Close()
-			return nil, fmt.Errorf("failed to create 'file' table: %v", err)
-		}
-		slog.Info("Creating 'tag' table")
-		_, err = tx.Exec(`CREATE TABLE tag (
-  		fileId INTEGER NOT NULL,
-  		tagNameId INTEGER NOT NULL,
-		FOREIGN KEY (fileId) REFERENCES file(id),
-		FOREIGN KEY (tagNameId) REFERENCES tag_name(id),
-		UNIQUE(fileId, tagNameId))`)
+		err = createSchema(tx)
 		if err != nil {
-			slog.Error("Failed to create 'tag' table", "Error", err.Error())
-			db.Close()
-			return nil, fmt.Errorf("failed to create 'tag' table: %v", err)
-		}
-		slog.Info("Creating 'tag_name' table")
-		_, err = tx.Exec(`CREATE TABLE tag_name (
-  		id INTEGER PRIMARY KEY UNIQUE NOT NULL,
-  		value TEXT NOT NULL UNIQUE)`)
-		if err != nil {
-			slog.Error("Failed to create 'tag_name' table", "Error", err.Error())
+			tx.Rollback()
 			db.Close()
-			return nil, fmt.Errorf("failed to create 'tag_name' table: %v", err)
+			return nil, err
 		}
 		err = tx.Commit()
 		if err != nil {
@@ -1298,6 +1611,10 @@ func NewFileDb(dbPath string) (*FileDb, error) {
 		if meta.MajorVersion != MajorVersion {
 			slog.Warn("Database is a different major version, enabling safe mode", "DatabaseVersion", meta.VersionString(), "FileDbVersion", FormatVersion(MajorVersion, MinorVersion, Revision))
 			f.safeMode = true
+		} else if meta.MinorVersion < MinorVersion {
+			// Older minor versions are missing tables
+			slog.Warn("Database is a older minor version, enabling safe mode", "DatabaseVersion", meta.VersionString(), "FileDbVersion", FormatVersion(MajorVersion, MinorVersion, Revision))
+			f.safeMode = true
 		}
 		if v, found := meta.Map["experimental"]; found {
 			if isDemo, ok := v.(bool); ok && isDemo {
@@ -1305,5 +1622,12 @@ func NewFileDb(dbPath string) (*FileDb, error) {
 			}
 		}
 	}
+	if !f.safeMode {
+		err = f.setupSearchIndex()
+		if err != nil {
+			db.Close()
+			return nil, fmt.Errorf("failed to setup search index: %v", err)
+		}
+	}
 	return f, nil
 }
@@ -32,6 +32,14 @@ func getTestDb(t *testing.T) *FileDb {
 	return d
 }
 
+func getTestUser(t *testing.T, db *FileDb) *User {
+	u, err := db.AddUser("test", AccountTypeUser)
+	if err != nil {
+		t.Fatalf("AddUser(test) failed: %v", err)
+	}
+	return u
+}
+
 func TestAddFile(t *testing.T) {
 	db := getTestDb(t)
 	t.Cleanup(func() {
@@ -47,6 +55,7 @@ func TestAddFile(t *testing.T) {
 		id:         1,
 		tags:       make([]string, 0),
 		path:       "t1",
+		name:       "t1",
 		lastViewed: time.Unix(0, 0),
 		stars:      0,
 		size:       500,
@@ -59,6 +68,7 @@ func TestAddFile(t *testing.T) {
 		id:         1,
 		tags:       []string{},
 		path:       "t1",
+		name:       "t1",
 		lastViewed: time.Unix(0, 0),
 		stars:      0,
 		size:       500,
@@ -89,6 +99,7 @@ func TestAddFiles(t *testing.T) {
 		id:         1,
 		tags:       make([]string, 0),
 		path:       "t1",
+		name:       "t1",
 		lastViewed: time.Unix(0, 0),
 		stars:      0,
 		size:       500,
@@ -101,6 +112,7 @@ func TestAddFiles(t *testing.T) {
 		id:         1,
 		tags:       []string{},
 		path:       "t1",
+		name:       "t1",
 		lastViewed: time.Unix(0, 0),
 		stars:      0,
 		size:       500,
@@ -110,7 +122,9 @@ func TestAddFiles(t *testing.T) {
 func TestAddFileTag(t *testing.T) {
 	db := getTestDb(t)
 	defer db.Close()
+	u := getTestUser(t, db)
 	f := makeTestFile(t, "t1")
+	f.SetUser(u)
 	assert.NoErrorf(t, f.SetStars(3), "SetStars(3) failed")
 	if !assert.NoErrorf(t, f.AddTag("hello-world"), "Failed to add tag 'hello-world'") {
 		t.FailNow()
@@ -122,26 +136,33 @@ func TestAddFileTag(t *testing.T) {
 		id:         1,
 		tags:       []string{"hello-world"},
 		path:       "t1",
+		name:       "t1",
 		lastViewed: time.Unix(0, 0),
 		stars:      3,
+		user:       1,
 	}, f, "AddFile didn't add id or otherwise changed structure")
 	fl, err := db.GetFileById(f.id)
 	if err != nil {
 		t.Fatalf("GetFileById failed: %v", err)
 	}
+	assert.NoErrorf(t, db.LoadUserData(u, fl), "LoadUserData failed")
 	assert.Equal(t, &File{
 		id:         1,
 		tags:       []string{"hello-world"},
 		path:       "t1",
+		name:       "t1",
 		lastViewed: time.Unix(0, 0),
 		stars:      3,
+		user:       1,
 	}, fl, "AddFile didn't add id or otherwise changed structure")
 }
 
 func TestUpdateFile(t *testing.T) {
 	db := getTestDb(t)
 	defer db.Close()
+	u := getTestUser(t, db)
 	f := makeTestFile(t, "t1")
+	f.SetUser(u)
 	assert.NoErrorf(t, f.SetStars(2), "SetStars(2) failed")
 	assert.NoErrorf(t, f.AddTag("hello-world"), "AddTag('hello-world') failed")
 	if !assert.NoErrorf(t, db.AddFile(f), "AddFile failed") {
@@ -151,24 +172,30 @@ func TestUpdateFile(t *testing.T) {
 		id:         1,
 		tags:       []string{"hello-world"},
 		path:       "t1",
+		name:       "t1",
 		lastViewed: time.Unix(0, 0),
 		stars:      2,
+		user:       1,
 	}, f, "AddFile didn't add id or otherwise changed structure")
 	assert.NoErrorf(t, f.SetStars(4), "SetStars(4) failed")
 	assert.Equal(t, &File{
 		id:         1,
 		tags:       []string{"hello-world"},
 		path:       "t1",
+		name:       "t1",
 		lastViewed: time.Unix(0, 0),
 		stars:      4,
+		user:       1,
 	}, f, "SetStars didn't add id or otherwise changed structure")
 	assert.NoErrorf(t, f.AddTag("test"), "AddTag('test') failed")
 	assert.Equal(t, &File{
 		id:         1,
 		tags:       []string{"hello-world", "test"},
 		path:       "t1",
+		name:       "t1",
 		lastViewed: time.Unix(0, 0),
 		stars:      4,
+		user:       1,
 	}, f, "SetStars didn't add id or otherwise changed structure")
 	// Remove a tag
 	f.RemoveTag("hello-world")
@@ -182,6 +209,7 @@ func TestUpdateFile(t *testing.T) {
 	if err != nil {
 		t.Fatalf("GetFileById failed: %v", err)
 	}
+	assert.NoErrorf(t, db.LoadUserData(u, fl), "LoadUserData failed")
 	assert.Equal(t, f.lastViewed, fl.lastViewed, "Time wasn't equal")
 	assert.Equal(t, f, fl, "GetFileById didn't return the same file")
 }
@@ -189,7 +217,9 @@ func TestUpdateFile(t *testing.T) {
 func TestRemoveFile(t *testing.T) {
 	db := getTestDb(t)
 	defer db.Close()
+	u := getTestUser(t, db)
 	f := makeTestFile(t, "t1")
+	f.SetUser(u)
 	assert.NoErrorf(t, f.SetStars(3), "SetStars(3) failed")
 	if !assert.NoErrorf(t, db.AddFile(f), "AddFile failed") {
 		t.FailNow()
@@ -198,8 +228,10 @@ func TestRemoveFile(t *testing.T) {
 		id:         1,
 		tags:       []string{},
 		path:       "t1",
+		name:       "t1",
 		lastViewed: time.Unix(0, 0),
 		stars:      3,
+		user:       1,
 	}, f, "SetStars didn't add id or otherwise changed structure")
 	assert.NoErrorf(t, db.RemoveFile(f), "RemoveFile failed")
 	_, err := db.GetFileById(f.id)
@@ -211,15 +243,19 @@ func TestRemoveFile(t *testing.T) {
 func TestGetFileByPath(t *testing.T) {
 	db := getTestDb(t)
 	defer db.Close()
+	u := getTestUser(t, db)
 	f := makeTestFile(t, "t1")
+	f.SetUser(u)
 	assert.NoErrorf(t, f.SetStars(3), "SetStars(3) failed")
 	assert.NoErrorf(t, db.AddFile(f), "AddFile failed")
 	assert.Equal(t, &File{
 		id:         1,
 		tags:       []string{},
 		path:       "t1",
+		name:       "t1",
 		lastViewed: time.Unix(0, 0),
 		stars:      3,
+		user:       1,
 	}, f, "SetStars didn't add id or otherwise changed structure")
 	assert.NoErrorf(t, f.SetStars(4), "SetStars(4) failed")
 	assert.NoErrorf(t, f.AddTag("test"), "AddTag(test) failed")
@@ -229,21 +265,26 @@ func TestGetFileByPath(t *testing.T) {
 	if err != nil {
 		t.Fatalf("GetFileByPath failed: %v", err)
 	}
+	assert.NoErrorf(t, db.LoadUserData(u, fl), "LoadUserData failed")
 	assert.Equal(t, f, fl, "GetFileByPath didn't return correct file")
 }
 
 func TestGetFileById(t *testing.T) {
 	db := getTestDb(t)
 	defer db.Close()
+	u := getTestUser(t, db)
 	f := makeTestFile(t, "t1")
+	f.SetUser(u)
 	assert.NoErrorf(t, f.SetStars(3), "SetStars(3) failed")
 	assert.NoErrorf(t, db.AddFile(f), "AddFile failed")
 	assert.Equal(t, &File{
 		id:         1,
 		tags:       []string{},
 		path:       "t1",
+		name:       "t1",
 		lastViewed: time.Unix(0, 0),
 		stars:      3,
+		user:       1,
 	}, f, "SetStars didn't add id or otherwise changed structure")
 	assert.NoErrorf(t, f.SetStars(4), "SetStars(4) failed")
 	assert.NoErrorf(t, f.AddTag("test"), "AddTag(test) failed")
@@ -253,13 +294,16 @@ func TestGetFileById(t *testing.T) {
 	if err != nil {
 		t.Fatalf("GetFileById failed: %v", err)
 	}
+	assert.NoErrorf(t, db.LoadUserData(u, fl), "LoadUserData failed")
 	assert.Equal(t, f, fl, "GetFileById didn't return correct file")
 }
 
 func TestSearchFile(t *testing.T) {
 	db := getTestDb(t)
 	defer db.Close()
+	u := getTestUser(t, db)
 	f1 := makeTestFile(t, "test/t1")
+	f1.SetUser(u)
 	f1.SetSize(200)
 	f1.SetStars(5)
 	if !assert.NoErrorf(t, f1.AddTag("test"), "t1.AddTag(test) failed") {
@@ -271,6 +315,7 @@ func TestSearchFile(t *testing.T) {
 		return
 	}
 	f2 := makeTestFile(t, "test/t2")
+	f2.SetUser(u)
 	f2.SetSize(100)
 	f2.SetStars(1)
 	if !assert.NoErrorf(t, f2.AddTag("not_test"), "t2.AddTag(not_test) failed") {
@@ -282,6 +327,7 @@ func TestSearchFile(t *testing.T) {
 		return
 	}
 	f3 := makeTestFile(t, "test/t3")
+	f3.SetUser(u)
 	f3.SetSize(400)
 	f3.SetStars(4)
 	if !assert.NoErrorf(t, f3.AddTag("test"), "t3.AddTag(test) failed") {
@@ -298,7 +344,8 @@ func TestSearchFile(t *testing.T) {
 	// Get file 1
 	t.Run("GetFileByPartialPath", func(t *testing.T) {
 		fs, err := db.SearchFile(&SearchQuery{
-			Path: "t1",
+			UserId: u.GetId(),
+			Path:   "t1",
 		})
 		if err != nil {
 			t.Errorf("SearchFile failed: %v", err)
@@ -310,7 +357,8 @@ func TestSearchFile(t *testing.T) {
 	})
 	t.Run("GetFileByFullPath", func(t *testing.T) {
 		fs, err := db.SearchFile(&SearchQuery{
-			Path: "test/t1",
+			UserId: u.GetId(),
+			Path:   "test/t1",
 		})
 		if err != nil {
 			t.Errorf("SearchFile failed: %v", err)
@@ -323,6 +371,7 @@ func TestSearchFile(t *testing.T) {
 	// Get file 1 & 3 by tag
 	t.Run("GetFileByWhitelistTag", func(t *testing.T) {
 		fs, err := db.SearchFile(&SearchQuery{
+			UserId:        u.GetId(),
 			WhitelistTags: []string{"test"},
 		})
 		if err != nil {
@@ -335,6 +384,7 @@ func TestSearchFile(t *testing.T) {
 	})
 	t.Run("GetFileByBlacklistTag", func(t *testing.T) {
 		fs, err := db.SearchFile(&SearchQuery{
+			UserId:        u.GetId(),
 			BlacklistTags: []string{"not_test"},
 		})
 		if err != nil {
@@ -348,6 +398,7 @@ func TestSearchFile(t *testing.T) {
 	// Get file 1 by tag
 	t.Run("GetFileByWhitelistAndBlacklistTag", func(t *testing.T) {
 		fs, err := db.SearchFile(&SearchQuery{
+			UserId:        u.GetId(),
 			WhitelistTags: []string{"test"},
 			BlacklistTags: []string{"not_test"},
 		})
@@ -362,6 +413,7 @@ func TestSearchFile(t *testing.T) {
 	// Get nothing
 	t.Run("GetNothing", func(t *testing.T) {
 		fs, err := db.SearchFile(&SearchQuery{
+			UserId:        u.GetId(),
 			Path:          "test5",
 			WhitelistTags: []string{"test"},
 		})
@@ -373,7 +425,7 @@ func TestSearchFile(t *testing.T) {
 	})
 	// Get everything
 	t.Run("GetEverything", func(t *testing.T) {
-		fs, err := db.SearchFile(&SearchQuery{})
+		fs, err := db.SearchFile(&SearchQuery{UserId: u.GetId()})
 		if err != nil {
 			t.Errorf("SearchFile failed: %v", err)
 		} else {
@@ -387,6 +439,7 @@ func TestSearchFile(t *testing.T) {
 	// Get test 2
 	t.Run("GetByPathAndTags", func(t *testing.T) {
 		fs, err := db.SearchFile(&SearchQuery{
+			UserId:        u.GetId(),
 			Path:          "t",
 			WhitelistTags: []string{"not_test"},
 			BlacklistTags: []string{"test"},
@@ -401,6 +454,7 @@ func TestSearchFile(t *testing.T) {
 	})
 	t.Run("SearchRegex", func(t *testing.T) {
 		fs, err := db.SearchFile(&SearchQuery{
+			UserId: u.GetId(),
 			PathRe: "test/t[13]",
 		})
 		if err != nil {
@@ -414,6 +468,7 @@ func TestSearchFile(t *testing.T) {
 	})
 	t.Run("SortBySize", func(t *testing.T) {
 		fs, err := db.SearchFile(&SearchQuery{
+			UserId: u.GetId(),
 			SortBy: SortMethodSize,
 		})
 		if err != nil {
@@ -428,6 +483,7 @@ func TestSearchFile(t *testing.T) {
 	})
 	t.Run("SortBySizeRev", func(t *testing.T) {
 		fs, err := db.SearchFile(&SearchQuery{
+			UserId:      u.GetId(),
 			SortBy:      SortMethodSize,
 			SortReverse: true,
 		})
@@ -447,6 +503,7 @@ func TestSearchFile(t *testing.T) {
 	// File3: 4
 	t.Run("SortByStars", func(t *testing.T) {
 		fs, err := db.SearchFile(&SearchQuery{
+			UserId: u.GetId(),
 			SortBy: SortMethodStars,
 		})
 		if err != nil {
@@ -461,6 +518,7 @@ func TestSearchFile(t *testing.T) {
 	})
 	t.Run("SortByStarsRev", func(t *testing.T) {
 		fs, err := db.SearchFile(&SearchQuery{
+			UserId:      u.GetId(),
 			SortBy:      SortMethodStars,
 			SortReverse: true,
 		})
@@ -504,6 +562,7 @@ func TestSearchCountIndex(t *testing.T) {
 			id:         i + 26,
 			tags:       []string{"test"},
 			path:       fmt.Sprintf("%d", i+25),
+			name:       fmt.Sprintf("%d", i+25),
 			stars:      0,
 			size:       0,
 			lastViewed: time.Unix(0, 0),
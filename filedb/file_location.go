@@ -0,0 +1,301 @@
+package filedb
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"time"
+)
+
+// Another path a file was found at with the same hash. The files own path is the canonical copy, nothing on disk is changed.
+type FileLocation struct {
+	id     int
+	fileId int
+	path   string
+	added  time.Time
+}
+
+func (l *FileLocation) GetId() int {
+	return l.id
+}
+
+// Id of the file this is a copy of
+func (l *FileLocation) GetFileId() int {
+	return l.fileId
+}
+
+func (l *FileLocation) GetPath() string {
+	return l.path
+}
+
+// Time the copy was found
+func (l *FileLocation) GetAdded() time.Time {
+	return l.added
+}
+
+// The error of a ImportError when the file has the same hash as a file in the database, its path is recorded as a location of that file instead
+type DuplicateError struct {
+	FileId int    // Id of the file with the same hash
+	Path   string // Path of the file with the same hash
+}
+
+func (e *DuplicateError) Error() string {
+	return fmt.Sprintf("duplicate of '%s' (%d), recorded as another location of it", e.Path, e.FileId)
+}
+
+// A file & the other paths it was found at
+type DuplicateGroup struct {
+	File      *File
+	Locations []*FileLocation
+}
+
+// Bytes used by the other locations, 0 if the size of the file isn't known
+func (g *DuplicateGroup) GetWasted() int64 {
+	return g.File.size * int64(len(g.Locations))
+}
+
+// Columns read by sqlRowsToFileLocations, the file_location table must be 'l'
+const fileLocationColumns = "l.id, l.fileId, l.path, l.added"
+
+// Don't call .Next before calling this function or you will lose a location.
+//
+// The rows must be selected with fileLocationColumns
+func (d *FileDb) sqlRowsToFileLocations(r *sql.Rows) []*FileLocation {
+	locations := make([]*FileLocation, 0)
+	for r.Next() {
+		l := &FileLocation{}
+		added := int64(0)
+		err := r.Scan(&l.id, &l.fileId, &l.path, &added)
+		if err != nil {
+			// This can only happen if the database structure has changed.
+			slog.Error("Failed to scan from FileLocation rows", "Error", err.Error())
+			panic(fmt.Sprintf("MediaManager: sqlRowsToFileLocations: Scanning from file_location rows failed: %v", err))
+		}
+		l.added = time.Unix(added, 0)
+		locations = append(locations, l)
+	}
+	return locations
+}
+
+// Record f as another location of the file with the same hash. Returns a *DuplicateError if it was, or nil if f isn't a duplicate & should be inserted.
+func recordDuplicate(tx *sql.Tx, f *File) (*DuplicateError, error) {
+	dup := &DuplicateError{}
+	slog.Debug("Executing SELECT", "Query", "SELECT id, path FROM file WHERE hash=?", "QueryArgs", []any{f.hash})
+	err := tx.QueryRow("SELECT id, path FROM file WHERE hash=?", f.hash).Scan(&dup.FileId, &dup.Path)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	} else if err != nil {
+		slog.Error("Failed to get file by hash", "Error", err.Error(), "Hash", f.hash)
+		panic(fmt.Sprintf("MediaManager: recordDuplicate: Failed to get file by hash: %v", err))
+	}
+	if dup.Path == f.path {
+		// Already imported, inserting fails like it always has
+		return nil, nil
+	}
+	pathUsed := 0
+	slog.Debug("Executing SELECT", "Query", "SELECT COUNT(*) FROM file WHERE path=?", "QueryArgs", []any{f.path})
+	err = tx.QueryRow("SELECT COUNT(*) FROM file WHERE path=?", f.path).Scan(&pathUsed)
+	if err != nil {
+		slog.Error("Failed to count files by path", "Error", err.Error(), "Path", f.path)
+		panic(fmt.Sprintf("MediaManager: recordDuplicate: Failed to count files by path: %v", err))
+	}
+	if pathUsed != 0 {
+		// A different file with this path, it was changed on disk
+		return nil, nil
+	}
+	if err = insertLocation(tx, dup.FileId, f.path); err != nil {
+		return nil, err
+	}
+	return dup, nil
+}
+
+// Record the path of a file that changed on disk as another location of a different file with its new hash. Returns a *DuplicateError
+// if there was one, the file itself should be left unchanged. Returns nil if no other file has the hash & the file should be updated.
+func recordChangedDuplicate(tx *sql.Tx, f *File) (*DuplicateError, error) {
+	dup := &DuplicateError{}
+	slog.Debug("Executing SELECT", "Query", "SELECT id, path FROM file WHERE hash=? AND id!=?", "QueryArgs", []any{f.hash, f.id})
+	err := tx.QueryRow("SELECT id, path FROM file WHERE hash=? AND id!=?", f.hash, f.id).Scan(&dup.FileId, &dup.Path)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	} else if err != nil {
+		slog.Error("Failed to get file by hash", "Error", err.Error(), "Hash", f.hash)
+		panic(fmt.Sprintf("MediaManager: recordChangedDuplicate: Failed to get file by hash: %v", err))
+	}
+	if err = insertLocation(tx, dup.FileId, f.path); err != nil {
+		return nil, err
+	}
+	return dup, nil
+}
+
+// Record path as another location of a file, a path that was a copy of another file is moved to this one
+func insertLocation(tx *sql.Tx, fileId int, path string) error {
+	const query = "INSERT INTO file_location(fileId, path, added) VALUES (?, ?, ?) ON CONFLICT(path) DO UPDATE SET fileId=excluded.fileId"
+	queryArgs := []any{fileId, path, time.Now().Unix()}
+	slog.Info("Executing INSERT", "Query", query, "QueryArgs", queryArgs)
+	_, err := tx.Exec(query, queryArgs...)
+	if err != nil {
+		slog.Warn("Failed to insert into file_location", "Query", query, "QueryArgs", queryArgs, "Error", err.Error())
+		return fmt.Errorf("failed to insert into file_location table: %v", err)
+	}
+	return nil
+}
+
+// Get the other locations of a file, ordered by path
+func (d *FileDb) GetFileLocations(f *File) ([]*FileLocation, error) {
+	if d.safeMode {
+		return nil, ErrOutdatedDatabase
+	}
+	if err := isValidFile(f); err != nil {
+		return nil, err
+	}
+	const query = "SELECT " + fileLocationColumns + " FROM file_location l WHERE l.fileId=? ORDER BY l.path"
+	slog.Debug("Executing SELECT", "Query", query, "QueryArgs", []any{f.id})
+	rows, err := d.db.Query(query, f.id)
+	if err != nil {
+		slog.Error("Failed to execute select query", "Query", query, "QueryArgs", []any{f.id}, "Error", err.Error())
+		panic(fmt.Sprintf("MediaManager: GetFileLocations query failed: %v", err))
+	}
+	defer rows.Close()
+	return d.sqlRowsToFileLocations(rows), nil
+}
+
+// Get the path of every location, so copies aren't read again when importing
+func (d *FileDb) GetLocationPaths() (map[string]bool, error) {
+	if d.safeMode {
+		return nil, ErrOutdatedDatabase
+	}
+	slog.Debug("Executing SELECT", "Query", "SELECT path FROM file_location")
+	rows, err := d.db.Query("SELECT path FROM file_location")
+	if err != nil {
+		slog.Error("Failed to execute select query", "Query", "SELECT path FROM file_location", "Error", err.Error())
+		panic(fmt.Sprintf("MediaManager: GetLocationPaths query failed: %v", err))
+	}
+	defer rows.Close()
+	paths := make(map[string]bool)
+	for rows.Next() {
+		var p string
+		if err = rows.Scan(&p); err != nil {
+			slog.Error("Failed to scan location path", "Error", err.Error())
+			panic(fmt.Sprintf("MediaManager: GetLocationPaths: Failed to scan location path, did the structure change?: %v", err))
+		}
+		paths[p] = true
+	}
+	return paths, nil
+}
+
+// Get every file that was found at more then one path, the most wasted space first
+func (d *FileDb) GetDuplicates() ([]*DuplicateGroup, error) {
+	if d.safeMode {
+		return nil, ErrOutdatedDatabase
+	}
+	const fileQuery = "SELECT " + fileColumns + " FROM file f" + fileUserJoin + " WHERE f.id IN (SELECT fileId FROM file_location)"
+	slog.Debug("Executing SELECT", "Query", fileQuery, "QueryArgs", []any{0})
+	rows, err := d.db.Query(fileQuery, 0)
+	if err != nil {
+		slog.Error("Failed to execute select query", "Query", fileQuery, "QueryArgs", []any{0}, "Error", err.Error())
+		panic(fmt.Sprintf("MediaManager: GetDuplicates query failed: %v", err))
+	}
+	files := d.sqlRowsToFiles(rows, 0)
+	rows.Close()
+	const query = "SELECT " + fileLocationColumns + " FROM file_location l ORDER BY l.path"
+	slog.Debug("Executing SELECT", "Query", query)
+	rows, err = d.db.Query(query)
+	if err != nil {
+		slog.Error("Failed to execute select query", "Query", query, "Error", err.Error())
+		panic(fmt.Sprintf("MediaManager: GetDuplicates query failed: %v", err))
+	}
+	defer rows.Close()
+	byId := make(map[int]*DuplicateGroup, len(files))
+	groups := make([]*DuplicateGroup, len(files))
+	for i, f := range files {
+		groups[i] = &DuplicateGroup{File: f, Locations: make([]*FileLocation, 0)}
+		byId[f.id] = groups[i]
+	}
+	for _, l := range d.sqlRowsToFileLocations(rows) {
+		if g, found := byId[l.fileId]; found {
+			g.Locations = append(g.Locations, l)
+		}
+	}
+	sort.SliceStable(groups, func(i, j int) bool {
+		if groups[i].GetWasted() != groups[j].GetWasted() {
+			return groups[i].GetWasted() > groups[j].GetWasted()
+		}
+		return groups[i].File.path < groups[j].File.path
+	})
+	return groups, nil
+}
+
+// Make a location the canonical copy of its file, the files path becomes a location instead. Files named after their path are renamed.
+//
+// Returns the updated file.
+func (d *FileDb) SetCanonicalLocation(locationId int) (*File, error) {
+	if d.safeMode {
+		return nil, ErrOutdatedDatabase
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	tx, err := d.db.Begin()
+	if err != nil {
+		slog.Error("Failed to create new transaction for SetCanonicalLocation", "Error", err.Error())
+		return nil, err
+	}
+	defer tx.Rollback()
+	fileId := 0
+	var locationPath, filePath, name string
+	slog.Debug("Executing SELECT", "Query", "SELECT l.fileId, l.path, f.path, f.name FROM file_location l JOIN file f ON f.id = l.fileId WHERE l.id=?", "QueryArgs", []any{locationId})
+	err = tx.QueryRow("SELECT l.fileId, l.path, f.path, f.name FROM file_location l JOIN file f ON f.id = l.fileId WHERE l.id=?", locationId).Scan(&fileId, &locationPath, &filePath, &name)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errors.New("location not found")
+	} else if err != nil {
+		slog.Error("Failed to get file location", "Error", err.Error(), "Id", locationId)
+		panic(fmt.Sprintf("MediaManager: SetCanonicalLocation: Failed to get file location: %v", err))
+	}
+	if name == defaultFileName(filePath) {
+		name = defaultFileName(locationPath)
+	}
+	slog.Info("Executing UPDATE", "Query", "UPDATE file SET path=?, name=? WHERE id=?", "QueryArgs", []any{locationPath, name, fileId})
+	_, err = tx.Exec("UPDATE file SET path=?, name=? WHERE id=?", locationPath, name, fileId)
+	if err != nil {
+		// This would fail if another file now has the path
+		slog.Warn("Failed to update file", "Query", "UPDATE file SET path=?, name=? WHERE id=?", "QueryArgs", []any{locationPath, name, fileId}, "Error", err.Error())
+		return nil, fmt.Errorf("failed to update file: %v", err)
+	}
+	slog.Info("Executing UPDATE", "Query", "UPDATE file_location SET path=? WHERE id=?", "QueryArgs", []any{filePath, locationId})
+	_, err = tx.Exec("UPDATE file_location SET path=? WHERE id=?", filePath, locationId)
+	if err != nil {
+		slog.Warn("Failed to update file_location", "Query", "UPDATE file_location SET path=? WHERE id=?", "QueryArgs", []any{filePath, locationId}, "Error", err.Error())
+		return nil, fmt.Errorf("failed to update file_location table: %v", err)
+	}
+	err = tx.Commit()
+	if err != nil {
+		slog.Warn("Failed to commit SetCanonicalLocation", "Error", err.Error())
+		return nil, fmt.Errorf("transaction failed to commit: %v", err)
+	}
+	return d.GetFileById(fileId)
+}
+
+// Forget a location, for example after the copy was deleted
+func (d *FileDb) RemoveFileLocation(locationId int) error {
+	if d.safeMode {
+		return ErrOutdatedDatabase
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	slog.Info("Executing DELETE", "Query", "DELETE FROM file_location WHERE id=?", "QueryArgs", []any{locationId})
+	res, err := d.db.Exec("DELETE FROM file_location WHERE id=?", locationId)
+	if err != nil {
+		slog.Warn("Failed to delete from file_location", "Query", "DELETE FROM file_location WHERE id=?", "QueryArgs", []any{locationId}, "Error", err.Error())
+		return fmt.Errorf("failed to delete from file_location table: %v", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		slog.Error("Failed to get rowsAffected", "Error", err.Error(), "Query", "DELETE FROM file_location WHERE id=?")
+		panic(fmt.Sprintf("MediaManager: RemoveFileLocation: .RowsAffected failed, is the database correct?: %v", err))
+	}
+	if n == 0 {
+		return errors.New("location not found")
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package filedb
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// A file with a fake hash & size
+func makeTestHashedFile(t *testing.T, path string, hash byte, size int64) *File {
+	f := makeTestFile(t, path)
+	f.hash = strings.Repeat(string("0123456789abcdef"[hash%16]), 64)
+	f.size = size
+	return f
+}
+
+func TestFileLocation(t *testing.T) {
+	db := getTestDb(t)
+	defer db.Close()
+	a := makeTestHashedFile(t, "/a/movie.mkv", 1, 1000)
+	b := makeTestHashedFile(t, "/b/song.mp3", 2, 10)
+	failed, err := db.AddFiles(a, b)
+	if !assert.NoErrorf(t, err, "AddFiles failed") || !assert.Emptyf(t, failed, "Files failed to import") {
+		return
+	}
+	// Copies are recorded instead of failing
+	copies := []*File{
+		makeTestHashedFile(t, "/backup/movie.mkv", 1, 1000),
+		makeTestHashedFile(t, "/old/movie (1).mkv", 1, 1000),
+		makeTestHashedFile(t, "/backup/song.mp3", 2, 10),
+		makeTestHashedFile(t, "/a/movie.mkv", 1, 1000),
+	}
+	failed, err = db.AddFiles(copies...)
+	if !assert.NoErrorf(t, err, "AddFiles failed") || !assert.Lenf(t, failed, 4, "Copies were imported") {
+		return
+	}
+	for i, v := range failed[:3] {
+		var dup *DuplicateError
+		if assert.Truef(t, errors.As(v.Error, &dup), "Copy %d wasn't a duplicate: %v", i, v.Error) {
+			assert.Equalf(t, copies[i].hash, map[int]string{a.id: a.hash, b.id: b.hash}[dup.FileId], "Copy %d has the wrong original", i)
+		}
+	}
+	var dup *DuplicateError
+	assert.Falsef(t, errors.As(failed[3].Error, &dup), "Reimporting a file made it a copy of itself")
+	// Importing again doesn't add more
+	failed, _ = db.AddFiles(makeTestHashedFile(t, "/backup/movie.mkv", 1, 1000))
+	assert.Lenf(t, failed, 1, "Copy was imported")
+	groups, err := db.GetDuplicates()
+	if assert.NoErrorf(t, err, "GetDuplicates failed") && assert.Len(t, groups, 2) {
+		assert.Equalf(t, a.id, groups[0].File.GetId(), "Most wasted space wasn't first")
+		assert.Equalf(t, int64(2000), groups[0].GetWasted(), "Wrong wasted space")
+		paths := make([]string, 0)
+		for _, l := range groups[0].Locations {
+			paths = append(paths, l.GetPath())
+			assert.Equalf(t, a.id, l.GetFileId(), "Location has the wrong file")
+		}
+		assert.Equalf(t, []string{"/backup/movie.mkv", "/old/movie (1).mkv"}, paths, "Wrong locations")
+		assert.Equalf(t, int64(10), groups[1].GetWasted(), "Wrong wasted space")
+	}
+	// Swapping the canonical copy
+	locations, err := db.GetFileLocations(a)
+	if !assert.NoErrorf(t, err, "GetFileLocations failed") || !assert.Len(t, locations, 2) {
+		return
+	}
+	f, err := db.SetCanonicalLocation(locations[0].GetId())
+	if assert.NoErrorf(t, err, "SetCanonicalLocation failed") {
+		assert.Equalf(t, "/backup/movie.mkv", f.GetPath(), "Path wasn't changed")
+		assert.Equalf(t, "movie.mkv", f.GetName(), "Name wasn't changed")
+		assert.Equalf(t, a.id, f.GetId(), "File id changed")
+	}
+	locations, _ = db.GetFileLocations(a)
+	assert.Equalf(t, "/a/movie.mkv", locations[0].GetPath(), "Old path isn't a location")
+	paths, err := db.GetLocationPaths()
+	if assert.NoErrorf(t, err, "GetLocationPaths failed") {
+		assert.Equalf(t, map[string]bool{"/a/movie.mkv": true, "/old/movie (1).mkv": true, "/backup/song.mp3": true}, paths, "Wrong location paths")
+	}
+	_, err = db.SetCanonicalLocation(1000)
+	assert.Errorf(t, err, "Missing location was used")
+	// Forgetting locations
+	assert.NoErrorf(t, db.RemoveFileLocation(locations[1].GetId()), "RemoveFileLocation failed")
+	assert.Errorf(t, db.RemoveFileLocation(locations[1].GetId()), "Location was removed twice")
+	// A copy that changed on disk is imported as a new file
+	changed := makeTestHashedFile(t, "/a/movie.mkv", 3, 5)
+	failed, err = db.AddFiles(changed)
+	if assert.NoErrorf(t, err, "AddFiles failed") && assert.Emptyf(t, failed, "Changed copy wasn't imported") {
+		locations, _ = db.GetFileLocations(a)
+		assert.Emptyf(t, locations, "Imported path is still a location")
+	}
+	assert.NoErrorf(t, db.RemoveFile(b), "RemoveFile failed")
+	groups, _ = db.GetDuplicates()
+	assert.Emptyf(t, groups, "Locations of removed files weren't removed")
+}
+
+func TestUpdateFileDuplicate(t *testing.T) {
+	db := getTestDb(t)
+	defer db.Close()
+	a := makeTestHashedFile(t, "/a/movie.mkv", 1, 1000)
+	b := makeTestHashedFile(t, "/b/movie.mkv", 2, 500)
+	failed, err := db.AddFiles(a, b)
+	if !assert.NoErrorf(t, err, "AddFiles failed") || !assert.Emptyf(t, failed, "Files failed to import") {
+		return
+	}
+	// b was replaced on disk with a copy of a
+	changed := makeTestHashedFile(t, "/b/movie.mkv", 1, 1000)
+	changed.id = b.id
+	var dup *DuplicateError
+	if assert.Truef(t, errors.As(db.UpdateFile(changed), &dup), "Update to a hash in use wasn't a duplicate") {
+		assert.Equalf(t, a.id, dup.FileId, "Wrong original")
+		assert.Equalf(t, "/a/movie.mkv", dup.Path, "Wrong original path")
+	}
+	locations, err := db.GetFileLocations(a)
+	if assert.NoErrorf(t, err, "GetFileLocations failed") && assert.Lenf(t, locations, 1, "Path wasn't recorded") {
+		assert.Equalf(t, "/b/movie.mkv", locations[0].GetPath(), "Wrong location")
+	}
+	old, err := db.GetFileById(b.id)
+	if assert.NoErrorf(t, err, "GetFileById failed") {
+		assert.Equalf(t, b.hash, old.GetHash(), "File was updated")
+	}
+	// Updating a file with its own hash still works
+	assert.NoErrorf(t, db.UpdateFile(a), "UpdateFile failed")
+}
@@ -0,0 +1,115 @@
+package filedb
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"mediamanager/metadata"
+	"strings"
+	"time"
+)
+
+// Columns of the file_meta table, in the order putFileMeta & addFileMeta use them
+const fileMetaColumns = "title, artist, album, track, camera, taken, width, height, duration, latitude, longitude"
+
+// A value, or nil if it's the zero value so it's stored as NULL
+func nullIfZero[T comparable](v T) any {
+	var zero T
+	if v == zero {
+		return nil
+	}
+	return v
+}
+
+// Checks if two files metadata is the same, nil & empty metadata are the same
+func metaEqual(a, b *metadata.Metadata) bool {
+	if a == nil || b == nil {
+		return (a == nil || a.IsEmpty()) && (b == nil || b.IsEmpty())
+	}
+	return *a == *b
+}
+
+// Set the embedded metadata of a file, a nil or empty m removes it
+func putFileMeta(tx *sql.Tx, fileId int, m *metadata.Metadata) error {
+	if m == nil || m.IsEmpty() {
+		slog.Info("Executing DELETE", "Query", "DELETE FROM file_meta WHERE fileId=?", "QueryArgs", []any{fileId})
+		_, err := tx.Exec("DELETE FROM file_meta WHERE fileId=?", fileId)
+		if err != nil {
+			slog.Warn("Failed to delete from file_meta", "Query", "DELETE FROM file_meta WHERE fileId=?", "QueryArgs", []any{fileId}, "Error", err.Error())
+			return fmt.Errorf("failed to delete from file_meta table: %v", err)
+		}
+		return nil
+	}
+	var taken, latitude, longitude any
+	if !m.Taken.IsZero() {
+		taken = m.Taken.Unix()
+	}
+	if m.HasLocation {
+		latitude, longitude = m.Latitude, m.Longitude
+	}
+	args := []any{fileId, nullIfZero(m.Title), nullIfZero(m.Artist), nullIfZero(m.Album), nullIfZero(m.Track), nullIfZero(m.Camera), taken, nullIfZero(m.Width), nullIfZero(m.Height), nullIfZero(m.Duration), latitude, longitude}
+	query := "INSERT OR REPLACE INTO file_meta(fileId, " + fileMetaColumns + ") VALUES (?" + strings.Repeat(", ?", len(args)-1) + ")"
+	slog.Info("Executing INSERT", "Query", query, "QueryArgs", args)
+	_, err := tx.Exec(query, args...)
+	if err != nil {
+		// This would fail if the file doesn't exist.
+		slog.Warn("Failed to insert into file_meta", "Query", query, "QueryArgs", args, "Error", err.Error())
+		return fmt.Errorf("failed to insert into file_meta table: %v", err)
+	}
+	return nil
+}
+
+// Add embedded metadata to files in batches, files without any are left with none
+func (d *FileDb) addFileMeta(files []*File) {
+	byId := make(map[int]*File, len(files))
+	for _, f := range files {
+		byId[f.id] = f
+	}
+	// Split like addFileTags to stay under the argument limit
+	for i := 0; i < len(files); i += 500 {
+		batch := files[i:min(i+500, len(files))]
+		query := "SELECT fileId, " + fileMetaColumns + " FROM file_meta WHERE fileId IN (?" + strings.Repeat(", ?", len(batch)-1) + ")"
+		queryArgs := make([]any, len(batch))
+		for j, f := range batch {
+			queryArgs[j] = f.id
+		}
+		slog.Debug("Executing SELECT", "Query", query, "QueryArgs", queryArgs)
+		rows, err := d.db.Query(query, queryArgs...)
+		if err != nil {
+			slog.Error("Failed to get file metadata", "Error", err.Error(), "Query", query, "QueryArgs", queryArgs)
+			panic(fmt.Sprintf("MediaManager: addFileMeta: Failed to get file metadata: %v", err))
+		}
+		for rows.Next() {
+			fileId := 0
+			var title, artist, album, camera sql.NullString
+			var track, taken, width, height sql.NullInt64
+			var duration, latitude, longitude sql.NullFloat64
+			err = rows.Scan(&fileId, &title, &artist, &album, &track, &camera, &taken, &width, &height, &duration, &latitude, &longitude)
+			if err != nil {
+				rows.Close()
+				slog.Error("Failed to scan file_meta", "Error", err.Error(), "Query", query, "QueryArgs", queryArgs)
+				panic(fmt.Sprintf("MediaManager: addFileMeta: Failed to scan file_meta, did the structure change?: %v", err))
+			}
+			m := &metadata.Metadata{
+				Title:       title.String,
+				Artist:      artist.String,
+				Album:       album.String,
+				Track:       int(track.Int64),
+				Camera:      camera.String,
+				Width:       int(width.Int64),
+				Height:      int(height.Int64),
+				Duration:    duration.Float64,
+				Latitude:    latitude.Float64,
+				Longitude:   longitude.Float64,
+				HasLocation: latitude.Valid && longitude.Valid,
+			}
+			if taken.Valid {
+				m.Taken = time.Unix(taken.Int64, 0)
+			}
+			if f, found := byId[fileId]; found {
+				f.meta = m
+			}
+		}
+		rows.Close()
+	}
+}
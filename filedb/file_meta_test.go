@@ -0,0 +1,133 @@
+package filedb
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"mediamanager/metadata"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileMeta(t *testing.T) {
+	db := getTestDb(t)
+	defer db.Close()
+	taken := time.Date(2024, 5, 6, 7, 8, 9, 0, time.Local)
+	photo := makeTestFile(t, "/photos/beach.jpg")
+	photo.SetMeta(&metadata.Metadata{Camera: "Canon EOS 5D", Taken: taken, Width: 4000, Height: 3000, Latitude: 51.5, Longitude: -0.125, HasLocation: true})
+	song := makeTestFile(t, "/music/song.mp3")
+	song.SetMeta(&metadata.Metadata{Title: "Blue Song", Artist: "Someone", Album: "Album", Track: 2, Duration: 200})
+	song2 := makeTestFile(t, "/music/song2.mp3")
+	song2.SetMeta(&metadata.Metadata{Title: "another song", Artist: "Someone", Album: "Album", Track: 1, Duration: 95})
+	video := makeTestFile(t, "/tv/episode.mp4")
+	video.SetMeta(&metadata.Metadata{Width: 1920, Height: 1080, Duration: 1500})
+	plain := makeTestFile(t, "/docs/plain.txt")
+	files := []*File{photo, song, song2, video, plain}
+	if _, err := db.AddFiles(files...); !assert.NoErrorf(t, err, "AddFiles failed") {
+		return
+	}
+	f, err := db.GetFileById(photo.GetId())
+	if assert.NoErrorf(t, err, "GetFileById failed") {
+		assert.Equalf(t, photo.GetMeta(), f.GetMeta(), "Metadata wasn't stored")
+	}
+	f, err = db.GetFileById(plain.GetId())
+	if assert.NoErrorf(t, err, "GetFileById failed") {
+		assert.Nilf(t, f.GetMeta(), "File without metadata had some")
+	}
+	ids := func(fs ...*File) []int {
+		r := make([]int, len(fs))
+		for i, v := range fs {
+			r[i] = v.GetId()
+		}
+		return r
+	}
+	search := func(q *SearchQuery) []int {
+		fs, err := db.SearchFile(q)
+		assert.NoErrorf(t, err, "SearchFile(%+v) failed", *q)
+		return ids(fs...)
+	}
+	for query, expected := range map[string][]int{
+		`title:song`:                          ids(song, song2),
+		`artist:someone -title:blue`:          ids(song2),
+		`album:Album track:2`:                 ids(song),
+		`camera:canon`:                        ids(photo),
+		`title:none`:                          ids(photo, video, plain),
+		`camera:None -artist:none`:            ids(song, song2),
+		`-album:none`:                         ids(song, song2),
+		`width>=1920`:                         ids(photo, video),
+		`height<1080`:                         ids(),
+		`duration>3m`:                         ids(song, video),
+		`duration<=95`:                        ids(song2),
+		`duration>=1h`:                        ids(),
+		`taken:2024-05-06`:                    ids(photo),
+		`taken<2024-01-01`:                    ids(),
+		`taken:none`:                          ids(song, song2, video, plain),
+		`taken<100y -taken:none`:              ids(photo),
+		`location:any`:                        ids(photo),
+		`location:none`:                       ids(song, song2, video, plain),
+		`title:song OR camera:"canon eos"`:    ids(photo, song, song2),
+		`-title:song path:/music/ OR width:0`: ids(),
+		`-width>100`:                          ids(song, song2, plain),
+	} {
+		assert.ElementsMatchf(t, expected, search(&SearchQuery{Query: query}), "Wrong files for '%s'", query)
+	}
+	for _, v := range []string{"duration>ten", "track:one", "location:here", "taken<never", "title<a"} {
+		_, err = ParseQuery(v)
+		assert.Errorf(t, err, "Invalid query '%s' was parsed", v)
+	}
+	// Files without the value are last in either direction
+	for _, v := range []struct {
+		Sort     SortMethod
+		Reverse  bool
+		Expected []int // Files with the value
+	}{
+		{SortMethodTitle, false, ids(song2, song)},
+		{SortMethodTitle, true, ids(song, song2)},
+		{SortMethodArtist, false, ids(song2, song)},
+		{SortMethodAlbum, true, ids(song, song2)},
+		{SortMethodDuration, true, ids(video, song, song2)},
+		{SortMethodTaken, false, ids(photo)},
+		{SortMethodResolution, false, ids(video, photo)},
+		{SortMethodResolution, true, ids(photo, video)},
+	} {
+		fs := search(&SearchQuery{SortBy: v.Sort, SortReverse: v.Reverse})
+		if assert.Len(t, fs, len(files)) {
+			assert.Equalf(t, v.Expected, fs[:len(v.Expected)], "Wrong order for %s (Reverse %v)", v.Sort, v.Reverse)
+		}
+	}
+	// Updating replaces or removes the metadata
+	song.SetMeta(&metadata.Metadata{Title: "Renamed"})
+	assert.NoErrorf(t, db.UpdateFile(song), "UpdateFile failed")
+	video.SetMeta(nil)
+	assert.NoErrorf(t, db.UpdateFile(video), "UpdateFile failed")
+	assert.ElementsMatchf(t, ids(song), search(&SearchQuery{Query: "title:renamed -artist:someone"}), "Metadata wasn't updated")
+	assert.ElementsMatchf(t, ids(), search(&SearchQuery{Query: "width:1920"}), "Metadata wasn't removed")
+	assert.NoErrorf(t, db.RemoveFile(photo), "RemoveFile failed")
+	count := 0
+	assert.NoErrorf(t, db.db.QueryRow("SELECT COUNT(*) FROM file_meta").Scan(&count), "Failed to count file_meta")
+	assert.Equalf(t, 2, count, "Metadata of removed files wasn't removed")
+}
+
+func TestAddInfoMeta(t *testing.T) {
+	png := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\x0dIHDR")
+	png = binary.BigEndian.AppendUint32(png, 64)
+	png = binary.BigEndian.AppendUint32(png, 32)
+	dir := t.TempDir()
+	for name, data := range map[string][]byte{"a.png": png, "b.txt": []byte("text"), "c.png": []byte("corrupt")} {
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0666); err != nil {
+			t.Fatalf("Failed to write test file: %v", err)
+		}
+	}
+	a, b, c := NewFile(filepath.Join(dir, "a.png")), NewFile(filepath.Join(dir, "b.txt")), NewFile(filepath.Join(dir, "c.png"))
+	err := AddInfoToFiles(&AddInfoOpts{DontAddHash: true, DontAddSize: true, AddMeta: true}, a, b, c)
+	if assert.NoErrorf(t, err, "AddInfoToFiles failed") {
+		assert.Equalf(t, &metadata.Metadata{Width: 64, Height: 32}, a.GetMeta(), "Metadata wasn't added")
+		assert.Nilf(t, b.GetMeta(), "Unsupported file had metadata")
+		assert.Nilf(t, c.GetMeta(), "Corrupt file had metadata")
+		assert.Emptyf(t, a.GetHash(), "Hash was added")
+	}
+	assert.Errorf(t, AddInfoToFiles(&AddInfoOpts{DontAddHash: true, DontAddSize: true}, a), "Adding nothing didn't fail")
+}
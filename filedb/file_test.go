@@ -119,6 +119,7 @@ func TestSetStars(t *testing.T) {
 		id:         0,
 		tags:       make([]string, 0),
 		path:       "test",
+		name:       "test",
 		lastViewed: time.Unix(0, 0),
 		stars:      0,
 	}, f, "Starting file was not equal") {
@@ -131,6 +132,7 @@ func TestSetStars(t *testing.T) {
 			id:         0,
 			tags:       make([]string, 0),
 			path:       "test",
+			name:       "test",
 			lastViewed: time.Unix(0, 0),
 			stars:      i,
 		}, f, "Other structure values modified")
@@ -169,6 +171,7 @@ func TestNewFile(t *testing.T) {
 		id:         0,
 		tags:       make([]string, 0),
 		path:       "t",
+		name:       "t",
 		lastViewed: time.Unix(0, 0),
 		stars:      0,
 	}, f, "Starting file was no equal")
@@ -198,3 +201,12 @@ func TestNewFileWithInfo(t *testing.T) {
 	assert.Equalf(t, ExpectedHash, f.GetHash(), "Invalid hash")
 	assert.Equal(t, int64(len(TestString)), f.GetSize(), "Invalid size")
 }
+
+func TestSetName(t *testing.T) {
+	f := NewFile("dir/sub/file.mp4")
+	assert.Equalf(t, "file.mp4", f.GetName(), "Default name should be the last path element")
+	assert.NoErrorf(t, f.SetName("My File"), "SetName failed")
+	assert.Equalf(t, "My File", f.GetName(), "GetName returned wrong value")
+	assert.Errorf(t, f.SetName(""), "SetName accepted a empty name")
+	assert.Equalf(t, "file.mp4", NewFile("dir\\file.mp4").GetName(), "Default name should handle '\\' seperators")
+}
@@ -0,0 +1,51 @@
+package filedb
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// Set or clear the missing time of the file at path, returns false if no file has the path or it was already set or cleared
+func (d *FileDb) setFileMissing(path string, missing bool) (bool, error) {
+	if d.safeMode {
+		return false, ErrOutdatedDatabase
+	}
+	if path == "" {
+		return false, errors.New("cannot use empty path")
+	}
+	path = strings.ReplaceAll(path, "\\", "/")
+	query, queryArgs := "UPDATE file SET missing=NULL WHERE path=? AND missing IS NOT NULL", []any{path}
+	if missing {
+		query, queryArgs = "UPDATE file SET missing=? WHERE path=? AND missing IS NULL", []any{time.Now().Unix(), path}
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	slog.Info("Executing UPDATE", "Query", query, "QueryArgs", queryArgs)
+	res, err := d.db.Exec(query, queryArgs...)
+	if err != nil {
+		slog.Warn("Failed to update file", "Query", query, "QueryArgs", queryArgs, "Error", err.Error())
+		return false, fmt.Errorf("failed to update file: %v", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		slog.Error("Failed to get rowsAffected", "Error", err.Error(), "Query", query)
+		panic(fmt.Sprintf("MediaManager: setFileMissing: .RowsAffected failed, is the database correct?: %v", err))
+	}
+	return n != 0, nil
+}
+
+// Mark the file at path as missing from disk, it's kept with its tags, stars & last viewed times until it's found again or removed.
+// Returns false if no file has the path or it was already marked.
+//
+// Find these with the 'missing:yes' query, see also FindMovedFiles.
+func (d *FileDb) MarkFileMissing(path string) (bool, error) {
+	return d.setFileMissing(path, true)
+}
+
+// Remove the missing mark of the file at path after it was found again, returns false if no file has the path or it wasn't marked
+func (d *FileDb) MarkFileFound(path string) (bool, error) {
+	return d.setFileMissing(path, false)
+}
@@ -0,0 +1,49 @@
+package filedb
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMarkFileMissing(t *testing.T) {
+	db := getTestDb(t)
+	defer db.Close()
+	a, b := makeTestFile(t, "/a.mp4"), makeTestFile(t, "/b.mp4")
+	assert.NoErrorf(t, a.AddTag("keep"), "AddTag failed")
+	if _, err := db.AddFiles(a, b); !assert.NoErrorf(t, err, "AddFiles failed") {
+		return
+	}
+	marked, err := db.MarkFileMissing("/a.mp4")
+	assert.NoErrorf(t, err, "MarkFileMissing failed")
+	assert.Truef(t, marked, "File wasn't marked")
+	marked, _ = db.MarkFileMissing("/a.mp4")
+	assert.Falsef(t, marked, "File was marked twice")
+	marked, _ = db.MarkFileMissing("/c.mp4")
+	assert.Falsef(t, marked, "File that isn't in the database was marked")
+	f, err := db.GetFileById(a.GetId())
+	if assert.NoErrorf(t, err, "GetFileById failed") {
+		_, missing := f.GetMissing()
+		assert.Truef(t, missing, "Missing mark wasn't loaded")
+		assert.Equalf(t, []string{"keep"}, f.GetTags(), "Tags weren't kept")
+	}
+	search := func(query string) []string {
+		fs, err := db.SearchFile(&SearchQuery{Query: query})
+		assert.NoErrorf(t, err, "SearchFile(%s) failed", query)
+		paths := make([]string, len(fs))
+		for i, v := range fs {
+			paths[i] = v.GetPath()
+		}
+		return paths
+	}
+	assert.Equalf(t, []string{"/a.mp4"}, search("missing:yes"), "Wrong missing files")
+	assert.Equalf(t, []string{"/b.mp4"}, search("missing:no"), "Wrong files that aren't missing")
+	_, err = ParseQuery("missing:maybe")
+	assert.Errorf(t, err, "Invalid missing value was parsed")
+	found, err := db.MarkFileFound("/a.mp4")
+	assert.NoErrorf(t, err, "MarkFileFound failed")
+	assert.Truef(t, found, "Missing mark wasn't removed")
+	found, _ = db.MarkFileFound("/b.mp4")
+	assert.Falsef(t, found, "File that wasn't missing was found")
+	assert.Emptyf(t, search("missing:yes"), "Files are still missing")
+}
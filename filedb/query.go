@@ -0,0 +1,679 @@
+package filedb
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A search query written in the query language, for example
+//
+//	(tag:anime OR tag:cartoon) -tag:watched stars>=4 size<2GB viewed<30d path:"/tv/"
+//
+// Terms next to each other must all match, OR matches either side and '-' or NOT matches files the term doesn't.
+// Parentheses group terms, AND binds tighter than OR. Words without a field are text search terms (See SearchQuery.Text).
+//
+// Fields:
+//   - tag:<tag>          : File has the tag or one of its children (tag:genre:music matches genre:music/jazz), a trailing '*' matches tags starting with the value
+//   - path:<text>        : Path contains the text
+//   - name:<text>        : Display name contains the text
+//   - re:<regex>         : Path matches the regex
+//   - hash:<hash>        : Hash is the value, 'none' for files without a hash & 'any' for files with one
+//   - id, stars, size    : Compared with ':', '=', '!=', '<', '<=', '>' or '>='. Sizes can use B, KB, MB, GB & TB (1024 based)
+//   - missing            : 'yes' for files marked missing from disk (See FileDb.MarkFileMissing) & 'no' for files that aren't
+//   - category           : image, audio or video, 'none' for files without a type (See AddInfoOpts.AddType)
+//   - mime               : MIME type is the value, like mime:video/mp4. 'none' for files without a type
+//   - viewed             : Compared with a age (h, d, w or y, e.g. viewed<30d is viewed in the last 30 days) or a date (viewed<2024-01-02 is viewed before it), viewed:2024-01-02 for that day & viewed:never for files that haven't been viewed
+//
+// Embedded metadata fields, files without metadata only match 'none' (See AddInfoOpts.AddMeta):
+//   - title, artist, album, camera : Metadata contains the text, 'none' for files without the value
+//   - track, width, height         : Compared like id
+//   - duration                     : Compared like id, in seconds or with a unit like 90s, 10m or 1h30m
+//   - taken                        : When a photo was taken, compared like viewed. taken:none for photos without a date
+//   - location                     : 'any' for photos with a GPS location & 'none' for files without one
+type QueryNode interface {
+	String() string
+	// Compile to a SQL expression and its arguments, the file table must be 'f' and user_file 'uf'
+	sql(c *queryCompiler) (string, []any)
+}
+
+// Every node must match
+type QueryAnd struct {
+	Nodes []QueryNode
+}
+
+// Any node must match
+type QueryOr struct {
+	Nodes []QueryNode
+}
+
+// Node must not match
+type QueryNot struct {
+	Node QueryNode
+}
+
+// A field comparison, or a text search term if Field is empty
+type QueryTerm struct {
+	Field  string
+	Op     string // ':', '=', '!=', '<', '<=', '>' or '>='
+	Value  string
+	Prefix bool // Value ended with '*', only used by tag & text terms
+}
+
+func (q *QueryAnd) String() string {
+	return joinQueryNodes(q.Nodes, " ")
+}
+
+func (q *QueryOr) String() string {
+	return joinQueryNodes(q.Nodes, " OR ")
+}
+
+func (q *QueryNot) String() string {
+	if _, ok := q.Node.(*QueryTerm); ok {
+		return "-" + q.Node.String()
+	}
+	return "-(" + q.Node.String() + ")"
+}
+
+// Checks if a term value has to be quoted to be parsed back to the same value
+func (q *QueryTerm) needsQuotes() bool {
+	v := q.Value
+	switch {
+	case v == "", strings.ContainsAny(v, " \t\r\n()\""), strings.HasSuffix(v, "*"):
+		return true
+	case q.Field == "":
+		// Text that looks like a field, operator or negation
+		if v == "AND" || v == "OR" || v == "NOT" || strings.HasPrefix(v, "-") {
+			return true
+		}
+		if opIdx := strings.IndexAny(v, ":=<>!"); opIdx > 0 {
+			_, known := queryFields[strings.ToLower(v[:opIdx])]
+			return known
+		}
+	}
+	return false
+}
+
+func (q *QueryTerm) String() string {
+	v := q.Value
+	if q.needsQuotes() {
+		v = `"` + strings.ReplaceAll(v, `"`, `""`) + `"`
+	}
+	if q.Prefix {
+		v += "*"
+	}
+	return q.Field + q.Op + v
+}
+
+func joinQueryNodes(nodes []QueryNode, sep string) string {
+	parts := make([]string, len(nodes))
+	for i, v := range nodes {
+		parts[i] = v.String()
+		if _, ok := v.(*QueryOr); ok {
+			parts[i] = "(" + parts[i] + ")"
+		}
+	}
+	return strings.Join(parts, sep)
+}
+
+// A error in a query, Pos is the byte offset it was found at
+type QueryError struct {
+	Pos int
+	Msg string
+}
+
+func (e *QueryError) Error() string {
+	return fmt.Sprintf("invalid query at %d: %s", e.Pos, e.Msg)
+}
+
+type queryTokenType int
+
+const (
+	queryTokenTerm queryTokenType = iota
+	queryTokenOpen
+	queryTokenClose
+	queryTokenNot
+	queryTokenAnd
+	queryTokenOr
+	queryTokenEnd
+)
+
+type queryToken struct {
+	Type queryTokenType
+	Pos  int
+	Term *QueryTerm
+}
+
+// Fields & the operators they accept
+var queryFields = map[string][]string{
+	"tag":      {":"},
+	"path":     {":"},
+	"name":     {":"},
+	"re":       {":"},
+	"hash":     {":"},
+	"id":       {":", "=", "!=", "<", "<=", ">", ">="},
+	"stars":    {":", "=", "!=", "<", "<=", ">", ">="},
+	"size":     {":", "=", "!=", "<", "<=", ">", ">="},
+	"viewed":   {":", "=", "!=", "<", "<=", ">", ">="},
+	"missing":  {":"},
+	"category": {":"},
+	"mime":     {":"},
+	// Embedded metadata
+	"title":    {":"},
+	"artist":   {":"},
+	"album":    {":"},
+	"camera":   {":"},
+	"track":    {":", "=", "!=", "<", "<=", ">", ">="},
+	"width":    {":", "=", "!=", "<", "<=", ">", ">="},
+	"height":   {":", "=", "!=", "<", "<=", ">", ">="},
+	"duration": {":", "=", "!=", "<", "<=", ">", ">="},
+	"taken":    {":", "=", "!=", "<", "<=", ">", ">="},
+	"location": {":"},
+}
+
+// Read a quoted string starting at the opening quote, "" is a escaped quote. Returns the value and the index after the closing quote.
+func readQueryQuoted(s string, start int) (string, int, error) {
+	b := strings.Builder{}
+	for i := start + 1; i < len(s); i++ {
+		if s[i] != '"' {
+			b.WriteByte(s[i])
+			continue
+		}
+		if i+1 < len(s) && s[i+1] == '"' {
+			b.WriteByte('"')
+			i++
+			continue
+		}
+		return b.String(), i + 1, nil
+	}
+	return "", 0, &QueryError{Pos: start, Msg: "unclosed quote"}
+}
+
+func isQuerySpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+// Split a query into tokens
+func lexQuery(s string) ([]queryToken, error) {
+	tokens := make([]queryToken, 0)
+	isEnd := func(c byte) bool {
+		return isQuerySpace(c) || c == '(' || c == ')'
+	}
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case isQuerySpace(c):
+			i++
+			continue
+		case c == '(':
+			tokens = append(tokens, queryToken{Type: queryTokenOpen, Pos: i})
+			i++
+			continue
+		case c == ')':
+			tokens = append(tokens, queryToken{Type: queryTokenClose, Pos: i})
+			i++
+			continue
+		case c == '-' && i+1 < len(s) && !isQuerySpace(s[i+1]) && s[i+1] != ')':
+			tokens = append(tokens, queryToken{Type: queryTokenNot, Pos: i})
+			i++
+			continue
+		}
+		start := i
+		term := &QueryTerm{}
+		quoted := false
+		if c != '"' {
+			for i < len(s) && !isEnd(s[i]) && s[i] != '"' {
+				i++
+			}
+			word := s[start:i]
+			switch word {
+			case "AND":
+				tokens = append(tokens, queryToken{Type: queryTokenAnd, Pos: start})
+				continue
+			case "OR":
+				tokens = append(tokens, queryToken{Type: queryTokenOr, Pos: start})
+				continue
+			case "NOT":
+				tokens = append(tokens, queryToken{Type: queryTokenNot, Pos: start})
+				continue
+			}
+			term.Value = word
+			// Split out the field & operator
+			if opIdx := strings.IndexAny(word, ":=<>!"); opIdx > 0 {
+				if _, known := queryFields[strings.ToLower(word[:opIdx])]; known {
+					op := word[opIdx : opIdx+1]
+					if opIdx+1 < len(word) && word[opIdx+1] == '=' && op != ":" && op != "=" {
+						op += "="
+					}
+					term.Field = strings.ToLower(word[:opIdx])
+					term.Op = op
+					term.Value = word[opIdx+len(op):]
+				}
+			}
+		}
+		// A quoted phrase or value, e.g. "big cat" or path:"/tv/"
+		if i < len(s) && s[i] == '"' {
+			if term.Value != "" {
+				return nil, &QueryError{Pos: i, Msg: "unexpected quote"}
+			}
+			v, end, err := readQueryQuoted(s, i)
+			if err != nil {
+				return nil, err
+			}
+			term.Value = v
+			quoted = true
+			i = end
+		}
+		if quoted && i < len(s) && s[i] == '*' {
+			term.Prefix = true
+			i++
+		} else if !quoted && strings.HasSuffix(term.Value, "*") && (term.Field == "" || term.Field == "tag") {
+			term.Prefix = true
+			term.Value = strings.TrimSuffix(term.Value, "*")
+		}
+		if i < len(s) && !isEnd(s[i]) {
+			return nil, &QueryError{Pos: i, Msg: "expected a space or parenthesis after the term"}
+		}
+		if err := checkQueryTerm(term, start); err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, queryToken{Type: queryTokenTerm, Pos: start, Term: term})
+	}
+	return append(tokens, queryToken{Type: queryTokenEnd, Pos: len(s)}), nil
+}
+
+// Check the operator & value of a term so errors are found before the query is used
+func checkQueryTerm(t *QueryTerm, pos int) error {
+	if t.Field == "" {
+		if strings.TrimSpace(t.Value) == "" {
+			return &QueryError{Pos: pos, Msg: "empty term"}
+		}
+		return nil
+	}
+	if t.Op == "!" {
+		return &QueryError{Pos: pos, Msg: "'!' must be followed by '='"}
+	}
+	if t.Prefix && t.Field != "tag" {
+		return &QueryError{Pos: pos, Msg: fmt.Sprintf("'%s' doesn't support '*'", t.Field)}
+	}
+	ops := queryFields[t.Field]
+	if !slices.Contains(ops, t.Op) {
+		return &QueryError{Pos: pos, Msg: fmt.Sprintf("'%s' doesn't support '%s'", t.Field, t.Op)}
+	}
+	if t.Value == "" && !(t.Field == "tag" && t.Prefix) {
+		return &QueryError{Pos: pos, Msg: fmt.Sprintf("'%s' needs a value", t.Field)}
+	}
+	var err error
+	switch t.Field {
+	case "re":
+		_, err = regexp.Compile(t.Value)
+	case "id", "track", "width", "height":
+		_, err = strconv.ParseInt(t.Value, 10, 64)
+	case "duration":
+		_, err = parseQueryDuration(t.Value)
+	case "location":
+		if t.Value != "any" && t.Value != "none" {
+			err = errors.New("must be 'any' or 'none'")
+		}
+	case "missing":
+		if t.Value != "yes" && t.Value != "no" {
+			err = errors.New("must be 'yes' or 'no'")
+		}
+	case "category":
+		if !slices.Contains([]string{"image", "audio", "video", "none"}, strings.ToLower(t.Value)) {
+			err = errors.New("must be 'image', 'audio', 'video' or 'none'")
+		}
+	case "stars":
+		var stars uint64
+		stars, err = strconv.ParseUint(t.Value, 10, 8)
+		if err == nil && stars > 5 {
+			err = errors.New("stars must be 0 to 5")
+		}
+	case "size":
+		_, err = parseQuerySize(t.Value)
+	case "viewed", "taken":
+		if (t.Field == "viewed" && t.Value == "never") || (t.Field == "taken" && t.Value == "none") {
+			if t.Op != ":" && t.Op != "=" && t.Op != "!=" {
+				err = fmt.Errorf("'%s' can only be compared with ':', '=' or '!='", t.Value)
+			}
+			break
+		}
+		var age bool
+		_, age, err = parseQueryTime(t.Value, time.Now())
+		if err == nil && age && (t.Op == ":" || t.Op == "=" || t.Op == "!=") {
+			err = errors.New("ages can only be compared with '<', '<=', '>' or '>='")
+		}
+	}
+	if err != nil {
+		return &QueryError{Pos: pos, Msg: fmt.Sprintf("invalid '%s' value '%s': %v", t.Field, t.Value, err)}
+	}
+	return nil
+}
+
+// Parse a size like '2GB' or '512', units are 1024 based
+func parseQuerySize(s string) (int64, error) {
+	units := []struct {
+		Suffix string
+		Size   int64
+	}{
+		{"TB", 1 << 40}, {"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}, {"B", 1},
+	}
+	upper := strings.ToUpper(s)
+	mult := int64(1)
+	for _, u := range units {
+		if strings.HasSuffix(upper, u.Suffix) {
+			upper = strings.TrimSuffix(upper, u.Suffix)
+			mult = u.Size
+			break
+		}
+	}
+	v, err := strconv.ParseFloat(upper, 64)
+	if err != nil || v < 0 {
+		return 0, errors.New("must be a positive number with a optional B, KB, MB, GB or TB unit")
+	}
+	return int64(v * float64(mult)), nil
+}
+
+// Parse a duration in seconds ('90') or with a unit ('10m', '1h30m'), returns seconds
+func parseQueryDuration(s string) (float64, error) {
+	if v, err := strconv.ParseFloat(s, 64); err == nil && v >= 0 {
+		return v, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, errors.New("must be a positive number of seconds or a duration like 10m or 1h30m")
+	}
+	return d.Seconds(), nil
+}
+
+// Parse a age ('30d') or date ('2024-01-02'), age is true for ages. Returns the time it refers to.
+func parseQueryTime(s string, now time.Time) (t time.Time, age bool, err error) {
+	if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		return t, false, nil
+	}
+	units := map[byte]time.Duration{
+		'h': time.Hour,
+		'd': 24 * time.Hour,
+		'w': 7 * 24 * time.Hour,
+		'y': 365 * 24 * time.Hour,
+	}
+	if len(s) < 2 {
+		return t, false, errors.New("must be a age like 30d or a date like 2024-01-02")
+	}
+	unit, found := units[s[len(s)-1]]
+	n, err := strconv.ParseUint(s[:len(s)-1], 10, 32)
+	if !found || err != nil {
+		return t, false, errors.New("must be a age like 30d or a date like 2024-01-02")
+	}
+	return now.Add(-time.Duration(n) * unit), true, nil
+}
+
+type queryParser struct {
+	tokens []queryToken
+	pos    int
+}
+
+func (p *queryParser) peek() queryToken {
+	return p.tokens[p.pos]
+}
+
+func (p *queryParser) next() queryToken {
+	t := p.tokens[p.pos]
+	if t.Type != queryTokenEnd {
+		p.pos++
+	}
+	return t
+}
+
+// or := and ("OR" and)*
+func (p *queryParser) parseOr() (QueryNode, error) {
+	nodes := make([]QueryNode, 0, 1)
+	for {
+		n, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, n)
+		if p.peek().Type != queryTokenOr {
+			break
+		}
+		p.next()
+	}
+	if len(nodes) == 1 {
+		return nodes[0], nil
+	}
+	return &QueryOr{Nodes: nodes}, nil
+}
+
+// and := unary (["AND"] unary)*
+func (p *queryParser) parseAnd() (QueryNode, error) {
+	nodes := make([]QueryNode, 0, 1)
+	for {
+		n, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, n)
+		t := p.peek()
+		if t.Type == queryTokenAnd {
+			p.next()
+			continue
+		}
+		if t.Type != queryTokenTerm && t.Type != queryTokenOpen && t.Type != queryTokenNot {
+			break
+		}
+	}
+	if len(nodes) == 1 {
+		return nodes[0], nil
+	}
+	return &QueryAnd{Nodes: nodes}, nil
+}
+
+// unary := ("-" | "NOT") unary | "(" or ")" | term
+func (p *queryParser) parseUnary() (QueryNode, error) {
+	t := p.next()
+	switch t.Type {
+	case queryTokenNot:
+		n, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &QueryNot{Node: n}, nil
+	case queryTokenOpen:
+		n, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if c := p.next(); c.Type != queryTokenClose {
+			return nil, &QueryError{Pos: c.Pos, Msg: "expected ')'"}
+		}
+		return n, nil
+	case queryTokenTerm:
+		return t.Term, nil
+	case queryTokenEnd:
+		return nil, &QueryError{Pos: t.Pos, Msg: "unexpected end of query"}
+	default:
+		return nil, &QueryError{Pos: t.Pos, Msg: "expected a term or '('"}
+	}
+}
+
+// Parse a query, see QueryNode for the syntax. A empty query returns a nil node.
+func ParseQuery(s string) (QueryNode, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+	tokens, err := lexQuery(s)
+	if err != nil {
+		return nil, err
+	}
+	p := &queryParser{tokens: tokens}
+	n, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.Type != queryTokenEnd {
+		return nil, &QueryError{Pos: t.Pos, Msg: "unexpected ')'"}
+	}
+	return n, nil
+}
+
+// State used while compiling a query
+type queryCompiler struct {
+	now      time.Time
+	fullText bool
+	aliases  tagAliasMap // See AddTagAlias
+}
+
+// Compile a query to a SQL expression and its arguments
+func compileQuery(n QueryNode, now time.Time, fullText bool, aliases tagAliasMap) (string, []any) {
+	return n.sql(&queryCompiler{now: now, fullText: fullText, aliases: aliases})
+}
+
+func (q *QueryAnd) sql(c *queryCompiler) (string, []any) {
+	return joinQuerySql(c, q.Nodes, " AND ")
+}
+
+func (q *QueryOr) sql(c *queryCompiler) (string, []any) {
+	return joinQuerySql(c, q.Nodes, " OR ")
+}
+
+func (q *QueryNot) sql(c *queryCompiler) (string, []any) {
+	s, args := q.Node.sql(c)
+	return "NOT (" + s + ")", args
+}
+
+func joinQuerySql(c *queryCompiler, nodes []QueryNode, sep string) (string, []any) {
+	parts := make([]string, len(nodes))
+	args := make([]any, 0)
+	for i, v := range nodes {
+		s, a := v.sql(c)
+		parts[i] = "(" + s + ")"
+		args = append(args, a...)
+	}
+	return strings.Join(parts, sep), args
+}
+
+// Escape LIKE wildcards, used with ESCAPE '\'
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// A column of the files embedded metadata, NULL if it has none
+func metaColumnSql(column string) string {
+	return "(SELECT qm." + column + " FROM file_meta qm WHERE qm.fileId = f.id)"
+}
+
+// Compare a time column with a age or date term, see the viewed field
+func timeTermSql(c *queryCompiler, column string, op string, value string) (string, []any) {
+	t, age, _ := parseQueryTime(value, c.now)
+	if age {
+		// Less than 30 days ago is after the time
+		op = reversedQueryOps[op]
+	} else if op == "=" || op == "!=" {
+		// Any time on the day
+		day := column + " >= ? AND " + column + " < ?"
+		if op == "!=" {
+			day = "NOT (" + day + ")"
+		}
+		return column + " IS NOT NULL AND " + day, []any{t.Unix(), t.AddDate(0, 0, 1).Unix()}
+	}
+	return column + " IS NOT NULL AND " + column + " " + op + " ?", []any{t.Unix()}
+}
+
+// Operators that are the opposite direction, used to turn a age into a time
+var reversedQueryOps = map[string]string{
+	"<":  ">",
+	"<=": ">=",
+	">":  "<",
+	">=": "<=",
+}
+
+func (q *QueryTerm) sql(c *queryCompiler) (string, []any) {
+	op := q.Op
+	if op == ":" {
+		op = "="
+	}
+	switch q.Field {
+	case "":
+		return textTermSql(searchTerm{Value: q.Value, Prefix: q.Prefix}, c.fullText)
+	case "tag":
+		if q.Prefix {
+			return `EXISTS (SELECT 1 FROM tag qt JOIN tag_name qtn ON qt.tagNameId = qtn.id WHERE qt.fileId = f.id AND qtn.value LIKE ? ESCAPE '\')`, []any{escapeLike(strings.ToLower(q.Value)) + "%"}
+		}
+		match, args := tagMatchSql("qtn.value", c.aliases.resolve(q.Value))
+		return "EXISTS (SELECT 1 FROM tag qt JOIN tag_name qtn ON qt.tagNameId = qtn.id WHERE qt.fileId = f.id AND " + match + ")", args
+	case "path":
+		return `f.path LIKE ? ESCAPE '\'`, []any{"%" + escapeLike(q.Value) + "%"}
+	case "name":
+		return `f.name LIKE ? ESCAPE '\'`, []any{"%" + escapeLike(q.Value) + "%"}
+	case "re":
+		return "f.path regexp ?", []any{q.Value}
+	case "hash":
+		switch q.Value {
+		case "none":
+			return "f.hash IS NULL", nil
+		case "any":
+			return "f.hash IS NOT NULL", nil
+		}
+		return "f.hash = ?", []any{strings.ToLower(q.Value)}
+	case "missing":
+		if q.Value == "no" {
+			return "f.missing IS NULL", nil
+		}
+		return "f.missing IS NOT NULL", nil
+	case "category", "mime":
+		if strings.ToLower(q.Value) == "none" {
+			return "f." + q.Field + " IS NULL", nil
+		}
+		return "f." + q.Field + " = ?", []any{strings.ToLower(q.Value)}
+	case "id":
+		v, _ := strconv.ParseInt(q.Value, 10, 64)
+		return "f.id " + op + " ?", []any{v}
+	case "stars":
+		v, _ := strconv.ParseUint(q.Value, 10, 8)
+		return "COALESCE(uf.stars, 0) " + op + " ?", []any{v}
+	case "size":
+		v, _ := parseQuerySize(q.Value)
+		return "COALESCE(f.size, 0) " + op + " ?", []any{v}
+	case "viewed":
+		if q.Value == "never" {
+			if op == "!=" {
+				return "COALESCE(uf.lastViewed, 0) != 0", nil
+			}
+			return "COALESCE(uf.lastViewed, 0) = 0", nil
+		}
+		// Unset times are 0
+		return timeTermSql(c, "NULLIF(uf.lastViewed, 0)", op, q.Value)
+	case "title", "artist", "album", "camera":
+		if strings.ToLower(q.Value) == "none" {
+			return "COALESCE(" + metaColumnSql(q.Field) + ", '') = ''", nil
+		}
+		return "COALESCE(" + metaColumnSql(q.Field) + `, '') LIKE ? ESCAPE '\'`, []any{"%" + escapeLike(q.Value) + "%"}
+	case "track", "width", "height":
+		v, _ := strconv.ParseInt(q.Value, 10, 64)
+		// Files without the value never match, even when negated
+		return "COALESCE(" + metaColumnSql(q.Field) + " " + op + " ?, FALSE)", []any{v}
+	case "duration":
+		v, _ := parseQueryDuration(q.Value)
+		return "COALESCE(" + metaColumnSql("duration") + " " + op + " ?, FALSE)", []any{v}
+	case "taken":
+		if q.Value == "none" {
+			if op == "!=" {
+				return metaColumnSql("taken") + " IS NOT NULL", nil
+			}
+			return metaColumnSql("taken") + " IS NULL", nil
+		}
+		return timeTermSql(c, metaColumnSql("taken"), op, q.Value)
+	case "location":
+		if q.Value == "none" {
+			return metaColumnSql("latitude") + " IS NULL", nil
+		}
+		return metaColumnSql("latitude") + " IS NOT NULL", nil
+	}
+	// checkQueryTerm only allows queryFields
+	panic(fmt.Sprintf("MediaManager: QueryTerm.sql: Got unexpected field '%s'", q.Field))
+}
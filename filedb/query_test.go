@@ -0,0 +1,112 @@
+package filedb
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseQuery(t *testing.T) {
+	// Queries are printed back in a normal form
+	for query, expected := range map[string]string{
+		`(tag:anime OR tag:cartoon) -tag:watched stars>=4 size<2GB viewed<30d path:"/tv/"`: `(tag:anime OR tag:cartoon) -tag:watched stars>=4 size<2GB viewed<30d path:/tv/`,
+		`a OR b c`:                  `a OR b c`,
+		`a AND (b OR c)`:            `a (b OR c)`,
+		`NOT (a OR b)`:              `-(a OR b)`,
+		`"big cat"* TAG:Author:*`:   `"big cat"* tag:Author:*`,
+		`path:"say ""hi"""`:         `path:"say ""hi"""`,
+		`hash:none id!=3 unknown:x`: `hash:none id!=3 unknown:x`,
+	} {
+		n, err := ParseQuery(query)
+		if assert.NoErrorf(t, err, "ParseQuery(%s) failed", query) {
+			assert.Equalf(t, expected, n.String(), "Wrong query for '%s'", query)
+		}
+	}
+	n, err := ParseQuery("  ")
+	assert.NoErrorf(t, err, "Empty query failed")
+	assert.Nilf(t, n, "Empty query wasn't nil")
+	for _, query := range []string{
+		`(a OR b`, `a)`, `a OR`, `path:"unclosed`, `stars>6`, `stars<x`, `size>2XB`, `re:(`,
+		`viewed=30d`, `viewed<never`, `path:`, `id!3`, `path:"a"*`, `a"b"`,
+	} {
+		_, err := ParseQuery(query)
+		qErr := &QueryError{}
+		assert.Truef(t, errors.As(err, &qErr), "ParseQuery(%s) didn't return a QueryError: %v", query, err)
+	}
+}
+
+func TestSearchQueryLanguage(t *testing.T) {
+	db := getTestDb(t)
+	defer db.Close()
+	u := getTestUser(t, db)
+	anime := makeTestFile(t, "/tv/anime.mp4")
+	assert.NoErrorf(t, anime.AddTag("anime"), "AddTag failed")
+	anime.SetSize(3 << 30)
+	cartoon := makeTestFile(t, "/tv/cartoon.mp4")
+	assert.NoErrorf(t, cartoon.AddTag("cartoon"), "AddTag failed")
+	cartoon.SetSize(1 << 30)
+	watched := makeTestFile(t, "/tv/watched_cartoon.mp4")
+	assert.NoErrorf(t, watched.AddTag("cartoon"), "AddTag failed")
+	assert.NoErrorf(t, watched.AddTag("watched"), "AddTag failed")
+	film := makeTestFile(t, "/films/anime_film.mp4")
+	assert.NoErrorf(t, film.AddTag("anime"), "AddTag failed")
+	files := []*File{anime, cartoon, watched, film}
+	if _, err := db.AddFiles(files...); !assert.NoErrorf(t, err, "AddFiles failed") {
+		return
+	}
+	assert.NoErrorf(t, db.LoadUserData(u, files...), "LoadUserData failed")
+	assert.NoErrorf(t, anime.SetStars(5), "SetStars failed")
+	assert.NoErrorf(t, cartoon.SetStars(4), "SetStars failed")
+	assert.NoErrorf(t, watched.SetStars(4), "SetStars failed")
+	anime.MarkFileRead()
+	cartoon.MarkFileRead()
+	for _, f := range files {
+		assert.NoErrorf(t, db.UpdateFile(f), "UpdateFile failed")
+	}
+	// Make the cartoon look like it was viewed 60 days ago
+	_, err := db.db.Exec("UPDATE user_file SET lastViewed=? WHERE fileId=?", time.Now().Add(-60*24*time.Hour).Unix(), cartoon.GetId())
+	assert.NoErrorf(t, err, "Failed to set lastViewed")
+	search := func(query string) []int {
+		fs, err := db.SearchFile(&SearchQuery{Query: query, UserId: u.GetId()})
+		assert.NoErrorf(t, err, "SearchFile(%s) failed", query)
+		ids := make([]int, len(fs))
+		for i, v := range fs {
+			ids[i] = v.GetId()
+		}
+		return ids
+	}
+	ids := func(fs ...*File) []int {
+		r := make([]int, len(fs))
+		for i, v := range fs {
+			r[i] = v.GetId()
+		}
+		return r
+	}
+	for query, expected := range map[string][]int{
+		`(tag:anime OR tag:cartoon) -tag:watched`:                                          ids(anime, cartoon, film),
+		`(tag:anime OR tag:cartoon) -tag:watched stars>=4 size<2GB viewed>30d path:"/tv/"`: ids(cartoon),
+		`tag:anime OR tag:cartoon stars=4`:                                                 ids(anime, cartoon, watched, film),
+		`tag:anime AND path:/tv/ OR tag:watched`:                                           ids(anime, watched),
+		`viewed<30d`:                                                                       ids(anime),
+		`viewed:never`:                                                                     ids(watched, film),
+		`stars:0`:                                                                          ids(film),
+		`size>=1GB size<=1GB`:                                                              ids(cartoon),
+		`tag:car*`:                                                                         ids(cartoon, watched),
+		`cartoon -watched`:                                                                 ids(cartoon),
+		`"anime film"`:                                                                     ids(film),
+		`re:^/films/ OR name:watched`:                                                      ids(watched, film),
+		`hash:any`:                                                                         ids(),
+		`path:_`:                                                                           ids(watched, film),
+	} {
+		assert.ElementsMatchf(t, expected, search(query), "Wrong files for '%s'", query)
+	}
+	assert.Equalf(t, ids(film), search(fmt.Sprintf("id:%d", film.GetId())), "Wrong files for id")
+	assert.ElementsMatchf(t, ids(anime, cartoon, watched), search(fmt.Sprintf("id<%d", film.GetId())), "Wrong files for id range")
+	// Invalid queries fail the search
+	_, err = db.SearchFile(&SearchQuery{Query: "(tag:anime"})
+	qErr := &QueryError{}
+	assert.Truef(t, errors.As(err, &qErr), "Invalid query didn't return a QueryError: %v", err)
+}
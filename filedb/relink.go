@@ -0,0 +1,222 @@
+package filedb
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// A file missing from disk that was found at another path, see FindMovedFiles
+type MovedFile struct {
+	File    *File
+	NewPath string
+	ByHash  bool // Matched by hash, otherwise it has no hash & was the only file with its size
+}
+
+// A file in the database that isn't on disk
+type missingFile struct {
+	id   int
+	path string
+	size int64
+	hash string
+}
+
+// Get files with a size that are missing from disk, files that can't be checked are logged & skipped
+func (d *FileDb) getMissingFiles() []*missingFile {
+	slog.Debug("Executing SELECT", "Query", "SELECT id, path, size, hash FROM file WHERE size > 0")
+	rows, err := d.db.Query("SELECT id, path, size, hash FROM file WHERE size > 0")
+	if err != nil {
+		slog.Error("Failed to execute select query", "Query", "SELECT id, path, size, hash FROM file WHERE size > 0", "Error", err.Error())
+		panic(fmt.Sprintf("MediaManager: getMissingFiles query failed: %v", err))
+	}
+	defer rows.Close()
+	missing := make([]*missingFile, 0)
+	for rows.Next() {
+		m := &missingFile{}
+		var hash sql.NullString
+		if err = rows.Scan(&m.id, &m.path, &m.size, &hash); err != nil {
+			slog.Error("Failed to scan file", "Error", err.Error())
+			panic(fmt.Sprintf("MediaManager: getMissingFiles: Failed to scan file, did the structure change?: %v", err))
+		}
+		m.hash = hash.String
+		if _, err = os.Stat(m.path); err == nil {
+			continue
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			slog.Warn("Failed to stat file", "Path", m.path, "Error", err.Error())
+			continue
+		}
+		missing = append(missing, m)
+	}
+	return missing
+}
+
+// Find files that are missing from disk somewhere under root. Files are matched by size & then hash, files without a hash are
+// only matched if they're the only missing file & the only file under root with their size. Paths already in the database are skipped.
+//
+// Nothing is changed, see RelinkFile.
+func (d *FileDb) FindMovedFiles(root string) ([]*MovedFile, error) {
+	if d.safeMode {
+		return nil, ErrOutdatedDatabase
+	}
+	st, err := os.Stat(root)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat root: %v", err)
+	}
+	if !st.IsDir() {
+		return nil, errors.New("root isn't a directory")
+	}
+	missing := d.getMissingFiles()
+	if len(missing) == 0 {
+		return make([]*MovedFile, 0), nil
+	}
+	missingSizes := make(map[int64]int)
+	for _, m := range missing {
+		missingSizes[m.size]++
+	}
+	// Only files with the size of a missing file are kept
+	candidates := make(map[int64][]string)
+	err = filepath.WalkDir(root, func(p string, e fs.DirEntry, err error) error {
+		if err != nil {
+			slog.Warn("Failed to walk directory", "Path", p, "Error", err.Error())
+			return nil
+		}
+		if !e.Type().IsRegular() {
+			return nil
+		}
+		info, err := e.Info()
+		if err != nil {
+			slog.Warn("Failed to stat file", "Path", p, "Error", err.Error())
+			return nil
+		}
+		if missingSizes[info.Size()] == 0 {
+			return nil
+		}
+		p = strings.ReplaceAll(p, "\\", "/")
+		// Already imported, it's another file or a copy of it
+		exists := 0
+		slog.Debug("Executing SELECT", "Query", "SELECT COUNT(*) FROM file WHERE path=?", "QueryArgs", []any{p})
+		err = d.db.QueryRow("SELECT COUNT(*) FROM file WHERE path=?", p).Scan(&exists)
+		if err != nil {
+			slog.Error("Failed to count files by path", "Error", err.Error(), "Path", p)
+			panic(fmt.Sprintf("MediaManager: FindMovedFiles: Failed to count files by path: %v", err))
+		}
+		if exists == 0 {
+			candidates[info.Size()] = append(candidates[info.Size()], p)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to walk root: %v", err)
+	}
+	hashes := make(map[string]string)
+	hashOf := func(p string) string {
+		if h, found := hashes[p]; found {
+			return h
+		}
+		f, err := NewFileWithInfo(p)
+		if err != nil {
+			slog.Warn("Failed to hash file", "Path", p, "Error", err.Error())
+			hashes[p] = ""
+			return ""
+		}
+		hashes[p] = f.hash
+		return f.hash
+	}
+	used := make(map[string]bool)
+	moved := make([]*MovedFile, 0)
+	for _, m := range missing {
+		var newPath string
+		byHash := m.hash != ""
+		for _, p := range candidates[m.size] {
+			if used[p] {
+				continue
+			}
+			if !byHash {
+				if len(candidates[m.size]) == 1 && missingSizes[m.size] == 1 {
+					newPath = p
+				}
+				break
+			}
+			if hashOf(p) == m.hash {
+				newPath = p
+				break
+			}
+		}
+		if newPath == "" {
+			continue
+		}
+		f, err := d.GetFileById(m.id)
+		if err != nil {
+			// Removed while we were looking
+			continue
+		}
+		used[newPath] = true
+		moved = append(moved, &MovedFile{File: f, NewPath: newPath, ByHash: byHash})
+	}
+	sort.Slice(moved, func(i, j int) bool {
+		return moved[i].File.path < moved[j].File.path
+	})
+	return moved, nil
+}
+
+// Change the path of a file that was moved on disk, its tags, stars & last viewed times are kept & its missing mark is removed. Files named after their old path are renamed.
+func (d *FileDb) RelinkFile(f *File, path string) error {
+	if d.safeMode {
+		return ErrOutdatedDatabase
+	}
+	if err := isValidFile(f); err != nil {
+		return err
+	}
+	if path == "" {
+		return errors.New("cannot use empty path")
+	}
+	path = strings.ReplaceAll(path, "\\", "/")
+	name := f.name
+	if name == defaultFileName(f.path) {
+		name = defaultFileName(path)
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	tx, err := d.db.Begin()
+	if err != nil {
+		slog.Error("Failed to create new transaction for RelinkFile", "Error", err.Error())
+		return err
+	}
+	defer tx.Rollback()
+	slog.Info("Executing UPDATE", "Query", "UPDATE file SET path=?, name=?, missing=NULL WHERE id=?", "QueryArgs", []any{path, name, f.id})
+	res, err := tx.Exec("UPDATE file SET path=?, name=?, missing=NULL WHERE id=?", path, name, f.id)
+	if err != nil {
+		// This would fail if another file has the path
+		slog.Warn("Failed to update file", "Query", "UPDATE file SET path=?, name=?, missing=NULL WHERE id=?", "QueryArgs", []any{path, name, f.id}, "Error", err.Error())
+		return fmt.Errorf("failed to update file: %v", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		slog.Error("Failed to get rowsAffected", "Error", err.Error(), "Query", "UPDATE file SET path=?, name=?, missing=NULL WHERE id=?")
+		panic(fmt.Sprintf("MediaManager: RelinkFile: .RowsAffected failed, is the database correct?: %v", err))
+	}
+	if n == 0 {
+		return errors.New("file not found")
+	}
+	// The path is no longer a copy of another file
+	slog.Info("Executing DELETE", "Query", "DELETE FROM file_location WHERE path=?", "QueryArgs", []any{path})
+	_, err = tx.Exec("DELETE FROM file_location WHERE path=?", path)
+	if err != nil {
+		slog.Warn("Failed to delete from file_location", "Query", "DELETE FROM file_location WHERE path=?", "QueryArgs", []any{path}, "Error", err.Error())
+		return fmt.Errorf("failed to delete from file_location table: %v", err)
+	}
+	err = tx.Commit()
+	if err != nil {
+		slog.Warn("Failed to commit RelinkFile", "Error", err.Error())
+		return fmt.Errorf("transaction failed to commit: %v", err)
+	}
+	f.path, f.name, f.missing = path, name, time.Time{}
+	return nil
+}
@@ -0,0 +1,115 @@
+package filedb
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Write a file & get it with its hash & size
+func writeTestFile(t *testing.T, path, data string) *File {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+	f, err := NewFileWithInfo(path)
+	if err != nil {
+		t.Fatalf("Failed to hash test file: %v", err)
+	}
+	return f
+}
+
+// Move a file on disk
+func moveTestFile(t *testing.T, from, to string) {
+	if err := os.MkdirAll(filepath.Dir(to), 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	if err := os.Rename(from, to); err != nil {
+		t.Fatalf("Failed to move test file: %v", err)
+	}
+}
+
+func TestRelink(t *testing.T) {
+	db := getTestDb(t)
+	defer db.Close()
+	dir := t.TempDir()
+	old, root := filepath.Join(dir, "old"), filepath.Join(dir, "new")
+	a := writeTestFile(t, filepath.Join(old, "a.mp4"), "aaaa")
+	// Same size as a, different hash
+	b := writeTestFile(t, filepath.Join(old, "b.mp4"), "bbbb")
+	// No hash, a unique size
+	c := writeTestFile(t, filepath.Join(old, "c.mp3"), "cc")
+	c.hash = ""
+	// No hash, a size shared with d2
+	d := writeTestFile(t, filepath.Join(old, "d.png"), "ddddd")
+	d.hash = ""
+	stays := writeTestFile(t, filepath.Join(old, "stays.gif"), "s")
+	lost := writeTestFile(t, filepath.Join(old, "lost.mp4"), "lost")
+	assert.NoErrorf(t, a.AddTag("keep"), "AddTag failed")
+	assert.NoErrorf(t, b.SetName("Custom name"), "SetName failed")
+	failed, err := db.AddFiles(a, b, c, d, stays, lost)
+	if !assert.NoErrorf(t, err, "AddFiles failed") || !assert.Emptyf(t, failed, "Files failed to import") {
+		return
+	}
+	moveTestFile(t, a.path, filepath.Join(root, "x", "a2.mp4"))
+	moveTestFile(t, b.path, filepath.Join(root, "b.mp4"))
+	moveTestFile(t, c.path, filepath.Join(root, "y", "c.mp3"))
+	moveTestFile(t, d.path, filepath.Join(root, "d.png"))
+	writeTestFile(t, filepath.Join(root, "d2.png"), "eeeee")
+	os.Remove(lost.path)
+	// Same size as stays but already in the database
+	os.Remove(stays.path)
+	stays2 := writeTestFile(t, filepath.Join(root, "stays.gif"), "t")
+	if _, err = db.AddFiles(stays2); !assert.NoErrorf(t, err, "AddFiles failed") {
+		return
+	}
+	moved, err := db.FindMovedFiles(root)
+	if !assert.NoErrorf(t, err, "FindMovedFiles failed") {
+		return
+	}
+	found := make(map[int]*MovedFile)
+	for _, v := range moved {
+		found[v.File.id] = v
+	}
+	assert.Lenf(t, moved, 3, "Wrong number of moved files")
+	if assert.Containsf(t, found, a.id, "a wasn't found") {
+		assert.Equalf(t, filepath.Join(root, "x", "a2.mp4"), found[a.id].NewPath, "a was matched to the wrong file")
+		assert.Truef(t, found[a.id].ByHash, "a wasn't matched by hash")
+	}
+	if assert.Containsf(t, found, b.id, "b wasn't found") {
+		assert.Equalf(t, filepath.Join(root, "b.mp4"), found[b.id].NewPath, "b was matched to the wrong file")
+	}
+	if assert.Containsf(t, found, c.id, "c wasn't found") {
+		assert.Falsef(t, found[c.id].ByHash, "c was matched by hash")
+	}
+	assert.NotContainsf(t, found, d.id, "d was matched without a hash when another file had its size")
+	// Nothing was changed
+	f, _ := db.GetFileById(a.id)
+	assert.Equalf(t, a.path, f.GetPath(), "FindMovedFiles changed a path")
+	db.MarkFileMissing(a.path)
+	// Relinking keeps everything else
+	for _, v := range moved {
+		assert.NoErrorf(t, db.RelinkFile(v.File, v.NewPath), "RelinkFile failed")
+	}
+	f, err = db.GetFileById(a.id)
+	if assert.NoErrorf(t, err, "GetFileById failed") {
+		assert.Equalf(t, filepath.Join(root, "x", "a2.mp4"), f.GetPath(), "Path wasn't changed")
+		assert.Equalf(t, "a2.mp4", f.GetName(), "Default name wasn't changed")
+		assert.Equalf(t, []string{"keep"}, f.GetTags(), "Tags weren't kept")
+		_, missing := f.GetMissing()
+		assert.Falsef(t, missing, "Missing mark wasn't removed")
+	}
+	f, _ = db.GetFileById(b.id)
+	assert.Equalf(t, "Custom name", f.GetName(), "Custom name was changed")
+	moved, err = db.FindMovedFiles(root)
+	if assert.NoErrorf(t, err, "FindMovedFiles failed") {
+		assert.Emptyf(t, moved, "Relinked files were found again")
+	}
+	assert.Errorf(t, db.RelinkFile(f, stays2.path), "File was relinked to a path in the database")
+	_, err = db.FindMovedFiles(filepath.Join(root, "d.png"))
+	assert.Errorf(t, err, "A file was used as root")
+}
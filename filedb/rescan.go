@@ -0,0 +1,80 @@
+package filedb
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// What's known about a file on disk, used to tell if it changed since it was imported without hashing it
+type Fingerprint struct {
+	Id      int
+	Size    int64     // 0 if it isn't known
+	ModTime time.Time // Zero if it isn't known
+	Missing bool      // Marked missing, see MarkFileMissing
+}
+
+// Checks if a file with this size & modification time is the same as when the fingerprint was taken, false if it isn't known
+func (fp *Fingerprint) Matches(size int64, modTime time.Time) bool {
+	return fp.Size != 0 && !fp.ModTime.IsZero() && fp.Size == size && fp.ModTime.Equal(modTime)
+}
+
+// Get the fingerprints of every file, by path
+func (d *FileDb) GetFingerprints() (map[string]*Fingerprint, error) {
+	if d.safeMode {
+		return nil, ErrOutdatedDatabase
+	}
+	slog.Debug("Executing SELECT", "Query", "SELECT id, path, size, mtime, missing IS NOT NULL FROM file")
+	rows, err := d.db.Query("SELECT id, path, size, mtime, missing IS NOT NULL FROM file")
+	if err != nil {
+		slog.Error("Failed to execute select query", "Query", "SELECT id, path, size, mtime, missing IS NOT NULL FROM file", "Error", err.Error())
+		panic(fmt.Sprintf("MediaManager: GetFingerprints query failed: %v", err))
+	}
+	defer rows.Close()
+	fingerprints := make(map[string]*Fingerprint)
+	for rows.Next() {
+		fp := &Fingerprint{}
+		var path string
+		var size, mtime sql.NullInt64
+		if err = rows.Scan(&fp.Id, &path, &size, &mtime, &fp.Missing); err != nil {
+			slog.Error("Failed to scan fingerprint", "Error", err.Error())
+			panic(fmt.Sprintf("MediaManager: GetFingerprints: Failed to scan fingerprint, did the structure change?: %v", err))
+		}
+		fp.Size = size.Int64
+		if mtime.Valid {
+			fp.ModTime = time.Unix(0, mtime.Int64)
+		}
+		fingerprints[path] = fp
+	}
+	return fingerprints, nil
+}
+
+// Set the modification times of files by id, without changing anything else. Used for files imported before they were stored.
+func (d *FileDb) SetModTimes(times map[int]time.Time) error {
+	if d.safeMode {
+		return ErrOutdatedDatabase
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	tx, err := d.db.Begin()
+	if err != nil {
+		slog.Error("Failed to create new transaction for SetModTimes", "Error", err.Error())
+		return err
+	}
+	defer tx.Rollback()
+	for id, t := range times {
+		slog.Debug("Executing UPDATE", "Query", "UPDATE file SET mtime=? WHERE id=?", "QueryArgs", []any{t.UnixNano(), id})
+		_, err = tx.Exec("UPDATE file SET mtime=? WHERE id=?", t.UnixNano(), id)
+		if err != nil {
+			slog.Warn("Failed to update file", "Query", "UPDATE file SET mtime=? WHERE id=?", "QueryArgs", []any{t.UnixNano(), id}, "Error", err.Error())
+			return fmt.Errorf("failed to update file: %v", err)
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		slog.Warn("Failed to commit SetModTimes", "Error", err.Error())
+		return fmt.Errorf("transaction failed to commit: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package filedb
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFingerprints(t *testing.T) {
+	db := getTestDb(t)
+	defer db.Close()
+	dir := t.TempDir()
+	a := writeTestFile(t, filepath.Join(dir, "a.mp4"), "aaaa")
+	if !assert.NoErrorf(t, AddInfoToFiles(&AddInfoOpts{DontAddHash: true}, a), "AddInfoToFiles failed") {
+		return
+	}
+	assert.Falsef(t, a.GetModTime().IsZero(), "Modification time wasn't added with the size")
+	// Imported before modification times were stored
+	b := makeTestFile(t, filepath.Join(dir, "b.mp4"))
+	b.SetSize(2)
+	if _, err := db.AddFiles(a, b); !assert.NoErrorf(t, err, "AddFiles failed") {
+		return
+	}
+	db.MarkFileMissing(b.GetPath())
+	fps, err := db.GetFingerprints()
+	if !assert.NoErrorf(t, err, "GetFingerprints failed") || !assert.Lenf(t, fps, 2, "Wrong number of fingerprints") {
+		return
+	}
+	st, _ := os.Stat(a.GetPath())
+	fa, fb := fps[a.GetPath()], fps[b.GetPath()]
+	assert.Equalf(t, a.GetId(), fa.Id, "Wrong id")
+	assert.Truef(t, fa.Matches(st.Size(), st.ModTime()), "Unchanged file didn't match")
+	assert.Falsef(t, fa.Matches(st.Size()+1, st.ModTime()), "File with a new size matched")
+	assert.Falsef(t, fa.Matches(st.Size(), st.ModTime().Add(time.Second)), "File with a new modification time matched")
+	assert.Falsef(t, fa.Missing, "File was missing")
+	assert.Truef(t, fb.Missing, "File wasn't missing")
+	assert.Falsef(t, fb.Matches(2, time.Time{}), "File without a modification time matched")
+	mod := time.Unix(1700000000, 123)
+	assert.NoErrorf(t, db.SetModTimes(map[int]time.Time{b.GetId(): mod}), "SetModTimes failed")
+	fps, _ = db.GetFingerprints()
+	assert.Truef(t, fps[b.GetPath()].Matches(2, mod), "Modification time wasn't set")
+	f, err := db.GetFileById(b.GetId())
+	if assert.NoErrorf(t, err, "GetFileById failed") {
+		assert.Truef(t, mod.Equal(f.GetModTime()), "Modification time wasn't loaded")
+	}
+}
@@ -0,0 +1,314 @@
+package filedb
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// A named search belonging to a user, it's stored as a query (See QueryNode) and evaluated when it is run so results follow changes to files.
+type SavedSearch struct {
+	id               int
+	userId           int
+	name             string
+	query            string
+	sortBy           SortMethod
+	sortReverse      bool
+	ignoreUnsetStars bool // See SearchQuery.IgnoreUnsetStars
+	created          time.Time
+}
+
+func (s *SavedSearch) GetId() int {
+	return s.id
+}
+
+// Id of the user this search belongs to, stars & last viewed times are theirs
+func (s *SavedSearch) GetUserId() int {
+	return s.userId
+}
+
+func (s *SavedSearch) GetName() string {
+	return s.name
+}
+
+// Set the name, this is not updated until FileDb.UpdateSavedSearch is called
+func (s *SavedSearch) SetName(name string) error {
+	if name == "" {
+		return errors.New("name cannot be empty")
+	}
+	s.name = name
+	return nil
+}
+
+// Query the search runs, see QueryNode for the syntax
+func (s *SavedSearch) GetQuery() string {
+	return s.query
+}
+
+// Set the query, it must be valid. This is not updated until FileDb.UpdateSavedSearch is called
+func (s *SavedSearch) SetQuery(query string) error {
+	if _, err := ParseQuery(query); err != nil {
+		return err
+	}
+	s.query = query
+	return nil
+}
+
+func (s *SavedSearch) GetSortBy() SortMethod {
+	return s.sortBy
+}
+
+func (s *SavedSearch) GetSortReverse() bool {
+	return s.sortReverse
+}
+
+// Set how results are sorted, this is not updated until FileDb.UpdateSavedSearch is called
+func (s *SavedSearch) SetSort(sortBy SortMethod, reverse bool) error {
+	if _, found := sortMethodNames[sortBy]; !found {
+		return fmt.Errorf("invalid sort method %d", sortBy)
+	}
+	s.sortBy = sortBy
+	s.sortReverse = reverse
+	return nil
+}
+
+// SortMethodAverageStars ignores users who haven't starred a file, see SearchQuery.IgnoreUnsetStars
+func (s *SavedSearch) GetIgnoreUnsetStars() bool {
+	return s.ignoreUnsetStars
+}
+
+// Set if SortMethodAverageStars ignores unset stars, this is not updated until FileDb.UpdateSavedSearch is called
+func (s *SavedSearch) SetIgnoreUnsetStars(ignore bool) {
+	s.ignoreUnsetStars = ignore
+}
+
+// Time the search was saved
+func (s *SavedSearch) GetCreated() time.Time {
+	return s.created
+}
+
+// The search as a SearchQuery for its user, count & index are the same as SearchQuery.Count & SearchQuery.Index
+func (s *SavedSearch) ToSearchQuery(count int64, index int64) *SearchQuery {
+	return &SearchQuery{
+		Query:            s.query,
+		SortBy:           s.sortBy,
+		SortReverse:      s.sortReverse,
+		IgnoreUnsetStars: s.ignoreUnsetStars,
+		UserId:           s.userId,
+		Count:            count,
+		Index:            index,
+	}
+}
+
+// Convert the filters of a SearchQuery to a query, Count, Index, sorting (Including IgnoreUnsetStars) & UserId aren't part of the query.
+func (q *SearchQuery) ToQuery() string {
+	nodes := make([]QueryNode, 0)
+	if q.Path != "" {
+		nodes = append(nodes, &QueryTerm{Field: "path", Op: ":", Value: q.Path})
+	}
+	if q.PathRe != "" {
+		nodes = append(nodes, &QueryTerm{Field: "re", Op: ":", Value: q.PathRe})
+	}
+	for _, v := range q.WhitelistTags {
+		nodes = append(nodes, &QueryTerm{Field: "tag", Op: ":", Value: v})
+	}
+	for _, v := range q.BlacklistTags {
+		nodes = append(nodes, &QueryNot{Node: &QueryTerm{Field: "tag", Op: ":", Value: v}})
+	}
+	switch q.Hash {
+	case "":
+	case "NULL":
+		nodes = append(nodes, &QueryTerm{Field: "hash", Op: ":", Value: "none"})
+	default:
+		nodes = append(nodes, &QueryTerm{Field: "hash", Op: ":", Value: q.Hash})
+	}
+	for _, v := range parseSearchText(q.Text) {
+		nodes = append(nodes, &QueryTerm{Value: v.Value, Prefix: v.Prefix})
+	}
+	query := (&QueryAnd{Nodes: nodes}).String()
+	if q.Query == "" {
+		return query
+	} else if query == "" {
+		return q.Query
+	}
+	return query + " (" + q.Query + ")"
+}
+
+// Don't call .Next before calling this function or you will lose a search.
+func (d *FileDb) sqlRowsToSavedSearches(r *sql.Rows) []*SavedSearch {
+	searches := make([]*SavedSearch, 0)
+	for r.Next() {
+		s := &SavedSearch{}
+		created := int64(0)
+		err := r.Scan(&s.id, &s.userId, &s.name, &s.query, &s.sortBy, &s.sortReverse, &s.ignoreUnsetStars, &created)
+		if err != nil {
+			// This can only happen if the database structure has changed.
+			slog.Error("Failed to scan from SavedSearch rows", "Error", err.Error())
+			panic(fmt.Sprintf("MediaManager: sqlRowsToSavedSearches: Scanning from saved_search rows failed: %v", err))
+		}
+		s.created = time.Unix(created, 0)
+		searches = append(searches, s)
+	}
+	return searches
+}
+
+// Save a search for a user, the filters of q are saved as a query (See SearchQuery.ToQuery) along with its sorting.
+//
+// The name must be unique for the user.
+func (d *FileDb) AddSavedSearch(u *User, name string, q *SearchQuery) (*SavedSearch, error) {
+	if d.safeMode {
+		return nil, ErrOutdatedDatabase
+	}
+	if err := isValidUser(u); err != nil {
+		return nil, err
+	}
+	if q == nil {
+		return nil, errors.New("query cannot be nil")
+	}
+	s := &SavedSearch{
+		userId:  u.id,
+		created: time.Unix(time.Now().Unix(), 0),
+	}
+	if err := s.SetName(name); err != nil {
+		return nil, err
+	}
+	if err := s.SetQuery(q.ToQuery()); err != nil {
+		return nil, err
+	}
+	if err := s.SetSort(q.SortBy, q.SortReverse); err != nil {
+		return nil, err
+	}
+	s.SetIgnoreUnsetStars(q.IgnoreUnsetStars)
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	slog.Info("Executing INSERT", "Query", "INSERT INTO saved_search(userId, name, query, sortBy, sortReverse, ignoreUnsetStars, created) VALUES (?, ?, ?, ?, ?, ?, ?)", "QueryArgs", []any{s.userId, s.name, s.query, s.sortBy, s.sortReverse, s.ignoreUnsetStars, s.created.Unix()})
+	res, err := d.db.Exec("INSERT INTO saved_search(userId, name, query, sortBy, sortReverse, ignoreUnsetStars, created) VALUES (?, ?, ?, ?, ?, ?, ?)", s.userId, s.name, s.query, s.sortBy, s.sortReverse, s.ignoreUnsetStars, s.created.Unix())
+	if err != nil {
+		// This would fail if the name isn't unique for the user
+		slog.Info("Failed to insert into saved_search", "Query", "INSERT INTO saved_search(userId, name, query, sortBy, sortReverse, ignoreUnsetStars, created) VALUES (?, ?, ?, ?, ?, ?, ?)", "Error", err.Error())
+		return nil, fmt.Errorf("failed to insert into saved_search table: %v", err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		slog.Error("Failed to get lastInsertId", "Error", err.Error(), "Query", "INSERT INTO saved_search(userId, name, query, sortBy, sortReverse, ignoreUnsetStars, created) VALUES (?, ?, ?, ?, ?, ?, ?)")
+		panic(fmt.Sprintf("MediaManager: AddSavedSearch: .LastInsertId failed to get id, is the database correct?: %v", err))
+	}
+	s.id = int(id)
+	return s, nil
+}
+
+// Get a saved search of a user by name
+func (d *FileDb) GetSavedSearch(u *User, name string) (*SavedSearch, error) {
+	if d.safeMode {
+		return nil, ErrOutdatedDatabase
+	}
+	if err := isValidUser(u); err != nil {
+		return nil, err
+	}
+	slog.Debug("Executing SELECT", "Query", "SELECT id, userId, name, query, sortBy, sortReverse, ignoreUnsetStars, created FROM saved_search WHERE userId=? AND name=?", "QueryArgs", []any{u.id, name})
+	rows, err := d.db.Query("SELECT id, userId, name, query, sortBy, sortReverse, ignoreUnsetStars, created FROM saved_search WHERE userId=? AND name=?", u.id, name)
+	if err != nil {
+		slog.Error("Failed to execute select query", "Query", "SELECT id, userId, name, query, sortBy, sortReverse, ignoreUnsetStars, created FROM saved_search WHERE userId=? AND name=?", "QueryArgs", []any{u.id, name}, "Error", err.Error())
+		panic(fmt.Sprintf("MediaManager: GetSavedSearch query failed: %v", err))
+	}
+	defer rows.Close()
+	searches := d.sqlRowsToSavedSearches(rows)
+	if len(searches) == 0 {
+		return nil, errors.New("saved search not found")
+	}
+	return searches[0], nil
+}
+
+// Get a saved search by ID
+func (d *FileDb) GetSavedSearchById(id int) (*SavedSearch, error) {
+	if d.safeMode {
+		return nil, ErrOutdatedDatabase
+	}
+	slog.Debug("Executing SELECT", "Query", "SELECT id, userId, name, query, sortBy, sortReverse, ignoreUnsetStars, created FROM saved_search WHERE id=?", "QueryArgs", []any{id})
+	rows, err := d.db.Query("SELECT id, userId, name, query, sortBy, sortReverse, ignoreUnsetStars, created FROM saved_search WHERE id=?", id)
+	if err != nil {
+		slog.Error("Failed to execute select query", "Query", "SELECT id, userId, name, query, sortBy, sortReverse, ignoreUnsetStars, created FROM saved_search WHERE id=?", "QueryArgs", []any{id}, "Error", err.Error())
+		panic(fmt.Sprintf("MediaManager: GetSavedSearchById query failed: %v", err))
+	}
+	defer rows.Close()
+	searches := d.sqlRowsToSavedSearches(rows)
+	if len(searches) == 0 {
+		return nil, errors.New("saved search not found")
+	}
+	return searches[0], nil
+}
+
+// Get every saved search of a user, ordered by name
+func (d *FileDb) GetSavedSearches(u *User) ([]*SavedSearch, error) {
+	if d.safeMode {
+		return nil, ErrOutdatedDatabase
+	}
+	if err := isValidUser(u); err != nil {
+		return nil, err
+	}
+	slog.Debug("Executing SELECT", "Query", "SELECT id, userId, name, query, sortBy, sortReverse, ignoreUnsetStars, created FROM saved_search WHERE userId=? ORDER BY name", "QueryArgs", []any{u.id})
+	rows, err := d.db.Query("SELECT id, userId, name, query, sortBy, sortReverse, ignoreUnsetStars, created FROM saved_search WHERE userId=? ORDER BY name", u.id)
+	if err != nil {
+		slog.Error("Failed to execute select query", "Query", "SELECT id, userId, name, query, sortBy, sortReverse, ignoreUnsetStars, created FROM saved_search WHERE userId=? ORDER BY name", "QueryArgs", []any{u.id}, "Error", err.Error())
+		panic(fmt.Sprintf("MediaManager: GetSavedSearches query failed: %v", err))
+	}
+	defer rows.Close()
+	return d.sqlRowsToSavedSearches(rows), nil
+}
+
+// Update the name, query & sorting of a saved search
+func (d *FileDb) UpdateSavedSearch(s *SavedSearch) error {
+	if d.safeMode {
+		return ErrOutdatedDatabase
+	}
+	if s == nil || s.id == 0 {
+		return errors.New("invalid saved search")
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	slog.Info("Executing UPDATE", "Query", "UPDATE saved_search SET name=?, query=?, sortBy=?, sortReverse=?, ignoreUnsetStars=? WHERE id=?", "QueryArgs", []any{s.name, s.query, s.sortBy, s.sortReverse, s.ignoreUnsetStars, s.id})
+	res, err := d.db.Exec("UPDATE saved_search SET name=?, query=?, sortBy=?, sortReverse=?, ignoreUnsetStars=? WHERE id=?", s.name, s.query, s.sortBy, s.sortReverse, s.ignoreUnsetStars, s.id)
+	if err != nil {
+		// This would fail if the name isn't unique for the user
+		slog.Warn("Failed to update saved search", "Query", "UPDATE saved_search SET name=?, query=?, sortBy=?, sortReverse=?, ignoreUnsetStars=? WHERE id=?", "QueryArgs", []any{s.name, s.query, s.sortBy, s.sortReverse, s.ignoreUnsetStars, s.id}, "Error", err.Error())
+		return fmt.Errorf("failed to update saved_search table: %v", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return errors.New("saved search not found")
+	}
+	return nil
+}
+
+// Remove a saved search
+func (d *FileDb) RemoveSavedSearch(s *SavedSearch) error {
+	if d.safeMode {
+		return ErrOutdatedDatabase
+	}
+	if s == nil || s.id == 0 {
+		return errors.New("invalid saved search")
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	slog.Info("Executing DELETE", "Query", "DELETE FROM saved_search WHERE id=?", "QueryArgs", []any{s.id})
+	res, err := d.db.Exec("DELETE FROM saved_search WHERE id=?", s.id)
+	if err != nil {
+		slog.Warn("Failed to delete saved search", "Query", "DELETE FROM saved_search WHERE id=?", "QueryArgs", []any{s.id}, "Error", err.Error())
+		return fmt.Errorf("failed to remove from saved_search table: %v", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return errors.New("saved search not found")
+	}
+	// Invalidate the search
+	s.id = 0
+	return nil
+}
+
+// Run a saved search for its user, count & index are the same as SearchQuery.Count & SearchQuery.Index
+func (d *FileDb) RunSavedSearch(s *SavedSearch, count int64, index int64) ([]*File, error) {
+	if s == nil || s.id == 0 {
+		return nil, errors.New("invalid saved search")
+	}
+	return d.SearchFile(s.ToSearchQuery(count, index))
+}
@@ -0,0 +1,104 @@
+package filedb
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSearchQueryToQuery(t *testing.T) {
+	for _, v := range []struct {
+		Query    *SearchQuery
+		Expected string
+	}{
+		{&SearchQuery{}, ""},
+		{&SearchQuery{Path: "/tv/", WhitelistTags: []string{"anime"}, BlacklistTags: []string{"watched"}}, "path:/tv/ tag:anime -tag:watched"},
+		{&SearchQuery{PathRe: `^/a b/`, Hash: "NULL", Text: `"big cat" docu* -x`}, `re:"^/a b/" hash:none "big cat" docu* "-x"`},
+		{&SearchQuery{Text: "OR tag:x", Query: "stars>=4 OR size<1GB"}, `"OR" "tag:x" (stars>=4 OR size<1GB)`},
+		{&SearchQuery{Query: "stars>=4 OR size<1GB"}, "stars>=4 OR size<1GB"},
+	} {
+		q := v.Query.ToQuery()
+		assert.Equalf(t, v.Expected, q, "Wrong query for %+v", *v.Query)
+		_, err := ParseQuery(q)
+		assert.NoErrorf(t, err, "ToQuery made a invalid query '%s'", q)
+	}
+}
+
+func TestSavedSearch(t *testing.T) {
+	db := getTestDb(t)
+	defer db.Close()
+	u := getTestUser(t, db)
+	s, err := db.AddSavedSearch(u, "4+ star videos", &SearchQuery{Query: "stars>=4 path:.mp4", SortBy: SortMethodStars, SortReverse: true})
+	if !assert.NoErrorf(t, err, "AddSavedSearch failed") {
+		return
+	}
+	_, err = db.AddSavedSearch(u, "4+ star videos", &SearchQuery{})
+	assert.Errorf(t, err, "Added saved search with a duplicate name")
+	_, err = db.AddSavedSearch(u, "bad", &SearchQuery{Query: "(stars>=4"})
+	assert.Errorf(t, err, "Added saved search with a invalid query")
+	unrated, err := db.AddSavedSearch(u, "unrated", &SearchQuery{Query: "stars:0"})
+	if !assert.NoErrorf(t, err, "AddSavedSearch failed") {
+		return
+	}
+	fromDb, err := db.GetSavedSearch(u, "4+ star videos")
+	if assert.NoErrorf(t, err, "GetSavedSearch failed") {
+		assert.Equalf(t, s, fromDb, "GetSavedSearch returned the wrong search")
+	}
+	searches, err := db.GetSavedSearches(u)
+	if assert.NoErrorf(t, err, "GetSavedSearches failed") {
+		assert.Equalf(t, []*SavedSearch{s, unrated}, searches, "Wrong saved searches")
+	}
+	// Results follow changes to files
+	f1 := makeTestFile(t, "/a.mp4")
+	f2 := makeTestFile(t, "/b.mp4")
+	if _, err := db.AddFiles(f1, f2); !assert.NoErrorf(t, err, "AddFiles failed") {
+		return
+	}
+	fs, err := db.RunSavedSearch(s, -1, 0)
+	assert.NoErrorf(t, err, "RunSavedSearch failed")
+	assert.Emptyf(t, fs, "Unstarred files were found")
+	assert.NoErrorf(t, db.LoadUserData(u, f1, f2), "LoadUserData failed")
+	assert.NoErrorf(t, f1.SetStars(4), "SetStars failed")
+	assert.NoErrorf(t, f2.SetStars(5), "SetStars failed")
+	assert.NoErrorf(t, db.UpdateFile(f1), "UpdateFile failed")
+	assert.NoErrorf(t, db.UpdateFile(f2), "UpdateFile failed")
+	fs, err = db.RunSavedSearch(s, -1, 0)
+	if assert.NoErrorf(t, err, "RunSavedSearch failed") && assert.Len(t, fs, 2) {
+		assert.Equalf(t, []int{f2.GetId(), f1.GetId()}, []int{fs[0].GetId(), fs[1].GetId()}, "Saved sort wasn't used")
+	}
+	// Update
+	assert.Errorf(t, s.SetQuery("stars>"), "SetQuery accepted a invalid query")
+	assert.NoErrorf(t, s.SetQuery("stars=5"), "SetQuery failed")
+	assert.NoErrorf(t, s.SetName("5 star videos"), "SetName failed")
+	assert.NoErrorf(t, db.UpdateSavedSearch(s), "UpdateSavedSearch failed")
+	fs, err = db.RunSavedSearch(s, -1, 0)
+	if assert.NoErrorf(t, err, "RunSavedSearch failed") && assert.Len(t, fs, 1) {
+		assert.Equalf(t, f2.GetId(), fs[0].GetId(), "Updated query wasn't used")
+	}
+	// Sorting by average stars keeps if unset stars are ignored
+	popular, err := db.AddSavedSearch(u, "popular", &SearchQuery{SortBy: SortMethodAverageStars, IgnoreUnsetStars: true})
+	if assert.NoErrorf(t, err, "AddSavedSearch failed") {
+		fromDb, err := db.GetSavedSearchById(popular.GetId())
+		if assert.NoErrorf(t, err, "GetSavedSearchById failed") {
+			assert.Truef(t, fromDb.GetIgnoreUnsetStars(), "IgnoreUnsetStars wasn't saved")
+			assert.Truef(t, fromDb.ToSearchQuery(50, 0).IgnoreUnsetStars, "IgnoreUnsetStars wasn't used")
+		}
+		popular.SetIgnoreUnsetStars(false)
+		assert.NoErrorf(t, db.UpdateSavedSearch(popular), "UpdateSavedSearch failed")
+		fromDb, err = db.GetSavedSearchById(popular.GetId())
+		if assert.NoErrorf(t, err, "GetSavedSearchById failed") {
+			assert.Falsef(t, fromDb.GetIgnoreUnsetStars(), "IgnoreUnsetStars wasn't updated")
+		}
+	}
+	assert.NoErrorf(t, unrated.SetName("5 star videos"), "SetName failed")
+	assert.Errorf(t, db.UpdateSavedSearch(unrated), "Renamed saved search to a duplicate name")
+	// Remove
+	assert.NoErrorf(t, db.RemoveSavedSearch(s), "RemoveSavedSearch failed")
+	assert.Errorf(t, db.RemoveSavedSearch(s), "RemoveSavedSearch worked on a removed search")
+	_, err = db.GetSavedSearch(u, "5 star videos")
+	assert.Errorf(t, err, "Removed saved search was found")
+	// Removing a user removes their saved searches
+	assert.NoErrorf(t, db.RemoveUser(u), "RemoveUser failed")
+	_, err = db.GetSavedSearchById(unrated.GetId())
+	assert.Errorf(t, err, "Saved search of removed user was found")
+}
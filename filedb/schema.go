@@ -0,0 +1,235 @@
+package filedb
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+)
+
+// A table (or anything else created with a CREATE statement) that is part of the database
+type schemaEntry struct {
+	Name  string // Name of the table, used for logging
+	Query string // CREATE statement
+}
+
+// The current database structure, entries are created in order.
+//
+// Any change to this must come with a migration in update.go
+var schema = []schemaEntry{
+	{
+		Name: "db_info",
+		Query: `CREATE TABLE db_info (
+		key TEXT UNIQUE NOT NULL,
+		value ANY
+		) STRICT`,
+	},
+	{
+		Name: "file",
+		Query: `CREATE TABLE file (
+		id INTEGER PRIMARY KEY AUTOINCREMENT UNIQUE NOT NULL,
+		path TEXT NOT NULL UNIQUE,
+		name TEXT NOT NULL,
+		size INTEGER,
+		hash TEXT UNIQUE,
+		phash INTEGER,
+		missing INTEGER,
+		mtime INTEGER,
+		mime TEXT,
+		category TEXT,
+		CHECK(length(name) > 0),
+		CHECK(hash IS NULL OR length(hash) == 64)
+		) STRICT`,
+	},
+	{
+		Name: "tag_name",
+		Query: `CREATE TABLE tag_name (
+		id INTEGER PRIMARY KEY UNIQUE NOT NULL,
+		value TEXT NOT NULL UNIQUE
+		) STRICT`,
+	},
+	{
+		Name: "tag",
+		Query: `CREATE TABLE tag (
+		fileId INTEGER NOT NULL,
+		tagNameId INTEGER NOT NULL,
+		FOREIGN KEY (fileId) REFERENCES file(id),
+		FOREIGN KEY (tagNameId) REFERENCES tag_name(id),
+		UNIQUE(fileId, tagNameId)
+		) STRICT`,
+	},
+	{
+		Name: "user",
+		Query: `CREATE TABLE user (
+		id INTEGER PRIMARY KEY AUTOINCREMENT UNIQUE NOT NULL,
+		name TEXT NOT NULL UNIQUE,
+		accountType INTEGER NOT NULL,
+		created INTEGER NOT NULL,
+		password TEXT,
+		CHECK(length(name) > 0),
+		CHECK(accountType >= 0 AND accountType <= 3)
+		) STRICT`,
+	},
+	{
+		Name: "user_file",
+		Query: `CREATE TABLE user_file (
+		userId INTEGER NOT NULL,
+		fileId INTEGER NOT NULL,
+		stars INTEGER NOT NULL,
+		lastViewed INTEGER NOT NULL,
+		FOREIGN KEY (userId) REFERENCES user(id),
+		FOREIGN KEY (fileId) REFERENCES file(id),
+		UNIQUE(userId, fileId),
+		CHECK(stars >= 0 AND stars <= 5)
+		) STRICT`,
+	},
+	{
+		Name: "api_key",
+		Query: `CREATE TABLE api_key (
+		id INTEGER PRIMARY KEY AUTOINCREMENT UNIQUE NOT NULL,
+		userId INTEGER NOT NULL,
+		name TEXT NOT NULL,
+		hash TEXT NOT NULL UNIQUE,
+		permissions INTEGER NOT NULL,
+		created INTEGER NOT NULL,
+		FOREIGN KEY (userId) REFERENCES user(id),
+		UNIQUE(userId, name),
+		CHECK(length(name) > 0),
+		CHECK(length(hash) == 64)
+		) STRICT`,
+	},
+	{
+		Name: "session",
+		Query: `CREATE TABLE session (
+		id INTEGER PRIMARY KEY AUTOINCREMENT UNIQUE NOT NULL,
+		userId INTEGER NOT NULL,
+		hash TEXT NOT NULL UNIQUE,
+		created INTEGER NOT NULL,
+		lastSeen INTEGER NOT NULL,
+		ip TEXT NOT NULL,
+		userAgent TEXT NOT NULL,
+		FOREIGN KEY (userId) REFERENCES user(id),
+		CHECK(length(hash) == 64)
+		) STRICT`,
+	},
+	{
+		Name: "saved_search",
+		Query: `CREATE TABLE saved_search (
+		id INTEGER PRIMARY KEY AUTOINCREMENT UNIQUE NOT NULL,
+		userId INTEGER NOT NULL,
+		name TEXT NOT NULL,
+		query TEXT NOT NULL,
+		sortBy INTEGER NOT NULL,
+		sortReverse INTEGER NOT NULL,
+		ignoreUnsetStars INTEGER NOT NULL DEFAULT 0,
+		created INTEGER NOT NULL,
+		FOREIGN KEY (userId) REFERENCES user(id),
+		UNIQUE(userId, name),
+		CHECK(length(name) > 0)
+		) STRICT`,
+	},
+	{
+		Name: "collection",
+		Query: `CREATE TABLE collection (
+		id INTEGER PRIMARY KEY AUTOINCREMENT UNIQUE NOT NULL,
+		name TEXT NOT NULL UNIQUE,
+		description TEXT NOT NULL,
+		coverFileId INTEGER,
+		created INTEGER NOT NULL,
+		FOREIGN KEY (coverFileId) REFERENCES file(id),
+		CHECK(length(name) > 0)
+		) STRICT`,
+	},
+	{
+		Name: "collection_item",
+		Query: `CREATE TABLE collection_item (
+		collectionId INTEGER NOT NULL,
+		fileId INTEGER NOT NULL,
+		position INTEGER NOT NULL,
+		FOREIGN KEY (collectionId) REFERENCES collection(id),
+		FOREIGN KEY (fileId) REFERENCES file(id),
+		UNIQUE(collectionId, fileId),
+		UNIQUE(collectionId, position)
+		) STRICT`,
+	},
+	{
+		Name: "tag_implication",
+		Query: `CREATE TABLE tag_implication (
+		id INTEGER PRIMARY KEY UNIQUE NOT NULL,
+		tag TEXT NOT NULL,
+		implies TEXT NOT NULL,
+		CHECK(length(tag) > 0 AND length(implies) > 0 AND tag != implies),
+		UNIQUE(tag, implies)
+		) STRICT`,
+	},
+	{
+		Name: "tag_alias",
+		Query: `CREATE TABLE tag_alias (
+		id INTEGER PRIMARY KEY UNIQUE NOT NULL,
+		alias TEXT NOT NULL UNIQUE,
+		tag TEXT NOT NULL,
+		CHECK(length(alias) > 0 AND length(tag) > 0 AND alias != tag)
+		) STRICT`,
+	},
+	{
+		Name: "file_meta",
+		Query: `CREATE TABLE file_meta (
+		fileId INTEGER PRIMARY KEY UNIQUE NOT NULL,
+		title TEXT,
+		artist TEXT,
+		album TEXT,
+		track INTEGER,
+		camera TEXT,
+		taken INTEGER,
+		width INTEGER,
+		height INTEGER,
+		duration REAL,
+		latitude REAL,
+		longitude REAL,
+		FOREIGN KEY (fileId) REFERENCES file(id)
+		) STRICT`,
+	},
+	{
+		Name: "file_location",
+		Query: `CREATE TABLE file_location (
+		id INTEGER PRIMARY KEY AUTOINCREMENT UNIQUE NOT NULL,
+		fileId INTEGER NOT NULL,
+		path TEXT NOT NULL UNIQUE,
+		added INTEGER NOT NULL,
+		FOREIGN KEY (fileId) REFERENCES file(id)
+		) STRICT`,
+	},
+}
+
+// Create every entry in the schema
+func createSchema(tx *sql.Tx) error {
+	for _, v := range schema {
+		slog.Info("Creating table", "Name", v.Name)
+		_, err := tx.Exec(v.Query)
+		if err != nil {
+			slog.Error("Failed to create table", "Name", v.Name, "Error", err.Error())
+			return fmt.Errorf("failed to create '%s' table: %v", v.Name, err)
+		}
+	}
+	return nil
+}
+
+// Create every entry in the schema that doesn't exist yet, used to add new tables when migrating
+func createMissingSchema(tx *sql.Tx) error {
+	for _, v := range schema {
+		exists := 0
+		err := tx.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE name=?", v.Name).Scan(&exists)
+		if err != nil {
+			return fmt.Errorf("failed to check for '%s' table: %v", v.Name, err)
+		}
+		if exists != 0 {
+			continue
+		}
+		slog.Info("Creating table", "Name", v.Name)
+		_, err = tx.Exec(v.Query)
+		if err != nil {
+			slog.Error("Failed to create table", "Name", v.Name, "Error", err.Error())
+			return fmt.Errorf("failed to create '%s' table: %v", v.Name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,242 @@
+package filedb
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+)
+
+// Full text search index over file paths, names & tags, it is kept in sync by searchIndexTriggers.
+//
+// This requires SQLite to be built with FTS5 (The 'sqlite_fts5' build tag), without it text searches use LIKE instead.
+const searchIndexTable = `CREATE VIRTUAL TABLE IF NOT EXISTS file_search USING fts5(
+		path,
+		name,
+		tags,
+		tokenize = 'unicode61 remove_diacritics 2',
+		prefix = '2 3'
+		)`
+
+// Tags of file id 'ID' joined by spaces
+const searchIndexTags = "COALESCE((SELECT group_concat(tn.value, ' ') FROM tag t JOIN tag_name tn ON tn.id = t.tagNameId WHERE t.fileId = ID), '')"
+
+// Triggers that keep file_search in sync with file, tag & tag_name
+var searchIndexTriggers = []schemaEntry{
+	{
+		Name: "file_search_insert",
+		Query: `CREATE TRIGGER file_search_insert AFTER INSERT ON file BEGIN
+		INSERT INTO file_search(rowid, path, name, tags) VALUES (new.id, new.path, new.name, '');
+		END`,
+	},
+	{
+		Name: "file_search_update",
+		Query: `CREATE TRIGGER file_search_update AFTER UPDATE OF path, name ON file BEGIN
+		UPDATE file_search SET path = new.path, name = new.name WHERE rowid = new.id;
+		END`,
+	},
+	{
+		Name: "file_search_delete",
+		Query: `CREATE TRIGGER file_search_delete AFTER DELETE ON file BEGIN
+		DELETE FROM file_search WHERE rowid = old.id;
+		END`,
+	},
+	{
+		Name: "file_search_tag_insert",
+		Query: `CREATE TRIGGER file_search_tag_insert AFTER INSERT ON tag BEGIN
+		UPDATE file_search SET tags = ` + strings.ReplaceAll(searchIndexTags, "ID", "new.fileId") + ` WHERE rowid = new.fileId;
+		END`,
+	},
+	{
+		Name: "file_search_tag_delete",
+		Query: `CREATE TRIGGER file_search_tag_delete AFTER DELETE ON tag BEGIN
+		UPDATE file_search SET tags = ` + strings.ReplaceAll(searchIndexTags, "ID", "old.fileId") + ` WHERE rowid = old.fileId;
+		END`,
+	},
+	{
+		Name: "file_search_tag_name_update",
+		Query: `CREATE TRIGGER file_search_tag_name_update AFTER UPDATE OF value ON tag_name BEGIN
+		UPDATE file_search SET tags = ` + strings.ReplaceAll(searchIndexTags, "ID", "file_search.rowid") + ` WHERE rowid IN (SELECT fileId FROM tag WHERE tagNameId = new.id);
+		END`,
+	},
+}
+
+// Checks if SQLite was built with FTS5
+func (d *FileDb) hasFts5() bool {
+	enabled := false
+	err := d.db.QueryRow("SELECT sqlite_compileoption_used('ENABLE_FTS5')").Scan(&enabled)
+	if err != nil {
+		slog.Warn("Failed to check for FTS5", "Error", err.Error())
+		return false
+	}
+	return enabled
+}
+
+// Create the full text search index if FTS5 is available, the index is rebuilt if it may be out of date.
+//
+// Without FTS5 the triggers are removed, otherwise they would fail every change to file, tag & tag_name.
+func (d *FileDb) setupSearchIndex() error {
+	if d.safeMode {
+		return ErrOutdatedDatabase
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.fullText = d.hasFts5()
+	tx, err := d.db.Begin()
+	if err != nil {
+		slog.Error("Failed to create new transaction for setupSearchIndex", "Error", err.Error())
+		return err
+	}
+	if !d.fullText {
+		slog.Info("SQLite doesn't have FTS5, text search will be slow")
+		for _, v := range searchIndexTriggers {
+			_, err = tx.Exec("DROP TRIGGER IF EXISTS " + v.Name)
+			if err != nil {
+				tx.Rollback()
+				slog.Error("Failed to drop search index trigger", "Name", v.Name, "Error", err.Error())
+				return fmt.Errorf("failed to drop '%s' trigger: %v", v.Name, err)
+			}
+		}
+		return tx.Commit()
+	}
+	_, err = tx.Exec(searchIndexTable)
+	if err != nil {
+		tx.Rollback()
+		slog.Error("Failed to create file_search table", "Error", err.Error())
+		return fmt.Errorf("failed to create 'file_search' table: %v", err)
+	}
+	// The index is only up to date if every trigger was there
+	missing := 0
+	for _, v := range searchIndexTriggers {
+		found := 0
+		err = tx.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'trigger' AND name = ?", v.Name).Scan(&found)
+		if err != nil {
+			tx.Rollback()
+			slog.Error("Failed to check for trigger", "Name", v.Name, "Error", err.Error())
+			return fmt.Errorf("failed to check for '%s' trigger: %v", v.Name, err)
+		}
+		if found != 0 {
+			continue
+		}
+		missing++
+		slog.Info("Creating trigger", "Name", v.Name)
+		_, err = tx.Exec(v.Query)
+		if err != nil {
+			tx.Rollback()
+			slog.Error("Failed to create trigger", "Name", v.Name, "Error", err.Error())
+			return fmt.Errorf("failed to create '%s' trigger: %v", v.Name, err)
+		}
+	}
+	if missing != 0 {
+		slog.Info("Rebuilding search index")
+		_, err = tx.Exec("DELETE FROM file_search")
+		if err == nil {
+			_, err = tx.Exec("INSERT INTO file_search(rowid, path, name, tags) SELECT f.id, f.path, f.name, " + strings.ReplaceAll(searchIndexTags, "ID", "f.id") + " FROM file f")
+		}
+		if err != nil {
+			tx.Rollback()
+			slog.Error("Failed to rebuild search index", "Error", err.Error())
+			return fmt.Errorf("failed to rebuild search index: %v", err)
+		}
+	}
+	return tx.Commit()
+}
+
+// A term of a text search
+type searchTerm struct {
+	Value  string
+	Prefix bool // Match anything starting with Value, set with a trailing '*'
+}
+
+// Split a text search into terms, terms are separated by spaces unless they are in double quotes (A phrase).
+func parseSearchText(text string) []searchTerm {
+	terms := make([]searchTerm, 0)
+	current := strings.Builder{}
+	inPhrase := false
+	add := func(prefix bool) {
+		v := strings.TrimSpace(current.String())
+		current.Reset()
+		if v != "" {
+			terms = append(terms, searchTerm{Value: v, Prefix: prefix})
+		}
+	}
+	runes := []rune(text)
+	for i := 0; i < len(runes); i++ {
+		c := runes[i]
+		switch {
+		case c == '"':
+			if inPhrase {
+				// A '*' directly after the phrase makes it a prefix
+				prefix := i+1 < len(runes) && runes[i+1] == '*'
+				if prefix {
+					i++
+				}
+				add(prefix)
+			} else {
+				add(false)
+			}
+			inPhrase = !inPhrase
+		case c == ' ' && !inPhrase:
+			add(false)
+		case c == '*' && !inPhrase && (i+1 == len(runes) || runes[i+1] == ' '):
+			add(true)
+		default:
+			current.WriteRune(c)
+		}
+	}
+	add(false)
+	return terms
+}
+
+// Convert terms to a FTS5 query, every term is quoted so it can't use FTS5 syntax.
+func ftsQuery(terms []searchTerm) string {
+	parts := make([]string, len(terms))
+	for i, v := range terms {
+		parts[i] = `"` + strings.ReplaceAll(v.Value, `"`, `""`) + `"`
+		if v.Prefix {
+			parts[i] += "*"
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// Convert terms to a FTS5 query matching files with any of the terms, files are ranked by it
+func ftsRankQuery(terms []searchTerm) string {
+	parts := make([]string, len(terms))
+	for i, v := range terms {
+		parts[i] = ftsQuery([]searchTerm{v})
+	}
+	return strings.Join(parts, " OR ")
+}
+
+// Text terms of a query that files are ranked by, terms under a NOT aren't
+func queryTextTerms(n QueryNode) []searchTerm {
+	terms := make([]searchTerm, 0)
+	switch n := n.(type) {
+	case *QueryAnd:
+		for _, v := range n.Nodes {
+			terms = append(terms, queryTextTerms(v)...)
+		}
+	case *QueryOr:
+		for _, v := range n.Nodes {
+			terms = append(terms, queryTextTerms(v)...)
+		}
+	case *QueryTerm:
+		if n.Field == "" {
+			terms = append(terms, searchTerm{Value: n.Value, Prefix: n.Prefix})
+		}
+	}
+	return terms
+}
+
+// SQL expression matching files with a term in their path, name or tags
+func textTermSql(t searchTerm, fullText bool) (string, []any) {
+	if fullText {
+		return "f.id IN (SELECT rowid FROM file_search WHERE file_search MATCH ?)", []any{ftsQuery([]searchTerm{t})}
+	}
+	// Words of a phrase can have anything between them, since paths rarely use spaces
+	like := "%" + strings.ReplaceAll(t.Value, " ", "%") + "%"
+	if t.Prefix {
+		like = strings.TrimSuffix(like, "%") + "%"
+	}
+	return "(f.path LIKE ? OR f.name LIKE ? OR EXISTS (SELECT 1 FROM tag st JOIN tag_name stn ON st.tagNameId = stn.id WHERE st.fileId = f.id AND stn.value LIKE ?))", []any{like, like, like}
+}
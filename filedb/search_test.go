@@ -0,0 +1,72 @@
+package filedb
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSearchText(t *testing.T) {
+	for _, v := range []struct {
+		Text  string
+		Terms []searchTerm
+	}{
+		{"", []searchTerm{}},
+		{"  cat  dog ", []searchTerm{{"cat", false}, {"dog", false}}},
+		{"ca* dog", []searchTerm{{"ca", true}, {"dog", false}}},
+		{`"big cat" dog`, []searchTerm{{"big cat", false}, {"dog", false}}},
+		{`"big ca"* d*g`, []searchTerm{{"big ca", true}, {"d*g", false}}},
+		{`"unclosed phrase`, []searchTerm{{"unclosed phrase", false}}},
+	} {
+		assert.Equalf(t, v.Terms, parseSearchText(v.Text), "Wrong terms for '%s'", v.Text)
+	}
+	assert.Equalf(t, `"big ""cat""" "dog"*`, ftsQuery([]searchTerm{{`big "cat"`, false}, {"dog", true}}), "Wrong FTS5 query")
+}
+
+func TestSearchText(t *testing.T) {
+	db := getTestDb(t)
+	defer db.Close()
+	t.Logf("Full text search: %v", db.fullText)
+	f1 := makeTestFile(t, "shows/Big_Cat_Diaries.mp4")
+	f2 := makeTestFile(t, "shows/cat.mp4")
+	assert.NoErrorf(t, f2.AddTag("documentary"), "AddTag failed")
+	f3 := makeTestFile(t, "music/dog.mp3")
+	for _, f := range []*File{f1, f2, f3} {
+		if !assert.NoErrorf(t, db.AddFile(f), "AddFile failed") {
+			return
+		}
+	}
+	search := func(text string) []*File {
+		fs, err := db.SearchFile(&SearchQuery{Text: text})
+		assert.NoErrorf(t, err, "SearchFile(%s) failed", text)
+		return fs
+	}
+	assert.ElementsMatchf(t, []*File{f1, f2}, search("cat"), "Wrong files for 'cat'")
+	assert.ElementsMatchf(t, []*File{f2}, search("cat documentary"), "Every term should have to match")
+	assert.ElementsMatchf(t, []*File{f2}, search("docu*"), "Prefix didn't match")
+	assert.ElementsMatchf(t, []*File{f1}, search(`"big cat"`), "Phrase didn't match")
+	assert.ElementsMatchf(t, []*File{f3}, search(`dog "`), "Unclosed quotes should be ignored")
+	// Changes are kept in sync
+	assert.NoErrorf(t, f3.SetName("Cat video"), "SetName failed")
+	assert.NoErrorf(t, db.UpdateFile(f3), "UpdateFile failed")
+	assert.ElementsMatchf(t, []*File{f1, f2, f3}, search("cat"), "Name change wasn't searchable")
+	f2.RemoveTag("documentary")
+	assert.NoErrorf(t, db.UpdateFile(f2), "UpdateFile failed")
+	assert.Emptyf(t, search("documentary"), "Removed tag was still searchable")
+	if db.fullText {
+		// 'cat' is more of 'shows/cat.mp4' than the other files
+		fs := search("cat")
+		if assert.Len(t, fs, 3) {
+			assert.Equalf(t, f2.GetId(), fs[0].GetId(), "Files weren't sorted by relevance")
+		}
+		// Words in the query are ranked the same, files that only match the rest of the query are last
+		fs, err := db.SearchFile(&SearchQuery{Query: "cat"})
+		if assert.NoErrorf(t, err, "SearchFile failed") && assert.Len(t, fs, 3) {
+			assert.Equalf(t, f2.GetId(), fs[0].GetId(), "Query words weren't sorted by relevance")
+		}
+		fs, err = db.SearchFile(&SearchQuery{Query: "path:music/ OR diaries"})
+		if assert.NoErrorf(t, err, "SearchFile failed") && assert.Len(t, fs, 2) {
+			assert.Equalf(t, []int{f1.GetId(), f3.GetId()}, []int{fs[0].GetId(), fs[1].GetId()}, "Files only matching the rest of the query weren't last")
+		}
+	}
+}
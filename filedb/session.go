@@ -0,0 +1,249 @@
+package filedb
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// A login session, only the hash of the session token is stored.
+type Session struct {
+	id        int
+	userId    int
+	created   time.Time
+	lastSeen  time.Time
+	ip        string
+	userAgent string
+}
+
+func (s *Session) GetId() int {
+	return s.id
+}
+
+// Id of the user this session belongs to
+func (s *Session) GetUserId() int {
+	return s.userId
+}
+
+// Time the user logged in
+func (s *Session) GetCreated() time.Time {
+	return s.created
+}
+
+// Last time the session was used (See FileDb.TouchSession)
+func (s *Session) GetLastSeen() time.Time {
+	return s.lastSeen
+}
+
+// Address the user logged in from
+func (s *Session) GetIp() string {
+	return s.ip
+}
+
+// User-Agent the user logged in with
+func (s *Session) GetUserAgent() string {
+	return s.userAgent
+}
+
+// Checks if the session hasn't been used for idle, or was created more than absolute ago. A timeout of 0 is ignored.
+func (s *Session) IsExpired(idle time.Duration, absolute time.Duration) bool {
+	now := time.Now()
+	if idle > 0 && now.Sub(s.lastSeen) > idle {
+		return true
+	}
+	return absolute > 0 && now.Sub(s.created) > absolute
+}
+
+// Don't call .Next before calling this function or you will lose a session.
+func (d *FileDb) sqlRowsToSessions(r *sql.Rows) []*Session {
+	sessions := make([]*Session, 0)
+	for r.Next() {
+		s := &Session{}
+		created := int64(0)
+		lastSeen := int64(0)
+		err := r.Scan(&s.id, &s.userId, &created, &lastSeen, &s.ip, &s.userAgent)
+		if err != nil {
+			// This can only happen if the database structure has changed.
+			slog.Error("Failed to scan from Session rows", "Error", err.Error())
+			panic(fmt.Sprintf("MediaManager: sqlRowsToSessions: Scanning from session rows failed: %v", err))
+		}
+		s.created = time.Unix(created, 0)
+		s.lastSeen = time.Unix(lastSeen, 0)
+		sessions = append(sessions, s)
+	}
+	return sessions
+}
+
+// Create a new session for a user, the session token is returned and cannot be retrieved again.
+func (d *FileDb) AddSession(u *User, ip string, userAgent string) (string, *Session, error) {
+	if d.safeMode {
+		return "", nil, ErrOutdatedDatabase
+	}
+	if err := isValidUser(u); err != nil {
+		return "", nil, err
+	}
+	buf := make([]byte, 32)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to generate session token: %v", err)
+	}
+	token := fmt.Sprintf("%x", buf)
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	now := time.Unix(time.Now().Unix(), 0)
+	s := &Session{
+		userId:    u.id,
+		created:   now,
+		lastSeen:  now,
+		ip:        ip,
+		userAgent: userAgent,
+	}
+	// Don't log the hash
+	slog.Info("Executing INSERT", "Query", "INSERT INTO session(userId, hash, created, lastSeen, ip, userAgent) VALUES (?, ?, ?, ?, ?, ?)", "QueryArgs", []any{s.userId, "<hash>", s.created.Unix(), s.lastSeen.Unix(), s.ip, s.userAgent})
+	res, err := d.db.Exec("INSERT INTO session(userId, hash, created, lastSeen, ip, userAgent) VALUES (?, ?, ?, ?, ?, ?)", s.userId, hashToken(token), s.created.Unix(), s.lastSeen.Unix(), s.ip, s.userAgent)
+	if err != nil {
+		slog.Info("Failed to insert into session", "Query", "INSERT INTO session(userId, hash, created, lastSeen, ip, userAgent) VALUES (?, ?, ?, ?, ?, ?)", "Error", err.Error())
+		return "", nil, fmt.Errorf("failed to insert into session table: %v", err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		slog.Error("Failed to get lastInsertId", "Error", err.Error(), "Query", "INSERT INTO session(userId, hash, created, lastSeen, ip, userAgent) VALUES (?, ?, ?, ?, ?, ?)")
+		panic(fmt.Sprintf("MediaManager: AddSession: .LastInsertId failed to get id, is the database correct?: %v", err))
+	}
+	s.id = int(id)
+	return token, s, nil
+}
+
+// Get the session matching token
+func (d *FileDb) GetSession(token string) (*Session, error) {
+	if d.safeMode {
+		return nil, ErrOutdatedDatabase
+	}
+	slog.Debug("Executing SELECT", "Query", "SELECT id, userId, created, lastSeen, ip, userAgent FROM session WHERE hash=?")
+	rows, err := d.db.Query("SELECT id, userId, created, lastSeen, ip, userAgent FROM session WHERE hash=?", hashToken(token))
+	if err != nil {
+		slog.Error("Failed to execute select query", "Query", "SELECT id, userId, created, lastSeen, ip, userAgent FROM session WHERE hash=?", "Error", err.Error())
+		panic(fmt.Sprintf("MediaManager: GetSession query failed: %v", err))
+	}
+	defer rows.Close()
+	sessions := d.sqlRowsToSessions(rows)
+	if len(sessions) == 0 {
+		return nil, errors.New("session not found")
+	}
+	return sessions[0], nil
+}
+
+// Get a session by ID
+func (d *FileDb) GetSessionById(id int) (*Session, error) {
+	if d.safeMode {
+		return nil, ErrOutdatedDatabase
+	}
+	slog.Debug("Executing SELECT", "Query", "SELECT id, userId, created, lastSeen, ip, userAgent FROM session WHERE id=?", "QueryArgs", []any{id})
+	rows, err := d.db.Query("SELECT id, userId, created, lastSeen, ip, userAgent FROM session WHERE id=?", id)
+	if err != nil {
+		slog.Error("Failed to execute select query", "Query", "SELECT id, userId, created, lastSeen, ip, userAgent FROM session WHERE id=?", "QueryArgs", []any{id}, "Error", err.Error())
+		panic(fmt.Sprintf("MediaManager: GetSessionById query failed: %v", err))
+	}
+	defer rows.Close()
+	sessions := d.sqlRowsToSessions(rows)
+	if len(sessions) == 0 {
+		return nil, errors.New("session not found")
+	}
+	return sessions[0], nil
+}
+
+// Get every session, ordered by ID
+func (d *FileDb) GetSessions() ([]*Session, error) {
+	if d.safeMode {
+		return nil, ErrOutdatedDatabase
+	}
+	slog.Debug("Executing SELECT", "Query", "SELECT id, userId, created, lastSeen, ip, userAgent FROM session ORDER BY id")
+	rows, err := d.db.Query("SELECT id, userId, created, lastSeen, ip, userAgent FROM session ORDER BY id")
+	if err != nil {
+		slog.Error("Failed to execute select query", "Query", "SELECT id, userId, created, lastSeen, ip, userAgent FROM session ORDER BY id", "Error", err.Error())
+		panic(fmt.Sprintf("MediaManager: GetSessions query failed: %v", err))
+	}
+	defer rows.Close()
+	return d.sqlRowsToSessions(rows), nil
+}
+
+// Set the last seen time of a session to now
+func (d *FileDb) TouchSession(s *Session) error {
+	if d.safeMode {
+		return ErrOutdatedDatabase
+	}
+	if s == nil || s.id == 0 {
+		return errors.New("invalid session")
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	now := time.Unix(time.Now().Unix(), 0)
+	slog.Debug("Executing UPDATE", "Query", "UPDATE session SET lastSeen=? WHERE id=?", "QueryArgs", []any{now.Unix(), s.id})
+	res, err := d.db.Exec("UPDATE session SET lastSeen=? WHERE id=?", now.Unix(), s.id)
+	if err != nil {
+		slog.Warn("Failed to update session", "Query", "UPDATE session SET lastSeen=? WHERE id=?", "QueryArgs", []any{now.Unix(), s.id}, "Error", err.Error())
+		return fmt.Errorf("failed to update session table: %v", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return errors.New("session not found")
+	}
+	s.lastSeen = now
+	return nil
+}
+
+// Remove a session, its token can no longer be used
+func (d *FileDb) RemoveSession(s *Session) error {
+	if d.safeMode {
+		return ErrOutdatedDatabase
+	}
+	if s == nil || s.id == 0 {
+		return errors.New("invalid session")
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	slog.Info("Executing DELETE", "Query", "DELETE FROM session WHERE id=?", "QueryArgs", []any{s.id})
+	res, err := d.db.Exec("DELETE FROM session WHERE id=?", s.id)
+	if err != nil {
+		slog.Warn("Failed to delete session", "Query", "DELETE FROM session WHERE id=?", "QueryArgs", []any{s.id}, "Error", err.Error())
+		return fmt.Errorf("failed to remove from session table: %v", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return errors.New("session not found")
+	}
+	// Invalidate the session
+	s.id = 0
+	return nil
+}
+
+// Remove every session that is expired (See Session.IsExpired), returns the number of sessions removed.
+func (d *FileDb) RemoveExpiredSessions(idle time.Duration, absolute time.Duration) (int64, error) {
+	if d.safeMode {
+		return 0, ErrOutdatedDatabase
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	now := time.Now()
+	// A timeout of 0 never expires, so use a time no session can be before
+	idleBefore := int64(0)
+	if idle > 0 {
+		idleBefore = now.Add(-idle).Unix()
+	}
+	createdBefore := int64(0)
+	if absolute > 0 {
+		createdBefore = now.Add(-absolute).Unix()
+	}
+	slog.Info("Executing DELETE", "Query", "DELETE FROM session WHERE lastSeen < ? OR created < ?", "QueryArgs", []any{idleBefore, createdBefore})
+	res, err := d.db.Exec("DELETE FROM session WHERE lastSeen < ? OR created < ?", idleBefore, createdBefore)
+	if err != nil {
+		slog.Warn("Failed to delete expired sessions", "Query", "DELETE FROM session WHERE lastSeen < ? OR created < ?", "QueryArgs", []any{idleBefore, createdBefore}, "Error", err.Error())
+		return 0, fmt.Errorf("failed to remove from session table: %v", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, nil
+	}
+	return n, nil
+}
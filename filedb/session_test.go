@@ -0,0 +1,92 @@
+package filedb
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAddSession(t *testing.T) {
+	db := getTestDb(t)
+	defer db.Close()
+	u := getTestUser(t, db)
+	token, s, err := db.AddSession(u, "127.0.0.1", "test")
+	if !assert.NoErrorf(t, err, "AddSession failed") {
+		return
+	}
+	assert.NotEmptyf(t, token, "Token was empty")
+	assert.Equalf(t, u.GetId(), s.GetUserId(), "Session belongs to wrong user")
+	fromDb, err := db.GetSession(token)
+	if assert.NoErrorf(t, err, "GetSession failed") {
+		assert.Equalf(t, s, fromDb, "GetSession returned wrong session")
+	}
+	_, err = db.GetSession(token + "0")
+	assert.Errorf(t, err, "GetSession found a invalid token")
+	sessions, err := db.GetSessions()
+	if assert.NoErrorf(t, err, "GetSessions failed") {
+		assert.Equalf(t, []*Session{s}, sessions, "Wrong sessions")
+	}
+}
+
+func TestRemoveSession(t *testing.T) {
+	db := getTestDb(t)
+	defer db.Close()
+	u := getTestUser(t, db)
+	token, s, err := db.AddSession(u, "127.0.0.1", "test")
+	if !assert.NoErrorf(t, err, "AddSession failed") {
+		return
+	}
+	assert.NoErrorf(t, db.RemoveSession(s), "RemoveSession failed")
+	assert.Errorf(t, db.RemoveSession(s), "RemoveSession worked on a removed session")
+	_, err = db.GetSession(token)
+	assert.Errorf(t, err, "Removed session was found")
+	// Removing a user removes their sessions
+	token, _, err = db.AddSession(u, "127.0.0.1", "test")
+	if !assert.NoErrorf(t, err, "AddSession failed") {
+		return
+	}
+	assert.NoErrorf(t, db.RemoveUser(u), "RemoveUser failed")
+	_, err = db.GetSession(token)
+	assert.Errorf(t, err, "Session of removed user was found")
+}
+
+func TestSessionExpiry(t *testing.T) {
+	db := getTestDb(t)
+	defer db.Close()
+	u := getTestUser(t, db)
+	_, active, err := db.AddSession(u, "127.0.0.1", "test")
+	if !assert.NoErrorf(t, err, "AddSession failed") {
+		return
+	}
+	_, idle, err := db.AddSession(u, "127.0.0.1", "test")
+	if !assert.NoErrorf(t, err, "AddSession failed") {
+		return
+	}
+	_, old, err := db.AddSession(u, "127.0.0.1", "test")
+	if !assert.NoErrorf(t, err, "AddSession failed") {
+		return
+	}
+	now := time.Now().Unix()
+	_, err = db.db.Exec("UPDATE session SET lastSeen=? WHERE id=?", now-7200, idle.GetId())
+	assert.NoErrorf(t, err, "Failed to set lastSeen")
+	_, err = db.db.Exec("UPDATE session SET created=? WHERE id=?", now-86400*2, old.GetId())
+	assert.NoErrorf(t, err, "Failed to set created")
+	idle, _ = db.GetSessionById(idle.GetId())
+	old, _ = db.GetSessionById(old.GetId())
+	assert.Falsef(t, active.IsExpired(time.Hour, 24*time.Hour), "Active session expired")
+	assert.Truef(t, idle.IsExpired(time.Hour, 24*time.Hour), "Idle session didn't expire")
+	assert.Falsef(t, idle.IsExpired(0, 24*time.Hour), "Idle timeout of 0 should be ignored")
+	assert.Truef(t, old.IsExpired(time.Hour, 24*time.Hour), "Old session didn't expire")
+	// Using the session keeps it active
+	assert.NoErrorf(t, db.TouchSession(idle), "TouchSession failed")
+	assert.Falsef(t, idle.IsExpired(time.Hour, 24*time.Hour), "Session expired after being used")
+	n, err := db.RemoveExpiredSessions(time.Hour, 24*time.Hour)
+	if assert.NoErrorf(t, err, "RemoveExpiredSessions failed") {
+		assert.Equalf(t, int64(1), n, "Wrong number of sessions removed")
+	}
+	sessions, err := db.GetSessions()
+	if assert.NoErrorf(t, err, "GetSessions failed") {
+		assert.Equalf(t, []*Session{active, idle}, sessions, "Wrong sessions remain")
+	}
+}
@@ -0,0 +1,82 @@
+package filedb
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"mediamanager/phash"
+	"sort"
+	"strings"
+)
+
+// A file that looks like another, see FindSimilar
+type SimilarFile struct {
+	File     *File
+	Distance int // Bits that differ between the perceptual hashes, 0 looks the same
+}
+
+// Get files whose perceptual hash differs from f's by at most maxDistance bits (0 to 64), the closest first. Files are loaded for the same user as f.
+//
+// f must have a perceptual hash, see AddInfoOpts.AddPHash. Around 10 finds the same picture saved at a different quality or size.
+func (d *FileDb) FindSimilar(f *File, maxDistance int) ([]*SimilarFile, error) {
+	if d.safeMode {
+		return nil, ErrOutdatedDatabase
+	}
+	if err := isValidFile(f); err != nil {
+		return nil, err
+	}
+	if !f.hasPHash {
+		return nil, errors.New("file has no perceptual hash")
+	}
+	if maxDistance < 0 || maxDistance > phash.Bits {
+		return nil, fmt.Errorf("max distance must be 0 to %d", phash.Bits)
+	}
+	// SQLite can't count bits, so every hash is compared here
+	slog.Debug("Executing SELECT", "Query", "SELECT id, phash FROM file WHERE phash IS NOT NULL AND id!=?", "QueryArgs", []any{f.id})
+	rows, err := d.db.Query("SELECT id, phash FROM file WHERE phash IS NOT NULL AND id!=?", f.id)
+	if err != nil {
+		slog.Error("Failed to execute select query", "Query", "SELECT id, phash FROM file WHERE phash IS NOT NULL AND id!=?", "QueryArgs", []any{f.id}, "Error", err.Error())
+		panic(fmt.Sprintf("MediaManager: FindSimilar query failed: %v", err))
+	}
+	distances := make(map[int]int)
+	for rows.Next() {
+		id, h := 0, int64(0)
+		if err = rows.Scan(&id, &h); err != nil {
+			rows.Close()
+			slog.Error("Failed to scan file perceptual hash", "Error", err.Error())
+			panic(fmt.Sprintf("MediaManager: FindSimilar: Failed to scan file perceptual hash, did the structure change?: %v", err))
+		}
+		if dist := phash.Distance(f.phash, uint64(h)); dist <= maxDistance {
+			distances[id] = dist
+		}
+	}
+	rows.Close()
+	ids := make([]any, 0, len(distances))
+	for id := range distances {
+		ids = append(ids, id)
+	}
+	similar := make([]*SimilarFile, 0, len(ids))
+	// Split like addFileTags to stay under the argument limit
+	for i := 0; i < len(ids); i += 500 {
+		batch := ids[i:min(i+500, len(ids))]
+		query := "SELECT " + fileColumns + " FROM file f" + fileUserJoin + " WHERE f.id IN (?" + strings.Repeat(", ?", len(batch)-1) + ")"
+		queryArgs := append([]any{f.user}, batch...)
+		slog.Debug("Executing SELECT", "Query", query, "QueryArgs", queryArgs)
+		rows, err = d.db.Query(query, queryArgs...)
+		if err != nil {
+			slog.Error("Failed to execute select query", "Query", query, "QueryArgs", queryArgs, "Error", err.Error())
+			panic(fmt.Sprintf("MediaManager: FindSimilar query failed: %v", err))
+		}
+		for _, v := range d.sqlRowsToFiles(rows, f.user) {
+			similar = append(similar, &SimilarFile{File: v, Distance: distances[v.id]})
+		}
+		rows.Close()
+	}
+	sort.Slice(similar, func(i, j int) bool {
+		if similar[i].Distance != similar[j].Distance {
+			return similar[i].Distance < similar[j].Distance
+		}
+		return similar[i].File.path < similar[j].File.path
+	})
+	return similar, nil
+}
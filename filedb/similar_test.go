@@ -0,0 +1,100 @@
+package filedb
+
+import (
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// A file with a fake perceptual hash
+func makeTestPHashFile(t *testing.T, path string, h uint64) *File {
+	f := makeTestFile(t, path)
+	f.phash, f.hasPHash = h, true
+	return f
+}
+
+func TestFindSimilar(t *testing.T) {
+	db := getTestDb(t)
+	defer db.Close()
+	a := makeTestPHashFile(t, "/a.jpg", 0xffff_0000_ffff_0000)
+	files := []*File{
+		a,
+		makeTestPHashFile(t, "/a-small.jpg", 0xffff_0000_ffff_0001),
+		makeTestPHashFile(t, "/a-low-quality.jpg", 0xffff_0000_ffff_0003),
+		makeTestPHashFile(t, "/a-copy.jpg", 0xffff_0000_ffff_0000),
+		makeTestPHashFile(t, "/b.jpg", 0x0000_ffff_0000_ffff),
+		// Signed in the database
+		makeTestPHashFile(t, "/c.jpg", 0xffff_ffff_ffff_ffff),
+		makeTestFile(t, "/d.mp4"),
+	}
+	failed, err := db.AddFiles(files...)
+	if !assert.NoErrorf(t, err, "AddFiles failed") || !assert.Emptyf(t, failed, "Files failed to import") {
+		return
+	}
+	c, err := db.GetFileById(files[5].id)
+	if assert.NoErrorf(t, err, "GetFileById failed") {
+		h, ok := c.GetPHash()
+		assert.Truef(t, ok, "Perceptual hash wasn't loaded")
+		assert.Equalf(t, uint64(0xffff_ffff_ffff_ffff), h, "Wrong perceptual hash")
+	}
+	similar, err := db.FindSimilar(a, 2)
+	if assert.NoErrorf(t, err, "FindSimilar failed") {
+		paths, distances := make([]string, 0), make([]int, 0)
+		for _, v := range similar {
+			paths = append(paths, v.File.GetPath())
+			distances = append(distances, v.Distance)
+		}
+		assert.Equalf(t, []string{"/a-copy.jpg", "/a-small.jpg", "/a-low-quality.jpg"}, paths, "Wrong similar files")
+		assert.Equalf(t, []int{0, 1, 2}, distances, "Wrong distances")
+	}
+	similar, _ = db.FindSimilar(a, 64)
+	assert.Lenf(t, similar, 5, "Every hashed file wasn't found")
+	similar, _ = db.FindSimilar(a, 0)
+	assert.Lenf(t, similar, 1, "Only the same hash should be found")
+	_, err = db.FindSimilar(a, 65)
+	assert.Errorf(t, err, "Invalid distance was used")
+	_, err = db.FindSimilar(files[6], 10)
+	assert.Errorf(t, err, "File without a perceptual hash was used")
+	_, err = db.FindSimilar(makeTestPHashFile(t, "/e.jpg", 0), 10)
+	assert.Errorf(t, err, "File that isn't in the database was used")
+}
+
+func TestAddPHash(t *testing.T) {
+	db := getTestDb(t)
+	defer db.Close()
+	// Dark left, light right
+	img := image.NewGray(image.Rect(0, 0, 32, 32))
+	for y := range 32 {
+		for x := 16; x < 32; x++ {
+			img.Set(x, y, color.Gray{Y: 255})
+		}
+	}
+	p := filepath.Join(t.TempDir(), "a.png")
+	out, err := os.Create(p)
+	if err != nil {
+		t.Fatalf("Failed to create image: %v", err)
+	}
+	png.Encode(out, img)
+	out.Close()
+	f := makeTestFile(t, p)
+	if _, err = db.AddFiles(f); !assert.NoErrorf(t, err, "AddFiles failed") {
+		return
+	}
+	if !assert.NoErrorf(t, AddInfoToFiles(&AddInfoOpts{DontAddHash: true, DontAddSize: true, AddPHash: true}, f), "AddInfoToFiles failed") {
+		return
+	}
+	_, ok := f.GetPHash()
+	assert.Truef(t, ok, "Perceptual hash wasn't added")
+	assert.NoErrorf(t, db.UpdateFile(f), "UpdateFile failed")
+	f, err = db.GetFileById(f.id)
+	if assert.NoErrorf(t, err, "GetFileById failed") {
+		h, ok := f.GetPHash()
+		assert.Truef(t, ok, "Perceptual hash wasn't saved")
+		assert.Zerof(t, h, "Wrong perceptual hash")
+	}
+}
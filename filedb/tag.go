@@ -0,0 +1,570 @@
+package filedb
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+// Separates a tags namespace from the rest of it, e.g. 'artist:bob' is in the 'artist' namespace
+const TagNamespaceSeparator = ":"
+
+// Separates a tag from its parent, e.g. 'genre:music/jazz' is a child of 'genre:music'. Searching for a tag also matches its children.
+const TagHierarchySeparator = "/"
+
+// Namespace of a tag, or "" if it doesn't have one
+func TagNamespace(tag string) string {
+	ns, _, found := strings.Cut(tag, TagNamespaceSeparator)
+	if !found {
+		return ""
+	}
+	return ns
+}
+
+// Parents of a tag, closest first. 'genre:music/jazz/bebop' has the parents 'genre:music/jazz' & 'genre:music'
+func TagParents(tag string) []string {
+	start := 0
+	if ns := TagNamespace(tag); ns != "" {
+		start = len(ns) + len(TagNamespaceSeparator)
+	}
+	parents := make([]string, 0)
+	for {
+		i := strings.LastIndex(tag[start:], TagHierarchySeparator)
+		if i <= 0 {
+			return parents
+		}
+		tag = tag[:start+i]
+		parents = append(parents, tag)
+	}
+}
+
+// SQL expression matching 'column' against a tag or any of its children, and its arguments
+func tagMatchSql(column string, tag string) (string, []any) {
+	tag = strings.ToLower(tag)
+	return fmt.Sprintf(`(%s = ? OR %s LIKE ? ESCAPE '\')`, column, column), []any{tag, escapeLike(tag+TagHierarchySeparator) + "%"}
+}
+
+// A rule that adds Implies to every file that gets Tag or one of its children
+type TagImplication struct {
+	Tag     string
+	Implies string
+}
+
+// Tags directly implied by a tag & its parents
+func getImpliedTags(tx *sql.Tx, tag string) []string {
+	tags := append([]string{tag}, TagParents(tag)...)
+	query := "SELECT implies FROM tag_implication WHERE tag IN (?" + strings.Repeat(", ?", len(tags)-1) + ") ORDER BY id"
+	args := make([]any, len(tags))
+	for i, v := range tags {
+		args[i] = v
+	}
+	slog.Debug("Executing SELECT", "Query", query, "QueryArgs", args)
+	rows, err := tx.Query(query, args...)
+	if err != nil {
+		slog.Error("Failed to query tag_implication", "Query", query, "QueryArgs", args, "Error", err.Error())
+		panic(fmt.Sprintf("MediaManager: getImpliedTags: Failed to query tag_implication: %v", err))
+	}
+	defer rows.Close()
+	implied := make([]string, 0)
+	for rows.Next() {
+		v := ""
+		if err := rows.Scan(&v); err != nil {
+			slog.Error("Failed to scan tag_implication.implies", "Query", query, "Error", err.Error())
+			panic(fmt.Sprintf("MediaManager: getImpliedTags: Failed to scan tag_implication.implies: %v", err))
+		}
+		implied = append(implied, v)
+	}
+	return implied
+}
+
+// Add a implication rule, every file with 'tag' or one of its children also gets 'implies'.
+//
+// The rule is applied to files that already have the tag, removing the rule later doesn't remove the tags it added.
+func (d *FileDb) AddTagImplication(tag string, implies string) error {
+	if d.safeMode {
+		return ErrOutdatedDatabase
+	}
+	tag = strings.ToLower(tag)
+	implies = strings.ToLower(implies)
+	if tag == "" || implies == "" {
+		return errors.New("tags cannot be empty")
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	tx, err := d.db.Begin()
+	if err != nil {
+		slog.Error("Failed to begin transaction for AddTagImplication", "Error", err.Error())
+		return err
+	}
+	tag = resolveTagAlias(tx, tag)
+	implies = resolveTagAlias(tx, implies)
+	if tag == implies {
+		tx.Rollback()
+		return errors.New("a tag cannot imply itself")
+	}
+	slog.Info("Executing INSERT", "Query", "INSERT INTO tag_implication(tag, implies) VALUES (?, ?)", "QueryArgs", []any{tag, implies})
+	_, err = tx.Exec("INSERT INTO tag_implication(tag, implies) VALUES (?, ?)", tag, implies)
+	if err != nil {
+		// This would fail if the rule already exists
+		slog.Info("Failed to insert into tag_implication", "Query", "INSERT INTO tag_implication(tag, implies) VALUES (?, ?)", "QueryArgs", []any{tag, implies}, "Error", err.Error())
+		tx.Rollback()
+		return fmt.Errorf("failed to insert into tag_implication table: %v", err)
+	}
+	// Apply it to files that already have the tag, but not the tag it implies
+	match, args := tagMatchSql("tn.value", tag)
+	query := "SELECT DISTINCT t.fileId FROM tag t JOIN tag_name tn ON tn.id = t.tagNameId WHERE " + match +
+		" AND t.fileId NOT IN (SELECT it.fileId FROM tag it JOIN tag_name itn ON itn.id = it.tagNameId WHERE itn.value = ?)"
+	args = append(args, implies)
+	slog.Debug("Executing SELECT", "Query", query, "QueryArgs", args)
+	rows, err := tx.Query(query, args...)
+	if err != nil {
+		slog.Error("Failed to query tag", "Query", query, "QueryArgs", args, "Error", err.Error())
+		tx.Rollback()
+		panic(fmt.Sprintf("MediaManager: AddTagImplication: Failed to query tag: %v", err))
+	}
+	fileIds := make([]int, 0)
+	for rows.Next() {
+		id := 0
+		if err := rows.Scan(&id); err != nil {
+			slog.Error("Failed to scan tag.fileId", "Query", query, "Error", err.Error())
+			rows.Close()
+			tx.Rollback()
+			panic(fmt.Sprintf("MediaManager: AddTagImplication: Failed to scan tag.fileId: %v", err))
+		}
+		fileIds = append(fileIds, id)
+	}
+	rows.Close()
+	for _, id := range fileIds {
+		if _, err := d.addFileTag(tx, id, implies); err != nil {
+			slog.Warn("Aborting AddTagImplication after failing to add tag", "Error", err.Error(), "Tag", implies, "File.Id", id)
+			tx.Rollback()
+			return err
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		slog.Warn("Failed to commit AddTagImplication", "Error", err.Error(), "Tag", tag, "Implies", implies)
+		return fmt.Errorf("transaction failed to commit: %v", err)
+	}
+	return nil
+}
+
+// Remove a implication rule, files keep the tags it added
+func (d *FileDb) RemoveTagImplication(tag string, implies string) error {
+	if d.safeMode {
+		return ErrOutdatedDatabase
+	}
+	tag = strings.ToLower(tag)
+	implies = strings.ToLower(implies)
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	slog.Info("Executing DELETE", "Query", "DELETE FROM tag_implication WHERE tag=? AND implies=?", "QueryArgs", []any{tag, implies})
+	res, err := d.db.Exec("DELETE FROM tag_implication WHERE tag=? AND implies=?", tag, implies)
+	if err != nil {
+		slog.Error("Failed to delete from tag_implication", "Query", "DELETE FROM tag_implication WHERE tag=? AND implies=?", "QueryArgs", []any{tag, implies}, "Error", err.Error())
+		panic(fmt.Sprintf("MediaManager: RemoveTagImplication: Failed to delete from tag_implication: %v", err))
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return errors.New("tag implication not found")
+	}
+	return nil
+}
+
+// Get every implication rule, ordered by tag
+func (d *FileDb) GetTagImplications() ([]*TagImplication, error) {
+	if d.safeMode {
+		return nil, ErrOutdatedDatabase
+	}
+	const query = "SELECT tag, implies FROM tag_implication ORDER BY tag, implies"
+	slog.Debug("Executing SELECT", "Query", query)
+	rows, err := d.db.Query(query)
+	if err != nil {
+		slog.Error("Failed to execute select query", "Query", query, "Error", err.Error())
+		panic(fmt.Sprintf("MediaManager: GetTagImplications query failed: %v", err))
+	}
+	defer rows.Close()
+	implications := make([]*TagImplication, 0)
+	for rows.Next() {
+		v := &TagImplication{}
+		if err := rows.Scan(&v.Tag, &v.Implies); err != nil {
+			slog.Error("Failed to scan tag_implication", "Query", query, "Error", err.Error())
+			panic(fmt.Sprintf("MediaManager: GetTagImplications: Failed to scan tag_implication: %v", err))
+		}
+		implications = append(implications, v)
+	}
+	return implications, nil
+}
+
+// A tag in the tree returned by GetTagTree
+type TagNode struct {
+	Name     string     // Last part of the tag, or the namespace for namespace nodes
+	Tag      string     // The full tag, empty for namespace nodes
+	Id       int        // Tag id, 0 for namespace nodes & parents that only exist because of their children
+	Implies  []string   // Tags this tag implies
+	Aliases  []string   // Aliases that point to this tag
+	Children []*TagNode // Sorted by name
+}
+
+// Find a child by its full tag & namespace name, adding it if it doesn't exist
+func (n *TagNode) child(name string, tag string) *TagNode {
+	for _, v := range n.Children {
+		if v.Tag == tag && v.Name == name {
+			return v
+		}
+	}
+	c := &TagNode{Name: name, Tag: tag, Implies: make([]string, 0), Aliases: make([]string, 0), Children: make([]*TagNode, 0)}
+	n.Children = append(n.Children, c)
+	return c
+}
+
+func (n *TagNode) sort() {
+	slices.SortFunc(n.Children, func(a, b *TagNode) int {
+		if c := strings.Compare(a.Name, b.Name); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Tag, b.Tag)
+	})
+	for _, v := range n.Children {
+		v.sort()
+	}
+}
+
+// Get every tag as a tree, tags in a namespace are under a node for the namespace & children are under their parents
+func (d *FileDb) GetTagTree() ([]*TagNode, error) {
+	implications, err := d.GetTagImplications()
+	if err != nil {
+		return nil, err
+	}
+	aliases, err := d.GetTagAliases()
+	if err != nil {
+		return nil, err
+	}
+	root := &TagNode{Children: make([]*TagNode, 0)}
+	for id, tag := range d.GetAllTags() {
+		node := root
+		value := tag
+		if ns := TagNamespace(tag); ns != "" {
+			node = node.child(ns, "")
+			value = tag[len(ns)+len(TagNamespaceSeparator):]
+		}
+		prefix := tag[:len(tag)-len(value)]
+		parts := strings.Split(value, TagHierarchySeparator)
+		for i, v := range parts {
+			node = node.child(v, prefix+strings.Join(parts[:i+1], TagHierarchySeparator))
+		}
+		node.Id = id
+	}
+	var addRules func(n *TagNode)
+	addRules = func(n *TagNode) {
+		for _, v := range implications {
+			if n.Tag != "" && v.Tag == n.Tag {
+				n.Implies = append(n.Implies, v.Implies)
+			}
+		}
+		for _, v := range aliases {
+			if n.Tag != "" && v.Tag == n.Tag {
+				n.Aliases = append(n.Aliases, v.Alias)
+			}
+		}
+		for _, v := range n.Children {
+			addRules(v)
+		}
+	}
+	addRules(root)
+	root.sort()
+	return root.Children, nil
+}
+
+// A alternative name for a tag, adding or searching for Alias uses Tag instead
+type TagAlias struct {
+	Alias string
+	Tag   string
+}
+
+// Tag a alias points to, or the tag unchanged if it isn't a alias
+func resolveTagAlias(tx *sql.Tx, tag string) string {
+	v := ""
+	slog.Debug("Executing SELECT", "Query", "SELECT tag FROM tag_alias WHERE alias=?", "QueryArgs", []any{strings.ToLower(tag)})
+	err := tx.QueryRow("SELECT tag FROM tag_alias WHERE alias=?", strings.ToLower(tag)).Scan(&v)
+	if errors.Is(err, sql.ErrNoRows) {
+		return tag
+	} else if err != nil {
+		slog.Error("Failed to query tag_alias", "Query", "SELECT tag FROM tag_alias WHERE alias=?", "QueryArgs", []any{tag}, "Error", err.Error())
+		panic(fmt.Sprintf("MediaManager: resolveTagAlias: Failed to query tag_alias: %v", err))
+	}
+	return v
+}
+
+// Aliases mapped to the tag they point to
+type tagAliasMap map[string]string
+
+// Tag a alias points to, or the tag unchanged if it isn't a alias
+func (m tagAliasMap) resolve(tag string) string {
+	if v, ok := m[strings.ToLower(tag)]; ok {
+		return v
+	}
+	return tag
+}
+
+// Every alias as a tagAliasMap
+func (d *FileDb) getTagAliasMap() tagAliasMap {
+	aliases, err := d.GetTagAliases()
+	if err != nil {
+		return tagAliasMap{}
+	}
+	m := make(tagAliasMap, len(aliases))
+	for _, v := range aliases {
+		m[v.Alias] = v.Tag
+	}
+	return m
+}
+
+// Add a alias, adding or searching for 'alias' uses 'tag' instead. A alias of a alias points to the same tag.
+//
+// The alias can't be a existing tag, use MergeTags to combine them.
+func (d *FileDb) AddTagAlias(alias string, tag string) error {
+	if d.safeMode {
+		return ErrOutdatedDatabase
+	}
+	alias = strings.ToLower(alias)
+	tag = strings.ToLower(tag)
+	if alias == "" || tag == "" {
+		return errors.New("tags cannot be empty")
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	tx, err := d.db.Begin()
+	if err != nil {
+		slog.Error("Failed to begin transaction for AddTagAlias", "Error", err.Error())
+		return err
+	}
+	tag = resolveTagAlias(tx, tag)
+	if alias == tag {
+		tx.Rollback()
+		return errors.New("a tag cannot be a alias of itself")
+	}
+	exists := 0
+	slog.Debug("Executing SELECT", "Query", "SELECT COUNT(*) FROM tag_name WHERE value=?", "QueryArgs", []any{alias})
+	err = tx.QueryRow("SELECT COUNT(*) FROM tag_name WHERE value=?", alias).Scan(&exists)
+	if err != nil {
+		slog.Error("Failed to query tag_name", "Query", "SELECT COUNT(*) FROM tag_name WHERE value=?", "QueryArgs", []any{alias}, "Error", err.Error())
+		tx.Rollback()
+		panic(fmt.Sprintf("MediaManager: AddTagAlias: Failed to query tag_name: %v", err))
+	}
+	if exists != 0 {
+		tx.Rollback()
+		return fmt.Errorf("tag '%s' exists, merge it into '%s' instead", alias, tag)
+	}
+	slog.Info("Executing INSERT", "Query", "INSERT INTO tag_alias(alias, tag) VALUES (?, ?)", "QueryArgs", []any{alias, tag})
+	_, err = tx.Exec("INSERT INTO tag_alias(alias, tag) VALUES (?, ?)", alias, tag)
+	if err != nil {
+		// This would fail if the alias already exists
+		slog.Info("Failed to insert into tag_alias", "Query", "INSERT INTO tag_alias(alias, tag) VALUES (?, ?)", "QueryArgs", []any{alias, tag}, "Error", err.Error())
+		tx.Rollback()
+		return fmt.Errorf("failed to insert into tag_alias table: %v", err)
+	}
+	// Aliases of the alias point to the tag instead
+	slog.Info("Executing UPDATE", "Query", "UPDATE tag_alias SET tag=? WHERE tag=?", "QueryArgs", []any{tag, alias})
+	_, err = tx.Exec("UPDATE tag_alias SET tag=? WHERE tag=?", tag, alias)
+	if err != nil {
+		slog.Warn("Failed to update tag_alias", "Query", "UPDATE tag_alias SET tag=? WHERE tag=?", "QueryArgs", []any{tag, alias}, "Error", err.Error())
+		tx.Rollback()
+		return fmt.Errorf("failed to update tag_alias table: %v", err)
+	}
+	err = tx.Commit()
+	if err != nil {
+		slog.Warn("Failed to commit AddTagAlias", "Error", err.Error(), "Alias", alias, "Tag", tag)
+		return fmt.Errorf("transaction failed to commit: %v", err)
+	}
+	return nil
+}
+
+// Remove a alias, files with the tag it points to are kept
+func (d *FileDb) RemoveTagAlias(alias string) error {
+	if d.safeMode {
+		return ErrOutdatedDatabase
+	}
+	alias = strings.ToLower(alias)
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	slog.Info("Executing DELETE", "Query", "DELETE FROM tag_alias WHERE alias=?", "QueryArgs", []any{alias})
+	res, err := d.db.Exec("DELETE FROM tag_alias WHERE alias=?", alias)
+	if err != nil {
+		slog.Error("Failed to delete from tag_alias", "Query", "DELETE FROM tag_alias WHERE alias=?", "QueryArgs", []any{alias}, "Error", err.Error())
+		panic(fmt.Sprintf("MediaManager: RemoveTagAlias: Failed to delete from tag_alias: %v", err))
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return errors.New("tag alias not found")
+	}
+	return nil
+}
+
+// Get every alias, ordered by the tag they point to
+func (d *FileDb) GetTagAliases() ([]*TagAlias, error) {
+	if d.safeMode {
+		return nil, ErrOutdatedDatabase
+	}
+	const query = "SELECT alias, tag FROM tag_alias ORDER BY tag, alias"
+	slog.Debug("Executing SELECT", "Query", query)
+	rows, err := d.db.Query(query)
+	if err != nil {
+		slog.Error("Failed to execute select query", "Query", query, "Error", err.Error())
+		panic(fmt.Sprintf("MediaManager: GetTagAliases query failed: %v", err))
+	}
+	defer rows.Close()
+	aliases := make([]*TagAlias, 0)
+	for rows.Next() {
+		v := &TagAlias{}
+		if err := rows.Scan(&v.Alias, &v.Tag); err != nil {
+			slog.Error("Failed to scan tag_alias", "Query", query, "Error", err.Error())
+			panic(fmt.Sprintf("MediaManager: GetTagAliases: Failed to scan tag_alias: %v", err))
+		}
+		aliases = append(aliases, v)
+	}
+	return aliases, nil
+}
+
+// Rename a tag & its children, renaming 'genre:music' to 'genre:songs' renames 'genre:music/jazz' to 'genre:songs/jazz'.
+// Implication rules & aliases are updated to use the new names.
+//
+// Fails if a new name is already a tag or alias, use MergeTags to combine tags.
+func (d *FileDb) RenameTag(tag string, name string) error {
+	return d.moveTag(tag, name, false)
+}
+
+// Merge a tag & its children into another tag, every file with 'from' gets 'into' instead & 'from' is removed.
+// Children are merged into the same child of 'into', or renamed if it doesn't exist. Implication rules & aliases are updated to use 'into',
+// but rules of 'into' aren't applied to the files that get it.
+func (d *FileDb) MergeTags(from string, into string) error {
+	return d.moveTag(from, into, true)
+}
+
+// Rename or merge a tag & its children in a single transaction
+func (d *FileDb) moveTag(from string, to string, merge bool) error {
+	if d.safeMode {
+		return ErrOutdatedDatabase
+	}
+	from = strings.ToLower(from)
+	to = strings.ToLower(to)
+	if from == "" || to == "" {
+		return errors.New("tags cannot be empty")
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	tx, err := d.db.Begin()
+	if err != nil {
+		slog.Error("Failed to begin transaction for moveTag", "Error", err.Error())
+		return err
+	}
+	if merge {
+		to = resolveTagAlias(tx, to)
+	}
+	if from == to || strings.HasPrefix(to, from+TagHierarchySeparator) {
+		tx.Rollback()
+		return errors.New("a tag cannot be moved into itself or its children")
+	}
+	// Get the tag & its children
+	match, args := tagMatchSql("value", from)
+	query := "SELECT id, value FROM tag_name WHERE " + match
+	slog.Debug("Executing SELECT", "Query", query, "QueryArgs", args)
+	rows, err := tx.Query(query, args...)
+	if err != nil {
+		slog.Error("Failed to query tag_name", "Query", query, "QueryArgs", args, "Error", err.Error())
+		tx.Rollback()
+		panic(fmt.Sprintf("MediaManager: moveTag: Failed to query tag_name: %v", err))
+	}
+	tags := make(map[int]string)
+	for rows.Next() {
+		id := 0
+		value := ""
+		if err := rows.Scan(&id, &value); err != nil {
+			slog.Error("Failed to scan tag_name", "Query", query, "Error", err.Error())
+			rows.Close()
+			tx.Rollback()
+			panic(fmt.Sprintf("MediaManager: moveTag: Failed to scan tag_name: %v", err))
+		}
+		tags[id] = value
+	}
+	rows.Close()
+	if len(tags) == 0 {
+		tx.Rollback()
+		return errors.New("tag not found")
+	}
+	for id, value := range tags {
+		target := to + value[len(from):]
+		if alias := resolveTagAlias(tx, target); alias != target {
+			tx.Rollback()
+			return fmt.Errorf("'%s' is a alias of '%s', remove it first", target, alias)
+		}
+		targetId := 0
+		slog.Debug("Executing SELECT", "Query", "SELECT id FROM tag_name WHERE value=?", "QueryArgs", []any{target})
+		err = tx.QueryRow("SELECT id FROM tag_name WHERE value=?", target).Scan(&targetId)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			slog.Error("Failed to query tag_name", "Query", "SELECT id FROM tag_name WHERE value=?", "QueryArgs", []any{target}, "Error", err.Error())
+			tx.Rollback()
+			panic(fmt.Sprintf("MediaManager: moveTag: Failed to query tag_name: %v", err))
+		}
+		if targetId == 0 {
+			slog.Info("Executing UPDATE", "Query", "UPDATE tag_name SET value=? WHERE id=?", "QueryArgs", []any{target, id})
+			_, err = tx.Exec("UPDATE tag_name SET value=? WHERE id=?", target, id)
+			if err != nil {
+				slog.Warn("Failed to update tag_name", "Query", "UPDATE tag_name SET value=? WHERE id=?", "QueryArgs", []any{target, id}, "Error", err.Error())
+				tx.Rollback()
+				return fmt.Errorf("failed to rename '%s': %v", value, err)
+			}
+			continue
+		}
+		if !merge {
+			tx.Rollback()
+			return fmt.Errorf("tag '%s' already exists, merge the tags instead", target)
+		}
+		// Give every file with the old tag the existing one, then remove the old tag
+		for _, q := range []struct {
+			query string
+			args  []any
+		}{
+			{"INSERT OR IGNORE INTO tag(fileId, tagNameId) SELECT fileId, ? FROM tag WHERE tagNameId=?", []any{targetId, id}},
+			{"DELETE FROM tag WHERE tagNameId=?", []any{id}},
+			{"DELETE FROM tag_name WHERE id=?", []any{id}},
+		} {
+			slog.Info("Executing "+strings.Fields(q.query)[0], "Query", q.query, "QueryArgs", q.args)
+			_, err = tx.Exec(q.query, q.args...)
+			if err != nil {
+				slog.Warn("Failed to merge tag", "Query", q.query, "QueryArgs", q.args, "Error", err.Error())
+				tx.Rollback()
+				return fmt.Errorf("failed to merge '%s' into '%s': %v", value, target, err)
+			}
+		}
+	}
+	// Move rules & aliases, ones that would be duplicates or point to themselves are removed
+	likeFrom := escapeLike(from+TagHierarchySeparator) + "%"
+	for _, column := range [][2]string{{"tag_implication", "tag"}, {"tag_implication", "implies"}, {"tag_alias", "tag"}} {
+		update := fmt.Sprintf(`UPDATE OR IGNORE %s SET %s = ? || substr(%s, ?) WHERE %s = ? OR %s LIKE ? ESCAPE '\'`, column[0], column[1], column[1], column[1], column[1])
+		remove := fmt.Sprintf(`DELETE FROM %s WHERE %s = ? OR %s LIKE ? ESCAPE '\'`, column[0], column[1], column[1])
+		for _, q := range []struct {
+			query string
+			args  []any
+		}{
+			{update, []any{to, utf8.RuneCountInString(from) + 1, from, likeFrom}},
+			{remove, []any{from, likeFrom}},
+		} {
+			slog.Info("Executing "+strings.Fields(q.query)[0], "Query", q.query, "QueryArgs", q.args)
+			_, err = tx.Exec(q.query, q.args...)
+			if err != nil {
+				slog.Warn("Failed to update tag rules", "Query", q.query, "QueryArgs", q.args, "Error", err.Error())
+				tx.Rollback()
+				return fmt.Errorf("failed to update %s table: %v", column[0], err)
+			}
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		slog.Warn("Failed to commit moveTag", "Error", err.Error(), "From", from, "To", to)
+		return fmt.Errorf("transaction failed to commit: %v", err)
+	}
+	return nil
+}
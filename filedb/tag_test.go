@@ -0,0 +1,200 @@
+package filedb
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTagParents(t *testing.T) {
+	for tag, expected := range map[string][]string{
+		"anime":                 {},
+		"genre:music":           {},
+		"genre:music/jazz":      {"genre:music"},
+		"genre:music/jazz/bop":  {"genre:music/jazz", "genre:music"},
+		"a/b":                   {"a"},
+		"genre:/music":          {},
+		"series:foo/s1/":        {"series:foo/s1", "series:foo"},
+		"path/like:namespace/x": {"path/like:namespace"},
+	} {
+		assert.Equalf(t, expected, TagParents(tag), "Wrong parents for '%s'", tag)
+	}
+	assert.Equalf(t, "genre", TagNamespace("genre:music/jazz"), "Wrong namespace")
+	assert.Equalf(t, "", TagNamespace("music/jazz"), "Tag without a namespace had one")
+}
+
+func TestTagImplication(t *testing.T) {
+	db := getTestDb(t)
+	defer db.Close()
+	assert.NoErrorf(t, db.AddTagImplication("series:foo", "type:tv"), "AddTagImplication failed")
+	assert.NoErrorf(t, db.AddTagImplication("type:tv", "video"), "AddTagImplication failed")
+	assert.NoErrorf(t, db.AddTagImplication("video", "series:foo"), "Cycles should be allowed")
+	assert.Errorf(t, db.AddTagImplication("series:foo", "type:tv"), "Added a duplicate implication")
+	assert.Errorf(t, db.AddTagImplication("video", "Video"), "A tag implied itself")
+	// Implied tags are added along with the tag, & rules apply to children
+	f := makeTestFile(t, "/foo/s1/e1.mp4")
+	assert.NoErrorf(t, f.AddTag("series:foo/s1"), "AddTag failed")
+	if _, err := db.AddFiles(f); !assert.NoErrorf(t, err, "AddFiles failed") {
+		return
+	}
+	assert.ElementsMatchf(t, []string{"series:foo/s1", "type:tv", "video", "series:foo"}, f.GetTags(), "Implied tags weren't added to the file")
+	fromDb, err := db.GetFileById(f.GetId())
+	if assert.NoErrorf(t, err, "GetFileById failed") {
+		assert.ElementsMatchf(t, f.GetTags(), fromDb.GetTags(), "Implied tags weren't added to the database")
+	}
+	// Updating keeps implied tags, removing them explicitly is kept
+	f.RemoveTag("video")
+	assert.NoErrorf(t, db.UpdateFile(f), "UpdateFile failed")
+	fromDb, err = db.GetFileById(f.GetId())
+	if assert.NoErrorf(t, err, "GetFileById failed") {
+		assert.ElementsMatchf(t, []string{"series:foo/s1", "type:tv", "series:foo"}, fromDb.GetTags(), "Wrong tags after update")
+	}
+	// New rules are applied to existing files
+	assert.NoErrorf(t, db.AddTagImplication("series:foo", "lang:en"), "AddTagImplication failed")
+	fromDb, err = db.GetFileById(f.GetId())
+	if assert.NoErrorf(t, err, "GetFileById failed") {
+		assert.Truef(t, fromDb.HasTag("lang:en"), "New rule wasn't applied to existing files")
+	}
+	assert.NoErrorf(t, db.RemoveTagImplication("series:foo", "lang:en"), "RemoveTagImplication failed")
+	assert.Errorf(t, db.RemoveTagImplication("series:foo", "lang:en"), "Removed a missing implication")
+	implications, err := db.GetTagImplications()
+	if assert.NoErrorf(t, err, "GetTagImplications failed") {
+		assert.Equalf(t, []*TagImplication{{"series:foo", "type:tv"}, {"type:tv", "video"}, {"video", "series:foo"}}, implications, "Wrong implications")
+	}
+}
+
+func TestTagHierarchy(t *testing.T) {
+	db := getTestDb(t)
+	defer db.Close()
+	jazz, music, musical := makeTestFile(t, "/jazz.mp3"), makeTestFile(t, "/music.mp3"), makeTestFile(t, "/musical.mp4")
+	assert.NoErrorf(t, jazz.AddTag("genre:music/jazz"), "AddTag failed")
+	assert.NoErrorf(t, music.AddTag("genre:music"), "AddTag failed")
+	assert.NoErrorf(t, musical.AddTag("genre:musical"), "AddTag failed")
+	assert.NoErrorf(t, musical.AddTag("anime"), "AddTag failed")
+	if _, err := db.AddFiles(jazz, music, musical); !assert.NoErrorf(t, err, "AddFiles failed") {
+		return
+	}
+	search := func(q *SearchQuery) []*File {
+		fs, err := db.SearchFile(q)
+		assert.NoErrorf(t, err, "SearchFile failed")
+		return fs
+	}
+	assert.ElementsMatchf(t, []*File{jazz, music}, search(&SearchQuery{WhitelistTags: []string{"genre:music"}}), "Whitelist didn't match children")
+	assert.ElementsMatchf(t, []*File{musical}, search(&SearchQuery{BlacklistTags: []string{"genre:music"}}), "Blacklist didn't match children")
+	assert.ElementsMatchf(t, []*File{jazz}, search(&SearchQuery{Query: "tag:genre:music/jazz"}), "Query matched parents")
+	assert.ElementsMatchf(t, []*File{jazz, music}, search(&SearchQuery{Query: "tag:Genre:Music"}), "Query didn't match children")
+	assert.NoErrorf(t, db.AddTagImplication("genre:music", "audio"), "AddTagImplication failed")
+	tree, err := db.GetTagTree()
+	if !assert.NoErrorf(t, err, "GetTagTree failed") || !assert.Len(t, tree, 3) {
+		return
+	}
+	// anime, audio, genre:
+	assert.Equalf(t, []string{"anime", "audio", "genre"}, []string{tree[0].Name, tree[1].Name, tree[2].Name}, "Wrong roots")
+	genre := tree[2]
+	assert.Equalf(t, "", genre.Tag, "Namespace node had a tag")
+	if assert.Len(t, genre.Children, 2) {
+		m := genre.Children[0]
+		assert.Equalf(t, "genre:music", m.Tag, "Wrong tag")
+		assert.NotZerof(t, m.Id, "Existing tag had no id")
+		assert.Equalf(t, []string{"audio"}, m.Implies, "Wrong implications")
+		if assert.Len(t, m.Children, 1) {
+			assert.Equalf(t, "jazz", m.Children[0].Name, "Wrong child name")
+			assert.Equalf(t, "genre:music/jazz", m.Children[0].Tag, "Wrong child tag")
+		}
+		assert.Equalf(t, "genre:musical", genre.Children[1].Tag, "Wrong tag")
+	}
+}
+
+func TestTagAlias(t *testing.T) {
+	db := getTestDb(t)
+	defer db.Close()
+	tv := makeTestFile(t, "/tv.mp4")
+	assert.NoErrorf(t, tv.AddTag("type:tv"), "AddTag failed")
+	if _, err := db.AddFiles(tv); !assert.NoErrorf(t, err, "AddFiles failed") {
+		return
+	}
+	assert.NoErrorf(t, db.AddTagAlias("tv", "type:tv"), "AddTagAlias failed")
+	assert.NoErrorf(t, db.AddTagAlias("television", "TV"), "AddTagAlias failed for a alias of a alias")
+	assert.Errorf(t, db.AddTagAlias("type:tv", "video"), "Added a alias with the name of a existing tag")
+	assert.Errorf(t, db.AddTagAlias("tv", "video"), "Added a duplicate alias")
+	assert.Errorf(t, db.AddTagAlias("type:tv", "tv"), "Added a alias of itself")
+	aliases, err := db.GetTagAliases()
+	if assert.NoErrorf(t, err, "GetTagAliases failed") {
+		assert.Equalf(t, []*TagAlias{{"television", "type:tv"}, {"tv", "type:tv"}}, aliases, "Wrong aliases")
+	}
+	// Aliases resolve when adding tags
+	f := makeTestFile(t, "/show.mp4")
+	assert.NoErrorf(t, f.AddTag("Television"), "AddTag failed")
+	assert.NoErrorf(t, f.AddTag("type:tv"), "AddTag failed")
+	if _, err := db.AddFiles(f); !assert.NoErrorf(t, err, "AddFiles failed") {
+		return
+	}
+	assert.Equalf(t, []string{"type:tv"}, f.GetTags(), "Alias wasn't resolved")
+	assert.NotContainsf(t, db.GetAllTags(), "television", "Alias was added as a tag")
+	// & when searching
+	for _, q := range []*SearchQuery{{WhitelistTags: []string{"tv"}}, {Query: "tag:television"}} {
+		fs, err := db.SearchFile(q)
+		if assert.NoErrorf(t, err, "SearchFile failed") {
+			assert.ElementsMatchf(t, []*File{tv, f}, fs, "Alias didn't match in search")
+		}
+	}
+	fs, err := db.SearchFile(&SearchQuery{BlacklistTags: []string{"tv"}})
+	if assert.NoErrorf(t, err, "SearchFile failed") {
+		assert.Emptyf(t, fs, "Alias didn't match in blacklist")
+	}
+	assert.NoErrorf(t, db.RemoveTagAlias("television"), "RemoveTagAlias failed")
+	assert.Errorf(t, db.RemoveTagAlias("television"), "Removed a missing alias")
+}
+
+func TestRenameMergeTags(t *testing.T) {
+	db := getTestDb(t)
+	defer db.Close()
+	music, jazz, songs := makeTestFile(t, "/music.mp3"), makeTestFile(t, "/jazz.mp3"), makeTestFile(t, "/songs.mp3")
+	assert.NoErrorf(t, music.AddTag("genre:music"), "AddTag failed")
+	assert.NoErrorf(t, jazz.AddTag("genre:music/jazz"), "AddTag failed")
+	assert.NoErrorf(t, songs.AddTag("songs"), "AddTag failed")
+	assert.NoErrorf(t, songs.AddTag("songs/jazz"), "AddTag failed")
+	if _, err := db.AddFiles(music, jazz, songs); !assert.NoErrorf(t, err, "AddFiles failed") {
+		return
+	}
+	assert.NoErrorf(t, db.AddTagImplication("genre:music/jazz", "audio"), "AddTagImplication failed")
+	assert.NoErrorf(t, db.AddTagAlias("jazz", "genre:music/jazz"), "AddTagAlias failed")
+	tags := func(f *File) []string {
+		fromDb, err := db.GetFileById(f.GetId())
+		if !assert.NoErrorf(t, err, "GetFileById failed") {
+			return nil
+		}
+		return fromDb.GetTags()
+	}
+	// Renaming moves children, rules & aliases
+	assert.Errorf(t, db.RenameTag("genre:music", "songs"), "Renamed onto a existing tag")
+	assert.Errorf(t, db.RenameTag("genre:music", "genre:music/rock"), "Renamed into a child")
+	assert.Errorf(t, db.RenameTag("missing", "other"), "Renamed a missing tag")
+	if assert.NoErrorf(t, db.RenameTag("Genre:Music", "genre:audio"), "RenameTag failed") {
+		assert.ElementsMatchf(t, []string{"genre:audio/jazz", "audio"}, tags(jazz), "Child wasn't renamed")
+		assert.ElementsMatchf(t, []string{"genre:audio"}, tags(music), "Tag wasn't renamed")
+	}
+	implications, err := db.GetTagImplications()
+	if assert.NoErrorf(t, err, "GetTagImplications failed") {
+		assert.Equalf(t, []*TagImplication{{"genre:audio/jazz", "audio"}}, implications, "Implication wasn't renamed")
+	}
+	aliases, err := db.GetTagAliases()
+	if assert.NoErrorf(t, err, "GetTagAliases failed") {
+		assert.Equalf(t, []*TagAlias{{"jazz", "genre:audio/jazz"}}, aliases, "Alias wasn't renamed")
+	}
+	// Merging combines children that exist in both & renames the rest
+	if assert.NoErrorf(t, db.MergeTags("songs", "genre:audio"), "MergeTags failed") {
+		assert.ElementsMatchf(t, []string{"genre:audio", "genre:audio/jazz"}, tags(songs), "Tags weren't merged")
+	}
+	assert.NotContainsf(t, db.GetAllTags(), "songs", "Merged tag wasn't removed")
+	assert.NotContainsf(t, db.GetAllTags(), "songs/jazz", "Merged child wasn't removed")
+	// Merging into a alias uses its tag, rules that would imply themselves are removed
+	assert.NoErrorf(t, db.AddTagImplication("audio", "genre:audio/jazz"), "AddTagImplication failed for a file that had the tag")
+	if assert.NoErrorf(t, db.MergeTags("audio", "jazz"), "MergeTags failed") {
+		assert.ElementsMatchf(t, []string{"genre:audio/jazz"}, tags(jazz), "Tags weren't merged into the alias")
+	}
+	implications, err = db.GetTagImplications()
+	if assert.NoErrorf(t, err, "GetTagImplications failed") {
+		assert.Emptyf(t, implications, "Implications of a tag to itself weren't removed")
+	}
+}
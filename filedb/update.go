@@ -1,11 +1,24 @@
 package filedb
 
 import (
+	"context"
+	"database/sql"
+	"errors"
 	"fmt"
+	"mediamanager/mediatype"
+	"strings"
+	"time"
 )
 
+// Options used during migration
+type MigrationOpts struct {
+	// Account existing stars & last viewed times are moved to when migrating from 3.X, it is created as a administrator.
+	Account string
+}
+
 type migrationDb struct {
-	f *FileDb
+	f    *FileDb
+	opts *MigrationOpts
 }
 
 func (m *migrationDb) updateRevision() error {
@@ -18,12 +31,18 @@ func (m *migrationDb) updateMinor(to int) error {
 	return err
 }
 
-func (m *migrationDb) updateMajor() error {
-	_, err := m.f.db.Exec("UPDATE db_info SET value = ? WHERE key=\"majorVersion\"", MajorVersion)
-	return err
+// Every 3.X database is moved to 4.X, which needs a account to move stars & last viewed times to.
+func (m *migrationDb) requireAccount() error {
+	if m.opts == nil || m.opts.Account == "" {
+		return errors.New("a destination account is required to migrate from 3.X, stars & last viewed times are moved to it")
+	}
+	return nil
 }
 
 func (m *migrationDb) migrate3(meta *DbMetadata) error {
+	if err := m.requireAccount(); err != nil {
+		return err
+	}
 	switch meta.MinorVersion {
 	case 0:
 		// Changes how databases metadata is stored - This was  before migration reworks.
@@ -51,6 +70,633 @@ func (m *migrationDb) migrate3(meta *DbMetadata) error {
 		fmt.Printf("+ Done\n")
 		fallthrough
 	case 2:
+		// Latest 3.X, move to 4.X
+		return m.migrate3To4()
+	default:
+		return fmt.Errorf("unsupported version, max version is %s", FormatVersion(MajorVersion, MinorVersion, Revision))
+	}
+}
+
+// A file from a 3.X database
+type file3 struct {
+	id         int
+	path       string
+	lastViewed sql.NullInt64
+	stars      sql.NullInt64
+	size       sql.NullInt64
+	hash       sql.NullString
+}
+
+// Moves a 3.2rX database to 4.0rX.
+//
+// Every table is recreated as STRICT, the files stars & last viewed time are moved into the destination account
+// and the file names are set to the last element of the path.
+func (m *migrationDb) migrate3To4() error {
+	if err := m.requireAccount(); err != nil {
+		return err
+	}
+	fmt.Printf("* Migrating from 3.2rX to 4.0rX\n")
+	// Foreign keys can't be changed in a transaction & we need them off to rebuild the tables, so we need to hold onto a single connection.
+	ctx := context.Background()
+	conn, err := m.f.db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get database connection: %v", err)
+	}
+	defer conn.Close()
+	_, err = conn.ExecContext(ctx, "PRAGMA foreign_keys = false")
+	if err != nil {
+		return fmt.Errorf("failed to disable foreign keys: %v", err)
+	}
+	defer conn.ExecContext(ctx, "PRAGMA foreign_keys = true")
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %v", err)
+	}
+	err = m.rebuild3To4(tx)
+	if err != nil {
+		fmt.Printf("  ! Failed: %v\n", err)
+		tx.Rollback()
+		return err
+	}
+	fmt.Printf("  | Checking foreign keys\n")
+	rows, err := tx.Query("PRAGMA foreign_key_check")
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to check foreign keys: %v", err)
+	}
+	violations := 0
+	for rows.Next() {
+		violations++
+	}
+	rows.Close()
+	if violations != 0 {
+		fmt.Printf("  ! %d foreign key violations\n", violations)
+		tx.Rollback()
+		return fmt.Errorf("migrated database has %d foreign key violations", violations)
+	}
+	err = tx.Commit()
+	if err != nil {
+		fmt.Printf("  ! Failed to commit: %v\n", err)
+		return fmt.Errorf("failed to commit migration: %v", err)
+	}
+	m.f.safeMode = false
+	fmt.Printf("+ Done\n")
+	return nil
+}
+
+// Does the work of migrate3To4, the transaction is expected to be rolled back if this fails.
+func (m *migrationDb) rebuild3To4(tx *sql.Tx) error {
+	fmt.Printf("  | Renaming 3.X tables\n")
+	for _, v := range []string{"db_info", "file", "tag_name", "tag"} {
+		_, err := tx.Exec(fmt.Sprintf("ALTER TABLE %s RENAME TO %s_3", v, v))
+		if err != nil {
+			return fmt.Errorf("failed to rename '%s': %v", v, err)
+		}
+	}
+	fmt.Printf("  | Creating 4.X tables\n")
+	err := createSchema(tx)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("  | Copying metadata\n")
+	_, err = tx.Exec("INSERT INTO db_info(key, value) SELECT key, value FROM db_info_3 WHERE key NOT IN ('majorVersion', 'minorVersion', 'revision', 'versionName')")
+	if err != nil {
+		return fmt.Errorf("failed to copy metadata: %v", err)
+	}
+	for _, v := range [][2]any{{"majorVersion", MajorVersion}, {"minorVersion", MinorVersion}, {"revision", Revision}, {"versionName", VersionCodeName}} {
+		_, err = tx.Exec("INSERT INTO db_info(key, value) VALUES (?, ?)", v[0], v[1])
+		if err != nil {
+			return fmt.Errorf("failed to set '%s': %v", v[0], err)
+		}
+	}
+	fmt.Printf("  | Creating account '%s'\n", m.opts.Account)
+	res, err := tx.Exec("INSERT INTO user(name, accountType, created) VALUES (?, ?, ?)", m.opts.Account, AccountTypeAdministrator, time.Now().Unix())
+	if err != nil {
+		return fmt.Errorf("failed to create account '%s': %v", m.opts.Account, err)
+	}
+	userId, err := res.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("failed to get account id: %v", err)
+	}
+	// Read every file first, we can't have rows open while we insert.
+	fmt.Printf("  | Reading files\n")
+	rows, err := tx.Query("SELECT id, path, lastViewed, stars, size, hash FROM file_3")
+	if err != nil {
+		return fmt.Errorf("failed to read files: %v", err)
+	}
+	files := make([]*file3, 0)
+	for rows.Next() {
+		f := &file3{}
+		err = rows.Scan(&f.id, &f.path, &f.lastViewed, &f.stars, &f.size, &f.hash)
+		if err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to read file: %v", err)
+		}
+		files = append(files, f)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("failed to read files: %v", err)
+	}
+	fmt.Printf("  | Copying %d files\n", len(files))
+	userFiles := 0
+	for _, f := range files {
+		_, err = tx.Exec("INSERT INTO file(id, path, name, size, hash) VALUES (?, ?, ?, ?, ?)", f.id, f.path, defaultFileName(f.path), f.size, f.hash)
+		if err != nil {
+			return fmt.Errorf("failed to copy file '%s' (%d): %v", f.path, f.id, err)
+		}
+		if f.stars.Int64 == 0 && f.lastViewed.Int64 == 0 {
+			// Nothing to keep
+			continue
+		}
+		_, err = tx.Exec("INSERT INTO user_file(userId, fileId, stars, lastViewed) VALUES (?, ?, ?, ?)", userId, f.id, f.stars.Int64, f.lastViewed.Int64)
+		if err != nil {
+			return fmt.Errorf("failed to copy stars & last viewed time of file '%s' (%d): %v", f.path, f.id, err)
+		}
+		userFiles++
+	}
+	fmt.Printf("  + Moved stars & last viewed times of %d files to '%s'\n", userFiles, m.opts.Account)
+	fmt.Printf("  | Copying tags\n")
+	_, err = tx.Exec("INSERT INTO tag_name(id, value) SELECT id, value FROM tag_name_3")
+	if err != nil {
+		return fmt.Errorf("failed to copy tag names: %v", err)
+	}
+	_, err = tx.Exec("INSERT INTO tag(fileId, tagNameId) SELECT fileId, tagNameId FROM tag_3")
+	if err != nil {
+		return fmt.Errorf("failed to copy tags: %v", err)
+	}
+	err = convertCollectionTags(tx)
+	if err != nil {
+		return err
+	}
+	err = setDefaultTypes(tx)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("  | Removing 3.X tables\n")
+	for _, v := range []string{"tag_3", "tag_name_3", "file_3", "db_info_3"} {
+		_, err = tx.Exec(fmt.Sprintf("DROP TABLE %s", v))
+		if err != nil {
+			return fmt.Errorf("failed to drop '%s': %v", v, err)
+		}
+	}
+	return nil
+}
+
+// Moves a 4.0rX database to 4.1rX, which adds collections & converts 'collection:<Name>' tags into them.
+func (m *migrationDb) migrate40To41() error {
+	fmt.Printf("* Migrating from 4.0rX to 4.1rX\n")
+	tx, err := m.f.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %v", err)
+	}
+	// 4.0 databases made before API keys, sessions, passwords & saved searches were added to 4.0 get them here too
+	fmt.Printf("  | Creating 4.1 tables\n")
+	err = createMissingSchema(tx)
+	if err != nil {
+		fmt.Printf("  ! Failed: %v\n", err)
+		tx.Rollback()
+		return err
+	}
+	err = addMissingColumn(tx, "user", "password", "TEXT")
+	if err != nil {
+		fmt.Printf("  ! Failed: %v\n", err)
+		tx.Rollback()
+		return err
+	}
+	err = convertCollectionTags(tx)
+	if err != nil {
+		fmt.Printf("  ! Failed: %v\n", err)
+		tx.Rollback()
+		return err
+	}
+	_, err = tx.Exec("UPDATE db_info SET value = 1 WHERE key=\"minorVersion\"")
+	if err != nil {
+		fmt.Printf("  ! Failed to update version: %v\n", err)
+		tx.Rollback()
+		return err
+	}
+	err = tx.Commit()
+	if err != nil {
+		fmt.Printf("  ! Failed to commit: %v\n", err)
+		return fmt.Errorf("failed to commit migration: %v", err)
+	}
+	m.f.safeMode = false
+	fmt.Printf("+ Done\n")
+	return nil
+}
+
+// Add a column to a table that doesn't have it
+func addMissingColumn(tx *sql.Tx, table, column, definition string) error {
+	exists := 0
+	err := tx.QueryRow("SELECT COUNT(*) FROM pragma_table_info(?) WHERE name=?", table, column).Scan(&exists)
+	if err != nil {
+		return fmt.Errorf("failed to check for '%s' column: %v", column, err)
+	}
+	if exists != 0 {
+		return nil
+	}
+	fmt.Printf("  | Adding '%s' to %s\n", column, table)
+	_, err = tx.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	if err != nil {
+		return fmt.Errorf("failed to add %s column: %v", column, err)
+	}
+	return nil
+}
+
+// Turn every 'collection:<Name>' tag into a collection & remove the tag.
+//
+// Files with a 'colindex:<Index>' tag go first ordered by it, followed by the rest ordered by path. The colindex tags are
+// removed afterwards since they only ordered collections.
+func convertCollectionTags(tx *sql.Tx) error {
+	fmt.Printf("  | Converting collection tags\n")
+	rows, err := tx.Query("SELECT id, value FROM tag_name WHERE substr(value, 1, 11) = 'collection:'")
+	if err != nil {
+		return fmt.Errorf("failed to get collection tags: %v", err)
+	}
+	type collectionTag struct {
+		id   int
+		name string
+	}
+	tags := make([]collectionTag, 0)
+	for rows.Next() {
+		t := collectionTag{}
+		err = rows.Scan(&t.id, &t.name)
+		if err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to read collection tag: %v", err)
+		}
+		t.name = strings.TrimPrefix(t.name, "collection:")
+		tags = append(tags, t)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("failed to get collection tags: %v", err)
+	}
+	now := time.Now().Unix()
+	for _, t := range tags {
+		if t.name == "" {
+			fmt.Printf("  - Skipping 'collection:' tag without a name\n")
+			continue
+		}
+		res, err := tx.Exec("INSERT INTO collection(name, description, created) VALUES (?, '', ?)", t.name, now)
+		if err != nil {
+			return fmt.Errorf("failed to create collection '%s': %v", t.name, err)
+		}
+		id, err := res.LastInsertId()
+		if err != nil {
+			return fmt.Errorf("failed to get collection id: %v", err)
+		}
+		rows, err := tx.Query(`SELECT t.fileId FROM tag t JOIN file f ON f.id = t.fileId
+		LEFT JOIN (SELECT it.fileId, MIN(CAST(substr(itn.value, 10) AS INTEGER)) AS idx FROM tag it JOIN tag_name itn ON itn.id = it.tagNameId WHERE substr(itn.value, 1, 9) = 'colindex:' GROUP BY it.fileId) i ON i.fileId = t.fileId
+		WHERE t.tagNameId = ? ORDER BY i.idx IS NULL, i.idx, f.path`, t.id)
+		if err != nil {
+			return fmt.Errorf("failed to get files of collection '%s': %v", t.name, err)
+		}
+		ids := make([]int, 0)
+		for rows.Next() {
+			fileId := 0
+			err = rows.Scan(&fileId)
+			if err != nil {
+				rows.Close()
+				return fmt.Errorf("failed to read files of collection '%s': %v", t.name, err)
+			}
+			ids = append(ids, fileId)
+		}
+		rows.Close()
+		if err = rows.Err(); err != nil {
+			return fmt.Errorf("failed to get files of collection '%s': %v", t.name, err)
+		}
+		err = setCollectionItemIds(tx, int(id), ids)
+		if err != nil {
+			return fmt.Errorf("failed to fill collection '%s': %v", t.name, err)
+		}
+		_, err = tx.Exec("DELETE FROM tag WHERE tagNameId=?", t.id)
+		if err != nil {
+			return fmt.Errorf("failed to remove tag 'collection:%s' from files: %v", t.name, err)
+		}
+		_, err = tx.Exec("DELETE FROM tag_name WHERE id=?", t.id)
+		if err != nil {
+			return fmt.Errorf("failed to remove tag 'collection:%s': %v", t.name, err)
+		}
+		fmt.Printf("  + Converted 'collection:%s' with %d files\n", t.name, len(ids))
+	}
+	_, err = tx.Exec("DELETE FROM tag WHERE tagNameId IN (SELECT id FROM tag_name WHERE substr(value, 1, 9) = 'colindex:')")
+	if err != nil {
+		return fmt.Errorf("failed to remove colindex tags from files: %v", err)
+	}
+	_, err = tx.Exec("DELETE FROM tag_name WHERE substr(value, 1, 9) = 'colindex:'")
+	if err != nil {
+		return fmt.Errorf("failed to remove colindex tags: %v", err)
+	}
+	return nil
+}
+
+// Moves a 4.(minor-1)rX database to 4.(minor)rX for versions that only add tables.
+func (m *migrationDb) migrateNewTables(minor int) error {
+	fmt.Printf("* Migrating from 4.%drX to 4.%drX\n", minor-1, minor)
+	tx, err := m.f.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %v", err)
+	}
+	fmt.Printf("  | Creating 4.%d tables\n", minor)
+	err = createMissingSchema(tx)
+	if err != nil {
+		fmt.Printf("  ! Failed: %v\n", err)
+		tx.Rollback()
+		return err
+	}
+	_, err = tx.Exec("UPDATE db_info SET value = ? WHERE key=\"minorVersion\"", minor)
+	if err != nil {
+		fmt.Printf("  ! Failed to update version: %v\n", err)
+		tx.Rollback()
+		return err
+	}
+	err = tx.Commit()
+	if err != nil {
+		fmt.Printf("  ! Failed to commit: %v\n", err)
+		return fmt.Errorf("failed to commit migration: %v", err)
+	}
+	m.f.safeMode = false
+	fmt.Printf("+ Done\n")
+	return nil
+}
+
+// Moves a 4.1rX database to 4.2rX, which adds tag implication rules.
+func (m *migrationDb) migrate41To42() error {
+	return m.migrateNewTables(2)
+}
+
+// Moves a 4.2rX database to 4.3rX, which adds tag aliases.
+func (m *migrationDb) migrate42To43() error {
+	return m.migrateNewTables(3)
+}
+
+// Moves a 4.3rX database to 4.4rX, which adds embedded file metadata.
+func (m *migrationDb) migrate43To44() error {
+	return m.migrateNewTables(4)
+}
+
+// Moves a 4.4rX database to 4.5rX, which adds duplicate file locations.
+func (m *migrationDb) migrate44To45() error {
+	return m.migrateNewTables(5)
+}
+
+// Moves a 4.5rX database to 4.6rX, which adds perceptual hashes to files.
+func (m *migrationDb) migrate45To46() error {
+	fmt.Printf("* Migrating from 4.5rX to 4.6rX\n")
+	tx, err := m.f.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %v", err)
+	}
+	fmt.Printf("  | Adding 'phash' to files\n")
+	_, err = tx.Exec("ALTER TABLE file ADD COLUMN phash INTEGER")
+	if err != nil {
+		fmt.Printf("  ! Failed: %v\n", err)
+		tx.Rollback()
+		return fmt.Errorf("failed to add phash column: %v", err)
+	}
+	_, err = tx.Exec("UPDATE db_info SET value = 6 WHERE key=\"minorVersion\"")
+	if err != nil {
+		fmt.Printf("  ! Failed to update version: %v\n", err)
+		tx.Rollback()
+		return err
+	}
+	err = tx.Commit()
+	if err != nil {
+		fmt.Printf("  ! Failed to commit: %v\n", err)
+		return fmt.Errorf("failed to commit migration: %v", err)
+	}
+	m.f.safeMode = false
+	fmt.Printf("+ Done\n")
+	return nil
+}
+
+// Moves a 4.6rX database to 4.7rX, which marks files that were found missing from disk.
+func (m *migrationDb) migrate46To47() error {
+	fmt.Printf("* Migrating from 4.6rX to 4.7rX\n")
+	tx, err := m.f.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %v", err)
+	}
+	fmt.Printf("  | Adding 'missing' to files\n")
+	_, err = tx.Exec("ALTER TABLE file ADD COLUMN missing INTEGER")
+	if err != nil {
+		fmt.Printf("  ! Failed: %v\n", err)
+		tx.Rollback()
+		return fmt.Errorf("failed to add missing column: %v", err)
+	}
+	_, err = tx.Exec("UPDATE db_info SET value = 7 WHERE key=\"minorVersion\"")
+	if err != nil {
+		fmt.Printf("  ! Failed to update version: %v\n", err)
+		tx.Rollback()
+		return err
+	}
+	err = tx.Commit()
+	if err != nil {
+		fmt.Printf("  ! Failed to commit: %v\n", err)
+		return fmt.Errorf("failed to commit migration: %v", err)
+	}
+	m.f.safeMode = false
+	fmt.Printf("+ Done\n")
+	return nil
+}
+
+// Moves a 4.7rX database to 4.8rX, which adds modification times to files.
+func (m *migrationDb) migrate47To48() error {
+	fmt.Printf("* Migrating from 4.7rX to 4.8rX\n")
+	tx, err := m.f.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %v", err)
+	}
+	fmt.Printf("  | Adding 'mtime' to files\n")
+	_, err = tx.Exec("ALTER TABLE file ADD COLUMN mtime INTEGER")
+	if err != nil {
+		fmt.Printf("  ! Failed: %v\n", err)
+		tx.Rollback()
+		return fmt.Errorf("failed to add mtime column: %v", err)
+	}
+	_, err = tx.Exec("UPDATE db_info SET value = 8 WHERE key=\"minorVersion\"")
+	if err != nil {
+		fmt.Printf("  ! Failed to update version: %v\n", err)
+		tx.Rollback()
+		return err
+	}
+	err = tx.Commit()
+	if err != nil {
+		fmt.Printf("  ! Failed to commit: %v\n", err)
+		return fmt.Errorf("failed to commit migration: %v", err)
+	}
+	m.f.safeMode = false
+	fmt.Printf("+ Done\n")
+	return nil
+}
+
+// Set the MIME type & category of every file to the default type of its extension, used by migrations from before 4.9
+func setDefaultTypes(tx *sql.Tx) error {
+	fmt.Printf("  | Setting types by extension\n")
+	rows, err := tx.Query("SELECT id, path FROM file")
+	if err != nil {
+		return fmt.Errorf("failed to get files: %v", err)
+	}
+	types := mediatype.Default()
+	found := make(map[int]*mediatype.Type)
+	for rows.Next() {
+		var id int
+		var path string
+		if err = rows.Scan(&id, &path); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan file: %v", err)
+		}
+		if t := types.ByExtension(path); t != nil {
+			found[id] = t
+		}
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("failed to get files: %v", err)
+	}
+	for id, t := range found {
+		_, err = tx.Exec("UPDATE file SET mime=?, category=? WHERE id=?", t.Mime, string(t.Category), id)
+		if err != nil {
+			return fmt.Errorf("failed to set type of file %d: %v", id, err)
+		}
+	}
+	fmt.Printf("  | %d files have a type\n", len(found))
+	return nil
+}
+
+// Moves a 4.8rX database to 4.9rX, which adds MIME types & categories to files. Files get the default type of their extension
+func (m *migrationDb) migrate48To49() error {
+	fmt.Printf("* Migrating from 4.8rX to 4.9rX\n")
+	tx, err := m.f.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %v", err)
+	}
+	fmt.Printf("  | Adding 'mime' & 'category' to files\n")
+	for _, q := range []string{"ALTER TABLE file ADD COLUMN mime TEXT", "ALTER TABLE file ADD COLUMN category TEXT"} {
+		_, err = tx.Exec(q)
+		if err != nil {
+			fmt.Printf("  ! Failed: %v\n", err)
+			tx.Rollback()
+			return fmt.Errorf("failed to add column: %v", err)
+		}
+	}
+	err = setDefaultTypes(tx)
+	if err != nil {
+		fmt.Printf("  ! Failed: %v\n", err)
+		tx.Rollback()
+		return err
+	}
+	_, err = tx.Exec("UPDATE db_info SET value = 9 WHERE key=\"minorVersion\"")
+	if err != nil {
+		fmt.Printf("  ! Failed to update version: %v\n", err)
+		tx.Rollback()
+		return err
+	}
+	err = tx.Commit()
+	if err != nil {
+		fmt.Printf("  ! Failed to commit: %v\n", err)
+		return fmt.Errorf("failed to commit migration: %v", err)
+	}
+	m.f.safeMode = false
+	fmt.Printf("+ Done\n")
+	return nil
+}
+
+// Moves a 4.9rX database to 4.10rX, which saves SearchQuery.IgnoreUnsetStars with saved searches
+func (m *migrationDb) migrate49To410() error {
+	fmt.Printf("* Migrating from 4.9rX to 4.10rX\n")
+	tx, err := m.f.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %v", err)
+	}
+	// Already added by migrations that create the saved_search table
+	err = addMissingColumn(tx, "saved_search", "ignoreUnsetStars", "INTEGER NOT NULL DEFAULT 0")
+	if err != nil {
+		fmt.Printf("  ! Failed: %v\n", err)
+		tx.Rollback()
+		return err
+	}
+	_, err = tx.Exec("UPDATE db_info SET value = 10 WHERE key=\"minorVersion\"")
+	if err != nil {
+		fmt.Printf("  ! Failed to update version: %v\n", err)
+		tx.Rollback()
+		return err
+	}
+	err = tx.Commit()
+	if err != nil {
+		fmt.Printf("  ! Failed to commit: %v\n", err)
+		return fmt.Errorf("failed to commit migration: %v", err)
+	}
+	m.f.safeMode = false
+	fmt.Printf("+ Done\n")
+	return nil
+}
+
+func (m *migrationDb) migrate4(meta *DbMetadata) error {
+	switch meta.MinorVersion {
+	case 0:
+		err := m.migrate40To41()
+		if err != nil {
+			return err
+		}
+		fallthrough
+	case 1:
+		err := m.migrate41To42()
+		if err != nil {
+			return err
+		}
+		fallthrough
+	case 2:
+		err := m.migrate42To43()
+		if err != nil {
+			return err
+		}
+		fallthrough
+	case 3:
+		err := m.migrate43To44()
+		if err != nil {
+			return err
+		}
+		fallthrough
+	case 4:
+		err := m.migrate44To45()
+		if err != nil {
+			return err
+		}
+		fallthrough
+	case 5:
+		err := m.migrate45To46()
+		if err != nil {
+			return err
+		}
+		fallthrough
+	case 6:
+		err := m.migrate46To47()
+		if err != nil {
+			return err
+		}
+		fallthrough
+	case 7:
+		err := m.migrate47To48()
+		if err != nil {
+			return err
+		}
+		fallthrough
+	case 8:
+		err := m.migrate48To49()
+		if err != nil {
+			return err
+		}
+		fallthrough
+	case 9:
+		err := m.migrate49To410()
+		if err != nil {
+			return err
+		}
+		fallthrough
+	case 10:
 		// Latest
 	default:
 		return fmt.Errorf("unsupported version, max version is %s", FormatVersion(MajorVersion, MinorVersion, Revision))
@@ -73,14 +719,22 @@ func (m *migrationDb) MigrateToLatest() error {
 		return fmt.Errorf("version %d (%s) databases cannot be migrated", meta.MajorVersion, MajorVersionToCodeName(meta.MajorVersion))
 	case 3:
 		return m.migrate3(meta)
+	case 4:
+		return m.migrate4(meta)
 	default:
 		return fmt.Errorf("unsupported major version %d", meta.MajorVersion)
 	}
 }
 
-func DoMigration(d *FileDb) error {
+// Migrate the database to the latest version, opts may be nil if the migration doesn't need them.
+func DoMigration(d *FileDb, opts *MigrationOpts) error {
 	m := &migrationDb{
-		f: d,
+		f:    d,
+		opts: opts,
+	}
+	err := m.MigrateToLatest()
+	if err != nil {
+		return err
 	}
-	return m.MigrateToLatest()
+	return d.setupSearchIndex()
 }
@@ -0,0 +1,210 @@
+package filedb
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Create a 3.2r1 database at path, with 2 files: 'dir/a.mp4' (4 stars, tag 'a') & 'b.png' (no stars, tag 'b')
+func makeTestDb3(t *testing.T, path string) {
+	db, err := sql.Open("sqlite3-re", path)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	defer db.Close()
+	for _, q := range []string{
+		`CREATE TABLE db_info (
+			key TEXT UNIQUE NOT NULL ,
+			value ANY
+		)`,
+		`CREATE TABLE file (
+		id INTEGER PRIMARY KEY AUTOINCREMENT UNIQUE NOT NULL,
+		path TEXT NOT NULL UNIQUE,
+		lastViewed INTEGER NOT NULL,
+		stars INTEGER,
+		size INTEGER,
+		hash TEXT UNIQUE,
+		CHECK(HASH is NULL OR length(HASH) == 64),
+		CHECK(stars >= 0 AND stars <= 5)
+		)`,
+		`CREATE TABLE tag (
+		fileId INTEGER NOT NULL,
+		tagNameId INTEGER NOT NULL,
+		FOREIGN KEY (fileId) REFERENCES file(id),
+		FOREIGN KEY (tagNameId) REFERENCES tag_name(id),
+		UNIQUE(fileId, tagNameId))`,
+		`CREATE TABLE tag_name (
+		id INTEGER PRIMARY KEY UNIQUE NOT NULL,
+		value TEXT NOT NULL UNIQUE)`,
+		"INSERT INTO db_info VALUES ('majorVersion', 3), ('minorVersion', 2), ('revision', 1), ('versionName', 'EcstacyInGrief')",
+		"INSERT INTO file(path, lastViewed, stars, size) VALUES ('dir/a.mp4', 1000, 4, 500), ('b.png', 0, NULL, NULL)",
+		"INSERT INTO tag_name(id, value) VALUES (1, 'a'), (2, 'b')",
+		"INSERT INTO tag(fileId, tagNameId) VALUES (1, 1), (2, 2)",
+	} {
+		_, err = db.Exec(q)
+		if err != nil {
+			t.Fatalf("Failed to create 3.2 database (%s): %v", q, err)
+		}
+	}
+}
+
+func TestMigrate3To4(t *testing.T) {
+	path := getTestPath(t)
+	makeTestDb3(t, path)
+	db, err := NewFileDb(path)
+	if !assert.NoErrorf(t, err, "NewFileDb failed") {
+		return
+	}
+	defer db.Close()
+	assert.Truef(t, db.IsSafeMode(), "3.X database wasn't opened in safe mode")
+	// A account is required
+	assert.Errorf(t, DoMigration(db, nil), "Migration without a account worked")
+	assert.Errorf(t, DoMigration(db, &MigrationOpts{}), "Migration without a account worked")
+	if !assert.NoErrorf(t, DoMigration(db, &MigrationOpts{Account: "alex"}), "DoMigration failed") {
+		return
+	}
+	assert.Falsef(t, db.IsSafeMode(), "Database still in safe mode after migration")
+	meta, err := db.GetMetadata()
+	if assert.NoErrorf(t, err, "GetMetadata failed") {
+		assert.Equalf(t, FormatVersion(MajorVersion, MinorVersion, Revision), meta.VersionString(), "Version wasn't updated")
+		assert.Equalf(t, VersionCodeName, meta.VersionCodeName, "Version name wasn't updated")
+	}
+	// Every table should be STRICT
+	for _, v := range schema {
+		strict := 0
+		err = db.db.QueryRow("SELECT strict FROM pragma_table_list WHERE name=?", v.Name).Scan(&strict)
+		if assert.NoErrorf(t, err, "Failed to get table info for '%s'", v.Name) {
+			assert.Equalf(t, 1, strict, "Table '%s' isn't STRICT", v.Name)
+		}
+	}
+	u, err := db.GetUserByName("alex")
+	if !assert.NoErrorf(t, err, "Destination account wasn't created") {
+		return
+	}
+	assert.Equalf(t, AccountTypeAdministrator, u.GetAccountType(), "Destination account should be a administrator")
+	a, err := db.GetFileByPath("dir/a.mp4")
+	if !assert.NoErrorf(t, err, "GetFileByPath(dir/a.mp4) failed") {
+		return
+	}
+	b, err := db.GetFileByPath("b.png")
+	if !assert.NoErrorf(t, err, "GetFileByPath(b.png) failed") {
+		return
+	}
+	assert.NoErrorf(t, db.LoadUserData(u, a, b), "LoadUserData failed")
+	assert.Equalf(t, "a.mp4", a.GetName(), "Name wasn't set from path")
+	assert.Equalf(t, uint8(4), a.GetStars(), "Stars weren't moved to account")
+	assert.Equalf(t, time.Unix(1000, 0), a.GetLastPlayTime(), "Last viewed time wasn't moved to account")
+	assert.Equalf(t, int64(500), a.GetSize(), "Size wasn't kept")
+	assert.Equalf(t, []string{"a"}, a.GetTags(), "Tags weren't kept")
+	assert.Equalf(t, "video/mp4", a.GetMime(), "Type wasn't set from extension")
+	assert.Equalf(t, "video", a.GetCategory(), "Category wasn't set from extension")
+	assert.Equalf(t, "image/png", b.GetMime(), "Type wasn't set from extension")
+	assert.Equalf(t, "b.png", b.GetName(), "Name wasn't set from path")
+	assert.Equalf(t, uint8(0), b.GetStars(), "File without stars got stars")
+	assert.Equalf(t, []string{"b"}, b.GetTags(), "Tags weren't kept")
+	// New files should work as normal
+	assert.NoErrorf(t, db.AddFile(makeTestFile(t, "c")), "AddFile failed after migration")
+	// Nothing else to do
+	assert.NoErrorf(t, DoMigration(db, nil), "Migrating a up to date database failed")
+}
+
+func TestMigrate40To41(t *testing.T) {
+	path := getTestPath(t)
+	db, err := NewFileDb(path)
+	if !assert.NoErrorf(t, err, "NewFileDb failed") {
+		return
+	}
+	// Collections were tags in 4.0
+	e2, e10, extra := makeTestFile(t, "/s/e2.mp4"), makeTestFile(t, "/s/e10.mp4"), makeTestFile(t, "/s/extra.mp4")
+	for _, v := range []string{"collection:Season 1", "colindex:2"} {
+		assert.NoErrorf(t, e2.AddTag(v), "AddTag failed")
+	}
+	for _, v := range []string{"collection:Season 1", "colindex:10"} {
+		assert.NoErrorf(t, e10.AddTag(v), "AddTag failed")
+	}
+	assert.NoErrorf(t, extra.AddTag("collection:Season 1"), "AddTag failed")
+	if _, err := db.AddFiles(e10, extra, e2); !assert.NoErrorf(t, err, "AddFiles failed") {
+		return
+	}
+	// The first 4.0 databases didn't have API keys, sessions, passwords or saved searches
+	for _, q := range []string{"DROP TABLE api_key", "DROP TABLE session", "DROP TABLE saved_search", "ALTER TABLE user DROP COLUMN password", "DROP TABLE collection_item", "DROP TABLE collection", "DROP TABLE tag_implication", "DROP TABLE tag_alias", "DROP TABLE file_meta", "DROP TABLE file_location", "ALTER TABLE file DROP COLUMN phash", "ALTER TABLE file DROP COLUMN missing", "ALTER TABLE file DROP COLUMN mtime", "ALTER TABLE file DROP COLUMN mime", "ALTER TABLE file DROP COLUMN category", "UPDATE db_info SET value = 0 WHERE key=\"minorVersion\""} {
+		_, err = db.db.Exec(q)
+		assert.NoErrorf(t, err, "Failed to make 4.0 database (%s)", q)
+	}
+	db.Close()
+	db, err = NewFileDb(path)
+	if !assert.NoErrorf(t, err, "NewFileDb failed") {
+		return
+	}
+	defer db.Close()
+	assert.Truef(t, db.IsSafeMode(), "4.0 database wasn't opened in safe mode")
+	if !assert.NoErrorf(t, DoMigration(db, nil), "DoMigration failed") {
+		return
+	}
+	assert.Falsef(t, db.IsSafeMode(), "Database still in safe mode after migration")
+	meta, err := db.GetMetadata()
+	if assert.NoErrorf(t, err, "GetMetadata failed") {
+		assert.Equalf(t, FormatVersion(MajorVersion, MinorVersion, Revision), meta.VersionString(), "Version wasn't updated")
+	}
+	c, err := db.GetCollection("season 1")
+	if !assert.NoErrorf(t, err, "Collection tag wasn't converted") {
+		return
+	}
+	fs, err := db.GetCollectionFiles(c, 0)
+	if assert.NoErrorf(t, err, "GetCollectionFiles failed") && assert.Len(t, fs, 3) {
+		// colindex first, then by path
+		assert.Equalf(t, []string{"/s/e2.mp4", "/s/e10.mp4", "/s/extra.mp4"}, []string{fs[0].GetPath(), fs[1].GetPath(), fs[2].GetPath()}, "Wrong collection order")
+		assert.Emptyf(t, fs[0].GetTags(), "Collection tags weren't removed")
+	}
+	assert.Emptyf(t, db.GetAllTags(), "Collection tags weren't removed")
+	// Types were set by extension
+	assert.Equalf(t, "video/mp4", fs[0].GetMime(), "Type wasn't set")
+	u, err := db.AddUser("alex", AccountTypeAdministrator)
+	if assert.NoErrorf(t, err, "AddUser failed") && assert.NoErrorf(t, db.SetPassword(u, "password"), "Password column wasn't added") {
+		_, err = db.CheckPassword("alex", "password")
+		assert.NoErrorf(t, err, "CheckPassword failed")
+		_, _, err = db.AddApiKey(u, "key", PermissionRead)
+		assert.NoErrorf(t, err, "api_key table wasn't created")
+	}
+}
+
+func TestMigrate49To410(t *testing.T) {
+	path := getTestPath(t)
+	db, err := NewFileDb(path)
+	if !assert.NoErrorf(t, err, "NewFileDb failed") {
+		return
+	}
+	u, err := db.AddUser("alex", AccountTypeAdministrator)
+	if !assert.NoErrorf(t, err, "AddUser failed") {
+		return
+	}
+	s, err := db.AddSavedSearch(u, "popular", &SearchQuery{SortBy: SortMethodAverageStars})
+	if !assert.NoErrorf(t, err, "AddSavedSearch failed") {
+		return
+	}
+	// 4.9 didn't save IgnoreUnsetStars
+	for _, q := range []string{"ALTER TABLE saved_search DROP COLUMN ignoreUnsetStars", "UPDATE db_info SET value = 9 WHERE key=\"minorVersion\""} {
+		_, err = db.db.Exec(q)
+		assert.NoErrorf(t, err, "Failed to make 4.9 database (%s)", q)
+	}
+	db.Close()
+	db, err = NewFileDb(path)
+	if !assert.NoErrorf(t, err, "NewFileDb failed") {
+		return
+	}
+	defer db.Close()
+	assert.Truef(t, db.IsSafeMode(), "4.9 database wasn't opened in safe mode")
+	if !assert.NoErrorf(t, DoMigration(db, nil), "DoMigration failed") {
+		return
+	}
+	assert.Falsef(t, db.IsSafeMode(), "Database still in safe mode after migration")
+	fromDb, err := db.GetSavedSearchById(s.GetId())
+	if assert.NoErrorf(t, err, "Saved search wasn't kept") {
+		assert.Falsef(t, fromDb.GetIgnoreUnsetStars(), "Old saved search ignores unset stars")
+	}
+	_, err = db.AddSavedSearch(u, "popular ignoring unset", &SearchQuery{SortBy: SortMethodAverageStars, IgnoreUnsetStars: true})
+	assert.NoErrorf(t, err, "AddSavedSearch failed after migration")
+}
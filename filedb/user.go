@@ -0,0 +1,526 @@
+package filedb
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Type of account, this controls what a user is allowed to do
+type AccountType int
+
+const (
+	AccountTypeGuest         AccountType = iota // Cannot modify the database at all, including stars & last viewed times.
+	AccountTypeUser                             // Can set their own stars & last viewed times.
+	AccountTypeModerator                        // Can add/remove global file tags.
+	AccountTypeAdministrator                    // Can add/remove tags, files and users.
+)
+
+func (a AccountType) String() string {
+	switch a {
+	case AccountTypeGuest:
+		return "guest"
+	case AccountTypeUser:
+		return "user"
+	case AccountTypeModerator:
+		return "moderator"
+	case AccountTypeAdministrator:
+		return "administrator"
+	default:
+		return fmt.Sprintf("<Unknown account type '%d'>", int(a))
+	}
+}
+
+// Checks the account type is a known value
+func (a AccountType) IsValid() bool {
+	return a >= AccountTypeGuest && a <= AccountTypeAdministrator
+}
+
+// Parse a account type from its name, as returned by AccountType.String
+func ParseAccountType(s string) (AccountType, error) {
+	switch strings.ToLower(s) {
+	case "guest":
+		return AccountTypeGuest, nil
+	case "user":
+		return AccountTypeUser, nil
+	case "moderator":
+		return AccountTypeModerator, nil
+	case "administrator", "admin":
+		return AccountTypeAdministrator, nil
+	default:
+		return 0, fmt.Errorf("unknown account type '%s', must be 'guest', 'user', 'moderator' or 'administrator'", s)
+	}
+}
+
+// A account, each user has their own stars & last viewed times.
+type User struct {
+	id          int
+	name        string
+	accountType AccountType
+	created     time.Time
+}
+
+func (u *User) GetId() int {
+	return u.id
+}
+
+func (u *User) GetName() string {
+	return u.name
+}
+
+// Set the users name, this is not updated until FileDb.UpdateUser is called
+func (u *User) SetName(name string) error {
+	if name == "" {
+		return errors.New("name cannot be empty")
+	}
+	u.name = name
+	return nil
+}
+
+func (u *User) GetAccountType() AccountType {
+	return u.accountType
+}
+
+// Set the account type, this is not updated until FileDb.UpdateUser is called
+func (u *User) SetAccountType(a AccountType) error {
+	if !a.IsValid() {
+		return fmt.Errorf("invalid account type '%d'", int(a))
+	}
+	u.accountType = a
+	return nil
+}
+
+// Time the user was created
+func (u *User) GetCreated() time.Time {
+	return u.created
+}
+
+func isValidUser(u *User) error {
+	if u == nil {
+		return errors.New("user is nil")
+	}
+	if u.id == 0 {
+		// Invalid ID, SQL ids start at 1.
+		return errors.New("invalid user id")
+	}
+	return nil
+}
+
+// Don't call .Next before calling this function or you will lose a user.
+func (d *FileDb) sqlRowsToUsers(r *sql.Rows) []*User {
+	users := make([]*User, 0)
+	for r.Next() {
+		u := &User{}
+		created := int64(0)
+		err := r.Scan(&u.id, &u.name, &u.accountType, &created)
+		if err != nil {
+			// This can only happen if the database structure has changed.
+			slog.Error("Failed to scan from User rows", "Error", err.Error())
+			panic(fmt.Sprintf("MediaManager: sqlRowsToUsers: Scanning from user rows failed: %v", err))
+		}
+		u.created = time.Unix(created, 0)
+		users = append(users, u)
+	}
+	return users
+}
+
+// Add a new user
+func (d *FileDb) AddUser(name string, accountType AccountType) (*User, error) {
+	if d.safeMode {
+		return nil, ErrOutdatedDatabase
+	}
+	if name == "" {
+		return nil, errors.New("name cannot be empty")
+	}
+	if !accountType.IsValid() {
+		return nil, fmt.Errorf("invalid account type '%d'", int(accountType))
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	u := &User{
+		name:        name,
+		accountType: accountType,
+		created:     time.Unix(time.Now().Unix(), 0),
+	}
+	slog.Info("Executing INSERT", "Query", "INSERT INTO user(name, accountType, created) VALUES (?, ?, ?)", "QueryArgs", []any{u.name, u.accountType, u.created.Unix()})
+	res, err := d.db.Exec("INSERT INTO user(name, accountType, created) VALUES (?, ?, ?)", u.name, u.accountType, u.created.Unix())
+	if err != nil {
+		// This would fail if the name isn't unique
+		slog.Info("Failed to insert into user", "Query", "INSERT INTO user(name, accountType, created) VALUES (?, ?, ?)", "QueryArgs", []any{u.name, u.accountType, u.created.Unix()}, "Error", err.Error())
+		return nil, fmt.Errorf("failed to insert into user table: %v", err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		slog.Error("Failed to get lastInsertId", "Error", err.Error(), "Query", "INSERT INTO user(name, accountType, created) VALUES (?, ?, ?)")
+		panic(fmt.Sprintf("MediaManager: AddUser: .LastInsertId failed to get id, is the database correct?: %v", err))
+	}
+	u.id = int(id)
+	return u, nil
+}
+
+// Get a user by ID
+func (d *FileDb) GetUserById(id int) (*User, error) {
+	if d.safeMode {
+		return nil, ErrOutdatedDatabase
+	}
+	slog.Debug("Executing SELECT", "Query", "SELECT id, name, accountType, created FROM user WHERE id=?", "QueryArgs", []any{id})
+	rows, err := d.db.Query("SELECT id, name, accountType, created FROM user WHERE id=?", id)
+	if err != nil {
+		slog.Error("Failed to execute select query", "Query", "SELECT id, name, accountType, created FROM user WHERE id=?", "QueryArgs", []any{id}, "Error", err.Error())
+		panic(fmt.Sprintf("MediaManager: GetUserById query failed: %v", err))
+	}
+	defer rows.Close()
+	users := d.sqlRowsToUsers(rows)
+	if len(users) == 0 {
+		return nil, errors.New("user not found")
+	}
+	return users[0], nil
+}
+
+// Get a user by name
+func (d *FileDb) GetUserByName(name string) (*User, error) {
+	if d.safeMode {
+		return nil, ErrOutdatedDatabase
+	}
+	slog.Debug("Executing SELECT", "Query", "SELECT id, name, accountType, created FROM user WHERE name=?", "QueryArgs", []any{name})
+	rows, err := d.db.Query("SELECT id, name, accountType, created FROM user WHERE name=?", name)
+	if err != nil {
+		slog.Error("Failed to execute select query", "Query", "SELECT id, name, accountType, created FROM user WHERE name=?", "QueryArgs", []any{name}, "Error", err.Error())
+		panic(fmt.Sprintf("MediaManager: GetUserByName query failed: %v", err))
+	}
+	defer rows.Close()
+	users := d.sqlRowsToUsers(rows)
+	if len(users) == 0 {
+		return nil, errors.New("user not found")
+	}
+	return users[0], nil
+}
+
+// Get every user, ordered by ID
+func (d *FileDb) GetAllUsers() ([]*User, error) {
+	if d.safeMode {
+		return nil, ErrOutdatedDatabase
+	}
+	slog.Debug("Executing SELECT", "Query", "SELECT id, name, accountType, created FROM user ORDER BY id")
+	rows, err := d.db.Query("SELECT id, name, accountType, created FROM user ORDER BY id")
+	if err != nil {
+		slog.Error("Failed to execute select query", "Query", "SELECT id, name, accountType, created FROM user ORDER BY id", "Error", err.Error())
+		panic(fmt.Sprintf("MediaManager: GetAllUsers query failed: %v", err))
+	}
+	defer rows.Close()
+	return d.sqlRowsToUsers(rows), nil
+}
+
+// Updates a users name and account type
+func (d *FileDb) UpdateUser(u *User) error {
+	if d.safeMode {
+		return ErrOutdatedDatabase
+	}
+	if err := isValidUser(u); err != nil {
+		return err
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	slog.Info("Executing UPDATE", "Query", "UPDATE user SET name=?, accountType=? WHERE id=?", "QueryArgs", []any{u.name, u.accountType, u.id})
+	res, err := d.db.Exec("UPDATE user SET name=?, accountType=? WHERE id=?", u.name, u.accountType, u.id)
+	if err != nil {
+		// This could fail if the name is no longer unique
+		slog.Warn("Failed to update user", "Query", "UPDATE user SET name=?, accountType=? WHERE id=?", "QueryArgs", []any{u.name, u.accountType, u.id}, "Error", err.Error())
+		return fmt.Errorf("failed to update user: %v", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return errors.New("user not found")
+	}
+	return nil
+}
+
+// Remove a user, all of their file data (stars & last viewed times), their API keys, logins and saved searches
+func (d *FileDb) RemoveUser(u *User) error {
+	if d.safeMode {
+		return ErrOutdatedDatabase
+	}
+	if err := isValidUser(u); err != nil {
+		return err
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	tx, err := d.db.Begin()
+	if err != nil {
+		slog.Error("Failed to create new transaction for RemoveUser", "Error", err.Error())
+		return err
+	}
+	slog.Info("Executing DELETE", "Query", "DELETE FROM user_file WHERE userId=?", "QueryArgs", []any{u.id})
+	_, err = tx.Exec("DELETE FROM user_file WHERE userId=?", u.id)
+	if err != nil {
+		slog.Warn("Failed to delete user file data", "Query", "DELETE FROM user_file WHERE userId=?", "QueryArgs", []any{u.id}, "Error", err.Error())
+		tx.Rollback()
+		return fmt.Errorf("failed to remove from user_file table: %v", err)
+	}
+	slog.Info("Executing DELETE", "Query", "DELETE FROM api_key WHERE userId=?", "QueryArgs", []any{u.id})
+	_, err = tx.Exec("DELETE FROM api_key WHERE userId=?", u.id)
+	if err != nil {
+		slog.Warn("Failed to delete user api keys", "Query", "DELETE FROM api_key WHERE userId=?", "QueryArgs", []any{u.id}, "Error", err.Error())
+		tx.Rollback()
+		return fmt.Errorf("failed to remove from api_key table: %v", err)
+	}
+	slog.Info("Executing DELETE", "Query", "DELETE FROM session WHERE userId=?", "QueryArgs", []any{u.id})
+	_, err = tx.Exec("DELETE FROM session WHERE userId=?", u.id)
+	if err != nil {
+		slog.Warn("Failed to delete user sessions", "Query", "DELETE FROM session WHERE userId=?", "QueryArgs", []any{u.id}, "Error", err.Error())
+		tx.Rollback()
+		return fmt.Errorf("failed to remove from session table: %v", err)
+	}
+	slog.Info("Executing DELETE", "Query", "DELETE FROM saved_search WHERE userId=?", "QueryArgs", []any{u.id})
+	_, err = tx.Exec("DELETE FROM saved_search WHERE userId=?", u.id)
+	if err != nil {
+		slog.Warn("Failed to delete user saved searches", "Query", "DELETE FROM saved_search WHERE userId=?", "QueryArgs", []any{u.id}, "Error", err.Error())
+		tx.Rollback()
+		return fmt.Errorf("failed to remove from saved_search table: %v", err)
+	}
+	slog.Info("Executing DELETE", "Query", "DELETE FROM user WHERE id=?", "QueryArgs", []any{u.id})
+	res, err := tx.Exec("DELETE FROM user WHERE id=?", u.id)
+	if err != nil {
+		slog.Warn("Failed to delete user", "Query", "DELETE FROM user WHERE id=?", "QueryArgs", []any{u.id}, "Error", err.Error())
+		tx.Rollback()
+		return fmt.Errorf("failed to remove from user table: %v", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		tx.Rollback()
+		return errors.New("user not found")
+	}
+	err = tx.Commit()
+	if err != nil {
+		slog.Warn("Failed to commit RemoveUser", "Error", err.Error(), "User.Id", u.id)
+		return fmt.Errorf("transaction failed to commit: %v", err)
+	}
+	// Invalidate the user
+	u.id = 0
+	return nil
+}
+
+// Write a files stars & last viewed time for the user it belongs to, nothing happens if the file has no user.
+func (d *FileDb) putUserFile(tx *sql.Tx, f *File) error {
+	if f.user == 0 {
+		return nil
+	}
+	lastViewed := f.lastViewed.UTC().Unix()
+	const query = "INSERT INTO user_file(userId, fileId, stars, lastViewed) VALUES (?, ?, ?, ?) ON CONFLICT(userId, fileId) DO UPDATE SET stars=excluded.stars, lastViewed=excluded.lastViewed"
+	slog.Info("Executing INSERT", "Query", query, "QueryArgs", []any{f.user, f.id, f.stars, lastViewed})
+	_, err := tx.Exec(query, f.user, f.id, f.stars, lastViewed)
+	if err != nil {
+		// This would fail if the user doesn't exist.
+		slog.Warn("Failed to insert into user_file", "Query", query, "QueryArgs", []any{f.user, f.id, f.stars, lastViewed}, "Error", err.Error())
+		return fmt.Errorf("failed to insert into user_file table: %v", err)
+	}
+	return nil
+}
+
+// Load a users stars & last viewed times into files, the files will then belong to the user and
+// their values will be written to the user on FileDb.UpdateFile
+func (d *FileDb) LoadUserData(u *User, files ...*File) error {
+	if d.safeMode {
+		return ErrOutdatedDatabase
+	}
+	if err := isValidUser(u); err != nil {
+		return err
+	}
+	for _, f := range files {
+		if err := isValidFile(f); err != nil {
+			return err
+		}
+		f.user = u.id
+		f.stars = 0
+		f.lastViewed = time.Unix(0, 0)
+		slog.Debug("Executing SELECT", "Query", "SELECT stars, lastViewed FROM user_file WHERE userId=? AND fileId=?", "QueryArgs", []any{u.id, f.id})
+		row := d.db.QueryRow("SELECT stars, lastViewed FROM user_file WHERE userId=? AND fileId=?", u.id, f.id)
+		lastViewed := int64(0)
+		err := row.Scan(&f.stars, &lastViewed)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				// User hasn't set anything for this file
+				continue
+			}
+			slog.Error("Failed to scan user_file", "Error", err.Error(), "User.Id", u.id, "File.Id", f.id)
+			panic(fmt.Sprintf("MediaManager: LoadUserData: Failed to scan user_file, did the structure change?: %v", err))
+		}
+		f.lastViewed = time.Unix(lastViewed, 0)
+	}
+	return nil
+}
+
+// A users stars & last viewed time for a file
+type UserFileData struct {
+	UserId     int
+	UserName   string
+	Stars      uint8
+	LastViewed time.Time
+}
+
+// Get the stars & last viewed time every user has set for a file, ordered by user ID. Users that haven't set anything are not included.
+func (d *FileDb) GetFileUserData(f *File) ([]*UserFileData, error) {
+	if d.safeMode {
+		return nil, ErrOutdatedDatabase
+	}
+	if err := isValidFile(f); err != nil {
+		return nil, err
+	}
+	const query = "SELECT u.id, u.name, uf.stars, uf.lastViewed FROM user_file uf JOIN user u ON u.id = uf.userId WHERE uf.fileId=? ORDER BY u.id"
+	slog.Debug("Executing SELECT", "Query", query, "QueryArgs", []any{f.id})
+	rows, err := d.db.Query(query, f.id)
+	if err != nil {
+		slog.Error("Failed to execute select query", "Query", query, "QueryArgs", []any{f.id}, "Error", err.Error())
+		panic(fmt.Sprintf("MediaManager: GetFileUserData query failed: %v", err))
+	}
+	defer rows.Close()
+	data := make([]*UserFileData, 0)
+	for rows.Next() {
+		v := &UserFileData{}
+		lastViewed := int64(0)
+		err = rows.Scan(&v.UserId, &v.UserName, &v.Stars, &lastViewed)
+		if err != nil {
+			slog.Error("Failed to scan user_file", "Error", err.Error(), "File.Id", f.id)
+			panic(fmt.Sprintf("MediaManager: GetFileUserData: Failed to scan user_file, did the structure change?: %v", err))
+		}
+		v.LastViewed = time.Unix(lastViewed, 0)
+		data = append(data, v)
+	}
+	return data, nil
+}
+
+// Stars & last viewed times of a file aggregated across every user
+type FileGlobalStats struct {
+	LastViewed   time.Time // Latest last viewed time of any user, Unix 0 if nobody viewed the file
+	AverageStars float64   // Average stars of users with data for the file
+	Viewed       int       // Number of users who viewed the file
+	Starred      int       // Number of users who starred the file
+}
+
+// Get the stars & last viewed times of f aggregated across every user.
+//
+// If ignoreUnset is true users who haven't starred the file aren't counted in the average stars.
+func (d *FileDb) GetFileGlobalStats(f *File, ignoreUnset bool) (*FileGlobalStats, error) {
+	if d.safeMode {
+		return nil, ErrOutdatedDatabase
+	}
+	if err := isValidFile(f); err != nil {
+		return nil, err
+	}
+	avg := "AVG(stars)"
+	if ignoreUnset {
+		avg = "AVG(NULLIF(stars, 0))"
+	}
+	query := "SELECT COALESCE(MAX(lastViewed), 0), COALESCE(" + avg + ", 0), COUNT(NULLIF(lastViewed, 0)), COUNT(NULLIF(stars, 0)) FROM user_file WHERE fileId=?"
+	slog.Debug("Executing SELECT", "Query", query, "QueryArgs", []any{f.id})
+	s := &FileGlobalStats{}
+	lastViewed := int64(0)
+	err := d.db.QueryRow(query, f.id).Scan(&lastViewed, &s.AverageStars, &s.Viewed, &s.Starred)
+	if err != nil {
+		slog.Error("Failed to execute select query", "Query", query, "QueryArgs", []any{f.id}, "Error", err.Error())
+		panic(fmt.Sprintf("MediaManager: GetFileGlobalStats query failed: %v", err))
+	}
+	s.LastViewed = time.Unix(lastViewed, 0)
+	return s, nil
+}
+
+// Longest password allowed, bcrypt can't use anything longer
+const MaxPasswordLength = 72
+
+var (
+	dummyHashOnce sync.Once
+	dummyHash     []byte
+)
+
+// Hash compared against when a user can't log in, so it takes the same time as a real login
+func getDummyHash() []byte {
+	dummyHashOnce.Do(func() {
+		var err error
+		dummyHash, err = bcrypt.GenerateFromPassword([]byte("MediaManager"), bcrypt.DefaultCost)
+		if err != nil {
+			panic(fmt.Sprintf("MediaManager: getDummyHash: Failed to hash password: %v", err))
+		}
+	})
+	return dummyHash
+}
+
+// Set the password a user logs in with, only a bcrypt hash of it is stored.
+func (d *FileDb) SetPassword(u *User, password string) error {
+	if d.safeMode {
+		return ErrOutdatedDatabase
+	}
+	if err := isValidUser(u); err != nil {
+		return err
+	}
+	if password == "" {
+		return errors.New("password cannot be empty")
+	}
+	if len(password) > MaxPasswordLength {
+		return fmt.Errorf("password cannot be longer than %d bytes", MaxPasswordLength)
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return fmt.Errorf("failed to hash password: %v", err)
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	// Don't log the hash
+	slog.Info("Executing UPDATE", "Query", "UPDATE user SET password=? WHERE id=?", "QueryArgs", []any{"<hash>", u.id})
+	res, err := d.db.Exec("UPDATE user SET password=? WHERE id=?", string(hash), u.id)
+	if err != nil {
+		slog.Warn("Failed to update user password", "Query", "UPDATE user SET password=? WHERE id=?", "QueryArgs", []any{"<hash>", u.id}, "Error", err.Error())
+		return fmt.Errorf("failed to update user: %v", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return errors.New("user not found")
+	}
+	return nil
+}
+
+// Checks if a user has a password, users without one can't log in
+func (d *FileDb) HasPassword(u *User) (bool, error) {
+	if d.safeMode {
+		return false, ErrOutdatedDatabase
+	}
+	if err := isValidUser(u); err != nil {
+		return false, err
+	}
+	slog.Debug("Executing SELECT", "Query", "SELECT password IS NOT NULL FROM user WHERE id=?", "QueryArgs", []any{u.id})
+	hasPassword := false
+	err := d.db.QueryRow("SELECT password IS NOT NULL FROM user WHERE id=?", u.id).Scan(&hasPassword)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, errors.New("user not found")
+	} else if err != nil {
+		slog.Error("Failed to execute select query", "Query", "SELECT password IS NOT NULL FROM user WHERE id=?", "QueryArgs", []any{u.id}, "Error", err.Error())
+		panic(fmt.Sprintf("MediaManager: HasPassword query failed: %v", err))
+	}
+	return hasPassword, nil
+}
+
+// Get the user named name if password is correct.
+//
+// This takes the same time whether or not the user exists, so it can't be used to find users.
+func (d *FileDb) CheckPassword(name string, password string) (*User, error) {
+	if d.safeMode {
+		return nil, ErrOutdatedDatabase
+	}
+	slog.Debug("Executing SELECT", "Query", "SELECT password FROM user WHERE name=?", "QueryArgs", []any{name})
+	hash := sql.NullString{}
+	err := d.db.QueryRow("SELECT password FROM user WHERE name=?", name).Scan(&hash)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		slog.Error("Failed to execute select query", "Query", "SELECT password FROM user WHERE name=?", "QueryArgs", []any{name}, "Error", err.Error())
+		panic(fmt.Sprintf("MediaManager: CheckPassword query failed: %v", err))
+	}
+	if !hash.Valid {
+		bcrypt.CompareHashAndPassword(getDummyHash(), []byte(password))
+		return nil, errors.New("invalid account")
+	}
+	if bcrypt.CompareHashAndPassword([]byte(hash.String), []byte(password)) != nil {
+		return nil, errors.New("invalid account")
+	}
+	return d.GetUserByName(name)
+}
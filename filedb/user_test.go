@@ -0,0 +1,305 @@
+package filedb
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAddUser(t *testing.T) {
+	db := getTestDb(t)
+	defer db.Close()
+	u, err := db.AddUser("alex", AccountTypeAdministrator)
+	if !assert.NoErrorf(t, err, "AddUser failed") {
+		return
+	}
+	assert.Equalf(t, 1, u.GetId(), "User ID should have been 1")
+	assert.Equalf(t, "alex", u.GetName(), "Wrong name")
+	assert.Equalf(t, AccountTypeAdministrator, u.GetAccountType(), "Wrong account type")
+	_, err = db.AddUser("alex", AccountTypeUser)
+	assert.Errorf(t, err, "Added duplicate user")
+	_, err = db.AddUser("", AccountTypeUser)
+	assert.Errorf(t, err, "Added user with empty name")
+	_, err = db.AddUser("invalid", AccountType(10))
+	assert.Errorf(t, err, "Added user with invalid account type")
+	byName, err := db.GetUserByName("alex")
+	if assert.NoErrorf(t, err, "GetUserByName failed") {
+		assert.Equalf(t, u, byName, "GetUserByName returned wrong user")
+	}
+	byId, err := db.GetUserById(u.GetId())
+	if assert.NoErrorf(t, err, "GetUserById failed") {
+		assert.Equalf(t, u, byId, "GetUserById returned wrong user")
+	}
+	_, err = db.GetUserByName("missing")
+	assert.Errorf(t, err, "GetUserByName found a missing user")
+}
+
+func TestGetAllUsers(t *testing.T) {
+	db := getTestDb(t)
+	defer db.Close()
+	u1, err := db.AddUser("u1", AccountTypeUser)
+	if !assert.NoErrorf(t, err, "AddUser(u1) failed") {
+		return
+	}
+	u2, err := db.AddUser("u2", AccountTypeGuest)
+	if !assert.NoErrorf(t, err, "AddUser(u2) failed") {
+		return
+	}
+	users, err := db.GetAllUsers()
+	if assert.NoErrorf(t, err, "GetAllUsers failed") {
+		assert.Equalf(t, []*User{u1, u2}, users, "GetAllUsers returned wrong users")
+	}
+}
+
+func TestUpdateUser(t *testing.T) {
+	db := getTestDb(t)
+	defer db.Close()
+	u, err := db.AddUser("u1", AccountTypeUser)
+	if !assert.NoErrorf(t, err, "AddUser(u1) failed") {
+		return
+	}
+	_, err = db.AddUser("u2", AccountTypeUser)
+	if !assert.NoErrorf(t, err, "AddUser(u2) failed") {
+		return
+	}
+	assert.NoErrorf(t, u.SetName("renamed"), "SetName failed")
+	assert.NoErrorf(t, u.SetAccountType(AccountTypeModerator), "SetAccountType failed")
+	assert.Errorf(t, u.SetAccountType(AccountType(-1)), "SetAccountType accepted invalid value")
+	assert.NoErrorf(t, db.UpdateUser(u), "UpdateUser failed")
+	fromDb, err := db.GetUserById(u.GetId())
+	if assert.NoErrorf(t, err, "GetUserById failed") {
+		assert.Equalf(t, "renamed", fromDb.GetName(), "Name wasn't updated")
+		assert.Equalf(t, AccountTypeModerator, fromDb.GetAccountType(), "Account type wasn't updated")
+	}
+	// Names must be unique
+	assert.NoErrorf(t, u.SetName("u2"), "SetName failed")
+	assert.Errorf(t, db.UpdateUser(u), "UpdateUser allowed duplicate name")
+}
+
+func TestRemoveUser(t *testing.T) {
+	db := getTestDb(t)
+	defer db.Close()
+	u := getTestUser(t, db)
+	f := makeTestFile(t, "t1")
+	f.SetUser(u)
+	assert.NoErrorf(t, f.SetStars(4), "SetStars(4) failed")
+	if !assert.NoErrorf(t, db.AddFile(f), "AddFile failed") {
+		return
+	}
+	assert.NoErrorf(t, db.RemoveUser(u), "RemoveUser failed")
+	assert.Equalf(t, 0, u.GetId(), "RemoveUser didn't invalidate the user")
+	assert.Errorf(t, db.RemoveUser(u), "RemoveUser worked on a removed user")
+	users, err := db.GetAllUsers()
+	if assert.NoErrorf(t, err, "GetAllUsers failed") {
+		assert.Emptyf(t, users, "User wasn't removed")
+	}
+	// The users file data should be gone, but the file should not be.
+	fl, err := db.GetFileById(f.GetId())
+	if !assert.NoErrorf(t, err, "GetFileById failed") {
+		return
+	}
+	u2 := getTestUser(t, db)
+	assert.NoErrorf(t, db.LoadUserData(u2, fl), "LoadUserData failed")
+	assert.Equalf(t, uint8(0), fl.GetStars(), "Stars from removed user remained")
+}
+
+func TestPerUserFileData(t *testing.T) {
+	db := getTestDb(t)
+	defer db.Close()
+	u1, err := db.AddUser("u1", AccountTypeUser)
+	if !assert.NoErrorf(t, err, "AddUser(u1) failed") {
+		return
+	}
+	u2, err := db.AddUser("u2", AccountTypeUser)
+	if !assert.NoErrorf(t, err, "AddUser(u2) failed") {
+		return
+	}
+	f1 := makeTestFile(t, "t1")
+	f2 := makeTestFile(t, "t2")
+	if _, err := db.AddFiles(f1, f2); !assert.NoErrorf(t, err, "AddFiles failed") {
+		return
+	}
+	// User 1 likes file 1, user 2 likes file 2
+	assert.NoErrorf(t, db.LoadUserData(u1, f1, f2), "LoadUserData(u1) failed")
+	assert.Equalf(t, u1.GetId(), f1.GetUserId(), "File wasn't given to user")
+	assert.NoErrorf(t, f1.SetStars(5), "SetStars(5) failed")
+	assert.NoErrorf(t, f2.SetStars(1), "SetStars(1) failed")
+	f1.MarkFileRead()
+	assert.NoErrorf(t, db.UpdateFile(f1), "UpdateFile(f1) failed")
+	assert.NoErrorf(t, db.UpdateFile(f2), "UpdateFile(f2) failed")
+	assert.NoErrorf(t, db.LoadUserData(u2, f1, f2), "LoadUserData(u2) failed")
+	assert.Equalf(t, uint8(0), f1.GetStars(), "User 2 got user 1's stars")
+	assert.Equalf(t, time.Unix(0, 0), f1.GetLastPlayTime(), "User 2 got user 1's last viewed time")
+	assert.NoErrorf(t, f1.SetStars(2), "SetStars(2) failed")
+	assert.NoErrorf(t, f2.SetStars(3), "SetStars(3) failed")
+	assert.NoErrorf(t, db.UpdateFile(f1), "UpdateFile(f1) failed")
+	assert.NoErrorf(t, db.UpdateFile(f2), "UpdateFile(f2) failed")
+	// Now check both users are seperate
+	assert.NoErrorf(t, db.LoadUserData(u1, f1, f2), "LoadUserData(u1) failed")
+	assert.Equalf(t, uint8(5), f1.GetStars(), "User 1's stars were changed")
+	assert.Equalf(t, uint8(1), f2.GetStars(), "User 1's stars were changed")
+	assert.NotEqualf(t, time.Unix(0, 0), f1.GetLastPlayTime(), "User 1's last viewed time was changed")
+	// Sorting should use the users values
+	fs, err := db.SearchFile(&SearchQuery{
+		UserId:      u1.GetId(),
+		SortBy:      SortMethodStars,
+		SortReverse: true,
+	})
+	if assert.NoErrorf(t, err, "SearchFile failed") && assert.Len(t, fs, 2) {
+		assert.Equalf(t, []int{f1.GetId(), f2.GetId()}, []int{fs[0].GetId(), fs[1].GetId()}, "User 1 sorting was wrong")
+		assert.Equalf(t, u1.GetId(), fs[0].GetUserId(), "Search results didn't belong to the user")
+	}
+	fs, err = db.SearchFile(&SearchQuery{
+		UserId:      u2.GetId(),
+		SortBy:      SortMethodStars,
+		SortReverse: true,
+	})
+	if assert.NoErrorf(t, err, "SearchFile failed") && assert.Len(t, fs, 2) {
+		assert.Equalf(t, []int{f2.GetId(), f1.GetId()}, []int{fs[0].GetId(), fs[1].GetId()}, "User 2 sorting was wrong")
+	}
+	// Files without a user have no stars
+	fl, err := db.GetFileById(f1.GetId())
+	if assert.NoErrorf(t, err, "GetFileById failed") {
+		assert.Equalf(t, uint8(0), fl.GetStars(), "File without a user had stars")
+		assert.Equalf(t, 0, fl.GetUserId(), "File without a user had a user")
+	}
+}
+
+func TestParseAccountType(t *testing.T) {
+	for _, v := range []AccountType{AccountTypeGuest, AccountTypeUser, AccountTypeModerator, AccountTypeAdministrator} {
+		a, err := ParseAccountType(v.String())
+		if assert.NoErrorf(t, err, "ParseAccountType(%s) failed", v) {
+			assert.Equalf(t, v, a, "ParseAccountType(%s) returned the wrong value", v)
+		}
+	}
+	_, err := ParseAccountType("root")
+	assert.Errorf(t, err, "ParseAccountType accepted a invalid value")
+}
+
+func TestGetFileUserData(t *testing.T) {
+	db := getTestDb(t)
+	defer db.Close()
+	u1, err := db.AddUser("u1", AccountTypeUser)
+	if !assert.NoErrorf(t, err, "AddUser(u1) failed") {
+		return
+	}
+	u2, err := db.AddUser("u2", AccountTypeUser)
+	if !assert.NoErrorf(t, err, "AddUser(u2) failed") {
+		return
+	}
+	_, err = db.AddUser("u3", AccountTypeUser)
+	if !assert.NoErrorf(t, err, "AddUser(u3) failed") {
+		return
+	}
+	f := makeTestFile(t, "t1")
+	if !assert.NoErrorf(t, db.AddFile(f), "AddFile failed") {
+		return
+	}
+	assert.NoErrorf(t, db.LoadUserData(u1, f), "LoadUserData(u1) failed")
+	assert.NoErrorf(t, f.SetStars(3), "SetStars failed")
+	assert.NoErrorf(t, db.UpdateFile(f), "UpdateFile failed")
+	assert.NoErrorf(t, db.LoadUserData(u2, f), "LoadUserData(u2) failed")
+	f.MarkFileRead()
+	assert.NoErrorf(t, db.UpdateFile(f), "UpdateFile failed")
+	data, err := db.GetFileUserData(f)
+	if assert.NoErrorf(t, err, "GetFileUserData failed") && assert.Len(t, data, 2, "Users without data should be excluded") {
+		assert.Equalf(t, &UserFileData{UserId: u1.GetId(), UserName: "u1", Stars: 3, LastViewed: time.Unix(0, 0)}, data[0], "Wrong data for u1")
+		assert.Equalf(t, &UserFileData{UserId: u2.GetId(), UserName: "u2", Stars: 0, LastViewed: f.GetLastPlayTime()}, data[1], "Wrong data for u2")
+	}
+}
+
+func TestGetFileGlobalStats(t *testing.T) {
+	db := getTestDb(t)
+	defer db.Close()
+	users := make([]*User, 0)
+	for _, n := range []string{"u1", "u2", "u3"} {
+		u, err := db.AddUser(n, AccountTypeUser)
+		if !assert.NoErrorf(t, err, "AddUser(%s) failed", n) {
+			return
+		}
+		users = append(users, u)
+	}
+	f1 := makeTestFile(t, "t1")
+	f2 := makeTestFile(t, "t2")
+	if !assert.NoErrorf(t, db.AddFile(f1), "AddFile failed") || !assert.NoErrorf(t, db.AddFile(f2), "AddFile failed") {
+		return
+	}
+	// f1: u1 4 stars, u2 viewed, u3 2 stars & viewed. f2: u1 5 stars
+	set := func(u *User, f *File, stars uint8, viewed bool) {
+		assert.NoErrorf(t, db.LoadUserData(u, f), "LoadUserData failed")
+		assert.NoErrorf(t, f.SetStars(stars), "SetStars failed")
+		if viewed {
+			f.MarkFileRead()
+		}
+		assert.NoErrorf(t, db.UpdateFile(f), "UpdateFile failed")
+	}
+	set(users[0], f1, 4, false)
+	set(users[1], f1, 0, true)
+	set(users[2], f1, 2, true)
+	set(users[0], f2, 5, false)
+	s, err := db.GetFileGlobalStats(f1, false)
+	if assert.NoErrorf(t, err, "GetFileGlobalStats failed") {
+		assert.Equalf(t, 2.0, s.AverageStars, "Wrong average stars")
+		assert.Equalf(t, 2, s.Viewed, "Wrong viewed count")
+		assert.Equalf(t, 2, s.Starred, "Wrong starred count")
+		assert.Equalf(t, f1.GetLastPlayTime(), s.LastViewed, "Wrong last viewed time")
+	}
+	s, err = db.GetFileGlobalStats(f1, true)
+	if assert.NoErrorf(t, err, "GetFileGlobalStats failed") {
+		assert.Equalf(t, 3.0, s.AverageStars, "Unset stars weren't ignored")
+	}
+	s, err = db.GetFileGlobalStats(f2, false)
+	if assert.NoErrorf(t, err, "GetFileGlobalStats failed") {
+		assert.Equalf(t, &FileGlobalStats{LastViewed: time.Unix(0, 0), AverageStars: 5, Viewed: 0, Starred: 1}, s, "Wrong stats")
+	}
+	// Sorting by the aggregated values
+	for _, v := range []struct {
+		Name        string
+		Sort        SortMethod
+		IgnoreUnset bool
+		First       *File
+	}{
+		{"AverageStars", SortMethodAverageStars, false, f2},
+		{"AverageStarsIgnoreUnset", SortMethodAverageStars, true, f2},
+		{"Popularity", SortMethodPopularity, false, f1},
+		{"GlobalLastViewed", SortMethodGlobalLastViewed, false, f1},
+	} {
+		t.Run(v.Name, func(t *testing.T) {
+			fs, err := db.SearchFile(&SearchQuery{SortBy: v.Sort, SortReverse: true, IgnoreUnsetStars: v.IgnoreUnset})
+			if assert.NoErrorf(t, err, "SearchFile failed") && assert.Len(t, fs, 2) {
+				assert.Equalf(t, v.First.GetId(), fs[0].GetId(), "Wrong sort order")
+			}
+		})
+	}
+}
+
+func TestPassword(t *testing.T) {
+	db := getTestDb(t)
+	defer db.Close()
+	u := getTestUser(t, db)
+	has, err := db.HasPassword(u)
+	if assert.NoErrorf(t, err, "HasPassword failed") {
+		assert.Falsef(t, has, "New user has a password")
+	}
+	_, err = db.CheckPassword(u.GetName(), "")
+	assert.Errorf(t, err, "User without a password logged in")
+	assert.Errorf(t, db.SetPassword(u, ""), "Set a empty password")
+	assert.Errorf(t, db.SetPassword(u, strings.Repeat("a", MaxPasswordLength+1)), "Set a password that is too long")
+	if !assert.NoErrorf(t, db.SetPassword(u, "hunter2"), "SetPassword failed") {
+		return
+	}
+	has, err = db.HasPassword(u)
+	if assert.NoErrorf(t, err, "HasPassword failed") {
+		assert.Truef(t, has, "Password wasn't set")
+	}
+	fromDb, err := db.CheckPassword(u.GetName(), "hunter2")
+	if assert.NoErrorf(t, err, "CheckPassword failed") {
+		assert.Equalf(t, u, fromDb, "CheckPassword returned the wrong user")
+	}
+	_, err = db.CheckPassword(u.GetName(), "hunter3")
+	assert.Errorf(t, err, "Logged in with the wrong password")
+	_, err = db.CheckPassword("nobody", "hunter2")
+	assert.Errorf(t, err, "Logged in to a user that doesn't exist")
+}
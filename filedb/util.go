@@ -7,11 +7,21 @@ import (
 	"fmt"
 	"io"
 	"log/slog"
+	"mediamanager/mediatype"
+	"mediamanager/metadata"
+	"mediamanager/phash"
 	"os"
+	"path"
+	"strings"
 	"sync"
 	"sync/atomic"
 )
 
+// The default display name of a file, the last element of its path
+func defaultFileName(p string) string {
+	return path.Base(strings.ReplaceAll(p, "\\", "/"))
+}
+
 func isValidFile(f *File) error {
 	if f.id == 0 {
 		// Invalid ID, SQL ids start at 1.
@@ -146,7 +156,9 @@ func AddFileInfo(goroutines int, f ...*File) error {
 	return nil
 }
 
+// The hash is removed if it can't be added, FileDb.UpdateFile then keeps the hash in the database
 func addInfoHash(f *File) error {
+	f.hash = ""
 	file, err := os.Open(f.GetPath())
 	if err != nil {
 		return fmt.Errorf("failed to open file: %v", err)
@@ -167,13 +179,50 @@ func addInfoSize(f *File) error {
 		return fmt.Errorf("failed to stat file: %v", err)
 	}
 	f.SetSize(st.Size())
-	slog.Debug("Goroutine adding size to file", "Id", f.GetId(), "Path", f.GetPath(), "Size", st.Size())
+	f.modTime = st.ModTime()
+	slog.Debug("Goroutine adding size to file", "Id", f.GetId(), "Path", f.GetPath(), "Size", st.Size(), "ModTime", st.ModTime())
+	return nil
+}
+
+func addInfoMeta(f *File) error {
+	m, err := metadata.Extract(f.GetPath())
+	if err != nil {
+		return err
+	}
+	f.meta = m
+	slog.Debug("Goroutine adding metadata to file", "Id", f.GetId(), "Path", f.GetPath(), "Metadata", *m)
 	return nil
 }
 
-func addInfoToFilesRoutine(recv <-chan *File, wg *sync.WaitGroup, prog *atomic.Int64, ctx context.Context, withHash bool, withSize bool) {
-	if !withHash && !withSize {
-		panic("FileDb: addInfoToFilesRoutine: withHash & withSize are both false.")
+func addInfoPHash(f *File) error {
+	h, err := phash.HashFile(f.GetPath())
+	if err != nil {
+		return err
+	}
+	f.phash, f.hasPHash = h, true
+	slog.Debug("Goroutine adding perceptual hash to file", "Id", f.GetId(), "Path", f.GetPath(), "PHash", h)
+	return nil
+}
+
+// Set the type of a file, left unchanged if the type isn't known
+func addInfoType(f *File, types *mediatype.Registry, sniff bool) error {
+	t, err := types.Detect(f.GetPath(), sniff)
+	if err != nil {
+		return err
+	}
+	if t == nil {
+		slog.Debug("Unknown type of file", "Id", f.GetId(), "Path", f.GetPath())
+		return nil
+	}
+	f.SetType(t.Mime, string(t.Category))
+	slog.Debug("Goroutine adding type to file", "Id", f.GetId(), "Path", f.GetPath(), "Mime", t.Mime, "Category", t.Category)
+	return nil
+}
+
+// types is only used if it isn't nil
+func addInfoToFilesRoutine(recv <-chan *File, wg *sync.WaitGroup, prog *atomic.Int64, ctx context.Context, withHash bool, withSize bool, withMeta bool, withPHash bool, types *mediatype.Registry, sniff bool) {
+	if !withHash && !withSize && !withMeta && !withPHash && types == nil {
+		panic("FileDb: addInfoToFilesRoutine: withHash, withSize, withMeta & withPHash are all false and types is nil.")
 	}
 	defer wg.Done()
 	for {
@@ -198,17 +247,46 @@ func addInfoToFilesRoutine(recv <-chan *File, wg *sync.WaitGroup, prog *atomic.I
 					slog.Warn("Failed to add size to file", "Path", f.GetPath(), "Error", err.Error())
 				}
 			}
+			if withMeta {
+				err := addInfoMeta(f)
+				if errors.Is(err, metadata.ErrUnsupported) {
+					// Most files don't have any
+					slog.Debug("No metadata extractor for file", "Path", f.GetPath())
+				} else if err != nil {
+					slog.Warn("Failed to add metadata to file", "Path", f.GetPath(), "Error", err.Error())
+				}
+			}
+			if withPHash {
+				err := addInfoPHash(f)
+				if errors.Is(err, phash.ErrUnsupported) {
+					// Only images have one
+					slog.Debug("Can't add perceptual hash to file", "Path", f.GetPath())
+				} else if err != nil {
+					slog.Warn("Failed to add perceptual hash to file", "Path", f.GetPath(), "Error", err.Error())
+				}
+			}
+			if types != nil {
+				err := addInfoType(f, types, sniff)
+				if err != nil {
+					slog.Warn("Failed to add type to file", "Path", f.GetPath(), "Error", err.Error())
+				}
+			}
 			prog.Add(1)
 		}
 	}
 }
 
 type AddInfoOpts struct {
-	DontAddHash  bool            // Don't add SHA-256 hashes to files. Default: false
-	DontAddSize  bool            // Don't add file sizes to files. Default: false
-	Goroutines   int             // Goroutines to use for file updating. Default: 100, this is very IO bound, going much higher then this doesn't change much.
-	Context      context.Context // Context to wait on. Default: context.Background
-	ProgressChan chan<- int64    // The current completed number will be sent every time the value changes, -1 will be sent when done.
+	DontAddHash  bool                // Don't add SHA-256 hashes to files. Default: false
+	DontAddSize  bool                // Don't add file sizes to files. Default: false
+	AddMeta      bool                // Read metadata embedded in files, like EXIF & ID3 tags. Files it can't be read from are left without any. Default: false
+	AddPHash     bool                // Add perceptual hashes to JPEG, PNG & GIF files, used by FileDb.FindSimilar. Other files are left without one. Default: false
+	AddType      bool                // Add MIME types & categories by extension, files of unknown types are left unchanged. Default: false
+	Types        *mediatype.Registry // Types used by AddType. Default: mediatype.Default
+	Sniff        bool                // AddType reads the start of files & uses the type of the contents if it's known, see mediatype.Sniff. Default: false
+	Goroutines   int                 // Goroutines to use for file updating. Default: 100, this is very IO bound, going much higher then this doesn't change much.
+	Context      context.Context     // Context to wait on. Default: context.Background
+	ProgressChan chan<- int64        // The current completed number will be sent every time the value changes, -1 will be sent when done.
 	// Deprecated: Use OnCompleteCallback
 	//
 	//Where to write the progress bar to, if nil no progress bar will be written. Default: nil
@@ -232,9 +310,16 @@ func AddInfoToFiles(opts *AddInfoOpts, files ...*File) error {
 	if opts.Context == nil {
 		opts.Context = context.Background()
 	}
-	if opts.DontAddHash && opts.DontAddSize {
+	if opts.DontAddHash && opts.DontAddSize && !opts.AddMeta && !opts.AddPHash && !opts.AddType {
 		// No operation?
-		return errors.New("hash or size must be added")
+		return errors.New("hash, size, metadata, perceptual hash or type must be added")
+	}
+	var types *mediatype.Registry
+	if opts.AddType {
+		types = opts.Types
+		if types == nil {
+			types = mediatype.Default()
+		}
 	}
 	// Setup context
 	ctx, cancel := context.WithCancel(opts.Context)
@@ -246,7 +331,7 @@ func AddInfoToFiles(opts *AddInfoOpts, files ...*File) error {
 	slog.Debug("Starting goroutines for adding file info", "Options", opts, "Files", len(files))
 	// Run them
 	for range opts.Goroutines {
-		go addInfoToFilesRoutine(fileCh, &wg, &pg, ctx, !opts.DontAddHash, !opts.DontAddSize)
+		go addInfoToFilesRoutine(fileCh, &wg, &pg, ctx, !opts.DontAddHash, !opts.DontAddSize, opts.AddMeta, opts.AddPHash, types, opts.Sniff)
 	}
 	// Deprecated
 	if opts.ProgressBarWriter != nil {
@@ -1,6 +1,9 @@
 package filedb
 
 import (
+	"mediamanager/mediatype"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -16,3 +19,58 @@ func TestIsValidFile(t *testing.T) {
 	assert.NoErrorf(t, isValidFile(okFile), "File shouldn't have returned a file")
 	assert.Errorf(t, isValidFile(badFile), "File should have returned a file")
 }
+
+func TestAddType(t *testing.T) {
+	db := getTestDb(t)
+	defer db.Close()
+	dir := t.TempDir()
+	// A PNG with the wrong extension, a upper case extension & a unknown one
+	png, upper, unknown := filepath.Join(dir, "a.jpg"), filepath.Join(dir, "B.MP4"), filepath.Join(dir, "c.mkv")
+	os.WriteFile(png, []byte("\x89PNG\x0D\x0A\x1A\x0A\x00\x00\x00\x0DIHDR"), 0644)
+	os.WriteFile(upper, []byte("b"), 0644)
+	os.WriteFile(unknown, []byte{0x1A, 0x45, 0xDF, 0xA3}, 0644)
+	files := []*File{NewFile(png), NewFile(upper), NewFile(unknown)}
+	if !assert.NoErrorf(t, AddInfoToFiles(&AddInfoOpts{DontAddHash: true, DontAddSize: true, AddType: true}, files...), "AddInfoToFiles failed") {
+		return
+	}
+	assert.Equalf(t, "image/jpeg", files[0].GetMime(), "Extension wasn't used")
+	assert.Equalf(t, "video", files[1].GetCategory(), "Upper case extension wasn't used")
+	assert.Emptyf(t, files[2].GetMime(), "Unknown file got a type")
+	types := mediatype.Default()
+	types.Add(mediatype.Type{Mime: "video/x-matroska", Category: mediatype.Video})
+	if !assert.NoErrorf(t, AddInfoToFiles(&AddInfoOpts{DontAddHash: true, DontAddSize: true, AddType: true, Types: types, Sniff: true}, files...), "AddInfoToFiles failed") {
+		return
+	}
+	assert.Equalf(t, "image/png", files[0].GetMime(), "Sniffed type wasn't used")
+	assert.Equalf(t, "video/x-matroska", files[2].GetMime(), "Sniffed type wasn't used")
+	if _, err := db.AddFiles(files[:2]...); !assert.NoErrorf(t, err, "AddFiles failed") {
+		return
+	}
+	files[1].SetType("video/quicktime", "video")
+	assert.NoErrorf(t, db.UpdateFile(files[1]), "UpdateFile failed")
+	for _, v := range [][]string{{png, "image/png", "image"}, {upper, "video/quicktime", "video"}} {
+		f, err := db.GetFileByPath(v[0])
+		if assert.NoErrorf(t, err, "GetFileByPath failed") {
+			assert.Equalf(t, v[1:], []string{f.GetMime(), f.GetCategory()}, "Wrong type was saved")
+		}
+	}
+	assert.NoErrorf(t, db.AddFile(NewFile("/none.txt")), "AddFile failed")
+	for query, expected := range map[string][]string{
+		"category:video":       {upper},
+		"category:Image":       {png},
+		"category:none":        {"/none.txt"},
+		"mime:video/quicktime": {upper},
+		"mime:none":            {"/none.txt"},
+	} {
+		fs, err := db.SearchFile(&SearchQuery{Query: query})
+		if assert.NoErrorf(t, err, "SearchFile(%s) failed", query) {
+			paths := make([]string, len(fs))
+			for i, v := range fs {
+				paths[i] = v.GetPath()
+			}
+			assert.Equalf(t, expected, paths, "Wrong files for '%s'", query)
+		}
+	}
+	_, err := ParseQuery("category:text")
+	assert.Errorf(t, err, "Invalid category was parsed")
+}
@@ -0,0 +1,46 @@
+// Decodes JPEG, PNG & GIF images with the standard library, refusing images that are too large to decode safely.
+//
+// Used by the thumbnail & phash packages, so they support the same files & have the same limit.
+package imagedecode
+
+import (
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Images with more pixels then this aren't decoded, so a small file can't use gigabytes of memory
+const MaxPixels = 100_000_000
+
+// Extensions of files that can be decoded
+var extensions = []string{".jpg", ".jpeg", ".png", ".gif"}
+
+// Checks if a file can be decoded, by its extension
+func IsSupported(path string) bool {
+	return slices.Contains(extensions, strings.ToLower(filepath.Ext(path)))
+}
+
+// Decode a image, its size is read first so images with more then MaxPixels pixels aren't decoded.
+func Decode(r io.ReadSeeker) (image.Image, error) {
+	config, _, err := image.DecodeConfig(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image: %v", err)
+	}
+	if config.Width <= 0 || config.Height <= 0 || config.Width*config.Height > MaxPixels {
+		return nil, fmt.Errorf("image is %dx%d, which isn't supported", config.Width, config.Height)
+	}
+	if _, err = r.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("failed to seek: %v", err)
+	}
+	img, _, err := image.Decode(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image: %v", err)
+	}
+	return img, nil
+}
@@ -0,0 +1,35 @@
+package imagedecode
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecode(t *testing.T) {
+	b := &bytes.Buffer{}
+	if err := png.Encode(b, image.NewGray(image.Rect(0, 0, 30, 20))); err != nil {
+		t.Fatalf("Failed to encode image: %v", err)
+	}
+	// Reading the size moves past the start, so Decode must seek back
+	img, err := Decode(bytes.NewReader(b.Bytes()))
+	if assert.NoError(t, err) {
+		assert.Equal(t, image.Rect(0, 0, 30, 20), img.Bounds())
+	}
+	// A GIF header claiming to be 65535x65535, the limit is checked before anything else is read
+	huge := []byte("GIF89a\xff\xff\xff\xff\x00\x00\x00")
+	_, err = Decode(bytes.NewReader(huge))
+	assert.ErrorContainsf(t, err, "65535x65535", "Image over MaxPixels was decoded")
+	_, err = Decode(bytes.NewReader([]byte("not a image")))
+	assert.Errorf(t, err, "Invalid image was decoded")
+}
+
+func TestIsSupported(t *testing.T) {
+	assert.True(t, IsSupported("a/b.JPG"))
+	assert.True(t, IsSupported("b.gif"))
+	assert.False(t, IsSupported("b.webp"))
+	assert.False(t, IsSupported("png"))
+}
@@ -3,29 +3,56 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/fs"
 	"log/slog"
 	"mediamanager/filedb"
+	"mediamanager/mediatype"
+	"mediamanager/tagrules"
+	"mediamanager/walk"
+	"mediamanager/watch"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/alexflint/go-arg"
 	"github.com/pterm/pterm"
 )
 
 type ImportArgs struct {
-	DatabasePath string   `arg:"positional,required" help:"Path to the database."`
-	ImportFiles  []string `arg:"-f,--importfiles,separate" help:"Import files"`
-	ImportDirs   []string `arg:"-d,--importdirs,separate" help:"Import directories"`
-	AddTags      []string `arg:"-t,--tag,separate" help:"Add tags to every import"`
-	AddHashes    bool     `arg:"-H,--addhash" help:"Add hashes to file"`
-	AddSizes     bool     `arg:"-S,--addsizes" help:"Add sizes to files"`
-	SetStars     int      `arg:"-s,--stars" help:"Number of stars to set on imports"`
-	SetDate      bool     `arg:"-l,--setlastviewed" help:"Set last view date to right now"`
-	Silent       bool     `arg:"--silent" help:"Don't log errors on import"`
+	DatabasePath string    `arg:"positional,required" help:"Path to the database."`
+	ImportFiles  []string  `arg:"-f,--importfiles,separate" help:"Import files"`
+	ImportDirs   []string  `arg:"-d,--importdirs,separate" help:"Import directories"`
+	AddTags      []string  `arg:"-t,--tag,separate" help:"Add tags to every import"`
+	Rules        string    `arg:"--rules" help:"Add tags to imports by path & extension with the rules in this JSON file, see README.md for format. A JSON import config can be used"`
+	Types        string    `arg:"--types" help:"JSON config of the types of files to import, added to the default types. See README.md for format"`
+	Sniff        bool      `arg:"--sniff" help:"Read the start of files to detect their type, files with a unknown extension are imported if their contents are a known type"`
+	Exclude      []string  `arg:"-x,--exclude,separate" help:"Skip files & directories in --importdirs matching this pattern, like '@eaDir', '*.part' or 'tv/*/Sample/'. Patterns in .mmignore files are used too, see README.md"`
+	Include      []string  `arg:"-i,--include,separate" help:"Only import files in --importdirs matching one of these patterns, like '*.mkv'"`
+	MinSize      walk.Size `arg:"--minsize" help:"Skip files in --importdirs smaller than this, like 500KB"`
+	MaxSize      walk.Size `arg:"--maxsize" help:"Skip files in --importdirs larger than this, like 4GB"`
+	Symlinks     string    `arg:"--symlinks" help:"Symbolic links in --importdirs to walk: 'files' walks links to files, 'follow' walks links to directories too & 'skip' skips every link" default:"files"`
+	AddHashes    bool      `arg:"-H,--addhash" help:"Add hashes to file"`
+	AddSizes     bool      `arg:"-S,--addsizes" help:"Add sizes to files"`
+	AddMeta      bool      `arg:"-M,--addmeta" help:"Add metadata embedded in files, like EXIF & ID3 tags. Searchable with title:, artist:, camera:, taken: etc."`
+	AddPHash     bool      `arg:"-P,--addphash" help:"Add perceptual hashes to JPEG, PNG & GIF files, used to find similar images"`
+	SetStars     int       `arg:"-s,--stars" help:"Number of stars to set on imports"`
+	SetDate      bool      `arg:"-l,--setlastviewed" help:"Set last view date to right now"`
+	User         string    `arg:"-u,--user" help:"User to set stars & last view dates for, required by --stars and --setlastviewed"`
+	Silent       bool      `arg:"--silent" help:"Don't log errors on import"`
+	Rescan       bool      `arg:"-r,--rescan" help:"Only import new files in --importdirs & update files that changed since they were imported, found by their size & modification time. Unchanged files aren't hashed again, changed files always are. Sizes are always added"`
+	Watch        bool      `arg:"-w,--watch" help:"Keep running after the import & import new files in --importdirs as they're added, removed files are marked missing (Search with missing:yes)"`
+	Poll         bool      `arg:"--poll" help:"Scan --importdirs every --pollinterval seconds for --watch instead of using inotify, always used on systems without inotify"`
+	PollInterval int       `arg:"--pollinterval" help:"Seconds between scans of --importdirs with --poll" default:"30"`
+	Settle       int       `arg:"--settle" help:"Seconds a new file must stop changing for before --watch imports it, so files still being written aren't imported" default:"5"`
 
 	ImportJson string `arg:"--importjson" help:"Deprecated: Import from a JSON config, see README.md for format. Cannot co exist with ImportDirs or ImportFiles. Ignore all other values."`
+
+	rules *tagrules.Engine    // Loaded from Rules
+	types *mediatype.Registry // Loaded from Types, the default types if it isn't set
 }
 
 type JsonEntry struct {
@@ -36,12 +63,14 @@ type JsonImport struct {
 	AddFileInfo         bool     // Add hash & sizes to files
 	Extensions          []string // By default extensions added are .jpg, .png, .jpeg, .gif, .mp3, .flac, .wav, .webm, .mp4, .mov, .m4v
 	NoDefaultExtensions bool
+	Types               []mediatype.Type // Types added to the default types, like --types
 	Dirs                map[string]JsonEntry
 	Files               map[string]JsonEntry
+	tagrules.Config     // Rules, TypeTags & ExtensionTags
 }
 
-func ptermProgressBar(total int, ch <-chan int64, ctx context.Context) {
-	pBar, err := pterm.DefaultProgressbar.WithCurrent(0).WithShowElapsedTime(true).WithShowCount(true).WithShowPercentage(true).WithTotal(total).Start("Adding file info")
+func ptermProgressBar(title string, total int, ch <-chan int64, ctx context.Context) {
+	pBar, err := pterm.DefaultProgressbar.WithCurrent(0).WithShowElapsedTime(true).WithShowCount(true).WithShowPercentage(true).WithTotal(total).Start(title)
 	if err != nil {
 		panic(fmt.Sprintf("MediaManager: pterm.DefaultProgressbar: Failed to start progress bar: %v", err))
 	}
@@ -60,6 +89,71 @@ func ptermProgressBar(total int, ch <-chan int64, ctx context.Context) {
 	}
 }
 
+// Add the tags rules give f that it doesn't have, returns the tags added
+func applyRules(rules *tagrules.Engine, f *filedb.File) ([]string, error) {
+	added := make([]string, 0)
+	for _, t := range rules.Tags(f.GetPath()) {
+		if f.HasTag(t) {
+			continue
+		}
+		if err := f.AddTag(t); err != nil {
+			return added, fmt.Errorf("failed to add tag '%s' from rules: %v", t, err)
+		}
+		added = append(added, t)
+	}
+	return added, nil
+}
+
+// Print the files that failed to import & how many were imported, copies of files in the database are counted separately
+func printImportResult(total int, failed []*filedb.ImportError, silent bool) {
+	duplicates := 0
+	for _, f := range failed {
+		var dup *filedb.DuplicateError
+		if errors.As(f.Error, &dup) {
+			duplicates++
+			if !silent {
+				fmt.Printf("= '%s' is a copy of '%s' (%d)\n", f.File.GetPath(), dup.Path, dup.FileId)
+			}
+		} else if !silent {
+			fmt.Printf("Failed to add file '%s': %v\n", f.File.GetPath(), f.Error)
+			fmt.Printf("  | Hash: %s\n", f.File.GetHash())
+		}
+	}
+	fmt.Printf("Imported %d files\n", total-len(failed))
+	if duplicates != 0 {
+		fmt.Printf("%d files were copies of files already imported, their paths were recorded. See 'database --duplicates'\n", duplicates)
+	}
+}
+
+// Print the paths that couldn't be read while walking directories & how many files & directories were skipped
+func printWalkResult(res *walk.Result, silent bool) {
+	if !silent {
+		for _, e := range res.Errors {
+			fmt.Printf("! Failed to read %v\n", e)
+		}
+	}
+	if res.Skipped != 0 {
+		fmt.Printf("Skipped %d files & directories by pattern, size or symbolic link\n", res.Skipped)
+	}
+	if len(res.Errors) != 0 {
+		fmt.Printf("%d paths couldn't be read\n", len(res.Errors))
+	}
+}
+
+// Walk dirs, a directory that can't be walked is added to the errors of the result
+func walkDirs(dirs []string, opts *walk.Opts, fn func(path string, info fs.FileInfo)) *walk.Result {
+	res := &walk.Result{Errors: make([]*walk.Error, 0)}
+	for _, d := range dirs {
+		r, err := walk.Walk(d, opts, fn)
+		if err != nil {
+			res.Errors = append(res.Errors, &walk.Error{Path: d, Err: err})
+			continue
+		}
+		res.Merge(r)
+	}
+	return res
+}
+
 func importJson(db *filedb.FileDb, path string) {
 	data, err := os.ReadFile(path)
 	if err != nil {
@@ -72,6 +166,28 @@ func importJson(db *filedb.FileDb, path string) {
 		fmt.Printf("Failed to unmarshal JSON data\n")
 		return
 	}
+	types := mediatype.Default()
+	if im.NoDefaultExtensions {
+		types = mediatype.NewRegistry()
+	}
+	for _, t := range im.Types {
+		if err = types.Add(t); err != nil {
+			fmt.Printf("Invalid type '%s': %v\n", t.Mime, err)
+			return
+		}
+	}
+	for _, ext := range im.Extensions {
+		if err = types.AddExtension(ext); err != nil {
+			fmt.Printf("Invalid extension: %v\n", err)
+			return
+		}
+	}
+	rules, err := tagrules.New(&im.Config)
+	if err != nil {
+		fmt.Printf("Failed to compile rules: %v\n", err)
+		return
+	}
+	rules.SetTypes(types)
 	importList := make([]*filedb.File, 0)
 	for path, meta := range im.Files {
 		f := filedb.NewFile(path)
@@ -82,22 +198,18 @@ func importJson(db *filedb.FileDb, path string) {
 				return
 			}
 		}
+		if _, err = applyRules(rules, f); err != nil {
+			fmt.Printf("! Failed to tag file '%s': %v\n", path, err)
+			return
+		}
 		importList = append(importList, f)
 	}
 	exitNow := false
+	walkRes := &walk.Result{Errors: make([]*walk.Error, 0)}
 	for path, meta := range im.Dirs {
-		filepath.WalkDir(path, func(path string, d fs.DirEntry, _ error) error {
-			if d == nil {
-				slog.Warn("DirEntry was nil?", "Path", path)
-				return nil
-			}
-			if d.IsDir() {
-				return nil
-			}
-			// Check if we want this file imported
-			if !isImportableFile(path, im.Extensions...) {
-				slog.Debug("Not importing non media", "Path", path)
-				return nil
+		walkRes.Merge(walkDirs([]string{path}, &walk.Opts{Filter: types.IsImportable}, func(path string, _ fs.FileInfo) {
+			if exitNow {
+				return
 			}
 			f := filedb.NewFile(path)
 			for _, t := range meta.Tags {
@@ -105,23 +217,32 @@ func importJson(db *filedb.FileDb, path string) {
 				if err != nil {
 					fmt.Printf("! Failed to add tag '%s' to file '%s': %v\n", t, path, err)
 					exitNow = true
-					return filepath.SkipAll
+					return
 				}
 			}
+			if _, err = applyRules(rules, f); err != nil {
+				fmt.Printf("! Failed to tag file '%s': %v\n", path, err)
+				exitNow = true
+				return
+			}
 			importList = append(importList, f)
-			return nil
-		})
+		}))
 	}
 	if exitNow {
 		return
 	}
+	for _, f := range importList {
+		if t := types.ByExtension(f.GetPath()); t != nil {
+			f.SetType(t.Mime, string(t.Category))
+		}
+	}
 	fmt.Printf("Importing %d files\n", len(importList))
 	if im.AddFileInfo {
 		fmt.Printf("Adding file info\n")
 		ctx, can := context.WithCancel(context.Background())
 		defer can()
 		channel := make(chan int64, 10)
-		go ptermProgressBar(len(importList), channel, ctx)
+		go ptermProgressBar("Adding file info", len(importList), channel, ctx)
 		err = filedb.AddInfoToFiles(&filedb.AddInfoOpts{
 			DontAddHash:  false,
 			DontAddSize:  false,
@@ -138,99 +259,450 @@ func importJson(db *filedb.FileDb, path string) {
 		fmt.Printf("Failed to add files: %v\n", err)
 		return
 	}
-	imported := len(importList)
-	for _, f := range failed {
-		fmt.Printf("Failed to add file '%s': %v\n", f.File.GetPath(), f.Error)
-		fmt.Printf("  | Hash: %s\n", f.File.GetHash())
-		imported--
-	}
-	fmt.Printf("Imported %d files\n", imported)
+	printImportResult(len(importList), failed, false)
+	printWalkResult(walkRes, false)
 }
 
-// Parse import arguments
-func ParseImport(a *ArgList, p *arg.Parser) {
-	db, err := filedb.NewFileDb(a.Import.DatabasePath)
+// Checks if a file has a type in --types, with --sniff files with a unknown extension are read
+func (a *ImportArgs) isImportable(path string) bool {
+	if a.types.IsImportable(path) {
+		return true
+	}
+	if !a.Sniff {
+		return false
+	}
+	t, err := a.types.Detect(path, true)
 	if err != nil {
-		fmt.Printf("Failed to create file database: %v\n", err)
-		return
+		slog.Warn("Failed to sniff file", "Path", path, "Error", err.Error())
+		return false
 	}
-	defer db.Close()
-	toImport := make([]*filedb.File, 0)
-	if a.Import.ImportJson != "" {
-		importJson(db, a.Import.ImportJson)
-		return
+	return t != nil
+}
+
+// Options to walk --importdirs with
+func (a *ImportArgs) walkOpts() *walk.Opts {
+	return &walk.Opts{
+		Include:  a.Include,
+		Exclude:  a.Exclude,
+		MinSize:  int64(a.MinSize),
+		MaxSize:  int64(a.MaxSize),
+		Symlinks: walk.SymlinkPolicy(a.Symlinks),
+		Filter:   a.isImportable,
 	}
-	for _, v := range a.Import.ImportFiles {
-		f := filedb.NewFile(v)
-		toImport = append(toImport, f)
+}
+
+// Checks if a file a watcher found is skipped by --exclude, --include, .mmignore files, --minsize or --maxsize
+func (a *ImportArgs) isSkipped(path string) bool {
+	info, err := os.Stat(path)
+	if err != nil {
+		slog.Warn("Failed to stat file", "Path", path, "Error", err.Error())
+		return true
 	}
-	for _, d := range a.Import.ImportDirs {
-		filepath.WalkDir(d, func(path string, d fs.DirEntry, _ error) error {
-			if d == nil {
-				slog.Warn("DirEntry was nil?", "Path", path)
-				return nil
-			}
-			if d.IsDir() {
-				return nil
-			}
-			// Check if we want this file imported
-			if !isImportableFile(path) {
-				slog.Debug("Not importing non media", "  bPath", path)
-				return nil
-			}
-			f := filedb.NewFile(path)
-			toImport = append(toImport, f)
-			return nil
-		})
+	for _, d := range a.ImportDirs {
+		if !isUnderDirs(path, []string{d}) {
+			continue
+		}
+		skip, err := walk.Skipped(d, path, a.walkOpts(), info)
+		if err != nil {
+			slog.Warn("Failed to check if file is skipped", "Path", path, "Error", err.Error())
+			return false
+		}
+		return skip
 	}
-	for _, v := range toImport {
-		for _, t := range a.Import.AddTags {
-			err = v.AddTag(t)
+	return false
+}
+
+// Set the user, type, tags, stars & last viewed time of files about to be imported. Tags from --rules are added too
+func (a *ImportArgs) prepareFiles(user *filedb.User, files ...*filedb.File) error {
+	for _, v := range files {
+		v.SetUser(user)
+		t, err := a.types.Detect(v.GetPath(), a.Sniff)
+		if err != nil {
+			slog.Warn("Failed to sniff file", "Path", v.GetPath(), "Error", err.Error())
+		} else if t != nil {
+			v.SetType(t.Mime, string(t.Category))
+		}
+		for _, t := range a.AddTags {
+			err := v.AddTag(t)
 			if err != nil {
-				fmt.Printf("Failed to add tag '%s': %v\n", t, err)
-				return
+				return fmt.Errorf("failed to add tag '%s': %v", t, err)
+			}
+		}
+		if a.rules != nil {
+			if _, err := applyRules(a.rules, v); err != nil {
+				return fmt.Errorf("'%s': %v", v.GetPath(), err)
 			}
 		}
-		if a.Import.SetStars != 0 {
-			err = v.SetStars(uint8(a.Import.SetStars))
+		if a.SetStars != 0 {
+			err := v.SetStars(uint8(a.SetStars))
 			if err != nil {
-				fmt.Printf("Failed to set stars '%d': %v\n", a.Import.SetStars, err)
-				return
+				return fmt.Errorf("failed to set stars '%d': %v", a.SetStars, err)
 			}
 		}
-		if a.Import.SetDate {
+		if a.SetDate {
 			v.MarkFileRead()
 		}
 	}
-	ctx, can := context.WithCancel(context.Background())
-	if a.Import.AddHashes || a.Import.AddSizes {
-		channel := make(chan int64, 10)
-		go ptermProgressBar(len(toImport), channel, ctx)
-		err = filedb.AddInfoToFiles(&filedb.AddInfoOpts{
-			DontAddHash:  !a.Import.AddHashes,
-			DontAddSize:  !a.Import.AddSizes,
-			ProgressChan: channel,
-			Context:      ctx,
+	return nil
+}
+
+// Import the new files a watcher found & mark the removed ones missing
+func importWatchEvents(db *filedb.FileDb, a *ImportArgs, user *filedb.User, events []watch.Event) {
+	toImport := make([]*filedb.File, 0)
+	for _, e := range events {
+		switch e.Op {
+		case watch.Removed:
+			marked, err := db.MarkFileMissing(e.Path)
+			if err != nil {
+				fmt.Printf("! Failed to mark '%s' as missing: %v\n", e.Path, err)
+			} else if marked {
+				fmt.Printf("- '%s' is missing\n", e.Path)
+			}
+		case watch.Created:
+			found, err := db.MarkFileFound(e.Path)
+			if err != nil {
+				fmt.Printf("! Failed to check '%s': %v\n", e.Path, err)
+				continue
+			}
+			if found {
+				fmt.Printf("+ '%s' was found again\n", e.Path)
+				continue
+			}
+			if _, err = db.GetFileByPath(e.Path); err == nil {
+				// Already imported
+				continue
+			}
+			if a.isSkipped(e.Path) {
+				continue
+			}
+			toImport = append(toImport, filedb.NewFile(e.Path))
+		}
+	}
+	if len(toImport) == 0 {
+		return
+	}
+	if err := a.prepareFiles(user, toImport...); err != nil {
+		fmt.Printf("! %v\n", err)
+		return
+	}
+	if a.AddHashes || a.AddSizes || a.AddMeta || a.AddPHash {
+		err := filedb.AddInfoToFiles(&filedb.AddInfoOpts{
+			DontAddHash: !a.AddHashes,
+			DontAddSize: !a.AddSizes,
+			AddMeta:     a.AddMeta,
+			AddPHash:    a.AddPHash,
 		}, toImport...)
 		if err != nil {
-			can()
+			fmt.Printf("! Failed to add file info: %v\n", err)
+			return
+		}
+	}
+	failed, err := db.AddFiles(toImport...)
+	if err != nil {
+		fmt.Printf("! Failed to add files: %v\n", err)
+		return
+	}
+	for _, f := range toImport {
+		if f.GetId() != 0 {
+			fmt.Printf("+ Imported '%s' (%d)\n", f.GetPath(), f.GetId())
+		}
+	}
+	printImportResult(len(toImport), failed, a.Silent)
+}
+
+// Import files added to a.ImportDirs until ctx is done, files removed from them are marked missing
+func watchImport(ctx context.Context, db *filedb.FileDb, a *ImportArgs, user *filedb.User) error {
+	w, err := watch.New(&watch.Opts{
+		Handler: func(events []watch.Event) {
+			importWatchEvents(db, a, user, events)
+		},
+		// The same files as a import, with --sniff files are read until their contents are a known type
+		Filter:   a.isImportable,
+		Settle:   time.Duration(a.Settle) * time.Second,
+		Interval: time.Duration(a.PollInterval) * time.Second,
+		Poll:     a.Poll,
+	}, a.ImportDirs...)
+	if err != nil {
+		return err
+	}
+	return w.Run(ctx)
+}
+
+// Import --importfiles & every importable file in --importdirs, returns false if the import failed
+func importFiles(db *filedb.FileDb, a *ImportArgs, user *filedb.User) bool {
+	toImport := make([]*filedb.File, 0)
+	for _, v := range a.ImportFiles {
+		f := filedb.NewFile(v)
+		toImport = append(toImport, f)
+	}
+	walkRes := walkDirs(a.ImportDirs, a.walkOpts(), func(path string, _ fs.FileInfo) {
+		toImport = append(toImport, filedb.NewFile(path))
+	})
+	if err := a.prepareFiles(user, toImport...); err != nil {
+		fmt.Printf("Failed to prepare files: %v\n", err)
+		return false
+	}
+	if a.AddHashes || a.AddSizes || a.AddMeta || a.AddPHash {
+		err := addInfoWithProgressBar(&filedb.AddInfoOpts{
+			DontAddHash: !a.AddHashes,
+			DontAddSize: !a.AddSizes,
+			AddMeta:     a.AddMeta,
+			AddPHash:    a.AddPHash,
+		}, toImport)
+		if err != nil {
 			fmt.Printf("Failed to add file info: %v\n", err)
+			return false
+		}
+	}
+	failed, err := db.AddFiles(toImport...)
+	if err != nil {
+		fmt.Printf("Failed to add files: %v\n", err)
+		return false
+	}
+	printImportResult(len(toImport), failed, a.Silent)
+	printWalkResult(walkRes, a.Silent)
+	return true
+}
+
+// Import new files in --importdirs & update the ones that changed, files that are gone are marked missing. Returns false if the rescan failed
+func rescanImport(db *filedb.FileDb, a *ImportArgs, user *filedb.User) bool {
+	fingerprints, err := db.GetFingerprints()
+	if err != nil {
+		fmt.Printf("Failed to get files: %v\n", err)
+		return false
+	}
+	// Copies of other files, see 'database --duplicates'
+	locations, err := db.GetLocationPaths()
+	if err != nil {
+		fmt.Printf("Failed to get file locations: %v\n", err)
+		return false
+	}
+	toImport, changed := make([]*filedb.File, 0), make([]*filedb.File, 0)
+	// Imported before modification times were stored, they're trusted if the size is the same
+	modTimes := make(map[int]time.Time)
+	seen := make(map[string]bool)
+	unchanged, found := 0, 0
+	walkRes := walkDirs(a.ImportDirs, a.walkOpts(), func(path string, info fs.FileInfo) {
+		if locations[path] {
+			return
+		}
+		seen[path] = true
+		fp := fingerprints[path]
+		if fp == nil {
+			toImport = append(toImport, filedb.NewFile(path))
 			return
 		}
+		if fp.Missing {
+			if marked, err := db.MarkFileFound(path); err == nil && marked {
+				found++
+			}
+		}
+		if fp.Matches(info.Size(), info.ModTime()) {
+			unchanged++
+		} else if fp.ModTime.IsZero() && fp.Size != 0 && fp.Size == info.Size() {
+			modTimes[fp.Id] = info.ModTime()
+			unchanged++
+		} else if f, err := db.GetFileById(fp.Id); err == nil {
+			changed = append(changed, f)
+		}
+	})
+	// Files in the directories that weren't found, files that are still there were skipped by a pattern or size
+	missing := 0
+	for path, fp := range fingerprints {
+		if seen[path] || !isUnderDirs(path, a.ImportDirs) {
+			continue
+		}
+		if _, err := os.Lstat(path); err == nil {
+			continue
+		}
+		missing++
+		if !fp.Missing {
+			if _, err = db.MarkFileMissing(path); err != nil {
+				fmt.Printf("! Failed to mark '%s' as missing: %v\n", path, err)
+			}
+		}
+	}
+	if len(modTimes) != 0 {
+		if err = db.SetModTimes(modTimes); err != nil {
+			fmt.Printf("Failed to save modification times: %v\n", err)
+			return false
+		}
+	}
+	if err = a.prepareFiles(user, toImport...); err != nil {
+		fmt.Printf("Failed to prepare files: %v\n", err)
+		return false
+	}
+	// Only new & changed files are read, the size is the fingerprint so it's always added
+	if len(toImport) != 0 {
+		err = addInfoWithProgressBar(&filedb.AddInfoOpts{
+			DontAddHash: !a.AddHashes,
+			AddMeta:     a.AddMeta,
+			AddPHash:    a.AddPHash,
+			AddType:     true,
+			Types:       a.types,
+			Sniff:       a.Sniff,
+		}, toImport)
+		if err != nil {
+			fmt.Printf("Failed to add file info: %v\n", err)
+			return false
+		}
+	}
+	// The contents of changed files are new, so they're always hashed again & info they had is read again or removed
+	addMeta, addPHash := a.AddMeta, a.AddPHash
+	for _, f := range changed {
+		if f.GetMeta() != nil {
+			addMeta = true
+			f.SetMeta(nil)
+		}
+		if _, ok := f.GetPHash(); ok {
+			addPHash = true
+			f.ClearPHash()
+		}
+	}
+	if len(changed) != 0 {
+		err = addInfoWithProgressBar(&filedb.AddInfoOpts{
+			AddMeta:  addMeta,
+			AddPHash: addPHash,
+			AddType:  true,
+			Types:    a.types,
+			Sniff:    a.Sniff,
+		}, changed)
+		if err != nil {
+			fmt.Printf("Failed to add file info: %v\n", err)
+			return false
+		}
+	}
+	for _, f := range changed {
+		if f.GetHash() == "" {
+			// Its fingerprint isn't updated, so it's checked again on the next rescan
+			fmt.Printf("! Failed to hash changed file '%s', it was left unchanged\n", f.GetPath())
+			continue
+		}
+		if err = db.UpdateFile(f); err != nil {
+			var dup *filedb.DuplicateError
+			if errors.As(err, &dup) {
+				fmt.Printf("= '%s' changed into a copy of '%s' (%d), its path was recorded. See 'database --duplicates'\n", f.GetPath(), dup.Path, dup.FileId)
+				continue
+			}
+			fmt.Printf("Failed to update changed file '%s': %v\n", f.GetPath(), err)
+			continue
+		}
+		if !a.Silent {
+			fmt.Printf("* '%s' changed\n", f.GetPath())
+		}
 	}
-	can()
 	failed, err := db.AddFiles(toImport...)
 	if err != nil {
 		fmt.Printf("Failed to add files: %v\n", err)
+		return false
+	}
+	printImportResult(len(toImport), failed, a.Silent)
+	fmt.Printf("New: %d, Changed: %d, Missing: %d, Unchanged: %d\n", len(toImport), len(changed), missing, unchanged)
+	if found != 0 {
+		fmt.Printf("%d files that were missing were found again\n", found)
+	}
+	printWalkResult(walkRes, a.Silent)
+	return true
+}
+
+// Checks if path is in one of dirs, path & dirs must both be relative or absolute like the paths a walk or watcher gives
+func isUnderDirs(path string, dirs []string) bool {
+	for _, d := range dirs {
+		rel, err := filepath.Rel(filepath.Clean(d), path)
+		if err == nil && rel != "." && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Add info to files while showing a progress bar
+func addInfoWithProgressBar(opts *filedb.AddInfoOpts, files []*filedb.File) error {
+	ctx, can := context.WithCancel(context.Background())
+	defer can()
+	channel := make(chan int64, 10)
+	go ptermProgressBar("Adding file info", len(files), channel, ctx)
+	opts.ProgressChan, opts.Context = channel, ctx
+	return filedb.AddInfoToFiles(opts, files...)
+}
+
+// Parse import arguments
+func ParseImport(a *ArgList, p *arg.Parser) {
+	if a.Import.User == "" && (a.Import.SetStars != 0 || a.Import.SetDate) {
+		p.FailSubcommand("--stars and --setlastviewed require --user", "import")
 		return
 	}
-	imported := len(toImport)
-	for _, f := range failed {
-		if !a.Import.Silent {
-			fmt.Printf("Failed to add file '%s': %v\n", f.File.GetPath(), f.Error)
-			fmt.Printf("  | Hash: %s\n", f.File.GetHash())
+	if a.Import.Watch && (len(a.Import.ImportDirs) == 0 || a.Import.ImportJson != "") {
+		p.FailSubcommand("--watch requires --importdirs and cannot be used with --importjson", "import")
+		return
+	}
+	if a.Import.Rescan && (len(a.Import.ImportDirs) == 0 || len(a.Import.ImportFiles) > 0 || a.Import.ImportJson != "") {
+		p.FailSubcommand("--rescan requires --importdirs and cannot be used with --importfiles or --importjson", "import")
+		return
+	}
+	if a.Import.PollInterval <= 0 || a.Import.Settle < 0 {
+		p.FailSubcommand("--pollinterval must be positive and --settle cannot be negative", "import")
+		return
+	}
+	if a.Import.ImportJson != "" && (a.Import.Rules != "" || a.Import.Types != "") {
+		p.FailSubcommand("--rules and --types cannot be used with --importjson, add them to the JSON config instead", "import")
+		return
+	}
+	if err := a.Import.walkOpts().Validate(); err != nil {
+		p.FailSubcommand(err.Error(), "import")
+		return
+	}
+	a.Import.types = mediatype.Default()
+	if a.Import.Types != "" {
+		types, err := mediatype.Load(a.Import.Types)
+		if err != nil {
+			fmt.Printf("Failed to load types: %v\n", err)
+			return
 		}
-		imported--
+		a.Import.types = types
+	}
+	if a.Import.Rules != "" {
+		rules, err := tagrules.Load(a.Import.Rules)
+		if err != nil {
+			fmt.Printf("Failed to load rules: %v\n", err)
+			return
+		}
+		rules.SetTypes(a.Import.types)
+		a.Import.rules = rules
+	}
+	db, err := filedb.NewFileDb(a.Import.DatabasePath)
+	if err != nil {
+		fmt.Printf("Failed to create file database: %v\n", err)
+		return
+	}
+	defer db.Close()
+	if a.Import.ImportJson != "" {
+		importJson(db, a.Import.ImportJson)
+		return
+	}
+	var user *filedb.User
+	if a.Import.User != "" {
+		user, err = db.GetUserByName(a.Import.User)
+		if err != nil {
+			fmt.Printf("Failed to get user '%s': %v\n", a.Import.User, err)
+			return
+		}
+	}
+	if a.Import.Rescan {
+		if !rescanImport(db, a.Import, user) {
+			return
+		}
+	} else if !importFiles(db, a.Import, user) {
+		return
+	}
+	if !a.Import.Watch {
+		return
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	fmt.Printf("Watching %d directories for new files, press Ctrl+C to stop\n", len(a.Import.ImportDirs))
+	err = watchImport(ctx, db, a.Import, user)
+	if err != nil {
+		fmt.Printf("Failed to watch directories: %v\n", err)
 	}
-	fmt.Printf("Imported %d files\n", imported)
 }
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"mediamanager/filedb"
+	"mediamanager/mediatype"
+	"mediamanager/phash"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Write a PNG of a gradient, flipped reverses it so it has a different perceptual hash
+func writeTestImage(t *testing.T, path string, size int, flipped bool) {
+	img := image.NewGray(image.Rect(0, 0, size, size))
+	for x := range size {
+		for y := range size {
+			v := uint8(x * 255 / size)
+			if flipped {
+				v = 255 - v
+			}
+			img.SetGray(x, y, color.Gray{Y: v})
+		}
+	}
+	b := &bytes.Buffer{}
+	if err := png.Encode(b, img); err != nil {
+		t.Fatalf("Failed to encode image: %v", err)
+	}
+	writeTestFile(t, path, b.Bytes())
+}
+
+// Write a file with a modification time after the last one, so it's seen as changed even on file systems with coarse times
+func writeTestFile(t *testing.T, path string, data []byte) {
+	var mod time.Time
+	if st, err := os.Stat(path); err == nil {
+		mod = st.ModTime().Add(time.Second)
+	} else {
+		mod = time.Now()
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	if err := os.Chtimes(path, mod, mod); err != nil {
+		t.Fatalf("Failed to set modification time: %v", err)
+	}
+}
+
+func fileHash(t *testing.T, path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read file: %v", err)
+	}
+	return fmt.Sprintf("%x", sha256.Sum256(data))
+}
+
+func TestRescanChangedFile(t *testing.T) {
+	db, err := filedb.NewFileDb(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer db.Close()
+	user, err := db.AddUser("admin", filedb.AccountTypeAdministrator)
+	if err != nil {
+		t.Fatalf("Failed to add user: %v", err)
+	}
+	dir := t.TempDir()
+	path := filepath.Join(dir, "a.png")
+	writeTestImage(t, path, 32, false)
+	a := &ImportArgs{ImportDirs: []string{dir}, AddHashes: true, AddPHash: true, Silent: true, types: mediatype.Default()}
+	if !rescanImport(db, a, user) {
+		t.Fatalf("Rescan failed")
+	}
+
+	// Without -H & -P changed files are still hashed again
+	writeTestImage(t, path, 48, true)
+	a = &ImportArgs{ImportDirs: []string{dir}, Silent: true, types: mediatype.Default()}
+	if !rescanImport(db, a, user) {
+		t.Fatalf("Rescan failed")
+	}
+	f, err := db.GetFileByPath(path)
+	if err != nil {
+		t.Fatalf("Failed to get file: %v", err)
+	}
+	assert.Equalf(t, fileHash(t, path), f.GetHash(), "Changed file kept its old hash")
+	want, err := phash.HashFile(path)
+	if err != nil {
+		t.Fatalf("Failed to hash image: %v", err)
+	}
+	got, ok := f.GetPHash()
+	assert.Truef(t, ok, "Changed file lost its perceptual hash")
+	assert.Equalf(t, want, got, "Changed file kept its old perceptual hash")
+
+	// A perceptual hash that can't be read again is removed
+	writeTestFile(t, path, []byte("not a image anymore"))
+	if !rescanImport(db, a, user) {
+		t.Fatalf("Rescan failed")
+	}
+	f, err = db.GetFileByPath(path)
+	if err != nil {
+		t.Fatalf("Failed to get file: %v", err)
+	}
+	assert.Equalf(t, fileHash(t, path), f.GetHash(), "Changed file kept its old hash")
+	_, ok = f.GetPHash()
+	assert.Falsef(t, ok, "Changed file kept a perceptual hash of its old contents")
+}
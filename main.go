@@ -28,6 +28,7 @@ type ArgList struct {
 	Database *DatabaseArgs `arg:"subcommand:database" help:"Database management"`
 	Web      *WebArgs      `arg:"subcommand:web" help:"Web API"`
 	Import   *ImportArgs   `arg:"subcommand:import" help:"Import into database"`
+	User     *UserArgs     `arg:"subcommand:user" help:"Account management"`
 }
 
 func main() {
@@ -81,6 +82,8 @@ func main() {
 		ParseWeb(args, p)
 	case args.Import != nil:
 		ParseImport(args, p)
+	case args.User != nil:
+		ParseUser(args, p)
 	case args.Version:
 		fmt.Printf("MediaManager & FileDb by Alex Strueby\n")
 		fmt.Printf("  MediaManager Version: %s", VersionString)
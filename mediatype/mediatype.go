@@ -0,0 +1,251 @@
+// Media types files are imported as, a type has a MIME type, a category & the extensions it's imported from.
+//
+// The default types can be extended with a JSON config, see Load. Files can also be sniffed, which reads the start of the file
+// and checks for container signatures (MP4, QuickTime, Matroska, FLAC, WAV) before falling back to http.DetectContentType.
+package mediatype
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type Category string
+
+const (
+	Image Category = "image"
+	Audio Category = "audio"
+	Video Category = "video"
+)
+
+// Bytes read when sniffing, all http.DetectContentType uses
+const sniffLen = 512
+
+type Type struct {
+	Mime       string   // Like 'video/mp4'
+	Category   Category // image, audio or video
+	Extensions []string // Extensions with a leading '.', like '.mp4'. Matched without case
+}
+
+// Types imported by default
+var defaultTypes = []Type{
+	{Mime: "image/jpeg", Category: Image, Extensions: []string{".jpg", ".jpeg"}},
+	{Mime: "image/png", Category: Image, Extensions: []string{".png"}},
+	{Mime: "image/gif", Category: Image, Extensions: []string{".gif"}},
+	{Mime: "audio/mpeg", Category: Audio, Extensions: []string{".mp3"}},
+	{Mime: "audio/flac", Category: Audio, Extensions: []string{".flac"}},
+	{Mime: "audio/wav", Category: Audio, Extensions: []string{".wav"}},
+	{Mime: "video/webm", Category: Video, Extensions: []string{".webm"}},
+	{Mime: "video/mp4", Category: Video, Extensions: []string{".mp4"}},
+	{Mime: "video/quicktime", Category: Video, Extensions: []string{".mov"}},
+	{Mime: "video/x-m4v", Category: Video, Extensions: []string{".m4v"}},
+}
+
+// A set of types, by extension & MIME type
+type Registry struct {
+	byExt  map[string]*Type
+	byMime map[string]*Type
+}
+
+// A JSON config of types, see Load
+type Config struct {
+	NoDefaults bool // Only use Types
+	Types      []Type
+}
+
+// Valid category
+func (c Category) IsValid() bool {
+	return c == Image || c == Audio || c == Video
+}
+
+// A empty registry
+func NewRegistry() *Registry {
+	return &Registry{
+		byExt:  make(map[string]*Type),
+		byMime: make(map[string]*Type),
+	}
+}
+
+// A registry of the default types, .jpg, .jpeg, .png, .gif, .mp3, .flac, .wav, .webm, .mp4, .mov & .m4v
+func Default() *Registry {
+	r := NewRegistry()
+	for _, t := range defaultTypes {
+		if err := r.Add(t); err != nil {
+			panic(fmt.Sprintf("MediaManager: mediatype.Default: Invalid default type %s: %v", t.Mime, err))
+		}
+	}
+	return r
+}
+
+// Read a Config from a JSON file. The types are added to the default types, unless NoDefaults is set
+func Load(path string) (*Registry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read types: %v", err)
+	}
+	c := &Config{}
+	if err = json.Unmarshal(data, c); err != nil {
+		return nil, fmt.Errorf("failed to parse types: %v", err)
+	}
+	r := NewRegistry()
+	if !c.NoDefaults {
+		r = Default()
+	}
+	for _, t := range c.Types {
+		if err = r.Add(t); err != nil {
+			return nil, fmt.Errorf("invalid type '%s': %v", t.Mime, err)
+		}
+	}
+	return r, nil
+}
+
+// Normalize a extension, '.MP4' & 'mp4' are '.mp4'
+func normalizeExt(ext string) string {
+	ext = strings.ToLower(ext)
+	if !strings.HasPrefix(ext, ".") {
+		ext = "." + ext
+	}
+	return ext
+}
+
+// Add a type, extensions of other types are moved to it
+func (r *Registry) Add(t Type) error {
+	t.Mime = strings.ToLower(strings.TrimSpace(t.Mime))
+	if !strings.Contains(t.Mime, "/") {
+		return errors.New("MIME type must be like 'video/mp4'")
+	}
+	if !t.Category.IsValid() {
+		return fmt.Errorf("category must be image, audio or video, not '%s'", t.Category)
+	}
+	exts := make([]string, 0, len(t.Extensions))
+	for _, e := range t.Extensions {
+		if e == "" || e == "." {
+			return errors.New("extensions can't be empty")
+		}
+		exts = append(exts, normalizeExt(e))
+	}
+	t.Extensions = exts
+	if old := r.byMime[t.Mime]; old != nil {
+		// Extend the existing type
+		for _, e := range old.Extensions {
+			if r.byExt[e] == old {
+				t.Extensions = append(t.Extensions, e)
+			}
+		}
+	}
+	nt := &t
+	r.byMime[t.Mime] = nt
+	for _, e := range t.Extensions {
+		r.byExt[e] = nt
+	}
+	return nil
+}
+
+// Add a extension without a type, like the JSON import config Extensions. The type is guessed with mime.TypeByExtension
+// and isn't changed if the extension is already known.
+func (r *Registry) AddExtension(ext string) error {
+	ext = normalizeExt(ext)
+	if r.byExt[ext] != nil {
+		return nil
+	}
+	m, _, _ := strings.Cut(mime.TypeByExtension(ext), ";")
+	c := Category(strings.SplitN(m, "/", 2)[0])
+	if !c.IsValid() {
+		return fmt.Errorf("the type of '%s' isn't known, add it to a types config", ext)
+	}
+	if t := r.byMime[m]; t != nil {
+		t.Extensions = append(t.Extensions, ext)
+		r.byExt[ext] = t
+		return nil
+	}
+	return r.Add(Type{Mime: m, Category: c, Extensions: []string{ext}})
+}
+
+// Type of a path by extension, nil if it isn't known
+func (r *Registry) ByExtension(path string) *Type {
+	ext := filepath.Ext(path)
+	if ext == "" {
+		return nil
+	}
+	return r.byExt[strings.ToLower(ext)]
+}
+
+// Type with a MIME type, nil if it isn't known
+func (r *Registry) ByMime(m string) *Type {
+	return r.byMime[strings.ToLower(m)]
+}
+
+// The file has a extension of a known type
+func (r *Registry) IsImportable(path string) bool {
+	return r.ByExtension(path) != nil
+}
+
+// Get the type of a file, with sniff the start of the file is read & the sniffed type is used if it's known.
+// Otherwise the extension is used, nil is returned if the type isn't known. Only sniffing returns errors.
+func (r *Registry) Detect(path string, sniff bool) (*Type, error) {
+	if sniff {
+		m, err := SniffFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if t := r.ByMime(m); t != nil {
+			return t, nil
+		}
+	}
+	return r.ByExtension(path), nil
+}
+
+// Read the start of a file & get its MIME type, see Sniff
+func SniffFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open file: %v", err)
+	}
+	defer f.Close()
+	buf := make([]byte, sniffLen)
+	n, err := io.ReadFull(f, buf)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return "", fmt.Errorf("failed to read file: %v", err)
+	}
+	return Sniff(buf[:n]), nil
+}
+
+// Get the MIME type of data, the start of a file, without parameters. Container signatures are checked first
+// since http.DetectContentType doesn't know QuickTime, M4V, Matroska or FLAC
+func Sniff(data []byte) string {
+	switch {
+	case len(data) >= 12 && bytes.Equal(data[4:8], []byte("ftyp")):
+		switch string(data[8:12]) {
+		case "qt  ":
+			return "video/quicktime"
+		case "M4V ", "M4VH", "M4VP":
+			return "video/x-m4v"
+		case "M4A ", "M4B ":
+			return "audio/mp4"
+		}
+		return "video/mp4"
+	case bytes.HasPrefix(data, []byte{0x1A, 0x45, 0xDF, 0xA3}):
+		// EBML, the DocType is in the header
+		if bytes.Contains(data, []byte("webm")) {
+			return "video/webm"
+		}
+		return "video/x-matroska"
+	case bytes.HasPrefix(data, []byte("fLaC")):
+		return "audio/flac"
+	case len(data) >= 12 && bytes.HasPrefix(data, []byte("RIFF")) && bytes.Equal(data[8:12], []byte("WAVE")):
+		return "audio/wav"
+	}
+	m, _, _ := strings.Cut(http.DetectContentType(data), ";")
+	if m == "application/octet-stream" && len(data) >= 2 && data[0] == 0xFF && data[1]&0xE0 == 0xE0 && data[1]&0x06 != 0 {
+		// MPEG audio frame without a ID3 tag
+		return "audio/mpeg"
+	}
+	return m
+}
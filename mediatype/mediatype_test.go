@@ -0,0 +1,120 @@
+package mediatype
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDefault(t *testing.T) {
+	r := Default()
+	for path, expected := range map[string]string{
+		"/a/b.jpg":       "image/jpeg",
+		"/a/IMG_001.JPG": "image/jpeg",
+		"/a/b.JpEg":      "image/jpeg",
+		"/a/b.mov":       "video/quicktime",
+		"/a/b.m4v":       "video/x-m4v",
+		"/a/b.flac":      "audio/flac",
+	} {
+		if tp := r.ByExtension(path); assert.NotNilf(t, tp, "'%s' wasn't importable", path) {
+			assert.Equalf(t, expected, tp.Mime, "Wrong type for '%s'", path)
+		}
+	}
+	for _, path := range []string{"/a/b.txt", "/a/b", "/a.jpg/b", "/a/.mp4.part"} {
+		assert.Falsef(t, r.IsImportable(path), "'%s' was importable", path)
+	}
+	assert.Equalf(t, Video, r.ByMime("VIDEO/MP4").Category, "Wrong category")
+	assert.Nilf(t, r.ByMime("video/x-matroska"), "Unknown MIME type was found")
+}
+
+func TestAdd(t *testing.T) {
+	r := Default()
+	assert.NoErrorf(t, r.Add(Type{Mime: "video/x-matroska", Category: Video, Extensions: []string{"MKV", ".mk3d"}}), "Add failed")
+	assert.Equalf(t, "video/x-matroska", r.ByExtension("a.mkv").Mime, "Added type wasn't found")
+	assert.Equalf(t, "video/x-matroska", r.ByExtension("a.mk3d").Mime, "Added type wasn't found")
+	// Extending a type keeps its extensions
+	assert.NoErrorf(t, r.Add(Type{Mime: "image/jpeg", Category: Image, Extensions: []string{".jfif"}}), "Add failed")
+	for _, path := range []string{"a.jpg", "a.jpeg", "a.jfif"} {
+		assert.Equalf(t, "image/jpeg", r.ByExtension(path).Mime, "'%s' lost its type", path)
+	}
+	// Moving a extension
+	assert.NoErrorf(t, r.Add(Type{Mime: "audio/mp4", Category: Audio, Extensions: []string{".m4v"}}), "Add failed")
+	assert.Equalf(t, "audio/mp4", r.ByExtension("a.m4v").Mime, "Extension wasn't moved")
+	for _, tp := range []Type{
+		{Mime: "video", Category: Video},
+		{Mime: "text/plain", Category: "text"},
+		{Mime: "video/a", Category: Video, Extensions: []string{""}},
+	} {
+		assert.Errorf(t, r.Add(tp), "Invalid type %+v was added", tp)
+	}
+	assert.NoErrorf(t, r.AddExtension(".WEBP"), "AddExtension failed")
+	if tp := r.ByExtension("a.webp"); assert.NotNilf(t, tp, "Extension wasn't added") {
+		assert.Equalf(t, Type{Mime: "image/webp", Category: Image, Extensions: []string{".webp"}}, *tp, "Wrong type was guessed")
+	}
+	assert.NoErrorf(t, r.AddExtension("mp4"), "AddExtension of a known extension failed")
+	assert.Errorf(t, r.AddExtension(".unknownext"), "Extension of a unknown type was added")
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	p := filepath.Join(dir, "types.json")
+	os.WriteFile(p, []byte(`{"Types": [{"Mime": "video/x-matroska", "Category": "video", "Extensions": [".mkv"]}]}`), 0644)
+	r, err := Load(p)
+	if assert.NoErrorf(t, err, "Load failed") {
+		assert.Truef(t, r.IsImportable("a.mkv"), "Added type wasn't importable")
+		assert.Truef(t, r.IsImportable("a.mp4"), "Default type wasn't importable")
+	}
+	os.WriteFile(p, []byte(`{"NoDefaults": true, "Types": [{"Mime": "video/x-matroska", "Category": "video", "Extensions": [".mkv"]}]}`), 0644)
+	r, err = Load(p)
+	if assert.NoErrorf(t, err, "Load failed") {
+		assert.Falsef(t, r.IsImportable("a.mp4"), "Default type was used with NoDefaults")
+	}
+	os.WriteFile(p, []byte(`{"Types": [{"Mime": "text/plain", "Category": "text"}]}`), 0644)
+	_, err = Load(p)
+	assert.Errorf(t, err, "Invalid type was loaded")
+	_, err = Load(filepath.Join(dir, "missing.json"))
+	assert.Errorf(t, err, "Missing file was loaded")
+}
+
+func TestSniff(t *testing.T) {
+	for expected, data := range map[string][]byte{
+		"video/mp4":        append([]byte{0, 0, 0, 0x20}, []byte("ftypisom")...),
+		"video/quicktime":  append([]byte{0, 0, 0, 0x14}, []byte("ftypqt  ")...),
+		"video/x-m4v":      append([]byte{0, 0, 0, 0x1C}, []byte("ftypM4V ")...),
+		"video/webm":       append([]byte{0x1A, 0x45, 0xDF, 0xA3, 0x9F, 0x42, 0x82, 0x84}, []byte("webm")...),
+		"video/x-matroska": append([]byte{0x1A, 0x45, 0xDF, 0xA3, 0x9F, 0x42, 0x82, 0x88}, []byte("matroska")...),
+		"audio/flac":       []byte("fLaC\x00\x00\x00\x22"),
+		"audio/wav":        []byte("RIFF\x24\x00\x00\x00WAVEfmt "),
+		"audio/mpeg":       {0xFF, 0xFB, 0x90, 0x64, 0x00},
+		"image/png":        []byte("\x89PNG\x0D\x0A\x1A\x0A\x00\x00\x00\x0DIHDR"),
+		"image/jpeg":       {0xFF, 0xD8, 0xFF, 0xE0},
+		"text/plain":       []byte("hello"),
+	} {
+		assert.Equalf(t, expected, Sniff(data), "Wrong sniffed type")
+	}
+	assert.Equalf(t, "audio/mpeg", Sniff([]byte("ID3\x04\x00")), "ID3 tag wasn't sniffed")
+	dir := t.TempDir()
+	// A PNG with the wrong extension
+	p := filepath.Join(dir, "image.jpg")
+	os.WriteFile(p, []byte("\x89PNG\x0D\x0A\x1A\x0A\x00\x00\x00\x0DIHDR"), 0644)
+	r := Default()
+	tp, err := r.Detect(p, false)
+	if assert.NoErrorf(t, err, "Detect failed") {
+		assert.Equalf(t, "image/jpeg", tp.Mime, "Extension wasn't used")
+	}
+	tp, err = r.Detect(p, true)
+	if assert.NoErrorf(t, err, "Detect failed") {
+		assert.Equalf(t, "image/png", tp.Mime, "Sniffed type wasn't used")
+	}
+	// Unknown sniffed types fall back to the extension
+	p = filepath.Join(dir, "video.mp4")
+	os.WriteFile(p, []byte("not a video"), 0644)
+	tp, err = r.Detect(p, true)
+	if assert.NoErrorf(t, err, "Detect failed") {
+		assert.Equalf(t, "video/mp4", tp.Mime, "Extension wasn't used")
+	}
+	_, err = r.Detect(filepath.Join(dir, "missing.mp4"), true)
+	assert.Errorf(t, err, "Missing file was sniffed")
+}
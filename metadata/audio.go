@@ -0,0 +1,237 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// A 28 bit ID3v2 "syncsafe" integer, the top bit of each byte is unused
+func syncsafe(b []byte) int64 {
+	return int64(b[0]&0x7F)<<21 | int64(b[1]&0x7F)<<14 | int64(b[2]&0x7F)<<7 | int64(b[3]&0x7F)
+}
+
+// Reverse ID3v2 unsynchronisation, which inserts a 0x00 after every 0xFF
+func unsynchronise(b []byte) []byte {
+	return bytes.ReplaceAll(b, []byte{0xFF, 0x00}, []byte{0xFF})
+}
+
+// Decode a ID3v2 text frame, multiple values are separated by NULs & only the first is used
+func decodeId3Text(b []byte) string {
+	if len(b) == 0 {
+		return ""
+	}
+	enc, b := b[0], b[1:]
+	var s string
+	switch enc {
+	case 1, 2:
+		// UTF-16 with a BOM, or big endian without one
+		var order binary.ByteOrder = binary.BigEndian
+		if len(b) >= 2 && b[0] == 0xFF && b[1] == 0xFE {
+			order, b = binary.LittleEndian, b[2:]
+		} else if len(b) >= 2 && b[0] == 0xFE && b[1] == 0xFF {
+			b = b[2:]
+		}
+		u := make([]uint16, 0, len(b)/2)
+		for i := 0; i+1 < len(b); i += 2 {
+			u = append(u, order.Uint16(b[i:]))
+		}
+		s = string(utf16.Decode(u))
+	case 3:
+		s = string(b)
+	default:
+		// ISO-8859-1, every byte is the same code point
+		r := make([]rune, len(b))
+		for i, v := range b {
+			r[i] = rune(v)
+		}
+		s = string(r)
+	}
+	s, _, _ = strings.Cut(s, "\x00")
+	return strings.TrimSpace(s)
+}
+
+// Parse a track number like '3' or '3/12'
+func parseTrack(s string) int {
+	s, _, _ = strings.Cut(s, "/")
+	n, _ := strconv.Atoi(strings.TrimSpace(s))
+	return n
+}
+
+// Read the title, artist, album & track from a ID3v2 tag at the start of the file
+func readId3(r io.ReadSeeker, m *Metadata) error {
+	header, err := readN(r, 10)
+	if err != nil || string(header[:3]) != "ID3" {
+		// Files without tags are fine
+		return nil
+	}
+	version, flags := header[3], header[5]
+	if version < 2 || version > 4 {
+		return nil
+	}
+	tag, err := readN(r, syncsafe(header[6:]))
+	if err != nil {
+		return err
+	}
+	if flags&0x80 != 0 && version < 4 {
+		// Version 4 unsynchronises each frame instead
+		tag = unsynchronise(tag)
+	}
+	if flags&0x40 != 0 && version > 2 && len(tag) >= 4 {
+		// Skip the extended header, its size doesn't include itself in version 3
+		size := int64(binary.BigEndian.Uint32(tag))
+		if version == 3 {
+			size += 4
+		} else {
+			size = syncsafe(tag)
+		}
+		if size > int64(len(tag)) {
+			return errors.New("invalid ID3 extended header")
+		}
+		tag = tag[size:]
+	}
+	frames := map[string]string{
+		"TIT2": "title", "TT2": "title",
+		"TPE1": "artist", "TP1": "artist",
+		"TALB": "album", "TAL": "album",
+		"TRCK": "track", "TRK": "track",
+	}
+	idLen, headerLen := 4, 10
+	if version == 2 {
+		idLen, headerLen = 3, 6
+	}
+	for len(tag) >= headerLen && tag[0] != 0 {
+		id := string(tag[:idLen])
+		var size int64
+		switch version {
+		case 2:
+			size = int64(tag[3])<<16 | int64(tag[4])<<8 | int64(tag[5])
+		case 3:
+			size = int64(binary.BigEndian.Uint32(tag[4:]))
+		default:
+			size = syncsafe(tag[4:])
+		}
+		if size > int64(len(tag)-headerLen) {
+			// Corrupt, keep what was already read
+			break
+		}
+		data := tag[headerLen : int64(headerLen)+size]
+		if version == 4 && tag[9]&0x02 != 0 {
+			data = unsynchronise(data)
+		}
+		switch frames[id] {
+		case "title":
+			m.Title = decodeId3Text(data)
+		case "artist":
+			m.Artist = decodeId3Text(data)
+		case "album":
+			m.Album = decodeId3Text(data)
+		case "track":
+			m.Track = parseTrack(decodeId3Text(data))
+		}
+		tag = tag[int64(headerLen)+size:]
+	}
+	return nil
+}
+
+// Use a Vorbis comment like 'TITLE=Song', the field name is case insensitive
+func applyVorbisComment(m *Metadata, comment string) {
+	k, v, found := strings.Cut(comment, "=")
+	if !found {
+		return
+	}
+	switch strings.ToUpper(k) {
+	case "TITLE":
+		m.Title = v
+	case "ARTIST":
+		m.Artist = v
+	case "ALBUM":
+		m.Album = v
+	case "TRACKNUMBER":
+		m.Track = parseTrack(v)
+	}
+}
+
+// Read Vorbis comments from a FLAC VORBIS_COMMENT block, the values are little endian
+func readVorbisComments(b []byte, m *Metadata) error {
+	next := func() ([]byte, error) {
+		if len(b) < 4 {
+			return nil, errors.New("invalid vorbis comment")
+		}
+		n := binary.LittleEndian.Uint32(b)
+		if int64(n) > int64(len(b)-4) {
+			return nil, errors.New("invalid vorbis comment")
+		}
+		v := b[4 : 4+n]
+		b = b[4+n:]
+		return v, nil
+	}
+	// Vendor string
+	if _, err := next(); err != nil {
+		return err
+	}
+	if len(b) < 4 {
+		return errors.New("invalid vorbis comment")
+	}
+	count := binary.LittleEndian.Uint32(b)
+	b = b[4:]
+	for i := uint32(0); i < count; i++ {
+		c, err := next()
+		if err != nil {
+			return err
+		}
+		applyVorbisComment(m, string(c))
+	}
+	return nil
+}
+
+// Read the duration & Vorbis comments of a FLAC file
+func readFlac(r io.ReadSeeker, m *Metadata) error {
+	magic, err := readN(r, 4)
+	if err != nil || string(magic) != "fLaC" {
+		return errors.New("not a FLAC file")
+	}
+	for {
+		header, err := readN(r, 4)
+		if err != nil {
+			return err
+		}
+		last, kind := header[0]&0x80 != 0, header[0]&0x7F
+		size := int64(header[1])<<16 | int64(header[2])<<8 | int64(header[3])
+		switch kind {
+		case 0:
+			// STREAMINFO, 20 bits of sample rate & 36 bits of total samples start 10 bytes in
+			info, err := readN(r, size)
+			if err != nil {
+				return err
+			}
+			if len(info) >= 18 {
+				v := binary.BigEndian.Uint64(info[10:])
+				rate, samples := v>>44, v&(1<<36-1)
+				if rate != 0 {
+					m.Duration = float64(samples) / float64(rate)
+				}
+			}
+		case 4:
+			// VORBIS_COMMENT
+			comments, err := readN(r, size)
+			if err != nil {
+				return err
+			}
+			if err = readVorbisComments(comments, m); err != nil {
+				return err
+			}
+		default:
+			if _, err = r.Seek(size, io.SeekCurrent); err != nil {
+				return err
+			}
+		}
+		if last {
+			return nil
+		}
+	}
+}
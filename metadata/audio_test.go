@@ -0,0 +1,125 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"unicode/utf16"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func encodeSyncsafe(n int) []byte {
+	return []byte{byte(n>>21) & 0x7F, byte(n>>14) & 0x7F, byte(n>>7) & 0x7F, byte(n) & 0x7F}
+}
+
+type testId3Frame struct {
+	Id   string
+	Data []byte
+}
+
+// Build a ID3v2 tag with padding, frames sizes are syncsafe in version 4
+func buildTestId3(version byte, frames ...testId3Frame) []byte {
+	tag := make([]byte, 0)
+	for _, f := range frames {
+		tag = append(tag, f.Id...)
+		switch version {
+		case 2:
+			tag = append(tag, byte(len(f.Data)>>16), byte(len(f.Data)>>8), byte(len(f.Data)))
+		case 3:
+			tag = binary.BigEndian.AppendUint32(tag, uint32(len(f.Data)))
+		default:
+			tag = append(tag, encodeSyncsafe(len(f.Data))...)
+		}
+		if version != 2 {
+			tag = append(tag, 0, 0)
+		}
+		tag = append(tag, f.Data...)
+	}
+	tag = append(tag, make([]byte, 16)...)
+	header := append([]byte{'I', 'D', '3', version, 0, 0}, encodeSyncsafe(len(tag))...)
+	return append(header, tag...)
+}
+
+// A UTF-16 text frame value with a little endian BOM
+func utf16Text(s string) []byte {
+	b := []byte{1, 0xFF, 0xFE}
+	for _, v := range utf16.Encode([]rune(s)) {
+		b = binary.LittleEndian.AppendUint16(b, v)
+	}
+	return append(b, 0, 0)
+}
+
+func TestReadId3(t *testing.T) {
+	expected := &Metadata{Title: "Song", Artist: "Artïst", Album: "Album", Track: 3}
+	v3 := buildTestId3(3,
+		testId3Frame{"TIT2", []byte("\x00Song")},
+		testId3Frame{"TPE1", utf16Text("Artïst")},
+		testId3Frame{"COMM", []byte("\x00engIgnored")},
+		testId3Frame{"TALB", []byte("\x03Album\x00Second")},
+		testId3Frame{"TRCK", []byte("\x003/12")},
+	)
+	m, err := Extract(writeTestFile(t, "song.mp3", append(v3, 0xFF, 0xFB, 0x90, 0x00)))
+	if assert.NoErrorf(t, err, "Extract failed") {
+		assert.Equalf(t, expected, m, "Wrong ID3v2.3 metadata")
+	}
+	v4 := buildTestId3(4,
+		testId3Frame{"TIT2", []byte("\x03Song")},
+		testId3Frame{"TPE1", []byte("\x03Artïst")},
+		testId3Frame{"TALB", []byte("\x03Album")},
+		testId3Frame{"TRCK", []byte("\x033")},
+	)
+	m = &Metadata{}
+	if assert.NoErrorf(t, readId3(bytes.NewReader(v4), m), "readId3 failed") {
+		assert.Equalf(t, expected, m, "Wrong ID3v2.4 metadata")
+	}
+	v2 := buildTestId3(2,
+		testId3Frame{"TT2", []byte("\x00Song")},
+		testId3Frame{"TP1", []byte("\x00Art\xefst")},
+		testId3Frame{"TAL", []byte("\x00Album")},
+		testId3Frame{"TRK", []byte("\x00 3 ")},
+	)
+	m = &Metadata{}
+	if assert.NoErrorf(t, readId3(bytes.NewReader(v2), m), "readId3 failed") {
+		assert.Equalf(t, expected, m, "Wrong ID3v2.2 metadata")
+	}
+	// Files without tags have no metadata
+	m = &Metadata{}
+	assert.NoErrorf(t, readId3(bytes.NewReader([]byte{0xFF, 0xFB, 0x90, 0x00}), m), "File without a tag failed")
+	assert.Truef(t, m.IsEmpty(), "File without a tag had metadata")
+	assert.Equalf(t, []byte{0xFF, 0xFF, 0x12}, unsynchronise([]byte{0xFF, 0x00, 0xFF, 0x00, 0x12}), "Wrong unsynchronisation")
+}
+
+// A FLAC metadata block header
+func flacBlock(kind byte, last bool, data []byte) []byte {
+	if last {
+		kind |= 0x80
+	}
+	return append([]byte{kind, byte(len(data) >> 16), byte(len(data) >> 8), byte(len(data))}, data...)
+}
+
+func TestReadFlac(t *testing.T) {
+	info := make([]byte, 34)
+	// 44100Hz, 2 channels, 16 bits & 3 seconds of samples
+	binary.BigEndian.PutUint64(info[10:], 44100<<44|1<<41|15<<36|44100*3)
+	comments := binary.LittleEndian.AppendUint32(nil, 6)
+	comments = append(comments, "vendor"...)
+	comments = binary.LittleEndian.AppendUint32(comments, 4)
+	for _, v := range []string{"TITLE=Flac Song", "artist=Someone", "TRACKNUMBER=7", "invalid"} {
+		comments = binary.LittleEndian.AppendUint32(comments, uint32(len(v)))
+		comments = append(comments, v...)
+	}
+	data := []byte("fLaC")
+	data = append(data, flacBlock(0, false, info)...)
+	data = append(data, flacBlock(1, false, make([]byte, 10))...)
+	data = append(data, flacBlock(4, true, comments)...)
+	data = append(data, 0xFF, 0xF8)
+	m, err := Extract(writeTestFile(t, "song.flac", data))
+	if assert.NoErrorf(t, err, "Extract failed") {
+		assert.Equalf(t, &Metadata{Title: "Flac Song", Artist: "Someone", Track: 7, Duration: 3}, m, "Wrong FLAC metadata")
+	}
+	// Comment counts larger then the block
+	bad := append([]byte("fLaC"), flacBlock(4, true, binary.LittleEndian.AppendUint32(comments[:10], 100))...)
+	assert.Errorf(t, readFlac(bytes.NewReader(bad), &Metadata{}), "Corrupt comments were read")
+	assert.Errorf(t, readFlac(bytes.NewReader([]byte("ID3")), &Metadata{}), "Non FLAC was read")
+}
@@ -0,0 +1,245 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strings"
+	"time"
+)
+
+// Read the dimensions of a JPEG & its EXIF data
+func readJpeg(r io.ReadSeeker, m *Metadata) error {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(r, header); err != nil || header[0] != 0xFF || header[1] != 0xD8 {
+		return errors.New("not a JPEG file")
+	}
+	for {
+		// Markers can be padded with any number of 0xFF bytes
+		marker := make([]byte, 1)
+		for marker[0] != 0xFF {
+			if _, err := io.ReadFull(r, marker); err != nil {
+				return err
+			}
+		}
+		for marker[0] == 0xFF {
+			if _, err := io.ReadFull(r, marker); err != nil {
+				return err
+			}
+		}
+		switch {
+		case marker[0] == 0xD9 || marker[0] == 0xDA:
+			// End of image or start of the image data, there's no metadata after this
+			return nil
+		case marker[0] >= 0xD0 && marker[0] <= 0xD7, marker[0] == 0x01:
+			// No length
+			continue
+		}
+		var length uint16
+		if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+			return err
+		}
+		if length < 2 {
+			return errors.New("invalid JPEG segment length")
+		}
+		data, err := readN(r, int64(length)-2)
+		if err != nil {
+			return err
+		}
+		switch {
+		case marker[0] == 0xE1 && bytes.HasPrefix(data, []byte("Exif\x00\x00")):
+			// Bad EXIF data shouldn't stop the dimensions being read
+			readExif(data[6:], m)
+		case marker[0] >= 0xC0 && marker[0] <= 0xCF && marker[0] != 0xC4 && marker[0] != 0xC8 && marker[0] != 0xCC:
+			// Start of frame
+			if len(data) >= 5 {
+				m.Height = int(binary.BigEndian.Uint16(data[1:3]))
+				m.Width = int(binary.BigEndian.Uint16(data[3:5]))
+			}
+		}
+	}
+}
+
+// A entry in a TIFF image file directory
+type tiffEntry struct {
+	Type  uint16
+	Count uint32
+	Value []byte // The value, or the offset of it if it's larger then 4 bytes
+}
+
+type tiffReader struct {
+	data  []byte
+	order binary.ByteOrder
+}
+
+// Read the entries of the IFD at offset
+func (t *tiffReader) ifd(offset uint32) map[uint16]*tiffEntry {
+	entries := make(map[uint16]*tiffEntry)
+	if int64(offset)+2 > int64(len(t.data)) {
+		return entries
+	}
+	n := int(t.order.Uint16(t.data[offset:]))
+	for i := 0; i < n; i++ {
+		start := int(offset) + 2 + i*12
+		if start+12 > len(t.data) {
+			break
+		}
+		e := t.data[start : start+12]
+		entries[t.order.Uint16(e)] = &tiffEntry{Type: t.order.Uint16(e[2:]), Count: t.order.Uint32(e[4:]), Value: e[8:12]}
+	}
+	return entries
+}
+
+// Bytes of a entries value, nil if it's out of range
+func (t *tiffReader) bytes(e *tiffEntry) []byte {
+	sizes := map[uint16]uint32{1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 7: 1, 9: 4, 10: 8}
+	size, found := sizes[e.Type]
+	if !found || e.Count > maxBlockSize {
+		return nil
+	}
+	n := size * e.Count
+	if n <= 4 {
+		return e.Value[:n]
+	}
+	offset := t.order.Uint32(e.Value)
+	if int64(offset)+int64(n) > int64(len(t.data)) {
+		return nil
+	}
+	return t.data[offset : offset+n]
+}
+
+func (t *tiffReader) string(e *tiffEntry) string {
+	if e == nil || e.Type != 2 {
+		return ""
+	}
+	b, _, _ := bytes.Cut(t.bytes(e), []byte{0})
+	return strings.TrimSpace(string(b))
+}
+
+// A SHORT or LONG value
+func (t *tiffReader) uint(e *tiffEntry) uint32 {
+	if e == nil {
+		return 0
+	}
+	b := t.bytes(e)
+	switch {
+	case e.Type == 3 && len(b) >= 2:
+		return uint32(t.order.Uint16(b))
+	case e.Type == 4 && len(b) >= 4:
+		return t.order.Uint32(b)
+	}
+	return 0
+}
+
+// RATIONAL values as floats
+func (t *tiffReader) rationals(e *tiffEntry) []float64 {
+	if e == nil || e.Type != 5 {
+		return nil
+	}
+	b := t.bytes(e)
+	v := make([]float64, 0, e.Count)
+	for i := 0; i+8 <= len(b); i += 8 {
+		num, den := t.order.Uint32(b[i:]), t.order.Uint32(b[i+4:])
+		if den == 0 {
+			return nil
+		}
+		v = append(v, float64(num)/float64(den))
+	}
+	return v
+}
+
+// EXIF tags that are read
+const (
+	exifMake             = 0x010F
+	exifModel            = 0x0110
+	exifDateTime         = 0x0132
+	exifIfdPointer       = 0x8769
+	exifGpsPointer       = 0x8825
+	exifDateTimeOriginal = 0x9003
+	exifPixelWidth       = 0xA002
+	exifPixelHeight      = 0xA003
+	gpsLatitudeRef       = 0x0001
+	gpsLatitude          = 0x0002
+	gpsLongitudeRef      = 0x0003
+	gpsLongitude         = 0x0004
+)
+
+// Read the camera, date taken, dimensions & location from EXIF data
+func readExif(data []byte, m *Metadata) {
+	if len(data) < 8 {
+		return
+	}
+	t := &tiffReader{data: data}
+	switch string(data[:2]) {
+	case "II":
+		t.order = binary.LittleEndian
+	case "MM":
+		t.order = binary.BigEndian
+	default:
+		return
+	}
+	if t.order.Uint16(data[2:]) != 42 {
+		return
+	}
+	ifd0 := t.ifd(t.order.Uint32(data[4:]))
+	maker, model := t.string(ifd0[exifMake]), t.string(ifd0[exifModel])
+	if strings.HasPrefix(strings.ToLower(model), strings.ToLower(maker)) {
+		// Most cameras already include the make in the model
+		m.Camera = model
+	} else {
+		m.Camera = strings.TrimSpace(maker + " " + model)
+	}
+	taken := t.string(ifd0[exifDateTime])
+	if e := ifd0[exifIfdPointer]; e != nil {
+		exif := t.ifd(t.uint(e))
+		if v := t.string(exif[exifDateTimeOriginal]); v != "" {
+			taken = v
+		}
+		if w, h := t.uint(exif[exifPixelWidth]), t.uint(exif[exifPixelHeight]); w != 0 && h != 0 {
+			m.Width, m.Height = int(w), int(h)
+		}
+	}
+	// EXIF dates don't have a time zone
+	if v, err := time.ParseInLocation("2006:01:02 15:04:05", taken, time.Local); err == nil {
+		m.Taken = v
+	}
+	if e := ifd0[exifGpsPointer]; e != nil {
+		gps := t.ifd(t.uint(e))
+		lat, lon := t.rationals(gps[gpsLatitude]), t.rationals(gps[gpsLongitude])
+		if len(lat) == 3 && len(lon) == 3 {
+			m.Latitude = lat[0] + lat[1]/60 + lat[2]/3600
+			m.Longitude = lon[0] + lon[1]/60 + lon[2]/3600
+			if t.string(gps[gpsLatitudeRef]) == "S" {
+				m.Latitude = -m.Latitude
+			}
+			if t.string(gps[gpsLongitudeRef]) == "W" {
+				m.Longitude = -m.Longitude
+			}
+			m.HasLocation = true
+		}
+	}
+}
+
+// Read the dimensions of a PNG
+func readPng(r io.ReadSeeker, m *Metadata) error {
+	// Signature, then the IHDR chunk which must be first
+	header, err := readN(r, 24)
+	if err != nil || !bytes.HasPrefix(header, []byte("\x89PNG\r\n\x1a\n")) || string(header[12:16]) != "IHDR" {
+		return errors.New("not a PNG file")
+	}
+	m.Width = int(binary.BigEndian.Uint32(header[16:]))
+	m.Height = int(binary.BigEndian.Uint32(header[20:]))
+	return nil
+}
+
+// Read the dimensions of a GIF
+func readGif(r io.ReadSeeker, m *Metadata) error {
+	header, err := readN(r, 10)
+	if err != nil || (string(header[:6]) != "GIF87a" && string(header[:6]) != "GIF89a") {
+		return errors.New("not a GIF file")
+	}
+	m.Width = int(binary.LittleEndian.Uint16(header[6:]))
+	m.Height = int(binary.LittleEndian.Uint16(header[8:]))
+	return nil
+}
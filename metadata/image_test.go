@@ -0,0 +1,150 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// A entry of a test TIFF, Data is the raw value or nil for a pointer to the IFD at index Ifd
+type testTiffEntry struct {
+	Tag   uint16
+	Type  uint16
+	Count uint32
+	Data  []byte
+	Ifd   int
+}
+
+func testAscii(tag uint16, s string) testTiffEntry {
+	return testTiffEntry{Tag: tag, Type: 2, Count: uint32(len(s) + 1), Data: append([]byte(s), 0)}
+}
+
+func testRationals(tag uint16, v ...uint32) testTiffEntry {
+	e := testTiffEntry{Tag: tag, Type: 5, Count: uint32(len(v) / 2)}
+	for _, n := range v {
+		e.Data = binary.LittleEndian.AppendUint32(e.Data, n)
+	}
+	return e
+}
+
+func testPointer(tag uint16, ifd int) testTiffEntry {
+	return testTiffEntry{Tag: tag, Type: 4, Count: 1, Ifd: ifd}
+}
+
+// Build a little endian TIFF, each IFD is followed by the values that don't fit in its entries
+func buildTestTiff(ifds ...[]testTiffEntry) []byte {
+	le := binary.LittleEndian
+	offsets := make([]uint32, len(ifds))
+	pos := uint32(8)
+	for i, ifd := range ifds {
+		offsets[i] = pos
+		pos += 2 + 12*uint32(len(ifd)) + 4
+		for _, e := range ifd {
+			if len(e.Data) > 4 {
+				pos += uint32(len(e.Data))
+			}
+		}
+	}
+	b := []byte("II\x2a\x00\x08\x00\x00\x00")
+	for i, ifd := range ifds {
+		dataPos := offsets[i] + 2 + 12*uint32(len(ifd)) + 4
+		data := make([]byte, 0)
+		b = le.AppendUint16(b, uint16(len(ifd)))
+		for _, e := range ifd {
+			b = le.AppendUint16(b, e.Tag)
+			b = le.AppendUint16(b, e.Type)
+			b = le.AppendUint32(b, e.Count)
+			v := e.Data
+			if v == nil {
+				v = le.AppendUint32(nil, offsets[e.Ifd])
+			}
+			if len(v) > 4 {
+				b = le.AppendUint32(b, dataPos+uint32(len(data)))
+				data = append(data, v...)
+			} else {
+				b = append(b, append(v, make([]byte, 4-len(v))...)...)
+			}
+		}
+		b = le.AppendUint32(b, 0)
+		b = append(b, data...)
+	}
+	return b
+}
+
+// A JPEG segment
+func jpegSegment(marker byte, data []byte) []byte {
+	return append([]byte{0xFF, marker, byte((len(data) + 2) >> 8), byte(len(data) + 2)}, data...)
+}
+
+func TestReadJpeg(t *testing.T) {
+	tiff := buildTestTiff(
+		[]testTiffEntry{
+			testAscii(exifMake, "Canon"),
+			testAscii(exifModel, "Canon EOS 5D"),
+			testAscii(exifDateTime, "2020:01:01 00:00:00"),
+			testPointer(exifIfdPointer, 1),
+			testPointer(exifGpsPointer, 2),
+		},
+		[]testTiffEntry{
+			testAscii(exifDateTimeOriginal, "2024:05:06 07:08:09"),
+		},
+		[]testTiffEntry{
+			testAscii(gpsLatitudeRef, "N"),
+			testRationals(gpsLatitude, 51, 1, 30, 1, 0, 1),
+			testAscii(gpsLongitudeRef, "W"),
+			testRationals(gpsLongitude, 0, 1, 7, 1, 30, 1),
+		},
+	)
+	data := []byte{0xFF, 0xD8}
+	data = append(data, jpegSegment(0xE0, []byte("JFIF\x00\x01\x01"))...)
+	data = append(data, jpegSegment(0xE1, append([]byte("Exif\x00\x00"), tiff...))...)
+	data = append(data, jpegSegment(0xC4, []byte{0, 1, 2, 3, 4, 5})...)
+	// Baseline frame, 8 bit 100x200 with 1 component
+	data = append(data, jpegSegment(0xC0, []byte{8, 0, 100, 0, 200, 1, 1, 0x11, 0})...)
+	data = append(data, jpegSegment(0xDA, []byte{1, 2, 3})...)
+	m, err := Extract(writeTestFile(t, "photo.JPG", data))
+	if !assert.NoErrorf(t, err, "Extract failed") {
+		return
+	}
+	assert.Equalf(t, &Metadata{
+		Camera:      "Canon EOS 5D",
+		Taken:       time.Date(2024, 5, 6, 7, 8, 9, 0, time.Local),
+		Width:       200,
+		Height:      100,
+		Latitude:    51.5,
+		Longitude:   -0.125,
+		HasLocation: true,
+	}, m, "Wrong JPEG metadata")
+	// The make is added when the model doesn't include it
+	m = &Metadata{}
+	readExif(buildTestTiff([]testTiffEntry{testAscii(exifMake, "NIKON CORPORATION"), testAscii(exifModel, "D750")}), m)
+	assert.Equalf(t, "NIKON CORPORATION D750", m.Camera, "Make wasn't added to the model")
+	// Corrupt EXIF data is ignored
+	m = &Metadata{}
+	readExif([]byte("II\x2a\x00\xFF\xFF\xFF\xFF"), m)
+	assert.Truef(t, m.IsEmpty(), "Corrupt EXIF data was read")
+	assert.Errorf(t, readJpeg(bytes.NewReader([]byte("GIF89a")), &Metadata{}), "Non JPEG was read")
+	assert.Errorf(t, readJpeg(bytes.NewReader(data[:30]), &Metadata{}), "Truncated JPEG was read")
+}
+
+func TestReadPngGif(t *testing.T) {
+	png := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\x0dIHDR")
+	png = binary.BigEndian.AppendUint32(png, 640)
+	png = binary.BigEndian.AppendUint32(png, 480)
+	m, err := Extract(writeTestFile(t, "a.png", append(png, 8, 6, 0, 0, 0)))
+	if assert.NoErrorf(t, err, "Extract failed") {
+		assert.Equalf(t, &Metadata{Width: 640, Height: 480}, m, "Wrong PNG metadata")
+	}
+	gif := []byte("GIF89a")
+	gif = binary.LittleEndian.AppendUint16(gif, 320)
+	gif = binary.LittleEndian.AppendUint16(gif, 240)
+	m, err = Extract(writeTestFile(t, "a.gif", gif))
+	if assert.NoErrorf(t, err, "Extract failed") {
+		assert.Equalf(t, &Metadata{Width: 320, Height: 240}, m, "Wrong GIF metadata")
+	}
+	_, err = Extract(writeTestFile(t, "b.png", gif))
+	assert.Errorf(t, err, "GIF was read as a PNG")
+}
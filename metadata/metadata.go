@@ -0,0 +1,117 @@
+// Reads metadata embedded in media files, like EXIF data from photos, ID3 tags from songs & the length of videos.
+//
+// Extractors are registered by file extension, see Register.
+package metadata
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// No extractor is registered for the files extension
+var ErrUnsupported = errors.New("unsupported file type")
+
+// Metadata read from a file, values that weren't found are left empty
+type Metadata struct {
+	Title       string    // Song or video title
+	Artist      string    // Song artist
+	Album       string    // Song album
+	Track       int       // Track number in the album, 0 if unknown
+	Camera      string    // Make & model of the camera that took the photo
+	Taken       time.Time // When the photo was taken, zero if unknown
+	Width       int       // Image or video width in pixels
+	Height      int       // Image or video height in pixels
+	Duration    float64   // Audio or video length in seconds
+	Latitude    float64   // Where the photo was taken, only set if HasLocation is
+	Longitude   float64   // Where the photo was taken, only set if HasLocation is
+	HasLocation bool      // Latitude & Longitude are set
+}
+
+// Checks if no metadata was found
+func (m *Metadata) IsEmpty() bool {
+	return *m == Metadata{}
+}
+
+// Reads metadata from a file into m. Values already in m can be overwritten, values that aren't found must be left alone.
+type Extractor func(r io.ReadSeeker, m *Metadata) error
+
+var (
+	extractorLock sync.RWMutex
+	extractors    = make(map[string][]Extractor)
+)
+
+// Register a extractor for a file extension like ".jpg", the case of the extension is ignored.
+// Every extractor registered for a extension is used, in the order they were registered.
+func Register(extension string, e Extractor) {
+	extractorLock.Lock()
+	defer extractorLock.Unlock()
+	extension = strings.ToLower(extension)
+	extractors[extension] = append(extractors[extension], e)
+}
+
+// Checks if a extractor is registered for the extension of path
+func IsSupported(path string) bool {
+	extractorLock.RLock()
+	defer extractorLock.RUnlock()
+	return len(extractors[strings.ToLower(filepath.Ext(path))]) != 0
+}
+
+// Read the metadata of a file with the extractors registered for its extension, returns ErrUnsupported if there are none
+func Extract(path string) (*Metadata, error) {
+	extractorLock.RLock()
+	list := extractors[strings.ToLower(filepath.Ext(path))]
+	extractorLock.RUnlock()
+	if len(list) == 0 {
+		return nil, ErrUnsupported
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %v", err)
+	}
+	defer f.Close()
+	m := &Metadata{}
+	for _, e := range list {
+		if _, err = f.Seek(0, io.SeekStart); err != nil {
+			return nil, fmt.Errorf("failed to seek: %v", err)
+		}
+		if err = e(f, m); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+func init() {
+	for _, v := range []string{".jpg", ".jpeg"} {
+		Register(v, readJpeg)
+	}
+	Register(".png", readPng)
+	Register(".gif", readGif)
+	Register(".mp3", readId3)
+	Register(".flac", readFlac)
+	for _, v := range []string{".mp4", ".mov", ".m4v", ".m4a"} {
+		Register(v, readMp4)
+	}
+	for _, v := range []string{".webm", ".mkv", ".mka"} {
+		Register(v, readMatroska)
+	}
+}
+
+// Largest block of metadata that is read into memory, anything bigger is treated as a corrupt file
+const maxBlockSize = 16 << 20
+
+// Read exactly n bytes
+func readN(r io.Reader, n int64) ([]byte, error) {
+	if n < 0 || n > maxBlockSize {
+		return nil, fmt.Errorf("invalid block size %d", n)
+	}
+	b := make([]byte, n)
+	_, err := io.ReadFull(r, b)
+	return b, err
+}
@@ -0,0 +1,57 @@
+package metadata
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Write data to a file with the given name in a temporary directory
+func writeTestFile(t *testing.T, name string, data []byte) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, data, 0666); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+	return path
+}
+
+func TestRegister(t *testing.T) {
+	assert.Falsef(t, IsSupported("a.mmtest"), "Extension was supported before being registered")
+	_, err := Extract(writeTestFile(t, "a.mmtest", []byte("x")))
+	assert.ErrorIsf(t, err, ErrUnsupported, "Unregistered extension didn't return ErrUnsupported")
+	Register(".MMTest", func(r io.ReadSeeker, m *Metadata) error {
+		m.Title = "first"
+		m.Width = 1
+		return nil
+	})
+	Register(".mmtest", func(r io.ReadSeeker, m *Metadata) error {
+		// Every extractor starts at the beginning of the file
+		b, err := io.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		m.Title = string(b)
+		return nil
+	})
+	assert.Truef(t, IsSupported("/dir/A.MMTEST"), "Extension case wasn't ignored")
+	m, err := Extract(writeTestFile(t, "b.MMTEST", []byte("second")))
+	if assert.NoErrorf(t, err, "Extract failed") {
+		assert.Equalf(t, &Metadata{Title: "second", Width: 1}, m, "Extractors weren't all used in order")
+	}
+	Register(".mmfail", func(r io.ReadSeeker, m *Metadata) error {
+		return errors.New("bad file")
+	})
+	_, err = Extract(writeTestFile(t, "c.mmfail", []byte("x")))
+	assert.Errorf(t, err, "Extractor error wasn't returned")
+	_, err = Extract(filepath.Join(t.TempDir(), "missing.jpg"))
+	assert.Errorf(t, err, "Missing file didn't fail")
+}
+
+func TestIsEmpty(t *testing.T) {
+	assert.Truef(t, (&Metadata{}).IsEmpty(), "Empty metadata wasn't empty")
+	assert.Falsef(t, (&Metadata{Track: 1}).IsEmpty(), "Metadata with a track was empty")
+}
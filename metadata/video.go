@@ -0,0 +1,282 @@
+package metadata
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+)
+
+// A MP4/MOV box (atom) header. Size includes the header, -1 if the box goes to the end of its parent.
+type mp4Box struct {
+	Type       string
+	Size       int64
+	HeaderSize int64
+}
+
+func readMp4Box(r io.Reader) (*mp4Box, error) {
+	header, err := readN(r, 8)
+	if err != nil {
+		return nil, err
+	}
+	b := &mp4Box{Type: string(header[4:]), Size: int64(binary.BigEndian.Uint32(header)), HeaderSize: 8}
+	switch b.Size {
+	case 0:
+		b.Size = -1
+	case 1:
+		// 64 bit size
+		large, err := readN(r, 8)
+		if err != nil {
+			return nil, err
+		}
+		b.Size, b.HeaderSize = int64(binary.BigEndian.Uint64(large)), 16
+	}
+	if b.Size != -1 && b.Size < b.HeaderSize {
+		return nil, errors.New("invalid box size")
+	}
+	return b, nil
+}
+
+// Call fn for every box in r until end bytes have been read (-1 for the end of the file), fn must read or skip the whole box
+func walkMp4Boxes(r io.ReadSeeker, end int64, fn func(b *mp4Box, bodyEnd int64) error) error {
+	for {
+		pos, err := r.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return err
+		}
+		if end != -1 && pos+8 > end {
+			return nil
+		}
+		b, err := readMp4Box(r)
+		if errors.Is(err, io.EOF) && end == -1 {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		bodyEnd := end
+		if b.Size != -1 {
+			bodyEnd = pos + b.Size
+		}
+		if err = fn(b, bodyEnd); err != nil {
+			return err
+		}
+		if bodyEnd == -1 {
+			return nil
+		}
+		if _, err = r.Seek(bodyEnd, io.SeekStart); err != nil {
+			return err
+		}
+	}
+}
+
+// Read up to n bytes of the element or box being walked, end is where it ends or -1 for the end of the file
+func readBody(r io.ReadSeeker, end int64, n int64) ([]byte, error) {
+	if end != -1 {
+		pos, err := r.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return nil, err
+		}
+		n = min(n, end-pos)
+	}
+	return readN(r, n)
+}
+
+// Read the duration from the movie header & the resolution from the first video track header
+func readMp4(r io.ReadSeeker, m *Metadata) error {
+	return walkMp4Boxes(r, -1, func(b *mp4Box, end int64) error {
+		if b.Type != "moov" {
+			return nil
+		}
+		return walkMp4Boxes(r, end, func(b *mp4Box, end int64) error {
+			switch b.Type {
+			case "mvhd":
+				body, err := readBody(r, end, 32)
+				if err != nil {
+					return err
+				}
+				// Version 1 uses 64 bit times & durations
+				var timescale, duration uint64
+				if len(body) >= 32 && body[0] == 1 {
+					timescale, duration = uint64(binary.BigEndian.Uint32(body[20:])), binary.BigEndian.Uint64(body[24:])
+				} else if len(body) >= 20 {
+					timescale, duration = uint64(binary.BigEndian.Uint32(body[12:])), uint64(binary.BigEndian.Uint32(body[16:]))
+				}
+				if timescale != 0 {
+					m.Duration = float64(duration) / float64(timescale)
+				}
+			case "trak":
+				return walkMp4Boxes(r, end, func(b *mp4Box, end int64) error {
+					if b.Type != "tkhd" || m.Width != 0 {
+						return nil
+					}
+					body, err := readBody(r, end, 96)
+					if err != nil {
+						return err
+					}
+					// Width & height are 16.16 fixed point numbers at the end, audio tracks have 0
+					offset := 76
+					if len(body) > 0 && body[0] == 1 {
+						offset = 88
+					}
+					if len(body) >= offset+8 {
+						m.Width = int(binary.BigEndian.Uint32(body[offset:]) >> 16)
+						m.Height = int(binary.BigEndian.Uint32(body[offset+4:]) >> 16)
+					}
+					return nil
+				})
+			}
+			return nil
+		})
+	})
+}
+
+// Matroska/WebM element ids that are read
+const (
+	ebmlHeader        = 0x1A45DFA3
+	ebmlSegment       = 0x18538067
+	ebmlInfo          = 0x1549A966
+	ebmlTimecodeScale = 0x2AD7B1
+	ebmlDuration      = 0x4489
+	ebmlTitle         = 0x7BA9
+	ebmlTracks        = 0x1654AE6B
+	ebmlTrackEntry    = 0xAE
+	ebmlVideo         = 0xE0
+	ebmlPixelWidth    = 0xB0
+	ebmlPixelHeight   = 0xBA
+	ebmlCluster       = 0x1F43B675
+)
+
+// Read a EBML variable length integer. Ids keep their length marker, sizes don't & are -1 if they're unknown.
+func readEbmlVint(r io.Reader, keepMarker bool) (int64, error) {
+	first := make([]byte, 1)
+	if _, err := io.ReadFull(r, first); err != nil {
+		return 0, err
+	}
+	length := 1
+	for mask := byte(0x80); length <= 8 && first[0]&mask == 0; mask >>= 1 {
+		length++
+	}
+	if length > 8 {
+		return 0, errors.New("invalid EBML integer")
+	}
+	rest, err := readN(r, int64(length-1))
+	if err != nil {
+		return 0, err
+	}
+	v := int64(first[0])
+	if !keepMarker {
+		v &= int64(0xFF >> length)
+	}
+	allOnes := v == int64(0xFF>>length)
+	for _, b := range rest {
+		v = v<<8 | int64(b)
+		allOnes = allOnes && b == 0xFF
+	}
+	if !keepMarker && allOnes {
+		return -1, nil
+	}
+	return v, nil
+}
+
+func readEbmlUint(b []byte) uint64 {
+	v := uint64(0)
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+	return v
+}
+
+// Call fn for every element in r until end (-1 for the end of the file), fn must read or skip the whole element. Returning io.EOF stops without a error.
+func walkEbml(r io.ReadSeeker, end int64, fn func(id int64, bodyEnd int64) error) error {
+	for {
+		pos, err := r.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return err
+		}
+		if end != -1 && pos >= end {
+			return nil
+		}
+		id, err := readEbmlVint(r, true)
+		if errors.Is(err, io.EOF) && end == -1 {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		size, err := readEbmlVint(r, false)
+		if err != nil {
+			return err
+		}
+		bodyStart, err := r.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return err
+		}
+		bodyEnd := end
+		if size != -1 {
+			bodyEnd = bodyStart + size
+		}
+		if err = fn(id, bodyEnd); err != nil {
+			return err
+		}
+		if bodyEnd == -1 {
+			return nil
+		}
+		if _, err = r.Seek(bodyEnd, io.SeekStart); err != nil {
+			return err
+		}
+	}
+}
+
+// Read the duration, title & resolution of a Matroska or WebM file
+func readMatroska(r io.ReadSeeker, m *Metadata) error {
+	header, err := readEbmlVint(r, true)
+	if err != nil || header != ebmlHeader {
+		return errors.New("not a Matroska file")
+	}
+	if _, err = r.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	scale := uint64(1000000)
+	duration := 0.0
+	var walk func(end int64) error
+	walk = func(end int64) error {
+		return walkEbml(r, end, func(id int64, end int64) error {
+			switch id {
+			case ebmlSegment, ebmlInfo, ebmlTracks, ebmlTrackEntry, ebmlVideo:
+				return walk(end)
+			case ebmlCluster:
+				// Media data, the headers come before it
+				return io.EOF
+			case ebmlTimecodeScale, ebmlDuration, ebmlTitle, ebmlPixelWidth, ebmlPixelHeight:
+			default:
+				return nil
+			}
+			body, err := readBody(r, end, maxBlockSize)
+			if err != nil {
+				return err
+			}
+			switch {
+			case id == ebmlTimecodeScale:
+				scale = readEbmlUint(body)
+			case id == ebmlTitle:
+				m.Title = string(body)
+			case id == ebmlPixelWidth && m.Width == 0:
+				// Only the first video track is used
+				m.Width = int(readEbmlUint(body))
+			case id == ebmlPixelHeight && m.Height == 0:
+				m.Height = int(readEbmlUint(body))
+			case id == ebmlDuration && len(body) == 4:
+				duration = float64(math.Float32frombits(binary.BigEndian.Uint32(body)))
+			case id == ebmlDuration && len(body) == 8:
+				duration = math.Float64frombits(binary.BigEndian.Uint64(body))
+			}
+			return nil
+		})
+	}
+	err = walk(-1)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	// Duration is in TimecodeScale nanoseconds
+	m.Duration = duration * float64(scale) / 1e9
+	return nil
+}
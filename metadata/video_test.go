@@ -0,0 +1,128 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// A MP4 box
+func mp4TestBox(kind string, body ...[]byte) []byte {
+	b := binary.BigEndian.AppendUint32(nil, 0)
+	b = append(b, kind...)
+	for _, v := range body {
+		b = append(b, v...)
+	}
+	binary.BigEndian.PutUint32(b, uint32(len(b)))
+	return b
+}
+
+// A version 0 track header with a 16.16 width & height
+func mp4TestTkhd(width, height uint32) []byte {
+	body := make([]byte, 84)
+	binary.BigEndian.PutUint32(body[76:], width<<16)
+	binary.BigEndian.PutUint32(body[80:], height<<16)
+	return mp4TestBox("tkhd", body)
+}
+
+func TestReadMp4(t *testing.T) {
+	mvhd := make([]byte, 100)
+	binary.BigEndian.PutUint32(mvhd[12:], 1000)
+	binary.BigEndian.PutUint32(mvhd[16:], 90500)
+	// Media data with a 64 bit size
+	mdat := append(binary.BigEndian.AppendUint32(nil, 1), "mdat"...)
+	mdat = binary.BigEndian.AppendUint64(mdat, 20)
+	mdat = append(mdat, 1, 2, 3, 4)
+	data := mp4TestBox("ftyp", []byte("isom\x00\x00\x02\x00"))
+	data = append(data, mdat...)
+	data = append(data, mp4TestBox("moov",
+		mp4TestBox("mvhd", mvhd),
+		// Audio tracks have no size
+		mp4TestBox("trak", mp4TestTkhd(0, 0), mp4TestBox("mdia")),
+		mp4TestBox("trak", mp4TestTkhd(1920, 1080)),
+		mp4TestBox("trak", mp4TestTkhd(640, 480)),
+	)...)
+	m, err := Extract(writeTestFile(t, "video.mp4", data))
+	if assert.NoErrorf(t, err, "Extract failed") {
+		assert.Equalf(t, &Metadata{Width: 1920, Height: 1080, Duration: 90.5}, m, "Wrong MP4 metadata")
+	}
+	// Version 1 headers use 64 bit values
+	mvhd = make([]byte, 112)
+	mvhd[0] = 1
+	binary.BigEndian.PutUint32(mvhd[20:], 600)
+	binary.BigEndian.PutUint64(mvhd[24:], 600*60)
+	m = &Metadata{}
+	if assert.NoErrorf(t, readMp4(bytes.NewReader(mp4TestBox("moov", mp4TestBox("mvhd", mvhd))), m), "readMp4 failed") {
+		assert.Equalf(t, 60.0, m.Duration, "Wrong version 1 duration")
+	}
+	bad := append(binary.BigEndian.AppendUint32(nil, 4), "moov"...)
+	assert.Errorf(t, readMp4(bytes.NewReader(bad), &Metadata{}), "Invalid box size was read")
+}
+
+// Encode a EBML element id without its leading zero bytes
+func ebmlTestId(id uint32) []byte {
+	b := binary.BigEndian.AppendUint32(nil, id)
+	for len(b) > 1 && b[0] == 0 {
+		b = b[1:]
+	}
+	return b
+}
+
+// A EBML element with a 2 byte size
+func ebmlTestElement(id uint32, body ...[]byte) []byte {
+	data := bytes.Join(body, nil)
+	b := ebmlTestId(id)
+	return append(append(b, 0x40|byte(len(data)>>8), byte(len(data))), data...)
+}
+
+func TestReadMatroska(t *testing.T) {
+	duration := binary.BigEndian.AppendUint64(nil, math.Float64bits(12345))
+	data := ebmlTestElement(ebmlHeader, ebmlTestElement(0x4282, []byte("webm")))
+	// Segments can have a unknown size
+	data = append(data, ebmlTestId(ebmlSegment)...)
+	data = append(data, 0x01, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF)
+	data = append(data, ebmlTestElement(ebmlInfo,
+		ebmlTestElement(ebmlTimecodeScale, []byte{0x0F, 0x42, 0x40}),
+		ebmlTestElement(ebmlDuration, duration),
+		ebmlTestElement(ebmlTitle, []byte("Webm Title")),
+	)...)
+	data = append(data, ebmlTestElement(ebmlTracks,
+		// Audio track
+		ebmlTestElement(ebmlTrackEntry, ebmlTestElement(0xD7, []byte{1})),
+		ebmlTestElement(ebmlTrackEntry, ebmlTestElement(ebmlVideo,
+			ebmlTestElement(ebmlPixelWidth, []byte{0x02, 0x80}),
+			ebmlTestElement(ebmlPixelHeight, []byte{0x01, 0x68}),
+		)),
+		ebmlTestElement(ebmlTrackEntry, ebmlTestElement(ebmlVideo,
+			ebmlTestElement(ebmlPixelWidth, []byte{0x01}),
+			ebmlTestElement(ebmlPixelHeight, []byte{0x01}),
+		)),
+	)...)
+	// Clusters with a unknown size, nothing after the first is read
+	data = append(data, ebmlTestId(ebmlCluster)...)
+	data = append(data, 0xFF, 0xA3, 0x81, 0x00)
+	m, err := Extract(writeTestFile(t, "video.webm", data))
+	if assert.NoErrorf(t, err, "Extract failed") {
+		assert.Equalf(t, &Metadata{Title: "Webm Title", Width: 640, Height: 360, Duration: 12.345}, m, "Wrong Matroska metadata")
+	}
+	// Float durations with a different scale
+	m = &Metadata{}
+	data = ebmlTestElement(ebmlHeader)
+	data = append(data, ebmlTestElement(ebmlSegment, ebmlTestElement(ebmlInfo,
+		ebmlTestElement(ebmlTimecodeScale, []byte{0x3B, 0x9A, 0xCA, 0x00}),
+		ebmlTestElement(ebmlDuration, binary.BigEndian.AppendUint32(nil, math.Float32bits(2.5))),
+	))...)
+	if assert.NoErrorf(t, readMatroska(bytes.NewReader(data), m), "readMatroska failed") {
+		assert.Equalf(t, 2.5, m.Duration, "Wrong float32 duration")
+	}
+	assert.Errorf(t, readMatroska(bytes.NewReader([]byte("RIFF")), &Metadata{}), "Non Matroska was read")
+	for v, expected := range map[string]int64{"\x81": 1, "\x40\x02": 2, "\xFF": -1, "\x7F\xFF": -1, "\x10\x00\x00\x05": 5} {
+		n, err := readEbmlVint(bytes.NewReader([]byte(v)), false)
+		if assert.NoErrorf(t, err, "readEbmlVint failed") {
+			assert.Equalf(t, expected, n, "Wrong value for %x", v)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+// Perceptual hashes of images, the same picture saved at a different quality or size has a hash that only differs by a few bits.
+//
+// The hash is a 64 bit difference hash (dHash) of a 9x8 grayscale copy of the image. Only JPEG, PNG & GIF files are supported,
+// they are decoded with the standard library.
+package phash
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"math/bits"
+	"mediamanager/imagedecode"
+	"os"
+)
+
+// The file type can't be hashed
+var ErrUnsupported = errors.New("unsupported file type")
+
+// Bits in a hash, the largest possible distance
+const Bits = 64
+
+// Size of the grayscale copy, each row has one more column then bits so every pixel has a neighbour
+const (
+	gridWidth  = 9
+	gridHeight = 8
+)
+
+// Checks if a file can be hashed, by its extension
+func IsSupported(path string) bool {
+	return imagedecode.IsSupported(path)
+}
+
+// Number of bits that differ between two hashes, 0 is the same image
+func Distance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+// Hash the image in a file.
+//
+// ErrUnsupported is returned if the file isn't a JPEG, PNG or GIF.
+func HashFile(path string) (uint64, error) {
+	if !IsSupported(path) {
+		return 0, ErrUnsupported
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open file: %v", err)
+	}
+	defer f.Close()
+	return Hash(f)
+}
+
+// Decode a image & hash it
+func Hash(r io.ReadSeeker) (uint64, error) {
+	img, err := imagedecode.Decode(r)
+	if err != nil {
+		return 0, err
+	}
+	return HashImage(img), nil
+}
+
+// Hash a decoded image. Every bit is set if a pixel of the grayscale copy is brighter then the one to its right.
+func HashImage(img image.Image) uint64 {
+	grid := grayscale(img)
+	hash := uint64(0)
+	for y := range gridHeight {
+		for x := range gridWidth - 1 {
+			hash <<= 1
+			if grid[y][x] > grid[y][x+1] {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
+
+// Average brightness of each cell of the image split into a gridWidth x gridHeight grid, from 0 to 0xffff.
+//
+// Transparent parts are treated as white, like thumbnails.
+func grayscale(img image.Image) [gridHeight][gridWidth]uint64 {
+	b := img.Bounds()
+	var sums, counts [gridHeight][gridWidth]uint64
+	// Cells are at least 1 pixel, images smaller then the grid repeat pixels
+	cellX := make([][]int, b.Dx())
+	for x := range b.Dx() {
+		cellX[x] = cells(x, b.Dx(), gridWidth)
+	}
+	for y := range b.Dy() {
+		rows := cells(y, b.Dy(), gridHeight)
+		for x := range b.Dx() {
+			v := luminance(img, b.Min.X+x, b.Min.Y+y)
+			for _, row := range rows {
+				for _, col := range cellX[x] {
+					sums[row][col] += v
+					counts[row][col]++
+				}
+			}
+		}
+	}
+	for y := range gridHeight {
+		for x := range gridWidth {
+			sums[y][x] /= counts[y][x]
+		}
+	}
+	return sums
+}
+
+// The cells pixel i of n falls in when split into size cells
+func cells(i, n, size int) []int {
+	if n >= size {
+		return []int{i * size / n}
+	}
+	// Small images, every cell this pixel covers
+	c := make([]int, 0, 1)
+	for j := range size {
+		if j*n/size == i {
+			c = append(c, j)
+		}
+	}
+	return c
+}
+
+// Brightness of a pixel from 0 to 0xffff
+func luminance(img image.Image, x, y int) uint64 {
+	switch img := img.(type) {
+	case *image.YCbCr:
+		// JPEGs, the luma is already there
+		return uint64(img.Y[img.YOffset(x, y)]) * 0x101
+	case *image.Gray:
+		return uint64(img.Pix[img.PixOffset(x, y)]) * 0x101
+	}
+	r, g, b, a := img.At(x, y).RGBA()
+	// Premultiplied, so adding the missing alpha puts it on white
+	r, g, b = r+0xffff-a, g+0xffff-a, b+0xffff-a
+	// Same weights as color.GrayModel
+	return (19595*uint64(r) + 38470*uint64(g) + 7471*uint64(b) + 1<<15) >> 16
+}
@@ -0,0 +1,120 @@
+package phash
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// A image of soft waves, drawn at any size. inverted swaps light & dark
+func testImage(width, height int, inverted bool) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := range height {
+		for x := range width {
+			v := 128 + 100*math.Sin(float64(x)/float64(width)*3*math.Pi)*math.Cos(float64(y)/float64(height)*2*math.Pi)
+			if inverted {
+				v = 255 - v
+			}
+			img.Set(x, y, color.NRGBA{R: uint8(v), G: uint8(v / 2), B: uint8(255 - v), A: 255})
+		}
+	}
+	return img
+}
+
+func encode(t *testing.T, img image.Image, quality int) *bytes.Reader {
+	b := &bytes.Buffer{}
+	var err error
+	if quality == 0 {
+		err = png.Encode(b, img)
+	} else {
+		err = jpeg.Encode(b, img, &jpeg.Options{Quality: quality})
+	}
+	if err != nil {
+		t.Fatalf("Failed to encode image: %v", err)
+	}
+	return bytes.NewReader(b.Bytes())
+}
+
+func TestHash(t *testing.T) {
+	original, err := Hash(encode(t, testImage(800, 600, false), 0))
+	if !assert.NoErrorf(t, err, "Hash failed") {
+		return
+	}
+	assert.NotZerof(t, original, "Image had a empty hash")
+	// The same picture saved differently
+	for _, v := range []struct {
+		Width, Height, Quality int
+	}{{800, 600, 95}, {800, 600, 20}, {200, 150, 0}, {123, 77, 50}} {
+		h, err := Hash(encode(t, testImage(v.Width, v.Height, false), v.Quality))
+		if assert.NoErrorf(t, err, "Hash failed") {
+			assert.LessOrEqualf(t, Distance(original, h), 4, "%dx%d at quality %d wasn't similar", v.Width, v.Height, v.Quality)
+		}
+	}
+	h, _ := Hash(encode(t, testImage(800, 600, true), 0))
+	assert.Greaterf(t, Distance(original, h), 32, "Inverted image was similar")
+	// Images smaller then the grid
+	_, err = Hash(encode(t, testImage(2, 2, false), 0))
+	assert.NoErrorf(t, err, "Tiny image wasn't hashed")
+	_, err = Hash(bytes.NewReader([]byte("not a image")))
+	assert.Errorf(t, err, "Invalid image was hashed")
+}
+
+func TestHashImage(t *testing.T) {
+	// Transparent pixels are white
+	white := image.NewNRGBA(image.Rect(0, 0, 18, 8))
+	clear := image.NewNRGBA(image.Rect(0, 0, 18, 8))
+	for y := range 8 {
+		for x := range 9 {
+			white.Set(x, y, color.NRGBA{A: 255})
+			clear.Set(x, y, color.NRGBA{A: 255})
+		}
+		for x := 9; x < 18; x++ {
+			white.Set(x, y, color.NRGBA{R: 255, G: 255, B: 255, A: 255})
+		}
+	}
+	assert.Equalf(t, HashImage(white), HashImage(clear), "Transparent pixels weren't white")
+	// Every row is dark, then light
+	assert.Zerof(t, HashImage(white), "Wrong hash")
+	// Offset bounds
+	assert.Equalf(t, HashImage(white), HashImage(white.SubImage(image.Rect(0, 0, 18, 8))), "Sub image had a different hash")
+}
+
+func TestHashFile(t *testing.T) {
+	dir := t.TempDir()
+	p := filepath.Join(dir, "a.JPG")
+	r := encode(t, testImage(100, 100, false), 90)
+	data := make([]byte, r.Len())
+	r.Read(data)
+	if err := os.WriteFile(p, data, 0444); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+	h, err := HashFile(p)
+	if assert.NoErrorf(t, err, "HashFile failed") {
+		expected, _ := Hash(bytes.NewReader(data))
+		assert.Equalf(t, expected, h, "Wrong hash")
+	}
+	_, err = HashFile(filepath.Join(dir, "b.mp4"))
+	assert.ErrorIsf(t, err, ErrUnsupported, "Unsupported file didn't return ErrUnsupported")
+	_, err = HashFile(filepath.Join(dir, "c.png"))
+	assert.Errorf(t, err, "Missing file was hashed")
+}
+
+func TestDistance(t *testing.T) {
+	assert.Equal(t, 0, Distance(0xf0f0, 0xf0f0))
+	assert.Equal(t, 64, Distance(0, math.MaxUint64))
+	assert.Equal(t, 2, Distance(0b1010, 0b0110))
+}
+
+func TestIsSupported(t *testing.T) {
+	for path, expected := range map[string]bool{"a.jpg": true, "b.JPEG": true, "c.png": true, "d.gif": true, "e.webp": false, "f": false, "g.mp4": false} {
+		assert.Equalf(t, expected, IsSupported(path), "Wrong support for '%s'", path)
+	}
+}
@@ -0,0 +1,171 @@
+// Tags files by their path with rules, for example
+//
+//	{"Pattern": "^/media/tv/(?P<series>[^/]+)/Season (?P<season>\\d+)/"}
+//
+// tags '/media/tv/Foo/Season 2/01.mkv' with series:foo & season:2. Files can also be tagged by their extension.
+package tagrules
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mediamanager/mediatype"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// A rule matched against the path of a file, with '/' as the separator. Every named group that matched becomes a '<name>:<value>' tag.
+type Rule struct {
+	Pattern string   // Regular expression, can match anywhere in the path
+	Glob    string   // Used instead of Pattern, matches the whole path. '*' matches inside a path element, '**' across them, '?' one character & '{name}' captures a path element as a named group
+	Tags    []string // Tags added when the rule matches, $name & ${name} are replaced with named groups
+}
+
+// Rules used when importing, the keys are the same in the import JSON config
+type Config struct {
+	Rules         []Rule
+	TypeTags      bool                // Add type:image, type:video or type:audio by extension, see Engine.SetTypes
+	ExtensionTags map[string][]string // Tags added to files by extension, like {".mkv": ["container:mkv"]}
+}
+
+type compiledRule struct {
+	re   *regexp.Regexp
+	tags []string
+}
+
+// Compiled rules, see New
+type Engine struct {
+	rules         []*compiledRule
+	typeTags      bool
+	types         *mediatype.Registry // Used by typeTags
+	extensionTags map[string][]string // Lower case extensions
+}
+
+// Convert a glob to a regular expression matching the whole path
+func globToRegexp(glob string) (string, error) {
+	b := strings.Builder{}
+	b.WriteString("^")
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; c {
+		case '*':
+			if i+1 < len(glob) && glob[i+1] == '*' {
+				b.WriteString(".*")
+				i++
+			} else {
+				b.WriteString("[^/]*")
+			}
+		case '?':
+			b.WriteString("[^/]")
+		case '{':
+			end := strings.IndexByte(glob[i:], '}')
+			if end == -1 {
+				return "", errors.New("unclosed '{'")
+			}
+			name := glob[i+1 : i+end]
+			if name == "" {
+				return "", errors.New("'{}' needs a name")
+			}
+			b.WriteString("(?P<" + name + ">[^/]+)")
+			i += end
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("$")
+	return b.String(), nil
+}
+
+// Compile the rules of c, fails if a pattern is invalid
+func New(c *Config) (*Engine, error) {
+	e := &Engine{
+		rules:         make([]*compiledRule, 0, len(c.Rules)),
+		typeTags:      c.TypeTags,
+		types:         mediatype.Default(),
+		extensionTags: make(map[string][]string),
+	}
+	for i, r := range c.Rules {
+		pattern := r.Pattern
+		if (r.Pattern == "") == (r.Glob == "") {
+			return nil, fmt.Errorf("rule %d must have a pattern or a glob", i)
+		}
+		if r.Glob != "" {
+			var err error
+			if pattern, err = globToRegexp(r.Glob); err != nil {
+				return nil, fmt.Errorf("rule %d has a invalid glob: %v", i, err)
+			}
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("rule %d has a invalid pattern: %v", i, err)
+		}
+		e.rules = append(e.rules, &compiledRule{re: re, tags: r.Tags})
+	}
+	for ext, tags := range c.ExtensionTags {
+		ext = strings.ToLower(ext)
+		if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		e.extensionTags[ext] = append(e.extensionTags[ext], tags...)
+	}
+	return e, nil
+}
+
+// Read a Config from a JSON file, like the import JSON config, & compile it
+func Load(path string) (*Engine, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read rules: %v", err)
+	}
+	c := &Config{}
+	if err = json.Unmarshal(data, c); err != nil {
+		return nil, fmt.Errorf("failed to parse rules: %v", err)
+	}
+	return New(c)
+}
+
+// Set the types TypeTags uses, the default types are used until this is called
+func (e *Engine) SetTypes(r *mediatype.Registry) {
+	e.types = r
+}
+
+// Get the tags the rules give a file, lower case like tags in the database. Each tag is only returned once
+func (e *Engine) Tags(path string) []string {
+	path = filepath.ToSlash(path)
+	tags := make([]string, 0)
+	add := func(t string) {
+		t = strings.ToLower(strings.TrimSpace(t))
+		if t == "" || strings.HasSuffix(t, ":") {
+			return
+		}
+		for _, v := range tags {
+			if v == t {
+				return
+			}
+		}
+		tags = append(tags, t)
+	}
+	for _, r := range e.rules {
+		m := r.re.FindStringSubmatchIndex(path)
+		if m == nil {
+			continue
+		}
+		for i, name := range r.re.SubexpNames() {
+			if name != "" && m[2*i] != -1 {
+				add(name + ":" + path[m[2*i]:m[2*i+1]])
+			}
+		}
+		for _, t := range r.tags {
+			add(string(r.re.ExpandString(nil, t, path, m)))
+		}
+	}
+	if t := e.types.ByExtension(path); t != nil && e.typeTags {
+		add("type:" + string(t.Category))
+	}
+	ext := strings.ToLower(filepath.Ext(path))
+	for _, t := range e.extensionTags[ext] {
+		add(t)
+	}
+	return tags
+}
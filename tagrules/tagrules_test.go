@@ -0,0 +1,65 @@
+package tagrules
+
+import (
+	"mediamanager/mediatype"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTags(t *testing.T) {
+	e, err := New(&Config{
+		Rules: []Rule{
+			{Pattern: `^/media/tv/(?P<series>[^/]+)/Season (?P<season>\d+)/`, Tags: []string{"tv"}},
+			{Glob: "/media/music/{artist}/{album}/*.mp3", Tags: []string{"music", "by:${artist}"}},
+			{Pattern: `(?P<year>\d{4})?\.mkv$`},
+		},
+		TypeTags:      true,
+		ExtensionTags: map[string][]string{"mkv": {"container:mkv"}, ".MP3": {"lossy"}},
+	})
+	if !assert.NoErrorf(t, err, "New failed") {
+		return
+	}
+	for path, expected := range map[string][]string{
+		"/media/tv/Some Show/Season 2/01.mp4":   {"series:some show", "season:2", "tv", "type:video"},
+		"/media/tv/Some Show/Extras/01.mp4":     {"type:video"},
+		"/media/music/Band/Album/01 Song.mp3":   {"artist:band", "album:album", "music", "by:band", "type:audio", "lossy"},
+		"/media/music/Band/Album/Disc 1/01.mp3": {"type:audio", "lossy"},
+		"/media/films/Film 1999.MKV":            {"container:mkv"},
+		"/media/films/Film 1999.mkv":            {"year:1999", "container:mkv"},
+		"/media/films/Film.mkv":                 {"container:mkv"},
+		"/media/tv/Other/Season 1/file.txt":     {"series:other", "season:1", "tv"},
+	} {
+		assert.Equalf(t, expected, e.Tags(path), "Wrong tags for '%s'", path)
+	}
+	e, _ = New(&Config{})
+	assert.Emptyf(t, e.Tags("/a.mp4"), "Empty config added tags")
+	e, _ = New(&Config{TypeTags: true})
+	types := mediatype.Default()
+	types.Add(mediatype.Type{Mime: "video/x-matroska", Category: mediatype.Video, Extensions: []string{".mkv"}})
+	e.SetTypes(types)
+	assert.Equalf(t, []string{"type:video"}, e.Tags("/a.MKV"), "Added type wasn't used")
+}
+
+func TestNew(t *testing.T) {
+	for _, r := range []Rule{
+		{},
+		{Pattern: "a", Glob: "a"},
+		{Pattern: "(?P<a"},
+		{Glob: "/{a"},
+		{Glob: "/{}"},
+	} {
+		_, err := New(&Config{Rules: []Rule{r}})
+		assert.Errorf(t, err, "Invalid rule %+v was compiled", r)
+	}
+	p := filepath.Join(t.TempDir(), "rules.json")
+	os.WriteFile(p, []byte(`{"Dirs": {}, "Rules": [{"Glob": "/tv/{series}/**"}], "TypeTags": true}`), 0644)
+	e, err := Load(p)
+	if assert.NoErrorf(t, err, "Load failed") {
+		assert.Equalf(t, []string{"series:a", "type:image"}, e.Tags("/tv/a/b/c.png"), "Wrong tags")
+	}
+	_, err = Load(filepath.Join(t.TempDir(), "missing.json"))
+	assert.Errorf(t, err, "Missing file was loaded")
+}
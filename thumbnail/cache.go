@@ -0,0 +1,240 @@
+package thumbnail
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image/jpeg"
+	"log/slog"
+	"mediamanager/filedb"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// JPEG quality of thumbnails
+const quality = 85
+
+// A directory of thumbnails named by the hash of the file they were made from, so moved or renamed files keep theirs.
+//
+// Layout: <Dir>/<First 2 characters of the hash>/<Hash>-<Size>.jpg
+type Cache struct {
+	dir string
+}
+
+// Create a cache in dir, it's created when the first thumbnail is written
+func NewCache(dir string) *Cache {
+	return &Cache{dir: dir}
+}
+
+// The cache directory used when none is given, <Database path>.thumbs
+func DefaultDir(databasePath string) string {
+	return databasePath + ".thumbs"
+}
+
+// Get the cache directory
+func (c *Cache) GetDir() string {
+	return c.dir
+}
+
+// Checks if a hash is lowercase hex, so it can't be used to leave the cache directory
+func isValidHash(hash string) bool {
+	if len(hash) < 2 {
+		return false
+	}
+	for _, v := range hash {
+		if (v < '0' || v > '9') && (v < 'a' || v > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// Path of the thumbnail of a file with this hash, it might not exist
+func (c *Cache) Path(hash string, size int) (string, error) {
+	if !isValidHash(hash) {
+		return "", fmt.Errorf("invalid hash '%s'", hash)
+	}
+	if !IsValidSize(size) {
+		return "", fmt.Errorf("invalid size %d", size)
+	}
+	return filepath.Join(c.dir, hash[:2], fmt.Sprintf("%s-%d.jpg", hash, size)), nil
+}
+
+// Checks if the thumbnail of a file with this hash exists
+func (c *Cache) Has(hash string, size int) bool {
+	p, err := c.Path(hash, size)
+	if err != nil {
+		return false
+	}
+	_, err = os.Stat(p)
+	return err == nil
+}
+
+// Get the path of a thumbnail, creating it from src if it isn't cached. hash must be the hash of src.
+//
+// ErrUnsupported is returned if src can't have a thumbnail.
+func (c *Cache) Get(hash string, size int, src string) (string, error) {
+	p, err := c.Path(hash, size)
+	if err != nil {
+		return "", err
+	}
+	if _, err = os.Stat(p); err == nil {
+		return p, nil
+	}
+	if !IsSupported(src) {
+		return "", ErrUnsupported
+	}
+	// Only ever opened for reading
+	f, err := os.Open(src)
+	if err != nil {
+		return "", fmt.Errorf("failed to open file: %v", err)
+	}
+	img, err := Generate(f, size)
+	f.Close()
+	if err != nil {
+		return "", err
+	}
+	if err = os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return "", fmt.Errorf("failed to create cache directory: %v", err)
+	}
+	// Write somewhere else first so a partial thumbnail is never served
+	tmp, err := os.CreateTemp(filepath.Dir(p), "tmp-*.jpg")
+	if err != nil {
+		return "", fmt.Errorf("failed to create thumbnail: %v", err)
+	}
+	err = jpeg.Encode(tmp, img, &jpeg.Options{Quality: quality})
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), p)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", fmt.Errorf("failed to write thumbnail: %v", err)
+	}
+	slog.Debug("Created thumbnail", "Path", src, "Thumbnail", p)
+	return p, nil
+}
+
+// Options for GenerateFiles
+type GenerateOpts struct {
+	Size         int             // Size of the thumbnails. Default: DefaultSize
+	Goroutines   int             // Goroutines to use. Default: runtime.NumCPU(), decoding is CPU bound & each image is held in memory.
+	Context      context.Context // Context to wait on. Default: context.Background
+	ProgressChan chan<- int64    // The current completed number will be sent every time the value changes, -1 will be sent when done.
+}
+
+// What GenerateFiles did
+type GenerateResult struct {
+	Generated int // Thumbnails created
+	Cached    int // Files that already had a thumbnail
+	Skipped   int // Files without a hash or of a unsupported type
+	Failed    int // Files that couldn't be read, the errors are logged
+}
+
+func generateFilesRoutine(c *Cache, size int, recv <-chan *filedb.File, wg *sync.WaitGroup, prog *atomic.Int64, ctx context.Context, generated, cached, skipped, failed *atomic.Int64) {
+	defer wg.Done()
+	for {
+		select {
+		case <-ctx.Done():
+			// Abort (Context closed)
+			return
+		case f := <-recv:
+			if f == nil {
+				// Channel closed.
+				return
+			}
+			if f.GetHash() == "" || !IsSupported(f.GetPath()) {
+				skipped.Add(1)
+			} else if c.Has(f.GetHash(), size) {
+				cached.Add(1)
+			} else if _, err := c.Get(f.GetHash(), size, f.GetPath()); err != nil {
+				slog.Warn("Failed to create thumbnail", "Path", f.GetPath(), "Error", err.Error())
+				failed.Add(1)
+			} else {
+				generated.Add(1)
+			}
+			prog.Add(1)
+		}
+	}
+}
+
+// Create the thumbnails of files that don't have one, like filedb.AddInfoToFiles.
+//
+// If opts is nil the default options will be used.
+func (c *Cache) GenerateFiles(opts *GenerateOpts, files ...*filedb.File) (*GenerateResult, error) {
+	if len(files) == 0 {
+		return nil, errors.New("0 files passed to GenerateFiles")
+	}
+	// Verify options
+	if opts == nil {
+		opts = &GenerateOpts{}
+	}
+	if opts.Size == 0 {
+		opts.Size = DefaultSize
+	}
+	if !IsValidSize(opts.Size) {
+		return nil, fmt.Errorf("invalid size %d", opts.Size)
+	}
+	if opts.Goroutines == 0 {
+		opts.Goroutines = runtime.NumCPU()
+	}
+	if opts.Context == nil {
+		opts.Context = context.Background()
+	}
+	// Setup context
+	ctx, cancel := context.WithCancel(opts.Context)
+	// Setup goroutines
+	var pg, generated, cached, skipped, failed atomic.Int64
+	wg := sync.WaitGroup{}
+	fileCh := make(chan *filedb.File, opts.Goroutines*3)
+	wg.Add(opts.Goroutines)
+	slog.Debug("Starting goroutines for generating thumbnails", "Options", opts, "Files", len(files))
+	for range opts.Goroutines {
+		go generateFilesRoutine(c, opts.Size, fileCh, &wg, &pg, ctx, &generated, &cached, &skipped, &failed)
+	}
+	if opts.ProgressChan != nil {
+		go func() {
+			// Last value, set it to something it can't be so we write at first.
+			last := int64(0xfffffffffffffff)
+			for {
+				select {
+				case <-ctx.Done():
+					// Send -1 as a done
+					opts.ProgressChan <- -1
+					return
+				default:
+					n := pg.Load()
+					if last != n {
+						opts.ProgressChan <- n
+						last = n
+					}
+				}
+			}
+		}()
+	}
+	// Start sending files, stopping if the context is
+	func() {
+		for _, v := range files {
+			select {
+			case fileCh <- v:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	close(fileCh)
+	wg.Wait()
+	// Cancel the context, which will stop the progress.
+	cancel()
+	return &GenerateResult{
+		Generated: int(generated.Load()),
+		Cached:    int(cached.Load()),
+		Skipped:   int(skipped.Load()),
+		Failed:    int(failed.Load()),
+	}, opts.Context.Err()
+}
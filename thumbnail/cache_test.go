@@ -0,0 +1,102 @@
+package thumbnail
+
+import (
+	"bytes"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"mediamanager/filedb"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Write a PNG to dir & get it with its hash
+func writeTestPng(t *testing.T, dir, name string, width, height int) *filedb.File {
+	b := &bytes.Buffer{}
+	if err := png.Encode(b, testImage(width, height)); err != nil {
+		t.Fatalf("Failed to encode PNG: %v", err)
+	}
+	p := filepath.Join(dir, name)
+	if err := os.WriteFile(p, b.Bytes(), 0444); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+	f, err := filedb.NewFileWithInfo(p)
+	if err != nil {
+		t.Fatalf("Failed to hash test file: %v", err)
+	}
+	return f
+}
+
+func TestCache(t *testing.T) {
+	media, cacheDir := t.TempDir(), filepath.Join(t.TempDir(), "thumbs")
+	f := writeTestPng(t, media, "a.png", 600, 300)
+	c := NewCache(cacheDir)
+	assert.Falsef(t, c.Has(f.GetHash(), 256), "Cache had a thumbnail before it was made")
+	p, err := c.Get(f.GetHash(), 256, f.GetPath())
+	if !assert.NoErrorf(t, err, "Get failed") {
+		return
+	}
+	assert.Equalf(t, filepath.Join(cacheDir, f.GetHash()[:2], f.GetHash()+"-256.jpg"), p, "Wrong thumbnail path")
+	assert.Truef(t, c.Has(f.GetHash(), 256), "Thumbnail wasn't cached")
+	thumb, err := os.Open(p)
+	if assert.NoErrorf(t, err, "Failed to open thumbnail") {
+		config, err := jpeg.DecodeConfig(thumb)
+		thumb.Close()
+		if assert.NoErrorf(t, err, "Thumbnail isn't a JPEG") {
+			assert.Equalf(t, []int{256, 128}, []int{config.Width, config.Height}, "Wrong thumbnail size")
+		}
+	}
+	// Cached thumbnails are used without reading the original
+	os.Remove(f.GetPath())
+	p2, err := c.Get(f.GetHash(), 256, f.GetPath())
+	assert.NoErrorf(t, err, "Cached thumbnail wasn't used")
+	assert.Equalf(t, p, p2, "Cached thumbnail has a different path")
+	_, err = c.Get(f.GetHash(), 128, f.GetPath())
+	assert.Errorf(t, err, "Thumbnail of a missing file was made")
+	entries, _ := os.ReadDir(filepath.Dir(p))
+	assert.Lenf(t, entries, 1, "Temporary files were left in the cache")
+	// The original is never modified
+	f = writeTestPng(t, media, "b.png", 10, 10)
+	original, _ := os.ReadFile(f.GetPath())
+	_, err = c.Get(f.GetHash(), 512, f.GetPath())
+	assert.NoErrorf(t, err, "Get failed")
+	after, _ := os.ReadFile(f.GetPath())
+	assert.Equalf(t, original, after, "Original file was modified")
+	for _, hash := range []string{"", "../../etc", "ABCDEF", "a"} {
+		_, err = c.Path(hash, 256)
+		assert.Errorf(t, err, "Invalid hash '%s' was used", hash)
+	}
+	_, err = c.Path(f.GetHash(), 300)
+	assert.Errorf(t, err, "Invalid size was used")
+	_, err = c.Get("abcd", 256, filepath.Join(media, "video.mp4"))
+	assert.ErrorIsf(t, err, ErrUnsupported, "Unsupported file didn't return ErrUnsupported")
+}
+
+func TestGenerateFiles(t *testing.T) {
+	media := t.TempDir()
+	c := NewCache(filepath.Join(t.TempDir(), "thumbs"))
+	a := writeTestPng(t, media, "a.png", 300, 300)
+	b := writeTestPng(t, media, "b.png", 50, 80)
+	noHash := filedb.NewFile(a.GetPath())
+	text := filepath.Join(media, "c.txt")
+	os.WriteFile(text, []byte("text"), 0444)
+	unsupported, _ := filedb.NewFileWithInfo(text)
+	corrupt := filepath.Join(media, "d.gif")
+	os.WriteFile(corrupt, []byte("GIF89a"), 0444)
+	corruptFile, _ := filedb.NewFileWithInfo(corrupt)
+	if _, err := c.Get(b.GetHash(), 128, b.GetPath()); err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	res, err := c.GenerateFiles(&GenerateOpts{Size: 128, Goroutines: 2}, a, b, noHash, unsupported, corruptFile)
+	if assert.NoErrorf(t, err, "GenerateFiles failed") {
+		assert.Equalf(t, &GenerateResult{Generated: 1, Cached: 1, Skipped: 2, Failed: 1}, res, "Wrong result")
+	}
+	assert.Truef(t, c.Has(a.GetHash(), 128), "Thumbnail wasn't generated")
+	_, err = c.GenerateFiles(&GenerateOpts{Size: 100}, a)
+	assert.Errorf(t, err, "Invalid size was used")
+	_, err = c.GenerateFiles(nil)
+	assert.Errorf(t, err, "No files didn't fail")
+}
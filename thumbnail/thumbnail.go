@@ -0,0 +1,100 @@
+// Creates small JPEG previews of images & caches them by the hash of the original.
+//
+// Only JPEG, PNG & GIF files are supported, they are decoded with the standard library. The original files are only ever read.
+package thumbnail
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"io"
+	"mediamanager/imagedecode"
+	"slices"
+)
+
+// The file type can't have a thumbnail
+var ErrUnsupported = errors.New("unsupported file type")
+
+// Sizes thumbnails can be made at, the longest side is scaled down to the size
+var Sizes = []int{128, 256, 512}
+
+// Size used when none is given
+const DefaultSize = 256
+
+// Checks if a thumbnail can be made of a file, by its extension
+func IsSupported(path string) bool {
+	return imagedecode.IsSupported(path)
+}
+
+// Checks if size is one of Sizes
+func IsValidSize(size int) bool {
+	return slices.Contains(Sizes, size)
+}
+
+// Decode a image & scale it so its longest side is size, images smaller then that keep their size.
+//
+// Transparent parts are made white, as thumbnails are stored as JPEGs.
+func Generate(r io.ReadSeeker, size int) (*image.RGBA, error) {
+	if size <= 0 {
+		return nil, fmt.Errorf("invalid size %d", size)
+	}
+	src, err := imagedecode.Decode(r)
+	if err != nil {
+		return nil, err
+	}
+	width, height := scaledSize(src.Bounds().Dx(), src.Bounds().Dy(), size)
+	return resize(src, width, height), nil
+}
+
+// Size of a image scaled down so its longest side is size, keeping the aspect ratio
+func scaledSize(width, height, size int) (int, int) {
+	if width <= size && height <= size {
+		return width, height
+	}
+	if width >= height {
+		return size, max(1, height*size/width)
+	}
+	return max(1, width*size/height), size
+}
+
+// Scale src to width x height by averaging every source pixel that covers a destination pixel, over a white background.
+// This is only meant for scaling down.
+func resize(src image.Image, width, height int) *image.RGBA {
+	// Draw has fast paths for the types the decoders return
+	b := src.Bounds()
+	rgba, ok := src.(*image.RGBA)
+	if !ok || b.Min != (image.Point{}) {
+		rgba = image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+		draw.Draw(rgba, rgba.Bounds(), src, b.Min, draw.Src)
+	}
+	sw, sh := rgba.Bounds().Dx(), rgba.Bounds().Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for dy := range height {
+		y0 := dy * sh / height
+		y1 := max(y0+1, (dy+1)*sh/height)
+		for dx := range width {
+			x0 := dx * sw / width
+			x1 := max(x0+1, (dx+1)*sw/width)
+			var r, g, b, a uint64
+			for y := y0; y < y1; y++ {
+				row := rgba.Pix[y*rgba.Stride+x0*4 : y*rgba.Stride+x1*4]
+				for i := 0; i < len(row); i += 4 {
+					r += uint64(row[i])
+					g += uint64(row[i+1])
+					b += uint64(row[i+2])
+					a += uint64(row[i+3])
+				}
+			}
+			n := uint64((x1 - x0) * (y1 - y0))
+			// Colours are premultiplied, so adding the missing alpha is the same as drawing over white
+			white := 255*n - a
+			i := dy*dst.Stride + dx*4
+			dst.Pix[i] = uint8((r + white) / n)
+			dst.Pix[i+1] = uint8((g + white) / n)
+			dst.Pix[i+2] = uint8((b + white) / n)
+			dst.Pix[i+3] = 255
+		}
+	}
+	return dst
+}
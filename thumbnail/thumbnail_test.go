@@ -0,0 +1,77 @@
+package thumbnail
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// A image with the left half red & the right half transparent
+func testImage(width, height int) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := range height {
+		for x := range width / 2 {
+			img.Set(x, y, color.NRGBA{R: 255, A: 255})
+		}
+	}
+	return img
+}
+
+func TestScaledSize(t *testing.T) {
+	for _, v := range []struct{ Width, Height, Size, ExpectedWidth, ExpectedHeight int }{
+		{1000, 500, 256, 256, 128},
+		{500, 1000, 256, 128, 256},
+		{256, 256, 256, 256, 256},
+		{100, 50, 256, 100, 50},
+		{10000, 1, 128, 128, 1},
+	} {
+		w, h := scaledSize(v.Width, v.Height, v.Size)
+		assert.Equalf(t, []int{v.ExpectedWidth, v.ExpectedHeight}, []int{w, h}, "Wrong size for %dx%d at %d", v.Width, v.Height, v.Size)
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	src := testImage(400, 200)
+	encoded := map[string]*bytes.Buffer{}
+	for name, encode := range map[string]func(*bytes.Buffer) error{
+		"png": func(b *bytes.Buffer) error { return png.Encode(b, src) },
+		"gif": func(b *bytes.Buffer) error { return gif.Encode(b, src, nil) },
+		"jpg": func(b *bytes.Buffer) error { return jpeg.Encode(b, src, nil) },
+	} {
+		encoded[name] = &bytes.Buffer{}
+		if err := encode(encoded[name]); err != nil {
+			t.Fatalf("Failed to encode %s: %v", name, err)
+		}
+	}
+	for name, data := range encoded {
+		img, err := Generate(bytes.NewReader(data.Bytes()), 128)
+		if !assert.NoErrorf(t, err, "Generate failed for %s", name) {
+			continue
+		}
+		assert.Equalf(t, image.Rect(0, 0, 128, 64), img.Bounds(), "Wrong %s thumbnail size", name)
+		r, g, b, _ := img.At(10, 10).RGBA()
+		assert.Truef(t, r > 0xF000 && g < 0x1000 && b < 0x1000, "Left of the %s thumbnail isn't red", name)
+	}
+	// Transparent pixels are white
+	img, err := Generate(bytes.NewReader(encoded["png"].Bytes()), 512)
+	if assert.NoErrorf(t, err, "Generate failed") {
+		assert.Equalf(t, image.Rect(0, 0, 400, 200), img.Bounds(), "Small image was scaled up")
+		assert.Equalf(t, color.RGBA{255, 255, 255, 255}, img.At(300, 100), "Transparent pixel wasn't made white")
+	}
+	_, err = Generate(bytes.NewReader([]byte("not a image")), 128)
+	assert.Errorf(t, err, "Invalid image was decoded")
+	_, err = Generate(bytes.NewReader(encoded["png"].Bytes()), 0)
+	assert.Errorf(t, err, "Invalid size was used")
+}
+
+func TestIsSupported(t *testing.T) {
+	for path, expected := range map[string]bool{"a.jpg": true, "b.JPEG": true, "c.png": true, "d.gif": true, "e.webp": false, "f": false, "g.mp4": false} {
+		assert.Equalf(t, expected, IsSupported(path), "Wrong support for '%s'", path)
+	}
+}
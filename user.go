@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"mediamanager/filedb"
+	"os"
+	"strings"
+
+	"github.com/alexflint/go-arg"
+	"golang.org/x/term"
+)
+
+// User arguments, each action is its own subcommand
+type UserArgs struct {
+	Add           *UserAddArgs           `arg:"subcommand:add" help:"Add a account"`
+	Remove        *UserRemoveArgs        `arg:"subcommand:remove" help:"Remove a account along with its stars, last viewed times, API keys & logins"`
+	Passwd        *UserPasswdArgs        `arg:"subcommand:passwd" help:"Set the password of a account"`
+	List          *UserListArgs          `arg:"subcommand:list" help:"List every account"`
+	SetPermission *UserSetPermissionArgs `arg:"subcommand:set-permission" help:"Set the account type of a account"`
+}
+
+type UserAddArgs struct {
+	DatabasePath string `arg:"positional,required" help:"Path to the database."`
+	Name         string `arg:"positional" help:"Name of the account, required unless --generate is used"`
+	AccountType  string `arg:"-t,--type" help:"Account type, must be 'guest', 'user', 'moderator' or 'administrator'" default:"user"`
+	Generate     bool   `arg:"--generate" help:"Generate a random name (If not provided) & password, they are only shown once"`
+	NoPassword   bool   `arg:"--nopassword" help:"Don't set a password, the account can't log in but can still use API keys. Cannot be used with --generate"`
+}
+
+type UserRemoveArgs struct {
+	DatabasePath string `arg:"positional,required" help:"Path to the database."`
+	Name         string `arg:"positional,required" help:"Name of the account"`
+}
+
+type UserPasswdArgs struct {
+	DatabasePath string `arg:"positional,required" help:"Path to the database."`
+	Name         string `arg:"positional,required" help:"Name of the account"`
+	Generate     bool   `arg:"--generate" help:"Generate a random password, it is only shown once"`
+}
+
+type UserListArgs struct {
+	DatabasePath string `arg:"positional,required" help:"Path to the database."`
+}
+
+type UserSetPermissionArgs struct {
+	DatabasePath string `arg:"positional,required" help:"Path to the database."`
+	Name         string `arg:"positional,required" help:"Name of the account"`
+	AccountType  string `arg:"positional,required" help:"Account type, must be 'guest', 'user', 'moderator' or 'administrator'"`
+}
+
+// Length of generated names & passwords
+const (
+	generatedNameLength     = 8
+	generatedPasswordLength = 24
+)
+
+// Read a password from stdin, if stdin is a terminal the password isn't shown and must be entered twice.
+func readPassword() (string, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			return "", fmt.Errorf("failed to read password: %v", err)
+		}
+		return strings.TrimRight(line, "\r\n"), nil
+	}
+	fmt.Printf("Password: ")
+	password, err := term.ReadPassword(fd)
+	fmt.Println()
+	if err != nil {
+		return "", fmt.Errorf("failed to read password: %v", err)
+	}
+	fmt.Printf("Confirm password: ")
+	confirm, err := term.ReadPassword(fd)
+	fmt.Println()
+	if err != nil {
+		return "", fmt.Errorf("failed to read password: %v", err)
+	}
+	if string(password) != string(confirm) {
+		return "", errors.New("passwords don't match")
+	}
+	return string(password), nil
+}
+
+func userAdd(db *filedb.FileDb, a *UserAddArgs) {
+	accountType, err := filedb.ParseAccountType(a.AccountType)
+	if err != nil {
+		fmt.Printf("Invalid --type: %v\n", err)
+		return
+	}
+	name := a.Name
+	password := ""
+	if a.Generate {
+		genName, genPassword := GetRandomAccount(generatedNameLength, generatedPasswordLength)
+		if name == "" {
+			name = genName
+		}
+		password = genPassword
+	} else if !a.NoPassword {
+		password, err = readPassword()
+		if err != nil {
+			fmt.Printf("%v\n", err)
+			return
+		}
+	}
+	u, err := db.AddUser(name, accountType)
+	if err != nil {
+		fmt.Printf("Failed to add account '%s': %v\n", name, err)
+		return
+	}
+	if password != "" {
+		err = db.SetPassword(u, password)
+		if err != nil {
+			fmt.Printf("Failed to set password of '%s': %v\n", name, err)
+			// Don't leave a account that can't log in
+			db.RemoveUser(u)
+			return
+		}
+	}
+	fmt.Printf("+ Added %s account '%s' (%d)\n", u.GetAccountType(), u.GetName(), u.GetId())
+	if a.Generate {
+		fmt.Printf("  Name: %s\n  Password: %s\n  The password cannot be shown again\n", u.GetName(), password)
+	}
+}
+
+func userRemove(db *filedb.FileDb, a *UserRemoveArgs) {
+	u, err := db.GetUserByName(a.Name)
+	if err != nil {
+		fmt.Printf("Failed to get account '%s': %v\n", a.Name, err)
+		return
+	}
+	err = db.RemoveUser(u)
+	if err != nil {
+		fmt.Printf("Failed to remove account '%s': %v\n", a.Name, err)
+		return
+	}
+	fmt.Printf("- Removed account '%s'\n", a.Name)
+}
+
+func userPasswd(db *filedb.FileDb, a *UserPasswdArgs) {
+	u, err := db.GetUserByName(a.Name)
+	if err != nil {
+		fmt.Printf("Failed to get account '%s': %v\n", a.Name, err)
+		return
+	}
+	password := ""
+	if a.Generate {
+		_, password = GetRandomAccount(0, generatedPasswordLength)
+	} else {
+		password, err = readPassword()
+		if err != nil {
+			fmt.Printf("%v\n", err)
+			return
+		}
+	}
+	err = db.SetPassword(u, password)
+	if err != nil {
+		fmt.Printf("Failed to set password of '%s': %v\n", a.Name, err)
+		return
+	}
+	fmt.Printf("Set password of '%s'\n", a.Name)
+	if a.Generate {
+		fmt.Printf("  Password: %s\n  The password cannot be shown again\n", password)
+	}
+}
+
+func userList(db *filedb.FileDb) {
+	users, err := db.GetAllUsers()
+	if err != nil {
+		fmt.Printf("Failed to get accounts: %v\n", err)
+		return
+	}
+	for _, u := range users {
+		login := "no password"
+		if has, err := db.HasPassword(u); err == nil && has {
+			login = "password"
+		}
+		fmt.Printf("%d: %s (%s, %s) Created %s\n", u.GetId(), u.GetName(), u.GetAccountType(), login, u.GetCreated().Format("2006-01-02 15:04:05"))
+	}
+	if len(users) == 0 {
+		fmt.Printf("No accounts\n")
+	}
+}
+
+func userSetPermission(db *filedb.FileDb, a *UserSetPermissionArgs) {
+	accountType, err := filedb.ParseAccountType(a.AccountType)
+	if err != nil {
+		fmt.Printf("Invalid account type: %v\n", err)
+		return
+	}
+	u, err := db.GetUserByName(a.Name)
+	if err != nil {
+		fmt.Printf("Failed to get account '%s': %v\n", a.Name, err)
+		return
+	}
+	u.SetAccountType(accountType)
+	err = db.UpdateUser(u)
+	if err != nil {
+		fmt.Printf("Failed to update account '%s': %v\n", a.Name, err)
+		return
+	}
+	fmt.Printf("Set account type of '%s' to %s\n", a.Name, accountType)
+}
+
+// Parse and execute user
+func ParseUser(a *ArgList, p *arg.Parser) {
+	u := a.User
+	dbPath := ""
+	switch {
+	case u.Add != nil:
+		if u.Add.Name == "" && !u.Add.Generate {
+			p.FailSubcommand("a name must be provided unless --generate is used", "user", "add")
+			return
+		}
+		if u.Add.Generate && u.Add.NoPassword {
+			p.FailSubcommand("--generate and --nopassword cannot be used together", "user", "add")
+			return
+		}
+		dbPath = u.Add.DatabasePath
+	case u.Remove != nil:
+		dbPath = u.Remove.DatabasePath
+	case u.Passwd != nil:
+		dbPath = u.Passwd.DatabasePath
+	case u.List != nil:
+		dbPath = u.List.DatabasePath
+	case u.SetPermission != nil:
+		dbPath = u.SetPermission.DatabasePath
+	default:
+		p.FailSubcommand("A user subcommand must be selected", "user")
+		return
+	}
+	db, err := filedb.NewFileDb(dbPath)
+	if err != nil {
+		fmt.Printf("Failed to create file database: %v\n", err)
+		return
+	}
+	defer db.Close()
+	switch {
+	case u.Add != nil:
+		userAdd(db, u.Add)
+	case u.Remove != nil:
+		userRemove(db, u.Remove)
+	case u.Passwd != nil:
+		userPasswd(db, u.Passwd)
+	case u.List != nil:
+		userList(db)
+	case u.SetPermission != nil:
+		userSetPermission(db, u.SetPermission)
+	}
+}
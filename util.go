@@ -1,27 +1,31 @@
 package main
 
 import (
+	"crypto/rand"
 	"fmt"
 	"io"
-	"log/slog"
-	"math/rand"
+	"math/big"
 	"os"
-	"path/filepath"
-	"slices"
 )
 
 const chars = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
 
+// Random string of chars, this uses crypto/rand since it is used for passwords
+func randomChars(n int) string {
+	buf := make([]byte, n)
+	max := big.NewInt(int64(len(chars)))
+	for i := range buf {
+		c, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			panic(fmt.Sprintf("MediaManager: randomChars: Failed to read random number: %v", err))
+		}
+		buf[i] = chars[c.Int64()]
+	}
+	return string(buf)
+}
+
 func GetRandomAccount(accountLen, passwordLen int) (username string, password string) {
-	user := make([]byte, accountLen)
-	pass := make([]byte, passwordLen)
-	for i := range user {
-		user[i] = chars[rand.Intn(len(chars))]
-	}
-	for i := range pass {
-		pass[i] = chars[rand.Intn(len(chars))]
-	}
-	return string(user), string(pass)
+	return randomChars(accountLen), randomChars(passwordLen)
 }
 
 func copyFile(src string, dst string) error {
@@ -56,23 +60,3 @@ func bytesToString(b float64) string {
 	}
 	return fmt.Sprintf("%.0f", b)
 }
-
-func isImportableFile(path string, extraExt ...string) bool {
-	ext := filepath.Ext(path)
-	if len(ext) == 0 || ext[0] != '.' {
-		slog.Error("Invalid file, first value was not a dot", "Path", path, "Ext", ext)
-	}
-	switch ext {
-	// Images
-	case ".jpg", ".png", ".jpeg", ".gif":
-		return true
-	// Audio
-	case ".mp3", ".flac", ".wav":
-		return true
-	// Videos
-	case ".webm", ".mp4", ".mov", ".m4v":
-		return true
-	default:
-		return slices.Contains(extraExt, ext)
-	}
-}
@@ -0,0 +1,378 @@
+// Walks directory trees for import, skipping files & directories by glob patterns, .mmignore files & size. Symbolic links can be
+// followed, skipped or only followed to files. Errors reading the trees are returned instead of stopping the walk.
+//
+// Patterns are like .gitignore patterns, one without a '/' matches the name of a file or directory anywhere in the tree
+// (@eaDir, *.part), otherwise it matches the path from the root or the directory of the .mmignore file (tv/*/Sample).
+// '*' matches inside a path element, '**' across them & '?' one character, a trailing '/' only matches directories.
+package walk
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Name of the files with patterns to skip in a directory & the directories under it
+const IgnoreFile = ".mmignore"
+
+// A directory was already walked, a link loops back to a directory it's in or another link points to the same directory
+var ErrLoop = errors.New("directory already walked, symbolic link loop")
+
+// How symbolic links are walked
+type SymlinkPolicy string
+
+const (
+	SymlinkFiles  SymlinkPolicy = "files"  // Links to files are walked, links to directories are skipped, like filepath.WalkDir
+	SymlinkFollow SymlinkPolicy = "follow" // Links to directories are walked too, loops are reported as ErrLoop
+	SymlinkSkip   SymlinkPolicy = "skip"   // Every link is skipped
+)
+
+// A size in bytes, can be parsed from text with a B, KB, MB, GB or TB (1024 based) unit
+type Size int64
+
+func (s *Size) UnmarshalText(b []byte) error {
+	v, err := ParseSize(string(b))
+	if err != nil {
+		return err
+	}
+	*s = Size(v)
+	return nil
+}
+
+// Parse a size like '10MB' or '500', units are 1024 based
+func ParseSize(s string) (int64, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	mul := int64(1)
+	for _, u := range []struct {
+		suffix string
+		mul    int64
+	}{{"TB", 1 << 40}, {"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}, {"B", 1}} {
+		if strings.HasSuffix(s, u.suffix) {
+			s, mul = strings.TrimSpace(strings.TrimSuffix(s, u.suffix)), u.mul
+			break
+		}
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil || v < 0 {
+		return 0, fmt.Errorf("invalid size '%s'", s)
+	}
+	return int64(v * float64(mul)), nil
+}
+
+type Opts struct {
+	Include  []string               // Files must match one of these patterns, if there are any. Directories are always walked
+	Exclude  []string               // Files & directories to skip
+	MinSize  int64                  // Smallest file, 0 for no limit
+	MaxSize  int64                  // Largest file, 0 for no limit
+	Symlinks SymlinkPolicy          // Default: SymlinkFiles
+	NoIgnore bool                   // Don't read IgnoreFile files
+	Filter   func(path string) bool // Files to walk, like importable files. Checked before the size so files it rejects aren't read or counted as skipped
+}
+
+// A path that couldn't be read
+type Error struct {
+	Path string
+	Err  error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("'%s': %v", e.Path, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+type Result struct {
+	Errors  []*Error // Paths that couldn't be read
+	Skipped int      // Files & directories skipped by a pattern, size or link
+}
+
+// Add the errors & skipped count of another walk
+func (r *Result) Merge(o *Result) {
+	r.Errors = append(r.Errors, o.Errors...)
+	r.Skipped += o.Skipped
+}
+
+type pattern struct {
+	re      *regexp.Regexp
+	dirOnly bool
+	base    string // Directory a pattern with a '/' is relative to
+	anyName bool   // No '/', matches the name anywhere
+}
+
+// Convert a pattern to a regular expression matching the whole name or relative path
+func compilePattern(p, base string) (*pattern, error) {
+	pt := &pattern{base: base}
+	if strings.HasSuffix(p, "/") {
+		pt.dirOnly = true
+		p = strings.TrimRight(p, "/")
+	}
+	if p == "" {
+		return nil, errors.New("empty pattern")
+	}
+	pt.anyName = !strings.Contains(p, "/")
+	p = strings.TrimPrefix(p, "/")
+	b := strings.Builder{}
+	b.WriteString("^")
+	for i := 0; i < len(p); i++ {
+		switch c := p[i]; c {
+		case '*':
+			if strings.HasPrefix(p[i:], "**/") {
+				b.WriteString("(.*/)?")
+				i += 2
+			} else if strings.HasPrefix(p[i:], "**") {
+				b.WriteString(".*")
+				i++
+			} else {
+				b.WriteString("[^/]*")
+			}
+		case '?':
+			b.WriteString("[^/]")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("$")
+	re, err := regexp.Compile(b.String())
+	if err != nil {
+		return nil, err
+	}
+	pt.re = re
+	return pt, nil
+}
+
+func (p *pattern) matches(path string, isDir bool) bool {
+	if p.dirOnly && !isDir {
+		return false
+	}
+	if p.anyName {
+		return p.re.MatchString(filepath.Base(path))
+	}
+	rel, err := filepath.Rel(p.base, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return false
+	}
+	return p.re.MatchString(filepath.ToSlash(rel))
+}
+
+func compilePatterns(list []string, base string) ([]*pattern, error) {
+	out := make([]*pattern, 0, len(list))
+	for _, v := range list {
+		p, err := compilePattern(v, base)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern '%s': %v", v, err)
+		}
+		out = append(out, p)
+	}
+	return out, nil
+}
+
+// Read the patterns of a ignore file in dir, nil if there isn't one. Empty lines & lines starting with '#' are ignored
+func readIgnoreFile(dir string) ([]*pattern, error) {
+	f, err := os.Open(filepath.Join(dir, IgnoreFile))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	lines := make([]string, 0)
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		l := strings.TrimSpace(s.Text())
+		if l != "" && !strings.HasPrefix(l, "#") {
+			lines = append(lines, l)
+		}
+	}
+	if err = s.Err(); err != nil {
+		return nil, err
+	}
+	return compilePatterns(lines, dir)
+}
+
+type walker struct {
+	opts    *Opts
+	include []*pattern
+	fn      func(path string, info fs.FileInfo)
+	visited map[string]bool // Real paths of walked directories
+	res     *Result
+}
+
+func anyMatches(patterns []*pattern, path string, isDir bool) bool {
+	for _, p := range patterns {
+		if p.matches(path, isDir) {
+			return true
+		}
+	}
+	return false
+}
+
+func (w *walker) addError(path string, err error) {
+	w.res.Errors = append(w.res.Errors, &Error{Path: path, Err: err})
+}
+
+func (w *walker) walkDir(dir string, exclude []*pattern) {
+	real, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		w.addError(dir, err)
+		return
+	}
+	if w.visited[real] {
+		w.addError(dir, ErrLoop)
+		return
+	}
+	w.visited[real] = true
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		w.addError(dir, err)
+		// Entries read before the error are still walked
+	}
+	if !w.opts.NoIgnore {
+		ignore, err := readIgnoreFile(dir)
+		if err != nil {
+			w.addError(filepath.Join(dir, IgnoreFile), err)
+		}
+		if len(ignore) != 0 {
+			// Don't change the slice the parent directory is using
+			exclude = append(append(make([]*pattern, 0, len(exclude)+len(ignore)), exclude...), ignore...)
+		}
+	}
+	for _, e := range entries {
+		path := filepath.Join(dir, e.Name())
+		var info fs.FileInfo
+		if e.Type()&fs.ModeSymlink != 0 {
+			if w.opts.Symlinks == SymlinkSkip {
+				w.res.Skipped++
+				continue
+			}
+			info, err = os.Stat(path)
+			if err != nil {
+				w.addError(path, err)
+				continue
+			}
+			if info.IsDir() && w.opts.Symlinks != SymlinkFollow {
+				w.res.Skipped++
+				continue
+			}
+		} else {
+			info, err = e.Info()
+			if err != nil {
+				w.addError(path, err)
+				continue
+			}
+		}
+		if info.IsDir() {
+			if anyMatches(exclude, path, true) {
+				w.res.Skipped++
+				continue
+			}
+			w.walkDir(path, exclude)
+			continue
+		}
+		if !info.Mode().IsRegular() || (w.opts.Filter != nil && !w.opts.Filter(path)) {
+			continue
+		}
+		if anyMatches(exclude, path, false) || (len(w.include) != 0 && !anyMatches(w.include, path, false)) ||
+			(w.opts.MinSize > 0 && info.Size() < w.opts.MinSize) || (w.opts.MaxSize > 0 && info.Size() > w.opts.MaxSize) {
+			w.res.Skipped++
+			continue
+		}
+		w.fn(path, info)
+	}
+}
+
+// Check the patterns, fails if one is invalid
+func (o *Opts) Validate() error {
+	if _, err := compilePatterns(o.Include, ""); err != nil {
+		return err
+	}
+	if _, err := compilePatterns(o.Exclude, ""); err != nil {
+		return err
+	}
+	switch o.Symlinks {
+	case "", SymlinkFiles, SymlinkFollow, SymlinkSkip:
+	default:
+		return fmt.Errorf("symbolic link policy must be files, follow or skip, not '%s'", o.Symlinks)
+	}
+	if o.MaxSize > 0 && o.MinSize > o.MaxSize {
+		return errors.New("the minimum size is larger then the maximum size")
+	}
+	return nil
+}
+
+// Call fn with every file in the tree under root that isn't skipped, with the info of the file a link points to.
+// The root is always walked, even if it's a link.
+func Walk(root string, opts *Opts, fn func(path string, info fs.FileInfo)) (*Result, error) {
+	if opts == nil {
+		opts = &Opts{}
+	}
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	root = filepath.Clean(root)
+	w := &walker{
+		opts:    opts,
+		fn:      fn,
+		visited: make(map[string]bool),
+		res:     &Result{Errors: make([]*Error, 0)},
+	}
+	exclude, _ := compilePatterns(opts.Exclude, root)
+	w.include, _ = compilePatterns(opts.Include, root)
+	st, err := os.Stat(root)
+	if err != nil {
+		return nil, err
+	}
+	if !st.IsDir() {
+		return nil, fmt.Errorf("'%s' isn't a directory", root)
+	}
+	w.walkDir(root, exclude)
+	return w.res, nil
+}
+
+// Checks if a file under root would be skipped by Walk, without checking links. The ignore files of the directories between root
+// & path are read. info is only used for the size, it's skipped if info is nil
+func Skipped(root, path string, opts *Opts, info fs.FileInfo) (bool, error) {
+	root = filepath.Clean(root)
+	exclude, err := compilePatterns(opts.Exclude, root)
+	if err != nil {
+		return false, err
+	}
+	include, err := compilePatterns(opts.Include, root)
+	if err != nil {
+		return false, err
+	}
+	rel, err := filepath.Rel(root, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return false, fmt.Errorf("'%s' isn't under '%s'", path, root)
+	}
+	// Each directory from the root down
+	dir := root
+	parts := strings.Split(rel, string(filepath.Separator))
+	for i, name := range parts {
+		if !opts.NoIgnore {
+			ignore, err := readIgnoreFile(dir)
+			if err != nil {
+				return false, err
+			}
+			exclude = append(exclude, ignore...)
+		}
+		dir = filepath.Join(dir, name)
+		if anyMatches(exclude, dir, i != len(parts)-1) {
+			return true, nil
+		}
+	}
+	if len(include) != 0 && !anyMatches(include, path, false) {
+		return true, nil
+	}
+	if info != nil && ((opts.MinSize > 0 && info.Size() < opts.MinSize) || (opts.MaxSize > 0 && info.Size() > opts.MaxSize)) {
+		return true, nil
+	}
+	return false, nil
+}
@@ -0,0 +1,157 @@
+package walk
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Create files under dir, a value is the size of the file
+func makeTree(t *testing.T, dir string, files map[string]int) {
+	for p, size := range files {
+		p = filepath.Join(dir, filepath.FromSlash(p))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(p, make([]byte, size), 0644); err != nil {
+			t.Fatalf("Failed to create file: %v", err)
+		}
+	}
+}
+
+// Walk dir & get the walked paths relative to it
+func walkTree(t *testing.T, dir string, opts *Opts) ([]string, *Result) {
+	paths := make([]string, 0)
+	res, err := Walk(dir, opts, func(path string, _ fs.FileInfo) {
+		rel, _ := filepath.Rel(dir, path)
+		paths = append(paths, filepath.ToSlash(rel))
+	})
+	if !assert.NoErrorf(t, err, "Walk failed") {
+		return nil, nil
+	}
+	sort.Strings(paths)
+	return paths, res
+}
+
+func mediaOnly(path string) bool {
+	return strings.HasSuffix(path, ".mp4") || strings.HasSuffix(path, ".jpg")
+}
+
+func TestWalk(t *testing.T) {
+	dir := t.TempDir()
+	makeTree(t, dir, map[string]int{
+		"a.mp4":                      100,
+		"small.jpg":                  1,
+		"notes.txt":                  10,
+		"@eaDir/a.mp4/thumb.jpg":     10,
+		"tv/show/Sample/sample.mp4":  10,
+		"tv/show/01.mp4":             100,
+		"tv/show/01.mp4.part":        100,
+		"photos/.mmignore":           0,
+		"photos/raw/a.jpg":           10,
+		"photos/b.jpg":               10,
+		"photos/sub/c.jpg":           10,
+		"other/Sample/not-a-dir.jpg": 10,
+	})
+	os.WriteFile(filepath.Join(dir, "photos", IgnoreFile), []byte("# Comment\n\nraw/\n/sub/c.jpg\n"), 0644)
+	paths, res := walkTree(t, dir, &Opts{Filter: mediaOnly})
+	assert.Equalf(t, []string{"@eaDir/a.mp4/thumb.jpg", "a.mp4", "other/Sample/not-a-dir.jpg", "photos/b.jpg", "small.jpg",
+		"tv/show/01.mp4", "tv/show/Sample/sample.mp4"}, paths, "Wrong files with .mmignore")
+	assert.Equalf(t, 2, res.Skipped, "Wrong skipped count")
+	assert.Emptyf(t, res.Errors, "Walk had errors")
+
+	paths, res = walkTree(t, dir, &Opts{Filter: mediaOnly, Exclude: []string{"@eaDir", "tv/*/Sample/", "small.*"}})
+	assert.Equalf(t, []string{"a.mp4", "other/Sample/not-a-dir.jpg", "photos/b.jpg", "tv/show/01.mp4"}, paths, "Wrong files with --exclude")
+	assert.Equalf(t, 5, res.Skipped, "Wrong skipped count")
+
+	paths, _ = walkTree(t, dir, &Opts{Filter: mediaOnly, Include: []string{"*.mp4"}, NoIgnore: true, MinSize: 50})
+	assert.Equalf(t, []string{"a.mp4", "tv/show/01.mp4"}, paths, "Wrong files with --include & --minsize")
+	paths, _ = walkTree(t, dir, &Opts{Filter: mediaOnly, Include: []string{"photos/**"}, NoIgnore: true, MaxSize: 10})
+	assert.Equalf(t, []string{"photos/b.jpg", "photos/raw/a.jpg", "photos/sub/c.jpg"}, paths, "Wrong files with --include & --maxsize")
+
+	_, err := Walk(filepath.Join(dir, "missing"), nil, func(string, fs.FileInfo) {})
+	assert.Errorf(t, err, "Missing root was walked")
+	_, err = Walk(dir, &Opts{Exclude: []string{"/"}}, func(string, fs.FileInfo) {})
+	assert.Errorf(t, err, "Invalid pattern was used")
+	_, err = Walk(dir, &Opts{Symlinks: "sometimes"}, func(string, fs.FileInfo) {})
+	assert.Errorf(t, err, "Invalid symbolic link policy was used")
+}
+
+func TestSymlinks(t *testing.T) {
+	dir, other := t.TempDir(), t.TempDir()
+	makeTree(t, dir, map[string]int{"a/a.mp4": 10})
+	makeTree(t, other, map[string]int{"b.mp4": 10})
+	if err := os.Symlink(filepath.Join(other, "b.mp4"), filepath.Join(dir, "link.mp4")); err != nil {
+		t.Skipf("Symbolic links aren't supported: %v", err)
+	}
+	os.Symlink(other, filepath.Join(dir, "other"))
+	// Loop back to the root
+	os.Symlink(dir, filepath.Join(dir, "a", "loop"))
+	os.Symlink(filepath.Join(dir, "missing.mp4"), filepath.Join(dir, "broken.mp4"))
+
+	paths, res := walkTree(t, dir, &Opts{})
+	assert.Equalf(t, []string{"a/a.mp4", "link.mp4"}, paths, "Wrong files with links to files")
+	assert.Equalf(t, 2, res.Skipped, "Links to directories weren't skipped")
+	if assert.Lenf(t, res.Errors, 1, "Broken link wasn't reported") {
+		assert.Equalf(t, filepath.Join(dir, "broken.mp4"), res.Errors[0].Path, "Wrong error path")
+	}
+
+	paths, res = walkTree(t, dir, &Opts{Symlinks: SymlinkFollow})
+	assert.Equalf(t, []string{"a/a.mp4", "link.mp4", "other/b.mp4"}, paths, "Wrong files following links")
+	loops := 0
+	for _, e := range res.Errors {
+		if errors.Is(e, ErrLoop) {
+			loops++
+		}
+	}
+	assert.Equalf(t, 1, loops, "Loop wasn't reported")
+
+	paths, res = walkTree(t, dir, &Opts{Symlinks: SymlinkSkip})
+	assert.Equalf(t, []string{"a/a.mp4"}, paths, "Links weren't skipped")
+	assert.Equalf(t, 4, res.Skipped, "Wrong skipped count")
+	assert.Emptyf(t, res.Errors, "Skipped links had errors")
+}
+
+func TestSkipped(t *testing.T) {
+	dir := t.TempDir()
+	makeTree(t, dir, map[string]int{"tv/.mmignore": 0, "tv/show/01.mp4": 10, "tv/show/Sample/a.mp4": 10, "a.mp4": 10})
+	os.WriteFile(filepath.Join(dir, "tv", IgnoreFile), []byte("Sample/\n"), 0644)
+	opts := &Opts{Exclude: []string{"*.part"}, MinSize: 5}
+	for path, expected := range map[string]bool{
+		"tv/show/01.mp4":         false,
+		"tv/show/Sample/a.mp4":   true,
+		"a.mp4":                  false,
+		"tv/show/01.mp4.part":    true,
+		"tv/show/Sample.mp4":     false,
+		"tv/show/Sample/b/c.mp4": true,
+	} {
+		skip, err := Skipped(dir, filepath.Join(dir, filepath.FromSlash(path)), opts, nil)
+		if assert.NoErrorf(t, err, "Skipped failed") {
+			assert.Equalf(t, expected, skip, "Wrong result for '%s'", path)
+		}
+	}
+	info, _ := os.Stat(filepath.Join(dir, "a.mp4"))
+	skip, _ := Skipped(dir, filepath.Join(dir, "a.mp4"), &Opts{MinSize: 20}, info)
+	assert.Truef(t, skip, "Small file wasn't skipped")
+	_, err := Skipped(dir, "/elsewhere/a.mp4", opts, nil)
+	assert.Errorf(t, err, "Path outside the root was checked")
+}
+
+func TestParseSize(t *testing.T) {
+	for s, expected := range map[string]int64{"500": 500, "10B": 10, "1kb": 1024, "1.5MB": 3 << 19, "2 GB": 2 << 30, "1TB": 1 << 40} {
+		v, err := ParseSize(s)
+		if assert.NoErrorf(t, err, "ParseSize failed") {
+			assert.Equalf(t, expected, v, "Wrong size for '%s'", s)
+		}
+	}
+	for _, s := range []string{"", "MB", "-1", "ten"} {
+		_, err := ParseSize(s)
+		assert.Errorf(t, err, "Invalid size '%s' was parsed", s)
+	}
+}
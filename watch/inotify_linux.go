@@ -0,0 +1,146 @@
+//go:build linux
+// +build linux
+
+package watch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+const inotifyMask = syscall.IN_CREATE | syscall.IN_CLOSE_WRITE | syscall.IN_MOVED_TO | syscall.IN_MOVED_FROM | syscall.IN_DELETE | syscall.IN_DELETE_SELF
+
+// Finds changes with inotify, every directory in the trees is watched
+type inotify struct {
+	fd      int
+	file    *os.File // Closing it stops run
+	roots   []string
+	watches map[int32]string // Watch descriptor to directory
+}
+
+func newInotify(roots []string) (*inotify, error) {
+	// Non blocking so reads go through the runtime poller & closing the file stops them
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create inotify instance: %v", err)
+	}
+	in := &inotify{
+		fd:      fd,
+		file:    os.NewFile(uintptr(fd), "inotify"),
+		roots:   roots,
+		watches: make(map[int32]string),
+	}
+	for _, r := range roots {
+		if err = in.addTree(r); err != nil {
+			in.close()
+			return nil, err
+		}
+	}
+	return in, nil
+}
+
+// Watch dir & every directory under it
+func (in *inotify) addTree(dir string) error {
+	return filepath.WalkDir(dir, func(p string, e fs.DirEntry, err error) error {
+		if err != nil {
+			if p == dir {
+				return err
+			}
+			slog.Warn("Failed to walk directory", "Path", p, "Error", err.Error())
+			return nil
+		}
+		if !e.IsDir() {
+			return nil
+		}
+		wd, err := syscall.InotifyAddWatch(in.fd, p, inotifyMask)
+		if err != nil {
+			// Usually fs.inotify.max_user_watches is too low
+			return fmt.Errorf("failed to watch '%s': %v", p, err)
+		}
+		in.watches[int32(wd)] = p
+		return nil
+	})
+}
+
+// Stop watching dir & every directory under it, after it was moved away
+func (in *inotify) removeTree(dir string) {
+	prefix := dir + string(filepath.Separator)
+	for wd, p := range in.watches {
+		if p == dir || strings.HasPrefix(p, prefix) {
+			syscall.InotifyRmWatch(in.fd, uint32(wd))
+			delete(in.watches, wd)
+		}
+	}
+}
+
+// Read events & send the paths they happened to
+func (in *inotify) run(ctx context.Context, changes chan<- string) error {
+	go func() {
+		<-ctx.Done()
+		in.close()
+	}()
+	buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+	for {
+		n, err := in.file.Read(buf)
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, os.ErrClosed) {
+				return nil
+			}
+			return fmt.Errorf("failed to read inotify events: %v", err)
+		}
+		paths := make([]string, 0)
+		for off := 0; off+syscall.SizeofInotifyEvent <= n; {
+			ev := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[off]))
+			name := strings.TrimRight(string(buf[off+syscall.SizeofInotifyEvent:off+syscall.SizeofInotifyEvent+int(ev.Len)]), "\x00")
+			off += syscall.SizeofInotifyEvent + int(ev.Len)
+			if ev.Mask&syscall.IN_Q_OVERFLOW != 0 {
+				// Events were lost, everything is scanned
+				slog.Warn("inotify queue overflowed, scanning every directory")
+				paths = append(paths, in.roots...)
+				continue
+			}
+			dir, found := in.watches[ev.Wd]
+			if !found {
+				continue
+			}
+			if ev.Mask&syscall.IN_IGNORED != 0 {
+				delete(in.watches, ev.Wd)
+				continue
+			}
+			p := dir
+			if name != "" {
+				p = filepath.Join(dir, name)
+			}
+			if ev.Mask&syscall.IN_ISDIR != 0 {
+				if ev.Mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0 {
+					// Files created before the watch was added are found by the scan of p
+					if err = in.addTree(p); err != nil {
+						slog.Warn("Failed to watch new directory", "Path", p, "Error", err.Error())
+					}
+				} else if ev.Mask&syscall.IN_MOVED_FROM != 0 {
+					in.removeTree(p)
+				}
+			}
+			paths = append(paths, p)
+		}
+		for _, p := range paths {
+			select {
+			case changes <- p:
+			case <-ctx.Done():
+				return nil
+			}
+		}
+	}
+}
+
+func (in *inotify) close() {
+	in.file.Close()
+}
@@ -0,0 +1,22 @@
+//go:build !linux
+// +build !linux
+
+package watch
+
+import (
+	"context"
+	"errors"
+)
+
+// inotify is Linux only, other systems poll
+type inotify struct{}
+
+func newInotify(roots []string) (*inotify, error) {
+	return nil, errors.ErrUnsupported
+}
+
+func (in *inotify) run(ctx context.Context, changes chan<- string) error {
+	return errors.ErrUnsupported
+}
+
+func (in *inotify) close() {}
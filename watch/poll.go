@@ -0,0 +1,76 @@
+package watch
+
+import (
+	"context"
+	"io/fs"
+	"path/filepath"
+	"time"
+)
+
+type pollState struct {
+	size int64
+	mod  time.Time
+}
+
+// Finds changes by scanning the trees, used when inotify can't be
+type poller struct {
+	roots    []string
+	interval time.Duration
+	filter   func(path string) bool
+	files    map[string]pollState // Files found by the last scan
+}
+
+func newPoller(roots []string, interval time.Duration, filter func(path string) bool) *poller {
+	p := &poller{roots: roots, interval: interval, filter: filter}
+	p.files = p.scan()
+	return p
+}
+
+func (p *poller) scan() map[string]pollState {
+	files := make(map[string]pollState)
+	for _, r := range p.roots {
+		filepath.WalkDir(r, func(path string, e fs.DirEntry, err error) error {
+			if err != nil || !e.Type().IsRegular() || !p.filter(path) {
+				return nil
+			}
+			if info, err := e.Info(); err == nil {
+				files[path] = pollState{size: info.Size(), mod: info.ModTime()}
+			}
+			return nil
+		})
+	}
+	return files
+}
+
+// Scan every interval & send the paths of new, changed & removed files
+func (p *poller) run(ctx context.Context, changes chan<- string) error {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+		files := p.scan()
+		changed := make([]string, 0)
+		for path, st := range files {
+			if old, found := p.files[path]; !found || old.size != st.size || !old.mod.Equal(st.mod) {
+				changed = append(changed, path)
+			}
+		}
+		for path := range p.files {
+			if _, found := files[path]; !found {
+				changed = append(changed, path)
+			}
+		}
+		p.files = files
+		for _, path := range changed {
+			select {
+			case changes <- path:
+			case <-ctx.Done():
+				return nil
+			}
+		}
+	}
+}
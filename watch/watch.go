@@ -0,0 +1,265 @@
+// Watches directory trees for new & removed files, with inotify on Linux & by scanning the trees every so often on other systems.
+//
+// New files are only reported once their size & modification time stop changing, so files that are still being written (Like downloads)
+// aren't reported until they're done.
+package watch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Time a new file must stay the same before it's reported, when Opts.Settle isn't set
+const DefaultSettle = 5 * time.Second
+
+// Time between scans when polling, when Opts.Interval isn't set
+const DefaultInterval = 30 * time.Second
+
+type Op int
+
+const (
+	Created Op = iota // A new file stopped changing
+	Removed           // A file was removed or moved away
+)
+
+func (o Op) String() string {
+	switch o {
+	case Created:
+		return "Created"
+	case Removed:
+		return "Removed"
+	default:
+		return fmt.Sprintf("Op(%d)", int(o))
+	}
+}
+
+type Event struct {
+	Path string
+	Op   Op
+}
+
+type Opts struct {
+	Handler  func(events []Event)   // Called with each batch of events on the goroutine running Run, required
+	Filter   func(path string) bool // Files to watch, nil watches every file
+	Settle   time.Duration          // Time a new file must keep the same size & modification time before it's reported, DefaultSettle if 0
+	Interval time.Duration          // Time between scans when polling, DefaultInterval if 0
+	Poll     bool                   // Scan the trees even if inotify can be used
+}
+
+// A file that changed recently
+type pendingFile struct {
+	size  int64
+	mod   time.Time
+	since time.Time // Last time the size or modification time changed
+}
+
+type Watcher struct {
+	roots   []string
+	opts    Opts
+	known   map[string]bool // Files that have been seen
+	pending map[string]*pendingFile
+	events  []Event // Waiting for the next flush
+	polling atomic.Bool
+}
+
+// Create a watcher for the directory trees under roots, nothing is watched until Run is called.
+// Paths are reported under the roots as they're given, so a relative root gives relative paths
+func New(opts *Opts, roots ...string) (*Watcher, error) {
+	if opts == nil || opts.Handler == nil {
+		return nil, errors.New("a handler is required")
+	}
+	if len(roots) == 0 {
+		return nil, errors.New("at least one root is required")
+	}
+	w := &Watcher{
+		roots:   make([]string, 0, len(roots)),
+		opts:    *opts,
+		known:   make(map[string]bool),
+		pending: make(map[string]*pendingFile),
+		events:  make([]Event, 0),
+	}
+	if w.opts.Settle <= 0 {
+		w.opts.Settle = DefaultSettle
+	}
+	if w.opts.Interval <= 0 {
+		w.opts.Interval = DefaultInterval
+	}
+	for _, r := range roots {
+		r = filepath.Clean(r)
+		st, err := os.Stat(r)
+		if err != nil {
+			return nil, fmt.Errorf("failed to stat '%s': %v", r, err)
+		}
+		if !st.IsDir() {
+			return nil, fmt.Errorf("'%s' isn't a directory", r)
+		}
+		w.roots = append(w.roots, r)
+	}
+	return w, nil
+}
+
+// The trees are scanned instead of using inotify, only set once Run has started
+func (w *Watcher) IsPolling() bool {
+	return w.polling.Load()
+}
+
+// Watch until ctx is done, files that exist when this is called aren't reported.
+//
+// Returns nil once ctx is done, or a error if the trees can't be watched anymore.
+func (w *Watcher) Run(ctx context.Context) error {
+	ctx, can := context.WithCancel(ctx)
+	defer can()
+	changes := make(chan string, 256)
+	errs := make(chan error, 1)
+	var run func(ctx context.Context, changes chan<- string) error
+	if !w.opts.Poll {
+		// Watches are added before the first scan, so files created in between aren't lost
+		in, err := newInotify(w.roots)
+		if err == nil {
+			defer in.close()
+			run = in.run
+		} else if !errors.Is(err, errors.ErrUnsupported) {
+			slog.Warn("Failed to use inotify, polling instead", "Error", err.Error())
+		}
+	}
+	w.polling.Store(run == nil)
+	for _, r := range w.roots {
+		filepath.WalkDir(r, func(p string, e fs.DirEntry, err error) error {
+			if err == nil && e.Type().IsRegular() && w.isWatched(p) {
+				w.known[p] = true
+			}
+			return nil
+		})
+	}
+	if run == nil {
+		p := newPoller(w.roots, w.opts.Interval, w.isWatched)
+		run = p.run
+	}
+	go func() {
+		errs <- run(ctx, changes)
+	}()
+	// Pending files are checked a few times per settle time
+	ticker := time.NewTicker(max(w.opts.Settle/4, 10*time.Millisecond))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-errs:
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		case p := <-changes:
+			w.changed(p, time.Now())
+		case now := <-ticker.C:
+			w.checkPending(now)
+			if len(w.events) != 0 {
+				events := w.events
+				w.events = make([]Event, 0)
+				w.opts.Handler(events)
+			}
+		}
+	}
+}
+
+func (w *Watcher) isWatched(path string) bool {
+	return w.opts.Filter == nil || w.opts.Filter(path)
+}
+
+// Known files that are path or under it
+func (w *Watcher) knownUnder(path string) []string {
+	under := make([]string, 0)
+	prefix := path + string(filepath.Separator)
+	for k := range w.known {
+		if k == path || strings.HasPrefix(k, prefix) {
+			under = append(under, k)
+		}
+	}
+	return under
+}
+
+func (w *Watcher) removed(path string) {
+	w.events = append(w.events, Event{Path: path, Op: Removed})
+	delete(w.known, path)
+	delete(w.pending, path)
+}
+
+// Something happened at path, it's checked once it settles. Directories are scanned.
+func (w *Watcher) changed(path string, now time.Time) {
+	st, err := os.Stat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		for _, k := range w.knownUnder(path) {
+			w.removed(k)
+		}
+		delete(w.pending, path)
+		return
+	} else if err != nil {
+		slog.Warn("Failed to stat changed file", "Path", path, "Error", err.Error())
+		return
+	}
+	if !st.IsDir() {
+		if st.Mode().IsRegular() && w.isWatched(path) {
+			w.updatePending(path, st, now)
+		}
+		return
+	}
+	seen := make(map[string]bool)
+	filepath.WalkDir(path, func(p string, e fs.DirEntry, err error) error {
+		if err != nil || !e.Type().IsRegular() || !w.isWatched(p) {
+			return nil
+		}
+		seen[p] = true
+		if info, err := e.Info(); err == nil {
+			w.updatePending(p, info, now)
+		}
+		return nil
+	})
+	for _, k := range w.knownUnder(path) {
+		if !seen[k] {
+			w.removed(k)
+		}
+	}
+}
+
+func (w *Watcher) updatePending(path string, st fs.FileInfo, now time.Time) {
+	p := w.pending[path]
+	if p == nil || p.size != st.Size() || !p.mod.Equal(st.ModTime()) {
+		w.pending[path] = &pendingFile{size: st.Size(), mod: st.ModTime(), since: now}
+	}
+}
+
+// Report pending files that haven't changed for the settle time
+func (w *Watcher) checkPending(now time.Time) {
+	for path, p := range w.pending {
+		st, err := os.Stat(path)
+		if err != nil {
+			if w.known[path] {
+				w.removed(path)
+			}
+			delete(w.pending, path)
+			continue
+		}
+		if p.size != st.Size() || !p.mod.Equal(st.ModTime()) {
+			p.size, p.mod, p.since = st.Size(), st.ModTime(), now
+			continue
+		}
+		if now.Sub(p.since) < w.opts.Settle {
+			continue
+		}
+		delete(w.pending, path)
+		// Changes to files that were already seen aren't reported
+		if !w.known[path] {
+			w.known[path] = true
+			w.events = append(w.events, Event{Path: path, Op: Created})
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package watch
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Start a watcher on dir, events are sent to the returned channel
+func startTestWatcher(t *testing.T, dir string, poll bool) (<-chan Event, *Watcher) {
+	events := make(chan Event, 100)
+	w, err := New(&Opts{
+		Handler: func(e []Event) {
+			for _, v := range e {
+				events <- v
+			}
+		},
+		Filter: func(path string) bool {
+			return strings.HasSuffix(path, ".mp4")
+		},
+		Settle:   200 * time.Millisecond,
+		Interval: 50 * time.Millisecond,
+		Poll:     poll,
+	}, dir)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	ctx, can := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- w.Run(ctx)
+	}()
+	t.Cleanup(func() {
+		can()
+		assert.NoErrorf(t, <-done, "Run failed")
+	})
+	// Let it start
+	time.Sleep(100 * time.Millisecond)
+	return events, w
+}
+
+// Wait for the next event, fails the test after a few seconds
+func nextEvent(t *testing.T, events <-chan Event) Event {
+	select {
+	case e := <-events:
+		return e
+	case <-time.After(5 * time.Second):
+		t.Fatalf("No event was received")
+		return Event{}
+	}
+}
+
+func writeTestFile(t *testing.T, path, data string) {
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+}
+
+func testWatcher(t *testing.T, poll bool) {
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "old.mp4"), "old")
+	events, w := startTestWatcher(t, dir, poll)
+	if !poll {
+		assert.Falsef(t, w.IsPolling(), "inotify wasn't used")
+	}
+	// Still being written
+	p := filepath.Join(dir, "new.mp4")
+	f, err := os.Create(p)
+	if err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	start := time.Now()
+	for range 5 {
+		f.Write([]byte("part"))
+		time.Sleep(100 * time.Millisecond)
+	}
+	f.Close()
+	writeTestFile(t, filepath.Join(dir, "ignored.txt"), "ignored")
+	e := nextEvent(t, events)
+	assert.Equalf(t, Event{Path: p, Op: Created}, e, "Wrong event for new file")
+	assert.GreaterOrEqualf(t, time.Since(start), 600*time.Millisecond, "File was reported while it was being written")
+	// New directories are watched
+	sub := filepath.Join(dir, "sub")
+	if err = os.Mkdir(sub, 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	writeTestFile(t, filepath.Join(sub, "a.mp4"), "a")
+	assert.Equalf(t, Event{Path: filepath.Join(sub, "a.mp4"), Op: Created}, nextEvent(t, events), "Wrong event for file in new directory")
+	// Removed files, including ones that existed before Run
+	os.Remove(filepath.Join(dir, "old.mp4"))
+	assert.Equalf(t, Event{Path: filepath.Join(dir, "old.mp4"), Op: Removed}, nextEvent(t, events), "Wrong event for removed file")
+	os.RemoveAll(sub)
+	assert.Equalf(t, Event{Path: filepath.Join(sub, "a.mp4"), Op: Removed}, nextEvent(t, events), "Wrong event for removed directory")
+	// Moved in
+	other := filepath.Join(t.TempDir(), "moved.mp4")
+	writeTestFile(t, other, "moved")
+	if err = os.Rename(other, filepath.Join(dir, "moved.mp4")); err != nil {
+		t.Skipf("Can't move between temporary directories: %v", err)
+	}
+	assert.Equalf(t, Event{Path: filepath.Join(dir, "moved.mp4"), Op: Created}, nextEvent(t, events), "Wrong event for moved file")
+	select {
+	case e = <-events:
+		t.Errorf("Unexpected event %s %s", e.Op, e.Path)
+	case <-time.After(300 * time.Millisecond):
+	}
+}
+
+func TestWatcher(t *testing.T) {
+	testWatcher(t, false)
+}
+
+func TestWatcherPoll(t *testing.T) {
+	testWatcher(t, true)
+}
+
+func TestNew(t *testing.T) {
+	dir := t.TempDir()
+	handler := func([]Event) {}
+	_, err := New(&Opts{Handler: handler}, filepath.Join(dir, "missing"))
+	assert.Errorf(t, err, "Missing root was used")
+	writeTestFile(t, filepath.Join(dir, "a.mp4"), "a")
+	_, err = New(&Opts{Handler: handler}, filepath.Join(dir, "a.mp4"))
+	assert.Errorf(t, err, "File was used as root")
+	_, err = New(&Opts{}, dir)
+	assert.Errorf(t, err, "Watcher without a handler was created")
+	_, err = New(&Opts{Handler: handler})
+	assert.Errorf(t, err, "Watcher without roots was created")
+	w, err := New(&Opts{Handler: handler}, dir)
+	if assert.NoErrorf(t, err, "New failed") {
+		assert.Equalf(t, DefaultSettle, w.opts.Settle, "Default settle time wasn't used")
+	}
+}
+
+func TestRelativeRoot(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Failed to get working directory: %v", err)
+	}
+	if err = os.Chdir(t.TempDir()); err != nil {
+		t.Fatalf("Failed to change working directory: %v", err)
+	}
+	defer os.Chdir(wd)
+	os.Mkdir("tv", 0755)
+	writeTestFile(t, filepath.Join("tv", "old.mp4"), "old")
+	// Paths are reported like an import of the same directory stores them
+	events, _ := startTestWatcher(t, "./tv", false)
+	writeTestFile(t, filepath.Join("tv", "a.mp4"), "a")
+	assert.Equalf(t, Event{Path: filepath.Join("tv", "a.mp4"), Op: Created}, nextEvent(t, events), "Wrong event for new file")
+	os.Remove(filepath.Join("tv", "old.mp4"))
+	assert.Equalf(t, Event{Path: filepath.Join("tv", "old.mp4"), Op: Removed}, nextEvent(t, events), "Wrong event for removed file")
+}
@@ -1,16 +1,22 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"log/slog"
 	"mediamanager/filedb"
+	"mediamanager/mediatype"
+	"mediamanager/tagrules"
+	"mediamanager/thumbnail"
 	"mediamanager/web1"
+	"mediamanager/web2"
 	"net"
 	"net/http"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/alexflint/go-arg"
 )
@@ -18,13 +24,27 @@ import (
 // Web arguments
 type WebArgs struct {
 	DatabasePath string `arg:"positional,required" help:"Path to the database."`
-	ApiVersion   int    `arg:"--apiversion" help:"Api version to use. Version 1 is stable, 2 is experimental" default:"1"`
+	ApiVersion   int    `arg:"--apiversion" help:"Api version to use. Version 1 is stable, 2 is experimental and adds /api/2/ which uses API keys (See database --addkey)" default:"1"`
 	Address      string `arg:"-a,--address" help:"Address to host on as IP:PORT, default is <Local IP>:5555"`
 	LiveUpdate   bool   `arg:"--live" help:"Update files live for development purpose"`
 	DisableAuth  bool   `arg:"--noauth" help:"Disable HTTP authentication"`
-	AuthConfig   string `arg:"-c,--authconfig" help:"Authentication config file, a JSON dictonary of <Username>:{Password: <Password>}. Must be provided unelss --noauth is used"`
+	User         string `arg:"-u,--user" help:"Account that stars & last viewed times belong to when --noauth is used, it will be created if it doesn't exist"`
+	AuthConfig   string `arg:"-c,--authconfig" help:"Deprecated: import accounts from a JSON dictonary of <Username>:{Password: <Password>}, passwords are only imported for accounts without one. Use the user subcommand instead"`
+	RateLimit    int    `arg:"--ratelimit" help:"API version 2 requests a key can make each minute before it is locked out, 0 disables the limit" default:"30"`
+	RateLockout  int    `arg:"--ratelockout" help:"Minutes a API version 2 key is locked out for after going over --ratelimit" default:"5"`
+	SessionIdle  int    `arg:"--sessionidle" help:"Hours a login session can go unused before it expires, 0 disables this" default:"24"`
+	SessionMax   int    `arg:"--sessionmax" help:"Hours a login session lasts before it expires, 0 disables this" default:"720"`
 	TlsCert      string `arg:"--cert" help:"Certificate file path, to use TLS this and --key must be used."`
 	TlsKey       string `arg:"--key" help:"Key file path, to use TLS this and --cert must be used."`
+	ThumbDir     string `arg:"--thumbdir" help:"Directory thumbnails are cached in, default is <Databasepath>.thumbs"`
+	// Watching
+	WatchDirs    []string `arg:"--watchdir,separate" help:"Import new files added to this directory while running, removed files are marked missing. See import --watch"`
+	WatchTags    []string `arg:"--watchtag,separate" help:"Tags to add to files imported by --watchdir"`
+	WatchInfo    bool     `arg:"--watchinfo" help:"Add hashes & sizes to files imported by --watchdir"`
+	WatchPoll    bool     `arg:"--watchpoll" help:"Scan --watchdir every 30 seconds instead of using inotify"`
+	WatchRules   string   `arg:"--watchrules" help:"Add tags to files imported by --watchdir with the rules in this JSON file. See import --rules"`
+	WatchTypes   string   `arg:"--watchtypes" help:"JSON config of the types of files --watchdir imports. See import --types"`
+	WatchExclude []string `arg:"--watchexclude,separate" help:"Don't import files in --watchdir matching this pattern, .mmignore files are used too. See import --exclude"`
 }
 
 func (w *WebArgs) Verify() error {
@@ -37,9 +57,6 @@ func (w *WebArgs) Verify() error {
 	if w.ApiVersion <= 0 || w.ApiVersion > 2 {
 		return errors.New("unsupported --apversion value")
 	}
-	if !w.DisableAuth && w.AuthConfig == "" {
-		return errors.New("--authconfig must be providede unless --noauth is used")
-	}
 	return nil
 }
 
@@ -63,15 +80,18 @@ func ParseWeb(a *ArgList, p *arg.Parser) {
 		p.FailSubcommand("--key must be used with --cert", "web")
 		return
 	}
-	// If auth is enabled & config is not provided.
-	if !a.Web.DisableAuth && a.Web.AuthConfig == "" {
-		p.FailSubcommand("--authconfig must provided unless --noauth is provided", "web")
-		return
-	}
 	if a.Web.ApiVersion != 1 && a.Web.ApiVersion != 2 {
 		p.FailSubcommand("--apiversion must be either 1 or 2", "web")
 		return
 	}
+	if a.Web.RateLimit < 0 || a.Web.RateLockout < 0 {
+		p.FailSubcommand("--ratelimit and --ratelockout cannot be negative", "web")
+		return
+	}
+	if a.Web.SessionIdle < 0 || a.Web.SessionMax < 0 {
+		p.FailSubcommand("--sessionidle and --sessionmax cannot be negative", "web")
+		return
+	}
 	// Get address if needed
 	if a.Web.Address == "" {
 		// Get local address
@@ -85,7 +105,7 @@ func ParseWeb(a *ArgList, p *arg.Parser) {
 	}
 	accounts := make(map[string]*AccountConfigValue)
 	// Load accounts (If we are doing that)
-	if !a.Web.DisableAuth {
+	if !a.Web.DisableAuth && a.Web.AuthConfig != "" {
 		data, err := os.ReadFile(a.Web.AuthConfig)
 		if err != nil {
 			fmt.Printf("Failed to open -c/--authconfig: %v\n", err)
@@ -114,49 +134,165 @@ func ParseWeb(a *ArgList, p *arg.Parser) {
 			fmt.Printf("Can't get database version: %v\n", err)
 			return
 		}
-		fmt.Printf("Legacy databases cannot be used, this database is version %s, the current version is %s, the minimum supported version is %d.XrX\n  Use '%s database %s --migrate to update'\n",
-			meta.VersionString(), filedb.FormatVersion(filedb.MajorVersion, filedb.MinorVersion, filedb.Revision), filedb.MajorVersion, os.Args[0], a.Web.DatabasePath)
+		fmt.Printf("Outdated databases cannot be used, this database is version %s, the current version is %s\n  Use '%s database %s --update' to update, 3.X databases also need '--migrateaccount <Account>'\n",
+			meta.VersionString(), filedb.FormatVersion(filedb.MajorVersion, filedb.MinorVersion, filedb.Revision), os.Args[0], a.Web.DatabasePath)
 		return
 	}
-	switch a.Web.ApiVersion {
-	case 1:
-		var lm *web1.LoginManager
-		// Get API
-		mux := http.NewServeMux()
-		var handler http.Handler = mux
-		if !a.Web.DisableAuth {
-			// Setup authentication
-			lm = web1.NewLoginManager(mux, a.Web.LiveUpdate)
-			for k, v := range accounts {
-				err = lm.AddAccount(k, v.Password)
-				if err != nil {
-					fmt.Printf("Failed to add account '%s': %v\n", k, err)
-					return
-				}
+	// Move accounts into the database, existing passwords aren't replaced
+	for k, v := range accounts {
+		u, err := db.GetUserByName(k)
+		if err != nil {
+			u, err = db.AddUser(k, filedb.AccountTypeUser)
+			if err != nil {
+				fmt.Printf("Failed to create user for account '%s': %v\n", k, err)
+				return
+			}
+			fmt.Printf("+ Created user for account '%s'\n", k)
+		}
+		hasPassword, err := db.HasPassword(u)
+		if err != nil {
+			fmt.Printf("Failed to check password of account '%s': %v\n", k, err)
+			return
+		}
+		if hasPassword {
+			continue
+		}
+		err = db.SetPassword(u, v.Password)
+		if err != nil {
+			fmt.Printf("Failed to set password of account '%s': %v\n", k, err)
+			return
+		}
+		fmt.Printf("+ Imported password of account '%s'\n", k)
+	}
+	if len(accounts) != 0 {
+		fmt.Printf("-c/--authconfig is deprecated, accounts are now stored in the database. Delete '%s' and use '%s user' to manage accounts\n", a.Web.AuthConfig, os.Args[0])
+	}
+	if !a.Web.DisableAuth {
+		canLogin := false
+		users, err := db.GetAllUsers()
+		if err != nil {
+			fmt.Printf("Failed to get accounts: %v\n", err)
+			return
+		}
+		for _, u := range users {
+			if hasPassword, err := db.HasPassword(u); err == nil && hasPassword {
+				canLogin = true
+				break
 			}
-			handler = lm.HttpHandler(handler)
-		}
-		// Load API
-		api := web1.NewFileDbApi(db, mux, lm)
-		api.InitApp(mux, a.Web.LiveUpdate)
-		// Add the listen logger
-		handler = web1.ListenerLogger(handler)
-		if a.Web.TlsCert != "" || a.Web.TlsKey != "" {
-			fmt.Printf("Hosting on https://%s\n", a.Web.Address)
-			err := http.ListenAndServeTLS(a.Web.Address, a.Web.TlsCert, a.Web.TlsKey, handler)
+		}
+		if !canLogin {
+			fmt.Printf("No accounts have a password so nobody can log in, add one with '%s user add %s <Name>'\n", os.Args[0], a.Web.DatabasePath)
+		}
+	}
+	var noAuthUser *filedb.User
+	if a.Web.DisableAuth && a.Web.User != "" {
+		noAuthUser, err = db.GetUserByName(a.Web.User)
+		if err != nil {
+			// Without authentication everyone can do everything anyway.
+			noAuthUser, err = db.AddUser(a.Web.User, filedb.AccountTypeAdministrator)
+			if err != nil {
+				fmt.Printf("Failed to create user '%s': %v\n", a.Web.User, err)
+				return
+			}
+			fmt.Printf("+ Created user '%s'\n", a.Web.User)
+		}
+	}
+	if len(a.Web.WatchDirs) > 0 {
+		watchArgs := &ImportArgs{
+			ImportDirs:   a.Web.WatchDirs,
+			AddTags:      a.Web.WatchTags,
+			AddHashes:    a.Web.WatchInfo,
+			AddSizes:     a.Web.WatchInfo,
+			Poll:         a.Web.WatchPoll,
+			PollInterval: 30,
+			Settle:       5,
+			Exclude:      a.Web.WatchExclude,
+			types:        mediatype.Default(),
+		}
+		if err := watchArgs.walkOpts().Validate(); err != nil {
+			fmt.Printf("Invalid --watchexclude: %v\n", err)
+			return
+		}
+		if a.Web.WatchTypes != "" {
+			types, err := mediatype.Load(a.Web.WatchTypes)
+			if err != nil {
+				fmt.Printf("Failed to load types: %v\n", err)
+				return
+			}
+			watchArgs.types = types
+		}
+		if a.Web.WatchRules != "" {
+			rules, err := tagrules.Load(a.Web.WatchRules)
 			if err != nil {
-				slog.Error("Failed to server TLS", "Error", err.Error(), "Address", a.Web.Address, "CertFilePath", a.Web.TlsCert, "KeyFilePath", a.Web.TlsKey)
-				fmt.Printf("Failed: %v\n", err)
+				fmt.Printf("Failed to load rules: %v\n", err)
+				return
 			}
-		} else {
-			fmt.Printf("Hosting on http://%s\n", a.Web.Address)
-			err := http.ListenAndServe(a.Web.Address, handler)
+			rules.SetTypes(watchArgs.types)
+			watchArgs.rules = rules
+		}
+		go func() {
+			err := watchImport(context.Background(), db, watchArgs, nil)
 			if err != nil {
-				slog.Error("Failed to server TLS", "Error", err.Error(), "Address", a.Web.Address)
-				fmt.Printf("Failed: %v\n", err)
+				slog.Error("Failed to watch directories", "Error", err.Error(), "Dirs", a.Web.WatchDirs)
+				fmt.Printf("Failed to watch directories: %v\n", err)
 			}
+		}()
+		fmt.Printf("Watching %d directories for new files\n", len(a.Web.WatchDirs))
+	}
+	var lm *web1.LoginManager
+	// Get API
+	mux := http.NewServeMux()
+	var handler http.Handler = mux
+	if !a.Web.DisableAuth {
+		// Setup authentication
+		lm = web1.NewLoginManager(mux, a.Web.LiveUpdate, db, web1.SessionOpts{
+			IdleTimeout:     time.Duration(a.Web.SessionIdle) * time.Hour,
+			AbsoluteTimeout: time.Duration(a.Web.SessionMax) * time.Hour,
+			Secure:          a.Web.TlsCert != "",
+		})
+		handler = lm.HttpHandler(handler)
+	}
+	// Load API, the app always uses version 1
+	if a.Web.ThumbDir == "" {
+		a.Web.ThumbDir = thumbnail.DefaultDir(a.Web.DatabasePath)
+	}
+	api := web1.NewFileDbApi(db, mux, lm, noAuthUser, thumbnail.NewCache(a.Web.ThumbDir))
+	api.InitApp(mux, a.Web.LiveUpdate)
+	switch a.Web.ApiVersion {
+	case 1:
+		// Nothing else to add
+	case 2:
+		// Version 2 uses API keys instead of cookies, so it goes around the login manager
+		apiMux := http.NewServeMux()
+		var apiHandler http.Handler = apiMux
+		var limiter *web2.RateLimiter
+		if a.Web.RateLimit > 0 {
+			limiter = web2.NewRateLimiter(db, a.Web.RateLimit, time.Minute, time.Duration(a.Web.RateLockout)*time.Minute)
+			apiHandler = limiter.HttpHandler(apiHandler)
 		}
+		web2.NewFileDbApi(db, apiMux, limiter)
+		root := http.NewServeMux()
+		root.Handle("/api/2/", apiHandler)
+		root.Handle("/", handler)
+		handler = root
 	default:
 		panic(fmt.Sprintf("MediaManager: Version '%d' was allowed through argument check, but not implemented.", a.Web.ApiVersion))
 	}
+	// Add the listen logger
+	handler = web1.ListenerLogger(handler)
+	if a.Web.TlsCert != "" || a.Web.TlsKey != "" {
+		fmt.Printf("Hosting on https://%s\n", a.Web.Address)
+		err := http.ListenAndServeTLS(a.Web.Address, a.Web.TlsCert, a.Web.TlsKey, handler)
+		if err != nil {
+			slog.Error("Failed to server TLS", "Error", err.Error(), "Address", a.Web.Address, "CertFilePath", a.Web.TlsCert, "KeyFilePath", a.Web.TlsKey)
+			fmt.Printf("Failed: %v\n", err)
+		}
+	} else {
+		fmt.Printf("Hosting on http://%s\n", a.Web.Address)
+		err := http.ListenAndServe(a.Web.Address, handler)
+		if err != nil {
+			slog.Error("Failed to server TLS", "Error", err.Error(), "Address", a.Web.Address)
+			fmt.Printf("Failed: %v\n", err)
+		}
+	}
 }
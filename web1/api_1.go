@@ -2,18 +2,25 @@ package web1
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
 	"mediamanager/filedb"
+	"mediamanager/metadata"
+	"mediamanager/thumbnail"
 	"net/http"
+	"net/url"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 )
 
 type DbApi1 struct {
-	db *filedb.FileDb
-	lm *LoginManager
+	db     *filedb.FileDb
+	lm     *LoginManager
+	user   *filedb.User     // User for requests when lm is nil
+	thumbs *thumbnail.Cache // Thumbnail cache, nil if thumbnails are disabled
 }
 
 type apiBase struct {
@@ -24,10 +31,14 @@ type apiBase struct {
 type apiFile struct {
 	Id         int
 	Path       string
+	Name       string
 	Tags       []string
 	LastViewed time.Time
 	Stars      uint8
 	Size       int64
+	Meta       *metadata.Metadata // Embedded metadata, null if none was read
+	Mime       string             // Like 'video/mp4', empty if it isn't known
+	Category   string             // image, audio or video, empty if it isn't known
 }
 
 func (a *DbApi1) writeApiError(w http.ResponseWriter, r *http.Request, code int, msg string) {
@@ -67,15 +78,55 @@ func (a *DbApi1) filesToApiFile(files []*filedb.File) []*apiFile {
 		apiFiles[i] = &apiFile{
 			Id:         v.GetId(),
 			Path:       v.GetPath(),
+			Name:       v.GetName(),
 			Tags:       v.GetTags(),
 			LastViewed: v.GetLastPlayTime(),
 			Stars:      v.GetStars(),
 			Size:       v.GetSize(),
+			Meta:       v.GetMeta(),
+			Mime:       v.GetMime(),
+			Category:   v.GetCategory(),
 		}
 	}
 	return apiFiles
 }
 
+// Get the user making the request, or nil if there isn't one
+func (a *DbApi1) requestUser(r *http.Request) *filedb.User {
+	if a.lm == nil {
+		return a.user
+	}
+	u, err := a.lm.RequestUser(r)
+	if err != nil {
+		return nil
+	}
+	return u
+}
+
+// Id of the user making the request, or 0 if there isn't one
+func (a *DbApi1) requestUserId(r *http.Request) int {
+	u := a.requestUser(r)
+	if u == nil {
+		return 0
+	}
+	return u.GetId()
+}
+
+// Checks the user making the request has accountType or a higher one, if they don't a 401 or 403 error is written and false is returned.
+func (a *DbApi1) requireAccountType(w http.ResponseWriter, r *http.Request, accountType filedb.AccountType) bool {
+	user := a.requestUser(r)
+	if user == nil {
+		a.writeApiError(w, r, http.StatusUnauthorized, "a account is required")
+		return false
+	}
+	if user.GetAccountType() < accountType {
+		slog.Info("Account type too low", "Path", r.URL.Path, "User.Name", user.GetName(), "Required", accountType.String(), "Has", user.GetAccountType().String())
+		a.writeApiError(w, r, http.StatusForbidden, fmt.Sprintf("requires a %s account", accountType))
+		return false
+	}
+	return true
+}
+
 func (a *DbApi1) serveFileOrApiError(w http.ResponseWriter, r *http.Request, path string) {
 	_, err := os.Stat(path)
 	if err != nil {
@@ -129,12 +180,18 @@ func (a *DbApi1) ServeFile(w http.ResponseWriter, r *http.Request) {
 	}
 	// If this is a HEAD request we never update (We don't get any content.)
 	if r.Method == http.MethodGet && qr.Get("update") == "true" {
-		file.MarkFileRead()
-		a.db.UpdateFile(file)
+		if user := a.requestUser(r); user != nil && user.GetAccountType() >= filedb.AccountTypeUser && a.db.LoadUserData(user, file) == nil {
+			file.MarkFileRead()
+			a.db.UpdateFile(file)
+		}
 	}
 	// Now it depends, if this is a HEAD we just send content type, otherwise we send the file
 	switch r.Method {
 	case http.MethodGet:
+		// Otherwise it's guessed from the extension
+		if file.GetMime() != "" {
+			w.Header().Set("Content-Type", file.GetMime())
+		}
 		a.serveFileOrApiError(w, r, file.GetPath())
 	case http.MethodHead:
 		f, err := os.Open(file.GetPath())
@@ -160,13 +217,88 @@ func (a *DbApi1) ServeFile(w http.ResponseWriter, r *http.Request) {
 			a.writeApiError(w, r, http.StatusInternalServerError, fmt.Sprintf("Failed to read file: %v", err))
 			return
 		}
-		contentType := http.DetectContentType(buffer)
+		contentType := file.GetMime()
+		if contentType == "" {
+			contentType = http.DetectContentType(buffer)
+		}
 		w.Header().Add("content-type", contentType)
 	default:
 		panic(fmt.Sprintf("Web1: ServeFile: Expected method to be either 'GET' or 'HEAD' (Should have been checked), Was '%s'", r.Method))
 	}
 }
 
+// Serve a thumbnail of a image, it's created & cached the first time it's requested
+//
+// Method: GET
+//
+// URL: /api/1/thumbnail
+//
+// Headers:
+//   - If-None-Match: ETag of a earlier response, 304 is returned if the thumbnail is the same
+//
+// Query Params:
+//   - id: File id
+//   - size: Size of the longest side, 128, 256 or 512, default: 256
+//
+// Auth: Required
+//
+// Returns: JPEG thumbnail, this is not in the API format
+//
+// Error on: id not found, invalid size, the file has no hash or isn't a JPEG, PNG or GIF
+func (a *DbApi1) ServeThumbnail(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		a.writeApiError(w, r, http.StatusMethodNotAllowed, "Must be a 'GET' or 'HEAD' request")
+		return
+	}
+	if a.thumbs == nil {
+		a.writeApiError(w, r, http.StatusNotFound, "thumbnails are disabled")
+		return
+	}
+	qr := r.URL.Query()
+	id, err := strconv.ParseUint(qr.Get("id"), 0, 64)
+	if err != nil {
+		a.writeApiError(w, r, http.StatusBadRequest, "invalid id")
+		return
+	}
+	size := thumbnail.DefaultSize
+	if qr.Has("size") {
+		size, err = strconv.Atoi(qr.Get("size"))
+		if err != nil || !thumbnail.IsValidSize(size) {
+			a.writeApiError(w, r, http.StatusBadRequest, fmt.Sprintf("invalid size, must be one of %v", thumbnail.Sizes))
+			return
+		}
+	}
+	file, err := a.db.GetFileById(int(id))
+	if err != nil {
+		a.writeApiError(w, r, http.StatusNotFound, "file not found")
+		return
+	}
+	if file.GetHash() == "" {
+		a.writeApiError(w, r, http.StatusNotFound, "file has no hash, so it can't have a thumbnail")
+		return
+	}
+	// Thumbnails are named by hash, so they only change when the file does
+	etag := fmt.Sprintf("\"%s-%d\"", file.GetHash(), size)
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "private, no-cache")
+	for _, v := range strings.Split(r.Header.Get("If-None-Match"), ",") {
+		if v = strings.TrimPrefix(strings.TrimSpace(v), "W/"); v == etag || v == "*" {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+	p, err := a.thumbs.Get(file.GetHash(), size, file.GetPath())
+	if errors.Is(err, thumbnail.ErrUnsupported) {
+		a.writeApiError(w, r, http.StatusNotFound, "file type can't have a thumbnail")
+		return
+	} else if err != nil {
+		slog.Warn("Failed to create thumbnail", "Path", file.GetPath(), "Error", err.Error())
+		a.writeApiError(w, r, http.StatusInternalServerError, fmt.Sprintf("failed to create thumbnail: %v", err))
+		return
+	}
+	http.ServeFile(w, r, p)
+}
+
 // Serve a list of files info
 //
 // Method: GET
@@ -208,9 +340,82 @@ func (a *DbApi1) GetFileInfo(w http.ResponseWriter, r *http.Request) {
 		}
 		files = append(files, f)
 	}
+	if user := a.requestUser(r); user != nil {
+		err := a.db.LoadUserData(user, files...)
+		if err != nil {
+			a.writeApiError(w, r, http.StatusInternalServerError, fmt.Sprintf("Failed to get user data: %v", err))
+			return
+		}
+	}
 	a.writeApiData(w, r, a.filesToApiFile(files))
 }
 
+// A file that looks like another
+type apiSimilarFile struct {
+	*apiFile
+	Distance int // Bits that differ between the perceptual hashes, 0 looks the same
+}
+
+// Get images that look like a file, by perceptual hash
+//
+// Method: GET
+//
+// URL: /api/1/similar
+//
+// Auth: Required
+//
+// Headers: None
+//
+// Query params:
+//   - id: File id, the file must have a perceptual hash
+//   - distance: Bits the perceptual hashes can differ by, 0 to 64, default: 10
+//
+// Returns: JSON file array with the distance of each file, closest first
+//
+// Error: File not found, the file has no perceptual hash, invalid distance
+func (a *DbApi1) GetSimilarFiles(w http.ResponseWriter, r *http.Request) {
+	qr := r.URL.Query()
+	id, err := strconv.ParseUint(qr.Get("id"), 0, 64)
+	if err != nil {
+		a.writeApiError(w, r, http.StatusBadRequest, "invalid id")
+		return
+	}
+	distance := 10
+	if qr.Has("distance") {
+		distance, err = strconv.Atoi(qr.Get("distance"))
+		if err != nil {
+			a.writeApiError(w, r, http.StatusBadRequest, "invalid distance")
+			return
+		}
+	}
+	file, err := a.db.GetFileById(int(id))
+	if err != nil {
+		a.writeApiError(w, r, http.StatusNotFound, "file not found")
+		return
+	}
+	similar, err := a.db.FindSimilar(file, distance)
+	if err != nil {
+		a.writeApiError(w, r, http.StatusBadRequest, fmt.Sprintf("Failed to find similar files: %v", err))
+		return
+	}
+	files := make([]*filedb.File, len(similar))
+	for i, v := range similar {
+		files[i] = v.File
+	}
+	if user := a.requestUser(r); user != nil {
+		err = a.db.LoadUserData(user, files...)
+		if err != nil {
+			a.writeApiError(w, r, http.StatusInternalServerError, fmt.Sprintf("Failed to get user data: %v", err))
+			return
+		}
+	}
+	data := make([]*apiSimilarFile, len(similar))
+	for i, v := range a.filesToApiFile(files) {
+		data[i] = &apiSimilarFile{apiFile: v, Distance: similar[i].Distance}
+	}
+	a.writeApiData(w, r, data)
+}
+
 // Update a file
 //
 // Method: POST
@@ -222,7 +427,7 @@ func (a *DbApi1) GetFileInfo(w http.ResponseWriter, r *http.Request) {
 // Post Data:
 //   - Path   : File path, cannot exist with 'Id'
 //   - Id     : File Id, cannot exist with 'Path'
-//   - Stars  : New star count, if not set no change occurs
+//   - Stars  : New star count for the logged in account, if not set no change occurs
 //   - AddTags: Tag to add, can have multiple
 //   - RemTags: Tag to remove, can have multiple
 //
@@ -258,6 +463,26 @@ func (a *DbApi1) UpdateFile(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 	}
+	// Stars need a user account, tags are global so they need a moderator account
+	required := filedb.AccountTypeGuest
+	if r.PostForm.Get("Stars") != "" {
+		required = filedb.AccountTypeUser
+	}
+	if len(r.PostForm["AddTags"]) != 0 || len(r.PostForm["RemTags"]) != 0 {
+		required = filedb.AccountTypeModerator
+	}
+	if required != filedb.AccountTypeGuest && !a.requireAccountType(w, r, required) {
+		return
+	}
+	// Stars belong to the user, so get their values first.
+	user := a.requestUser(r)
+	if user != nil {
+		err := a.db.LoadUserData(user, file)
+		if err != nil {
+			a.writeApiError(w, r, http.StatusInternalServerError, fmt.Sprintf("Failed to get user data: %v", err))
+			return
+		}
+	}
 	starsStr := r.PostForm.Get("Stars")
 	if starsStr != "" {
 		stars, err := strconv.ParseUint(starsStr, 0, 8)
@@ -296,6 +521,70 @@ func (a *DbApi1) UpdateFile(w http.ResponseWriter, r *http.Request) {
 	a.writeApiData(w, r, nil)
 }
 
+// Parse the search parameters used by SearchFile & AddSavedSearch
+func parseSearchQuery(qr url.Values) (*filedb.SearchQuery, error) {
+	search := &filedb.SearchQuery{
+		Path:          qr.Get("path"),
+		PathRe:        qr.Get("path_re"),
+		WhitelistTags: qr["tag_whitelist"],
+		BlacklistTags: qr["tag_blacklist"],
+		Text:          qr.Get("text"),
+		Query:         qr.Get("q"),
+		SortReverse:   qr.Get("sort_reverse") == "true",
+		Index:         0,
+		Count:         50,
+	}
+	if idxStr := qr.Get("index"); idxStr != "" {
+		index, err := strconv.ParseUint(idxStr, 0, 64)
+		if err != nil {
+			return nil, errors.New("invalid 'index' value")
+		}
+		search.Index = int64(index)
+	}
+	if cntStr := qr.Get("count"); cntStr != "" {
+		count, err := strconv.ParseUint(cntStr, 0, 64)
+		if err != nil {
+			return nil, errors.New("invalid 'count' value")
+		}
+		// Max count value (For performance reasons) is 200
+		if count > 200 {
+			return nil, errors.New("'count' cannot exceed 200")
+		}
+		search.Count = int64(count)
+	}
+	switch qr.Get("sort") {
+	case "none", "":
+		search.SortBy = filedb.SortMethodNone
+	case "size":
+		search.SortBy = filedb.SortMethodSize
+	case "stars":
+		search.SortBy = filedb.SortMethodStars
+	case "date":
+		search.SortBy = filedb.SortMethodLastViewed
+	case "id":
+		search.SortBy = filedb.SortMethodId
+	case "random":
+		search.SortBy = filedb.SortMethodRandom
+	case "relevance":
+		search.SortBy = filedb.SortMethodRelevance
+	case "title":
+		search.SortBy = filedb.SortMethodTitle
+	case "artist":
+		search.SortBy = filedb.SortMethodArtist
+	case "album":
+		search.SortBy = filedb.SortMethodAlbum
+	case "duration":
+		search.SortBy = filedb.SortMethodDuration
+	case "taken":
+		search.SortBy = filedb.SortMethodTaken
+	case "resolution":
+		search.SortBy = filedb.SortMethodResolution
+	default:
+		return nil, errors.New("invalid 'sort' value, must be one of 'none, 'size', 'stars', 'date', 'id', 'random', 'relevance', 'title', 'artist', 'album', 'duration', 'taken' or 'resolution'")
+	}
+	return search, nil
+}
+
 // Search for files
 //
 // Method: GET
@@ -309,87 +598,645 @@ func (a *DbApi1) UpdateFile(w http.ResponseWriter, r *http.Request) {
 //   - path_re: Regex match
 //   - tag_whitelist: Whitelisted tags, multiple max exist
 //   - tag_blacklist: Blacklisted tags, multiple max exist
+//   - text         : Words that must be in the path, name or tags. "Quoted words" are a phrase, a trailing '*' matches prefixes
+//   - q            : Query language filter, e.g. '(tag:anime OR tag:cartoon) -tag:watched stars>=4 size<2GB viewed<30d path:"/tv/"'. See filedb.QueryNode
 //   - count        : Number of results to return
 //   - index        : Index to start at
-//   - sort         : Sort method, values are 'none', 'size' and 'stars' (In the future 'date' will be supported). Default: none
+//   - sort         : Sort method, values are 'none', 'size', 'stars', 'date', 'id', 'random', 'relevance', 'title', 'artist', 'album', 'duration', 'taken' and 'resolution'. Default: none, which is relevance when 'text' is used
 //   - sort_reverse : boolean, reverse search order (From ascending to descending) default: false
 //
 // Returns: filedb.File array for each found value
 //
-// Error: Search fails
-func (a *DbApi1) SearchFile(w http.ResponseWriter, r *http.Request) {
-	search := &filedb.SearchQuery{
-		Path:          "",
-		PathRe:        "",
-		WhitelistTags: make([]string, 0),
-		BlacklistTags: make([]string, 0),
-		Index:         0,
-		Count:         50,
+// Error: Search fails or 'q' is invalid
+func (a *DbApi1) SearchFile(w http.ResponseWriter, r *http.Request) {
+	search, err := parseSearchQuery(r.URL.Query())
+	if err != nil {
+		a.writeApiError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	search.UserId = a.requestUserId(r)
+	files, err := a.db.SearchFile(search)
+	if err != nil {
+		a.writeApiError(w, r, http.StatusBadRequest, fmt.Sprintf("Search failed: %v", err))
+		return
+	}
+	a.writeApiData(w, r, a.filesToApiFile(files))
+}
+
+type apiSavedSearch struct {
+	Id            int
+	Name          string
+	Query         string
+	Sort          string
+	SortReverse   bool
+	Created       int64
+	CreatedString string
+}
+
+func savedSearchToApi(s *filedb.SavedSearch) *apiSavedSearch {
+	return &apiSavedSearch{
+		Id:            s.GetId(),
+		Name:          s.GetName(),
+		Query:         s.GetQuery(),
+		Sort:          s.GetSortBy().String(),
+		SortReverse:   s.GetSortReverse(),
+		Created:       s.GetCreated().UTC().Unix(),
+		CreatedString: s.GetCreated().UTC().Format(time.RFC3339),
+	}
+}
+
+// List the saved searches of the logged in account
+//
+// Method: GET
+//
+// Auth: Required
+//
+// Headers: None
+//
+// Query Params: None
+//
+// Returns: Saved search array, ordered by name
+//
+// Error: No account
+func (a *DbApi1) GetSavedSearches(w http.ResponseWriter, r *http.Request) {
+	user := a.requestUser(r)
+	if user == nil {
+		a.writeApiError(w, r, http.StatusUnauthorized, "saved searches require a account")
+		return
+	}
+	searches, err := a.db.GetSavedSearches(user)
+	if err != nil {
+		a.writeApiError(w, r, http.StatusInternalServerError, fmt.Sprintf("Failed to get saved searches: %v", err))
+		return
+	}
+	data := make([]*apiSavedSearch, len(searches))
+	for i, v := range searches {
+		data[i] = savedSearchToApi(v)
+	}
+	a.writeApiData(w, r, data)
+}
+
+// Run a saved search of the logged in account
+//
+// Method: GET
+//
+// Auth: Required
+//
+// Headers: None
+//
+// Query Params:
+//   - name : Name of the saved search
+//   - count: Number of results to return, max 200. Default: 50
+//   - index: Index to start at
+//
+// Returns: filedb.File array for each found value
+//
+// Error: No account, search not found
+func (a *DbApi1) RunSavedSearch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		a.writeApiError(w, r, http.StatusMethodNotAllowed, "Must be a 'GET' request")
+		return
+	}
+	user := a.requestUser(r)
+	if user == nil {
+		a.writeApiError(w, r, http.StatusUnauthorized, "saved searches require a account")
+		return
+	}
+	qr := r.URL.Query()
+	name := qr.Get("name")
+	if name == "" {
+		a.writeApiError(w, r, http.StatusBadRequest, "missing 'name' query parameter")
+		return
+	}
+	// Only count & index are used
+	page, err := parseSearchQuery(url.Values{"count": qr["count"], "index": qr["index"]})
+	if err != nil {
+		a.writeApiError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	s, err := a.db.GetSavedSearch(user, name)
+	if err != nil {
+		a.writeApiError(w, r, http.StatusNotFound, "saved search not found")
+		return
+	}
+	files, err := a.db.RunSavedSearch(s, page.Count, page.Index)
+	if err != nil {
+		a.writeApiError(w, r, http.StatusBadRequest, fmt.Sprintf("Search failed: %v", err))
+		return
+	}
+	a.writeApiData(w, r, a.filesToApiFile(files))
+}
+
+// Save a search for the logged in account
+//
+// Method: POST
+//
+// Auth: Required, a user account or higher
+//
+// Headers: None
+//
+// Form Values:
+//   - name: Name of the saved search, must be unique for the account
+//   - path, path_re, tag_whitelist, tag_blacklist, text, q, sort & sort_reverse: Same as SearchFile, they are saved as a query
+//
+// Returns: The saved search
+//
+// Error: No account, invalid search, name already used
+func (a *DbApi1) AddSavedSearch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		a.writeApiError(w, r, http.StatusMethodNotAllowed, "Must be a 'POST' request")
+		return
+	}
+	if !a.requireAccountType(w, r, filedb.AccountTypeUser) {
+		return
+	}
+	user := a.requestUser(r)
+	if err := r.ParseForm(); err != nil {
+		a.writeApiError(w, r, http.StatusBadRequest, fmt.Sprintf("invalid form: %v", err))
+		return
+	}
+	name := r.PostForm.Get("name")
+	if name == "" {
+		a.writeApiError(w, r, http.StatusBadRequest, "missing 'name' form value")
+		return
+	}
+	search, err := parseSearchQuery(r.PostForm)
+	if err != nil {
+		a.writeApiError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	s, err := a.db.AddSavedSearch(user, name, search)
+	if err != nil {
+		a.writeApiError(w, r, http.StatusBadRequest, fmt.Sprintf("Failed to save search: %v", err))
+		return
+	}
+	a.writeApiData(w, r, savedSearchToApi(s))
+}
+
+// Remove a saved search of the logged in account
+//
+// Method: DELETE
+//
+// Auth: Required, a user account or higher
+//
+// Headers: None
+//
+// Query Params:
+//   - name: Name of the saved search
+//
+// Returns: Empty API Response
+//
+// Error: No account, search not found
+func (a *DbApi1) RemoveSavedSearch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		a.writeApiError(w, r, http.StatusMethodNotAllowed, "Must be a 'DELETE' request")
+		return
+	}
+	if !a.requireAccountType(w, r, filedb.AccountTypeUser) {
+		return
+	}
+	user := a.requestUser(r)
+	name := r.URL.Query().Get("name")
+	if name == "" {
+		a.writeApiError(w, r, http.StatusBadRequest, "missing 'name' query parameter")
+		return
+	}
+	s, err := a.db.GetSavedSearch(user, name)
+	if err != nil {
+		a.writeApiError(w, r, http.StatusNotFound, "saved search not found")
+		return
+	}
+	err = a.db.RemoveSavedSearch(s)
+	if err != nil {
+		a.writeApiError(w, r, http.StatusInternalServerError, fmt.Sprintf("Failed to remove saved search: %v", err))
+		return
+	}
+	a.writeApiData(w, r, nil)
+}
+
+type apiCollection struct {
+	Id            int
+	Name          string
+	Description   string
+	CoverId       int
+	Count         int
+	Created       int64
+	CreatedString string
+}
+
+type apiCollectionFiles struct {
+	Collection *apiCollection
+	Files      []*apiFile
+}
+
+func collectionToApi(c *filedb.Collection) *apiCollection {
+	return &apiCollection{
+		Id:            c.GetId(),
+		Name:          c.GetName(),
+		Description:   c.GetDescription(),
+		CoverId:       c.GetCoverId(),
+		Count:         c.GetCount(),
+		Created:       c.GetCreated().UTC().Unix(),
+		CreatedString: c.GetCreated().UTC().Format(time.RFC3339),
+	}
+}
+
+// Get a collection by name, writing a error if it fails
+func (a *DbApi1) requestCollection(w http.ResponseWriter, r *http.Request, name string) *filedb.Collection {
+	if name == "" {
+		a.writeApiError(w, r, http.StatusBadRequest, "missing 'name' value")
+		return nil
+	}
+	c, err := a.db.GetCollection(name)
+	if err != nil {
+		a.writeApiError(w, r, http.StatusNotFound, "collection not found")
+		return nil
+	}
+	return c
+}
+
+// Get files by id, writing a error if it fails
+func (a *DbApi1) requestFiles(w http.ResponseWriter, r *http.Request, ids []string) []*filedb.File {
+	if len(ids) == 0 {
+		a.writeApiError(w, r, http.StatusBadRequest, "missing 'id' value")
+		return nil
+	}
+	files := make([]*filedb.File, len(ids))
+	for i, v := range ids {
+		id, err := strconv.ParseUint(v, 0, 64)
+		if err != nil {
+			a.writeApiError(w, r, http.StatusBadRequest, fmt.Sprintf("Invalid 'id' value '%s'", v))
+			return nil
+		}
+		files[i], err = a.db.GetFileById(int(id))
+		if err != nil {
+			a.writeApiError(w, r, http.StatusNotFound, fmt.Sprintf("Failed to find file by id '%d'", id))
+			return nil
+		}
+	}
+	return files
+}
+
+// Parse a optional index value, a empty value is -1 (The end)
+func parseCollectionIndex(s string) (int, error) {
+	if s == "" {
+		return -1, nil
+	}
+	index, err := strconv.ParseInt(s, 0, 32)
+	if err != nil {
+		return 0, errors.New("'index' is invalid")
+	}
+	return int(index), nil
+}
+
+// List collections
+//
+// Method: GET
+//
+// Auth: Required
+//
+// Headers: None
+//
+// Query Params:
+//   - id: Only list collections this file is in, optional
+//
+// Returns: Collection array, ordered by name
+//
+// Error: File not found
+func (a *DbApi1) GetCollections(w http.ResponseWriter, r *http.Request) {
+	var collections []*filedb.Collection
+	var err error
+	if idStr := r.URL.Query().Get("id"); idStr != "" {
+		files := a.requestFiles(w, r, []string{idStr})
+		if files == nil {
+			return
+		}
+		collections, err = a.db.GetFileCollections(files[0])
+	} else {
+		collections, err = a.db.GetCollections()
+	}
+	if err != nil {
+		a.writeApiError(w, r, http.StatusInternalServerError, fmt.Sprintf("Failed to get collections: %v", err))
+		return
+	}
+	data := make([]*apiCollection, len(collections))
+	for i, v := range collections {
+		data[i] = collectionToApi(v)
+	}
+	a.writeApiData(w, r, data)
+}
+
+// Get a collection & its files in order
+//
+// Method: GET
+//
+// Auth: Required
+//
+// Headers: None
+//
+// Query Params:
+//   - name: Name of the collection
+//
+// Returns: The collection & a filedb.File array
+//
+// Error: Collection not found
+func (a *DbApi1) GetCollection(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		a.writeApiError(w, r, http.StatusMethodNotAllowed, "Must be a 'GET' request")
+		return
+	}
+	c := a.requestCollection(w, r, r.URL.Query().Get("name"))
+	if c == nil {
+		return
+	}
+	files, err := a.db.GetCollectionFiles(c, a.requestUserId(r))
+	if err != nil {
+		a.writeApiError(w, r, http.StatusInternalServerError, fmt.Sprintf("Failed to get collection files: %v", err))
+		return
+	}
+	a.writeApiData(w, r, &apiCollectionFiles{
+		Collection: collectionToApi(c),
+		Files:      a.filesToApiFile(files),
+	})
+}
+
+// Create a empty collection
+//
+// Method: POST
+//
+// Auth: Required, a administrator account or higher
+//
+// Headers: None
+//
+// Form Values:
+//   - name       : Name of the collection, must be unique
+//   - description: Description of the collection, optional
+//
+// Returns: The collection
+//
+// Error: Name already used
+func (a *DbApi1) AddCollection(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		a.writeApiError(w, r, http.StatusMethodNotAllowed, "Must be a 'POST' request")
+		return
+	}
+	if !a.requireAccountType(w, r, filedb.AccountTypeAdministrator) {
+		return
+	}
+	name := r.PostFormValue("name")
+	if name == "" {
+		a.writeApiError(w, r, http.StatusBadRequest, "missing 'name' form value")
+		return
+	}
+	c, err := a.db.AddCollection(name, r.PostFormValue("description"))
+	if err != nil {
+		a.writeApiError(w, r, http.StatusBadRequest, fmt.Sprintf("Failed to add collection: %v", err))
+		return
+	}
+	a.writeApiData(w, r, collectionToApi(c))
+}
+
+// Rename a collection or change its description or cover, values that aren't set aren't changed
+//
+// Method: POST
+//
+// Auth: Required, a moderator account or higher
+//
+// Headers: None
+//
+// Form Values:
+//   - name       : Name of the collection
+//   - newname    : New name, must be unique
+//   - description: New description
+//   - cover      : File id of the new cover, 0 removes it
+//
+// Returns: The collection
+//
+// Error: Collection not found, name already used, cover not found
+func (a *DbApi1) UpdateCollection(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		a.writeApiError(w, r, http.StatusMethodNotAllowed, "Must be a 'POST' request")
+		return
+	}
+	if !a.requireAccountType(w, r, filedb.AccountTypeModerator) {
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		a.writeApiError(w, r, http.StatusBadRequest, fmt.Sprintf("invalid form: %v", err))
+		return
+	}
+	c := a.requestCollection(w, r, r.PostForm.Get("name"))
+	if c == nil {
+		return
+	}
+	if r.PostForm.Has("newname") {
+		if err := c.SetName(r.PostForm.Get("newname")); err != nil {
+			a.writeApiError(w, r, http.StatusBadRequest, fmt.Sprintf("Invalid 'newname': %v", err))
+			return
+		}
+	}
+	if r.PostForm.Has("description") {
+		c.SetDescription(r.PostForm.Get("description"))
+	}
+	if r.PostForm.Has("cover") {
+		var cover *filedb.File
+		if idStr := r.PostForm.Get("cover"); idStr != "0" {
+			files := a.requestFiles(w, r, []string{idStr})
+			if files == nil {
+				return
+			}
+			cover = files[0]
+		}
+		c.SetCover(cover)
+	}
+	err := a.db.UpdateCollection(c)
+	if err != nil {
+		a.writeApiError(w, r, http.StatusBadRequest, fmt.Sprintf("Failed to update collection: %v", err))
+		return
+	}
+	a.writeApiData(w, r, collectionToApi(c))
+}
+
+// Remove a collection, the files in it are kept
+//
+// Method: DELETE
+//
+// Auth: Required, a administrator account or higher
+//
+// Headers: None
+//
+// Query Params:
+//   - name: Name of the collection
+//
+// Returns: Empty API Response
+//
+// Error: Collection not found
+func (a *DbApi1) RemoveCollection(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		a.writeApiError(w, r, http.StatusMethodNotAllowed, "Must be a 'DELETE' request")
+		return
+	}
+	if !a.requireAccountType(w, r, filedb.AccountTypeAdministrator) {
+		return
+	}
+	c := a.requestCollection(w, r, r.URL.Query().Get("name"))
+	if c == nil {
+		return
+	}
+	err := a.db.RemoveCollection(c)
+	if err != nil {
+		a.writeApiError(w, r, http.StatusInternalServerError, fmt.Sprintf("Failed to remove collection: %v", err))
+		return
+	}
+	a.writeApiData(w, r, nil)
+}
+
+// Insert files into a collection
+//
+// Method: POST
+//
+// Auth: Required, a moderator account or higher
+//
+// Headers: None
+//
+// Form Values:
+//   - name : Name of the collection
+//   - id   : File ids to insert in order, can have multiple
+//   - index: Index to insert them at, if not set they are added to the end
+//
+// Returns: The collection
+//
+// Error: Collection or file not found, file already in the collection
+func (a *DbApi1) AddCollectionItems(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		a.writeApiError(w, r, http.StatusMethodNotAllowed, "Must be a 'POST' request")
+		return
+	}
+	if !a.requireAccountType(w, r, filedb.AccountTypeModerator) {
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		a.writeApiError(w, r, http.StatusBadRequest, fmt.Sprintf("invalid form: %v", err))
+		return
+	}
+	index, err := parseCollectionIndex(r.PostForm.Get("index"))
+	if err != nil {
+		a.writeApiError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	c := a.requestCollection(w, r, r.PostForm.Get("name"))
+	if c == nil {
+		return
+	}
+	files := a.requestFiles(w, r, r.PostForm["id"])
+	if files == nil {
+		return
+	}
+	err = a.db.InsertCollectionItems(c, index, files...)
+	if err != nil {
+		a.writeApiError(w, r, http.StatusBadRequest, fmt.Sprintf("Failed to add files: %v", err))
+		return
+	}
+	a.writeApiData(w, r, collectionToApi(c))
+}
+
+// Remove files from a collection
+//
+// Method: POST
+//
+// Auth: Required, a moderator account or higher
+//
+// Headers: None
+//
+// Form Values:
+//   - name: Name of the collection
+//   - id  : File ids to remove, can have multiple
+//
+// Returns: The collection
+//
+// Error: Collection or file not found, file isn't in the collection
+func (a *DbApi1) RemoveCollectionItems(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		a.writeApiError(w, r, http.StatusMethodNotAllowed, "Must be a 'POST' request")
+		return
+	}
+	if !a.requireAccountType(w, r, filedb.AccountTypeModerator) {
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		a.writeApiError(w, r, http.StatusBadRequest, fmt.Sprintf("invalid form: %v", err))
+		return
+	}
+	c := a.requestCollection(w, r, r.PostForm.Get("name"))
+	if c == nil {
+		return
+	}
+	files := a.requestFiles(w, r, r.PostForm["id"])
+	if files == nil {
+		return
+	}
+	err := a.db.RemoveCollectionItems(c, files...)
+	if err != nil {
+		a.writeApiError(w, r, http.StatusBadRequest, fmt.Sprintf("Failed to remove files: %v", err))
+		return
+	}
+	a.writeApiData(w, r, collectionToApi(c))
+}
+
+// Move a file in a collection
+//
+// Method: POST
+//
+// Auth: Required, a moderator account or higher
+//
+// Headers: None
+//
+// Form Values:
+//   - name : Name of the collection
+//   - id   : File id to move
+//   - index: Index to move it to, if not set it is moved to the end
+//
+// Returns: The collection
+//
+// Error: Collection or file not found, file isn't in the collection
+func (a *DbApi1) MoveCollectionItem(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		a.writeApiError(w, r, http.StatusMethodNotAllowed, "Must be a 'POST' request")
+		return
 	}
-	qr := r.URL.Query()
-	// Setup values
-	search.Path = qr.Get("path")
-	search.PathRe = qr.Get("path_re")
-	search.WhitelistTags = qr["tag_whitelist"]
-	search.BlacklistTags = qr["tag_blacklist"]
-	search.SortReverse = qr.Get("sort_reverse") == "true"
-	// Index, Count, sort need parsing
-	idxStr := qr.Get("index")
-	cntStr := qr.Get("count")
-	searchStr := qr.Get("sort")
-	if idxStr != "" {
-		var err error
-		index, err := strconv.ParseUint(idxStr, 0, 64)
-		if err != nil {
-			a.writeApiError(w, r, http.StatusBadRequest, "invalid 'index' value")
-			return
-		}
-		search.Index = int64(index)
+	if !a.requireAccountType(w, r, filedb.AccountTypeModerator) {
+		return
 	}
-	if cntStr != "" {
-		var err error
-		count, err := strconv.ParseUint(cntStr, 0, 64)
-		if err != nil {
-			a.writeApiError(w, r, http.StatusBadRequest, "invalid 'index' value")
-			return
-		}
-		// Max count value (For performance reasons) is 200
-		if count > 200 {
-			a.writeApiError(w, r, http.StatusBadRequest, "'count' cannot exceed 200")
-			return
-		}
-		search.Count = int64(count)
+	if err := r.ParseForm(); err != nil {
+		a.writeApiError(w, r, http.StatusBadRequest, fmt.Sprintf("invalid form: %v", err))
+		return
 	}
-	switch searchStr {
-	case "none", "":
-		search.SortBy = filedb.SortMethodNone
-	case "size":
-		search.SortBy = filedb.SortMethodSize
-	case "stars":
-		search.SortBy = filedb.SortMethodStars
-	case "date":
-		search.SortBy = filedb.SortMethodLastViewed
-	case "id":
-		search.SortBy = filedb.SortMethodId
-	case "random":
-		search.SortBy = filedb.SortMethodRandom
-	default:
-		a.writeApiError(w, r, http.StatusBadRequest, "invalid 'sort' value, must be one of 'none, 'size', 'stars', 'date' or 'id'")
+	index, err := parseCollectionIndex(r.PostForm.Get("index"))
+	if err != nil {
+		a.writeApiError(w, r, http.StatusBadRequest, err.Error())
 		return
 	}
-	files, err := a.db.SearchFile(search)
+	c := a.requestCollection(w, r, r.PostForm.Get("name"))
+	if c == nil {
+		return
+	}
+	files := a.requestFiles(w, r, []string{r.PostForm.Get("id")})
+	if files == nil {
+		return
+	}
+	err = a.db.MoveCollectionItem(c, files[0], index)
 	if err != nil {
-		a.writeApiError(w, r, http.StatusBadRequest, fmt.Sprintf("Search failed: %v", err))
+		a.writeApiError(w, r, http.StatusBadRequest, fmt.Sprintf("Failed to move file: %v", err))
 		return
 	}
-	a.writeApiData(w, r, a.filesToApiFile(files))
+	a.writeApiData(w, r, collectionToApi(c))
 }
 
 // Delete a tag
 //
 // Method: DELETE
 //
-// Auth: Required
+// Auth: Required, a administrator account or higher
 //
 // Headers:
 //
@@ -400,6 +1247,9 @@ func (a *DbApi1) SearchFile(w http.ResponseWriter, r *http.Request) {
 //
 // Error: None
 func (a *DbApi1) DeleteTag(w http.ResponseWriter, r *http.Request) {
+	if !a.requireAccountType(w, r, filedb.AccountTypeAdministrator) {
+		return
+	}
 	tag := r.URL.Query().Get("tag")
 	if tag == "" {
 		a.writeApiError(w, r, http.StatusBadRequest, "missing 'tag' query parameter")
@@ -417,7 +1267,7 @@ func (a *DbApi1) DeleteTag(w http.ResponseWriter, r *http.Request) {
 //
 // Method: DELETE
 //
-// Auth: Required
+// Auth: Required, a administrator account or higher
 //
 // Headers:
 //
@@ -428,6 +1278,9 @@ func (a *DbApi1) DeleteTag(w http.ResponseWriter, r *http.Request) {
 //
 // Error: None
 func (a *DbApi1) DeleteFile(w http.ResponseWriter, r *http.Request) {
+	if !a.requireAccountType(w, r, filedb.AccountTypeAdministrator) {
+		return
+	}
 	idStr := r.URL.Query().Get("id")
 	if idStr == "" {
 		a.writeApiError(w, r, http.StatusBadRequest, "missing 'id' query parameter")
@@ -495,8 +1348,9 @@ func (a *DbApi1) GetFileList(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	files, err := a.db.SearchFile(&filedb.SearchQuery{
-		Count: int64(count),
-		Index: int64(index),
+		Count:  int64(count),
+		Index:  int64(index),
+		UserId: a.requestUserId(r),
 	})
 	if err != nil {
 		a.writeApiError(w, r, http.StatusBadRequest, fmt.Sprintf("Query failed '%v'", err))
@@ -513,29 +1367,292 @@ func (a *DbApi1) GetFileList(w http.ResponseWriter, r *http.Request) {
 //
 // Headers: None
 //
-// Query Params: None
+// Query Params:
+//   - tree: If 'true' the tags are returned as a tree of namespaces & parents
 //
-// Returns: String array
+// Returns: Tag ids to tags, or a array of tag nodes if tree is set
 //
 // Error: None
 func (a *DbApi1) GetAllTags(w http.ResponseWriter, r *http.Request) {
-	a.writeApiData(w, r, a.db.GetAllTags())
+	if r.URL.Query().Get("tree") != "true" {
+		a.writeApiData(w, r, a.db.GetAllTags())
+		return
+	}
+	tree, err := a.db.GetTagTree()
+	if err != nil {
+		a.writeApiError(w, r, http.StatusInternalServerError, fmt.Sprintf("Failed to get tags: %v", err))
+		return
+	}
+	a.writeApiData(w, r, tree)
 }
 
-// # Add a tag
+// Get every tag implication rule
+//
+// Method: GET
+//
+// Auth: Required
+//
+// Headers: None
+//
+// Query Params: None
+//
+// Returns: Array of implications, ordered by tag
+//
+// Error: None
+func (a *DbApi1) GetTagImplications(w http.ResponseWriter, r *http.Request) {
+	implications, err := a.db.GetTagImplications()
+	if err != nil {
+		a.writeApiError(w, r, http.StatusInternalServerError, fmt.Sprintf("Failed to get tag implications: %v", err))
+		return
+	}
+	a.writeApiData(w, r, implications)
+}
+
+// Add a tag implication rule, files with the tag or its children get the implied tag. Files that already have the tag get it too
+//
+// Method: POST
+//
+// Auth: Required, a moderator account or higher
+//
+// Headers: None
+//
+// Form Values:
+//   - tag    : Tag the rule applies to
+//   - implies: Tag that is added
+//
+// Returns: Empty API Response
+//
+// Error: Rule already exists, tag implies itself
+func (a *DbApi1) AddTagImplication(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		a.writeApiError(w, r, http.StatusMethodNotAllowed, "Must be a 'POST' request")
+		return
+	}
+	if !a.requireAccountType(w, r, filedb.AccountTypeModerator) {
+		return
+	}
+	tag, implies := r.PostFormValue("tag"), r.PostFormValue("implies")
+	if tag == "" || implies == "" {
+		a.writeApiError(w, r, http.StatusBadRequest, "missing 'tag' or 'implies' form value")
+		return
+	}
+	err := a.db.AddTagImplication(tag, implies)
+	if err != nil {
+		a.writeApiError(w, r, http.StatusBadRequest, fmt.Sprintf("Failed to add tag implication: %v", err))
+		return
+	}
+	a.writeApiData(w, r, nil)
+}
+
+// Remove a tag implication rule, files keep the tags it added
+//
+// Method: DELETE
+//
+// Auth: Required, a moderator account or higher
+//
+// Headers: None
+//
+// Query Params:
+//   - tag    : Tag the rule applies to
+//   - implies: Tag the rule adds
+//
+// Returns: Empty API Response
+//
+// Error: Rule not found
+func (a *DbApi1) RemoveTagImplication(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		a.writeApiError(w, r, http.StatusMethodNotAllowed, "Must be a 'DELETE' request")
+		return
+	}
+	if !a.requireAccountType(w, r, filedb.AccountTypeModerator) {
+		return
+	}
+	qr := r.URL.Query()
+	err := a.db.RemoveTagImplication(qr.Get("tag"), qr.Get("implies"))
+	if err != nil {
+		a.writeApiError(w, r, http.StatusNotFound, fmt.Sprintf("Failed to remove tag implication: %v", err))
+		return
+	}
+	a.writeApiData(w, r, nil)
+}
+
+// Rename a tag & its children, implication rules & aliases are updated to use the new name
+//
+// Method: POST
+//
+// Auth: Required, a moderator account or higher
+//
+// Headers: None
+//
+// Form Values:
+//   - tag : Tag to rename
+//   - name: New name of the tag
+//
+// Returns: Empty API Response
+//
+// Error: Tag not found, new name already exists
+func (a *DbApi1) RenameTag(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		a.writeApiError(w, r, http.StatusMethodNotAllowed, "Must be a 'POST' request")
+		return
+	}
+	if !a.requireAccountType(w, r, filedb.AccountTypeModerator) {
+		return
+	}
+	tag, name := r.PostFormValue("tag"), r.PostFormValue("name")
+	if tag == "" || name == "" {
+		a.writeApiError(w, r, http.StatusBadRequest, "missing 'tag' or 'name' form value")
+		return
+	}
+	err := a.db.RenameTag(tag, name)
+	if err != nil {
+		a.writeApiError(w, r, http.StatusBadRequest, fmt.Sprintf("Failed to rename tag: %v", err))
+		return
+	}
+	a.writeApiData(w, r, nil)
+}
+
+// Merge a tag & its children into another tag, files with the tag get the other tag instead
 //
 // Method: POST
 //
+// Auth: Required, a moderator account or higher
+//
+// Headers: None
+//
+// Form Values:
+//   - tag : Tag to merge, it's removed
+//   - into: Tag it's merged into
+//
+// Returns: Empty API Response
+//
+// Error: Tag not found, merging a tag into itself
+func (a *DbApi1) MergeTags(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		a.writeApiError(w, r, http.StatusMethodNotAllowed, "Must be a 'POST' request")
+		return
+	}
+	if !a.requireAccountType(w, r, filedb.AccountTypeModerator) {
+		return
+	}
+	tag, into := r.PostFormValue("tag"), r.PostFormValue("into")
+	if tag == "" || into == "" {
+		a.writeApiError(w, r, http.StatusBadRequest, "missing 'tag' or 'into' form value")
+		return
+	}
+	err := a.db.MergeTags(tag, into)
+	if err != nil {
+		a.writeApiError(w, r, http.StatusBadRequest, fmt.Sprintf("Failed to merge tags: %v", err))
+		return
+	}
+	a.writeApiData(w, r, nil)
+}
+
+// Get every tag alias
+//
+// Method: GET
+//
 // Auth: Required
 //
 // Headers: None
 //
+// Query Params: None
+//
+// Returns: Array of aliases, ordered by tag
+//
+// Error: None
+func (a *DbApi1) GetTagAliases(w http.ResponseWriter, r *http.Request) {
+	aliases, err := a.db.GetTagAliases()
+	if err != nil {
+		a.writeApiError(w, r, http.StatusInternalServerError, fmt.Sprintf("Failed to get tag aliases: %v", err))
+		return
+	}
+	a.writeApiData(w, r, aliases)
+}
+
+// Add a tag alias, adding or searching for the alias uses the tag instead
+//
+// Method: POST
+//
+// Auth: Required, a moderator account or higher
+//
+// Headers: None
+//
+// Form Values:
+//   - alias: Alternative name
+//   - tag  : Tag the alias points to
+//
+// Returns: Empty API Response
+//
+// Error: Alias already exists, alias is a existing tag
+func (a *DbApi1) AddTagAlias(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		a.writeApiError(w, r, http.StatusMethodNotAllowed, "Must be a 'POST' request")
+		return
+	}
+	if !a.requireAccountType(w, r, filedb.AccountTypeModerator) {
+		return
+	}
+	alias, tag := r.PostFormValue("alias"), r.PostFormValue("tag")
+	if alias == "" || tag == "" {
+		a.writeApiError(w, r, http.StatusBadRequest, "missing 'alias' or 'tag' form value")
+		return
+	}
+	err := a.db.AddTagAlias(alias, tag)
+	if err != nil {
+		a.writeApiError(w, r, http.StatusBadRequest, fmt.Sprintf("Failed to add tag alias: %v", err))
+		return
+	}
+	a.writeApiData(w, r, nil)
+}
+
+// Remove a tag alias
+//
+// Method: DELETE
+//
+// Auth: Required, a moderator account or higher
+//
+// Headers: None
+//
+// Query Params:
+//   - alias: Alias to remove
+//
+// Returns: Empty API Response
+//
+// Error: Alias not found
+func (a *DbApi1) RemoveTagAlias(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		a.writeApiError(w, r, http.StatusMethodNotAllowed, "Must be a 'DELETE' request")
+		return
+	}
+	if !a.requireAccountType(w, r, filedb.AccountTypeModerator) {
+		return
+	}
+	err := a.db.RemoveTagAlias(r.URL.Query().Get("alias"))
+	if err != nil {
+		a.writeApiError(w, r, http.StatusNotFound, fmt.Sprintf("Failed to remove tag alias: %v", err))
+		return
+	}
+	a.writeApiData(w, r, nil)
+}
+
+// # Add a tag
+//
+// Method: POST
+//
+// Auth: Required, a moderator account or higher
+//
+// Headers: None
+//
 // Query Params: tag
 //
 // Returns: None
 //
 // Error: Tag already exists
 func (a *DbApi1) AddTag(w http.ResponseWriter, r *http.Request) {
+	if !a.requireAccountType(w, r, filedb.AccountTypeModerator) {
+		return
+	}
 	qr := r.URL.Query()
 	tag := qr.Get("tag")
 	if tag == "" {
@@ -550,11 +1667,11 @@ func (a *DbApi1) AddTag(w http.ResponseWriter, r *http.Request) {
 	a.writeApiData(w, r, nil)
 }
 
-// Update files last viewed time
+// Update files last viewed time for the logged in account
 //
 // Method: GET
 //
-// Auth: Required
+// Auth: Required, a user account or higher
 //
 // Headers: None
 //
@@ -568,6 +1685,9 @@ func (a *DbApi1) UpdateFileDate(w http.ResponseWriter, r *http.Request) {
 		a.writeApiError(w, r, http.StatusMethodNotAllowed, "Must be a 'GET' request")
 		return
 	}
+	if !a.requireAccountType(w, r, filedb.AccountTypeUser) {
+		return
+	}
 	qr := r.URL.Query()
 	idStr := qr.Get("id")
 	if idStr == "" {
@@ -579,11 +1699,17 @@ func (a *DbApi1) UpdateFileDate(w http.ResponseWriter, r *http.Request) {
 		a.writeApiError(w, r, http.StatusBadRequest, "invalid id")
 		return
 	}
+	user := a.requestUser(r)
 	file, err := a.db.GetFileById(int(id))
 	if err != nil {
 		a.writeApiError(w, r, http.StatusNotFound, "file not found")
 		return
 	}
+	err = a.db.LoadUserData(user, file)
+	if err != nil {
+		a.writeApiError(w, r, http.StatusInternalServerError, fmt.Sprintf("Failed to get user data: %v", err))
+		return
+	}
 	file.MarkFileRead()
 	err = a.db.UpdateFile(file)
 	if err != nil {
@@ -614,6 +1740,7 @@ func (a *DbApi1) GetRandomFile(w http.ResponseWriter, r *http.Request) {
 	file, err := a.db.SearchFile(&filedb.SearchQuery{
 		Count:  1,
 		SortBy: filedb.SortMethodRandom,
+		UserId: a.requestUserId(r),
 	})
 	if err != nil {
 		a.writeApiError(w, r, http.StatusNotFound, "file not found")
@@ -721,6 +1848,8 @@ type apiAccount struct {
 	Username         string
 	LoggedInAt       int64
 	LoggedInAtString string
+	LastSeen         int64
+	LastSeenString   string
 	IpConnectedFrom  string
 	UserAgent        string
 }
@@ -730,19 +1859,33 @@ func (a *DbApi1) GetAccounts(w http.ResponseWriter, r *http.Request) {
 		a.writeApiError(w, r, http.StatusMethodNotAllowed, "Must be a 'GET' request")
 		return
 	}
+	if !a.requireAccountType(w, r, filedb.AccountTypeAdministrator) {
+		return
+	}
 	if a.lm == nil {
 		a.writeApiError(w, r, 404, "Not using authorization")
 		return
 	}
+	sessions, err := a.db.GetSessions()
+	if err != nil {
+		a.writeApiError(w, r, http.StatusInternalServerError, fmt.Sprintf("Failed to get sessions: %v", err))
+		return
+	}
 	data := make([]apiAccount, 0)
-	for k, v := range a.lm.cookies {
+	for _, v := range sessions {
+		name := ""
+		if u, err := a.db.GetUserById(v.GetUserId()); err == nil {
+			name = u.GetName()
+		}
 		data = append(data, apiAccount{
-			Id:               k,
-			Username:         v.AccountName,
-			LoggedInAt:       v.At.UTC().Unix(),
-			LoggedInAtString: v.At.UTC().UTC().Format(time.RFC3339),
-			IpConnectedFrom:  v.IpFrom,
-			UserAgent:        v.UserAgent,
+			Id:               v.GetId(),
+			Username:         name,
+			LoggedInAt:       v.GetCreated().UTC().Unix(),
+			LoggedInAtString: v.GetCreated().UTC().Format(time.RFC3339),
+			LastSeen:         v.GetLastSeen().UTC().Unix(),
+			LastSeenString:   v.GetLastSeen().UTC().Format(time.RFC3339),
+			IpConnectedFrom:  v.GetIp(),
+			UserAgent:        v.GetUserAgent(),
 		})
 	}
 	a.writeApiData(w, r, data)
@@ -753,6 +1896,9 @@ func (a *DbApi1) RemoveCookie(w http.ResponseWriter, r *http.Request) {
 		a.writeApiError(w, r, http.StatusMethodNotAllowed, "Must be a 'GET' request")
 		return
 	}
+	if !a.requireAccountType(w, r, filedb.AccountTypeAdministrator) {
+		return
+	}
 	if a.lm == nil {
 		a.writeApiError(w, r, 404, "Not using authorization")
 		return
@@ -768,7 +1914,11 @@ func (a *DbApi1) RemoveCookie(w http.ResponseWriter, r *http.Request) {
 		a.writeApiError(w, r, 400, fmt.Sprintf("Bad 'id' query parameter: %v", err))
 		return
 	}
-	a.lm.RemoveCookie(int(id))
+	err = a.lm.RemoveCookie(int(id))
+	if err != nil {
+		a.writeApiError(w, r, 404, fmt.Sprintf("Failed to remove cookie: %v", err))
+		return
+	}
 	a.writeApiData(w, r, nil)
 }
 
@@ -782,17 +1932,45 @@ type apiLoginAttempt struct {
 	UserAgent        string
 }
 
+// Get recent login attempts, oldest first
+//
+// Query Params:
+//   - user: Only attempts for this username
+//   - ip: Only attempts from this IP
+//   - success: boolean, only successful or failed attempts
 func (a *DbApi1) LoginAttempts(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		a.writeApiError(w, r, http.StatusMethodNotAllowed, "Must be a 'GET' request")
 		return
 	}
+	if !a.requireAccountType(w, r, filedb.AccountTypeAdministrator) {
+		return
+	}
 	if a.lm == nil {
 		a.writeApiError(w, r, 404, "Not using authorization")
 		return
 	}
+	qr := r.URL.Query()
+	var success *bool
+	if successStr := qr.Get("success"); successStr != "" {
+		v, err := strconv.ParseBool(successStr)
+		if err != nil {
+			a.writeApiError(w, r, 400, fmt.Sprintf("Bad 'success' query parameter: %v", err))
+			return
+		}
+		success = &v
+	}
 	data := make([]apiLoginAttempt, 0)
-	for _, v := range a.lm.loginAttempts {
+	for _, v := range a.lm.LoginAttempts() {
+		if qr.Has("user") && v.AccoutName != qr.Get("user") {
+			continue
+		}
+		if qr.Has("ip") && v.Ip != qr.Get("ip") {
+			continue
+		}
+		if success != nil && v.Success != *success {
+			continue
+		}
 		data = append(data, apiLoginAttempt{
 			Username:         v.AccoutName,
 			LoggedInAt:       v.At.UTC().Unix(),
@@ -806,19 +1984,45 @@ func (a *DbApi1) LoginAttempts(w http.ResponseWriter, r *http.Request) {
 	a.writeApiData(w, r, data)
 }
 
-func NewFileDbApi(db *filedb.FileDb, mux *http.ServeMux, lm *LoginManager) *DbApi1 {
+// Create the API, user is who stars & last viewed times belong to when lm is nil (No authentication).
+// thumbs is where thumbnails are cached, nil disables them.
+func NewFileDbApi(db *filedb.FileDb, mux *http.ServeMux, lm *LoginManager, user *filedb.User, thumbs *thumbnail.Cache) *DbApi1 {
 	api := &DbApi1{
-		db: db,
-		lm: lm,
+		db:     db,
+		lm:     lm,
+		user:   user,
+		thumbs: thumbs,
 	}
 	mux.HandleFunc("/api/1/content", api.ServeFile)
+	mux.HandleFunc("/api/1/thumbnail", api.ServeThumbnail)
 	mux.HandleFunc("/api/1/files", api.GetFileInfo)
+	mux.HandleFunc("/api/1/similar", api.GetSimilarFiles)
 	mux.HandleFunc("/api/1/update", api.UpdateFile)
 	mux.HandleFunc("/api/1/search", api.SearchFile)
+	mux.HandleFunc("/api/1/savedsearches", api.GetSavedSearches)
+	mux.HandleFunc("/api/1/savedsearch", api.RunSavedSearch)
+	mux.HandleFunc("/api/1/addsavedsearch", api.AddSavedSearch)
+	mux.HandleFunc("/api/1/removesavedsearch", api.RemoveSavedSearch)
+	mux.HandleFunc("/api/1/collections", api.GetCollections)
+	mux.HandleFunc("/api/1/collection", api.GetCollection)
+	mux.HandleFunc("/api/1/addcollection", api.AddCollection)
+	mux.HandleFunc("/api/1/updatecollection", api.UpdateCollection)
+	mux.HandleFunc("/api/1/removecollection", api.RemoveCollection)
+	mux.HandleFunc("/api/1/addcollectionitems", api.AddCollectionItems)
+	mux.HandleFunc("/api/1/removecollectionitems", api.RemoveCollectionItems)
+	mux.HandleFunc("/api/1/movecollectionitem", api.MoveCollectionItem)
 	mux.HandleFunc("/api/1/deletetag", api.DeleteTag)
 	mux.HandleFunc("/api/1/deletefile", api.DeleteFile)
 	mux.HandleFunc("/api/1/tags", api.GetAllTags)
 	mux.HandleFunc("/api/1/addtag", api.AddTag)
+	mux.HandleFunc("/api/1/tagimplications", api.GetTagImplications)
+	mux.HandleFunc("/api/1/addtagimplication", api.AddTagImplication)
+	mux.HandleFunc("/api/1/removetagimplication", api.RemoveTagImplication)
+	mux.HandleFunc("/api/1/renametag", api.RenameTag)
+	mux.HandleFunc("/api/1/mergetags", api.MergeTags)
+	mux.HandleFunc("/api/1/tagaliases", api.GetTagAliases)
+	mux.HandleFunc("/api/1/addtagalias", api.AddTagAlias)
+	mux.HandleFunc("/api/1/removetagalias", api.RemoveTagAlias)
 	mux.HandleFunc("/api/1/viewed", api.UpdateFileDate)
 	mux.HandleFunc("/api/1/status", api.GetStatus)
 	// Test stuff
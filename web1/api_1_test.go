@@ -0,0 +1,94 @@
+package web1
+
+import (
+	"encoding/json"
+	"mediamanager/filedb"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Make a request as user without authentication, returns the code of the API response
+func apiRequest(t *testing.T, db *filedb.FileDb, user *filedb.User, method, target string, form url.Values) int {
+	mux := http.NewServeMux()
+	NewFileDbApi(db, mux, nil, user, nil)
+	var r *http.Request
+	if form != nil {
+		r = httptest.NewRequest(method, target, strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	} else {
+		r = httptest.NewRequest(method, target, nil)
+	}
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, r)
+	res := apiBase{}
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatalf("%s %s: invalid response '%s': %v", method, target, w.Body.String(), err)
+	}
+	return res.Code
+}
+
+func TestAccountTypes(t *testing.T) {
+	db, err := filedb.NewFileDb(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer db.Close()
+	users := make(map[filedb.AccountType]*filedb.User)
+	for _, a := range []filedb.AccountType{filedb.AccountTypeGuest, filedb.AccountTypeUser, filedb.AccountTypeModerator, filedb.AccountTypeAdministrator} {
+		if users[a], err = db.AddUser(a.String(), a); err != nil {
+			t.Fatalf("Failed to add user: %v", err)
+		}
+	}
+	f := filedb.NewFile("/a.mp4")
+	if err = f.AddTag("tag"); err != nil {
+		t.Fatalf("Failed to add tag: %v", err)
+	}
+	if _, err = db.AddFiles(f); err != nil {
+		t.Fatalf("Failed to add file: %v", err)
+	}
+	if f, err = db.GetFileByPath("/a.mp4"); err != nil {
+		t.Fatalf("Failed to get file: %v", err)
+	}
+	id := strconv.Itoa(f.GetId())
+
+	// Every account type below the required one is rejected
+	endpoints := []struct {
+		method string
+		target string
+		form   url.Values
+		need   filedb.AccountType
+	}{
+		{"POST", "/api/1/update", url.Values{"Id": {id}, "Stars": {"3"}}, filedb.AccountTypeUser},
+		{"GET", "/api/1/viewed?id=" + id, nil, filedb.AccountTypeUser},
+		{"POST", "/api/1/addsavedsearch", url.Values{"name": {"s"}, "q": {"tag"}}, filedb.AccountTypeUser},
+		{"POST", "/api/1/update", url.Values{"Id": {id}, "AddTags": {"other"}}, filedb.AccountTypeModerator},
+		{"GET", "/api/1/addtag?tag=new", nil, filedb.AccountTypeModerator},
+		{"POST", "/api/1/renametag", url.Values{"tag": {"other"}, "name": {"renamed"}}, filedb.AccountTypeModerator},
+		{"POST", "/api/1/addtagalias", url.Values{"alias": {"alias"}, "tag": {"tag"}}, filedb.AccountTypeModerator},
+		{"POST", "/api/1/addcollection", url.Values{"name": {"c"}}, filedb.AccountTypeAdministrator},
+		{"GET", "/api/1/cookies", nil, filedb.AccountTypeAdministrator},
+		{"GET", "/api/1/removecookie?id=1", nil, filedb.AccountTypeAdministrator},
+		{"GET", "/api/1/loginattempts", nil, filedb.AccountTypeAdministrator},
+		{"DELETE", "/api/1/deletetag?tag=new", nil, filedb.AccountTypeAdministrator},
+		{"DELETE", "/api/1/deletefile?id=" + id, nil, filedb.AccountTypeAdministrator},
+	}
+	for _, e := range endpoints {
+		for a := filedb.AccountTypeGuest; a < e.need; a++ {
+			assert.Equalf(t, http.StatusForbidden, apiRequest(t, db, users[a], e.method, e.target, e.form), "%s %s was allowed for a %s", e.method, e.target, a)
+		}
+		// Endpoints about logins are 404 without authentication, once the account type is allowed
+		assert.NotEqualf(t, http.StatusForbidden, apiRequest(t, db, users[e.need], e.method, e.target, e.form), "%s %s wasn't allowed for a %s", e.method, e.target, e.need)
+	}
+	_, err = db.GetFileById(f.GetId())
+	assert.Errorf(t, err, "File wasn't deleted by a administrator")
+	// Guests can still browse
+	assert.Equalf(t, http.StatusOK, apiRequest(t, db, users[filedb.AccountTypeGuest], "GET", "/api/1/tags", nil), "Guest couldn't list tags")
+	assert.Equalf(t, http.StatusUnauthorized, apiRequest(t, db, nil, "GET", "/api/1/addtag?tag=x", nil), "Request without a account was allowed")
+}
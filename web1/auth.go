@@ -1,27 +1,25 @@
 package web1
 
 import (
-	"crypto/rand"
-	"encoding/base64"
 	"errors"
 	"fmt"
 	"log/slog"
+	"mediamanager/filedb"
+	"net"
 	"net/http"
+	"strconv"
 	"sync"
 	"time"
 )
 
-type account struct {
-	Username string
-	Password string
-}
+// Name of the session cookie
+const sessionCookie = "filedb_account"
 
-type cookie struct {
-	AccountName string
-	Value       string
-	At          time.Time
-	IpFrom      string
-	UserAgent   string
+// Session settings of a LoginManager
+type SessionOpts struct {
+	IdleTimeout     time.Duration // Sessions not used for this long expire, 0 to disable
+	AbsoluteTimeout time.Duration // Sessions expire this long after logging in, 0 to disable
+	Secure          bool          // Only send the session cookie over HTTPS
 }
 
 type loginAttempt struct {
@@ -33,24 +31,63 @@ type loginAttempt struct {
 	UserAgent    string
 }
 
+// Sessions are stored in the database so they last through restarts.
 type LoginManager struct {
-	accountLock       sync.Mutex
-	accounts          []*account
-	cookieLock        sync.Mutex
-	cookies           map[int]*cookie
-	cookieIndex       int
+	db                *filedb.FileDb
+	opts              SessionOpts
 	loginPageData     []byte
-	loginAttempts     []*loginAttempt
+	loginAttempts     attemptLog
 	loginAttemptsLock sync.Mutex
+	throttle          *loginThrottle
 }
 
-func (l *LoginManager) CookieToAccount(cookie string) (string, error) {
-	for _, c := range l.cookies {
-		if c.Value == cookie {
-			return c.AccountName, nil
+// Address of a request without the port
+func remoteHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// Get the session of a request, nil if there isn't a valid one.
+//
+// Sessions are bound to the address & User-Agent they were created with, expired sessions are removed.
+func (l *LoginManager) requestSession(r *http.Request) *filedb.Session {
+	c, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return nil
+	}
+	s, err := l.db.GetSession(c.Value)
+	if err != nil {
+		return nil
+	}
+	if s.IsExpired(l.opts.IdleTimeout, l.opts.AbsoluteTimeout) {
+		slog.Info("Session expired", "Session.Id", s.GetId(), "Session.Created", s.GetCreated(), "Session.LastSeen", s.GetLastSeen())
+		l.db.RemoveSession(s)
+		return nil
+	}
+	if s.GetIp() != remoteHost(r) || s.GetUserAgent() != r.UserAgent() {
+		slog.Warn("Session used from a different client", "Session.Id", s.GetId(), "Ip", remoteHost(r), "UserAgent", r.UserAgent(), "Session.Ip", s.GetIp(), "Session.UserAgent", s.GetUserAgent())
+		return nil
+	}
+	// Don't write to the database on every request
+	if time.Since(s.GetLastSeen()) >= time.Minute {
+		err = l.db.TouchSession(s)
+		if err != nil {
+			slog.Warn("Failed to update session last seen time", "Session.Id", s.GetId(), "Error", err.Error())
 		}
 	}
-	return "", errors.New("account not found")
+	return s
+}
+
+// Get the user that is logged in for a request
+func (l *LoginManager) RequestUser(r *http.Request) (*filedb.User, error) {
+	s := l.requestSession(r)
+	if s == nil {
+		return nil, errors.New("not logged in")
+	}
+	return l.db.GetUserById(s.GetUserId())
 }
 
 func (l *LoginManager) authenticate(w http.ResponseWriter, r *http.Request) bool {
@@ -65,17 +102,9 @@ func (l *LoginManager) authenticate(w http.ResponseWriter, r *http.Request) bool
 		redirectTo = "/"
 	}
 	slog.Debug("Checking cookies")
-	for _, c := range r.Cookies() {
-		if c.Name == "filedb_account" {
-			l.cookieLock.Lock()
-			defer l.cookieLock.Unlock()
-			for _, v := range l.cookies {
-				if v.Value == c.Value {
-					slog.Debug("Found cookie")
-					return true
-				}
-			}
-		}
+	if l.requestSession(r) != nil {
+		slog.Debug("Found cookie")
+		return true
 	}
 	slog.Debug("Sending to /login page")
 	// Redirect to login
@@ -83,79 +112,68 @@ func (l *LoginManager) authenticate(w http.ResponseWriter, r *http.Request) bool
 	return false
 }
 
-func (l *LoginManager) addAccountCookie(w http.ResponseWriter, r *http.Request, account *account) bool {
-	_ = r
-	buffer := make([]byte, 128)
-	_, err := rand.Read(buffer)
+func (l *LoginManager) addAccountCookie(w http.ResponseWriter, r *http.Request, user *filedb.User) bool {
+	// Good time to clean up
+	if _, err := l.db.RemoveExpiredSessions(l.opts.IdleTimeout, l.opts.AbsoluteTimeout); err != nil {
+		slog.Warn("Failed to remove expired sessions", "Error", err.Error())
+	}
+	token, s, err := l.db.AddSession(user, remoteHost(r), r.UserAgent())
 	if err != nil {
-		slog.Error("Failed to read account cookie", "Error", err.Error())
+		slog.Error("Failed to create session", "Account", user.GetName(), "Error", err.Error())
 		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte("random reader failed for cookie"))
+		w.Write([]byte("failed to create session"))
 		return false
 	}
-	cook := &cookie{
-		AccountName: account.Username,
-		At:          time.Now(),
-		IpFrom:      r.RemoteAddr,
-		UserAgent:   r.UserAgent(),
-	}
-	cook.Value = base64.StdEncoding.EncodeToString(buffer)
-	l.cookieLock.Lock()
-	defer l.cookieLock.Unlock()
-	l.cookies[l.cookieIndex] = cook
-	l.cookieIndex++
-	// Probably set more cookie values
+	slog.Info("Created session", "Account", user.GetName(), "Session.Id", s.GetId(), "Ip", s.GetIp())
 	http.SetCookie(w, &http.Cookie{
-		Name:   "filedb_account",
-		Value:  cook.Value,
-		Secure: false,
+		Name:     sessionCookie,
+		Value:    token,
+		Path:     "/",
+		MaxAge:   int(l.opts.AbsoluteTimeout.Seconds()),
+		Secure:   l.opts.Secure,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
 	})
 	return true
 }
 
+// Record a login attempt
+func (l *LoginManager) addAttempt(r *http.Request, user string, success bool, reasonFailed string) {
+	l.loginAttemptsLock.Lock()
+	defer l.loginAttemptsLock.Unlock()
+	l.loginAttempts.add(&loginAttempt{
+		AccoutName:   user,
+		At:           time.Now().UTC(),
+		Ip:           remoteHost(r),
+		Success:      success,
+		ReasonFailed: reasonFailed,
+		UserAgent:    r.UserAgent(),
+	})
+}
+
+// Get the recorded login attempts, oldest first
+func (l *LoginManager) LoginAttempts() []*loginAttempt {
+	l.loginAttemptsLock.Lock()
+	defer l.loginAttemptsLock.Unlock()
+	return l.loginAttempts.all()
+}
+
 func (l *LoginManager) authPage(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "POST" {
-		l.loginAttemptsLock.Lock()
-		defer l.loginAttemptsLock.Unlock()
-		l.loginAttempts = append(l.loginAttempts, &loginAttempt{
-			AccoutName:   "",
-			At:           time.Now().UTC(),
-			Ip:           r.RemoteAddr,
-			Success:      false,
-			ReasonFailed: fmt.Sprintf("Incorrect method '%s'", r.Method),
-			UserAgent:    r.UserAgent(),
-		})
+		l.addAttempt(r, "", false, fmt.Sprintf("Incorrect method '%s'", r.Method))
 		w.WriteHeader(405)
 		return
 	}
 	user := r.PostFormValue("username")
 	if user == "" {
-		l.loginAttemptsLock.Lock()
-		defer l.loginAttemptsLock.Unlock()
-		l.loginAttempts = append(l.loginAttempts, &loginAttempt{
-			AccoutName:   "",
-			At:           time.Now().UTC(),
-			Ip:           r.RemoteAddr,
-			Success:      false,
-			ReasonFailed: "Missing 'username' value",
-			UserAgent:    r.UserAgent(),
-		})
+		l.addAttempt(r, "", false, "Missing 'username' value")
 		w.WriteHeader(401)
 		w.Write([]byte("missing 'username' value"))
 		return
 	}
 	password := r.PostFormValue("password")
 	if password == "" {
-		l.loginAttemptsLock.Lock()
-		defer l.loginAttemptsLock.Unlock()
-		l.loginAttempts = append(l.loginAttempts, &loginAttempt{
-			AccoutName:   user,
-			At:           time.Now().UTC(),
-			Ip:           r.RemoteAddr,
-			Success:      false,
-			ReasonFailed: "Missing 'password' value",
-			UserAgent:    r.UserAgent(),
-		})
+		l.addAttempt(r, user, false, "Missing 'password' value")
 		w.WriteHeader(401)
 		w.Write([]byte("missing 'password' value"))
 		return
@@ -164,57 +182,32 @@ func (l *LoginManager) authPage(w http.ResponseWriter, r *http.Request) {
 	if redirect == "" {
 		redirect = "/"
 	}
-	l.accountLock.Lock()
-	defer l.accountLock.Unlock()
-	for _, v := range l.accounts {
-		if v.Username == user {
-			if v.Password == password {
-				// Create cookie
-				if l.addAccountCookie(w, r, v) {
-					l.loginAttemptsLock.Lock()
-					defer l.loginAttemptsLock.Unlock()
-					l.loginAttempts = append(l.loginAttempts, &loginAttempt{
-						AccoutName:   user,
-						At:           time.Now().UTC(),
-						Ip:           r.RemoteAddr,
-						Success:      true,
-						ReasonFailed: "",
-						UserAgent:    r.UserAgent(),
-					})
-					r.Method = "GET"
-					w.Write([]byte(fmt.Sprintf("<!DOCTYPE html><style>body {background-color: #282a36;color: #f8f8f2;}</style><html><body><a href=\"%s\">Redirecting in 1 second if not click here.</a>\n<meta http-equiv=\"Refresh\" content=\"1; url='%s'\" /></body></html>", redirect, redirect)))
-					return
-				}
-				return
-			}
-			// Invalid password
-			w.WriteHeader(http.StatusUnauthorized)
-			w.Write([]byte("invalid account"))
-			l.loginAttemptsLock.Lock()
-			defer l.loginAttemptsLock.Unlock()
-			l.loginAttempts = append(l.loginAttempts, &loginAttempt{
-				AccoutName:   user,
-				At:           time.Now().UTC(),
-				Ip:           r.RemoteAddr,
-				Success:      false,
-				ReasonFailed: "Invalid password",
-				UserAgent:    r.UserAgent(),
-			})
-			return
-		}
+	throttleKeys := []string{"ip:" + remoteHost(r), "user:" + user}
+	if allowed, retry := l.throttle.check(throttleKeys...); !allowed {
+		seconds := int(time.Until(retry).Seconds()) + 1
+		l.addAttempt(r, user, false, "Too many failed logins")
+		w.Header().Set("Retry-After", strconv.Itoa(seconds))
+		w.WriteHeader(http.StatusTooManyRequests)
+		w.Write([]byte(fmt.Sprintf("too many failed logins, try again in %d seconds", seconds)))
+		return
 	}
-	l.loginAttemptsLock.Lock()
-	defer l.loginAttemptsLock.Unlock()
-	l.loginAttempts = append(l.loginAttempts, &loginAttempt{
-		AccoutName:   user,
-		At:           time.Now().UTC(),
-		Ip:           r.RemoteAddr,
-		Success:      false,
-		ReasonFailed: "Invalid password",
-		UserAgent:    r.UserAgent(),
-	})
-	w.WriteHeader(http.StatusUnauthorized)
-	w.Write([]byte("invalid account"))
+	u, err := l.db.CheckPassword(user, password)
+	if err != nil {
+		l.throttle.fail(throttleKeys...)
+		l.addAttempt(r, user, false, "Invalid password")
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("invalid account"))
+		return
+	}
+	// Create cookie
+	if !l.addAccountCookie(w, r, u) {
+		return
+	}
+	// The IP's failures are kept until they expire, otherwise logging into any account would let it keep guessing others
+	l.throttle.reset("user:" + user)
+	l.addAttempt(r, user, true, "")
+	r.Method = "GET"
+	w.Write([]byte(fmt.Sprintf("<!DOCTYPE html><style>body {background-color: #282a36;color: #f8f8f2;}</style><html><body><a href=\"%s\">Redirecting in 1 second if not click here.</a>\n<meta http-equiv=\"Refresh\" content=\"1; url='%s'\" /></body></html>", redirect, redirect)))
 }
 
 func (l *LoginManager) HttpHandler(next http.Handler) http.Handler {
@@ -232,43 +225,21 @@ func (l *LoginManager) HttpHandler(next http.Handler) http.Handler {
 	})
 }
 
-func (l *LoginManager) AddAccount(user string, password string) error {
-	l.accountLock.Lock()
-	defer l.accountLock.Unlock()
-	for _, v := range l.accounts {
-		if v.Username == user {
-			return errors.New("account exists")
-		}
-	}
-	l.accounts = append(l.accounts, &account{
-		Username: user,
-		Password: password,
-	})
-	return nil
-}
-
+// Remove a session by ID, logging it out
 func (l *LoginManager) RemoveCookie(id int) error {
-	l.cookieLock.Lock()
-	defer l.cookieLock.Unlock()
-	delete(l.cookies, id)
-	return nil
+	s, err := l.db.GetSessionById(id)
+	if err != nil {
+		return err
+	}
+	return l.db.RemoveSession(s)
 }
 
 func (l *LoginManager) loginPage(w http.ResponseWriter, r *http.Request) {
-	for _, c := range r.Cookies() {
-		if c.Name == "filedb_account" {
-			l.cookieLock.Lock()
-			defer l.cookieLock.Unlock()
-			for _, v := range l.cookies {
-				// TODO: Check IpFrom and User-Agent
-				if v.Value == c.Value {
-					slog.Debug("Found cookie")
-					// Why are we still on the login page...?
-					http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
-					return
-				}
-			}
-		}
+	if l.requestSession(r) != nil {
+		slog.Debug("Found cookie")
+		// Why are we still on the login page...?
+		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+		return
 	}
 	w.Write(l.loginPageData)
 }
@@ -5,16 +5,15 @@ package web1
 
 import (
 	"fmt"
+	"mediamanager/filedb"
 	"net/http"
-	"sync"
 )
 
-func NewLoginManager(mux *http.ServeMux, live bool) *LoginManager {
+func NewLoginManager(mux *http.ServeMux, live bool, db *filedb.FileDb, opts SessionOpts) *LoginManager {
 	lm := &LoginManager{
-		accountLock: sync.Mutex{},
-		accounts:    make([]*account, 0),
-		cookieLock:  sync.Mutex{},
-		cookies:     make(map[int]*cookie),
+		db:       db,
+		opts:     opts,
+		throttle: newLoginThrottle(),
 	}
 	var err error
 	lm.loginPageData, err = webFs.ReadFile("frontend/login.html")
@@ -58,6 +58,15 @@ export class MMFile {
     getSize() {
         return this.file.Size;
     }
+    /**
+     * MIME type found when the file was imported, empty if it isn't known
+     */
+    getMime() {
+        return this.file.Mime || "";
+    }
+    getCategory() {
+        return this.file.Category || "";
+    }
     getContentUri() {
         return `/api/1/content?id=${this.file.Id}`;
     }
@@ -81,7 +90,9 @@ export class MMFile {
             Path: this.file.Path,
             Size: this.file.Size,
             Stars: this.file.Stars,
-            Tags: []
+            Tags: [],
+            Mime: this.file.Mime,
+            Category: this.file.Category
         };
         this.file.Tags.forEach(v => {
             newFile.Tags.push(v);
@@ -131,6 +142,13 @@ function indexedObjectToArray(obj) {
 export function apiGetContentUri(id, update = false) {
     return `/api/1/content?id=${id}&update=${update}`;
 }
+export function apiGetThumbnailUri(id, size = 128) {
+    return `/api/1/thumbnail?id=${id}&size=${size}`;
+}
+// Thumbnails can only be made of JPEG, PNG & GIF files
+export function apiHasThumbnail(path) {
+    return /\.(jpe?g|png|gif)$/i.test(path);
+}
 export function bytesToHumanReadableSize(size) {
     if (size >= 1e12) {
         return `${(size / 1e12).toFixed(2)} TB`;
@@ -269,6 +287,9 @@ export function apiSearch(qr) {
             if (qr.SortReverse == true) {
                 uri += `sort_reverse=true&`;
             }
+            if (qr.Query != undefined) {
+                uri += `q=${encodeURIComponent(qr.Query)}&`;
+            }
             uri = uri.substring(0, uri.length - 1);
             apiRequest(uri).then((data) => {
                 let files = [];
@@ -326,6 +347,17 @@ export function apiGetTags() {
         });
     });
 }
+export function apiGetTagTree() {
+    return __awaiter(this, void 0, void 0, function* () {
+        return new Promise((resolve, reject) => {
+            apiRequest(`/api/1/tags?tree=true`).then((data) => {
+                resolve(data.Data);
+            }).catch((err) => {
+                reject(err);
+            });
+        });
+    });
+}
 export function apiAddTag(tag) {
     return __awaiter(this, void 0, void 0, function* () {
         return new Promise((resolve, reject) => {
@@ -348,56 +380,42 @@ export function apiUpdateFileViewed(id) {
         });
     });
 }
-function pushToUndefined(array, value) {
-    for (const i in array) {
-        if (array[i] === undefined) {
-            array[i] = value;
-            return array;
-        }
-    }
-    // No undefined values, push
-    console.log(`Push`, value);
-    array.push(value);
-    return array;
+/**
+ * Get every collection, or the collections a file is in
+ * @param fileId Only get the collections this file is in
+ */
+export function apiGetCollections(fileId) {
+    return __awaiter(this, void 0, void 0, function* () {
+        return new Promise((resolve, reject) => {
+            let uri = "/api/1/collections";
+            if (fileId != undefined) {
+                uri += `?id=${fileId}`;
+            }
+            apiRequest(uri).then((data) => {
+                resolve(data.Data);
+            }).catch((err) => {
+                reject(err);
+            });
+        });
+    });
 }
-export function apiGetCollection(name, namespace = "collection") {
+/**
+ * Get the files of a collection in order
+ * @param name Collection name
+ */
+export function apiGetCollection(name) {
     return __awaiter(this, void 0, void 0, function* () {
-        return new Promise((resolve, reject) => __awaiter(this, void 0, void 0, function* () {
-            const files = yield apiSearch({
-                "TagWhitelist": [`${namespace}:${name}`]
+        return new Promise((resolve, reject) => {
+            apiRequest(`/api/1/collection?name=${encodeURIComponent(name)}`).then((data) => {
+                let files = [];
+                data.Data.Files.forEach(element => {
+                    files.push(new MMFile(element));
+                });
+                resolve(files);
+            }).catch((err) => {
+                reject(err);
             });
-            let orderedFiles = [];
-            let noOrder = [];
-            // If we have colindex we move the value to that index, given its a valid index, otherwise we reject with a ordering error
-            for (const element of files) {
-                let addedToArray = false;
-                for (const t of element.getTags()) {
-                    if (t.startsWith("colindex:")) {
-                        // Get the index value
-                        let n = Number(t.split(":")[1]);
-                        if (isNaN(n)) {
-                            reject(`Expected colindex value to be a number, but it was parsed as NaN`);
-                            return;
-                        }
-                        // Make sure its not already filled
-                        if (orderedFiles[n] != undefined) {
-                            reject(`Duplicate colindex: ${n}`);
-                            return;
-                        }
-                        orderedFiles[n] = element;
-                        addedToArray = true;
-                    }
-                }
-                if (!addedToArray) {
-                    noOrder.push(element);
-                }
-            }
-            for (const element of noOrder) {
-                orderedFiles = pushToUndefined(orderedFiles, element);
-            }
-            console.log(orderedFiles);
-            resolve(orderedFiles);
-        }));
+        });
     });
 }
 /**
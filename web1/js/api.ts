@@ -32,6 +32,40 @@ type apiFile = {
      * File size in bytes
      */
     Size: number
+    /**
+     * MIME type like 'video/mp4', empty if it isn't known
+     */
+    Mime: string
+    /**
+     * image, audio or video, empty if it isn't known
+     */
+    Category: string
+}
+
+/**
+ * Represents a collection via the API
+ */
+export type apiCollection = {
+    Id: number
+    Name: string
+    Description: string
+    /**
+     * File id of the cover, 0 if there isn't one
+     */
+    CoverId: number
+    /**
+     * Number of files in the collection
+     */
+    Count: number
+    /**
+     * RFC3339 format UTC time string
+     */
+    CreatedString: string
+}
+
+type apiCollectionFiles = {
+    Collection: apiCollection
+    Files: apiFile[]
 }
 
 type apiVersion = {
@@ -112,6 +146,17 @@ export class MMFile {
         return this.file.Size
     }
 
+    /**
+     * MIME type found when the file was imported, empty if it isn't known
+     */
+    public getMime(): string {
+        return this.file.Mime || ""
+    }
+
+    public getCategory(): string {
+        return this.file.Category || ""
+    }
+
     public getContentUri(): string {
         return `/api/1/content?id=${this.file.Id}`
     }
@@ -135,7 +180,9 @@ export class MMFile {
             Path: this.file.Path,
             Size: this.file.Size,
             Stars: this.file.Stars,
-            Tags: []
+            Tags: [],
+            Mime: this.file.Mime,
+            Category: this.file.Category
         }
         this.file.Tags.forEach(v => {
             newFile.Tags.push(v)
@@ -187,6 +234,15 @@ export function apiGetContentUri(id: number, update = false) {
     return `/api/1/content?id=${id}&update=${update}`
 }
 
+export function apiGetThumbnailUri(id: number, size = 128) {
+    return `/api/1/thumbnail?id=${id}&size=${size}`
+}
+
+// Thumbnails can only be made of JPEG, PNG & GIF files
+export function apiHasThumbnail(path: string): boolean {
+    return /\.(jpe?g|png|gif)$/i.test(path)
+}
+
 export function bytesToHumanReadableSize(size: number): string {
    if (size >= 1e12) {
         return `${(size/1e12).toFixed(2)} TB`
@@ -293,7 +349,8 @@ export type searchQuery = {
     Index?: number,
     Count?: number,
     Sort?: "none" | "size" | "stars" | "date" | "id" | "random",
-    SortReverse?: boolean
+    SortReverse?: boolean,
+    Query?: string
 }
 
 export async function apiSearch(qr: searchQuery): Promise<MMFile[]> {
@@ -330,6 +387,9 @@ export async function apiSearch(qr: searchQuery): Promise<MMFile[]> {
         if(qr.SortReverse == true) {
             uri += `sort_reverse=true&`
         }
+        if(qr.Query != undefined) {
+            uri += `q=${encodeURIComponent(qr.Query)}&`
+        }
         uri = uri.substring(0, uri.length - 1)
         apiRequest(uri).then((data) => {
             let files: MMFile[] = [];
@@ -384,6 +444,28 @@ export async function apiGetTags(): Promise<string[]> {
     })
 }
 
+/**
+ * A tag in the tag tree, namespaces have a empty Tag & parents that aren't tags have a Id of 0
+ */
+export type apiTagNode = {
+    Name: string,
+    Tag: string,
+    Id: number,
+    Implies: string[],
+    Aliases: string[],
+    Children: apiTagNode[]
+}
+
+export async function apiGetTagTree(): Promise<apiTagNode[]> {
+    return new Promise((resolve, reject) => {
+        apiRequest(`/api/1/tags?tree=true`).then((data) => {
+            resolve(data.Data as apiTagNode[])
+        }).catch((err) => {
+            reject(err)
+        })
+    })
+}
+
 export async function apiAddTag(tag: string): Promise<void> {
     return new Promise((resolve, reject) => {
         apiRequest(`/api/1/addtag?tag=${tag}`).then((data) => {
@@ -404,55 +486,39 @@ export async function apiUpdateFileViewed(id: number): Promise<void> {
     })
 }
 
-function pushToUndefined<T>(array: T[], value: T): T[] {
-    for (const i in array) {
-        if(array[i] === undefined) {
-            array[i] = value
-            return array
+/**
+ * Get every collection, or the collections a file is in
+ * @param fileId Only get the collections this file is in
+ */
+export async function apiGetCollections(fileId?: number): Promise<apiCollection[]> {
+    return new Promise((resolve, reject) => {
+        let uri = "/api/1/collections"
+        if (fileId != undefined) {
+            uri += `?id=${fileId}`
         }
-    }
-    // No undefined values, push
-    console.log(`Push`, value)
-    array.push(value)
-    return array
+        apiRequest(uri).then((data) => {
+            resolve(data.Data as apiCollection[])
+        }).catch((err) => {
+            reject(err)
+        })
+    })
 }
 
-export async function apiGetCollection(name: string, namespace="collection"): Promise<MMFile[]> {
-    return new Promise(async (resolve, reject) => {
-        const files = await apiSearch({
-            "TagWhitelist": [`${namespace}:${name}`]
+/**
+ * Get the files of a collection in order
+ * @param name Collection name
+ */
+export async function apiGetCollection(name: string): Promise<MMFile[]> {
+    return new Promise((resolve, reject) => {
+        apiRequest(`/api/1/collection?name=${encodeURIComponent(name)}`).then((data) => {
+            let files: MMFile[] = [];
+            (data.Data as apiCollectionFiles).Files.forEach(element => {
+                files.push(new MMFile(element))
+            });
+            resolve(files)
+        }).catch((err) => {
+            reject(err)
         })
-        let orderedFiles: MMFile[] = []
-        let noOrder: MMFile[] = []
-        // If we have colindex we move the value to that index, given its a valid index, otherwise we reject with a ordering error
-        for (const element of files) {
-            let addedToArray = false
-            for (const t of element.getTags()) {
-                if(t.startsWith("colindex:")) {
-                    // Get the index value
-                    let n = Number(t.split(":")[1])
-                    if (isNaN(n)) {
-                        reject(`Expected colindex value to be a number, but it was parsed as NaN`)
-                        return
-                    }
-                    // Make sure its not already filled
-                    if (orderedFiles[n] != undefined) {
-                        reject(`Duplicate colindex: ${n}`)
-                        return
-                    }
-                    orderedFiles[n] = element
-                    addedToArray = true
-                }
-            } 
-            if(!addedToArray) {
-                noOrder.push(element)
-            }
-        }
-        for(const element of noOrder) {
-            orderedFiles = pushToUndefined(orderedFiles, element)
-        }
-        console.log(orderedFiles)
-        resolve(orderedFiles)
     })
 }
 
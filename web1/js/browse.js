@@ -7,7 +7,7 @@ var __awaiter = (this && this.__awaiter) || function (thisArg, _arguments, P, ge
         step((generator = generator.apply(thisArg, _arguments || [])).next());
     });
 };
-import { apiGetRandomFile, apiSearch, bytesToHumanReadableSize, getCookie, setCookie } from "./api.js";
+import { apiGetRandomFile, apiGetThumbnailUri, apiHasThumbnail, apiSearch, bytesToHumanReadableSize, getCookie, setCookie } from "./api.js";
 // TODO: Set cookies based on what we've recently look at & our recent settings os we can actually make this work
 class FileManager {
     // TODO: Popup window
@@ -23,6 +23,7 @@ class FileManager {
         this.tag_whitelist = [];
         this.tag_blacklist = [];
         this.query = "";
+        this.filter = "";
         this.sortMethod = "stars";
         this.sortReverse = true;
         this.openInNewTab = true;
@@ -42,6 +43,15 @@ class FileManager {
         name.innerText = `${file.getId()}`;
         // TODO: Some javascript to pop this file up
         let path = document.createElement("td");
+        if (apiHasThumbnail(file.getPath())) {
+            let thumb = document.createElement("img");
+            thumb.classList.add("thumbnail");
+            thumb.loading = "lazy";
+            thumb.src = apiGetThumbnailUri(file.getId());
+            // Files without hashes have no thumbnail
+            thumb.onerror = () => thumb.remove();
+            path.appendChild(thumb);
+        }
         let pathA = document.createElement("a");
         // TODO: Remove update=false
         pathA.innerText = file.getPath();
@@ -125,6 +135,7 @@ class FileManager {
                 TagWhitelist: this.tag_whitelist.length == 0 ? undefined : this.tag_whitelist,
                 TagBlacklist: this.tag_blacklist.length == 0 ? undefined : this.tag_blacklist,
                 Path: this.query == "" ? undefined : this.query,
+                Query: this.filter == "" ? undefined : this.filter,
                 Sort: this.sortMethod,
                 SortReverse: this.sortReverse,
             };
@@ -211,6 +222,9 @@ class FileManager {
     setQuery(v) {
         this.query = v;
     }
+    setFilter(v) {
+        this.filter = v.trim();
+    }
     setSortMethod(method = "none", reverse = false) {
         this.sortMethod = method;
         this.sortReverse = reverse;
@@ -243,6 +257,9 @@ class FileManager {
         if (qr.Path) {
             this.setQuery(qr.Path);
         }
+        if (qr.Query) {
+            this.setFilter(qr.Query);
+        }
         if (qr.TagWhitelist) {
             this.setWhitelistTags(qr.TagWhitelist);
         }
@@ -272,6 +289,7 @@ window.onload = () => {
     let bltaginput = document.getElementById("bl_tags");
     let submitbutton = document.getElementById("submit");
     let queryinput = document.getElementById("query");
+    let filterinput = document.getElementById("filter");
     let sortMethod = document.getElementById("method");
     let sortReverse = document.getElementById("reverse_sort");
     let openInNewPage = document.getElementById("open_in_new");
@@ -309,6 +327,9 @@ window.onload = () => {
         if (opts.Path) {
             queryinput.value = opts.Path;
         }
+        if (opts.Query) {
+            filterinput.value = opts.Query;
+        }
         if (opts.Sort) {
             sortMethod.value = opts.Sort;
         }
@@ -334,10 +355,13 @@ window.onload = () => {
         fh.setWhitelistTags(wltaginput.value.split(","));
         fh.setBlacklistTags(bltaginput.value.split(","));
         fh.setQuery(queryinput.value);
+        fh.setFilter(filterinput.value);
         fh.setSortMethod(sortMethod.value, sortReverse.checked);
         fh.setCount(count.value);
         fh.openInNewTab = openInNewPage.checked;
-        fh.refresh();
+        fh.refresh().catch((err) => {
+            alert(`Search failed: ${err.Data}`);
+        });
     };
     let button = document.getElementById("random_button");
     button.onclick = () => {
@@ -1,4 +1,4 @@
-import { MMFile, apiGetFileList, apiGetRandomFile, apiSearch, bytesToHumanReadableSize, deleteCookie, getCookie, searchQuery, setCookie } from "./api.js"
+import { MMFile, apiGetFileList, apiGetRandomFile, apiGetThumbnailUri, apiHasThumbnail, apiSearch, bytesToHumanReadableSize, deleteCookie, getCookie, searchQuery, setCookie } from "./api.js"
 
 // TODO: Set cookies based on what we've recently look at & our recent settings os we can actually make this work
 
@@ -11,6 +11,7 @@ class FileManager {
     private tag_whitelist: string[] = []
     private tag_blacklist: string[] = []
     private query: string = ""
+    private filter: string = ""
     private sortMethod: "none" | "size" | "stars" | "date" | "id" | "random" = "stars"
     private sortReverse = true
     public openInNewTab = true
@@ -37,6 +38,15 @@ class FileManager {
         name.innerText = `${file.getId()}`
         // TODO: Some javascript to pop this file up
         let path = document.createElement("td")
+        if(apiHasThumbnail(file.getPath())) {
+            let thumb = document.createElement("img")
+            thumb.classList.add("thumbnail")
+            thumb.loading = "lazy"
+            thumb.src = apiGetThumbnailUri(file.getId())
+            // Files without hashes have no thumbnail
+            thumb.onerror = () => thumb.remove()
+            path.appendChild(thumb)
+        }
         let pathA = document.createElement("a")
         // TODO: Remove update=false
         pathA.innerText = file.getPath()
@@ -116,6 +126,7 @@ class FileManager {
             TagWhitelist: this.tag_whitelist.length == 0 ? undefined : this.tag_whitelist,
             TagBlacklist: this.tag_blacklist.length == 0 ? undefined : this.tag_blacklist,
             Path: this.query == "" ? undefined : this.query,
+            Query: this.filter == "" ? undefined : this.filter,
             Sort: this.sortMethod,
             SortReverse: this.sortReverse,
         }
@@ -201,6 +212,10 @@ class FileManager {
         this.query = v
     }
 
+    public setFilter(v: string) {
+        this.filter = v.trim()
+    }
+
     public setSortMethod(method: "none" | "size" | "stars" | "date" | "id" | "random" = "none", reverse: boolean = false) {
         this.sortMethod = method
         this.sortReverse = reverse
@@ -238,6 +253,9 @@ class FileManager {
         if(qr.Path) {
             this.setQuery(qr.Path)
         }
+        if(qr.Query) {
+            this.setFilter(qr.Query)
+        }
         if(qr.TagWhitelist) {
             this.setWhitelistTags(qr.TagWhitelist)
         }
@@ -268,6 +286,7 @@ window.onload = () => {
     let bltaginput = document.getElementById("bl_tags") as HTMLInputElement
     let submitbutton = document.getElementById("submit") as HTMLButtonElement
     let queryinput = document.getElementById("query") as HTMLInputElement
+    let filterinput = document.getElementById("filter") as HTMLInputElement
     let sortMethod = document.getElementById("method") as HTMLSelectElement
     let sortReverse = document.getElementById("reverse_sort") as HTMLInputElement
     let openInNewPage = document.getElementById("open_in_new") as HTMLInputElement
@@ -304,6 +323,9 @@ window.onload = () => {
         if(opts.Path) {
             queryinput.value = opts.Path
         }
+        if(opts.Query) {
+            filterinput.value = opts.Query
+        }
         if(opts.Sort) {
             sortMethod.value = opts.Sort
         }
@@ -329,10 +351,13 @@ window.onload = () => {
         fh.setWhitelistTags(wltaginput.value.split(","))
         fh.setBlacklistTags(bltaginput.value.split(","))
         fh.setQuery(queryinput.value)
+        fh.setFilter(filterinput.value)
         fh.setSortMethod(sortMethod.value, sortReverse.checked)
         fh.setCount(count.value)
         fh.openInNewTab = openInNewPage.checked
-        fh.refresh()
+        fh.refresh().catch((err) => {
+            alert(`Search failed: ${err.Data}`)
+        })
     }
     let button = document.getElementById("random_button") as HTMLButtonElement
     button.onclick = () => {
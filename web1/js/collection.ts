@@ -1,28 +1,22 @@
-import { apiGetCollection, apiGetTags } from "./api.js"
+import { apiGetCollections } from "./api.js"
 
 window.onload = async () => {
     const table = document.getElementById("table_body") as HTMLTableElement
-    // So we get all tags
-    const tags = await apiGetTags()
-    tags.forEach(async t => {
-        if(t.startsWith("collection:")) {
-            const nameTd = document.createElement("td")
-            const nameElement = document.createElement("a")
-            nameElement.text = t.split(":")[1]
-            nameElement.href = `/file?collection=${nameElement.text}`
-            nameTd.appendChild(nameElement)
-            const fileCountTd = document.createElement("td")
-            const fileCount = document.createElement("a")
-            // Get collection info
-            apiGetCollection(nameElement.text).then(e => {
-                fileCount.text = `${e.length}`
-            })
-            fileCount.text = `(not set)`
-            fileCountTd.appendChild(fileCount)
-            const tr = document.createElement("tr")
-            tr.appendChild(nameTd)
-            tr.appendChild(fileCountTd)
-            table.appendChild(tr)
-        }
+    const collections = await apiGetCollections()
+    collections.forEach(c => {
+        const nameTd = document.createElement("td")
+        const nameElement = document.createElement("a")
+        nameElement.text = c.Name
+        nameElement.href = `/file?collection=${encodeURIComponent(c.Name)}`
+        nameTd.appendChild(nameElement)
+        const fileCountTd = document.createElement("td")
+        fileCountTd.textContent = `${c.Count}`
+        const descriptionTd = document.createElement("td")
+        descriptionTd.textContent = c.Description
+        const tr = document.createElement("tr")
+        tr.appendChild(nameTd)
+        tr.appendChild(fileCountTd)
+        tr.appendChild(descriptionTd)
+        table.appendChild(tr)
     });
-}
\ No newline at end of file
+}
@@ -12,7 +12,7 @@ var __awaiter = (this && this.__awaiter) || function (thisArg, _arguments, P, ge
  *
  * @todo Error handling popups.
  */
-import { apiGetFile, apiGetContentUri, apiGetFileContentType, apiUpdateFile, apiGetTags, apiUpdateFileViewed, apiGetCollection, apiGetRandomFile, getCookie } from "./api.js";
+import { apiGetFile, apiGetContentUri, apiGetFileContentType, apiUpdateFile, apiGetTagTree, apiUpdateFileViewed, apiGetCollection, apiGetCollections, apiGetRandomFile, getCookie } from "./api.js";
 /**
  * Id of the current file, or -1 if we're using collection.
  * @todo Replace with file, or just remove.
@@ -22,23 +22,31 @@ var fileId = -1;
  * A list of all tags
  */
 var tagList = [];
+/**
+ * Every tag grouped by namespace & parent, used to render the tags that can be added
+ */
+var tagTree = [];
 /**
  * Current collection this file is part of (If it is, otherwise its empty)
  */
 var collection = [];
+/**
+ * Name of the loaded collection
+ */
+var collectionName = "";
 /**
  * Index in the collection this file is
  */
 var colIndex = 0;
 /**
  * Loads a collection by name
- * @param name Collection name (Without the collection: part)
+ * @param name Collection name
  * @param showFull Should the collection be showed now
- * @param author Replace using 'collection:' with using 'author:'
  * @todo Remove globals, add whatever error handling were going to use
  */
-function loadCollection(name, showFull = false, author = false) {
+function loadCollection(name, showFull = false) {
     return __awaiter(this, void 0, void 0, function* () {
+        var _a;
         const title = document.getElementById("file_name");
         title.text = `Collection: ${name}`;
         if (collection.length > 0) {
@@ -46,25 +54,14 @@ function loadCollection(name, showFull = false, author = false) {
             console.error("Attempted to reload loaded collection");
             return;
         }
-        // Search the collection.s
-        if (author) {
-            try {
-                collection = yield apiGetCollection(name, "author");
-            }
-            catch (e) {
-                alert(`Failed to get collection: ${e}`);
-                return;
-            }
+        try {
+            collection = yield apiGetCollection(name);
         }
-        else {
-            try {
-                collection = yield apiGetCollection(name);
-            }
-            catch (e) {
-                alert(`Failed to get collection: ${e}`);
-                return;
-            }
+        catch (e) {
+            alert(`Failed to get collection: ${(_a = e.Data) !== null && _a !== void 0 ? _a : e}`);
+            return;
         }
+        collectionName = name;
         // If we're showing the collection just do that now
         if (showFull) {
             yield showFullCollection();
@@ -129,22 +126,9 @@ function showFullCollection() {
                 e.hidden = !state;
             }
         };
-        // Get collection name
-        let cName = "";
-        for (const tag of collection[0].getTags()) {
-            if (tag.startsWith("collection:")) {
-                cName = tag.split(":")[1];
-                break;
-            }
-        }
-        // No name = bad.
-        if (cName == "") {
-            alert("Failed to get collection name");
-            return;
-        }
-        // Set it
+        // Set the name
         const path = document.getElementById("path");
-        path.innerText = `Collection: ${cName}`;
+        path.innerText = `Collection: ${collectionName}`;
         // Hide controls
         const controls = document.getElementById("controls");
         controls.hidden = true;
@@ -153,7 +137,7 @@ function showFullCollection() {
         container.innerHTML = "";
         // Change URL
         window.history.pushState("", "");
-        window.history.replaceState("", "", `/file?collection=${cName}`);
+        window.history.replaceState("", "", `/file?collection=${encodeURIComponent(collectionName)}`);
         // Add each one, making sure they are in order.
         collection.forEach(element => {
             let d = createFileElement(element, true, true);
@@ -180,42 +164,105 @@ function changeFile(file) {
     window.history.pushState("", "");
     window.history.replaceState("", "", `/file?id=${fileId}`);
 }
+/**
+ * Show the collections a file is in & load the first one so it can be walked through
+ * @param file File to get the collections of
+ */
+function setupCollections(file) {
+    return __awaiter(this, void 0, void 0, function* () {
+        let collections;
+        try {
+            collections = yield apiGetCollections(file.getId());
+        }
+        catch (e) {
+            console.error("Failed to get collections", e);
+            return;
+        }
+        if (collections.length == 0) {
+            return;
+        }
+        const collectionTagE = document.getElementById("col_tags");
+        collectionTagE.innerHTML = "";
+        collections.forEach(c => {
+            let element = document.createElement("a");
+            element.classList.add("tag", "collection");
+            element.text = c.Name;
+            element.href = `/file?collection=${encodeURIComponent(c.Name)}`;
+            collectionTagE.appendChild(element);
+        });
+        document.getElementById("collection_div").hidden = false;
+        yield loadCollection(collections[0].Name);
+    });
+}
 /**
  * Setup the tags of this file
  * @param file File to set the tags up for
  */
 function setupTags(file) {
     return __awaiter(this, void 0, void 0, function* () {
-        const tags = yield apiGetTags();
+        tagTree = yield apiGetTagTree();
         const fileTags = file.getTags();
         tagList = [];
-        tags.forEach(t => {
-            // Create element for it
-            let element = document.createElement("a");
-            element.classList.add("tag");
-            let sp = t.split(":");
-            if (sp.length > 1) {
-                if (sp[0] == "author") {
-                    element.classList.add("author");
-                }
-                else if (sp[0] == "collection") {
-                    element.classList.add("collection");
-                }
-                else {
-                    element.classList.add('metadata');
+        const addNodes = (nodes) => {
+            nodes.forEach(n => {
+                // Namespaces & parents that aren't tags only group their children
+                if (n.Id != 0) {
+                    // Create element for it
+                    let element = document.createElement("a");
+                    element.classList.add("tag");
+                    let sp = n.Tag.split(":");
+                    if (sp.length > 1) {
+                        if (sp[0] == "author") {
+                            element.classList.add("author");
+                        }
+                        else {
+                            element.classList.add('metadata');
+                        }
+                    }
+                    let title = [];
+                    if (n.Implies.length != 0) {
+                        title.push(`Implies ${n.Implies.join(", ")}`);
+                    }
+                    if (n.Aliases.length != 0) {
+                        title.push(`Aliases ${n.Aliases.join(", ")}`);
+                    }
+                    element.title = title.join("\n");
+                    tagList.push({
+                        Element: element,
+                        Node: n,
+                        IsFileTag: fileTags.includes(n.Tag),
+                        IsSelected: false,
+                        Value: n.Tag
+                    });
                 }
-                element.text = sp[1];
-            }
-            else {
-                element.text = t;
-            }
-            tagList.push({
-                Element: element,
-                IsFileTag: fileTags.includes(t),
-                IsSelected: false,
-                Value: t
+                addNodes(n.Children);
             });
-        });
+        };
+        addNodes(tagTree);
+    });
+}
+/**
+ * Create a collapsible group in the tag box for every namespace & parent tag
+ * @param nodes Nodes to add
+ * @param parent Element the groups are added to
+ * @param groups Tag to the element its children are rendered in
+ */
+function addTagGroups(nodes, parent, groups) {
+    nodes.forEach(n => {
+        if (n.Children.length == 0) {
+            groups.set(n.Tag, parent);
+            return;
+        }
+        const details = document.createElement("details");
+        details.classList.add("tag-group");
+        const summary = document.createElement("summary");
+        summary.textContent = n.Tag == "" ? `${n.Name}:` : n.Name;
+        details.appendChild(summary);
+        parent.appendChild(details);
+        if (n.Tag != "") {
+            groups.set(n.Tag, details);
+        }
+        addTagGroups(n.Children, details, groups);
     });
 }
 /**
@@ -227,15 +274,13 @@ function renderTags() {
         const allTagE = document.getElementById("all_tags");
         const authorTagE = document.getElementById("author_tags");
         const authorDiv = document.getElementById("author_div");
-        const collectionTagE = document.getElementById("col_tags");
-        const colDiv = document.getElementById("collection_div");
         const showAuthors = document.getElementById("show_authors").checked;
-        const showCollections = document.getElementById("show_collections").checked;
         // Clear tag
         fileTagE.innerHTML = "";
         allTagE.innerHTML = "";
         authorTagE.innerHTML = "";
-        collectionTagE.innerHTML = "";
+        const groups = new Map();
+        addTagGroups(tagTree, allTagE, groups);
         tagList.forEach(td => {
             // If we've already rendered this tags somewhere.
             let alreadyRender = false;
@@ -253,28 +298,6 @@ function renderTags() {
                         return;
                     }
                 }
-                else if (td.Value.startsWith("collection:")) {
-                    // Idk what this does...
-                    if (td.IsFileTag) {
-                        // Always render file tags
-                        loadCollection(td.Value.split(":")[1]);
-                        collectionTagE.appendChild(td.Element);
-                        colDiv.hidden = false;
-                        alreadyRender = true;
-                    }
-                    else if (!showCollections) {
-                        // Don't show this in the selection box if collections aren't selected
-                        return;
-                    }
-                }
-                else if (td.Value.startsWith("colindex:")) {
-                    // Collection index
-                    const colIndexA = document.getElementById("col_index");
-                    colIndexA.classList.add("tag");
-                    colIndexA.classList.add("metadata");
-                    colIndexA.text = `${td.Value.split(":")[1]}`;
-                    alreadyRender = true;
-                }
                 else {
                     // Other metadata
                     console.log(`Unknown tag namespace: ${td.Value}`);
@@ -283,6 +306,8 @@ function renderTags() {
             // Handle anything
             // Remove add_tag and rem_tag if its cached.
             td.Element.classList.remove("add_tag", "rem_tag");
+            // File tags show everything but the namespace, the tag box only needs the last part
+            td.Element.text = td.IsFileTag ? td.Value.substring(td.Value.indexOf(":") + 1) : td.Node.Name;
             if (td.IsFileTag) {
                 // Render these in the 'tags' section
                 td.Element.onclick = () => {
@@ -311,10 +336,18 @@ function renderTags() {
                     }
                 };
                 if (!alreadyRender) {
-                    allTagE.appendChild(td.Element);
+                    // Tags go before the groups of their children
+                    const group = groups.get(td.Value) || allTagE;
+                    group.insertBefore(td.Element, group.querySelector(":scope > details"));
                 }
             }
         });
+        // Remove groups that don't have any tags to add
+        allTagE.querySelectorAll("details").forEach(d => {
+            if (d.querySelector(".tag") == null) {
+                d.remove();
+            }
+        });
         return;
     });
 }
@@ -379,8 +412,9 @@ function createFileElement(file, addHiddenTitle = false, expandedImages = false)
         name.textContent = file.getPath();
         ourDiv.appendChild(name);
     }
-    // Get our expected content type
-    apiGetFileContentType(file.getId()).then((v) => {
+    // Get our expected content type, files imported without a type need to ask the server
+    const contentType = file.getMime() != "" ? Promise.resolve(file.getMime()) : apiGetFileContentType(file.getId());
+    contentType.then((v) => {
         if (v.startsWith("application/octet-stream") || v.startsWith("text/plain")) {
             let p = document.createElement("p");
             p.id = "text";
@@ -430,6 +464,14 @@ function createFileElement(file, addHiddenTitle = false, expandedImages = false)
             video.appendChild(source);
             ourDiv.appendChild(video);
         }
+        else if (v.startsWith("audio")) {
+            let audio = document.createElement("audio");
+            audio.id = "media";
+            audio.controls = true;
+            audio.preload = "metadata";
+            audio.appendChild(source);
+            ourDiv.appendChild(audio);
+        }
         else {
             // TODO: Some sorta fallback
             alert(`Failed to get content-type: ${v}`);
@@ -643,9 +685,6 @@ function setup() {
         document.getElementById("show_authors").onclick = () => {
             renderTags();
         };
-        document.getElementById("show_collections").onclick = () => {
-            renderTags();
-        };
         // Figure out if were viewing a file or collection (I know, putting it under /file is dumb, but idc)
         const urlParams = new URLSearchParams(window.location.search);
         // Figure out of it a collection, id or neither
@@ -663,6 +702,7 @@ function setup() {
         let file = yield getFile();
         fileId = file.getId();
         setupFile(file);
+        setupCollections(file);
         document.getElementById("pop_out").onclick = () => {
             var _a;
             (_a = window.open(file.getContentUri() + "&update=false", "_blank")) === null || _a === void 0 ? void 0 : _a.focus();
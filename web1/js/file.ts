@@ -3,13 +3,14 @@
  * 
  * @todo Error handling popups.
  */
-import { MMFile, apiGetFile, apiGetContentUri, apiGetFileContentType, apiUpdateFile, apiGetTags, apiUpdateFileViewed, apiAddTag, apiSearch, apiGetCollection, apiGetRandomFile, getCookie } from "./api.js"
+import { MMFile, apiGetFile, apiGetContentUri, apiGetFileContentType, apiUpdateFile, apiGetTagTree, apiTagNode, apiUpdateFileViewed, apiAddTag, apiSearch, apiGetCollection, apiGetCollections, apiCollection, apiGetRandomFile, getCookie } from "./api.js"
 
 /**
  * Data of a tag
  */
 type tagData = {
     Element: HTMLAnchorElement,
+    Node: apiTagNode,
     Value: string,
     IsFileTag: boolean,
     IsSelected: boolean
@@ -26,11 +27,21 @@ var fileId = -1
  */
 var tagList: tagData[] = []
 
+/**
+ * Every tag grouped by namespace & parent, used to render the tags that can be added
+ */
+var tagTree: apiTagNode[] = []
+
 /**
  * Current collection this file is part of (If it is, otherwise its empty)
  */
 var collection: MMFile[] = []
 
+/**
+ * Name of the loaded collection
+ */
+var collectionName = ""
+
 /**
  * Index in the collection this file is
  */
@@ -38,12 +49,11 @@ var colIndex = 0
 
 /**
  * Loads a collection by name
- * @param name Collection name (Without the collection: part)
+ * @param name Collection name
  * @param showFull Should the collection be showed now
- * @param author Replace using 'collection:' with using 'author:'
  * @todo Remove globals, add whatever error handling were going to use
  */
-async function loadCollection(name: string, showFull = false, author = false) {
+async function loadCollection(name: string, showFull = false) {
     const title = document.getElementById("file_name") as HTMLTitleElement
     title.text = `Collection: ${name}`
     if (collection.length > 0) {
@@ -51,22 +61,13 @@ async function loadCollection(name: string, showFull = false, author = false) {
         console.error("Attempted to reload loaded collection")
         return
     }
-    // Search the collection.s
-    if (author) {
-        try {
-            collection = await apiGetCollection(name, "author")
-        } catch(e) {
-            alert(`Failed to get collection: ${e}`)
-            return
-        }
-    } else {
-        try {
-            collection = await apiGetCollection(name)
-        } catch(e) {
-            alert(`Failed to get collection: ${e}`)
-            return
-        }
+    try {
+        collection = await apiGetCollection(name)
+    } catch(e) {
+        alert(`Failed to get collection: ${(e as any).Data ?? e}`)
+        return
     }
+    collectionName = name
     // If we're showing the collection just do that now
     if (showFull) {
         await showFullCollection()
@@ -130,22 +131,9 @@ async function showFullCollection() {
             e.hidden = !state
         }
     };
-    // Get collection name
-    let cName = ""
-    for (const tag of collection[0].getTags()) {
-        if (tag.startsWith("collection:")) {
-            cName = tag.split(":")[1]
-            break
-        }
-    }
-    // No name = bad.
-    if (cName == "") {
-        alert("Failed to get collection name")
-        return
-    }
-    // Set it
+    // Set the name
     const path = document.getElementById("path") as HTMLHeadElement
-    path.innerText = `Collection: ${cName}`
+    path.innerText = `Collection: ${collectionName}`
     // Hide controls
     const controls = document.getElementById("controls") as HTMLDivElement
     controls.hidden = true
@@ -154,7 +142,7 @@ async function showFullCollection() {
     container.innerHTML = ""
     // Change URL
     window.history.pushState("", "")
-    window.history.replaceState("", "", `/file?collection=${cName}`)
+    window.history.replaceState("", "", `/file?collection=${encodeURIComponent(collectionName)}`)
     // Add each one, making sure they are in order.
     collection.forEach(element => {
         let d = createFileElement(element, true, true)
@@ -182,38 +170,102 @@ function changeFile(file: MMFile) {
     window.history.replaceState("", "", `/file?id=${fileId}`)
 }
 
+/**
+ * Show the collections a file is in & load the first one so it can be walked through
+ * @param file File to get the collections of
+ */
+async function setupCollections(file: MMFile) {
+    let collections: apiCollection[]
+    try {
+        collections = await apiGetCollections(file.getId())
+    } catch(e) {
+        console.error("Failed to get collections", e)
+        return
+    }
+    if (collections.length == 0) {
+        return
+    }
+    const collectionTagE = document.getElementById("col_tags") as HTMLSpanElement
+    collectionTagE.innerHTML = ""
+    collections.forEach(c => {
+        let element = document.createElement("a")
+        element.classList.add("tag", "collection")
+        element.text = c.Name
+        element.href = `/file?collection=${encodeURIComponent(c.Name)}`
+        collectionTagE.appendChild(element)
+    });
+    (document.getElementById("collection_div") as HTMLDivElement).hidden = false
+    await loadCollection(collections[0].Name)
+}
+
 /**
  * Setup the tags of this file
  * @param file File to set the tags up for
  */
 async function setupTags(file: MMFile) {
-    const tags = await apiGetTags()
+    tagTree = await apiGetTagTree()
     const fileTags = file.getTags()
     tagList = []
-    tags.forEach(t => {
-        // Create element for it
-        let element = document.createElement("a")
-        element.classList.add("tag")
-        let sp = t.split(":")
-        if (sp.length > 1) {
-            if (sp[0] == "author") {
-                element.classList.add("author")
-            } else if (sp[0] == "collection") {
-                element.classList.add("collection")
-            } else {
-                element.classList.add('metadata')
+    const addNodes = (nodes: apiTagNode[]) => {
+        nodes.forEach(n => {
+            // Namespaces & parents that aren't tags only group their children
+            if (n.Id != 0) {
+                // Create element for it
+                let element = document.createElement("a")
+                element.classList.add("tag")
+                let sp = n.Tag.split(":")
+                if (sp.length > 1) {
+                    if (sp[0] == "author") {
+                        element.classList.add("author")
+                    } else {
+                        element.classList.add('metadata')
+                    }
+                }
+                let title = []
+                if (n.Implies.length != 0) {
+                    title.push(`Implies ${n.Implies.join(", ")}`)
+                }
+                if (n.Aliases.length != 0) {
+                    title.push(`Aliases ${n.Aliases.join(", ")}`)
+                }
+                element.title = title.join("\n")
+                tagList.push({
+                    Element: element,
+                    Node: n,
+                    IsFileTag: fileTags.includes(n.Tag),
+                    IsSelected: false,
+                    Value: n.Tag
+                })
             }
-            element.text = sp[1]
-        } else {
-            element.text = t
-        }
-        tagList.push({
-            Element: element,
-            IsFileTag: fileTags.includes(t),
-            IsSelected: false,
-            Value: t
+            addNodes(n.Children)
         })
-    });
+    }
+    addNodes(tagTree)
+}
+
+/**
+ * Create a collapsible group in the tag box for every namespace & parent tag
+ * @param nodes Nodes to add
+ * @param parent Element the groups are added to
+ * @param groups Tag to the element its children are rendered in
+ */
+function addTagGroups(nodes: apiTagNode[], parent: HTMLElement, groups: Map<string, HTMLElement>) {
+    nodes.forEach(n => {
+        if (n.Children.length == 0) {
+            groups.set(n.Tag, parent)
+            return
+        }
+        const details = document.createElement("details")
+        details.classList.add("tag-group")
+        const summary = document.createElement("summary")
+        summary.textContent = n.Tag == "" ? `${n.Name}:` : n.Name
+        details.appendChild(summary)
+        parent.appendChild(details)
+        if (n.Tag != "") {
+            groups.set(n.Tag, details)
+        }
+        addTagGroups(n.Children, details, groups)
+    })
 }
 
 
@@ -225,15 +277,13 @@ async function renderTags() {
     const allTagE = document.getElementById("all_tags") as HTMLSpanElement
     const authorTagE = document.getElementById("author_tags") as HTMLSpanElement
     const authorDiv = document.getElementById("author_div") as HTMLDivElement
-    const collectionTagE = document.getElementById("col_tags") as HTMLSpanElement
-    const colDiv = document.getElementById("collection_div") as HTMLDivElement
     const showAuthors = (document.getElementById("show_authors") as HTMLInputElement).checked
-    const showCollections = (document.getElementById("show_collections") as HTMLInputElement).checked
     // Clear tag
     fileTagE.innerHTML = ""
     allTagE.innerHTML = ""
     authorTagE.innerHTML = ""
-    collectionTagE.innerHTML = ""
+    const groups = new Map<string, HTMLElement>()
+    addTagGroups(tagTree, allTagE, groups)
     tagList.forEach(td => {
         // If we've already rendered this tags somewhere.
         let alreadyRender = false
@@ -249,25 +299,6 @@ async function renderTags() {
                     // Don't show this in the selection box if authors aren't selected
                     return
                 }
-            } else if (td.Value.startsWith("collection:")) {
-                // Idk what this does...
-                if(td.IsFileTag) {
-                    // Always render file tags
-                    loadCollection(td.Value.split(":")[1])
-                    collectionTagE.appendChild(td.Element)
-                    colDiv.hidden = false
-                    alreadyRender = true
-                } else if (!showCollections) {
-                    // Don't show this in the selection box if collections aren't selected
-                    return
-                }
-            } else if (td.Value.startsWith("colindex:")) {
-                // Collection index
-                const colIndexA = document.getElementById("col_index") as HTMLAnchorElement
-                colIndexA.classList.add("tag")
-                colIndexA.classList.add("metadata")
-                colIndexA.text = `${td.Value.split(":")[1]}`
-                alreadyRender = true
             } else {
                 // Other metadata
                 console.log(`Unknown tag namespace: ${td.Value}`)
@@ -276,6 +307,8 @@ async function renderTags() {
         // Handle anything
         // Remove add_tag and rem_tag if its cached.
         td.Element.classList.remove("add_tag", "rem_tag")
+        // File tags show everything but the namespace, the tag box only needs the last part
+        td.Element.text = td.IsFileTag ? td.Value.substring(td.Value.indexOf(":") + 1) : td.Node.Name
         if(td.IsFileTag) {
             // Render these in the 'tags' section
             td.Element.onclick = () => {
@@ -301,10 +334,18 @@ async function renderTags() {
                 }
             }
             if(!alreadyRender) {
-                allTagE.appendChild(td.Element)
+                // Tags go before the groups of their children
+                const group = groups.get(td.Value) || allTagE
+                group.insertBefore(td.Element, group.querySelector(":scope > details"))
             }
         }
     })
+    // Remove groups that don't have any tags to add
+    allTagE.querySelectorAll("details").forEach(d => {
+        if (d.querySelector(".tag") == null) {
+            d.remove()
+        }
+    })
     return
 }
 
@@ -367,8 +408,9 @@ function createFileElement(file: MMFile, addHiddenTitle = false, expandedImages
         name.textContent = file.getPath()
         ourDiv.appendChild(name)
     }
-    // Get our expected content type
-    apiGetFileContentType(file.getId()).then((v) => {
+    // Get our expected content type, files imported without a type need to ask the server
+    const contentType = file.getMime() != "" ? Promise.resolve(file.getMime()) : apiGetFileContentType(file.getId())
+    contentType.then((v) => {
         if(v.startsWith("application/octet-stream") || v.startsWith("text/plain")) {
             let p = document.createElement("p")
             p.id = "text"
@@ -414,6 +456,13 @@ function createFileElement(file: MMFile, addHiddenTitle = false, expandedImages
             video.preload = "metadata"
             video.appendChild(source)
             ourDiv.appendChild(video)
+        } else if (v.startsWith("audio")) {
+            let audio = document.createElement("audio") as HTMLAudioElement
+            audio.id = "media"
+            audio.controls = true
+            audio.preload = "metadata"
+            audio.appendChild(source)
+            ourDiv.appendChild(audio)
         } else {
             // TODO: Some sorta fallback
             alert(`Failed to get content-type: ${v}`)
@@ -625,9 +674,6 @@ async function setup() {
     (document.getElementById("show_authors") as HTMLInputElement).onclick = () => {
         renderTags()
     }
-    (document.getElementById("show_collections") as HTMLInputElement).onclick = () => {
-        renderTags()
-    }
     // Figure out if were viewing a file or collection (I know, putting it under /file is dumb, but idc)
     const urlParams = new URLSearchParams(window.location.search)
     // Figure out of it a collection, id or neither
@@ -644,7 +690,8 @@ async function setup() {
     }
     let file = await getFile()
     fileId = file.getId()
-    setupFile(file);
+    setupFile(file)
+    setupCollections(file);
     (document.getElementById("pop_out") as HTMLHeadElement).onclick = () => {
         window.open(file.getContentUri() + "&update=false", "_blank")?.focus()
     }
@@ -0,0 +1,131 @@
+package web1
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+)
+
+const (
+	maxLoginAttempts     = 1000             // Number of login attempts kept, older attempts are overwritten
+	freeLoginFailures    = 3                // Failed logins allowed before logins are slowed down
+	lockoutLoginFailures = 10               // Failed logins before being locked out
+	loginBackoff         = time.Second      // Wait after the first slowed down failure, this doubles each failure
+	loginLockout         = 15 * time.Minute // How long a IP or username is locked out for
+	loginFailureReset    = time.Hour        // Failed logins are forgotten after this long without another
+)
+
+// Fixed size log of the latest login attempts
+type attemptLog struct {
+	attempts []*loginAttempt
+	next     int // Index the next attempt is written to
+}
+
+func (a *attemptLog) add(v *loginAttempt) {
+	if len(a.attempts) < maxLoginAttempts {
+		a.attempts = append(a.attempts, v)
+		return
+	}
+	a.attempts[a.next] = v
+	a.next = (a.next + 1) % maxLoginAttempts
+}
+
+// Every attempt, oldest first
+func (a *attemptLog) all() []*loginAttempt {
+	all := make([]*loginAttempt, 0, len(a.attempts))
+	all = append(all, a.attempts[a.next:]...)
+	return append(all, a.attempts[:a.next]...)
+}
+
+// Failed logins of a IP or username
+type loginFailures struct {
+	count       int
+	last        time.Time // Time of the last failure
+	lockedUntil time.Time // When logins are allowed again
+}
+
+// Slows down failed logins by IP & username, each failure after freeLoginFailures doubles the wait
+// until lockoutLoginFailures is reached and logins are locked out for loginLockout.
+type loginThrottle struct {
+	lock     sync.Mutex
+	failures map[string]*loginFailures
+	pruned   time.Time // Last time old failures were removed
+}
+
+// Remove forgotten failures, t.lock must be held.
+func (t *loginThrottle) prune(now time.Time) {
+	if now.Sub(t.pruned) < loginFailureReset {
+		return
+	}
+	t.pruned = now
+	for k, v := range t.failures {
+		if now.Sub(v.last) >= loginFailureReset {
+			delete(t.failures, k)
+		}
+	}
+}
+
+// Check if every key is allowed to log in, if not returns when they can try again.
+func (t *loginThrottle) check(keys ...string) (allowed bool, retry time.Time) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	now := time.Now()
+	t.prune(now)
+	allowed = true
+	for _, k := range keys {
+		f, found := t.failures[k]
+		if !found {
+			continue
+		}
+		if now.Sub(f.last) >= loginFailureReset {
+			delete(t.failures, k)
+			continue
+		}
+		if now.Before(f.lockedUntil) {
+			allowed = false
+			if f.lockedUntil.After(retry) {
+				retry = f.lockedUntil
+			}
+		}
+	}
+	return allowed, retry
+}
+
+// Record a failed login for every key
+func (t *loginThrottle) fail(keys ...string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	now := time.Now()
+	for _, k := range keys {
+		f, found := t.failures[k]
+		if !found || now.Sub(f.last) >= loginFailureReset {
+			f = &loginFailures{}
+			t.failures[k] = f
+		}
+		f.count++
+		f.last = now
+		switch {
+		case f.count >= lockoutLoginFailures:
+			f.lockedUntil = now.Add(loginLockout)
+			slog.Warn("Too many failed logins, locking out", "Key", k, "Failures", f.count, "Until", f.lockedUntil)
+		case f.count > freeLoginFailures:
+			f.lockedUntil = now.Add(loginBackoff << (f.count - freeLoginFailures - 1))
+		}
+	}
+}
+
+// Forget the failed logins of every key
+func (t *loginThrottle) reset(keys ...string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	for _, k := range keys {
+		delete(t.failures, k)
+	}
+}
+
+func newLoginThrottle() *loginThrottle {
+	return &loginThrottle{
+		failures: make(map[string]*loginFailures),
+		pruned:   time.Now(),
+	}
+}
@@ -0,0 +1,79 @@
+package web1
+
+import (
+	"mediamanager/filedb"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoginThrottle(t *testing.T) {
+	th := newLoginThrottle()
+	for range freeLoginFailures {
+		th.fail("ip:a")
+		allowed, _ := th.check("ip:a")
+		assert.Truef(t, allowed, "Login was slowed down before %d failures", freeLoginFailures)
+	}
+	// Each failure after the free ones doubles the wait
+	for i := range 3 {
+		th.fail("ip:a")
+		allowed, retry := th.check("ip:a", "user:b")
+		assert.Falsef(t, allowed, "Login wasn't slowed down after %d failures", freeLoginFailures+i+1)
+		assert.WithinDurationf(t, time.Now().Add(loginBackoff<<i), retry, 100*time.Millisecond, "Wrong backoff")
+	}
+	allowed, _ := th.check("ip:b", "user:b")
+	assert.Truef(t, allowed, "Other keys were slowed down")
+	for th.failures["ip:a"].count < lockoutLoginFailures {
+		th.fail("ip:a")
+	}
+	_, retry := th.check("ip:a")
+	assert.WithinDurationf(t, time.Now().Add(loginLockout), retry, 100*time.Millisecond, "Wrong lockout")
+	// Failures are forgotten
+	th.failures["ip:a"].last = time.Now().Add(-loginFailureReset)
+	allowed, _ = th.check("ip:a")
+	assert.Truef(t, allowed, "Old failures weren't forgotten")
+	th.fail("user:c", "ip:c")
+	th.reset("user:c")
+	assert.Nilf(t, th.failures["user:c"], "Failures weren't reset")
+	assert.NotNilf(t, th.failures["ip:c"], "Key that wasn't reset lost its failures")
+}
+
+// Post a login to authPage, returns the status code
+func postLogin(lm *LoginManager, user, password string) int {
+	form := url.Values{"username": {user}, "password": {password}}
+	r := httptest.NewRequest("POST", "/authenticate", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	lm.authPage(w, r)
+	return w.Code
+}
+
+func TestLoginResetKeepsIp(t *testing.T) {
+	db, err := filedb.NewFileDb(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer db.Close()
+	u, err := db.AddUser("guest", filedb.AccountTypeGuest)
+	if err != nil {
+		t.Fatalf("Failed to add user: %v", err)
+	}
+	if err = db.SetPassword(u, "guestpassword"); err != nil {
+		t.Fatalf("Failed to set password: %v", err)
+	}
+	lm := &LoginManager{db: db, throttle: newLoginThrottle()}
+	for range freeLoginFailures {
+		assert.Equalf(t, http.StatusUnauthorized, postLogin(lm, "admin", "guess"), "Wrong password was accepted")
+	}
+	assert.Equalf(t, http.StatusOK, postLogin(lm, "guest", "guestpassword"), "Login failed")
+	assert.Equalf(t, http.StatusUnauthorized, postLogin(lm, "other", "guess"), "Wrong password was accepted")
+	// Logging into a account doesn't reset the IP's failures
+	assert.Equalf(t, http.StatusTooManyRequests, postLogin(lm, "another", "guess"), "IP wasn't slowed down after logging in")
+	assert.Nilf(t, lm.throttle.failures["user:guest"], "Failures of the account weren't reset")
+}
@@ -0,0 +1,1792 @@
+package web2
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"mediamanager/filedb"
+	"mediamanager/metadata"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Header the API key is provided in
+const ApiKeyHeader = "X-Api-Key"
+
+type DbApi2 struct {
+	db      *filedb.FileDb
+	limiter *RateLimiter // nil if there is no rate limit
+}
+
+type apiBase struct {
+	Code int
+	Data any
+}
+
+// Data of a error response
+type apiError struct {
+	Message    string
+	Permission string `json:",omitempty"` // Permission the request needed, set on 403 errors
+}
+
+// The key making a request
+type apiRequest struct {
+	key   *filedb.ApiKey // nil if no key was provided
+	user  *filedb.User   // nil if no key was provided
+	perms filedb.Permission
+}
+
+type apiHandler func(w http.ResponseWriter, r *http.Request, req *apiRequest)
+
+func writeApiError(w http.ResponseWriter, r *http.Request, code int, msg string) {
+	writeApiErrorData(w, r, code, apiError{
+		Message: msg,
+	})
+}
+
+func writeApiErrorData(w http.ResponseWriter, r *http.Request, code int, e apiError) {
+	data, err := json.Marshal(apiBase{
+		Code: code,
+		Data: e,
+	})
+	if err != nil {
+		slog.Error("web2.writeApiError json.Marshal failed", "Error", err.Error(), "code", code, "msg", e.Message, "Path", r.URL.Path)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf("Failed to encode error: %v", err)))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(data)
+}
+
+func writeApiData(w http.ResponseWriter, r *http.Request, data any) {
+	jData, err := json.Marshal(apiBase{
+		Code: http.StatusOK,
+		Data: data,
+	})
+	if err != nil {
+		slog.Error("web2.writeApiData json.Marshal failed", "Error", err.Error(), "data", fmt.Sprintf("%+v", data))
+		writeApiError(w, r, http.StatusInternalServerError, fmt.Sprintf("Failed to encode Api data: %v", err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jData)
+}
+
+// Context key of the authResult of a request
+type authContextKey struct{}
+
+// Result of looking up the key of a request
+type authResult struct {
+	req *apiRequest
+	err error
+}
+
+// Look up the key of r & save it in the request context, so it's only looked up once per request.
+func withAuthentication(db *filedb.FileDb, r *http.Request) (*http.Request, *apiRequest, error) {
+	if res, ok := r.Context().Value(authContextKey{}).(*authResult); ok {
+		return r, res.req, res.err
+	}
+	req, err := lookupApiKey(db, r)
+	return r.WithContext(context.WithValue(r.Context(), authContextKey{}, &authResult{req, err})), req, err
+}
+
+// Get the key making the request, if no key was provided the request has no permissions.
+//
+// Reuses the key found by withAuthentication if it was already looked up.
+func authenticate(db *filedb.FileDb, r *http.Request) (*apiRequest, error) {
+	_, req, err := withAuthentication(db, r)
+	return req, err
+}
+
+func lookupApiKey(db *filedb.FileDb, r *http.Request) (*apiRequest, error) {
+	keyStr := r.Header.Get(ApiKeyHeader)
+	if keyStr == "" {
+		return &apiRequest{}, nil
+	}
+	key, err := db.GetApiKey(keyStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid API key")
+	}
+	user, err := db.GetUserById(key.GetUserId())
+	if err != nil {
+		// Keys are removed with their user, so this shouldn't happen.
+		slog.Warn("API key belongs to a missing user", "Key.Id", key.GetId(), "Key.UserId", key.GetUserId(), "Error", err.Error())
+		return nil, fmt.Errorf("invalid API key")
+	}
+	return &apiRequest{
+		key:   key,
+		user:  user,
+		perms: key.GetEffectivePermissions(user),
+	}, nil
+}
+
+// Checks the request has perm, if it doesn't a 401 or 403 error is written and false is returned.
+func (a *DbApi2) requirePermission(w http.ResponseWriter, r *http.Request, req *apiRequest, perm filedb.Permission) bool {
+	if req.key == nil {
+		writeApiError(w, r, http.StatusUnauthorized, fmt.Sprintf("A API key must be provided in the '%s' header", ApiKeyHeader))
+		return false
+	}
+	if !req.perms.Has(perm) {
+		slog.Info("API key missing permission", "Path", r.URL.Path, "Key.Id", req.key.GetId(), "Required", perm.String(), "Has", req.perms.String())
+		writeApiErrorData(w, r, http.StatusForbidden, apiError{
+			Message:    fmt.Sprintf("API key requires the '%s' permission", perm),
+			Permission: perm.String(),
+		})
+		return false
+	}
+	return true
+}
+
+// Register a endpoint, only methods are allowed and the requests key must have perm.
+//
+// If perm is PermissionNone requests without a key are allowed.
+func (a *DbApi2) handle(mux *http.ServeMux, path string, methods []string, perm filedb.Permission, h apiHandler) {
+	mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+		if !slices.Contains(methods, r.Method) {
+			w.Header().Set("Allow", strings.Join(methods, ", "))
+			writeApiError(w, r, http.StatusMethodNotAllowed, fmt.Sprintf("Must be a '%s' request", strings.Join(methods, "' or '")))
+			return
+		}
+		req, err := authenticate(a.db, r)
+		if err != nil {
+			slog.Info("Rejected API request", "Path", r.URL.Path, "RemoteAddr", r.RemoteAddr, "Error", err.Error())
+			writeApiError(w, r, http.StatusUnauthorized, err.Error())
+			return
+		}
+		if perm != filedb.PermissionNone && !a.requirePermission(w, r, req, perm) {
+			return
+		}
+		h(w, r, req)
+	})
+}
+
+type versionData struct {
+	String   string
+	CodeName string
+	Major    int
+	Minor    int
+	Revision int
+}
+
+type statusVersion struct {
+	Database versionData
+	FileDb   versionData
+}
+
+// Info about the key making the request
+type statusKey struct {
+	Id          int
+	Name        string
+	User        string
+	AccountType string
+	Permissions []string
+	Created     time.Time
+}
+
+type statusInfo struct {
+	VersionInfo statusVersion
+	InSafeMode  bool
+	Key         *statusKey       // nil if no key was provided
+	RateLimit   *RateLimitStatus // nil if there is no rate limit
+}
+
+func (a *DbApi2) GetStatus(w http.ResponseWriter, r *http.Request, req *apiRequest) {
+	meta, err := a.db.GetMetadata()
+	if err != nil {
+		writeApiError(w, r, http.StatusInternalServerError, fmt.Sprintf("Failed to get version from database: %v", err))
+		return
+	}
+	status := statusInfo{
+		VersionInfo: statusVersion{
+			FileDb: versionData{
+				String:   filedb.FormatVersion(filedb.MajorVersion, filedb.MinorVersion, filedb.Revision),
+				CodeName: filedb.VersionCodeName,
+				Major:    filedb.MajorVersion,
+				Minor:    filedb.MinorVersion,
+				Revision: filedb.Revision,
+			},
+			Database: versionData{
+				String:   meta.VersionString(),
+				CodeName: meta.VersionCodeName,
+				Major:    meta.MajorVersion,
+				Minor:    meta.MinorVersion,
+				Revision: meta.RevisionVersion,
+			},
+		},
+		InSafeMode: a.db.IsSafeMode(),
+	}
+	if req.key != nil {
+		status.Key = &statusKey{
+			Id:          req.key.GetId(),
+			Name:        req.key.GetName(),
+			User:        req.user.GetName(),
+			AccountType: req.user.GetAccountType().String(),
+			Permissions: req.perms.Names(),
+			Created:     req.key.GetCreated(),
+		}
+	}
+	if a.limiter != nil {
+		status.RateLimit = a.limiter.Status(r)
+	}
+	writeApiData(w, r, status)
+}
+
+type apiFile struct {
+	Id         int
+	Path       string
+	Name       string
+	Tags       []string
+	LastViewed time.Time
+	Stars      uint8
+	Size       int64
+	Meta       *metadata.Metadata // Embedded metadata, null if none was read
+	Mime       string             // Like 'video/mp4', empty if it isn't known
+	Category   string             // image, audio or video, empty if it isn't known
+}
+
+func filesToApiFile(files []*filedb.File) []*apiFile {
+	apiFiles := make([]*apiFile, len(files))
+	for i, v := range files {
+		apiFiles[i] = &apiFile{
+			Id:         v.GetId(),
+			Path:       v.GetPath(),
+			Name:       v.GetName(),
+			Tags:       v.GetTags(),
+			LastViewed: v.GetLastPlayTime(),
+			Stars:      v.GetStars(),
+			Size:       v.GetSize(),
+			Meta:       v.GetMeta(),
+			Mime:       v.GetMime(),
+			Category:   v.GetCategory(),
+		}
+	}
+	return apiFiles
+}
+
+type apiUser struct {
+	Id          int
+	Name        string
+	AccountType string
+	Created     time.Time
+}
+
+func userToApiUser(u *filedb.User) *apiUser {
+	return &apiUser{
+		Id:          u.GetId(),
+		Name:        u.GetName(),
+		AccountType: u.GetAccountType().String(),
+		Created:     u.GetCreated(),
+	}
+}
+
+// Get a file from a id string, if it fails a error is written and nil is returned.
+func (a *DbApi2) fileFromId(w http.ResponseWriter, r *http.Request, name string, idStr string) *filedb.File {
+	if idStr == "" {
+		writeApiError(w, r, http.StatusBadRequest, fmt.Sprintf("'%s' must be set", name))
+		return nil
+	}
+	id, err := strconv.ParseUint(idStr, 0, 64)
+	if err != nil {
+		writeApiError(w, r, http.StatusBadRequest, fmt.Sprintf("invalid '%s' value '%s'", name, idStr))
+		return nil
+	}
+	f, err := a.db.GetFileById(int(id))
+	if err != nil {
+		writeApiError(w, r, http.StatusNotFound, fmt.Sprintf("file %d not found", id))
+		return nil
+	}
+	return f
+}
+
+// Get a user from a id string, if it fails a error is written and nil is returned.
+func (a *DbApi2) userFromId(w http.ResponseWriter, r *http.Request, name string, idStr string) *filedb.User {
+	if idStr == "" {
+		writeApiError(w, r, http.StatusBadRequest, fmt.Sprintf("'%s' must be set", name))
+		return nil
+	}
+	id, err := strconv.ParseUint(idStr, 0, 64)
+	if err != nil {
+		writeApiError(w, r, http.StatusBadRequest, fmt.Sprintf("invalid '%s' value '%s'", name, idStr))
+		return nil
+	}
+	u, err := a.db.GetUserById(int(id))
+	if err != nil {
+		writeApiError(w, r, http.StatusNotFound, fmt.Sprintf("user %d not found", id))
+		return nil
+	}
+	return u
+}
+
+// Checks the request can modify or remove target, users can only manage account types below their own unless the key has 'admin'.
+func (a *DbApi2) canManageUser(req *apiRequest, target filedb.AccountType) bool {
+	if req.perms.Has(filedb.PermissionAdmin) {
+		return true
+	}
+	return target < req.user.GetAccountType()
+}
+
+// Serve file content
+//
+// Method: GET, HEAD
+//
+// URL: /api/2/content
+//
+// Requires: read, user-write if update is set
+//
+// Query Params:
+//   - id: File id
+//   - update: Should the keys last viewed time be updated (true/false), default: false. Ignored on HEAD requests
+//
+// Returns: File content, this is not in the API format
+func (a *DbApi2) ServeFile(w http.ResponseWriter, r *http.Request, req *apiRequest) {
+	qr := r.URL.Query()
+	file := a.fileFromId(w, r, "id", qr.Get("id"))
+	if file == nil {
+		return
+	}
+	if r.Method == http.MethodGet && qr.Get("update") == "true" {
+		if !a.requirePermission(w, r, req, filedb.PermissionUserWrite) {
+			return
+		}
+		err := a.db.LoadUserData(req.user, file)
+		if err != nil {
+			writeApiError(w, r, http.StatusInternalServerError, fmt.Sprintf("Failed to get user data: %v", err))
+			return
+		}
+		file.MarkFileRead()
+		err = a.db.UpdateFile(file)
+		if err != nil {
+			slog.Warn("Failed to update last viewed time", "File.Id", file.GetId(), "Error", err.Error())
+		}
+	}
+	_, err := os.Stat(file.GetPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			writeApiError(w, r, http.StatusNotFound, "file exists in database, but not on disk.")
+			return
+		}
+		writeApiError(w, r, http.StatusInternalServerError, fmt.Sprintf("error accessing file: %v", err))
+		return
+	}
+	// Otherwise it's guessed from the extension
+	if file.GetMime() != "" {
+		w.Header().Set("Content-Type", file.GetMime())
+	}
+	// Handles HEAD & ranges for us
+	http.ServeFile(w, r, file.GetPath())
+}
+
+// Get info about files, with the keys stars & last viewed times
+//
+// Method: GET
+//
+// URL: /api/2/info
+//
+// Requires: read
+//
+// Query Params:
+//   - id: File ids, can have multiple
+//   - path: File paths, can have multiple
+//
+// Returns: File array
+func (a *DbApi2) GetFileInfo(w http.ResponseWriter, r *http.Request, req *apiRequest) {
+	qr := r.URL.Query()
+	files := make([]*filedb.File, 0)
+	for _, p := range qr["path"] {
+		f, err := a.db.GetFileByPath(p)
+		if err != nil {
+			writeApiError(w, r, http.StatusNotFound, fmt.Sprintf("Failed to find file by path '%s'", p))
+			return
+		}
+		files = append(files, f)
+	}
+	for _, i := range qr["id"] {
+		f := a.fileFromId(w, r, "id", i)
+		if f == nil {
+			return
+		}
+		files = append(files, f)
+	}
+	err := a.db.LoadUserData(req.user, files...)
+	if err != nil {
+		writeApiError(w, r, http.StatusInternalServerError, fmt.Sprintf("Failed to get user data: %v", err))
+		return
+	}
+	writeApiData(w, r, filesToApiFile(files))
+}
+
+// Set the keys stars for a file
+//
+// Method: POST
+//
+// URL: /api/2/setstars
+//
+// Requires: user-write
+//
+// Post Data:
+//   - Id: File id
+//   - Stars: New star count, 0 to 5
+//
+// Returns: Empty API response
+func (a *DbApi2) SetStars(w http.ResponseWriter, r *http.Request, req *apiRequest) {
+	file := a.fileFromId(w, r, "Id", r.PostFormValue("Id"))
+	if file == nil {
+		return
+	}
+	stars, err := strconv.ParseUint(r.PostFormValue("Stars"), 0, 8)
+	if err != nil {
+		writeApiError(w, r, http.StatusBadRequest, "invalid 'Stars' value")
+		return
+	}
+	err = a.db.LoadUserData(req.user, file)
+	if err != nil {
+		writeApiError(w, r, http.StatusInternalServerError, fmt.Sprintf("Failed to get user data: %v", err))
+		return
+	}
+	err = file.SetStars(uint8(stars))
+	if err != nil {
+		writeApiError(w, r, http.StatusBadRequest, fmt.Sprintf("failed to set 'Stars' value: %v", err))
+		return
+	}
+	err = a.db.UpdateFile(file)
+	if err != nil {
+		slog.Warn("Failed to update file", "File.Id", file.GetId(), "Error", err.Error())
+		writeApiError(w, r, http.StatusInternalServerError, fmt.Sprintf("failed to update file: %v", err))
+		return
+	}
+	writeApiData(w, r, nil)
+}
+
+// Update a files tags
+//
+// Method: POST
+//
+// URL: /api/2/update
+//
+// Requires: global-modify, global-write if AddNonExistTags is set
+//
+// Post Data:
+//   - Id: File id
+//   - AddTags: Tags to add, can have multiple. These must already exist unless AddNonExistTags is set
+//   - RemTags: Tags to remove, can have multiple
+//   - AddNonExistTags: Create tags in AddTags that don't exist (true/false), default: false
+//
+// Returns: Empty API response
+func (a *DbApi2) UpdateFile(w http.ResponseWriter, r *http.Request, req *apiRequest) {
+	file := a.fileFromId(w, r, "Id", r.PostFormValue("Id"))
+	if file == nil {
+		return
+	}
+	addNonExist := r.PostFormValue("AddNonExistTags") == "true"
+	if addNonExist && !a.requirePermission(w, r, req, filedb.PermissionGlobalWrite) {
+		return
+	}
+	for _, v := range r.PostForm["AddTags"] {
+		if !addNonExist && !a.db.HasTag(v) {
+			writeApiError(w, r, http.StatusBadRequest, fmt.Sprintf("tag '%s' doesn't exist, set 'AddNonExistTags' to create it", v))
+			return
+		}
+		err := file.AddTag(v)
+		if err != nil {
+			writeApiError(w, r, http.StatusBadRequest, fmt.Sprintf("failed to add tag '%s': %v", v, err))
+			return
+		}
+	}
+	for _, v := range r.PostForm["RemTags"] {
+		file.RemoveTag(v)
+	}
+	err := a.db.UpdateFile(file)
+	if err != nil {
+		slog.Warn("Failed to update file", "File.Id", file.GetId(), "Error", err.Error())
+		writeApiError(w, r, http.StatusInternalServerError, fmt.Sprintf("failed to update file: %v", err))
+		return
+	}
+	writeApiData(w, r, nil)
+}
+
+// Parse search query params, see DbApi2.SearchFile
+func parseSearchQuery(qr url.Values) (*filedb.SearchQuery, error) {
+	search := &filedb.SearchQuery{
+		Path:             qr.Get("path"),
+		PathRe:           qr.Get("path_re"),
+		WhitelistTags:    qr["tag_whitelist"],
+		BlacklistTags:    qr["tag_blacklist"],
+		SortReverse:      qr.Get("sort_reverse") == "true",
+		Index:            0,
+		Count:            50,
+		IgnoreUnsetStars: qr.Get("ignore_unset") == "true",
+		Text:             qr.Get("text"),
+		Query:            qr.Get("q"),
+	}
+	if idxStr := qr.Get("index"); idxStr != "" {
+		index, err := strconv.ParseUint(idxStr, 0, 64)
+		if err != nil {
+			return nil, errors.New("invalid 'index' value")
+		}
+		search.Index = int64(index)
+	}
+	if cntStr := qr.Get("count"); cntStr != "" {
+		count, err := strconv.ParseUint(cntStr, 0, 64)
+		if err != nil {
+			return nil, errors.New("invalid 'count' value")
+		}
+		// Max count value (For performance reasons) is 200
+		if count > 200 {
+			return nil, errors.New("'count' cannot exceed 200")
+		}
+		search.Count = int64(count)
+	}
+	switch qr.Get("sort") {
+	case "none", "":
+		search.SortBy = filedb.SortMethodNone
+	case "size":
+		search.SortBy = filedb.SortMethodSize
+	case "stars":
+		search.SortBy = filedb.SortMethodStars
+	case "date":
+		search.SortBy = filedb.SortMethodLastViewed
+	case "id":
+		search.SortBy = filedb.SortMethodId
+	case "random":
+		search.SortBy = filedb.SortMethodRandom
+	case "average_stars":
+		search.SortBy = filedb.SortMethodAverageStars
+	case "popularity":
+		search.SortBy = filedb.SortMethodPopularity
+	case "global_date":
+		search.SortBy = filedb.SortMethodGlobalLastViewed
+	case "relevance":
+		search.SortBy = filedb.SortMethodRelevance
+	case "title":
+		search.SortBy = filedb.SortMethodTitle
+	case "artist":
+		search.SortBy = filedb.SortMethodArtist
+	case "album":
+		search.SortBy = filedb.SortMethodAlbum
+	case "duration":
+		search.SortBy = filedb.SortMethodDuration
+	case "taken":
+		search.SortBy = filedb.SortMethodTaken
+	case "resolution":
+		search.SortBy = filedb.SortMethodResolution
+	default:
+		return nil, errors.New("invalid 'sort' value, must be one of 'none', 'size', 'stars', 'date', 'id', 'random', 'average_stars', 'popularity', 'global_date', 'relevance', 'title', 'artist', 'album', 'duration', 'taken' or 'resolution'")
+	}
+	return search, nil
+}
+
+// Checks the request can sort by sortBy, sorting by other users data requires global-read. If not a error is written and false is returned.
+func (a *DbApi2) requireSortPermission(w http.ResponseWriter, r *http.Request, req *apiRequest, sortBy filedb.SortMethod) bool {
+	switch sortBy {
+	case filedb.SortMethodAverageStars, filedb.SortMethodPopularity, filedb.SortMethodGlobalLastViewed:
+		return a.requirePermission(w, r, req, filedb.PermissionGlobalRead)
+	}
+	return true
+}
+
+// Search for files, stars & last viewed times are the keys
+//
+// Method: GET
+//
+// URL: /api/2/search
+//
+// Requires: read
+//
+// Query Params:
+//   - path: Search Path for any path that contains this value
+//   - path_re: Regex match
+//   - tag_whitelist: Whitelisted tags, multiple max exist
+//   - tag_blacklist: Blacklisted tags, multiple max exist
+//   - text: Words that must be in the path, name or tags. "Quoted words" are a phrase, a trailing '*' matches prefixes
+//   - q: Query language filter, e.g. '(tag:anime OR tag:cartoon) -tag:watched stars>=4'. See filedb.QueryNode
+//   - count: Number of results to return, max 200. Default: 50
+//   - index: Index to start at
+//   - sort: Sort method, values are 'none', 'size', 'stars', 'date', 'id', 'random', 'average_stars', 'popularity', 'global_date', 'relevance', 'title', 'artist', 'album', 'duration', 'taken' and 'resolution'. Default: none, which is relevance when 'text' is used
+//     'average_stars' (average stars of every user), 'popularity' (users who viewed or starred the file) & 'global_date' (latest view by any user) require global-read
+//   - sort_reverse: boolean, reverse search order (From ascending to descending) default: false
+//   - ignore_unset: boolean, 'average_stars' ignores users who haven't starred the file. Default: false
+//
+// Returns: File array
+func (a *DbApi2) SearchFile(w http.ResponseWriter, r *http.Request, req *apiRequest) {
+	search, err := parseSearchQuery(r.URL.Query())
+	if err != nil {
+		writeApiError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !a.requireSortPermission(w, r, req, search.SortBy) {
+		return
+	}
+	search.UserId = req.user.GetId()
+	files, err := a.db.SearchFile(search)
+	if err != nil {
+		writeApiError(w, r, http.StatusBadRequest, fmt.Sprintf("Search failed: %v", err))
+		return
+	}
+	writeApiData(w, r, filesToApiFile(files))
+}
+
+type apiSavedSearch struct {
+	Id               int
+	Name             string
+	Query            string
+	Sort             string
+	SortReverse      bool
+	IgnoreUnsetStars bool
+	Created          time.Time
+}
+
+func savedSearchToApi(s *filedb.SavedSearch) *apiSavedSearch {
+	return &apiSavedSearch{
+		Id:               s.GetId(),
+		Name:             s.GetName(),
+		Query:            s.GetQuery(),
+		Sort:             s.GetSortBy().String(),
+		SortReverse:      s.GetSortReverse(),
+		IgnoreUnsetStars: s.GetIgnoreUnsetStars(),
+		Created:          s.GetCreated(),
+	}
+}
+
+// List the saved searches of the keys user
+//
+// Method: GET
+//
+// URL: /api/2/savedsearches
+//
+// Requires: read
+//
+// Returns: Saved search array, ordered by name
+func (a *DbApi2) GetSavedSearches(w http.ResponseWriter, r *http.Request, req *apiRequest) {
+	searches, err := a.db.GetSavedSearches(req.user)
+	if err != nil {
+		writeApiError(w, r, http.StatusInternalServerError, fmt.Sprintf("Failed to get saved searches: %v", err))
+		return
+	}
+	data := make([]*apiSavedSearch, len(searches))
+	for i, v := range searches {
+		data[i] = savedSearchToApi(v)
+	}
+	writeApiData(w, r, data)
+}
+
+// Run a saved search of the keys user
+//
+// Method: GET
+//
+// URL: /api/2/savedsearch
+//
+// Requires: read, global-read if the search sorts by other users data
+//
+// Query Params:
+//   - name: Name of the saved search
+//   - count: Number of results to return, max 200. Default: 50
+//   - index: Index to start at
+//
+// Returns: File array
+func (a *DbApi2) RunSavedSearch(w http.ResponseWriter, r *http.Request, req *apiRequest) {
+	qr := r.URL.Query()
+	name := qr.Get("name")
+	if name == "" {
+		writeApiError(w, r, http.StatusBadRequest, "missing 'name' query parameter")
+		return
+	}
+	// Only count & index are used
+	page, err := parseSearchQuery(url.Values{"count": qr["count"], "index": qr["index"]})
+	if err != nil {
+		writeApiError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	s, err := a.db.GetSavedSearch(req.user, name)
+	if err != nil {
+		writeApiError(w, r, http.StatusNotFound, fmt.Sprintf("saved search '%s' not found", name))
+		return
+	}
+	if !a.requireSortPermission(w, r, req, s.GetSortBy()) {
+		return
+	}
+	files, err := a.db.RunSavedSearch(s, page.Count, page.Index)
+	if err != nil {
+		writeApiError(w, r, http.StatusBadRequest, fmt.Sprintf("Search failed: %v", err))
+		return
+	}
+	writeApiData(w, r, filesToApiFile(files))
+}
+
+// Save a search for the keys user
+//
+// Method: POST
+//
+// URL: /api/2/addsavedsearch
+//
+// Requires: user-write, global-read if the search sorts by other users data
+//
+// Post Data:
+//   - Name: Name of the saved search, must be unique for the user
+//   - path, path_re, tag_whitelist, tag_blacklist, text, q, sort, sort_reverse & ignore_unset: Same as /api/2/search, the filters are saved as a query
+//
+// Returns: The saved search
+func (a *DbApi2) AddSavedSearch(w http.ResponseWriter, r *http.Request, req *apiRequest) {
+	name := r.PostFormValue("Name")
+	if name == "" {
+		writeApiError(w, r, http.StatusBadRequest, "'Name' must be set")
+		return
+	}
+	search, err := parseSearchQuery(r.PostForm)
+	if err != nil {
+		writeApiError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !a.requireSortPermission(w, r, req, search.SortBy) {
+		return
+	}
+	s, err := a.db.AddSavedSearch(req.user, name, search)
+	if err != nil {
+		writeApiError(w, r, http.StatusBadRequest, fmt.Sprintf("Failed to save search: %v", err))
+		return
+	}
+	writeApiData(w, r, savedSearchToApi(s))
+}
+
+// Remove a saved search of the keys user
+//
+// Method: DELETE
+//
+// URL: /api/2/removesavedsearch
+//
+// Requires: user-write
+//
+// Query Params:
+//   - name: Name of the saved search
+//
+// Returns: Empty API response
+func (a *DbApi2) RemoveSavedSearch(w http.ResponseWriter, r *http.Request, req *apiRequest) {
+	name := r.URL.Query().Get("name")
+	if name == "" {
+		writeApiError(w, r, http.StatusBadRequest, "missing 'name' query parameter")
+		return
+	}
+	s, err := a.db.GetSavedSearch(req.user, name)
+	if err != nil {
+		writeApiError(w, r, http.StatusNotFound, fmt.Sprintf("saved search '%s' not found", name))
+		return
+	}
+	err = a.db.RemoveSavedSearch(s)
+	if err != nil {
+		writeApiError(w, r, http.StatusInternalServerError, fmt.Sprintf("Failed to remove saved search: %v", err))
+		return
+	}
+	writeApiData(w, r, nil)
+}
+
+type apiCollection struct {
+	Id          int
+	Name        string
+	Description string
+	CoverId     int // 0 if there is no cover
+	Count       int
+	Created     time.Time
+}
+
+type apiCollectionFiles struct {
+	Collection *apiCollection
+	Files      []*apiFile
+}
+
+func collectionToApi(c *filedb.Collection) *apiCollection {
+	return &apiCollection{
+		Id:          c.GetId(),
+		Name:        c.GetName(),
+		Description: c.GetDescription(),
+		CoverId:     c.GetCoverId(),
+		Count:       c.GetCount(),
+		Created:     c.GetCreated(),
+	}
+}
+
+// Get a collection by name, if it fails a error is written and nil is returned.
+func (a *DbApi2) collectionFromName(w http.ResponseWriter, r *http.Request, field string, name string) *filedb.Collection {
+	if name == "" {
+		writeApiError(w, r, http.StatusBadRequest, fmt.Sprintf("'%s' must be set", field))
+		return nil
+	}
+	c, err := a.db.GetCollection(name)
+	if err != nil {
+		writeApiError(w, r, http.StatusNotFound, fmt.Sprintf("collection '%s' not found", name))
+		return nil
+	}
+	return c
+}
+
+// Parse a optional 'Index' value, if it isn't set it's -1 (The end). If it fails a error is written and false is returned.
+func parseCollectionIndex(w http.ResponseWriter, r *http.Request, s string) (int, bool) {
+	if s == "" {
+		return -1, true
+	}
+	index, err := strconv.ParseInt(s, 0, 32)
+	if err != nil {
+		writeApiError(w, r, http.StatusBadRequest, fmt.Sprintf("invalid 'Index' value '%s'", s))
+		return 0, false
+	}
+	return int(index), true
+}
+
+// List collections
+//
+// Method: GET
+//
+// URL: /api/2/collections
+//
+// Requires: read
+//
+// Query Params:
+//   - id: Only list collections this file is in, optional
+//
+// Returns: Collection array, ordered by name
+func (a *DbApi2) GetCollections(w http.ResponseWriter, r *http.Request, req *apiRequest) {
+	var collections []*filedb.Collection
+	var err error
+	if idStr := r.URL.Query().Get("id"); idStr != "" {
+		file := a.fileFromId(w, r, "id", idStr)
+		if file == nil {
+			return
+		}
+		collections, err = a.db.GetFileCollections(file)
+	} else {
+		collections, err = a.db.GetCollections()
+	}
+	if err != nil {
+		writeApiError(w, r, http.StatusInternalServerError, fmt.Sprintf("Failed to get collections: %v", err))
+		return
+	}
+	data := make([]*apiCollection, len(collections))
+	for i, v := range collections {
+		data[i] = collectionToApi(v)
+	}
+	writeApiData(w, r, data)
+}
+
+// Get a collection & its files in order, stars & last viewed times are the keys users
+//
+// Method: GET
+//
+// URL: /api/2/collection
+//
+// Requires: read
+//
+// Query Params:
+//   - name: Name of the collection
+//
+// Returns: The collection & a file array
+func (a *DbApi2) GetCollection(w http.ResponseWriter, r *http.Request, req *apiRequest) {
+	c := a.collectionFromName(w, r, "name", r.URL.Query().Get("name"))
+	if c == nil {
+		return
+	}
+	files, err := a.db.GetCollectionFiles(c, req.user.GetId())
+	if err != nil {
+		writeApiError(w, r, http.StatusInternalServerError, fmt.Sprintf("Failed to get collection files: %v", err))
+		return
+	}
+	writeApiData(w, r, &apiCollectionFiles{
+		Collection: collectionToApi(c),
+		Files:      filesToApiFile(files),
+	})
+}
+
+// Create a empty collection
+//
+// Method: POST
+//
+// URL: /api/2/addcollection
+//
+// Requires: global-write
+//
+// Post Data:
+//   - Name: Name of the collection, must be unique
+//   - Description: Description of the collection, optional
+//
+// Returns: The collection
+func (a *DbApi2) AddCollection(w http.ResponseWriter, r *http.Request, req *apiRequest) {
+	name := r.PostFormValue("Name")
+	if name == "" {
+		writeApiError(w, r, http.StatusBadRequest, "'Name' must be set")
+		return
+	}
+	c, err := a.db.AddCollection(name, r.PostFormValue("Description"))
+	if err != nil {
+		writeApiError(w, r, http.StatusBadRequest, fmt.Sprintf("Failed to add collection: %v", err))
+		return
+	}
+	slog.Info("Added collection", "Collection.Id", c.GetId(), "Collection.Name", c.GetName(), "Key.Id", req.key.GetId())
+	writeApiData(w, r, collectionToApi(c))
+}
+
+// Rename a collection or change its description or cover, values that aren't set aren't changed
+//
+// Method: POST
+//
+// URL: /api/2/updatecollection
+//
+// Requires: global-modify
+//
+// Post Data:
+//   - Name: Name of the collection
+//   - NewName: New name, must be unique
+//   - Description: New description
+//   - Cover: File id of the new cover, 0 removes it
+//
+// Returns: The collection
+func (a *DbApi2) UpdateCollection(w http.ResponseWriter, r *http.Request, req *apiRequest) {
+	c := a.collectionFromName(w, r, "Name", r.PostFormValue("Name"))
+	if c == nil {
+		return
+	}
+	if r.PostForm.Has("NewName") {
+		if err := c.SetName(r.PostForm.Get("NewName")); err != nil {
+			writeApiError(w, r, http.StatusBadRequest, fmt.Sprintf("invalid 'NewName': %v", err))
+			return
+		}
+	}
+	if r.PostForm.Has("Description") {
+		c.SetDescription(r.PostForm.Get("Description"))
+	}
+	if r.PostForm.Has("Cover") {
+		var cover *filedb.File
+		if idStr := r.PostForm.Get("Cover"); idStr != "0" {
+			cover = a.fileFromId(w, r, "Cover", idStr)
+			if cover == nil {
+				return
+			}
+		}
+		c.SetCover(cover)
+	}
+	err := a.db.UpdateCollection(c)
+	if err != nil {
+		writeApiError(w, r, http.StatusBadRequest, fmt.Sprintf("Failed to update collection: %v", err))
+		return
+	}
+	writeApiData(w, r, collectionToApi(c))
+}
+
+// Remove a collection, the files in it are kept
+//
+// Method: DELETE
+//
+// URL: /api/2/removecollection
+//
+// Requires: global-write
+//
+// Query Params:
+//   - name: Name of the collection
+//
+// Returns: Empty API response
+func (a *DbApi2) RemoveCollection(w http.ResponseWriter, r *http.Request, req *apiRequest) {
+	c := a.collectionFromName(w, r, "name", r.URL.Query().Get("name"))
+	if c == nil {
+		return
+	}
+	id := c.GetId()
+	err := a.db.RemoveCollection(c)
+	if err != nil {
+		writeApiError(w, r, http.StatusInternalServerError, fmt.Sprintf("Failed to remove collection: %v", err))
+		return
+	}
+	slog.Info("Removed collection", "Collection.Id", id, "Collection.Name", c.GetName(), "Key.Id", req.key.GetId())
+	writeApiData(w, r, nil)
+}
+
+// Insert files into a collection
+//
+// Method: POST
+//
+// URL: /api/2/addcollectionitems
+//
+// Requires: global-modify
+//
+// Post Data:
+//   - Name: Name of the collection
+//   - Id: File ids to insert in order, can have multiple
+//   - Index: Index to insert them at, if not set they are added to the end
+//
+// Returns: The collection
+func (a *DbApi2) AddCollectionItems(w http.ResponseWriter, r *http.Request, req *apiRequest) {
+	c := a.collectionFromName(w, r, "Name", r.PostFormValue("Name"))
+	if c == nil {
+		return
+	}
+	index, ok := parseCollectionIndex(w, r, r.PostForm.Get("Index"))
+	if !ok {
+		return
+	}
+	if len(r.PostForm["Id"]) == 0 {
+		writeApiError(w, r, http.StatusBadRequest, "'Id' must be set")
+		return
+	}
+	files := make([]*filedb.File, len(r.PostForm["Id"]))
+	for i, v := range r.PostForm["Id"] {
+		files[i] = a.fileFromId(w, r, "Id", v)
+		if files[i] == nil {
+			return
+		}
+	}
+	err := a.db.InsertCollectionItems(c, index, files...)
+	if err != nil {
+		writeApiError(w, r, http.StatusBadRequest, fmt.Sprintf("Failed to add files: %v", err))
+		return
+	}
+	writeApiData(w, r, collectionToApi(c))
+}
+
+// Remove files from a collection
+//
+// Method: POST
+//
+// URL: /api/2/removecollectionitems
+//
+// Requires: global-modify
+//
+// Post Data:
+//   - Name: Name of the collection
+//   - Id: File ids to remove, can have multiple
+//
+// Returns: The collection
+func (a *DbApi2) RemoveCollectionItems(w http.ResponseWriter, r *http.Request, req *apiRequest) {
+	c := a.collectionFromName(w, r, "Name", r.PostFormValue("Name"))
+	if c == nil {
+		return
+	}
+	if len(r.PostForm["Id"]) == 0 {
+		writeApiError(w, r, http.StatusBadRequest, "'Id' must be set")
+		return
+	}
+	files := make([]*filedb.File, len(r.PostForm["Id"]))
+	for i, v := range r.PostForm["Id"] {
+		files[i] = a.fileFromId(w, r, "Id", v)
+		if files[i] == nil {
+			return
+		}
+	}
+	err := a.db.RemoveCollectionItems(c, files...)
+	if err != nil {
+		writeApiError(w, r, http.StatusBadRequest, fmt.Sprintf("Failed to remove files: %v", err))
+		return
+	}
+	writeApiData(w, r, collectionToApi(c))
+}
+
+// Move a file in a collection
+//
+// Method: POST
+//
+// URL: /api/2/movecollectionitem
+//
+// Requires: global-modify
+//
+// Post Data:
+//   - Name: Name of the collection
+//   - Id: File id to move
+//   - Index: Index to move it to, if not set it is moved to the end
+//
+// Returns: The collection
+func (a *DbApi2) MoveCollectionItem(w http.ResponseWriter, r *http.Request, req *apiRequest) {
+	c := a.collectionFromName(w, r, "Name", r.PostFormValue("Name"))
+	if c == nil {
+		return
+	}
+	index, ok := parseCollectionIndex(w, r, r.PostForm.Get("Index"))
+	if !ok {
+		return
+	}
+	file := a.fileFromId(w, r, "Id", r.PostForm.Get("Id"))
+	if file == nil {
+		return
+	}
+	err := a.db.MoveCollectionItem(c, file, index)
+	if err != nil {
+		writeApiError(w, r, http.StatusBadRequest, fmt.Sprintf("Failed to move file: %v", err))
+		return
+	}
+	writeApiData(w, r, collectionToApi(c))
+}
+
+// Delete a tag from the database and every file
+//
+// Method: DELETE
+//
+// URL: /api/2/deletetag
+//
+// Requires: global-write
+//
+// Query Params:
+//   - tag: Tag to delete
+//
+// Returns: Empty API response
+func (a *DbApi2) DeleteTag(w http.ResponseWriter, r *http.Request, req *apiRequest) {
+	tag := r.URL.Query().Get("tag")
+	if tag == "" {
+		writeApiError(w, r, http.StatusBadRequest, "missing 'tag' query parameter")
+		return
+	}
+	err := a.db.RemoveTag(tag)
+	if err != nil {
+		writeApiError(w, r, http.StatusBadRequest, fmt.Sprintf("Failed to remove tag '%s': %v", tag, err))
+		return
+	}
+	slog.Info("Removed tag", "Tag", tag, "Key.Id", req.key.GetId())
+	writeApiData(w, r, nil)
+}
+
+// Remove a file from the database, it isn't removed from disk
+//
+// Method: DELETE
+//
+// URL: /api/2/deletefile
+//
+// Requires: global-write
+//
+// Query Params:
+//   - id: File id
+//
+// Returns: Empty API response
+func (a *DbApi2) DeleteFile(w http.ResponseWriter, r *http.Request, req *apiRequest) {
+	file := a.fileFromId(w, r, "id", r.URL.Query().Get("id"))
+	if file == nil {
+		return
+	}
+	err := a.db.RemoveFile(file)
+	if err != nil {
+		writeApiError(w, r, http.StatusInternalServerError, fmt.Sprintf("Failed to remove file: %v", err))
+		return
+	}
+	slog.Info("Removed file", "File.Id", file.GetId(), "File.Path", file.GetPath(), "Key.Id", req.key.GetId())
+	writeApiData(w, r, nil)
+}
+
+// Get every tag
+//
+// Method: GET
+//
+// URL: /api/2/gettags
+//
+// Requires: read
+//
+// Query Params:
+//   - tree: If 'true' the tags are returned as a tree of namespaces & parents
+//
+// Returns: Sorted string array, or a array of tag nodes if tree is set
+func (a *DbApi2) GetAllTags(w http.ResponseWriter, r *http.Request, req *apiRequest) {
+	if r.URL.Query().Get("tree") == "true" {
+		tree, err := a.db.GetTagTree()
+		if err != nil {
+			writeApiError(w, r, http.StatusInternalServerError, fmt.Sprintf("Failed to get tags: %v", err))
+			return
+		}
+		writeApiData(w, r, tree)
+		return
+	}
+	tags := make([]string, 0)
+	for _, v := range a.db.GetAllTags() {
+		tags = append(tags, v)
+	}
+	sort.Strings(tags)
+	writeApiData(w, r, tags)
+}
+
+// Add a tag to the database
+//
+// Method: POST
+//
+// URL: /api/2/addtag
+//
+// Requires: global-modify
+//
+// Post Data:
+//   - tag: Tag to add
+//
+// Returns: Empty API response
+func (a *DbApi2) AddTag(w http.ResponseWriter, r *http.Request, req *apiRequest) {
+	tag := r.PostFormValue("tag")
+	if tag == "" {
+		writeApiError(w, r, http.StatusBadRequest, "missing 'tag' value")
+		return
+	}
+	_, err := a.db.AddTag(tag)
+	if err != nil {
+		writeApiError(w, r, http.StatusBadRequest, fmt.Sprintf("failed to add tag: %v", err))
+		return
+	}
+	writeApiData(w, r, nil)
+}
+
+// Get every tag implication rule
+//
+// Method: GET
+//
+// URL: /api/2/tagimplications
+//
+// Requires: read
+//
+// Returns: Array of implications, ordered by tag
+func (a *DbApi2) GetTagImplications(w http.ResponseWriter, r *http.Request, req *apiRequest) {
+	implications, err := a.db.GetTagImplications()
+	if err != nil {
+		writeApiError(w, r, http.StatusInternalServerError, fmt.Sprintf("Failed to get tag implications: %v", err))
+		return
+	}
+	writeApiData(w, r, implications)
+}
+
+// Add a tag implication rule, files with the tag or its children get the implied tag. Files that already have the tag get it too
+//
+// Method: POST
+//
+// URL: /api/2/addtagimplication
+//
+// Requires: global-modify
+//
+// Post Data:
+//   - Tag: Tag the rule applies to
+//   - Implies: Tag that is added
+//
+// Returns: Empty API response
+func (a *DbApi2) AddTagImplication(w http.ResponseWriter, r *http.Request, req *apiRequest) {
+	tag, implies := r.PostFormValue("Tag"), r.PostFormValue("Implies")
+	if tag == "" || implies == "" {
+		writeApiError(w, r, http.StatusBadRequest, "'Tag' & 'Implies' must be set")
+		return
+	}
+	err := a.db.AddTagImplication(tag, implies)
+	if err != nil {
+		writeApiError(w, r, http.StatusBadRequest, fmt.Sprintf("Failed to add tag implication: %v", err))
+		return
+	}
+	slog.Info("Added tag implication", "Tag", tag, "Implies", implies, "Key.Id", req.key.GetId())
+	writeApiData(w, r, nil)
+}
+
+// Remove a tag implication rule, files keep the tags it added
+//
+// Method: DELETE
+//
+// URL: /api/2/removetagimplication
+//
+// Requires: global-modify
+//
+// Query Params:
+//   - tag: Tag the rule applies to
+//   - implies: Tag the rule adds
+//
+// Returns: Empty API response
+func (a *DbApi2) RemoveTagImplication(w http.ResponseWriter, r *http.Request, req *apiRequest) {
+	qr := r.URL.Query()
+	err := a.db.RemoveTagImplication(qr.Get("tag"), qr.Get("implies"))
+	if err != nil {
+		writeApiError(w, r, http.StatusNotFound, fmt.Sprintf("Failed to remove tag implication: %v", err))
+		return
+	}
+	slog.Info("Removed tag implication", "Tag", qr.Get("tag"), "Implies", qr.Get("implies"), "Key.Id", req.key.GetId())
+	writeApiData(w, r, nil)
+}
+
+// Rename a tag & its children, implication rules & aliases are updated to use the new name
+//
+// Method: POST
+//
+// URL: /api/2/renametag
+//
+// Requires: global-modify
+//
+// Post Data:
+//   - Tag: Tag to rename
+//   - Name: New name of the tag
+//
+// Returns: Empty API response
+func (a *DbApi2) RenameTag(w http.ResponseWriter, r *http.Request, req *apiRequest) {
+	tag, name := r.PostFormValue("Tag"), r.PostFormValue("Name")
+	if tag == "" || name == "" {
+		writeApiError(w, r, http.StatusBadRequest, "'Tag' & 'Name' must be set")
+		return
+	}
+	err := a.db.RenameTag(tag, name)
+	if err != nil {
+		writeApiError(w, r, http.StatusBadRequest, fmt.Sprintf("Failed to rename tag: %v", err))
+		return
+	}
+	slog.Info("Renamed tag", "Tag", tag, "Name", name, "Key.Id", req.key.GetId())
+	writeApiData(w, r, nil)
+}
+
+// Merge a tag & its children into another tag, files with the tag get the other tag instead
+//
+// Method: POST
+//
+// URL: /api/2/mergetags
+//
+// Requires: global-modify
+//
+// Post Data:
+//   - Tag: Tag to merge, it's removed
+//   - Into: Tag it's merged into
+//
+// Returns: Empty API response
+func (a *DbApi2) MergeTags(w http.ResponseWriter, r *http.Request, req *apiRequest) {
+	tag, into := r.PostFormValue("Tag"), r.PostFormValue("Into")
+	if tag == "" || into == "" {
+		writeApiError(w, r, http.StatusBadRequest, "'Tag' & 'Into' must be set")
+		return
+	}
+	err := a.db.MergeTags(tag, into)
+	if err != nil {
+		writeApiError(w, r, http.StatusBadRequest, fmt.Sprintf("Failed to merge tags: %v", err))
+		return
+	}
+	slog.Info("Merged tags", "Tag", tag, "Into", into, "Key.Id", req.key.GetId())
+	writeApiData(w, r, nil)
+}
+
+// Get every tag alias
+//
+// Method: GET
+//
+// URL: /api/2/tagaliases
+//
+// Requires: read
+//
+// Returns: Array of aliases, ordered by tag
+func (a *DbApi2) GetTagAliases(w http.ResponseWriter, r *http.Request, req *apiRequest) {
+	aliases, err := a.db.GetTagAliases()
+	if err != nil {
+		writeApiError(w, r, http.StatusInternalServerError, fmt.Sprintf("Failed to get tag aliases: %v", err))
+		return
+	}
+	writeApiData(w, r, aliases)
+}
+
+// Add a tag alias, adding or searching for the alias uses the tag instead
+//
+// Method: POST
+//
+// URL: /api/2/addtagalias
+//
+// Requires: global-modify
+//
+// Post Data:
+//   - Alias: Alternative name
+//   - Tag: Tag the alias points to
+//
+// Returns: Empty API response
+func (a *DbApi2) AddTagAlias(w http.ResponseWriter, r *http.Request, req *apiRequest) {
+	alias, tag := r.PostFormValue("Alias"), r.PostFormValue("Tag")
+	if alias == "" || tag == "" {
+		writeApiError(w, r, http.StatusBadRequest, "'Alias' & 'Tag' must be set")
+		return
+	}
+	err := a.db.AddTagAlias(alias, tag)
+	if err != nil {
+		writeApiError(w, r, http.StatusBadRequest, fmt.Sprintf("Failed to add tag alias: %v", err))
+		return
+	}
+	slog.Info("Added tag alias", "Alias", alias, "Tag", tag, "Key.Id", req.key.GetId())
+	writeApiData(w, r, nil)
+}
+
+// Remove a tag alias
+//
+// Method: DELETE
+//
+// URL: /api/2/removetagalias
+//
+// Requires: global-modify
+//
+// Query Params:
+//   - alias: Alias to remove
+//
+// Returns: Empty API response
+func (a *DbApi2) RemoveTagAlias(w http.ResponseWriter, r *http.Request, req *apiRequest) {
+	alias := r.URL.Query().Get("alias")
+	err := a.db.RemoveTagAlias(alias)
+	if err != nil {
+		writeApiError(w, r, http.StatusNotFound, fmt.Sprintf("Failed to remove tag alias: %v", err))
+		return
+	}
+	slog.Info("Removed tag alias", "Alias", alias, "Key.Id", req.key.GetId())
+	writeApiData(w, r, nil)
+}
+
+type apiFileLocation struct {
+	Id    int
+	Path  string
+	Added time.Time
+}
+
+// A file & the other paths it was found at
+type apiDuplicate struct {
+	Hash      string
+	Wasted    int64 // Bytes used by the other locations
+	File      *apiFile
+	Locations []*apiFileLocation
+}
+
+// Get every file that was found at more then one path
+//
+// Method: GET
+//
+// URL: /api/2/duplicates
+//
+// Requires: read
+//
+// Returns: Array of duplicates, the most wasted space first
+func (a *DbApi2) GetDuplicates(w http.ResponseWriter, r *http.Request, req *apiRequest) {
+	groups, err := a.db.GetDuplicates()
+	if err != nil {
+		writeApiError(w, r, http.StatusInternalServerError, fmt.Sprintf("Failed to get duplicates: %v", err))
+		return
+	}
+	data := make([]*apiDuplicate, len(groups))
+	for i, g := range groups {
+		data[i] = &apiDuplicate{
+			Hash:      g.File.GetHash(),
+			Wasted:    g.GetWasted(),
+			File:      filesToApiFile([]*filedb.File{g.File})[0],
+			Locations: make([]*apiFileLocation, len(g.Locations)),
+		}
+		for j, l := range g.Locations {
+			data[i].Locations[j] = &apiFileLocation{Id: l.GetId(), Path: l.GetPath(), Added: l.GetAdded()}
+		}
+	}
+	writeApiData(w, r, data)
+}
+
+// Make a location the canonical copy of its file, the files path becomes a location instead. Nothing on disk is changed
+//
+// Method: POST
+//
+// URL: /api/2/setcanonical
+//
+// Requires: admin
+//
+// Post Data:
+//   - Location: Location id
+//
+// Returns: The updated file
+func (a *DbApi2) SetCanonicalLocation(w http.ResponseWriter, r *http.Request, req *apiRequest) {
+	id, err := strconv.ParseUint(r.PostFormValue("Location"), 0, 64)
+	if err != nil {
+		writeApiError(w, r, http.StatusBadRequest, "'Location' must be a location id")
+		return
+	}
+	f, err := a.db.SetCanonicalLocation(int(id))
+	if err != nil {
+		writeApiError(w, r, http.StatusBadRequest, fmt.Sprintf("Failed to set canonical location: %v", err))
+		return
+	}
+	slog.Info("Set canonical file location", "Location.Id", id, "File.Id", f.GetId(), "File.Path", f.GetPath(), "Key.Id", req.key.GetId())
+	writeApiData(w, r, filesToApiFile([]*filedb.File{f})[0])
+}
+
+// Forget a location of a file, for example after the copy was deleted. Nothing on disk is changed
+//
+// Method: DELETE
+//
+// URL: /api/2/removelocation
+//
+// Requires: admin
+//
+// Query Params:
+//   - id: Location id
+//
+// Returns: Empty API response
+func (a *DbApi2) RemoveFileLocation(w http.ResponseWriter, r *http.Request, req *apiRequest) {
+	id, err := strconv.ParseUint(r.URL.Query().Get("id"), 0, 64)
+	if err != nil {
+		writeApiError(w, r, http.StatusBadRequest, "'id' must be a location id")
+		return
+	}
+	err = a.db.RemoveFileLocation(int(id))
+	if err != nil {
+		writeApiError(w, r, http.StatusNotFound, fmt.Sprintf("Failed to remove location: %v", err))
+		return
+	}
+	slog.Info("Removed file location", "Location.Id", id, "Key.Id", req.key.GetId())
+	writeApiData(w, r, nil)
+}
+
+// Set the keys last viewed time of a file to now
+//
+// Method: POST
+//
+// URL: /api/2/viewed
+//
+// Requires: user-write
+//
+// Post Data:
+//   - Id: File id
+//
+// Returns: Empty API response
+func (a *DbApi2) UpdateFileDate(w http.ResponseWriter, r *http.Request, req *apiRequest) {
+	file := a.fileFromId(w, r, "Id", r.PostFormValue("Id"))
+	if file == nil {
+		return
+	}
+	err := a.db.LoadUserData(req.user, file)
+	if err != nil {
+		writeApiError(w, r, http.StatusInternalServerError, fmt.Sprintf("Failed to get user data: %v", err))
+		return
+	}
+	file.MarkFileRead()
+	err = a.db.UpdateFile(file)
+	if err != nil {
+		writeApiError(w, r, http.StatusInternalServerError, fmt.Sprintf("failed to update file: %v", err))
+		return
+	}
+	writeApiData(w, r, nil)
+}
+
+// Remove a user, their stars, last viewed times & API keys
+//
+// Method: DELETE
+//
+// URL: /api/2/removeuser
+//
+// Requires: global-modify, only account types below the keys can be removed unless the key has 'admin'
+//
+// Query Params:
+//   - id: User id
+//
+// Returns: Empty API response
+func (a *DbApi2) RemoveUser(w http.ResponseWriter, r *http.Request, req *apiRequest) {
+	user := a.userFromId(w, r, "id", r.URL.Query().Get("id"))
+	if user == nil {
+		return
+	}
+	if !a.canManageUser(req, user.GetAccountType()) {
+		writeApiError(w, r, http.StatusForbidden, fmt.Sprintf("cannot remove %s accounts", user.GetAccountType()))
+		return
+	}
+	name := user.GetName()
+	err := a.db.RemoveUser(user)
+	if err != nil {
+		writeApiError(w, r, http.StatusInternalServerError, fmt.Sprintf("failed to remove user: %v", err))
+		return
+	}
+	slog.Info("Removed user", "User.Name", name, "Key.Id", req.key.GetId())
+	writeApiData(w, r, nil)
+}
+
+// Modify a users name or account type, changing the account type changes what the users keys can do but their data isn't removed.
+//
+// Method: POST
+//
+// URL: /api/2/modifyuser
+//
+// Requires: global-modify, only account types below the keys can be modified or set unless the key has 'admin'
+//
+// Post Data:
+//   - Id: User id
+//   - Name: New name, if not set no change occurs
+//   - AccountType: New account type ('guest', 'user', 'moderator' or 'administrator'), if not set no change occurs
+//
+// Returns: The updated user
+func (a *DbApi2) ModifyUser(w http.ResponseWriter, r *http.Request, req *apiRequest) {
+	user := a.userFromId(w, r, "Id", r.PostFormValue("Id"))
+	if user == nil {
+		return
+	}
+	if !a.canManageUser(req, user.GetAccountType()) {
+		writeApiError(w, r, http.StatusForbidden, fmt.Sprintf("cannot modify %s accounts", user.GetAccountType()))
+		return
+	}
+	if name := r.PostFormValue("Name"); name != "" {
+		err := user.SetName(name)
+		if err != nil {
+			writeApiError(w, r, http.StatusBadRequest, fmt.Sprintf("invalid 'Name': %v", err))
+			return
+		}
+	}
+	if typeStr := r.PostFormValue("AccountType"); typeStr != "" {
+		accountType, err := filedb.ParseAccountType(typeStr)
+		if err != nil {
+			writeApiError(w, r, http.StatusBadRequest, fmt.Sprintf("invalid 'AccountType': %v", err))
+			return
+		}
+		if !a.canManageUser(req, accountType) {
+			writeApiError(w, r, http.StatusForbidden, fmt.Sprintf("cannot make %s accounts", accountType))
+			return
+		}
+		user.SetAccountType(accountType)
+	}
+	err := a.db.UpdateUser(user)
+	if err != nil {
+		writeApiError(w, r, http.StatusBadRequest, fmt.Sprintf("failed to update user: %v", err))
+		return
+	}
+	slog.Info("Modified user", "User.Id", user.GetId(), "User.Name", user.GetName(), "User.AccountType", user.GetAccountType(), "Key.Id", req.key.GetId())
+	writeApiData(w, r, userToApiUser(user))
+}
+
+// Get info about files with any users stars & last viewed times
+//
+// Method: GET
+//
+// URL: /api/2/readuser
+//
+// Requires: global-read
+//
+// Query Params:
+//   - user: User id
+//   - id: File ids, can have multiple
+//
+// Returns: File array
+func (a *DbApi2) ReadUser(w http.ResponseWriter, r *http.Request, req *apiRequest) {
+	qr := r.URL.Query()
+	user := a.userFromId(w, r, "user", qr.Get("user"))
+	if user == nil {
+		return
+	}
+	files := make([]*filedb.File, 0)
+	for _, i := range qr["id"] {
+		f := a.fileFromId(w, r, "id", i)
+		if f == nil {
+			return
+		}
+		files = append(files, f)
+	}
+	err := a.db.LoadUserData(user, files...)
+	if err != nil {
+		writeApiError(w, r, http.StatusInternalServerError, fmt.Sprintf("Failed to get user data: %v", err))
+		return
+	}
+	writeApiData(w, r, filesToApiFile(files))
+}
+
+// A users stars & last viewed time for a file
+type apiUserFileData struct {
+	UserId     int
+	UserName   string
+	Stars      uint8
+	LastViewed time.Time
+}
+
+type apiGlobalInfo struct {
+	Id           int
+	Users        []*apiUserFileData // Every user that has set stars or viewed the file
+	LastViewed   time.Time          // Latest last viewed time of any user
+	AverageStars float64            // Average stars of every user in Users
+	Viewed       int                // Number of users who viewed the file
+	Starred      int                // Number of users who starred the file
+}
+
+// Get every users stars & last viewed times for files
+//
+// Method: GET
+//
+// URL: /api/2/globalinfo
+//
+// Requires: global-read
+//
+// Query Params:
+//   - id: File ids, can have multiple
+//   - ignore_unset: boolean, users who haven't starred the file aren't counted in AverageStars. Default: false
+//
+// Returns: Array of each files user data
+func (a *DbApi2) GetGlobalInfo(w http.ResponseWriter, r *http.Request, req *apiRequest) {
+	qr := r.URL.Query()
+	ignoreUnset := qr.Get("ignore_unset") == "true"
+	info := make([]*apiGlobalInfo, 0)
+	for _, i := range qr["id"] {
+		f := a.fileFromId(w, r, "id", i)
+		if f == nil {
+			return
+		}
+		data, err := a.db.GetFileUserData(f)
+		if err != nil {
+			writeApiError(w, r, http.StatusInternalServerError, fmt.Sprintf("Failed to get user data: %v", err))
+			return
+		}
+		stats, err := a.db.GetFileGlobalStats(f, ignoreUnset)
+		if err != nil {
+			writeApiError(w, r, http.StatusInternalServerError, fmt.Sprintf("Failed to get user data: %v", err))
+			return
+		}
+		fileInfo := &apiGlobalInfo{
+			Id:           f.GetId(),
+			Users:        make([]*apiUserFileData, len(data)),
+			LastViewed:   stats.LastViewed,
+			AverageStars: stats.AverageStars,
+			Viewed:       stats.Viewed,
+			Starred:      stats.Starred,
+		}
+		for j, v := range data {
+			fileInfo.Users[j] = &apiUserFileData{
+				UserId:     v.UserId,
+				UserName:   v.UserName,
+				Stars:      v.Stars,
+				LastViewed: v.LastViewed,
+			}
+		}
+		info = append(info, fileInfo)
+	}
+	writeApiData(w, r, info)
+}
+
+// Create the API, every endpoint is under /api/2/ and is authenticated with a API key in the ApiKeyHeader header.
+//
+// limiter is only used to report the rate limit, the mux must be wrapped with RateLimiter.HttpHandler to apply it. It may be nil.
+func NewFileDbApi(db *filedb.FileDb, mux *http.ServeMux, limiter *RateLimiter) *DbApi2 {
+	api := &DbApi2{
+		db:      db,
+		limiter: limiter,
+	}
+	get := []string{http.MethodGet}
+	post := []string{http.MethodPost}
+	del := []string{http.MethodDelete}
+	api.handle(mux, "/api/2/content", []string{http.MethodGet, http.MethodHead}, filedb.PermissionRead, api.ServeFile)
+	api.handle(mux, "/api/2/info", get, filedb.PermissionRead, api.GetFileInfo)
+	api.handle(mux, "/api/2/setstars", post, filedb.PermissionUserWrite, api.SetStars)
+	api.handle(mux, "/api/2/update", post, filedb.PermissionGlobalModify, api.UpdateFile)
+	api.handle(mux, "/api/2/search", get, filedb.PermissionRead, api.SearchFile)
+	api.handle(mux, "/api/2/savedsearches", get, filedb.PermissionRead, api.GetSavedSearches)
+	api.handle(mux, "/api/2/savedsearch", get, filedb.PermissionRead, api.RunSavedSearch)
+	api.handle(mux, "/api/2/addsavedsearch", post, filedb.PermissionUserWrite, api.AddSavedSearch)
+	api.handle(mux, "/api/2/removesavedsearch", del, filedb.PermissionUserWrite, api.RemoveSavedSearch)
+	api.handle(mux, "/api/2/collections", get, filedb.PermissionRead, api.GetCollections)
+	api.handle(mux, "/api/2/collection", get, filedb.PermissionRead, api.GetCollection)
+	api.handle(mux, "/api/2/addcollection", post, filedb.PermissionGlobalWrite, api.AddCollection)
+	api.handle(mux, "/api/2/updatecollection", post, filedb.PermissionGlobalModify, api.UpdateCollection)
+	api.handle(mux, "/api/2/removecollection", del, filedb.PermissionGlobalWrite, api.RemoveCollection)
+	api.handle(mux, "/api/2/addcollectionitems", post, filedb.PermissionGlobalModify, api.AddCollectionItems)
+	api.handle(mux, "/api/2/removecollectionitems", post, filedb.PermissionGlobalModify, api.RemoveCollectionItems)
+	api.handle(mux, "/api/2/movecollectionitem", post, filedb.PermissionGlobalModify, api.MoveCollectionItem)
+	api.handle(mux, "/api/2/deletetag", del, filedb.PermissionGlobalWrite, api.DeleteTag)
+	api.handle(mux, "/api/2/deletefile", del, filedb.PermissionGlobalWrite, api.DeleteFile)
+	api.handle(mux, "/api/2/gettags", get, filedb.PermissionRead, api.GetAllTags)
+	api.handle(mux, "/api/2/addtag", post, filedb.PermissionGlobalModify, api.AddTag)
+	api.handle(mux, "/api/2/tagimplications", get, filedb.PermissionRead, api.GetTagImplications)
+	api.handle(mux, "/api/2/addtagimplication", post, filedb.PermissionGlobalModify, api.AddTagImplication)
+	api.handle(mux, "/api/2/removetagimplication", del, filedb.PermissionGlobalModify, api.RemoveTagImplication)
+	api.handle(mux, "/api/2/renametag", post, filedb.PermissionGlobalModify, api.RenameTag)
+	api.handle(mux, "/api/2/mergetags", post, filedb.PermissionGlobalModify, api.MergeTags)
+	api.handle(mux, "/api/2/tagaliases", get, filedb.PermissionRead, api.GetTagAliases)
+	api.handle(mux, "/api/2/addtagalias", post, filedb.PermissionGlobalModify, api.AddTagAlias)
+	api.handle(mux, "/api/2/removetagalias", del, filedb.PermissionGlobalModify, api.RemoveTagAlias)
+	api.handle(mux, "/api/2/duplicates", get, filedb.PermissionRead, api.GetDuplicates)
+	api.handle(mux, "/api/2/setcanonical", post, filedb.PermissionAdmin, api.SetCanonicalLocation)
+	api.handle(mux, "/api/2/removelocation", del, filedb.PermissionAdmin, api.RemoveFileLocation)
+	api.handle(mux, "/api/2/viewed", post, filedb.PermissionUserWrite, api.UpdateFileDate)
+	api.handle(mux, "/api/2/status", get, filedb.PermissionNone, api.GetStatus)
+	api.handle(mux, "/api/2/removeuser", del, filedb.PermissionGlobalModify, api.RemoveUser)
+	api.handle(mux, "/api/2/modifyuser", post, filedb.PermissionGlobalModify, api.ModifyUser)
+	api.handle(mux, "/api/2/readuser", get, filedb.PermissionGlobalRead, api.ReadUser)
+	api.handle(mux, "/api/2/globalinfo", get, filedb.PermissionGlobalRead, api.GetGlobalInfo)
+	return api
+}
@@ -0,0 +1,195 @@
+package web2
+
+import (
+	"encoding/json"
+	"mediamanager/filedb"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Every permission except admin
+const allPermissions = filedb.PermissionRead | filedb.PermissionUserWrite | filedb.PermissionGlobalRead |
+	filedb.PermissionGlobalModify | filedb.PermissionGlobalWrite | filedb.PermissionNoRateLimit
+
+func getTestDb(t *testing.T) *filedb.FileDb {
+	db, err := filedb.NewFileDb(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// Create the API, if limiter is set the API is wrapped with it
+func getTestApi(t *testing.T, limiter *RateLimiter) (*filedb.FileDb, http.Handler) {
+	db := getTestDb(t)
+	if limiter != nil {
+		limiter.db = db
+	}
+	mux := http.NewServeMux()
+	NewFileDbApi(db, mux, limiter)
+	if limiter != nil {
+		return db, limiter.HttpHandler(mux)
+	}
+	return db, mux
+}
+
+// Add a user with a key, returns the key
+func addTestKey(t *testing.T, db *filedb.FileDb, name string, accountType filedb.AccountType, perms filedb.Permission) (string, *filedb.User) {
+	u, err := db.AddUser(name, accountType)
+	if err != nil {
+		t.Fatalf("Failed to add user: %v", err)
+	}
+	key, _, err := db.AddApiKey(u, "key", perms)
+	if err != nil {
+		t.Fatalf("Failed to add API key: %v", err)
+	}
+	return key, u
+}
+
+type testResponse struct {
+	Code int
+	Data json.RawMessage
+}
+
+// Make a request, form is sent as the body if set. Returns the recorder & the decoded response
+func doRequest(t *testing.T, h http.Handler, method, target, key string, form url.Values) (*httptest.ResponseRecorder, *testResponse) {
+	var r *http.Request
+	if form != nil {
+		r = httptest.NewRequest(method, target, strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	} else {
+		r = httptest.NewRequest(method, target, nil)
+	}
+	if key != "" {
+		r.Header.Set(ApiKeyHeader, key)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	res := &testResponse{}
+	if err := json.Unmarshal(w.Body.Bytes(), res); err != nil {
+		t.Fatalf("%s %s: invalid response '%s': %v", method, target, w.Body.String(), err)
+	}
+	assert.Equalf(t, w.Code, res.Code, "%s %s: response code doesn't match the status", method, target)
+	return w, res
+}
+
+func decodeError(t *testing.T, res *testResponse) apiError {
+	e := apiError{}
+	if err := json.Unmarshal(res.Data, &e); err != nil {
+		t.Fatalf("Invalid error '%s': %v", res.Data, err)
+	}
+	return e
+}
+
+func TestAuthentication(t *testing.T) {
+	db, h := getTestApi(t, nil)
+	key, _ := addTestKey(t, db, "guest", filedb.AccountTypeGuest, filedb.PermissionRead)
+	// Status doesn't need a key
+	w, _ := doRequest(t, h, "GET", "/api/2/status", "", nil)
+	assert.Equalf(t, http.StatusOK, w.Code, "Status required a key")
+	w, res := doRequest(t, h, "GET", "/api/2/gettags", "", nil)
+	assert.Equalf(t, http.StatusUnauthorized, w.Code, "Request without a key was allowed")
+	assert.Containsf(t, decodeError(t, res).Message, ApiKeyHeader, "Error doesn't say where the key goes")
+	w, res = doRequest(t, h, "GET", "/api/2/gettags", "not a key", nil)
+	assert.Equalf(t, http.StatusUnauthorized, w.Code, "Invalid key was allowed")
+	assert.Equalf(t, "invalid API key", decodeError(t, res).Message, "Wrong error")
+	w, _ = doRequest(t, h, "GET", "/api/2/gettags", key, nil)
+	assert.Equalf(t, http.StatusOK, w.Code, "Valid key was rejected")
+	w, _ = doRequest(t, h, "POST", "/api/2/gettags", key, url.Values{})
+	assert.Equalf(t, http.StatusMethodNotAllowed, w.Code, "Wrong method was allowed")
+	assert.Equalf(t, "GET", w.Header().Get("Allow"), "Wrong 'Allow' header")
+}
+
+func TestPermissions(t *testing.T) {
+	db, h := getTestApi(t, nil)
+	endpoints := []struct {
+		method string
+		path   string
+		perm   filedb.Permission
+	}{
+		{"GET", "/api/2/content", filedb.PermissionRead},
+		{"GET", "/api/2/info", filedb.PermissionRead},
+		{"POST", "/api/2/setstars", filedb.PermissionUserWrite},
+		{"POST", "/api/2/update", filedb.PermissionGlobalModify},
+		{"GET", "/api/2/search", filedb.PermissionRead},
+		{"GET", "/api/2/savedsearches", filedb.PermissionRead},
+		{"POST", "/api/2/addsavedsearch", filedb.PermissionUserWrite},
+		{"DELETE", "/api/2/removesavedsearch", filedb.PermissionUserWrite},
+		{"GET", "/api/2/collections", filedb.PermissionRead},
+		{"POST", "/api/2/addcollection", filedb.PermissionGlobalWrite},
+		{"POST", "/api/2/updatecollection", filedb.PermissionGlobalModify},
+		{"DELETE", "/api/2/removecollection", filedb.PermissionGlobalWrite},
+		{"DELETE", "/api/2/deletetag", filedb.PermissionGlobalWrite},
+		{"DELETE", "/api/2/deletefile", filedb.PermissionGlobalWrite},
+		{"POST", "/api/2/addtag", filedb.PermissionGlobalModify},
+		{"POST", "/api/2/renametag", filedb.PermissionGlobalModify},
+		{"POST", "/api/2/setcanonical", filedb.PermissionAdmin},
+		{"DELETE", "/api/2/removelocation", filedb.PermissionAdmin},
+		{"POST", "/api/2/viewed", filedb.PermissionUserWrite},
+		{"DELETE", "/api/2/removeuser", filedb.PermissionGlobalModify},
+		{"POST", "/api/2/modifyuser", filedb.PermissionGlobalModify},
+		{"GET", "/api/2/readuser", filedb.PermissionGlobalRead},
+		{"GET", "/api/2/globalinfo", filedb.PermissionGlobalRead},
+	}
+	for i, e := range endpoints {
+		// The key has every other permission
+		key, _ := addTestKey(t, db, strings.Repeat("a", i+1), filedb.AccountTypeAdministrator, allPermissions&^e.perm)
+		var form url.Values
+		if e.method == "POST" {
+			form = url.Values{}
+		}
+		w, res := doRequest(t, h, e.method, e.path, key, form)
+		if assert.Equalf(t, http.StatusForbidden, w.Code, "%s was allowed without '%s'", e.path, e.perm) {
+			assert.Equalf(t, e.perm.String(), decodeError(t, res).Permission, "%s: wrong permission in error", e.path)
+		}
+	}
+	// Keys are limited by the account type of their user
+	key, u := addTestKey(t, db, "user", filedb.AccountTypeUser, filedb.PermissionRead|filedb.PermissionUserWrite)
+	if err := u.SetAccountType(filedb.AccountTypeGuest); err != nil {
+		t.Fatalf("Failed to set account type: %v", err)
+	}
+	if err := db.UpdateUser(u); err != nil {
+		t.Fatalf("Failed to update user: %v", err)
+	}
+	w, res := doRequest(t, h, "POST", "/api/2/setstars", key, url.Values{})
+	assert.Equalf(t, http.StatusForbidden, w.Code, "Key kept permissions its account type doesn't allow")
+	assert.Equalf(t, "user-write", decodeError(t, res).Permission, "Wrong permission in error")
+}
+
+func TestCanManageUser(t *testing.T) {
+	db, h := getTestApi(t, nil)
+	modKey, _ := addTestKey(t, db, "mod", filedb.AccountTypeModerator, filedb.PermissionGlobalModify)
+	adminKey, _ := addTestKey(t, db, "admin", filedb.AccountTypeAdministrator, filedb.PermissionAdmin)
+	_, user := addTestKey(t, db, "user", filedb.AccountTypeUser, filedb.PermissionRead)
+	_, otherMod := addTestKey(t, db, "othermod", filedb.AccountTypeModerator, filedb.PermissionRead)
+	userId := url.Values{"Id": {strconv.Itoa(user.GetId())}}
+	otherModId := url.Values{"Id": {strconv.Itoa(otherMod.GetId())}}
+
+	w, _ := doRequest(t, h, "POST", "/api/2/modifyuser", modKey, url.Values{"Id": userId["Id"], "Name": {"renamed"}})
+	assert.Equalf(t, http.StatusOK, w.Code, "Moderator couldn't modify a user")
+	w, res := doRequest(t, h, "POST", "/api/2/modifyuser", modKey, url.Values{"Id": userId["Id"], "AccountType": {"moderator"}})
+	assert.Equalf(t, http.StatusForbidden, w.Code, "Moderator could make a moderator")
+	assert.Equalf(t, "cannot make moderator accounts", decodeError(t, res).Message, "Wrong error")
+	w, _ = doRequest(t, h, "POST", "/api/2/modifyuser", modKey, url.Values{"Id": otherModId["Id"], "Name": {"renamed2"}})
+	assert.Equalf(t, http.StatusForbidden, w.Code, "Moderator could modify another moderator")
+	w, _ = doRequest(t, h, "DELETE", "/api/2/removeuser?id="+otherModId.Get("Id"), modKey, nil)
+	assert.Equalf(t, http.StatusForbidden, w.Code, "Moderator could remove another moderator")
+	_, err := db.GetUserById(otherMod.GetId())
+	assert.NoErrorf(t, err, "User was removed after a 403")
+
+	// 'admin' can manage any account
+	w, _ = doRequest(t, h, "POST", "/api/2/modifyuser", adminKey, url.Values{"Id": userId["Id"], "AccountType": {"administrator"}})
+	assert.Equalf(t, http.StatusOK, w.Code, "Admin couldn't make a administrator")
+	w, _ = doRequest(t, h, "DELETE", "/api/2/removeuser?id="+otherModId.Get("Id"), adminKey, nil)
+	assert.Equalf(t, http.StatusOK, w.Code, "Admin couldn't remove a moderator")
+	_, err = db.GetUserById(otherMod.GetId())
+	assert.Errorf(t, err, "User wasn't removed")
+}
@@ -0,0 +1,183 @@
+package web2
+
+import (
+	"fmt"
+	"log/slog"
+	"mediamanager/filedb"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Token bucket of a key or address
+type bucket struct {
+	tokens float64
+	last   time.Time // Last time tokens were added
+}
+
+// Limits API requests per key, requests without a valid key are limited per address.
+//
+// Each key gets a token bucket, if it runs out the key is locked out for a while.
+type RateLimiter struct {
+	db       *filedb.FileDb
+	requests int           // Size of the bucket
+	period   time.Duration // Time it takes the bucket to refill
+	lockout  time.Duration // How long a key is locked out after running out of requests
+	lock     sync.Mutex
+	buckets  map[string]*bucket
+	lockouts map[string]time.Time // When each locked out key can be used again
+	pruned   time.Time            // Last time old buckets & lockouts were removed
+}
+
+// Rate limit info for a key
+type RateLimitStatus struct {
+	Limited     bool       // False if the key has the 'no-rate-limit' permission
+	Limit       int        // Requests allowed each period
+	Remaining   int        // Requests remaining before the key is locked out
+	LockedUntil *time.Time // When the key can be used again, nil if the key isn't locked out
+}
+
+// Get who made a request, and if they are exempt from the rate limit. req & err are the result of authenticating r.
+func identify(r *http.Request, req *apiRequest, err error) (id string, exempt bool) {
+	if err == nil && req.key != nil {
+		if req.perms.Has(filedb.PermissionNoRateLimit) {
+			return "", true
+		}
+		return fmt.Sprintf("key:%d", req.key.GetId()), false
+	}
+	// Invalid keys are limited by address, so they can't be used to get around the limit.
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "addr:" + host, false
+}
+
+// Add tokens to b for the time since it was last updated
+func (l *RateLimiter) refill(b *bucket, now time.Time) {
+	b.tokens += now.Sub(b.last).Seconds() * float64(l.requests) / l.period.Seconds()
+	if b.tokens > float64(l.requests) {
+		b.tokens = float64(l.requests)
+	}
+	b.last = now
+}
+
+// Remove full buckets & expired lockouts, l.lock must be held.
+func (l *RateLimiter) prune(now time.Time) {
+	if now.Sub(l.pruned) < l.period {
+		return
+	}
+	l.pruned = now
+	for k, v := range l.buckets {
+		if now.Sub(v.last) >= l.period {
+			// Would be full anyway
+			delete(l.buckets, k)
+		}
+	}
+	for k, v := range l.lockouts {
+		if now.After(v) {
+			delete(l.lockouts, k)
+		}
+	}
+}
+
+// Take a token for id, if there are no tokens remaining id is locked out.
+//
+// Returns when id can make requests again if the request isn't allowed.
+func (l *RateLimiter) take(id string) (allowed bool, retry time.Time) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	now := time.Now()
+	l.prune(now)
+	if until, found := l.lockouts[id]; found {
+		if now.Before(until) {
+			return false, until
+		}
+		delete(l.lockouts, id)
+	}
+	b, found := l.buckets[id]
+	if !found {
+		b = &bucket{
+			tokens: float64(l.requests),
+			last:   now,
+		}
+		l.buckets[id] = b
+	}
+	l.refill(b, now)
+	if b.tokens < 1 {
+		until := now.Add(l.lockout)
+		slog.Warn("Rate limit exceeded, locking out", "Id", id, "Until", until)
+		l.lockouts[id] = until
+		// The bucket is full once the lockout is over
+		delete(l.buckets, id)
+		return false, until
+	}
+	b.tokens--
+	return true, time.Time{}
+}
+
+// Get the rate limit info of whoever made r, this doesn't use a request.
+func (l *RateLimiter) Status(r *http.Request) *RateLimitStatus {
+	req, err := authenticate(l.db, r)
+	id, exempt := identify(r, req, err)
+	if exempt {
+		return &RateLimitStatus{
+			Limited: false,
+		}
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	now := time.Now()
+	status := &RateLimitStatus{
+		Limited:   true,
+		Limit:     l.requests,
+		Remaining: l.requests,
+	}
+	if until, found := l.lockouts[id]; found && now.Before(until) {
+		status.Remaining = 0
+		status.LockedUntil = &until
+		return status
+	}
+	if b, found := l.buckets[id]; found {
+		l.refill(b, now)
+		status.Remaining = int(b.tokens)
+	}
+	return status
+}
+
+// Wrap a handler, requests over the limit get a 429 response with a 'Retry-After' header.
+//
+// The key of the request is passed on to next in the request context, so the API doesn't look it up again.
+func (l *RateLimiter) HttpHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r, req, err := withAuthentication(l.db, r)
+		id, exempt := identify(r, req, err)
+		if exempt {
+			next.ServeHTTP(w, r)
+			return
+		}
+		allowed, retry := l.take(id)
+		if !allowed {
+			seconds := int(time.Until(retry).Seconds()) + 1
+			w.Header().Set("Retry-After", strconv.Itoa(seconds))
+			writeApiError(w, r, http.StatusTooManyRequests, fmt.Sprintf("Rate limit exceeded, try again in %d seconds", seconds))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Create a rate limiter allowing requests every period, going over the limit locks the key out for lockout.
+func NewRateLimiter(db *filedb.FileDb, requests int, period time.Duration, lockout time.Duration) *RateLimiter {
+	return &RateLimiter{
+		db:       db,
+		requests: requests,
+		period:   period,
+		lockout:  lockout,
+		buckets:  make(map[string]*bucket),
+		lockouts: make(map[string]time.Time),
+		pruned:   time.Now(),
+	}
+}
@@ -0,0 +1,96 @@
+package web2
+
+import (
+	"encoding/json"
+	"mediamanager/filedb"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimit(t *testing.T) {
+	db, h := getTestApi(t, NewRateLimiter(nil, 2, time.Hour, time.Minute))
+	key, _ := addTestKey(t, db, "user", filedb.AccountTypeUser, filedb.PermissionRead)
+	otherKey, _ := addTestKey(t, db, "other", filedb.AccountTypeUser, filedb.PermissionRead)
+	exemptKey, _ := addTestKey(t, db, "mod", filedb.AccountTypeModerator, filedb.PermissionRead|filedb.PermissionNoRateLimit)
+	for range 2 {
+		w, _ := doRequest(t, h, "GET", "/api/2/gettags", key, nil)
+		assert.Equalf(t, http.StatusOK, w.Code, "Request under the limit was rejected")
+	}
+	w, res := doRequest(t, h, "GET", "/api/2/gettags", key, nil)
+	if assert.Equalf(t, http.StatusTooManyRequests, w.Code, "Request over the limit was allowed") {
+		retry, err := strconv.Atoi(w.Header().Get("Retry-After"))
+		assert.NoErrorf(t, err, "Invalid 'Retry-After' header")
+		assert.InDeltaf(t, 60, retry, 1, "Wrong 'Retry-After' header")
+		assert.Containsf(t, decodeError(t, res).Message, "Rate limit exceeded", "Wrong error")
+	}
+	// Locked out keys stay locked out, even for endpoints that don't need a key
+	w, _ = doRequest(t, h, "GET", "/api/2/status", key, nil)
+	assert.Equalf(t, http.StatusTooManyRequests, w.Code, "Locked out key was allowed")
+
+	// Each key has its own limit, requests without a valid key are limited by address
+	w, _ = doRequest(t, h, "GET", "/api/2/gettags", otherKey, nil)
+	assert.Equalf(t, http.StatusOK, w.Code, "Other key was limited")
+	w, _ = doRequest(t, h, "GET", "/api/2/status", "", nil)
+	assert.Equalf(t, http.StatusOK, w.Code, "Address was limited by a key")
+	doRequest(t, h, "GET", "/api/2/status", "not a key", nil)
+	w, _ = doRequest(t, h, "GET", "/api/2/status", "another invalid key", nil)
+	assert.Equalf(t, http.StatusTooManyRequests, w.Code, "Invalid keys weren't limited by address")
+
+	for range 5 {
+		w, _ = doRequest(t, h, "GET", "/api/2/gettags", exemptKey, nil)
+		assert.Equalf(t, http.StatusOK, w.Code, "Key with 'no-rate-limit' was limited")
+	}
+}
+
+func TestRateLimitStatus(t *testing.T) {
+	db, h := getTestApi(t, NewRateLimiter(nil, 3, time.Hour, time.Minute))
+	key, _ := addTestKey(t, db, "user", filedb.AccountTypeUser, filedb.PermissionRead)
+	exemptKey, _ := addTestKey(t, db, "mod", filedb.AccountTypeModerator, filedb.PermissionRead|filedb.PermissionNoRateLimit)
+	getStatus := func(key string) *RateLimitStatus {
+		_, res := doRequest(t, h, "GET", "/api/2/status", key, nil)
+		status := &statusInfo{}
+		if err := json.Unmarshal(res.Data, status); err != nil {
+			t.Fatalf("Invalid status '%s': %v", res.Data, err)
+		}
+		return status.RateLimit
+	}
+	doRequest(t, h, "GET", "/api/2/gettags", key, nil)
+	// The status request itself uses a request
+	status := getStatus(key)
+	if assert.NotNilf(t, status, "Rate limit wasn't reported") {
+		assert.Truef(t, status.Limited, "Key wasn't limited")
+		assert.Equalf(t, 3, status.Limit, "Wrong limit")
+		assert.Equalf(t, 1, status.Remaining, "Wrong remaining requests")
+		assert.Nilf(t, status.LockedUntil, "Key was locked out")
+	}
+	status = getStatus(exemptKey)
+	if assert.NotNilf(t, status, "Rate limit wasn't reported") {
+		assert.Falsef(t, status.Limited, "Key with 'no-rate-limit' was limited")
+	}
+}
+
+func TestRateLimitKeyLookup(t *testing.T) {
+	db := getTestDb(t)
+	key, _ := addTestKey(t, db, "user", filedb.AccountTypeUser, filedb.PermissionRead)
+	l := NewRateLimiter(db, 10, time.Hour, time.Minute)
+	// The key found by the limiter is passed on, so it isn't looked up again
+	var passed *authResult
+	var req *apiRequest
+	h := l.HttpHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		passed, _ = r.Context().Value(authContextKey{}).(*authResult)
+		req, _ = authenticate(db, r)
+	}))
+	r := httptest.NewRequest("GET", "/api/2/status", nil)
+	r.Header.Set(ApiKeyHeader, key)
+	h.ServeHTTP(httptest.NewRecorder(), r)
+	if assert.NotNilf(t, passed, "Key wasn't passed on") {
+		assert.NoError(t, passed.err)
+		assert.Samef(t, passed.req, req, "Key was looked up again")
+		assert.NotNilf(t, req.key, "Key wasn't found")
+	}
+}